@@ -0,0 +1,241 @@
+// Package accounts follows the MMS switch of the phone settings, the
+// MmsEnabled property AccountsService keeps for every user.
+//
+// nuntium runs as a system user, so the switch is the one of the user of the
+// active session on seat0 as logind reports it, which changes as users are
+// switched on multi-user devices. Without logind the user running nuntium is
+// used. Until the switch is known, or with a nil Monitor, MMS is enabled.
+package accounts
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/logging"
+)
+
+var logger = logging.New("accounts")
+
+const (
+	logindName          = "org.freedesktop.login1"
+	seatPath            = dbus.ObjectPath("/org/freedesktop/login1/seat/seat0")
+	seatInterface       = "org.freedesktop.login1.Seat"
+	sessionInterface    = "org.freedesktop.login1.Session"
+	accountsName        = "org.freedesktop.Accounts"
+	accountsPath        = dbus.ObjectPath("/org/freedesktop/Accounts")
+	accountsInterface   = "org.freedesktop.Accounts"
+	phoneInterface      = "com.ubuntu.touch.AccountsService.Phone"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+)
+
+// Monitor keeps track of the MmsEnabled property of the active user.
+type Monitor struct {
+	conn *dbus.Connection
+	lock sync.Mutex
+	// userPath is the AccountsService object of the active user, empty
+	// until it is known.
+	userPath dbus.ObjectPath
+	enabled  bool
+	known    bool
+	changed  chan struct{}
+}
+
+// NewMonitor creates a monitor using logind and AccountsService on the
+// system bus conn.
+func NewMonitor(conn *dbus.Connection) *Monitor {
+	return &Monitor{conn: conn, changed: make(chan struct{})}
+}
+
+// Init looks up the active user and its switch and follows their changes.
+func (m *Monitor) Init() error {
+	accountsWatch, err := m.conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Sender:    accountsName,
+		Interface: propertiesInterface,
+		Member:    "PropertiesChanged",
+	})
+	if err != nil {
+		return err
+	}
+	go m.watchAccounts(accountsWatch)
+	seatWatch, err := m.conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Sender:    logindName,
+		Interface: propertiesInterface,
+		Member:    "PropertiesChanged",
+		Path:      seatPath,
+	})
+	if err != nil {
+		logger.Error("Cannot follow the active session, ignoring user switches: ", err)
+	} else {
+		go m.watchSeat(seatWatch)
+	}
+	return m.refresh()
+}
+
+// refresh looks up the active user and reads its switch.
+func (m *Monitor) refresh() error {
+	uid, err := m.activeUid()
+	if err != nil {
+		logger.Error("Cannot find the user of the active session, using the current one: ", err)
+		usr, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("cannot get the current user: %w", err)
+		}
+		if uid, err = strconv.ParseUint(usr.Uid, 10, 32); err != nil {
+			return fmt.Errorf("cannot parse the uid of the current user: %w", err)
+		}
+	}
+	reply, err := m.conn.Object(accountsName, accountsPath).Call(accountsInterface, "FindUserById", int64(uid))
+	if err != nil {
+		return fmt.Errorf("cannot find the account of user %d: %w", uid, err)
+	}
+	var userPath dbus.ObjectPath
+	if err := reply.Args(&userPath); err != nil {
+		return fmt.Errorf("cannot parse the account of user %d: %w", uid, err)
+	}
+	m.setUser(userPath)
+
+	reply, err = m.conn.Object(accountsName, userPath).Call(propertiesInterface, "Get", phoneInterface, "MmsEnabled")
+	if err != nil {
+		return fmt.Errorf("cannot get the MMS switch of user %d: %w", uid, err)
+	}
+	var enabled dbus.Variant
+	if err := reply.Args(&enabled); err != nil {
+		return fmt.Errorf("cannot parse the MMS switch of user %d: %w", uid, err)
+	}
+	m.update(userPath, map[string]dbus.Variant{"MmsEnabled": enabled})
+	logger.Infof("MMS enabled for user %d: %v", uid, m.Enabled())
+	return nil
+}
+
+// activeUid returns the uid of the user of the active session on seat0.
+func (m *Monitor) activeUid() (uint64, error) {
+	reply, err := m.conn.Object(logindName, seatPath).Call(propertiesInterface, "Get", seatInterface, "ActiveSession")
+	if err != nil {
+		return 0, err
+	}
+	var session dbus.Variant
+	if err := reply.Args(&session); err != nil {
+		return 0, err
+	}
+	sessionPath, ok := structPath(session)
+	if !ok || sessionPath == "/" {
+		return 0, fmt.Errorf("no active session on %s", seatPath)
+	}
+	if reply, err = m.conn.Object(logindName, sessionPath).Call(propertiesInterface, "Get", sessionInterface, "User"); err != nil {
+		return 0, err
+	}
+	var sessionUser dbus.Variant
+	if err := reply.Args(&sessionUser); err != nil {
+		return 0, err
+	}
+	fields, _ := sessionUser.Value().([]interface{})
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected user %v of session %s", sessionUser.Value(), sessionPath)
+	}
+	uid, ok := fields[0].(uint32)
+	if !ok {
+		return 0, fmt.Errorf("unexpected user %v of session %s", sessionUser.Value(), sessionPath)
+	}
+	return uint64(uid), nil
+}
+
+// structPath returns the object path of a (so) or (uo) struct as logind uses
+// them to refer to sessions and users.
+func structPath(v dbus.Variant) (dbus.ObjectPath, bool) {
+	fields, _ := v.Value().([]interface{})
+	if len(fields) != 2 {
+		return "", false
+	}
+	path, ok := fields[1].(dbus.ObjectPath)
+	return path, ok
+}
+
+func (m *Monitor) watchSeat(w *dbus.SignalWatch) {
+	for msg := range w.C {
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &props, &invalidated); err != nil {
+			logger.Error("Cannot parse logind PropertiesChanged: ", err)
+			continue
+		}
+		if _, ok := props["ActiveSession"]; iface != seatInterface || !ok {
+			continue
+		}
+		if err := m.refresh(); err != nil {
+			logger.Error("Cannot follow the MMS switch of the active user: ", err)
+		}
+	}
+}
+
+func (m *Monitor) watchAccounts(w *dbus.SignalWatch) {
+	for msg := range w.C {
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &props, &invalidated); err != nil {
+			logger.Error("Cannot parse AccountsService PropertiesChanged: ", err)
+			continue
+		}
+		if iface != phoneInterface {
+			continue
+		}
+		m.update(msg.Path, props)
+	}
+}
+
+// setUser makes userPath the active user, whose switch is unknown until it
+// is read.
+func (m *Monitor) setUser(userPath dbus.ObjectPath) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if userPath != m.userPath {
+		m.userPath, m.known = userPath, false
+	}
+}
+
+// update applies the changed properties of the AccountsService user at
+// userPath, which are ignored unless it is the active user.
+func (m *Monitor) update(userPath dbus.ObjectPath, props map[string]dbus.Variant) {
+	enabled, ok := props["MmsEnabled"].Value().(bool)
+	if !ok {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if userPath != m.userPath || (m.known && enabled == m.enabled) {
+		return
+	}
+	if m.known {
+		logger.Infof("MMS enabled: %v", enabled)
+	}
+	m.enabled, m.known = enabled, true
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Enabled returns false if the active user turned MMS off.
+func (m *Monitor) Enabled() bool {
+	if m == nil {
+		return true
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.enabled || !m.known
+}
+
+// Changed returns a channel which is closed on the next change of state.
+func (m *Monitor) Changed() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.changed
+}
@@ -0,0 +1,76 @@
+package accounts
+
+import (
+	"testing"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+func TestMonitorEnabled(t *testing.T) {
+	const alice, bob = dbus.ObjectPath("/org/freedesktop/Accounts/User32011"), dbus.ObjectPath("/org/freedesktop/Accounts/User32012")
+	m := NewMonitor(nil)
+	if !m.Enabled() {
+		t.Error("Enabled() = false before Init, want true")
+	}
+	m.setUser(alice)
+
+	testCases := []struct {
+		userPath    dbus.ObjectPath
+		enabled     bool
+		want        bool
+		wantChanged bool
+	}{
+		{alice, false, false, true},
+		{alice, false, false, false},
+		// Other users do not count.
+		{bob, true, false, false},
+		{alice, true, true, true},
+	}
+	for _, tc := range testCases {
+		changed := m.Changed()
+		m.update(tc.userPath, map[string]dbus.Variant{"MmsEnabled": dbus.MakeVariant(tc.enabled)})
+		if got := m.Enabled(); got != tc.want {
+			t.Errorf("Enabled() after %s set MmsEnabled %v = %v, want %v", tc.userPath, tc.enabled, got, tc.want)
+		}
+		select {
+		case <-changed:
+			if !tc.wantChanged {
+				t.Errorf("Changed() was closed after %s set MmsEnabled %v, want it open", tc.userPath, tc.enabled)
+			}
+		default:
+			if tc.wantChanged {
+				t.Errorf("Changed() was not closed after %s set MmsEnabled %v", tc.userPath, tc.enabled)
+			}
+		}
+	}
+
+	// Switching to a user whose switch is not known yet enables MMS.
+	m.update(alice, map[string]dbus.Variant{"MmsEnabled": dbus.MakeVariant(false)})
+	m.setUser(bob)
+	if !m.Enabled() {
+		t.Error("Enabled() = false for a user whose switch is unknown, want true")
+	}
+
+	var nilMonitor *Monitor
+	if !nilMonitor.Enabled() || nilMonitor.Changed() != nil {
+		t.Error("nil Monitor reports MMS disabled")
+	}
+}
+
+func TestStructPath(t *testing.T) {
+	testCases := []struct {
+		value interface{}
+		want  dbus.ObjectPath
+		ok    bool
+	}{
+		{[]interface{}{"c2", dbus.ObjectPath("/org/freedesktop/login1/session/c2")}, "/org/freedesktop/login1/session/c2", true},
+		{[]interface{}{uint32(32011), dbus.ObjectPath("/org/freedesktop/login1/user/_32011")}, "/org/freedesktop/login1/user/_32011", true},
+		{"c2", "", false},
+		{[]interface{}{"c2"}, "", false},
+	}
+	for _, tc := range testCases {
+		if got, ok := structPath(dbus.MakeVariant(tc.value)); got != tc.want || ok != tc.ok {
+			t.Errorf("structPath(%v) = %q, %v, want %q, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package address parses and normalizes the sender and recipient addresses
+// of MMS PDUs.
+//
+// PDUs carry addresses as in OMA-TS-MMS_ENC-V1_3 section 8: phone numbers and
+// IP addresses with a "/TYPE=PLMN", "/TYPE=IPv4" or "/TYPE=IPv6" suffix,
+// e-mail addresses as they are. Telepathy and the other clients get the
+// normalized address without suffix, so the same number is the same string
+// however the MMSC or the user wrote it.
+package address
+
+import (
+	"net"
+	"strings"
+)
+
+// Type is the kind of an address.
+type Type int
+
+const (
+	// Unknown addresses, e.g. alphanumeric sender names or addresses of a
+	// type nuntium does not know, are passed on as they are.
+	Unknown Type = iota
+	// PLMN addresses are phone numbers.
+	PLMN
+	// ShortCode addresses are the short numbers of operator and value
+	// added services, they are PLMN addresses to the MMSC.
+	ShortCode
+	// Email addresses are RFC 2822 addresses.
+	Email
+	// IPv4 and IPv6 addresses are those of devices.
+	IPv4
+	IPv6
+)
+
+var typeNames = map[Type]string{
+	Unknown:   "unknown",
+	PLMN:      "plmn",
+	ShortCode: "short-code",
+	Email:     "email",
+	IPv4:      "ipv4",
+	IPv6:      "ipv6",
+}
+
+// String returns the name of t, e.g. "plmn".
+func (t Type) String() string {
+	return typeNames[t]
+}
+
+// maxShortCode is the number of digits up to which a number without
+// international prefix is a short code. National numbers are longer.
+const maxShortCode = 8
+
+// phoneSeparators are the characters people write phone numbers with which
+// are no part of the number.
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "", "\u00a0", "")
+
+// Address is a parsed sender or recipient address.
+type Address struct {
+	Type Type
+	// Value is the normalized address without type suffix.
+	Value string
+	// suffix is the type suffix of an Unknown address, kept to encode it
+	// again.
+	suffix string
+}
+
+// Parse parses the encoded address, with or without type suffix. Phone
+// numbers are normalized to E.164 as far as possible without knowing the
+// country: separators are removed, a "00" international prefix becomes a
+// '+' and the "(0)" trunk prefix of international numbers is dropped.
+// E-mail addresses lose their display name and get a lower case domain, IP
+// addresses are formatted canonically.
+func Parse(encoded string) Address {
+	value := strings.TrimSpace(encoded)
+	suffix := ""
+	if i := strings.LastIndex(strings.ToUpper(value), "/TYPE="); i >= 0 {
+		value, suffix = strings.TrimSpace(value[:i]), value[i:]
+	}
+
+	switch strings.ToUpper(suffix) {
+	case "/TYPE=PLMN":
+		if number, ok := phoneNumber(value); ok {
+			return number
+		}
+		// An alphanumeric sender, which cannot be replied to.
+		return Address{Type: Unknown, Value: value}
+	case "/TYPE=IPV4":
+		if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+			return Address{Type: IPv4, Value: ip.String()}
+		}
+	case "/TYPE=IPV6":
+		if ip := net.ParseIP(value); ip != nil {
+			return Address{Type: IPv6, Value: ip.String()}
+		}
+	case "":
+		if strings.Contains(value, "@") {
+			return emailAddress(value)
+		}
+		if number, ok := phoneNumber(value); ok {
+			return number
+		}
+		return Address{Type: Unknown, Value: value}
+	}
+	return Address{Type: Unknown, Value: value, suffix: suffix}
+}
+
+// phoneNumber returns the normalized phone number value, false if value is
+// no phone number.
+func phoneNumber(value string) (Address, bool) {
+	international := strings.HasPrefix(value, "+")
+	number := phoneSeparators.Replace(strings.Replace(strings.TrimPrefix(value, "+"), "(0)", "", 1))
+	if !international && strings.HasPrefix(number, "00") {
+		international, number = true, number[2:]
+	}
+	if number == "" {
+		return Address{}, false
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return Address{}, false
+		}
+	}
+	if international {
+		return Address{Type: PLMN, Value: "+" + number}, true
+	}
+	if len(number) <= maxShortCode {
+		return Address{Type: ShortCode, Value: number}, true
+	}
+	return Address{Type: PLMN, Value: number}, true
+}
+
+// emailAddress returns the normalized e-mail address value, with or without
+// display name.
+func emailAddress(value string) Address {
+	if start, end := strings.LastIndex(value, "<"), strings.LastIndex(value, ">"); start >= 0 && end > start {
+		value = strings.TrimSpace(value[start+1 : end])
+	}
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		value = value[:at] + strings.ToLower(value[at:])
+	}
+	return Address{Type: Email, Value: value}
+}
+
+// String returns the normalized address, as passed on to clients.
+func (address Address) String() string {
+	return address.Value
+}
+
+// Encode returns the address with the type suffix it is sent with in PDUs.
+func (address Address) Encode() string {
+	switch address.Type {
+	case PLMN, ShortCode:
+		return address.Value + "/TYPE=PLMN"
+	case IPv4:
+		return address.Value + "/TYPE=IPv4"
+	case IPv6:
+		return address.Value + "/TYPE=IPv6"
+	}
+	return address.Value + address.suffix
+}
+
+// Normalize returns the normalized form of the encoded address, see Parse.
+func Normalize(encoded string) string {
+	return Parse(encoded).Value
+}
+
+// Encode returns the address for PDUs of the address the user entered, see
+// Address.Encode.
+func Encode(address string) string {
+	return Parse(address).Encode()
+}
@@ -0,0 +1,41 @@
+package address
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		encoded string
+		want    Address
+		encode  string
+	}{
+		{"+491701234567/TYPE=PLMN", Address{Type: PLMN, Value: "+491701234567"}, "+491701234567/TYPE=PLMN"},
+		{"+49 (0)170 123-45 67", Address{Type: PLMN, Value: "+491701234567"}, "+491701234567/TYPE=PLMN"},
+		{"0049.170.1234567/type=plmn", Address{Type: PLMN, Value: "+491701234567"}, "+491701234567/TYPE=PLMN"},
+		{"(555) 123-4567", Address{Type: PLMN, Value: "5551234567"}, "5551234567/TYPE=PLMN"},
+		{"22333/TYPE=PLMN", Address{Type: ShortCode, Value: "22333"}, "22333/TYPE=PLMN"},
+		{"Vodafone/TYPE=PLMN", Address{Type: Unknown, Value: "Vodafone"}, "Vodafone"},
+		{"Jane Doe <Jane.Doe@Example.COM>", Address{Type: Email, Value: "Jane.Doe@example.com"}, "Jane.Doe@example.com"},
+		{"192.168.0.1/TYPE=IPv4", Address{Type: IPv4, Value: "192.168.0.1"}, "192.168.0.1/TYPE=IPv4"},
+		{"2001:DB8:0:0::1/TYPE=IPv6", Address{Type: IPv6, Value: "2001:db8::1"}, "2001:db8::1/TYPE=IPv6"},
+		{"x/TYPE=IPv4", Address{Type: Unknown, Value: "x", suffix: "/TYPE=IPv4"}, "x/TYPE=IPv4"},
+		{"id/TYPE=x-custom", Address{Type: Unknown, Value: "id", suffix: "/TYPE=x-custom"}, "id/TYPE=x-custom"},
+		{"", Address{Type: Unknown}, ""},
+	} {
+		address := Parse(test.encoded)
+		if address != test.want {
+			t.Errorf("Parse(%q) = %#v, want %#v", test.encoded, address, test.want)
+		}
+		if encoded := address.Encode(); encoded != test.encode {
+			t.Errorf("Parse(%q).Encode() = %q, want %q", test.encoded, encoded, test.encode)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize(Encode("+49 170 1234567")); got != "+491701234567" {
+		t.Errorf("Normalize(Encode()) = %q", got)
+	}
+	if got := Normalize("1234"); got != "1234" {
+		t.Errorf("Normalize of a short code = %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+package carrier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// SystemAccessPointsPath is the location of the access point database shipped
+// with nuntium.
+var SystemAccessPointsPath = "/usr/share/nuntium/apns.json"
+
+// UserAccessPointsPath is the location of the user editable access point
+// database relative to the XDG config directory, updated by
+// nuntium-import-apns.
+var UserAccessPointsPath = filepath.Join("nuntium", "apns.json")
+
+// AccessPoint holds what is needed to provision an MMS context in ofono for
+// an operator whose SIM has none configured.
+type AccessPoint struct {
+	// MCC and MNC identify the operator the access point belongs to.
+	MCC string
+	MNC string
+	// Name is an optional human readable operator name, used as the name
+	// of the context.
+	Name string `json:",omitempty"`
+	// AccessPointName is the APN to connect to.
+	AccessPointName string
+	// Username and Password authenticate against the APN.
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	// MessageCenter is the MMSC URL.
+	MessageCenter string
+	// MessageProxy is the MMS proxy in host:port form, if any.
+	MessageProxy string `json:",omitempty"`
+}
+
+func (ap AccessPoint) String() string {
+	return fmt.Sprintf("%s/%s %s (%s)", ap.MCC, ap.MNC, ap.AccessPointName, ap.Name)
+}
+
+// AccessPoints is a database of access points.
+type AccessPoints []AccessPoint
+
+// Lookup returns the access points for mcc and mnc, the ones added last
+// first, so the entries of the user database take precedence.
+func (accessPoints AccessPoints) Lookup(mcc, mnc string) AccessPoints {
+	var found AccessPoints
+	for i := len(accessPoints) - 1; i >= 0; i-- {
+		if accessPoints[i].MCC == mcc && accessPoints[i].MNC == mnc {
+			found = append(found, accessPoints[i])
+		}
+	}
+	return found
+}
+
+// Replace returns accessPoints with all entries for the operators in entries
+// replaced by entries.
+func (accessPoints AccessPoints) Replace(entries AccessPoints) AccessPoints {
+	replaced := make(map[string]bool)
+	for _, ap := range entries {
+		replaced[ap.MCC+"/"+ap.MNC] = true
+	}
+	var result AccessPoints
+	for _, ap := range accessPoints {
+		if !replaced[ap.MCC+"/"+ap.MNC] {
+			result = append(result, ap)
+		}
+	}
+	return append(result, entries...)
+}
+
+// ReadAccessPoints reads an access point database. A missing file is not an
+// error and yields no access points.
+func ReadAccessPoints(path string) (AccessPoints, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var accessPoints AccessPoints
+	if err := json.NewDecoder(f).Decode(&accessPoints); err != nil {
+		return nil, fmt.Errorf("cannot parse access points in %s: %w", path, err)
+	}
+	for i, ap := range accessPoints {
+		if ap.MCC == "" || ap.MNC == "" {
+			return nil, fmt.Errorf("access point %d in %s has no MCC or MNC", i, path)
+		}
+		if ap.AccessPointName == "" || ap.MessageCenter == "" {
+			return nil, fmt.Errorf("access point %d in %s has no AccessPointName or MessageCenter", i, path)
+		}
+	}
+	return accessPoints, nil
+}
+
+// WriteAccessPoints writes accessPoints to path in the format
+// ReadAccessPoints expects.
+func WriteAccessPoints(path string, accessPoints AccessPoints) error {
+	b, err := json.MarshalIndent(accessPoints, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// LoadAccessPoints reads the system and user access point databases, user
+// entries come last so they take precedence on Lookup.
+func LoadAccessPoints() (AccessPoints, error) {
+	accessPoints, err := ReadAccessPoints(SystemAccessPointsPath)
+	if err != nil {
+		return nil, err
+	}
+	if userPath, err := xdg.Config.Find(UserAccessPointsPath); err == nil {
+		userAccessPoints, err := ReadAccessPoints(userPath)
+		if err != nil {
+			return nil, err
+		}
+		accessPoints = append(accessPoints, userAccessPoints...)
+	}
+	return accessPoints, nil
+}
@@ -0,0 +1,91 @@
+package carrier
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type AccessPointsTestSuite struct {
+	dir string
+}
+
+var _ = Suite(&AccessPointsTestSuite{})
+
+func (s *AccessPointsTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *AccessPointsTestSuite) write(c *C, content string) string {
+	path := filepath.Join(s.dir, "apns.json")
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+	return path
+}
+
+func (s *AccessPointsTestSuite) TestReadAccessPointsMissingFile(c *C) {
+	accessPoints, err := ReadAccessPoints(filepath.Join(s.dir, "missing.json"))
+	c.Assert(err, IsNil)
+	c.Check(accessPoints, HasLen, 0)
+}
+
+func (s *AccessPointsTestSuite) TestReadAccessPointsIncomplete(c *C) {
+	_, err := ReadAccessPoints(s.write(c, `[{"MCC": "310", "MNC": "410", "AccessPointName": "mms"}]`))
+	c.Check(err, NotNil)
+}
+
+func (s *AccessPointsTestSuite) TestWriteReadAccessPoints(c *C) {
+	accessPoints := AccessPoints{{
+		MCC:             "310",
+		MNC:             "410",
+		AccessPointName: "mms",
+		MessageCenter:   "http://mmsc.example.com",
+		MessageProxy:    "10.0.0.1:80",
+	}}
+	path := filepath.Join(s.dir, "apns.json")
+	c.Assert(WriteAccessPoints(path, accessPoints), IsNil)
+	read, err := ReadAccessPoints(path)
+	c.Assert(err, IsNil)
+	c.Check(read, DeepEquals, accessPoints)
+}
+
+func (s *AccessPointsTestSuite) TestLookupPrefersLaterEntries(c *C) {
+	accessPoints := AccessPoints{
+		{MCC: "310", MNC: "410", AccessPointName: "system"},
+		{MCC: "231", MNC: "02", AccessPointName: "other"},
+		{MCC: "310", MNC: "410", AccessPointName: "user"},
+	}
+	found := accessPoints.Lookup("310", "410")
+	c.Assert(found, HasLen, 2)
+	c.Check(found[0].AccessPointName, Equals, "user")
+	c.Check(found[1].AccessPointName, Equals, "system")
+	c.Check(accessPoints.Lookup("001", "01"), HasLen, 0)
+}
+
+func (s *AccessPointsTestSuite) TestLoadAccessPointsSystem(c *C) {
+	orig := SystemAccessPointsPath
+	defer func() { SystemAccessPointsPath = orig }()
+	SystemAccessPointsPath = s.write(c, `[{"MCC": "231", "MNC": "01", "AccessPointName": "mms", "MessageCenter": "http://mms.example"}]`)
+
+	accessPoints, err := LoadAccessPoints()
+	c.Assert(err, IsNil)
+	found := accessPoints.Lookup("231", "01")
+	c.Assert(found, HasLen, 1)
+	c.Check(found[0].MessageCenter, Equals, "http://mms.example")
+}
+
+func (s *AccessPointsTestSuite) TestAndroidAccessPoint(c *C) {
+	apns, err := ParseAndroidAPNs(strings.NewReader(apnsConf))
+	c.Assert(err, IsNil)
+	c.Check(apns[0].AccessPoint(), DeepEquals, AccessPoint{
+		MCC:             "310",
+		MNC:             "410",
+		Name:            "Example MMS",
+		AccessPointName: "mms",
+		Username:        "mms",
+		Password:        "secret",
+		MessageCenter:   "http://mmsc.example.com/mms/wapenc",
+		MessageProxy:    "10.0.0.1:8080",
+	})
+}
@@ -0,0 +1,167 @@
+package carrier
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// AndroidAPN is an <apn> entry as found in Android's apns-conf.xml.
+type AndroidAPN struct {
+	Carrier  string `xml:"carrier,attr"`
+	MCC      string `xml:"mcc,attr"`
+	MNC      string `xml:"mnc,attr"`
+	APN      string `xml:"apn,attr"`
+	User     string `xml:"user,attr"`
+	Password string `xml:"password,attr"`
+	MMSC     string `xml:"mmsc,attr"`
+	MMSProxy string `xml:"mmsproxy,attr"`
+	MMSPort  string `xml:"mmsport,attr"`
+	Type     string `xml:"type,attr"`
+	// MVNOType and MVNOMatchData tell the virtual operator the entry is
+	// for, among those sharing the MCC and MNC of their host operator.
+	MVNOType      string `xml:"mvno_type,attr"`
+	MVNOMatchData string `xml:"mvno_match_data,attr"`
+}
+
+// IsMMS returns true if the APN is meant to be used for MMS.
+func (apn AndroidAPN) IsMMS() bool {
+	if apn.MMSC == "" {
+		return false
+	}
+	// An empty type means the APN is usable for everything.
+	if apn.Type == "" {
+		return true
+	}
+	for _, t := range strings.Split(apn.Type, ",") {
+		switch strings.TrimSpace(t) {
+		case "mms", "*":
+			return true
+		}
+	}
+	return false
+}
+
+// IsMVNO returns true if the APN is for a virtual operator.
+func (apn AndroidAPN) IsMVNO() bool {
+	return apn.MVNOType != "" || apn.MVNOMatchData != ""
+}
+
+// Proxy returns the MMS proxy in host:port form, or an empty string if the
+// APN has no MMS proxy.
+func (apn AndroidAPN) Proxy() string {
+	if apn.MMSProxy == "" {
+		return ""
+	}
+	if _, err := strconv.ParseUint(apn.MMSPort, 10, 16); err != nil {
+		return apn.MMSProxy
+	}
+	return net.JoinHostPort(apn.MMSProxy, apn.MMSPort)
+}
+
+// Profile converts the APN to a carrier profile.
+func (apn AndroidAPN) Profile() Profile {
+	return Profile{
+		MCC:           apn.MCC,
+		MNC:           apn.MNC,
+		Name:          apn.Carrier,
+		MessageCenter: apn.MMSC,
+		Proxy:         apn.Proxy(),
+	}
+}
+
+// AccessPoint converts the APN to an entry of the access point database.
+func (apn AndroidAPN) AccessPoint() AccessPoint {
+	return AccessPoint{
+		MCC:             apn.MCC,
+		MNC:             apn.MNC,
+		Name:            apn.Carrier,
+		AccessPointName: apn.APN,
+		Username:        apn.User,
+		Password:        apn.Password,
+		MessageCenter:   apn.MMSC,
+		MessageProxy:    apn.Proxy(),
+	}
+}
+
+// ParseAndroidAPNs reads an apns-conf.xml document and returns the entries
+// which are usable for MMS. Entries of virtual operators are left out, as
+// profiles are looked up by MCC and MNC only they would replace the one of
+// their host operator, and each other.
+func ParseAndroidAPNs(r io.Reader) ([]AndroidAPN, error) {
+	var doc struct {
+		APNs []AndroidAPN `xml:"apn"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot parse apns-conf: %w", err)
+	}
+
+	var apns []AndroidAPN
+	for _, apn := range doc.APNs {
+		if apn.MCC == "" || apn.MNC == "" || !apn.IsMMS() || apn.IsMVNO() {
+			continue
+		}
+		apns = append(apns, apn)
+	}
+	return apns, nil
+}
+
+// ParseAndroidCarrierConfig reads an Android carrier settings bundle
+// (<carrier_config_list>) and returns the MMS related settings as profiles.
+func ParseAndroidCarrierConfig(r io.Reader) (Overrides, error) {
+	type value struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+		Text  string `xml:",chardata"`
+	}
+	var doc struct {
+		Configs []struct {
+			MCC     string  `xml:"mcc,attr"`
+			MNC     string  `xml:"mnc,attr"`
+			Ints    []value `xml:"int"`
+			Strings []value `xml:"string"`
+		} `xml:"carrier_config"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot parse carrier config: %w", err)
+	}
+
+	var overrides Overrides
+	for _, config := range doc.Configs {
+		if config.MCC == "" || config.MNC == "" {
+			continue
+		}
+		profile := Profile{MCC: config.MCC, MNC: config.MNC}
+		for _, i := range config.Ints {
+			switch i.Name {
+			case "maxMessageSize":
+				if size, err := strconv.ParseUint(i.Value, 10, 64); err == nil {
+					profile.MaxMessageSize = size
+				}
+			case "recipientLimit":
+				// -1 means no limit.
+				if limit, err := strconv.ParseUint(i.Value, 10, 32); err == nil {
+					profile.MaxRecipients = uint32(limit)
+				}
+			}
+		}
+		for _, s := range config.Strings {
+			switch s.Name {
+			case "uaProfUrl":
+				profile.UAProf = strings.TrimSpace(s.Text)
+			case "userAgent":
+				profile.UserAgent = strings.TrimSpace(s.Text)
+			case "uaProfTagName":
+				profile.UAProfHeader = strings.TrimSpace(s.Text)
+			}
+		}
+		if profile.MaxMessageSize == 0 && profile.MaxRecipients == 0 && profile.UAProf == "" && profile.UAProfHeader == "" && profile.UserAgent == "" {
+			continue
+		}
+		overrides = append(overrides, profile)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,80 @@
+package carrier
+
+import (
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type AndroidTestSuite struct{}
+
+var _ = Suite(&AndroidTestSuite{})
+
+const apnsConf = `<?xml version="1.0" encoding="utf-8"?>
+<apns version="8">
+  <apn carrier="Example Internet" mcc="310" mnc="410" apn="internet" type="default,supl" />
+  <apn carrier="Example MMS" mcc="310" mnc="410" apn="mms" user="mms" password="secret"
+       mmsc="http://mmsc.example.com/mms/wapenc" mmsproxy="10.0.0.1" mmsport="8080" type="mms" />
+  <apn carrier="Other" mcc="231" mnc="02" apn="internet" mmsc="http://mms.other.example" type="" />
+  <apn carrier="No proxy port" mcc="231" mnc="06" apn="mms" mmsc="http://mms.noport.example" mmsproxy="10.1.1.1" type="default,mms" />
+  <apn carrier="Example MVNO" mcc="310" mnc="410" apn="mvno" mmsc="http://mms.mvno.example" type="mms"
+       mvno_type="spn" mvno_match_data="Example MVNO" />
+</apns>`
+
+func (s *AndroidTestSuite) TestParseAndroidAPNs(c *C) {
+	apns, err := ParseAndroidAPNs(strings.NewReader(apnsConf))
+	c.Assert(err, IsNil)
+	c.Assert(apns, HasLen, 3)
+
+	c.Check(apns[0].Profile(), DeepEquals, Profile{
+		MCC:           "310",
+		MNC:           "410",
+		Name:          "Example MMS",
+		MessageCenter: "http://mmsc.example.com/mms/wapenc",
+		Proxy:         "10.0.0.1:8080",
+	})
+	c.Check(apns[0].User, Equals, "mms")
+	c.Check(apns[0].Password, Equals, "secret")
+	c.Check(apns[1].Proxy(), Equals, "")
+	c.Check(apns[2].Proxy(), Equals, "10.1.1.1")
+}
+
+func (s *AndroidTestSuite) TestParseAndroidAPNsMalformed(c *C) {
+	_, err := ParseAndroidAPNs(strings.NewReader("<apns><apn"))
+	c.Check(err, NotNil)
+}
+
+const carrierConfig = `<carrier_config_list>
+  <carrier_config mcc="310" mnc="410">
+    <int name="maxMessageSize" value="1048576" />
+    <int name="recipientLimit" value="20" />
+    <string name="uaProfUrl">http://example.com/uaprof.xml</string>
+    <string name="userAgent">ExampleMMS/1.0</string>
+    <string name="uaProfTagName">Profile</string>
+    <boolean name="enabledMMS" value="true" />
+  </carrier_config>
+  <carrier_config mcc="231" mnc="02">
+    <int name="recipientLimit" value="-1" />
+    <boolean name="enabledMMS" value="true" />
+  </carrier_config>
+</carrier_config_list>`
+
+func (s *AndroidTestSuite) TestParseAndroidCarrierConfig(c *C) {
+	overrides, err := ParseAndroidCarrierConfig(strings.NewReader(carrierConfig))
+	c.Assert(err, IsNil)
+	c.Check(overrides, DeepEquals, Overrides{{
+		MCC:            "310",
+		MNC:            "410",
+		MaxMessageSize: 1048576,
+		MaxRecipients:  20,
+		UAProf:         "http://example.com/uaprof.xml",
+		UAProfHeader:   "Profile",
+		UserAgent:      "ExampleMMS/1.0",
+	}})
+}
+
+func (s *AndroidTestSuite) TestReplace(c *C) {
+	overrides := Overrides{{MCC: "310", MNC: "410", Name: "old"}, {MCC: "231", MNC: "02"}}
+	replaced := overrides.Replace(Overrides{{MCC: "310", MNC: "410", Name: "new"}})
+	c.Check(replaced, DeepEquals, Overrides{{MCC: "231", MNC: "02"}, {MCC: "310", MNC: "410", Name: "new"}})
+}
@@ -0,0 +1,275 @@
+// Package carrier provides per operator MMS settings that complement or
+// override what is provisioned in ofono.
+//
+// Operators are identified by the MCC/MNC pair of the SIM in use. Settings are
+// read from a system wide file (SystemOverridesPath) and from a user editable
+// file in the XDG config directory (UserOverridesPath), the latter taking
+// precedence on a per field basis. This lets porters and users fix limits for
+// a new operator without needing a new nuntium release.
+package carrier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// SystemOverridesPath is the location of the system wide carrier override
+// file, usually shipped by porters.
+var SystemOverridesPath = "/etc/nuntium/carriers.json"
+
+// SystemOverridesDir holds further system wide carrier override files, read
+// in lexical order after SystemOverridesPath. Quirk profiles for operators
+// can be shipped there in packages of their own, updated independently of
+// nuntium.
+var SystemOverridesDir = "/etc/nuntium/carriers.d"
+
+// UserOverridesPath is the location of the user editable carrier override
+// file relative to the XDG config directory.
+var UserOverridesPath = filepath.Join("nuntium", "carriers.json")
+
+// RejectStatus values.
+const (
+	RejectRejected  = "rejected"
+	RejectRetrieved = "retrieved"
+)
+
+// Redirects values.
+const (
+	RedirectNone     = "none"
+	RedirectSameHost = "same-host"
+	RedirectAny      = "any"
+)
+
+// Profile holds MMS settings for an operator.
+type Profile struct {
+	// MCC is the mobile country code the profile applies to.
+	MCC string
+	// MNC is the mobile network code the profile applies to.
+	MNC string
+	// Name is an optional human readable operator name.
+	Name string `json:",omitempty"`
+	// MaxMessageSize is the largest m-send.req in bytes the MMSC accepts,
+	// 0 means no limit is known.
+	MaxMessageSize uint64 `json:",omitempty"`
+	// MaxRecipients is the largest number of To, Cc and Bcc recipients of
+	// an m-send.req the MMSC accepts, 0 means no limit is known.
+	MaxRecipients uint32 `json:",omitempty"`
+	// MaxAddressSize is the largest size in bytes of the encoded To, Cc and
+	// Bcc headers of an m-send.req the MMSC accepts, 0 means no limit is
+	// known.
+	MaxAddressSize uint64 `json:",omitempty"`
+	// RejectStatus is the status of the m-notifyresp.ind answering the
+	// notification of a message the user deleted before downloading it,
+	// RejectRejected if empty. Some MMSCs keep pushing rejected messages.
+	RejectStatus string `json:",omitempty"`
+	// MessageCenter forces the MMSC URL, overriding the one provisioned in
+	// the ofono context.
+	MessageCenter string `json:",omitempty"`
+	// Proxy forces an MMS proxy in host:port form, overriding the proxy
+	// provisioned in the ofono context.
+	Proxy string `json:",omitempty"`
+	// ProxyUsername and ProxyPassword authenticate against the MMS proxy,
+	// whether it is forced or provisioned in the ofono context.
+	ProxyUsername string `json:",omitempty"`
+	ProxyPassword string `json:",omitempty"`
+	// UAProf is the User Agent Profile URL some MMSCs require to be
+	// advertised.
+	UAProf string `json:",omitempty"`
+	// UserAgent is the User-Agent some MMSCs require to be sent.
+	UserAgent string `json:",omitempty"`
+	// UAProfHeader is the name of the header UAProf is sent in, as is,
+	// for MMSCs which do not take X-Wap-Profile, e.g. "x-wap-profile" or
+	// "Profile".
+	UAProfHeader string `json:",omitempty"`
+	// EarlyNotifyResp answers downloads with the m-notifyresp.ind as soon
+	// as the m-retrieve.conf is stored, for MMSCs which give up on the
+	// retrieval if the answer comes late. By default it is sent once the
+	// message was handed on, so a message lost on the way is pushed again.
+	EarlyNotifyResp bool `json:",omitempty"`
+	// Redirects tells which redirects of the MMSC downloads follow, one of
+	// RedirectNone, RedirectSameHost and RedirectAny. If empty the download
+	// manager handles them.
+	Redirects string `json:",omitempty"`
+	// CACertificates is a PEM file with the CA certificates, besides the
+	// system ones, which issue the certificate of an MMSC with an https
+	// URL.
+	CACertificates string `json:",omitempty"`
+	// CertificatePins are the pins of which the certificate chain of an
+	// MMSC with an https URL must contain one, see mms.CertificatePin.
+	CertificatePins []string `json:",omitempty"`
+	// Transport is the name of the transport used to exchange PDUs with the
+	// MMSC, empty for MM1, see the transport package.
+	Transport string `json:",omitempty"`
+	// TransportOptions are passed to the transport.
+	TransportOptions map[string]string `json:",omitempty"`
+	// IPBearer tells the MMSC can be reached over any IP bearer, e.g.
+	// Wi-Fi, so no MMS context needs to be activated. It is only used if
+	// the AllowIPBearer option is set.
+	IPBearer bool `json:",omitempty"`
+}
+
+func (p Profile) String() string {
+	return fmt.Sprintf("%s/%s (%s)", p.MCC, p.MNC, p.Name)
+}
+
+// merge overrides fields in p with the ones set in o.
+func (p *Profile) merge(o Profile) {
+	if o.Name != "" {
+		p.Name = o.Name
+	}
+	if o.MaxMessageSize != 0 {
+		p.MaxMessageSize = o.MaxMessageSize
+	}
+	if o.MaxRecipients != 0 {
+		p.MaxRecipients = o.MaxRecipients
+	}
+	if o.MaxAddressSize != 0 {
+		p.MaxAddressSize = o.MaxAddressSize
+	}
+	if o.RejectStatus != "" {
+		p.RejectStatus = o.RejectStatus
+	}
+	if o.MessageCenter != "" {
+		p.MessageCenter = o.MessageCenter
+	}
+	if o.Proxy != "" {
+		p.Proxy = o.Proxy
+	}
+	if o.ProxyUsername != "" {
+		p.ProxyUsername = o.ProxyUsername
+		p.ProxyPassword = o.ProxyPassword
+	}
+	if o.UAProf != "" {
+		p.UAProf = o.UAProf
+	}
+	if o.UAProfHeader != "" {
+		p.UAProfHeader = o.UAProfHeader
+	}
+	if o.EarlyNotifyResp {
+		p.EarlyNotifyResp = true
+	}
+	if o.Redirects != "" {
+		p.Redirects = o.Redirects
+	}
+	if o.UserAgent != "" {
+		p.UserAgent = o.UserAgent
+	}
+	if o.CACertificates != "" {
+		p.CACertificates = o.CACertificates
+	}
+	if len(o.CertificatePins) > 0 {
+		p.CertificatePins = o.CertificatePins
+	}
+	if o.Transport != "" {
+		p.Transport = o.Transport
+		p.TransportOptions = o.TransportOptions
+	}
+	if o.IPBearer {
+		p.IPBearer = true
+	}
+}
+
+// Overrides is a set of carrier profiles.
+type Overrides []Profile
+
+// Lookup returns the merged profile for mcc and mnc. If no entry matches,
+// ok is false.
+func (overrides Overrides) Lookup(mcc, mnc string) (profile Profile, ok bool) {
+	profile = Profile{MCC: mcc, MNC: mnc}
+	for _, o := range overrides {
+		if o.MCC != mcc || o.MNC != mnc {
+			continue
+		}
+		profile.merge(o)
+		ok = true
+	}
+	return profile, ok
+}
+
+// Replace returns overrides with all entries for the operators in profiles
+// replaced by profiles.
+func (overrides Overrides) Replace(profiles Overrides) Overrides {
+	replaced := make(map[string]bool)
+	for _, p := range profiles {
+		replaced[p.MCC+"/"+p.MNC] = true
+	}
+	var result Overrides
+	for _, o := range overrides {
+		if !replaced[o.MCC+"/"+o.MNC] {
+			result = append(result, o)
+		}
+	}
+	return append(result, profiles...)
+}
+
+// ReadOverrides reads a carrier override file. A missing file is not an
+// error and yields no overrides.
+func ReadOverrides(path string) (Overrides, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var overrides Overrides
+	if err := json.NewDecoder(f).Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("cannot parse carrier overrides in %s: %w", path, err)
+	}
+	for i, o := range overrides {
+		if o.MCC == "" || o.MNC == "" {
+			return nil, fmt.Errorf("carrier override %d in %s has no MCC or MNC", i, path)
+		}
+		if o.RejectStatus != "" && o.RejectStatus != RejectRejected && o.RejectStatus != RejectRetrieved {
+			return nil, fmt.Errorf("carrier override %d in %s has an unknown RejectStatus %q", i, path, o.RejectStatus)
+		}
+		if o.Redirects != "" && o.Redirects != RedirectNone && o.Redirects != RedirectSameHost && o.Redirects != RedirectAny {
+			return nil, fmt.Errorf("carrier override %d in %s has an unknown Redirects %q", i, path, o.Redirects)
+		}
+	}
+	return overrides, nil
+}
+
+// WriteOverrides writes overrides to path in the format ReadOverrides expects.
+func WriteOverrides(path string, overrides Overrides) error {
+	b, err := json.MarshalIndent(overrides, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// LoadOverrides reads the system override files and the user one, user
+// entries come last so they take precedence on Lookup.
+func LoadOverrides() (Overrides, error) {
+	overrides, err := ReadOverrides(SystemOverridesPath)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(SystemOverridesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	// Glob returns the paths sorted.
+	for _, path := range paths {
+		dirOverrides, err := ReadOverrides(path)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, dirOverrides...)
+	}
+	if userPath, err := xdg.Config.Find(UserOverridesPath); err == nil {
+		userOverrides, err := ReadOverrides(userPath)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, userOverrides...)
+	}
+	return overrides, nil
+}
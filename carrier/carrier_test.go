@@ -0,0 +1,135 @@
+package carrier
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+type CarrierTestSuite struct {
+	dir string
+}
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var _ = Suite(&CarrierTestSuite{})
+
+func (s *CarrierTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *CarrierTestSuite) write(c *C, content string) string {
+	path := filepath.Join(s.dir, "carriers.json")
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+	return path
+}
+
+func (s *CarrierTestSuite) TestReadOverridesMissingFile(c *C) {
+	overrides, err := ReadOverrides(filepath.Join(s.dir, "missing.json"))
+	c.Assert(err, IsNil)
+	c.Check(overrides, HasLen, 0)
+}
+
+func (s *CarrierTestSuite) TestReadOverrides(c *C) {
+	path := s.write(c, `[{"MCC": "310", "MNC": "410", "MaxMessageSize": 1048576, "UAProf": "http://example.com/uaprof.xml"}]`)
+	overrides, err := ReadOverrides(path)
+	c.Assert(err, IsNil)
+	c.Assert(overrides, HasLen, 1)
+
+	profile, ok := overrides.Lookup("310", "410")
+	c.Assert(ok, Equals, true)
+	c.Check(profile.MaxMessageSize, Equals, uint64(1048576))
+	c.Check(profile.UAProf, Equals, "http://example.com/uaprof.xml")
+
+	_, ok = overrides.Lookup("310", "41")
+	c.Check(ok, Equals, false)
+}
+
+func (s *CarrierTestSuite) TestReadOverridesMissingMCC(c *C) {
+	path := s.write(c, `[{"MNC": "410"}]`)
+	_, err := ReadOverrides(path)
+	c.Check(err, NotNil)
+}
+
+func (s *CarrierTestSuite) TestReadOverridesUnknownRejectStatus(c *C) {
+	path := s.write(c, `[{"MCC": "310", "MNC": "410", "RejectStatus": "deferred"}]`)
+	_, err := ReadOverrides(path)
+	c.Check(err, NotNil)
+}
+
+func (s *CarrierTestSuite) TestReadOverridesUnknownRedirects(c *C) {
+	path := s.write(c, `[{"MCC": "310", "MNC": "410", "Redirects": "all"}]`)
+	_, err := ReadOverrides(path)
+	c.Check(err, NotNil)
+}
+
+func (s *CarrierTestSuite) TestReadOverridesMalformed(c *C) {
+	path := s.write(c, `{`)
+	_, err := ReadOverrides(path)
+	c.Check(err, NotNil)
+}
+
+func (s *CarrierTestSuite) TestLookupMerges(c *C) {
+	overrides := Overrides{
+		{MCC: "231", MNC: "02", Name: "System", MaxMessageSize: 300 * 1024, Proxy: "10.0.0.1:8080"},
+		{MCC: "231", MNC: "02", MaxMessageSize: 600 * 1024},
+	}
+	profile, ok := overrides.Lookup("231", "02")
+	c.Assert(ok, Equals, true)
+	c.Check(profile, DeepEquals, Profile{MCC: "231", MNC: "02", Name: "System", MaxMessageSize: 600 * 1024, Proxy: "10.0.0.1:8080"})
+}
+
+func (s *CarrierTestSuite) TestLookupMergesTransport(c *C) {
+	overrides := Overrides{
+		{MCC: "001", MNC: "01", Transport: "exec", TransportOptions: map[string]string{"download": "a", "upload": "b"}},
+		{MCC: "001", MNC: "01", Transport: "exec", TransportOptions: map[string]string{"download": "c"}},
+		{MCC: "001", MNC: "01", Name: "Lab"},
+	}
+	profile, ok := overrides.Lookup("001", "01")
+	c.Assert(ok, Equals, true)
+	c.Check(profile.Transport, Equals, "exec")
+	c.Check(profile.TransportOptions, DeepEquals, map[string]string{"download": "c"})
+}
+
+func (s *CarrierTestSuite) TestLookupMergesIPBearer(c *C) {
+	overrides := Overrides{
+		{MCC: "310", MNC: "260", IPBearer: true},
+		{MCC: "310", MNC: "260", MessageCenter: "http://mms.example.com"},
+	}
+	profile, ok := overrides.Lookup("310", "260")
+	c.Assert(ok, Equals, true)
+	c.Check(profile.IPBearer, Equals, true)
+	c.Check(profile.MessageCenter, Equals, "http://mms.example.com")
+}
+
+func (s *CarrierTestSuite) TestLoadOverridesSystem(c *C) {
+	orig := SystemOverridesPath
+	defer func() { SystemOverridesPath = orig }()
+	SystemOverridesPath = s.write(c, `[{"MCC": "231", "MNC": "01", "Proxy": "10.1.1.1:80"}]`)
+
+	overrides, err := LoadOverrides()
+	c.Assert(err, IsNil)
+	profile, ok := overrides.Lookup("231", "01")
+	c.Assert(ok, Equals, true)
+	c.Check(profile.Proxy, Equals, "10.1.1.1:80")
+}
+
+func (s *CarrierTestSuite) TestLoadOverridesSystemDir(c *C) {
+	origPath, origDir := SystemOverridesPath, SystemOverridesDir
+	defer func() { SystemOverridesPath, SystemOverridesDir = origPath, origDir }()
+	SystemOverridesPath = s.write(c, `[{"MCC": "231", "MNC": "01", "Proxy": "10.1.1.1:80", "UAProf": "http://a"}]`)
+	SystemOverridesDir = c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(SystemOverridesDir, "20-quirks.json"), []byte(`[{"MCC": "231", "MNC": "01", "UAProfHeader": "x-wap-profile"}]`), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(SystemOverridesDir, "10-quirks.json"), []byte(`[{"MCC": "231", "MNC": "01", "UAProfHeader": "Profile", "EarlyNotifyResp": true}]`), 0644), IsNil)
+
+	overrides, err := LoadOverrides()
+	c.Assert(err, IsNil)
+	profile, ok := overrides.Lookup("231", "01")
+	c.Assert(ok, Equals, true)
+	c.Check(profile.Proxy, Equals, "10.1.1.1:80")
+	c.Check(profile.UAProfHeader, Equals, "x-wap-profile")
+	c.Check(profile.EarlyNotifyResp, Equals, true)
+}
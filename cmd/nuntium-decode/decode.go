@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+)
+
+// Result is what is decoded from a PDU file.
+type Result struct {
+	// Push holds the headers of the WAP push the PDU came in, if it is one.
+	Push *ofono.PushPDU `json:",omitempty"`
+	// Type names the MMS PDU, e.g. m-retrieve.conf, and Headers holds its
+	// decoded headers, without the data of its parts.
+	Type    string      `json:",omitempty"`
+	Headers interface{} `json:",omitempty"`
+	Parts   []Part      `json:",omitempty"`
+	// Log is the decoder debug log and Error why decoding failed.
+	Log   string `json:",omitempty"`
+	Error string `json:",omitempty"`
+
+	attachments []mms.Attachment
+}
+
+// Part describes a part of a decoded message.
+type Part struct {
+	MediaType       string
+	Name            string `json:",omitempty"`
+	ContentId       string `json:",omitempty"`
+	ContentLocation string `json:",omitempty"`
+	Charset         string `json:",omitempty"`
+	Size            int
+}
+
+// pduTypes maps the MMS PDUs the decoder handles to their names and the
+// structures to decode them into.
+var pduTypes = map[byte]struct {
+	name string
+	new  func() mms.MMSReader
+}{
+	// An m-send.req has the headers and body of an m-retrieve.conf.
+	mms.TYPE_SEND_REQ:         {"m-send.req", func() mms.MMSReader { return &mms.MRetrieveConf{Type: mms.TYPE_SEND_REQ} }},
+	mms.TYPE_SEND_CONF:        {"m-send.conf", func() mms.MMSReader { return mms.NewMSendConf() }},
+	mms.TYPE_NOTIFICATION_IND: {"m-notification.ind", func() mms.MMSReader { return mms.NewMNotificationInd(time.Time{}) }},
+	mms.TYPE_NOTIFYRESP_IND:   {"m-notifyresp.ind", func() mms.MMSReader { return mms.NewMNotifyRespInd() }},
+	mms.TYPE_RETRIEVE_CONF:    {"m-retrieve.conf", func() mms.MMSReader { return mms.NewMRetrieveConf("") }},
+	mms.TYPE_DELIVERY_IND:     {"m-delivery.ind", func() mms.MMSReader { return mms.NewMDeliveryInd() }},
+	mms.TYPE_READ_ORIG_IND:    {"m-read-orig.ind", func() mms.MMSReader { return mms.NewMReadOrigInd() }},
+}
+
+// decode decodes data, either a WAP push or an MMS PDU. Whatever could be
+// decoded is in the result even if decoding failed.
+func decode(data []byte) *Result {
+	result := &Result{}
+	if _, err := mms.MessageType(data); err != nil {
+		push := new(ofono.PushPDU)
+		if err := ofono.NewDecoder(data).Decode(push); err != nil {
+			result.Error = fmt.Sprintf("neither an MMS PDU nor a WAP push: %v", err)
+			return result
+		}
+		data, push.Data = push.Data, nil
+		result.Push = push
+		if push.ContentType != mms.VND_WAP_MMS_MESSAGE {
+			return result
+		}
+	}
+
+	messageType, err := mms.MessageType(data)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	pduType, ok := pduTypes[messageType]
+	if !ok {
+		result.Error = fmt.Sprintf("unsupported message type %#x", messageType)
+		return result
+	}
+	result.Type = pduType.name
+	pdu := pduType.new()
+	dec := mms.NewDecoder(data)
+	if err := dec.Decode(pdu); err != nil {
+		result.Error = err.Error()
+	}
+	result.Log = dec.GetLog()
+	if mRetrieveConf, ok := pdu.(*mms.MRetrieveConf); ok {
+		result.attachments = mRetrieveConf.Attachments
+		for _, a := range mRetrieveConf.Attachments {
+			result.Parts = append(result.Parts, Part{a.MediaType, a.Name, a.ContentId, a.ContentLocation, a.Charset, len(a.Data)})
+		}
+		mRetrieveConf.Attachments = nil
+		mRetrieveConf.Content.Data = nil
+		mRetrieveConf.Data = nil
+	}
+	result.Headers = pdu
+	return result
+}
+
+// writeText writes result to w for people to read: the push headers, the
+// headers which are set, the parts and the decoder log.
+func writeText(w io.Writer, result *Result) error {
+	if result.Push != nil {
+		fmt.Fprintln(w, "WAP push:")
+		writeFields(w, reflect.ValueOf(result.Push).Elem())
+	}
+	if result.Type != "" {
+		fmt.Fprintf(w, "%s:\n", result.Type)
+		writeFields(w, reflect.ValueOf(result.Headers).Elem())
+	}
+	if len(result.Parts) > 0 {
+		fmt.Fprintln(w, "Parts:")
+		for i, part := range result.Parts {
+			fmt.Fprintf(w, "\t%d: %s, %d bytes", i, part.MediaType, part.Size)
+			for _, field := range []struct{ name, value string }{
+				{"name", part.Name},
+				{"id", part.ContentId},
+				{"location", part.ContentLocation},
+				{"charset", part.Charset},
+			} {
+				if field.value != "" {
+					fmt.Fprintf(w, ", %s %q", field.name, field.value)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if result.Log != "" {
+		fmt.Fprintf(w, "Decoder log:\n%s\n", result.Log)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// writeFields writes the exported fields of the struct v which are not zero,
+// a line each.
+func writeFields(w io.Writer, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if field.PkgPath != "" || field.Anonymous || value.IsZero() {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.Uint8:
+			fmt.Fprintf(w, "\t%s: %#x\n", field.Name, value.Uint())
+		case reflect.String:
+			fmt.Fprintf(w, "\t%s: %q\n", field.Name, value.String())
+		default:
+			fmt.Fprintf(w, "\t%s: %+v\n", field.Name, value.Interface())
+		}
+	}
+}
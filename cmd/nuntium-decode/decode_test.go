@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// vodafoneSpainPush is a WAP push carrying an m-notification.ind.
+var vodafoneSpainPush = []byte{
+	0x00, 0x06, 0x26, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2f, 0x76, 0x6e, 0x64, 0x2e, 0x77, 0x61, 0x70, 0x2e, 0x6d, 0x6d, 0x73,
+	0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x00, 0xaf, 0x84, 0xb4, 0x81,
+	0x8d, 0xdf, 0x8c, 0x82, 0x98, 0x4e, 0x4f, 0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30,
+	0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62, 0x77, 0x55, 0x48, 0x00, 0x8d, 0x90,
+	0x89, 0x18, 0x80, 0x2b, 0x33, 0x34, 0x36, 0x30, 0x30, 0x39, 0x34, 0x34, 0x34,
+	0x36, 0x33, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00,
+	0x8a, 0x80, 0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa3, 0x00,
+	0x83, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x31, 0x66, 0x65,
+	0x31, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x6c, 0x65, 0x74, 0x73, 0x2f, 0x4e, 0x4f,
+	0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30, 0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62,
+	0x77, 0x55, 0x48, 0x00,
+}
+
+func TestDecodePush(t *testing.T) {
+	result := decode(vodafoneSpainPush)
+	if result.Error != "" {
+		t.Fatalf("decode() failed: %s", result.Error)
+	}
+	if result.Push == nil || result.Push.ContentType != mms.VND_WAP_MMS_MESSAGE {
+		t.Errorf("decode() returned push %+v", result.Push)
+	}
+	mNotificationInd, ok := result.Headers.(*mms.MNotificationInd)
+	if result.Type != "m-notification.ind" || !ok {
+		t.Fatalf("decode() returned a %s: %#v", result.Type, result.Headers)
+	}
+	if want := "http://mm1fe1/servlets/NOK5Cdz08BAswabwUH"; mNotificationInd.ContentLocation != want {
+		t.Errorf("ContentLocation = %q, want %q", mNotificationInd.ContentLocation, want)
+	}
+
+	var out bytes.Buffer
+	if err := writeText(&out, result); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"WAP push:\n", "m-notification.ind:\n", "\tFrom: \"+34600944463/TYPE=PLMN\"\n", "Decoder log:\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("text output %q does not contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestDecodeMSendReq(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-decode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	textPath := filepath.Join(dir, "text.txt")
+	if err := ioutil.WriteFile(textPath, []byte("Hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	text, err := mms.NewAttachment("text", "text/plain", textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pdu bytes.Buffer
+	if err := mms.NewEncoder(&pdu).Encode(mms.NewMSendReq([]string{"+11111"}, []*mms.Attachment{text}, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	result := decode(pdu.Bytes())
+	if result.Error != "" {
+		t.Fatalf("decode() failed: %s", result.Error)
+	}
+	if result.Type != "m-send.req" || result.Push != nil {
+		t.Errorf("decode() returned a %s in push %+v", result.Type, result.Push)
+	}
+	if len(result.Parts) != 1 || !strings.HasPrefix(result.Parts[0].MediaType, "text/plain") || result.Parts[0].Size != 5 {
+		t.Errorf("decode() returned parts %+v", result.Parts)
+	}
+
+	partsDir := filepath.Join(dir, "parts")
+	if err := writeParts(partsDir, result); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(partsDir, "text")); err != nil || string(data) != "Hello" {
+		t.Errorf("written part is %q, %v", data, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a PDU"), {0x8c, 0x99}} {
+		if result := decode(data); result.Error == "" {
+			t.Errorf("decode(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Command nuntium-decode decodes a raw PDU file offline, to triage carrier
+// interoperability bugs with the PDUs users attach, e.g. the ones exported
+// with ExportMessagePDU.
+//
+// The file is either an MMS PDU, e.g. an m-retrieve.conf, m-send.req or
+// m-send.conf, or a WAP push as oFono passes it on, whose m-notification.ind
+// is decoded too. The headers, parts and decoder debug log are printed as
+// text or, with --json, as JSON. With --parts the data of the parts of a
+// message is written to a directory.
+//
+//	nuntium-decode [--json] [--parts DIR] FILE
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+type mainFlags struct {
+	JSON  bool   `long:"json" short:"j" description:"Print the decoded PDU as JSON"`
+	Parts string `long:"parts" short:"p" description:"Directory to write the data of the parts of a message to"`
+	Args  struct {
+		File string `positional-arg-name:"FILE" description:"The raw PDU file"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func main() {
+	var args mainFlags
+	parser := flags.NewParser(&args, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(args.Args.File)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	result := decode(data)
+
+	if args.Parts != "" && len(result.attachments) > 0 {
+		if err := writeParts(args.Parts, result); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if args.JSON {
+		out, err := json.MarshalIndent(result, "", "\t")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	} else if err := writeText(os.Stdout, result); err != nil {
+		fmt.Fprintln(os.Stderr, "Decoding failed:", err)
+	}
+	if result.Error != "" {
+		os.Exit(1)
+	}
+}
+
+// writeParts writes the data of the parts in result to dir, named after the
+// parts or, if they have no name, their index.
+func writeParts(dir string, result *Result) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, a := range result.attachments {
+		name := filepath.Base(a.Name)
+		if a.Name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+			name = strconv.Itoa(i)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), a.Data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
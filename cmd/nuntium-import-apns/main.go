@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/ubports/nuntium/carrier"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/ofono"
+	"launchpad.net/go-xdg/v0"
+)
+
+type mainFlags struct {
+	// APNs are Android apns-conf.xml files to import mmsc/mmsproxy/mmsport entries from.
+	APNs []string `long:"apns" short:"a" description:"Android apns-conf.xml file to import (can be repeated)"`
+	// CarrierConfigs are Android carrier settings bundles to import maxMessageSize/uaProfUrl from.
+	CarrierConfigs []string `long:"carrier-config" short:"c" description:"Android carrier config xml file to import (can be repeated)"`
+	// MCC and MNC restrict the import to a single operator.
+	MCC string `long:"mcc" description:"Only import entries for this mobile country code"`
+	MNC string `long:"mnc" description:"Only import entries for this mobile network code"`
+	// Output is the carrier override file to merge the imported profiles into.
+	Output string `long:"output" short:"o" description:"Carrier override file to write (if not set, the user override file is used)"`
+	// AccessPoints is the access point database to merge the imported APNs into.
+	AccessPoints string `long:"access-points" description:"Access point database to write (if not set, the user access point database is used)"`
+	// DryRun prints the imported profiles instead of writing them.
+	DryRun bool `long:"dry-run" short:"n" description:"Print the imported profiles instead of writing them"`
+	// Modem, when set, provisions ofono mms contexts for the SIM's operator.
+	Modem string `long:"ofono-modem" description:"Also add ofono mms contexts for the operator of the SIM in this modem (e.g. /ril_0)"`
+}
+
+func (args mainFlags) matches(mcc, mnc string) bool {
+	return (args.MCC == "" || args.MCC == mcc) && (args.MNC == "" || args.MNC == mnc)
+}
+
+func main() {
+	var args mainFlags
+
+	parser := flags.NewParser(&args, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		os.Exit(1)
+	}
+	if len(args.APNs) == 0 && len(args.CarrierConfigs) == 0 {
+		fmt.Println("Nothing to import, use --apns or --carrier-config")
+		os.Exit(1)
+	}
+
+	var apns []carrier.AndroidAPN
+	var accessPoints carrier.AccessPoints
+	var profiles carrier.Overrides
+	for _, path := range args.APNs {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		parsed, err := carrier.ParseAndroidAPNs(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, apn := range parsed {
+			if args.matches(apn.MCC, apn.MNC) {
+				apns = append(apns, apn)
+				profiles = append(profiles, apn.Profile())
+				if apn.APN != "" {
+					accessPoints = append(accessPoints, apn.AccessPoint())
+				}
+			}
+		}
+	}
+	for _, path := range args.CarrierConfigs {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		parsed, err := carrier.ParseAndroidCarrierConfig(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, profile := range parsed {
+			if args.matches(profile.MCC, profile.MNC) {
+				profiles = append(profiles, profile)
+			}
+		}
+	}
+	fmt.Printf("Imported %d carrier profiles and %d access points\n", len(profiles), len(accessPoints))
+
+	if args.DryRun {
+		for _, profile := range profiles {
+			fmt.Printf("%s: %+v\n", profile, profile)
+		}
+		for _, ap := range accessPoints {
+			fmt.Printf("%s: %+v\n", ap, ap)
+		}
+	} else {
+		if err := writeProfiles(args.Output, profiles); err != nil {
+			fmt.Println("Cannot write carrier overrides:", err)
+			os.Exit(1)
+		}
+		if len(accessPoints) > 0 {
+			if err := writeAccessPoints(args.AccessPoints, accessPoints); err != nil {
+				fmt.Println("Cannot write access points:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if args.Modem != "" {
+		if err := addContexts(dbus.ObjectPath(args.Modem), apns, args.DryRun); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+func writeProfiles(output string, profiles carrier.Overrides) error {
+	if output == "" {
+		var err error
+		if output, err = xdg.Config.Ensure(carrier.UserOverridesPath); err != nil {
+			return err
+		}
+	}
+	existing, err := carrier.ReadOverrides(output)
+	if err != nil {
+		return err
+	}
+	if err := carrier.WriteOverrides(output, existing.Replace(profiles)); err != nil {
+		return err
+	}
+	fmt.Println("Carrier overrides written to", output)
+	return nil
+}
+
+func writeAccessPoints(output string, accessPoints carrier.AccessPoints) error {
+	if output == "" {
+		var err error
+		if output, err = xdg.Config.Ensure(carrier.UserAccessPointsPath); err != nil {
+			return err
+		}
+	}
+	existing, err := carrier.ReadAccessPoints(output)
+	if err != nil {
+		return err
+	}
+	if err := carrier.WriteAccessPoints(output, existing.Replace(accessPoints)); err != nil {
+		return err
+	}
+	fmt.Println("Access points written to", output)
+	return nil
+}
+
+func addContexts(modemPath dbus.ObjectPath, apns []carrier.AndroidAPN, dryRun bool) error {
+	conn, err := dbus.Connect(dbus.SystemBus)
+	if err != nil {
+		return err
+	}
+	modem := ofono.NewModem(conn, modemPath)
+	mcc, mnc, err := modem.OperatorCode()
+	if err != nil {
+		return err
+	}
+
+	for _, apn := range apns {
+		if apn.MCC != mcc || apn.MNC != mnc {
+			continue
+		}
+		settings := ofono.ContextSettings{
+			Name:            apn.Carrier,
+			AccessPointName: apn.APN,
+			Username:        apn.User,
+			Password:        apn.Password,
+			MessageCenter:   apn.MMSC,
+			MessageProxy:    apn.Proxy(),
+		}
+		if dryRun {
+			fmt.Printf("Would add mms context %q to %s with apn %q, mmsc %q and proxy %q\n", settings.Name, modemPath, settings.AccessPointName, settings.MessageCenter, settings.MessageProxy)
+			continue
+		}
+		contextPath, err := modem.AddMMSContext(settings)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added mms context %s for %s\n", contextPath, apn.Carrier)
+	}
+	return nil
+}
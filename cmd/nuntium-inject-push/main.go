@@ -39,6 +39,10 @@ type mainFlags struct {
 	ErrorRespondHandle uint64 `long:"error-respond" description:"Number of respond handling errors after successful message forward"`
 	// ErrorRespondStorage indicates how many times will nuntium throw an storage error after message was successfully downloaded, forwarded to telepathy and responded to MMS center.
 	ErrorRespondStorage uint64 `long:"error-storage-respond" description:"Number of storage errors after successful message handling"`
+	// Expired makes the push notification expire long ago.
+	Expired bool `long:"expired" description:"Push a notification which has already expired"`
+	// Scenario is a file with a sequence of pushes to inject and the signals each is expected to cause.
+	Scenario string `long:"scenario" description:"Run the scenario in the JSON file instead of a single push and check the resulting MessageAdded signals"`
 	// ErrorTelepathyErrorNotify indicates how many times will nuntium throw an error when message handling error is communicated to telepathy.
 	ErrorTelepathyErrorNotify uint64 `long:"error-telepathy-error-notify" description:"Number of telepathy notify errors after message handling error"`
 }
@@ -68,6 +72,11 @@ func main() {
 		fmt.Printf("Using endpoint: \"%s\"\n", args.EndPoint)
 	}
 
+	if args.Scenario != "" {
+		scenarioMain(args)
+		return
+	}
+
 	fmt.Println("Creating web server to serve mms")
 	done := make(chan bool)
 	mmsHandler, err := createSpace(args, done)
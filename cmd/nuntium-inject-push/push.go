@@ -6,8 +6,8 @@ import (
 	"net/url"
 	"strconv"
 
+	"github.com/ubports/nuntium/internal/dbus"
 	"github.com/ubports/nuntium/mms"
-	"launchpad.net/go-dbus/v1"
 )
 
 const (
@@ -72,6 +72,13 @@ var mNotificationIndExpire = []byte{
 	0x80 + mms.X_MMS_EXPIRY, 0x05, 0x81, 0x03, 0x02, 0xa2, 0xff,
 }
 
+// mNotificationIndExpired is an absolute expiry a second after the epoch.
+var mNotificationIndExpired = []byte{
+	// Expire + num of bytes encoding token & expire value + token byte + expire value bytes
+	// 0x88, 3, ...
+	0x80 + mms.X_MMS_EXPIRY, 0x03, mms.ExpiryTokenAbsolute, 0x01, 0x01,
+}
+
 var mNotificationIndContentLocation = []byte{
 	// Content location + "http://localhost:9191/mms\0"
 	0x80 + mms.X_MMS_CONTENT_LOCATION,
@@ -116,6 +123,11 @@ func getMNotificationIndPayload(args mainFlags) []byte {
 		)
 	}
 
+	expire := mNotificationIndExpire
+	if args.Expired {
+		expire = mNotificationIndExpired
+	}
+
 	contentLocation := mNotificationIndContentLocation
 	params := map[string]uint64{}
 	if args.ErrorActivateContext > 0 {
@@ -173,7 +185,7 @@ func getMNotificationIndPayload(args mainFlags) []byte {
 			from,
 			mNotificationIndClass,
 			mNotificationIndSize,
-			mNotificationIndExpire,
+			expire,
 			contentLocation,
 		},
 		nil,
@@ -188,8 +200,8 @@ func push(args mainFlags) error {
 
 	obj := conn.Object(args.EndPoint, "/nuntium")
 
-	info := map[string]*dbus.Variant{"LocalSentTime": &dbus.Variant{"2014-02-05T08:29:55-0300"},
-		"Sender": &dbus.Variant{args.Sender}}
+	info := map[string]dbus.Variant{"LocalSentTime": dbus.MakeVariant("2014-02-05T08:29:55-0300"),
+		"Sender": dbus.MakeVariant(args.Sender)}
 
 	reply, err := obj.Call(pushInterface, pushMethod, getMNotificationIndPayload(args), info)
 	if err != nil || reply.Type == dbus.TypeError {
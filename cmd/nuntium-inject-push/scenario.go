@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/mms"
+)
+
+const (
+	mmsServiceInterface = "org.ofono.mms.Service"
+	messageAddedSignal  = "MessageAdded"
+
+	defaultStepTimeout = 30 * time.Second
+)
+
+// Scenario is a sequence of pushes, read from the JSON file given with
+// --scenario, with the MessageAdded signals nuntium is expected to emit for
+// each of them. For example, to check that a duplicate push is dropped and
+// that an expired message which failed to download cannot be redownloaded:
+//
+//	{"Steps": [
+//		{"Name": "first", "TransactionId": "t1", "Expect": [{"Status": "received"}]},
+//		{"Name": "duplicate", "TransactionId": "t1", "Timeout": "10s"},
+//		{"Name": "expired", "Expired": true, "Errors": {"error-get-proxy": 1},
+//		 "Expect": [{"Error": "x-ubports-nuntium-mms-error-get-proxy", "AllowRedownload": false}]}
+//	]}
+type Scenario struct {
+	Steps []Step
+}
+
+// Step is a push of a scenario.
+type Step struct {
+	Name string
+	// TransactionId, Sender, SenderNotification, Expired and DenialCount
+	// are as the flags of the same names for a single push.
+	TransactionId      string
+	Sender             string
+	SenderNotification string
+	Expired            bool
+	DenialCount        int
+	// Errors maps the debug errors to force, e.g. "error-get-proxy", to
+	// how many times they are to happen.
+	Errors map[string]uint64
+	// Expect lists the MessageAdded signals the push is to cause, in order.
+	// If it is empty, the push must not cause any within Timeout.
+	Expect []Expectation
+	// Timeout is how long to wait for the expected signals, 30s if empty.
+	Timeout string
+
+	timeout time.Duration
+}
+
+// Expectation describes a MessageAdded signal.
+type Expectation struct {
+	// Status is the expected Status property, any if empty.
+	Status string
+	// Error is the expected code of the Error property, empty if the
+	// message is expected to be received without error.
+	Error string
+	// Sender is the expected Sender property, any if empty.
+	Sender string
+	// AllowRedownload is the expected AllowRedownload property of a failed
+	// message, any if nil.
+	AllowRedownload *bool
+}
+
+// messageAdded holds the arguments of a MessageAdded signal.
+type messageAdded struct {
+	Path       dbus.ObjectPath
+	Properties map[string]dbus.Variant
+}
+
+// loadScenario reads and checks the scenario in the JSON file path.
+func loadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scenario Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("cannot parse scenario %s: %w", path, err)
+	}
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", path)
+	}
+	for i := range scenario.Steps {
+		step := &scenario.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		step.timeout = defaultStepTimeout
+		if step.Timeout != "" {
+			if step.timeout, err = time.ParseDuration(step.Timeout); err != nil {
+				return nil, fmt.Errorf("%s: invalid timeout: %w", step.Name, err)
+			}
+		}
+		if _, err := step.flags(mainFlags{}); err != nil {
+			return nil, fmt.Errorf("%s: %w", step.Name, err)
+		}
+	}
+	return &scenario, nil
+}
+
+// flags returns the flags of a single push of step, to the end point and with
+// the m-retrieve.conf of args.
+func (step Step) flags(args mainFlags) (mainFlags, error) {
+	args = mainFlags{EndPoint: args.EndPoint, MRetrieveConf: args.MRetrieveConf}
+	args.TransactionId = step.TransactionId
+	args.Sender = step.Sender
+	args.SenderNotification = step.SenderNotification
+	args.Expired = step.Expired
+	args.DenialCount = step.DenialCount
+	for name, count := range step.Errors {
+		switch name {
+		case mms.DebugErrorActivateContext:
+			args.ErrorActivateContext = count
+		case mms.DebugErrorGetProxy:
+			args.ErrorGetProxy = count
+		case mms.DebugErrorDownloadStorage:
+			args.ErrorDownloadStorage = count
+		case mms.DebugErrorReceiveHandle:
+			args.ErrorReceiveHandle = count
+		case mms.DebugErrorReceiveStorage:
+			args.ErrorReceiveStorage = count
+		case mms.DebugErrorRespondHandle:
+			args.ErrorRespondHandle = count
+		case mms.DebugErrorRespondStorage:
+			args.ErrorRespondStorage = count
+		case mms.DebugErrorTelepathyErrorNotify:
+			args.ErrorTelepathyErrorNotify = count
+		default:
+			return args, fmt.Errorf("unknown debug error %q", name)
+		}
+	}
+	return args, nil
+}
+
+// match returns an error telling how the properties of a MessageAdded signal
+// differ from e, nil if they match.
+func (e Expectation) match(properties map[string]dbus.Variant) error {
+	status, _ := properties["Status"].Value().(string)
+	if e.Status != "" && status != e.Status {
+		return fmt.Errorf("Status is %q, want %q", status, e.Status)
+	}
+	var code string
+	if errorJSON, ok := properties["Error"].Value().(string); ok {
+		var downloadError struct{ Code string }
+		if err := json.Unmarshal([]byte(errorJSON), &downloadError); err != nil {
+			return fmt.Errorf("cannot parse Error %q: %w", errorJSON, err)
+		}
+		code = downloadError.Code
+	}
+	if code != e.Error {
+		return fmt.Errorf("Error code is %q, want %q", code, e.Error)
+	}
+	sender, _ := properties["Sender"].Value().(string)
+	if e.Sender != "" && sender != e.Sender {
+		return fmt.Errorf("Sender is %q, want %q", sender, e.Sender)
+	}
+	allowRedownload, _ := properties["AllowRedownload"].Value().(bool)
+	if e.AllowRedownload != nil && allowRedownload != *e.AllowRedownload {
+		return fmt.Errorf("AllowRedownload is %t, want %t", allowRedownload, *e.AllowRedownload)
+	}
+	return nil
+}
+
+// runScenario runs the steps of scenario, pushing each with push, args as
+// set up by the step, and checking the MessageAdded signals received on
+// signals. It reports on out and returns the number of failed steps.
+func runScenario(scenario *Scenario, args mainFlags, push func(mainFlags) error, signals <-chan messageAdded, out io.Writer) int {
+	failed := 0
+	for _, step := range scenario.Steps {
+		if err := runStep(step, args, push, signals, out); err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", step.Name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "PASS %s\n", step.Name)
+	}
+	fmt.Fprintf(out, "%d of %d steps passed\n", len(scenario.Steps)-failed, len(scenario.Steps))
+	return failed
+}
+
+func runStep(step Step, args mainFlags, push func(mainFlags) error, signals <-chan messageAdded, out io.Writer) error {
+	stepArgs, err := step.flags(args)
+	if err != nil {
+		return err
+	}
+	// Drop signals of earlier steps which came in after they ended.
+	for len(signals) > 0 {
+		<-signals
+	}
+	if err := push(stepArgs); err != nil {
+		return err
+	}
+
+	timeout := time.After(step.timeout)
+	for i, expectation := range step.Expect {
+		select {
+		case signal := <-signals:
+			if err := expectation.match(signal.Properties); err != nil {
+				return fmt.Errorf("signal %d for %s: %w", i+1, signal.Path, err)
+			}
+			fmt.Fprintf(out, "\tMessageAdded %s\n", signal.Path)
+		case <-timeout:
+			return fmt.Errorf("got %d of %d expected signals in %s", i, len(step.Expect), step.timeout)
+		}
+	}
+	if len(step.Expect) == 0 {
+		select {
+		case signal := <-signals:
+			return fmt.Errorf("unexpected MessageAdded %s", signal.Path)
+		case <-timeout:
+		}
+	}
+	return nil
+}
+
+// watchMessageAdded returns the MessageAdded signals nuntium emits on the
+// session bus.
+func watchMessageAdded() (<-chan messageAdded, error) {
+	conn, err := dbus.Connect(dbus.SessionBus)
+	if err != nil {
+		return nil, err
+	}
+	w, err := conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Interface: mmsServiceInterface,
+		Member:    messageAddedSignal,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signals := make(chan messageAdded, 16)
+	go func() {
+		for msg := range w.C {
+			var signal messageAdded
+			if err := msg.Args(&signal.Path, &signal.Properties); err != nil {
+				fmt.Println("Cannot parse MessageAdded:", err)
+				continue
+			}
+			signals <- signal
+		}
+	}()
+	return signals, nil
+}
+
+// scenarioServer serves the m-retrieve.conf of the current step of a
+// scenario as often as it is downloaded, after denying as many downloads as
+// the step asks for.
+type scenarioServer struct {
+	mu       sync.Mutex
+	payload  []byte
+	denials  int
+	override []byte
+}
+
+// set prepares the server for the push set up in args.
+func (s *scenarioServer) set(args mainFlags) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payload = s.override
+	if s.payload == nil {
+		s.payload = getMRetrieveConfPayload(args)
+	}
+	s.denials = args.DenialCount
+}
+
+func (s *scenarioServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	payload, deny := s.payload, s.denials > 0
+	if deny {
+		s.denials--
+	}
+	s.mu.Unlock()
+	if deny {
+		fmt.Println("Serving MMS content denied")
+		http.Error(w, "Intentional denial", http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("Serving MMS content")
+	http.ServeContent(w, r, "mms", time.Time{}, bytes.NewReader(payload))
+}
+
+// scenarioMain runs the scenario file of args and exits with status 1 if
+// any step failed.
+func scenarioMain(args mainFlags) {
+	scenario, err := loadScenario(args.Scenario)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	server := &scenarioServer{}
+	if args.MRetrieveConf != "" {
+		if server.override, err = ioutil.ReadFile(args.MRetrieveConf); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/mms", server)
+	go http.ListenAndServe("localhost:9191", mux)
+
+	signals, err := watchMessageAdded()
+	if err != nil {
+		fmt.Println("Cannot watch MessageAdded signals on the session bus:", err)
+		os.Exit(1)
+	}
+	pushStep := func(stepArgs mainFlags) error {
+		server.set(stepArgs)
+		return push(stepArgs)
+	}
+	if runScenario(scenario, args, pushStep, signals, os.Stdout) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/mms"
+)
+
+const testScenario = `{"Steps": [
+	{"Name": "first", "TransactionId": "t1", "Expect": [{"Status": "received", "Sender": "+12345"}]},
+	{"Name": "duplicate", "TransactionId": "t1", "Timeout": "10ms"},
+	{"Name": "expired", "Expired": true, "Errors": {"error-get-proxy": 2},
+	 "Expect": [{"Status": "received", "Error": "x-ubports-nuntium-mms-error-get-proxy", "AllowRedownload": false}], "Timeout": "10ms"}
+]}`
+
+func writeScenario(t *testing.T, scenario string) string {
+	dir, err := ioutil.TempDir("", "nuntium-inject-push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "scenario.json")
+	if err := ioutil.WriteFile(path, []byte(scenario), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadScenario(t *testing.T) {
+	path := writeScenario(t, testScenario)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	scenario, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenario.Steps) != 3 {
+		t.Fatalf("loadScenario() returned %d steps, want 3", len(scenario.Steps))
+	}
+	if scenario.Steps[0].timeout != defaultStepTimeout || scenario.Steps[1].timeout != 10*time.Millisecond {
+		t.Errorf("step timeouts are %s and %s", scenario.Steps[0].timeout, scenario.Steps[1].timeout)
+	}
+
+	args, err := scenario.Steps[2].flags(mainFlags{EndPoint: ":1.42", ErrorReceiveHandle: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.EndPoint != ":1.42" || !args.Expired || args.ErrorGetProxy != 2 || args.ErrorReceiveHandle != 0 {
+		t.Errorf("flags() = %+v", args)
+	}
+
+	for _, invalid := range []string{
+		`{"Steps": []}`,
+		`{"Steps": [{"Timeout": "soon"}]}`,
+		`{"Steps": [{"Errors": {"error-unknown": 1}}]}`,
+		`{"Steps": `,
+	} {
+		path := writeScenario(t, invalid)
+		defer os.RemoveAll(filepath.Dir(path))
+		if _, err := loadScenario(path); err == nil {
+			t.Errorf("loadScenario() of %s succeeded", invalid)
+		}
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	path := writeScenario(t, testScenario)
+	defer os.RemoveAll(filepath.Dir(path))
+	scenario, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan messageAdded, 16)
+	var pushed []mainFlags
+	push := func(args mainFlags) error {
+		pushed = append(pushed, args)
+		// Received once, dropped as a duplicate, failed as expired.
+		switch len(pushed) {
+		case 1:
+			signals <- messageAdded{"/org/ofono/mms/1/a", map[string]dbus.Variant{
+				"Status": dbus.MakeVariant("received"),
+				"Sender": dbus.MakeVariant("+12345"),
+			}}
+		case 3:
+			signals <- messageAdded{"/org/ofono/mms/1/b", map[string]dbus.Variant{
+				"Status":          dbus.MakeVariant("received"),
+				"Error":           dbus.MakeVariant(`{"Code":"x-ubports-nuntium-mms-error-download-content"}`),
+				"AllowRedownload": dbus.MakeVariant(false),
+			}}
+		}
+		return nil
+	}
+	var out bytes.Buffer
+	if failed := runScenario(scenario, mainFlags{}, push, signals, &out); failed != 1 {
+		t.Errorf("runScenario() failed %d steps, want 1:\n%s", failed, out.String())
+	}
+	for _, want := range []string{"PASS first\n", "PASS duplicate\n", `FAIL expired: signal 1 for /org/ofono/mms/1/b: Error code is "x-ubports-nuntium-mms-error-download-content", want "x-ubports-nuntium-mms-error-get-proxy"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("runScenario() output %q does not contain %q", out.String(), want)
+		}
+	}
+	if len(pushed) != 3 || pushed[1].TransactionId != "t1" || !pushed[2].Expired {
+		t.Errorf("pushed %+v", pushed)
+	}
+}
+
+func TestExpiredPayload(t *testing.T) {
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	if err := mms.NewDecoder(getMNotificationIndPayload(mainFlags{Expired: true})).Decode(mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if !mNotificationInd.Expired() {
+		t.Errorf("notification expiring at %s has not expired", mNotificationInd.Expire())
+	}
+}
+
+func TestExpectationMatch(t *testing.T) {
+	allow, deny := true, false
+	properties := map[string]dbus.Variant{
+		"Status":          dbus.MakeVariant("received"),
+		"Error":           dbus.MakeVariant(`{"Code":"x-ubports-nuntium-mms-error-get-proxy","Message":"no proxy"}`),
+		"AllowRedownload": dbus.MakeVariant(true),
+	}
+	testCases := []struct {
+		expectation Expectation
+		match       bool
+	}{
+		{Expectation{Error: "x-ubports-nuntium-mms-error-get-proxy"}, true},
+		{Expectation{Status: "received", Error: "x-ubports-nuntium-mms-error-get-proxy", AllowRedownload: &allow}, true},
+		{Expectation{Error: "x-ubports-nuntium-mms-error-get-proxy", AllowRedownload: &deny}, false},
+		{Expectation{Status: "received"}, false},
+		{Expectation{Error: "x-ubports-nuntium-mms-error-get-proxy", Sender: "+12345"}, false},
+	}
+	for _, tc := range testCases {
+		if err := tc.expectation.match(properties); (err == nil) != tc.match {
+			t.Errorf("match() of %+v = %v, want match %t", tc.expectation, err, tc.match)
+		}
+	}
+}
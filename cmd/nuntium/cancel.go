@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ubports/nuntium/metrics"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// cancelSend cancels the outgoing message uuid unless it was sent already. An
+// upload in progress is aborted, a pending or parked send is dropped.
+func (mediator *Mediator) cancelSend(uuid string) {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil {
+		logger.Errorf("Cannot cancel message %s: %v", uuid, err)
+		return
+	}
+	if mmsState.State != storage.DRAFT && mmsState.State != storage.SEND_PENDING && mmsState.State != storage.SENDING {
+		logger.Errorf("Cannot cancel message %s in %s state", uuid, mmsState.State)
+		return
+	}
+	if _, err := mediator.storage.UpdateCancelled(uuid); err != nil {
+		logger.Errorf("Cannot cancel message %s: %v", uuid, err)
+		return
+	}
+	mediator.journal(uuid, "cancel", nil)
+
+	if mediator.cancelTransaction(uuid) {
+		logger.Infof("Cancelling upload of message %s", uuid)
+		return
+	}
+
+	logger.Infof("Cancelled message %s", uuid)
+	if mSendReqFile, err := mediator.storage.GetSendFile(uuid); err == nil {
+		os.Remove(mSendReqFile)
+	}
+	if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.CANCELLED); err != nil {
+		logger.Error(err)
+	}
+	mediator.telepathyService.MessageDestroy(uuid)
+}
+
+// sendCancelled returns true if the user cancelled the outgoing message uuid.
+func (mediator *Mediator) sendCancelled(uuid string) bool {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	return err == nil && mmsState.State == storage.CANCELLED
+}
+
+// handleMCancelReq decodes the m-cancel.req in data, with which the MMS center
+// withdraws a message it notified, removes that message of modemId if it was
+// not downloaded yet and confirms the cancel to the MMS center.
+func (mediator *Mediator) handleMCancelReq(data []byte, modemId string) {
+	mCancelReq := mms.NewMCancelReq()
+	dec := mms.NewDecoder(data)
+	if err := dec.Decode(mCancelReq); err != nil {
+		logger.Error("Unable to decode m-cancel.req:  ", err, " with log ", dec.GetLog())
+		metrics.IncCode(metrics.DecodeFailures, "m-cancel.req")
+		if mCancelReq.TransactionId != "" {
+			mediator.confirmCancel(mCancelReq, mms.CancelStatusCorrupted)
+		}
+		return
+	}
+
+	if uuid, ok := mediator.findNotification(modemId, mCancelReq.CancelId); ok {
+		logger.Infof("Message %s was cancelled by the MMS center", uuid)
+		mediator.removeCancelled(uuid)
+	} else {
+		// Downloaded messages are kept, the user got them already.
+		logger.Infof("Ignoring m-cancel.req for %s, no such message is waiting for download", mCancelReq.CancelId)
+	}
+	mediator.confirmCancel(mCancelReq, mms.CancelStatusReceived)
+}
+
+// findNotification returns the message of modemId waiting for download which
+// cancelId identifies. The X-Mms-Cancel-ID is the X-Mms-Transaction-Id of the
+// notification for some MMS centers, its X-Mms-Content-Location for others.
+func (mediator *Mediator) findNotification(modemId, cancelId string) (string, bool) {
+	if cancelId == "" {
+		return "", false
+	}
+	for _, uuid := range mediator.storage.GetModemUUIDs(modemId, storage.NOTIFICATION) {
+		mNotificationInd := mediator.storage.GetMNotificationInd(uuid)
+		if mNotificationInd == nil {
+			continue
+		}
+		if mNotificationInd.TransactionId == cancelId || mNotificationInd.ContentLocation == cancelId {
+			return uuid, true
+		}
+	}
+	return "", false
+}
+
+// removeCancelled removes the message uuid, which the MMS center cancelled
+// before it was downloaded, and tells telepathy it is gone.
+func (mediator *Mediator) removeCancelled(uuid string) {
+	if mediator.cancelTransaction(uuid) {
+		logger.Infof("Cancelled download of message %s", uuid)
+	}
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil {
+		logger.Errorf("Cannot remove cancelled message %s: %v", uuid, err)
+		return
+	}
+	if mmsState.MNotificationInd != nil {
+		mediator.unrespondedTransactions.remove(mmsState.MNotificationInd.TransactionId)
+	}
+	service := mediator.telepathyService
+	if service == nil {
+		if err := mediator.storage.Destroy(uuid); err != nil {
+			logger.Errorf("Error destroying cancelled message %s: %v", uuid, err)
+		}
+		return
+	}
+	if err := service.MessageRemoved(service.GenMessagePath(uuid)); err != nil {
+		// Telepathy has no handler for it, e.g. its download failed.
+		if err := mediator.storage.Destroy(uuid); err != nil {
+			logger.Errorf("Error destroying cancelled message %s: %v", uuid, err)
+		}
+		if err := service.SingnalMessageRemoved(service.GenMessagePath(uuid)); err != nil {
+			logger.Errorf("Error sending signal that message was removed: %v", err)
+		}
+	}
+}
+
+// confirmCancel answers mCancelReq with a m-cancel.conf with status.
+func (mediator *Mediator) confirmCancel(mCancelReq *mms.MCancelReq, status byte) {
+	if err := mediator.sendMCancelConf(mCancelReq.NewMCancelConf(status)); err != nil {
+		logger.Errorf("Cannot send m-cancel.conf for %s: %v", mCancelReq.CancelId, err)
+	}
+}
+
+func (mediator *Mediator) sendMCancelConf(mCancelConf *mms.MCancelConf) error {
+	defer mediator.beginTransaction(false)()
+
+	if !mmsEnabled() {
+		return errors.New("MMS is disabled")
+	}
+	if !mediator.online() {
+		return errOffline
+	}
+
+	mmsContext, deactivateMMSContext, err := mediator.activateMMSContext()
+	if err != nil {
+		return fmt.Errorf("cannot activate ofono context: %w", err)
+	}
+	if deactivateMMSContext != nil {
+		defer deactivateMMSContext()
+	}
+
+	f, err := ioutil.TempFile("", "nuntium-pdu-")
+	if err != nil {
+		return err
+	}
+	if err := mms.NewEncoder(f).Encode(mCancelConf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot encode m-cancel.conf: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return mediator.sendPDUFile("", "m-cancel.conf", f.Name(), &mmsContext)
+}
+
+// handleCancelDeliveryRequest asks the MMS center to cancel the delivery of
+// the sent message of a CancelDelivery call and answers it.
+func (mediator *Mediator) handleCancelDeliveryRequest(request *telepathy.CancelDeliveryRequest) {
+	service := mediator.telepathyService
+	if service == nil {
+		return
+	}
+	err := mediator.cancelDelivery(request)
+	if err != nil {
+		logger.Errorf("Cannot cancel delivery of %s: %v", request.UUID, err)
+	} else {
+		logger.Infof("Delivery of %s was cancelled", request.UUID)
+	}
+	if err := service.ReplyCancelDelivery(request, err); err != nil {
+		logger.Error("Could not send reply: ", err)
+	}
+}
+
+// cancelDelivery sends the m-cancel.req of request. MMS centers which do not
+// support cancelling fail the upload or answer with another message than a
+// m-cancel.conf, which does not decode.
+func (mediator *Mediator) cancelDelivery(request *telepathy.CancelDeliveryRequest) error {
+	mCancelReq := mms.NewMCancelReqFor(request.MessageId)
+	mCancelConf := mms.NewMCancelConf()
+	if err := mediator.exchangePDU(mCancelReq, mCancelConf); err != nil {
+		return err
+	}
+	if mCancelConf.CancelStatus != mms.CancelStatusReceived {
+		return fmt.Errorf("the MMS center rejected the cancel with status %#x", mCancelConf.CancelStatus)
+	}
+	mediator.journal(request.UUID, "cancel-delivery", nil)
+	return nil
+}
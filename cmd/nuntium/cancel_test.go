@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestFindNotification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.TransactionId = "t1"
+	mNotificationInd.ContentLocation = "http://mms/1"
+	if _, err := store.Create("modem", mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cancelId := range []string{"t1", "http://mms/1"} {
+		if uuid, ok := mediator.findNotification("modem", cancelId); !ok || uuid != mNotificationInd.UUID {
+			t.Errorf("findNotification(%q) = %q, %v, want %q, true", cancelId, uuid, ok, mNotificationInd.UUID)
+		}
+	}
+	for _, tc := range []struct{ modemId, cancelId string }{
+		{"modem", ""},
+		{"modem", "t2"},
+		{"other", "t1"},
+	} {
+		if uuid, ok := mediator.findNotification(tc.modemId, tc.cancelId); ok {
+			t.Errorf("findNotification(%q, %q) = %q, want none", tc.modemId, tc.cancelId, uuid)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// configureMMSContext writes the settings of a ConfigureMMSContext call to
+// the MMS context of the modem, makes it the preferred context and restarts
+// the downloads which did not succeed, as they may have failed because of the
+// settings.
+func (mediator *Mediator) configureMMSContext(configuration *telepathy.ContextConfiguration) {
+	service := mediator.telepathyService
+	if service == nil {
+		return
+	}
+	// No transaction may use the context while it changes, nor may it be
+	// kept active.
+	mediator.contextLock.Lock()
+	mediator.mmsContext.flush()
+	preferredContext, _ := service.GetPreferredContext()
+	contextPath, err := mediator.modem.ConfigureMMSContext(preferredContext, ofono.ContextSettings{
+		AccessPointName: configuration.AccessPointName,
+		MessageCenter:   configuration.MessageCenter,
+		MessageProxy:    configuration.MessageProxy(),
+	})
+	mediator.contextLock.Unlock()
+	if err != nil {
+		logger.Errorf("Cannot configure the MMS context: %v", err)
+	} else if contextPath != preferredContext {
+		if err := service.SetPreferredContext(contextPath); err != nil {
+			logger.Errorf("Cannot make %s the preferred context: %v", contextPath, err)
+		}
+	}
+	if err := service.ReplyConfigureMMSContext(configuration, err); err != nil {
+		logger.Error("Could not send reply: ", err)
+	}
+	if err == nil {
+		mediator.retryDownloads(service)
+	}
+}
+
+// retryDownloads restarts the downloads of the messages of the modem which
+// failed, with one of codes if any are given, unless they are in progress,
+// expired, quarantined or deferred. It is called when the context settings
+// change or the network comes back.
+func (mediator *Mediator) retryDownloads(service *telepathy.MMSService, codes ...string) {
+	for _, uuid := range mediator.storage.GetModemUUIDs(mediator.modem.Identity(), storage.NOTIFICATION) {
+		mmsState, err := mediator.storage.GetMMSState(uuid)
+		if err != nil || mmsState.State != storage.NOTIFICATION || mmsState.MNotificationInd == nil || mmsState.Quarantined {
+			continue
+		}
+		// A download whose failure telepathy was not told about may still
+		// be waiting for the context.
+		if !mmsState.TelepathyErrorNotified {
+			continue
+		}
+		if len(codes) > 0 && !hasCode(codes, mediator.lastErrorCode(uuid)) {
+			continue
+		}
+		if mmsState.MNotificationInd.Expired() || mediator.inTransaction(uuid) {
+			continue
+		}
+		// Whether the download would be deferred if it was pushed now, a
+		// redownload never is.
+		mNotificationInd := *mmsState.MNotificationInd
+		mNotificationInd.RedownloadOfUUID = ""
+		if reason, _ := mediator.deferReason(&mNotificationInd); reason != "" {
+			continue
+		}
+		logger.Infof("Retrying the download of %s", uuid)
+		if err := service.RedownloadMessage(uuid); err != nil {
+			logger.Errorf("Cannot retry the download of %s: %v", uuid, err)
+		}
+	}
+}
+
+// lastErrorCode returns the code of the last error journaled for the message
+// uuid, empty if there is none.
+func (mediator *Mediator) lastErrorCode(uuid string) string {
+	journal, err := mediator.storage.GetJournal(uuid)
+	if err != nil {
+		return ""
+	}
+	for i := len(journal) - 1; i >= 0; i-- {
+		if journal[i].Event == "error" {
+			return journal[i].Details["Code"]
+		}
+	}
+	return ""
+}
+
+func hasCode(codes []string, code string) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedContext is the MMS context shared by the transactions in progress. It
+// is activated by the first transaction to need it and deactivated once the
+// last one is done with it and the keep-alive time passed without another
+// transaction, so bursts of transactions share one activation.
+type sharedContext struct {
+	lock       sync.Mutex
+	refs       int
+	active     bool
+	context    ofono.OfonoContext
+	deactivate func()
+	idle       *time.Timer // deactivates the context once the keep-alive time passed
+	// activate activates the context, returning a function deactivating it.
+	activate func() (ofono.OfonoContext, func(), error)
+	// keepAlive returns the time the context stays active after the last
+	// transaction, nil deactivates it right away.
+	keepAlive func() time.Duration
+}
+
+// acquire returns the context, activating it unless it is active already,
+// and a function to be called once the transaction is done with it.
+// Transactions acquiring it during its activation wait for it to finish.
+func (shared *sharedContext) acquire() (ofono.OfonoContext, func(), error) {
+	shared.lock.Lock()
+	defer shared.lock.Unlock()
+
+	shared.stopIdle()
+	if !shared.active {
+		context, deactivate, err := shared.activate()
+		if err != nil {
+			return ofono.OfonoContext{}, nil, err
+		}
+		shared.context, shared.deactivate, shared.active = context, deactivate, true
+	}
+	shared.refs++
+	var once sync.Once
+	return shared.context, func() { once.Do(shared.release) }, nil
+}
+
+func (shared *sharedContext) release() {
+	shared.lock.Lock()
+	defer shared.lock.Unlock()
+
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+	var keepAlive time.Duration
+	if shared.keepAlive != nil {
+		keepAlive = shared.keepAlive()
+	}
+	if keepAlive <= 0 {
+		shared.deactivateLocked()
+		return
+	}
+	var idle *time.Timer
+	idle = time.AfterFunc(keepAlive, func() {
+		shared.lock.Lock()
+		defer shared.lock.Unlock()
+		// A transaction may have taken the context in the meantime.
+		if shared.idle == idle {
+			shared.idle = nil
+			shared.deactivateLocked()
+		}
+	})
+	shared.idle = idle
+}
+
+// flush deactivates the context right away if no transaction uses it, e.g.
+// before its settings change.
+func (shared *sharedContext) flush() {
+	shared.lock.Lock()
+	defer shared.lock.Unlock()
+
+	shared.stopIdle()
+	if shared.refs == 0 {
+		shared.deactivateLocked()
+	}
+}
+
+func (shared *sharedContext) stopIdle() {
+	if shared.idle != nil {
+		shared.idle.Stop()
+		shared.idle = nil
+	}
+}
+
+func (shared *sharedContext) deactivateLocked() {
+	if !shared.active {
+		return
+	}
+	if shared.deactivate != nil {
+		shared.deactivate()
+	}
+	shared.context, shared.deactivate, shared.active = ofono.OfonoContext{}, nil, false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestLastErrorCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	if _, err := store.Create("modem", mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	uuid := mNotificationInd.UUID
+	if code := mediator.lastErrorCode(uuid); code != "" {
+		t.Errorf("lastErrorCode() without errors = %q", code)
+	}
+	mediator.journal(uuid, "error", map[string]string{"Code": ErrorActivateContext})
+	mediator.journal(uuid, "context", nil)
+	mediator.journal(uuid, "error", map[string]string{"Code": ErrorDownloadContent})
+	mediator.journal(uuid, "resend", nil)
+	if code := mediator.lastErrorCode(uuid); code != ErrorDownloadContent {
+		t.Errorf("lastErrorCode() = %q, want %q", code, ErrorDownloadContent)
+	}
+	if !hasCode([]string{ErrorActivateContext, ErrorDownloadContent}, ErrorDownloadContent) || hasCode([]string{ErrorActivateContext}, "") {
+		t.Error("hasCode() does not match the codes")
+	}
+}
+
+func TestSharedContext(t *testing.T) {
+	var activations, deactivations int
+	shared := sharedContext{activate: func() (ofono.OfonoContext, func(), error) {
+		activations++
+		return ofono.OfonoContext{ObjectPath: "/ril_0/context2"}, func() { deactivations++ }, nil
+	}}
+
+	first, releaseFirst, err := shared.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, releaseSecond, err := shared.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ObjectPath != "/ril_0/context2" || second.ObjectPath != first.ObjectPath {
+		t.Errorf("acquire() = %s, %s", first.ObjectPath, second.ObjectPath)
+	}
+	if activations != 1 {
+		t.Errorf("expected a single activation, got %d", activations)
+	}
+
+	releaseFirst()
+	releaseFirst()
+	if deactivations != 0 {
+		t.Error("expected the context to stay active while in use")
+	}
+	releaseSecond()
+	if deactivations != 1 {
+		t.Errorf("expected a deactivation once released, got %d", deactivations)
+	}
+
+	if _, release, err := shared.acquire(); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+	if activations != 2 || deactivations != 2 {
+		t.Errorf("expected the context to be activated again, got %d activations, %d deactivations", activations, deactivations)
+	}
+}
+
+func TestSharedContextActivationError(t *testing.T) {
+	fail := true
+	shared := sharedContext{activate: func() (ofono.OfonoContext, func(), error) {
+		if fail {
+			return ofono.OfonoContext{}, nil, errors.New("activation failed")
+		}
+		return ofono.OfonoContext{ObjectPath: "/ril_0/context2"}, func() {}, nil
+	}}
+
+	if _, _, err := shared.acquire(); err == nil {
+		t.Fatal("expected the activation error")
+	}
+	fail = false
+	if _, release, err := shared.acquire(); err != nil {
+		t.Fatalf("expected the activation to be retried, got %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestSharedContextKeepAlive(t *testing.T) {
+	deactivated := make(chan struct{}, 2)
+	activations := 0
+	shared := sharedContext{
+		activate: func() (ofono.OfonoContext, func(), error) {
+			activations++
+			return ofono.OfonoContext{ObjectPath: "/ril_0/context2"}, func() { deactivated <- struct{}{} }, nil
+		},
+		keepAlive: func() time.Duration { return 50 * time.Millisecond },
+	}
+
+	_, release, err := shared.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	// A transaction within the keep-alive time reuses the context.
+	_, release, err = shared.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activations != 1 {
+		t.Errorf("expected the context to be kept active, got %d activations", activations)
+	}
+	release()
+
+	select {
+	case <-deactivated:
+	case <-time.After(time.Second):
+		t.Fatal("expected the context to be deactivated after the keep-alive time")
+	}
+
+	if _, release, err = shared.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	shared.flush()
+	select {
+	case <-deactivated:
+	default:
+		t.Error("expected flush to deactivate the idle context")
+	}
+	if activations != 2 {
+		t.Errorf("expected 2 activations, got %d", activations)
+	}
+}
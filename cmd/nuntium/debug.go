@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// debugState is what the Debug interface dumps of a mediator.
+type debugState struct {
+	Modem    dbus.ObjectPath
+	Identity string
+	Online   bool
+	// ActiveContext is the MMS context activated for a transaction.
+	ActiveContext dbus.ObjectPath `json:",omitempty"`
+	// UnrespondedTransactions maps the transaction ids of messages not
+	// yet acknowledged to the MMS center to their UUIDs.
+	UnrespondedTransactions map[string]string
+	Transactions            []debugTransaction
+	// Parked is the number of transactions waiting for the modem to be
+	// online.
+	Parked   int
+	Messages []debugMessage
+}
+
+// debugTransaction is a download or upload in progress.
+type debugTransaction struct {
+	UUID    string
+	Kind    string
+	Started time.Time
+}
+
+// debugMessage is the stored state of a message, without its addresses
+// and content.
+type debugMessage struct {
+	UUID          string
+	State         string
+	TransactionId string `json:",omitempty"`
+	SendAttempts  int    `json:",omitempty"`
+	Quarantined   bool   `json:",omitempty"`
+	Spam          bool   `json:",omitempty"`
+	Error         string `json:",omitempty"`
+}
+
+// setActiveContext records the MMS context activated for a transaction, an
+// empty path once it is deactivated.
+func (mediator *Mediator) setActiveContext(context dbus.ObjectPath) {
+	mediator.activeContextLock.Lock()
+	defer mediator.activeContextLock.Unlock()
+	mediator.activeContext = context
+}
+
+// debugState returns the state of the mediator and its stored messages.
+func (mediator *Mediator) debugState() debugState {
+	state := debugState{
+		Modem:                   mediator.modem.Modem,
+		Identity:                mediator.modem.Identity(),
+		Online:                  mediator.modem.Online(),
+		UnrespondedTransactions: mediator.unrespondedTransactions.snapshot(mediator.modem.Identity()),
+	}
+	mediator.activeContextLock.Lock()
+	state.ActiveContext = mediator.activeContext
+	mediator.activeContextLock.Unlock()
+
+	mediator.transactionsLock.Lock()
+	for uuid, t := range mediator.transactions {
+		state.Transactions = append(state.Transactions, debugTransaction{UUID: uuid, Kind: t.kind, Started: t.started})
+	}
+	mediator.transactionsLock.Unlock()
+	sort.Slice(state.Transactions, func(i, j int) bool { return state.Transactions[i].Started.Before(state.Transactions[j].Started) })
+
+	mediator.parkedLock.Lock()
+	state.Parked = len(mediator.parked)
+	mediator.parkedLock.Unlock()
+
+	if state.Identity != "" {
+		for _, uuid := range mediator.storage.GetModemUUIDs(state.Identity) {
+			message := debugMessage{UUID: uuid}
+			if mmsState, err := mediator.storage.GetMMSState(uuid); err == nil {
+				message.State = mmsState.State
+				message.TransactionId = mmsState.Id
+				message.SendAttempts = mmsState.SendAttempts
+				message.Quarantined = mmsState.Quarantined
+				message.Spam = mmsState.Spam
+			} else {
+				message.Error = err.Error()
+			}
+			state.Messages = append(state.Messages, message)
+		}
+	}
+	return state
+}
+
+// debugStates returns the state of the mediators of all modems.
+func (router *modemRouter) debugStates() interface{} {
+	router.lock.Lock()
+	mediators := make([]*Mediator, 0, len(router.mediators))
+	for _, mediator := range router.mediators {
+		mediators = append(mediators, mediator)
+	}
+	router.lock.Unlock()
+	sort.Slice(mediators, func(i, j int) bool { return mediators[i].modem.Modem < mediators[j].modem.Modem })
+
+	states := make([]debugState, 0, len(mediators))
+	for _, mediator := range mediators {
+		states = append(states, mediator.debugState())
+	}
+	return states
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestDebugState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	if _, err := store.Create("", &mms.MNotificationInd{UUID: "message", TransactionId: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+	mediator.unrespondedTransactions.set("t1", "message")
+	mediator.setActiveContext("/ril_0/context2")
+	_, done := mediator.startTransaction("download", "message", "replaced")
+
+	state := mediator.debugState()
+	if state.Modem != "/ril_0" || state.ActiveContext != "/ril_0/context2" || state.UnrespondedTransactions["t1"] != "message" {
+		t.Errorf("debugState() = %+v", state)
+	}
+	if len(state.Transactions) != 2 || state.Transactions[0].Kind != "download" {
+		t.Errorf("transactions = %+v", state.Transactions)
+	}
+	if _, err := json.Marshal(state); err != nil {
+		t.Error(err)
+	}
+
+	done()
+	mediator.setActiveContext("")
+	if state := mediator.debugState(); len(state.Transactions) != 0 || state.ActiveContext != "" {
+		t.Errorf("debugState() after the transaction = %+v", state)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// expiryTolerance is how far apart the expiries of the pushes of one message
+// may be. A relative expiry is resolved against the time of the push, so it
+// moves with the time the operator pushes the message again.
+const expiryTolerance = time.Minute
+
+// notificationDigest returns the hash of the content of mNotificationInd
+// but its expiry, which is the same for the pushes of one message even if
+// the operator pushes it again with another transaction id.
+func notificationDigest(mNotificationInd *mms.MNotificationInd) string {
+	hash := sha256.New()
+	for _, field := range []string{
+		mNotificationInd.ContentLocation,
+		mNotificationInd.From,
+		strconv.FormatUint(mNotificationInd.Size, 10),
+	} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// seenNotification is the first push of a message within the duplicate
+// window.
+type seenNotification struct {
+	uuid          string
+	transactionId string
+	expiry        time.Time
+	received      time.Time
+}
+
+// duplicateTable remembers the content of the notifications pushed lately,
+// to recognize the ones some operators push again with another transaction
+// id. It is safe for concurrent use.
+type duplicateTable struct {
+	lock sync.Mutex
+	seen map[string][]seenNotification // digest: first pushes
+}
+
+func newDuplicateTable() *duplicateTable {
+	return &duplicateTable{seen: make(map[string][]seenNotification)}
+}
+
+// check returns the UUID of the message mNotificationInd repeats with
+// another transaction id if it was pushed within window before now,
+// otherwise it remembers mNotificationInd and returns false. A push repeats
+// a message if their expiries are within expiryTolerance. Notifications
+// without content location are never duplicates, a window of 0 disables
+// the check.
+func (table *duplicateTable) check(mNotificationInd *mms.MNotificationInd, now time.Time, window time.Duration) (string, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	for digest, seens := range table.seen {
+		var recent []seenNotification
+		for _, seen := range seens {
+			if now.Sub(seen.received) < window {
+				recent = append(recent, seen)
+			}
+		}
+		if len(recent) == 0 {
+			delete(table.seen, digest)
+		} else {
+			table.seen[digest] = recent
+		}
+	}
+	if window <= 0 || mNotificationInd.ContentLocation == "" {
+		return "", false
+	}
+	digest := notificationDigest(mNotificationInd)
+	for _, seen := range table.seen[digest] {
+		if difference := mNotificationInd.Expiry.Sub(seen.expiry); difference > expiryTolerance || difference < -expiryTolerance {
+			continue
+		}
+		// Repeats of the same transaction are handled with the
+		// unresponded transactions.
+		if seen.transactionId != mNotificationInd.TransactionId {
+			return seen.uuid, true
+		}
+		return "", false
+	}
+	table.seen[digest] = append(table.seen[digest], seenNotification{
+		uuid:          mNotificationInd.UUID,
+		transactionId: mNotificationInd.TransactionId,
+		expiry:        mNotificationInd.Expiry,
+		received:      now,
+	})
+	return "", false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func TestDuplicateTableCheck(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 10, 0, time.UTC)
+	notification := func(uuid, transactionId string, expiry time.Time) *mms.MNotificationInd {
+		return &mms.MNotificationInd{
+			UUID:            uuid,
+			TransactionId:   transactionId,
+			ContentLocation: "http://mmsc/1",
+			From:            "+1",
+			Size:            1024,
+			Expiry:          expiry,
+		}
+	}
+	table := newDuplicateTable()
+	window := 10 * time.Minute
+
+	if _, ok := table.check(notification("first", "t1", now.Add(time.Hour)), now, window); ok {
+		t.Fatal("first push is a duplicate")
+	}
+	// The relative expiry of the repeated push is a few seconds later.
+	if uuid, ok := table.check(notification("second", "t2", now.Add(time.Hour+5*time.Second)), now.Add(5*time.Second), window); !ok || uuid != "first" {
+		t.Errorf("repeated push with another transaction = %q, %v", uuid, ok)
+	}
+	if _, ok := table.check(notification("third", "t1", now.Add(time.Hour)), now.Add(time.Minute), window); ok {
+		t.Error("repeated push with the same transaction is a duplicate")
+	}
+	// The expiries straddle the half minute, rounding them to the minute
+	// would tell them apart.
+	if uuid, ok := table.check(notification("straddling", "t6", now.Add(time.Hour+25*time.Second)), now.Add(25*time.Second), window); !ok || uuid != "first" {
+		t.Errorf("repeated push straddling the half minute = %q, %v", uuid, ok)
+	}
+	if _, ok := table.check(notification("later expiry", "t7", now.Add(2*time.Hour)), now, window); ok {
+		t.Error("push with another expiry is a duplicate")
+	}
+	other := notification("other", "t3", now.Add(time.Hour))
+	other.Size = 2048
+	if _, ok := table.check(other, now, window); ok {
+		t.Error("push of another message is a duplicate")
+	}
+	if _, ok := table.check(notification("late", "t4", now.Add(time.Hour)), now.Add(window), window); ok {
+		t.Error("push after the window is a duplicate")
+	}
+	if _, ok := table.check(notification("disabled", "t5", now.Add(time.Hour)), now.Add(window), 0); ok {
+		t.Error("push is a duplicate with the check disabled")
+	}
+}
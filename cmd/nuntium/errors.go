@@ -1,13 +1,74 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ubports/nuntium/i18n"
+	"github.com/ubports/nuntium/mms"
+)
+
 const (
-	ErrorActivateContext = "x-ubports-nuntium-mms-error-activate-context"
-	ErrorGetProxy        = "x-ubports-nuntium-mms-error-get-proxy"
-	ErrorDownloadContent = "x-ubports-nuntium-mms-error-download-content"
-	ErrorStorage         = "x-ubports-nuntium-mms-error-storage"
-	ErrorForward         = "x-ubports-nuntium-mms-error-forward"
+	ErrorActivateContext   = "x-ubports-nuntium-mms-error-activate-context"
+	ErrorGetProxy          = "x-ubports-nuntium-mms-error-get-proxy"
+	ErrorDownloadContent   = "x-ubports-nuntium-mms-error-download-content"
+	ErrorStorage           = "x-ubports-nuntium-mms-error-storage"
+	ErrorForward           = "x-ubports-nuntium-mms-error-forward"
+	ErrorDeferred          = "x-ubports-nuntium-mms-error-deferred"
+	ErrorDeferredSize      = "x-ubports-nuntium-mms-error-deferred-size"
+	ErrorWaiting           = "x-ubports-nuntium-mms-error-waiting-for-network"
+	ErrorTooLarge          = "x-ubports-nuntium-mms-error-too-large"
+	ErrorTooManyRecipients = "x-ubports-nuntium-mms-error-too-many-recipients"
+	ErrorAddressesTooLong  = "x-ubports-nuntium-mms-error-addresses-too-long"
+	ErrorExpired           = "x-ubports-nuntium-mms-error-expired"
+	ErrorServiceDenied     = "x-ubports-nuntium-mms-error-service-denied"
+	ErrorUnsupported       = "x-ubports-nuntium-mms-error-content-unsupported"
+	ErrorRetrieve          = "x-ubports-nuntium-mms-error-retrieve"
+	ErrorUnresolved        = "x-ubports-nuntium-mms-error-address-unresolved"
+	ErrorNotAccepted       = "x-ubports-nuntium-mms-error-not-accepted"
+	ErrorNetworkProblem    = "x-ubports-nuntium-mms-error-network-problem"
+	ErrorSend              = "x-ubports-nuntium-mms-error-send"
+	ErrorGatewayPage       = "x-ubports-nuntium-mms-error-gateway-page"
+	ErrorInvalidContent    = "x-ubports-nuntium-mms-error-invalid-content"
+	ErrorTLS               = "x-ubports-nuntium-mms-error-tls"
+	ErrorRedirectLoop      = "x-ubports-nuntium-mms-error-redirect-loop"
 )
 
+// The messages shown to users for each error code, translations are looked
+// up by these in the gettext catalogs.
+func init() {
+	i18n.Register(ErrorActivateContext, "The mobile data connection for MMS could not be activated")
+	i18n.Register(ErrorGetProxy, "The MMS proxy settings could not be read")
+	i18n.Register(ErrorDownloadContent, "The message could not be downloaded")
+	i18n.Register(ErrorStorage, "The message could not be saved")
+	i18n.Register(ErrorForward, "The message could not be displayed")
+	i18n.Register(ErrorDeferred, "Tap to download")
+	// TRANSLATORS: %s is the size of the message, e.g. 3.2MB
+	i18n.Register(ErrorDeferredSize, "Tap to download (%s)")
+	i18n.Register(ErrorWaiting, "Waiting for network")
+	// TRANSLATORS: the first %s is the size of the message, the second the
+	// largest size allowed, e.g. 1.2MB and 300kB
+	i18n.Register(ErrorTooLarge, "The message is too large to send (%s, at most %s)")
+	// TRANSLATORS: the first %d is the number of recipients of the message,
+	// the second the largest number allowed
+	i18n.Register(ErrorTooManyRecipients, "The message has too many recipients (%d, at most %d)")
+	// TRANSLATORS: the first %d is the size of the recipient addresses in
+	// bytes, the second the largest size allowed
+	i18n.Register(ErrorAddressesTooLong, "The recipient addresses are too long to send (%d bytes, at most %d)")
+	i18n.Register(ErrorExpired, "The message expired on the server")
+	i18n.Register(ErrorServiceDenied, "The MMS service refused to deliver the message")
+	i18n.Register(ErrorUnsupported, "The message content is not supported by the MMS service")
+	i18n.Register(ErrorRetrieve, "The MMS service could not deliver the message")
+	i18n.Register(ErrorUnresolved, "The recipient address is not known to the MMS service")
+	i18n.Register(ErrorNotAccepted, "The MMS service did not accept the message, it may be too large")
+	i18n.Register(ErrorNetworkProblem, "The MMS service has a network problem, try again later")
+	i18n.Register(ErrorSend, "The message could not be sent")
+	i18n.Register(ErrorGatewayPage, "The mobile network returned a web page instead of the message, check the MMS settings")
+	i18n.Register(ErrorInvalidContent, "The downloaded message is not valid")
+	i18n.Register(ErrorTLS, "A secure connection to the MMS service could not be established")
+	i18n.Register(ErrorRedirectLoop, "The MMS service redirected the download in a loop, check the MMS settings")
+}
+
 type standartizedError struct {
 	error
 	code string
@@ -20,3 +81,166 @@ type downloadError struct {
 }
 
 func (e downloadError) AllowRedownload() bool { return true }
+
+// deferredError is communicated for messages which are not downloaded
+// automatically, they are downloaded once the user asks for it.
+type deferredError struct {
+	downloadError
+	size uint64
+}
+
+func newDeferredError(reason string, size uint64) deferredError {
+	code := ErrorDeferred
+	if size > 0 {
+		code = ErrorDeferredSize
+	}
+	return deferredError{downloadError{standartizedError{fmt.Errorf("download deferred: %s", reason), code}}, size}
+}
+
+func (e deferredError) Deferred() bool { return true }
+
+func (e deferredError) TextArgs() []interface{} {
+	if e.size == 0 {
+		return nil
+	}
+	return []interface{}{formatSize(e.size)}
+}
+
+// waitingError is communicated for messages which are downloaded once the
+// modem is back online.
+type waitingError struct {
+	standartizedError
+}
+
+func (e waitingError) WaitingForNetwork() bool { return true }
+
+// tooLargeError is communicated for outgoing messages which are larger than
+// the carrier allows, they are not sent.
+type tooLargeError struct {
+	standartizedError
+	size, limit uint64
+}
+
+func newTooLargeError(size, limit uint64, source string) tooLargeError {
+	err := fmt.Errorf("message is %d bytes which exceeds the %d bytes allowed by %s", size, limit, source)
+	return tooLargeError{standartizedError{err, ErrorTooLarge}, size, limit}
+}
+
+func (e tooLargeError) Size() uint64 { return e.size }
+
+func (e tooLargeError) TextArgs() []interface{} {
+	return []interface{}{formatSize(e.size), formatSize(e.limit)}
+}
+
+// recipientsError is communicated for outgoing messages to more recipients,
+// or with longer recipient addresses, than the carrier allows, they are not
+// sent.
+type recipientsError struct {
+	standartizedError
+	count, limit uint64
+}
+
+func newTooManyRecipientsError(count, limit uint64, source string) recipientsError {
+	err := fmt.Errorf("message has %d recipients which exceeds the %d allowed by %s", count, limit, source)
+	return recipientsError{standartizedError{err, ErrorTooManyRecipients}, count, limit}
+}
+
+func newAddressesTooLongError(size, limit uint64, source string) recipientsError {
+	err := fmt.Errorf("recipient addresses are %d bytes which exceeds the %d bytes allowed by %s", size, limit, source)
+	return recipientsError{standartizedError{err, ErrorAddressesTooLong}, size, limit}
+}
+
+func (e recipientsError) TextArgs() []interface{} {
+	return []interface{}{e.count, e.limit}
+}
+
+// newDownloadContentError maps the error of a failed download to an error
+// code. Something else than a PDU, e.g. the page of a WAP gateway or captive
+// portal, gets its own code so it is not mistaken for a broken message.
+func newDownloadContentError(err error) downloadError {
+	if errors.As(err, &mms.ErrorTLS{}) {
+		return newTLSError(err).downloadError
+	}
+	if errors.As(err, &mms.ErrorRedirectLoop{}) {
+		return downloadError{standartizedError{err, ErrorRedirectLoop}}
+	}
+	var content mms.ErrorUnexpectedContent
+	if !errors.As(err, &content) {
+		return downloadError{standartizedError{err, ErrorDownloadContent}}
+	}
+	if content.Gateway() {
+		return downloadError{standartizedError{err, ErrorGatewayPage}}
+	}
+	return downloadError{standartizedError{err, ErrorInvalidContent}}
+}
+
+// tlsError is communicated for transactions which failed because no secure
+// connection to the MMS center could be established, e.g. its certificate is
+// not trusted. They may succeed again once the certificates are fixed.
+type tlsError struct {
+	downloadError
+}
+
+func newTLSError(err error) tlsError {
+	return tlsError{downloadError{standartizedError{err, ErrorTLS}}}
+}
+
+func (e tlsError) Transient() bool { return true }
+
+// newRetrieveError maps the retrieve status the MMS center answered a
+// download with to an error code, transient failures allow a redownload.
+func newRetrieveError(err mms.ErrorRetrieveStatus) error {
+	code := ErrorRetrieve
+	switch err.Reason() {
+	case mms.RetrieveStatusErrorTransientMessageNotFound, mms.RetrieveStatusErrorPermanentMessageNotFound:
+		code = ErrorExpired
+	case mms.RetrieveStatusErrorPermanentServiceDenied:
+		code = ErrorServiceDenied
+	case mms.RetrieveStatusErrorPermanentContentUnsupported:
+		code = ErrorUnsupported
+	}
+	if err.Transient() {
+		return downloadError{standartizedError{err, code}}
+	}
+	return standartizedError{err, code}
+}
+
+// responseError is communicated for outgoing messages the MMS center did
+// not accept.
+type responseError struct {
+	standartizedError
+	status mms.ErrorResponseStatus
+}
+
+// newResponseError maps the response status the MMS center answered a send
+// with to an error code.
+func newResponseError(err mms.ErrorResponseStatus) responseError {
+	code := ErrorSend
+	switch err.Status {
+	case mms.ResponseStatusErrorServiceDenied, mms.ResponseStatusErrorPermanentServiceDenied:
+		code = ErrorServiceDenied
+	case mms.ResponseStatusErrorUnsupportedMessage, mms.ResponseStatusErrorMessageFormatCorrupt, mms.ResponseStatusErrorPermanentMessageFormatCorrupt:
+		code = ErrorUnsupported
+	case mms.ResponseStatusErrorContentNotAccepted, mms.ResponseStatusErrorPermanentContentNotAccepted:
+		code = ErrorNotAccepted
+	case mms.ResponseStatusErrorNetworkProblem, mms.ResponseStatusErrorTransientNetworkProblem:
+		code = ErrorNetworkProblem
+	case mms.ResponseStatusErrorSendingAddressUnresolved, mms.ResponseStatusErrorTransientAddressUnresolved, mms.ResponseStatusErrorPermanentAddressUnresolved:
+		code = ErrorUnresolved
+	}
+	return responseError{standartizedError{err, code}, err}
+}
+
+// Transient returns true if the message may be sent again.
+func (e responseError) Transient() bool { return e.status.Transient() }
+
+// formatSize returns size in bytes in a human readable form, e.g. 3.2MB.
+func formatSize(size uint64) string {
+	switch {
+	case size >= 1000*1000:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1000*1000))
+	case size >= 1000:
+		return fmt.Sprintf("%.0fkB", float64(size)/1000)
+	}
+	return fmt.Sprintf("%dB", size)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ubports/nuntium/carrier"
+	"github.com/ubports/nuntium/mms"
+)
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		size uint64
+		want string
+	}{
+		{0, "0B"},
+		{999, "999B"},
+		{1000, "1kB"},
+		{300 * 1000, "300kB"},
+		{3200 * 1000, "3.2MB"},
+	}
+	for _, tc := range testCases {
+		if got := formatSize(tc.size); got != tc.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestNewDeferredError(t *testing.T) {
+	err := newDeferredError("too big", 3200*1000)
+	if err.Code() != ErrorDeferredSize || !err.AllowRedownload() || !err.Deferred() {
+		t.Errorf("newDeferredError(..., 3200000) = %#v, want a redownloadable %s error", err, ErrorDeferredSize)
+	}
+	if args := err.TextArgs(); !reflect.DeepEqual(args, []interface{}{"3.2MB"}) {
+		t.Errorf("TextArgs() = %v, want [3.2MB]", args)
+	}
+
+	err = newDeferredError("disabled", 0)
+	if err.Code() != ErrorDeferred || err.TextArgs() != nil {
+		t.Errorf("newDeferredError(..., 0) = %#v, want a %s error without text arguments", err, ErrorDeferred)
+	}
+}
+
+func TestNewRetrieveError(t *testing.T) {
+	testCases := []struct {
+		status    byte
+		code      string
+		transient bool
+	}{
+		{mms.RetrieveStatusErrorTransientMessageNotFound, ErrorExpired, true},
+		{mms.RetrieveStatusErrorPermanentMessageNotFound, ErrorExpired, false},
+		{mms.RetrieveStatusErrorPermanentServiceDenied, ErrorServiceDenied, false},
+		{mms.RetrieveStatusErrorPermanentContentUnsupported, ErrorUnsupported, false},
+		{mms.RetrieveStatusErrorTransientNetworkProblem, ErrorRetrieve, true},
+		{250, ErrorRetrieve, false},
+	}
+	for _, tc := range testCases {
+		err := newRetrieveError(mms.ErrorRetrieveStatus{Status: tc.status})
+		if code := err.(interface{ Code() string }).Code(); code != tc.code {
+			t.Errorf("newRetrieveError(%d) has code %s, want %s", tc.status, code, tc.code)
+		}
+		_, transient := err.(interface{ AllowRedownload() bool })
+		if transient != tc.transient {
+			t.Errorf("newRetrieveError(%d) allows redownload: %v, want %v", tc.status, transient, tc.transient)
+		}
+	}
+}
+
+func TestNewDownloadContentError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code string
+	}{
+		{errors.New("download timeout"), ErrorDownloadContent},
+		{fmt.Errorf("transfer: %w", mms.ErrorUnexpectedContent{ContentType: "text/html"}), ErrorGatewayPage},
+		{mms.ErrorUnexpectedContent{ContentType: "application/octet-stream"}, ErrorInvalidContent},
+		{mms.ErrorUnexpectedContent{}, ErrorInvalidContent},
+		{mms.ErrorTLS{Err: errors.New("x509: certificate signed by unknown authority")}, ErrorTLS},
+		{fmt.Errorf("download failed: %w", mms.ErrorRedirectLoop{URL: "http://mmsc/1"}), ErrorRedirectLoop},
+	}
+	for _, tc := range testCases {
+		if code := newDownloadContentError(tc.err).Code(); code != tc.code {
+			t.Errorf("newDownloadContentError(%v) has code %s, want %s", tc.err, code, tc.code)
+		}
+	}
+}
+
+func TestNewResponseError(t *testing.T) {
+	testCases := []struct {
+		status    byte
+		code      string
+		transient bool
+	}{
+		{mms.ResponseStatusErrorPermanentServiceDenied, ErrorServiceDenied, false},
+		{mms.ResponseStatusErrorUnsupportedMessage, ErrorUnsupported, false},
+		{mms.ResponseStatusErrorPermanentContentNotAccepted, ErrorNotAccepted, false},
+		{mms.ResponseStatusErrorTransientNetworkProblem, ErrorNetworkProblem, true},
+		{mms.ResponseStatusErrorPermanentAddressUnresolved, ErrorUnresolved, false},
+		{mms.ResponseStatusErrorTransientFailure, ErrorSend, true},
+		{mms.ResponseStatusErrorPermanentReplyChargingNotSupported, ErrorSend, false},
+	}
+	for _, tc := range testCases {
+		err := newResponseError(mms.ErrorResponseStatus{Status: tc.status})
+		if err.Code() != tc.code || err.Transient() != tc.transient {
+			t.Errorf("newResponseError(%d) = %s, transient %v, want %s, transient %v", tc.status, err.Code(), err.Transient(), tc.code, tc.transient)
+		}
+	}
+}
+
+func TestNewTooLargeError(t *testing.T) {
+	err := newTooLargeError(1200*1000, 300*1000, "the settings")
+	if err.Code() != ErrorTooLarge || err.Size() != 1200*1000 {
+		t.Errorf("newTooLargeError(...) = %#v, want a %s error of 1200000 bytes", err, ErrorTooLarge)
+	}
+	if args := err.TextArgs(); !reflect.DeepEqual(args, []interface{}{"1.2MB", "300kB"}) {
+		t.Errorf("TextArgs() = %v, want [1.2MB 300kB]", args)
+	}
+}
+
+func TestRecipientLimitError(t *testing.T) {
+	mSendReq := mms.NewMSendReq([]string{"+1", "+2", "+3"}, nil, false)
+	profile := carrier.Profile{MCC: "310", MNC: "410", MaxRecipients: 3}
+	if err := recipientLimitError(mSendReq, profile); err != nil {
+		t.Errorf("recipientLimitError() = %v for recipients within the limit", err)
+	}
+	profile.MaxRecipients = 2
+	err, ok := recipientLimitError(mSendReq, profile).(recipientsError)
+	if !ok || err.Code() != ErrorTooManyRecipients {
+		t.Fatalf("recipientLimitError() = %#v, want a %s error", err, ErrorTooManyRecipients)
+	}
+	if args := err.TextArgs(); !reflect.DeepEqual(args, []interface{}{uint64(3), uint64(2)}) {
+		t.Errorf("TextArgs() = %v, want [3 2]", args)
+	}
+
+	profile = carrier.Profile{MaxAddressSize: mSendReq.AddressSize() - 1}
+	if err, ok := recipientLimitError(mSendReq, profile).(recipientsError); !ok || err.Code() != ErrorAddressesTooLong {
+		t.Errorf("recipientLimitError() = %#v, want a %s error", err, ErrorAddressesTooLong)
+	}
+}
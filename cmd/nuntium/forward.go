@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// handleForwardRequest asks the MMS center to forward the message of a
+// Forward call and answers it.
+func (mediator *Mediator) handleForwardRequest(request *telepathy.ForwardRequest) {
+	service := mediator.telepathyService
+	if service == nil {
+		return
+	}
+	messageId, err := mediator.forward(request)
+	if err != nil {
+		logger.Errorf("Cannot forward %s: %v", request.UUID, err)
+	} else {
+		logger.Infof("Forwarded %s as %s", request.UUID, messageId)
+	}
+	if err := service.ReplyForward(request, messageId, err); err != nil {
+		logger.Error("Could not send reply: ", err)
+	}
+}
+
+// forward sends the m-forward.req of request and returns the Message-ID of
+// the forwarded message.
+func (mediator *Mediator) forward(request *telepathy.ForwardRequest) (string, error) {
+	var recipients []string
+	for _, to := range request.Recipients {
+		recipients = append(recipients, address.Normalize(to))
+	}
+	mForwardReq := mms.NewMForwardReq(request.ContentLocation, recipients, settings.Get().UseDeliveryReports)
+	mForwardConf := mms.NewMForwardConf()
+	if err := mediator.exchangePDU(mForwardReq, mForwardConf); err != nil {
+		return "", err
+	}
+	if err := mForwardConf.ResponseError(); err != nil {
+		return "", err
+	}
+	return mForwardConf.MessageId, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// gcInterval is the time between garbage collections of the storage.
+const gcInterval = 6 * time.Hour
+
+// collectGarbage removes read messages from the storage every gcInterval,
+// preferably while charging.
+func collectGarbage(mmsManager *telepathy.MMSManager) {
+	for {
+		time.Sleep(gcInterval)
+		powerMonitor.WaitMaintenance(gcInterval)
+		if _, err := mmsManager.CollectGarbage(); err != nil {
+			logger.Error("Cannot collect garbage: ", err)
+		}
+	}
+}
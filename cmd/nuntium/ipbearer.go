@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/ofono"
+)
+
+// useIPBearer returns true if transactions go to the MMSC over the default
+// route instead of through an MMS context, which takes the AllowIPBearer
+// option and a carrier profile allowing it.
+func (mediator *Mediator) useIPBearer() bool {
+	if !settings.Get().AllowIPBearer {
+		return false
+	}
+	profile, ok := mediator.carrierProfile()
+	return ok && profile.IPBearer
+}
+
+// online returns true if transactions can be attempted, i.e. the modem is
+// online or the IP bearer is used, which works in flight mode with Wi-Fi.
+func (mediator *Mediator) online() bool {
+	return mediator.modem.Online() || mediator.useIPBearer()
+}
+
+// ipBearerContext returns a context to use instead of an MMS context with the
+// IP bearer. It is not a context of ofono and has neither a proxy nor a
+// network interface, so transactions take the default route, but the message
+// center of the MMS context, if there is one. A message center or proxy of
+// the carrier profile still takes precedence.
+func (mediator *Mediator) ipBearerContext() ofono.OfonoContext {
+	properties := ofono.PropertiesType{}
+	preferredContext, _ := mediator.telepathyService.GetPreferredContext()
+	if contexts, err := mediator.modem.GetMMSContexts(preferredContext); err == nil {
+		if messageCenter, err := contexts[0].GetMessageCenter(); err == nil {
+			properties["MessageCenter"] = dbus.MakeVariant(messageCenter)
+		}
+	}
+	return ofono.OfonoContext{Properties: properties}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "sync"
+
+// priorityLock is a lock held by up to limit holders at once where callers of
+// LockPriority are let in before any caller of Lock that is waiting. The zero
+// value is an unlocked lock with a single holder, a mutual exclusion lock.
+type priorityLock struct {
+	mutex           sync.Mutex
+	cond            *sync.Cond
+	limit           int
+	holders         int
+	priorityWaiting int
+}
+
+// newPriorityLock returns a lock held by up to limit holders at once.
+func newPriorityLock(limit int) *priorityLock {
+	return &priorityLock{limit: limit}
+}
+
+// Lock locks l, waiting for any pending priority lockers to go first.
+func (l *priorityLock) Lock() {
+	l.lock(false)
+}
+
+// LockPriority locks l ahead of any waiting regular lockers.
+func (l *priorityLock) LockPriority() {
+	l.lock(true)
+}
+
+func (l *priorityLock) lock(priority bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mutex)
+	}
+	limit := l.limit
+	if limit < 1 {
+		limit = 1
+	}
+
+	if priority {
+		l.priorityWaiting++
+	}
+	for l.holders >= limit || (!priority && l.priorityWaiting > 0) {
+		l.cond.Wait()
+	}
+	if priority {
+		l.priorityWaiting--
+	}
+	l.holders++
+}
+
+// Unlock releases one hold of l.
+func (l *priorityLock) Unlock() {
+	l.mutex.Lock()
+	l.holders--
+	l.mutex.Unlock()
+	l.cond.Broadcast()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityLockOrdering(t *testing.T) {
+	var l priorityLock
+	l.Lock()
+
+	order := make(chan string, 2)
+	go func() {
+		l.Lock()
+		order <- "regular"
+		l.Unlock()
+	}()
+	// Give the regular locker time to start waiting before the priority one.
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		l.LockPriority()
+		order <- "priority"
+		l.Unlock()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	l.Unlock()
+	if first := <-order; first != "priority" {
+		t.Errorf("expected the priority locker first, got %s", first)
+	}
+	if second := <-order; second != "regular" {
+		t.Errorf("expected the regular locker second, got %s", second)
+	}
+}
+
+func TestPriorityLockLimit(t *testing.T) {
+	l := newPriorityLock(2)
+	l.Lock()
+	l.LockPriority()
+
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected the third locker to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.Unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the third locker in once a holder left")
+	}
+}
@@ -22,10 +22,11 @@
 package main
 
 import (
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
+
+	"github.com/ubports/nuntium/logging"
 )
 
 type Mainloop struct {
@@ -50,7 +51,7 @@ L:
 	for {
 		select {
 		case sig := <-m.sigchan:
-			log.Print("Received ", sig)
+			logger.Info("Received ", sig)
 			m.Bindings[sig]()
 		case _ = <-m.termchan:
 			break L
@@ -71,6 +72,12 @@ func HupHandler() {
 	syscall.Exit(1)
 }
 
-func IntHandler() {
-	syscall.Exit(1)
+// Usr1Handler toggles debug logging of every module, to debug an issue
+// without changing the LogLevel setting.
+func Usr1Handler() {
+	if logging.ToggleDebug() {
+		logger.Info("Debug logging enabled")
+	} else {
+		logger.Infof("Debug logging disabled, log level is %s", logging.Spec())
+	}
 }
@@ -22,55 +22,102 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"syscall"
+	"time"
 
+	"github.com/ubports/nuntium/accounts"
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/keyring"
+	"github.com/ubports/nuntium/logging"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/network"
 	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/power"
+	"github.com/ubports/nuntium/storage"
 	"github.com/ubports/nuntium/telepathy"
-	"launchpad.net/go-dbus/v1"
 )
 
+var logger = logging.New("mediator")
+
 func main() {
 	var (
 		conn        *dbus.Connection
 		connSession *dbus.Connection
 		err         error
 	)
+	// Packages without a logger of their own log through the standard
+	// logger, their lines are passed on at Info level.
+	log.SetFlags(0)
+	log.SetOutput(logging.New("nuntium").Writer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	loaded, err := config.Load()
+	if err != nil {
+		logger.Warn("Cannot load the configuration, using the defaults: ", err)
+	}
+	settings = config.NewStore(loaded)
+	go applyTransferSettings()
+	go applyLogLevel()
+
 	if connSession, err = dbus.Connect(dbus.SessionBus); err != nil {
-		log.Fatal("Connection error: ", err)
+		logger.Fatal("Connection error: ", err)
 	}
-	log.Print("Using session bus on ", connSession.UniqueName)
+	logger.Info("Using session bus on ", connSession.UniqueName)
 
-	mmsManager, err := telepathy.NewMMSManager(connSession)
+	store := storage.SQLite{}
+	store.Key, store.Encrypt = storageKey(connSession, settings.Get().EncryptStorage)
+	mmsManager, err := telepathy.NewMMSManager(connSession, settings, store)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal(err)
+	}
+	mmsManager.SetDebugState(modems.debugStates)
+	mobileDataMonitor = network.NewMobileDataMonitor(connSession)
+	if err := mobileDataMonitor.Init(); err != nil {
+		logger.Warn("Cannot follow the mobile data state, ignoring it: ", err)
 	}
 
 	if conn, err = dbus.Connect(dbus.SystemBus); err != nil {
-		log.Fatal("Connection error: ", err)
+		logger.Fatal("Connection error: ", err)
 	}
-	log.Print("Using system bus on ", conn.UniqueName)
+	logger.Info("Using system bus on ", conn.UniqueName)
+
+	powerMonitor = power.NewMonitor(conn)
+	if err := powerMonitor.Init(); err != nil {
+		logger.Warn("Cannot follow the battery state, ignoring it: ", err)
+	}
+	accountsMonitor = accounts.NewMonitor(conn)
+	if err := accountsMonitor.Init(); err != nil {
+		logger.Warn("Cannot follow the MMS switch, assuming MMS is enabled: ", err)
+	}
+	networkMonitor = network.NewMonitor(conn)
+	if err := networkMonitor.Init(); err != nil {
+		logger.Warn("Cannot follow the data saver state, ignoring it: ", err)
+	}
+	go collectGarbage(mmsManager)
+	go writeStatistics()
 
 	modemManager := ofono.NewModemManager(conn)
-	mediators := make(map[dbus.ObjectPath]*Mediator)
 	go func() {
 		for {
 			select {
 			case modem := <-modemManager.ModemAdded:
-				mediators[modem.Modem] = NewMediator(modem)
-				go mediators[modem.Modem].init(mmsManager)
+				modems.add(ctx, modem, mmsManager, store)
 				if err := modem.Init(); err != nil {
-					log.Printf("Cannot initialize modem %s", modem.Modem)
+					logger.Errorf("Cannot initialize modem %s", modem.Modem)
 				}
 			case modem := <-modemManager.ModemRemoved:
-				mediators[modem.Modem].Delete()
+				modems.remove(modem)
 			}
 		}
 	}()
 
 	if err := modemManager.Init(); err != nil {
-		log.Fatal(err)
+		logger.Fatal(err)
 	}
 
 	m := Mainloop{
@@ -78,7 +125,60 @@ func main() {
 		termchan: make(chan int),
 		Bindings: make(map[os.Signal]func())}
 
-	m.Bindings[syscall.SIGHUP] = func() { m.Stop(); HupHandler() }
-	m.Bindings[syscall.SIGINT] = func() { m.Stop(); IntHandler() }
+	m.Bindings[syscall.SIGHUP] = func() { m.Stop(); shutdown(cancel, mmsManager); HupHandler() }
+	// Interrupting and terminating nuntium are clean exits.
+	m.Bindings[syscall.SIGINT] = func() { m.Stop(); shutdown(cancel, mmsManager) }
+	m.Bindings[syscall.SIGTERM] = m.Bindings[syscall.SIGINT]
+	m.Bindings[syscall.SIGUSR1] = Usr1Handler
 	m.Start()
+	conn.Close()
+	connSession.Close()
+	logger.Info("Exiting")
+}
+
+// storageKey returns the key of encrypted messages from the keyring on the
+// session bus conn and whether downloaded messages are stored encrypted. The
+// key is created if encrypt is set, otherwise it is only looked up to read
+// messages stored while encryption was on.
+func storageKey(conn *dbus.Connection, encrypt bool) ([]byte, bool) {
+	if !encrypt {
+		key, err := keyring.Lookup(conn)
+		if err != nil && err != keyring.ErrNoKey {
+			logger.Error("Cannot get the storage key, encrypted messages cannot be read: ", err)
+		}
+		return key, false
+	}
+	key, err := keyring.Key(conn)
+	if err != nil {
+		logger.Error("Cannot get the storage key, storing messages unencrypted: ", err)
+		return nil, false
+	}
+	return key, true
+}
+
+// shutdownTimeout is how long shutting down waits for the transactions in
+// progress to be cancelled once drainTimeout passed.
+const shutdownTimeout = 5 * time.Second
+
+// applyTransferSettings keeps the download and upload timeouts and the
+// resuming of downloads in line with the settings.
+func applyTransferSettings() {
+	for {
+		changed := settings.Changed()
+		s := settings.Get()
+		mms.SetTimeouts(s.ConnectTimeoutDuration(), s.DownloadTimeoutDuration(), s.UploadTimeoutDuration())
+		mms.SetResumeDownloads(s.ResumeDownloads)
+		<-changed
+	}
+}
+
+// applyLogLevel keeps the levels of the loggers in line with the settings.
+func applyLogLevel() {
+	for {
+		changed := settings.Changed()
+		if err := logging.Configure(settings.Get().LogLevel); err != nil {
+			logger.Error("Cannot apply the log level: ", err)
+		}
+		<-changed
+	}
 }
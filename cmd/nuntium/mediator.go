@@ -22,49 +22,121 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/user"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ubports/nuntium/accounts"
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/carrier"
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/diagnostics"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/media"
+	"github.com/ubports/nuntium/metrics"
 	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/network"
 	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/policy"
+	"github.com/ubports/nuntium/power"
+	"github.com/ubports/nuntium/processor"
 	"github.com/ubports/nuntium/storage"
 	"github.com/ubports/nuntium/telepathy"
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/transport"
 )
 
+// maxParallelTransactions is the number of transactions, downloads, sends and
+// responses to the MMS center, which run at once on the MMS context.
+const maxParallelTransactions = 4
+
 type Mediator struct {
 	modem                   *ofono.Modem
 	telepathyService        *telepathy.MMSService
 	NewMNotificationInd     chan *mms.MNotificationInd
+	RejectMNotificationInd  chan *mms.MNotificationInd
+	MarkRead                chan string
+	CancelSend              chan string
+	Resend                  chan string
+	ConfigureContext        chan *telepathy.ContextConfiguration
+	MMBox                   chan *telepathy.MMBoxRequest
+	Forward                 chan *telepathy.ForwardRequest
+	CancelDelivery          chan *telepathy.CancelDeliveryRequest
 	NewMSendReq             chan *mms.MSendReq
 	NewMSendReqFile         chan struct{ filePath, uuid string }
 	outMessage              chan *telepathy.OutgoingMessage
 	terminate               chan bool
-	contextLock             sync.Mutex
-	unrespondedTransactions map[string]string // transactionId: UUID
+	shutdown                chan chan struct{} // stops the loop, closing the D-Bus objects
+	done                    chan struct{}      // closed once the loop ended
+	pushLock                sync.Mutex
+	pushesStopped           bool          // set while nuntium shuts down
+	transactionLock         *priorityLock // admits the transactions, at most maxParallelTransactions at once
+	contextLock             sync.RWMutex  // shared by the transactions, held exclusively to change the context
+	mmsContext              sharedContext // the MMS context in use by the transactions
+	unrespondedTransactions *transactionTable
+	recentNotifications     *duplicateTable // the notifications pushed within DuplicateWindow
+	parkedLock              sync.Mutex
+	parked                  []func() // transactions waiting for the modem to be online
+	transactionsLock        sync.Mutex
+	transactions            map[string]transaction // UUID: the transaction in progress
+	activeContextLock       sync.Mutex
+	activeContext           dbus.ObjectPath // the MMS context activated for a transaction
+	ctx                     context.Context               // done once the mediator stops
+	stop                    context.CancelFunc
+	storage                 storage.Storage // holds the messages, shared with the other mediators
+	// newTransport creates the transports PDUs are exchanged with,
+	// transport.New unless tests inject another one.
+	newTransport func(name string, options map[string]string) (transport.Transport, error)
 }
 
-//TODO these vars need a configuration location managed by system settings or
-//some UI accessible location.
-//useDeliveryReports is set in ofono
-var (
-	deferredDownload   bool
-	useDeliveryReports bool
-)
+// settings holds the nuntium options, they can change at runtime.
+var settings *config.Store
+
+// powerMonitor holds background work back while the battery is critical.
+var powerMonitor *power.Monitor
+
+// networkMonitor defers automatic downloads while data saving is on.
+var networkMonitor *network.Monitor
 
-func NewMediator(modem *ofono.Modem) *Mediator {
-	mediator := &Mediator{modem: modem}
+// accountsMonitor follows the MMS switch of the active user.
+var accountsMonitor *accounts.Monitor
+
+// mobileDataMonitor restarts downloads which failed for the lack of mobile
+// data once it is turned on.
+var mobileDataMonitor *network.MobileDataMonitor
+
+// NewMediator creates a mediator for modem which keeps its messages in store,
+// its transactions are cancelled once ctx is done.
+func NewMediator(ctx context.Context, modem *ofono.Modem, store storage.Storage) *Mediator {
+	mediator := &Mediator{modem: modem, storage: store}
+	mediator.ctx, mediator.stop = context.WithCancel(ctx)
 	mediator.NewMNotificationInd = make(chan *mms.MNotificationInd)
+	mediator.RejectMNotificationInd = make(chan *mms.MNotificationInd)
+	mediator.MarkRead = make(chan string)
+	mediator.CancelSend = make(chan string)
+	mediator.Resend = make(chan string)
+	mediator.ConfigureContext = make(chan *telepathy.ContextConfiguration)
+	mediator.MMBox = make(chan *telepathy.MMBoxRequest)
+	mediator.Forward = make(chan *telepathy.ForwardRequest)
+	mediator.CancelDelivery = make(chan *telepathy.CancelDeliveryRequest)
 	mediator.NewMSendReq = make(chan *mms.MSendReq)
 	mediator.NewMSendReqFile = make(chan struct{ filePath, uuid string })
 	mediator.outMessage = make(chan *telepathy.OutgoingMessage)
 	mediator.terminate = make(chan bool)
-	mediator.unrespondedTransactions = make(map[string]string)
+	mediator.shutdown = make(chan chan struct{})
+	mediator.done = make(chan struct{})
+	mediator.unrespondedTransactions = newTransactionTable(store)
+	mediator.recentNotifications = newDuplicateTable()
+	mediator.newTransport = transport.New
+	mediator.transactions = make(map[string]transaction)
+	mediator.transactionLock = newPriorityLock(maxParallelTransactions)
+	mediator.mmsContext.activate = mediator.activateOfonoContext
+	mediator.mmsContext.keepAlive = func() time.Duration { return settings.Get().ContextKeepAliveDuration() }
 	return mediator
 }
 
@@ -73,25 +145,75 @@ func (mediator *Mediator) Delete() {
 }
 
 func (mediator *Mediator) init(mmsManager *telepathy.MMSManager) {
+	defer close(mediator.done)
+	go mediator.reapExpired()
+	dataSaverChanged := networkMonitor.Changed()
+	mobileDataChanged := mobileDataMonitor.Changed()
+	mmsSwitchChanged := accountsMonitor.Changed()
 mediatorLoop:
 	for {
 		select {
+		case <-dataSaverChanged:
+			dataSaverChanged = networkMonitor.Changed()
+			mediator.updateDataSaver()
+		case <-mobileDataChanged:
+			mobileDataChanged = mobileDataMonitor.Changed()
+			if mobileDataMonitor.Enabled() && mediator.telepathyService != nil && mmsEnabled() {
+				go mediator.retryDownloads(mediator.telepathyService, ErrorActivateContext, ErrorDownloadContent)
+			}
+		case <-mmsSwitchChanged:
+			mmsSwitchChanged = accountsMonitor.Changed()
+			if mediator.telepathyService != nil && mmsEnabled() {
+				go mediator.releaseDisabled(mediator.modem.Identity())
+			}
 		case push, ok := <-mediator.modem.PushAgent.Push:
 			if !ok {
-				log.Print("PushChannel is closed")
+				logger.Info("PushChannel is closed")
+				continue
+			}
+			if push == nil {
+				logger.Info("Received nil push")
 				continue
 			}
-			if !mmsEnabled() {
-				log.Print("MMS is disabled")
+			if !push.IsMMS() {
+				go mediator.handlePush(mediator.telepathyService, push)
 				continue
 			}
 			go mediator.handlePushAgentNotification(push, mediator.modem.Identity())
 		case mNotificationInd := <-mediator.NewMNotificationInd:
-			if deferredDownload {
-				go mediator.handleDeferredDownload(mNotificationInd)
+			if mediator.blocked(mNotificationInd) {
+				go mediator.handleBlockedMNotificationInd(mNotificationInd)
+			} else if reason, size := mediator.deferReason(mNotificationInd); reason != "" {
+				go mediator.handleDeferredDownload(mNotificationInd, reason, size)
 			} else {
 				go mediator.handleMNotificationInd(mNotificationInd)
 			}
+		case mNotificationInd := <-mediator.RejectMNotificationInd:
+			go mediator.handleRejectedMNotificationInd(mNotificationInd)
+		case uuid := <-mediator.MarkRead:
+			go mediator.handleMarkRead(uuid)
+		case uuid := <-mediator.CancelSend:
+			go mediator.cancelSend(uuid)
+		case uuid := <-mediator.Resend:
+			go mediator.resend(uuid)
+		case configuration := <-mediator.ConfigureContext:
+			go mediator.configureMMSContext(configuration)
+		case request := <-mediator.MMBox:
+			go mediator.handleMMBoxRequest(request)
+		case request := <-mediator.Forward:
+			go mediator.handleForwardRequest(request)
+		case request := <-mediator.CancelDelivery:
+			go mediator.handleCancelDeliveryRequest(request)
+		case online := <-mediator.modem.OnlineChanged:
+			if online {
+				mediator.flushParked()
+			} else {
+				go mediator.mmsContext.flush()
+			}
+		case attached := <-mediator.modem.AttachedChanged:
+			if attached && mediator.telepathyService != nil && mmsEnabled() {
+				go mediator.retryDownloads(mediator.telepathyService)
+			}
 		case msg := <-mediator.outMessage:
 			go mediator.handleOutgoingMessage(msg)
 		case mSendReq := <-mediator.NewMSendReq:
@@ -99,104 +221,432 @@ mediatorLoop:
 		case mSendReqFile := <-mediator.NewMSendReqFile:
 			go mediator.sendMSendReq(mSendReqFile.filePath, mSendReqFile.uuid)
 		case id := <-mediator.modem.IdentityAdded:
+			// Another modem may still serve the SIM, e.g. when it moved
+			// between slots, two mediators must not share its messages.
+			if !modems.claim(mediator, id) {
+				logger.Warnf("Identity %s is served by another modem, ignoring it on %s", id, mediator.modem.Modem)
+				continue
+			}
 			var err error
-			mediator.telepathyService, err = mmsManager.AddService(id, mediator.modem.Modem, mediator.outMessage, useDeliveryReports, mediator.NewMNotificationInd)
+			mediator.telepathyService, err = mmsManager.AddService(id, mediator.modem.Modem, mediator.outMessage, settings.Get().UseDeliveryReports, settings.Get().MmsdCompatibility, mediator.NewMNotificationInd, mediator.RejectMNotificationInd, mediator.MarkRead, mediator.CancelSend, mediator.Resend, mediator.ConfigureContext, mediator.MMBox, mediator.Forward, mediator.CancelDelivery)
 			if err != nil {
-				log.Fatal(err)
+				logger.Fatal(err)
 			}
+			mediator.updateDataSaver()
 
 			mediator.initializeMessages(id)
+			if mmsEnabled() {
+				go mediator.releaseDisabled(id)
+			}
 		case id := <-mediator.modem.IdentityRemoved:
+			if mediator.telepathyService == nil {
+				continue
+			}
 			err := mmsManager.RemoveService(id)
 			if err != nil {
-				log.Fatal(err)
+				logger.Fatal(err)
 			}
 			mediator.telepathyService = nil
+			modems.release(mediator, id)
 		case ok := <-mediator.modem.PushInterfaceAvailable:
-			if ok {
+			if ok && mediator.pushesAreStopped() {
+				logger.Infof("Not registering the push agent of %s, shutting down", mediator.modem.Modem)
+			} else if ok {
 				if err := mediator.modem.PushAgent.Register(); err != nil {
-					log.Fatal(err)
+					logger.Fatal(err)
 				}
 			} else {
 				if err := mediator.modem.PushAgent.Unregister(); err != nil {
-					log.Fatal(err)
+					logger.Fatal(err)
 				}
 			}
+		case done := <-mediator.shutdown:
+			mediator.stop()
+			if mediator.telepathyService != nil {
+				id := mediator.modem.Identity()
+				if err := mmsManager.RemoveService(id); err != nil {
+					logger.Error(err)
+				}
+				// The service is kept for handlers which did not end
+				// in time, it is not on the bus anymore.
+				modems.release(mediator, id)
+			}
+			mediator.mmsContext.flush()
+			close(done)
+			break mediatorLoop
 		case terminate := <-mediator.terminate:
-			/*
-				close(mediator.terminate)
-				close(mediator.outMessage)
-				close(mediator.NewMNotificationInd)
-				close(mediator.NewMRetrieveConf)
-				close(mediator.NewMRetrieveConfFile)
-				close(mediator.NewMSendReq)
-				close(mediator.NewMSendReqFile)
-			*/
+			// The channels stay open, handlers still running may send
+			// on them.
 			if terminate {
+				mediator.stop()
+				mediator.mmsContext.flush()
 				break mediatorLoop
 			}
 		}
 	}
-	log.Print("Ending mediator instance loop for modem")
+	logger.Info("Ending mediator instance loop for modem")
 }
 
 func (mediator *Mediator) handlePushAgentNotification(pushMsg *ofono.PushPDU, modemId string) {
 	if pushMsg == nil {
-		log.Print("Received nil push")
+		logger.Info("Received nil push")
 		return
 	}
 
+	if messageType, err := mms.MessageType(pushMsg.Data); err == nil {
+		// Notifications are kept until MMS is enabled, see holdDisabled.
+		if messageType != mms.TYPE_NOTIFICATION_IND && !mmsEnabled() {
+			logger.Info("MMS is disabled, dropping push of message type ", messageType)
+			return
+		}
+		switch messageType {
+		case mms.TYPE_DELIVERY_IND:
+			mediator.handleMDeliveryInd(pushMsg.Data)
+			return
+		case mms.TYPE_READ_ORIG_IND:
+			mediator.handleMReadOrigInd(pushMsg.Data)
+			return
+		case mms.TYPE_CANCEL_REQ:
+			mediator.handleMCancelReq(pushMsg.Data, modemId)
+			return
+		}
+	}
+
 	dec := mms.NewDecoder(pushMsg.Data)
 	mNotificationInd := mms.NewMNotificationInd(time.Now())
 	if err := dec.Decode(mNotificationInd); err != nil {
-		log.Println("Unable to decode m-notification.ind: ", err, "with log", dec.GetLog())
+		logger.Error("Unable to decode m-notification.ind:  ", err, " with log ", dec.GetLog())
+		metrics.IncCode(metrics.DecodeFailures, "m-notification.ind")
+		return
+	}
+	mNotificationInd.PDU = pushMsg.Data
+
+	if uuid, ok := mediator.recentNotifications.check(mNotificationInd, time.Now(), settings.Get().DuplicateWindowDuration()); ok {
+		logger.Infof("Dropping push of transaction ID %s, it repeats the notification of message %s", mNotificationInd.TransactionId, uuid)
+		mediator.journal(uuid, "duplicate", map[string]string{"TransactionId": mNotificationInd.TransactionId})
 		return
 	}
 
 	// Set received date to first push occurrence, if this is not a first time this transaction ID occurred.
 	if mNotificationInd.TransactionId != "" {
-		if uuid, ok := mediator.unrespondedTransactions[mNotificationInd.TransactionId]; ok {
-			log.Printf("Pushed transaction ID (%s) is in undownloaded pointing to UUID: %s", mNotificationInd.TransactionId, uuid)
-			if st, err := storage.GetMMSState(uuid); err == nil {
-				if st.MNotificationInd != nil {
-					log.Printf("Changing recieved date to the first push date: %v", st.MNotificationInd.Received)
+		if uuid, ok := mediator.unrespondedTransactions.get(mNotificationInd.TransactionId); ok {
+			logger.Infof("Pushed transaction ID (%s) is in undownloaded pointing to UUID: %s", mNotificationInd.TransactionId, uuid)
+			if st, err := mediator.storage.GetMMSState(uuid); err == nil {
+				if st.RejectPending {
+					// The user deleted the message, its rejection waits for the network.
+					logger.Infof("Dropping push of transaction ID %s, message %s is to be rejected", mNotificationInd.TransactionId, uuid)
+					return
+				}
+				if st.MNotificationInd != nil && st.MNotificationInd.Received.IsZero() {
+					// The first push was stored without its date, it was
+					// received now as far as we know.
+					mediator.correctReceived(uuid, st.MNotificationInd, mNotificationInd)
+				} else if st.MNotificationInd != nil {
+					logger.Infof("Changing recieved date to the first push date: %v", st.MNotificationInd.Received)
 					mNotificationInd.Received = st.MNotificationInd.Received
+					mNotificationInd.ReceivedBoot = st.MNotificationInd.ReceivedBoot
+					mNotificationInd.ReceivedUptime = st.MNotificationInd.ReceivedUptime
 				} else {
-					log.Printf("Error, no MNotificationInd in loaded mmsState for UUID %s", uuid)
+					logger.Errorf("Error, no MNotificationInd in loaded mmsState for UUID %s", uuid)
 				}
 			} else {
-				log.Printf("Error, can't load mmsState for UUID %s: %v", uuid, err)
+				logger.Errorf("Error, can't load mmsState for UUID %s: %v", uuid, err)
 			}
 		}
 	}
 
-	storage.Create(modemId, mNotificationInd)
+	mediator.storage.Create(modemId, mNotificationInd)
+	mediator.journal(mNotificationInd.UUID, "notification", map[string]string{
+		"TransactionId":   mNotificationInd.TransactionId,
+		"ContentLocation": diagnostics.SanitizeURL(mNotificationInd.ContentLocation),
+		"Size":            strconv.FormatUint(mNotificationInd.Size, 10),
+		"Expiry":          mms.FormatEpoch(mms.Epoch(mNotificationInd.Expire())),
+	})
+	if !mmsEnabled() {
+		mediator.holdDisabled(mNotificationInd)
+		return
+	}
 	mediator.NewMNotificationInd <- mNotificationInd
 }
 
-func (mediator *Mediator) handleDeferredDownload(mNotificationInd *mms.MNotificationInd) {
-	//TODO send MessageAdded with status="deferred" and mNotificationInd relevant headers
-	//
-	//Reading:
-	//	http://www.openmobilealliance.org/release/MMS/V1_1-20021104-C/OMA-WAP-MMS-ENC-V1_1-20021030-C.pdf - no deferred instructions, just mentions.
-	//	https://dl.cdn-anritsu.com/en-gb/test-measurement/files/Technical-Notes/White-Paper/MC-MMS_Signaling_Examples_and_KPI_Calculations-WP-1_0.pdf - no defered instructions, just mentions.
-	//	https://developer.brewmp.com/resources/tech-guides/multimedia-messaging-service-mms-technology-guide/mms-protocol-overview/mms-fe/receiving-mms-message - instructions on how to deffer.
-	//	https://www.slideshare.net/glebodic/mobile-messaging-part-5-76-mms-arch-and-transactions-reduced - has deferred instructions
-	//
-	//Notes:
-	//	If the application chooses to download the message at a later point in time, then an M-NotifyResp.ind is sent with the deferred flag set to acknowledge the receipt notification and notify that message download is deferred.
+// holdDisabled keeps the message of mNotificationInd, which arrived while
+// the user disabled MMS, in storage without telling telepathy about it. It is
+// handled like a new one once MMS is enabled, see releaseDisabled.
+func (mediator *Mediator) holdDisabled(mNotificationInd *mms.MNotificationInd) {
+	logger.Infof("MMS is disabled, holding back message %s until it is enabled", mNotificationInd.UUID)
+	if _, err := mediator.storage.UpdateDisabled(mNotificationInd.UUID); err != nil {
+		logger.Errorf("Error updating storage for message %s held back: %v", mNotificationInd.UUID, err)
+	}
+}
+
+// releaseDisabled handles the messages of modemId which arrived while MMS was
+// disabled, oldest first.
+func (mediator *Mediator) releaseDisabled(modemId string) {
+	uuids := mediator.storage.GetModemUUIDs(modemId, storage.DISABLED)
+	if len(uuids) > 0 {
+		logger.Infof("MMS is enabled, handling %d messages held back", len(uuids))
+	}
+	for _, uuid := range uuids {
+		mmsState, err := mediator.storage.UpdateNotification(uuid)
+		if err != nil || mmsState.MNotificationInd == nil {
+			logger.Errorf("Cannot handle message %s held back: %v", uuid, err)
+			continue
+		}
+		mediator.NewMNotificationInd <- mmsState.MNotificationInd
+	}
+}
+
+// handleMDeliveryInd decodes the m-delivery.ind in data and reports the
+// delivery status it holds on the sent message it refers to.
+func (mediator *Mediator) handleMDeliveryInd(data []byte) {
+	mDeliveryInd := mms.NewMDeliveryInd()
+	dec := mms.NewDecoder(data)
+	if err := dec.Decode(mDeliveryInd); err != nil {
+		logger.Error("Unable to decode m-delivery.ind:  ", err, " with log ", dec.GetLog())
+		metrics.IncCode(metrics.DecodeFailures, "m-delivery.ind")
+		return
+	}
 
+	uuid, err := mediator.storage.FindSent(mDeliveryInd.MessageId)
+	if err != nil {
+		logger.Warnf("Dropping m-delivery.ind for unknown message: %v", err)
+		return
+	}
+	status := deliveryStatus(mDeliveryInd.Status)
+	for _, to := range mDeliveryInd.To {
+		recipient := address.Normalize(to)
+		mediator.journal(uuid, "delivery", map[string]string{"Recipient": recipient, "Status": status})
+		if _, err := mediator.storage.UpdateSendState(uuid, recipient, status); err != nil {
+			logger.Errorf("Error updating send state of message %s: %v", uuid, err)
+		}
+		if mediator.telepathyService == nil {
+			// The modem went away, the delivery is kept in the send
+			// state of the message for clients to find later.
+			logger.Infof("No service to signal the delivery of message %s on, keeping it stored", uuid)
+			continue
+		}
+		if err := mediator.telepathyService.MessageDelivered(uuid, recipient, status); err != nil {
+			logger.Errorf("Error signaling delivery of message %s: %v", uuid, err)
+		}
+	}
 }
 
-func (mediator *Mediator) activateMMSContext() (mmsContext ofono.OfonoContext, deactivationFunc func(), err error) {
+// handleMReadOrigInd decodes the m-read-orig.ind in data and reports the read
+// report it holds on the sent message it refers to.
+func (mediator *Mediator) handleMReadOrigInd(data []byte) {
+	mReadOrigInd := mms.NewMReadOrigInd()
+	dec := mms.NewDecoder(data)
+	if err := dec.Decode(mReadOrigInd); err != nil {
+		logger.Error("Unable to decode m-read-orig.ind:  ", err, " with log ", dec.GetLog())
+		metrics.IncCode(metrics.DecodeFailures, "m-read-orig.ind")
+		return
+	}
+
+	uuid, err := mediator.storage.FindSent(mReadOrigInd.MessageId)
+	if err != nil {
+		logger.Warnf("Dropping m-read-orig.ind for unknown message: %v", err)
+		return
+	}
+	// The read report comes from the recipient.
+	recipient := address.Normalize(mReadOrigInd.From)
+	status := storage.DELETED_UNREAD
+	if mReadOrigInd.ReadStatus == mms.ReadStatusRead {
+		status = storage.READ
+	}
+	mediator.journal(uuid, "read-report", map[string]string{"Recipient": recipient, "Status": status})
+	if _, err := mediator.storage.UpdateReadState(uuid, recipient, status); err != nil {
+		logger.Errorf("Error updating read state of message %s: %v", uuid, err)
+	}
+	if status != storage.READ {
+		return
+	}
+	if mediator.telepathyService == nil {
+		logger.Infof("No service to signal the read report of message %s on, keeping it stored", uuid)
+		return
+	}
+	if err := mediator.telepathyService.MessageReadByRecipient(uuid); err != nil {
+		logger.Errorf("Error signaling read report of message %s: %v", uuid, err)
+	}
+}
+
+// deliveryStatus returns the storage send state for the X-Mms-Status of an
+// m-delivery.ind.
+func deliveryStatus(status byte) string {
+	switch status {
+	case mms.STATUS_EXPIRED:
+		return storage.EXPIRED
+	case mms.STATUS_RETRIEVED:
+		return storage.RETRIEVED
+	case mms.STATUS_REJECTED:
+		return storage.REJECTED
+	case mms.STATUS_DEFERRED:
+		return storage.DEFERRED
+	case mms.STATUS_FORWARDED:
+		return storage.FORWARDED
+	case mms.STATUS_UNREACHABLE:
+		return storage.UNREACHABLE
+	}
+	return storage.INDETERMINATE
+}
+
+// deferReason returns why the download of mNotificationInd has to wait for
+// the user, or an empty string to download it right away. The size is set
+// when the message is too big to be downloaded automatically.
+func (mediator *Mediator) deferReason(mNotificationInd *mms.MNotificationInd) (reason string, size uint64) {
+	if mNotificationInd.RedownloadOfUUID != "" {
+		// The user asked for the download.
+		return "", 0
+	}
+	switch action, _ := senderPolicy(mNotificationInd); action {
+	case policy.Auto:
+		return "", 0
+	case policy.Defer:
+		if !mNotificationInd.IsPriority() {
+			return "sender policy", 0
+		}
+	}
+	if limit := settings.Get().MeteredDownloadLimit; limit > 0 && !mNotificationInd.IsPriority() && networkMonitor.Metered() {
+		if mNotificationInd.Size > limit {
+			return fmt.Sprintf("message size %d exceeds the metered download limit %d", mNotificationInd.Size, limit), mNotificationInd.Size
+		}
+	} else if networkMonitor.DataSaver() && !mNotificationInd.IsPriority() {
+		return "data saver is enabled", 0
+	}
+	if !settings.Get().AutoDownloadWhileRoaming && !mNotificationInd.IsPriority() && mediator.roaming() {
+		return "the modem is roaming", 0
+	}
+	// Pushes may arrive before the service is added or after it is removed.
+	if mediator.telepathyService != nil {
+		if limit := settings.Get().AutoDownloadLimit; limit > 0 && !mNotificationInd.IsPriority() && mNotificationInd.Size > limit {
+			return fmt.Sprintf("message size %d exceeds the automatic download limit %d", mNotificationInd.Size, limit), mNotificationInd.Size
+		}
+	}
+	if settings.Get().DeferredDownload && !mNotificationInd.IsPriority() {
+		return "automatic download is disabled", 0
+	}
+	return "", 0
+}
+
+// roaming returns true if the modem is roaming. It is assumed not to if its
+// registration cannot be read.
+func (mediator *Mediator) roaming() bool {
+	roaming, err := mediator.modem.Roaming()
+	if err != nil {
+		logger.Warn("Cannot determine whether the modem is roaming: ", err)
+		return false
+	}
+	return roaming
+}
+
+// updateDataSaver reflects the data saving state on the telepathy service.
+func (mediator *Mediator) updateDataSaver() {
+	if mediator.telepathyService == nil {
+		return
+	}
+	if err := mediator.telepathyService.SetDataSaver(networkMonitor.DataSaver()); err != nil {
+		logger.Error("Cannot update the data saver property: ", err)
+	}
+}
+
+// senderPolicy returns the download policy for the sender of
+// mNotificationInd.
+func senderPolicy(mNotificationInd *mms.MNotificationInd) (policy.Action, bool) {
+	policies, err := policy.Load()
+	if err != nil {
+		logger.Warn("Cannot load download policies, ignoring them: ", err)
+		return "", false
+	}
+	return policies.Lookup(address.Normalize(mNotificationInd.From))
+}
+
+// blocked returns true if the sender of mNotificationInd is blocked by the
+// download policies. Messages the user asked to download are never blocked.
+func (mediator *Mediator) blocked(mNotificationInd *mms.MNotificationInd) bool {
+	if mNotificationInd.RedownloadOfUUID != "" {
+		return false
+	}
+	action, _ := senderPolicy(mNotificationInd)
+	return action == policy.Block
+}
+
+// handleBlockedMNotificationInd rejects the message of a blocked sender on the
+// MMS center and drops it without telling telepathy.
+func (mediator *Mediator) handleBlockedMNotificationInd(mNotificationInd *mms.MNotificationInd) {
+	defer mediator.beginTransaction(false)()
+
+	logger.Infof("Message %s is from a blocked sender", mNotificationInd.UUID)
+	metrics.Inc(metrics.MessagesBlocked)
+	if err := mediator.rejectMNotificationInd(mNotificationInd); err != nil {
+		logger.Errorf("Cannot reject blocked message %s: %v", mNotificationInd.UUID, err)
+	}
+	mediator.unrespondedTransactions.remove(mNotificationInd.TransactionId)
+	if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+		logger.Errorf("Error removing blocked message %s from storage: %v", mNotificationInd.UUID, err)
+	}
+}
+
+// handleDeferredDownload leaves the message undownloaded and tells telepathy
+// about it as a download error which allows redownloading, so the user can
+// start the download. The MMS center is not told that the download is
+// deferred, see the automatic download limit in docs/dbus.md.
+//
+//Reading:
+//	http://www.openmobilealliance.org/release/MMS/V1_1-20021104-C/OMA-WAP-MMS-ENC-V1_1-20021030-C.pdf - no deferred instructions, just mentions.
+//	https://dl.cdn-anritsu.com/en-gb/test-measurement/files/Technical-Notes/White-Paper/MC-MMS_Signaling_Examples_and_KPI_Calculations-WP-1_0.pdf - no defered instructions, just mentions.
+//	https://developer.brewmp.com/resources/tech-guides/multimedia-messaging-service-mms-technology-guide/mms-protocol-overview/mms-fe/receiving-mms-message - instructions on how to deffer.
+//	https://www.slideshare.net/glebodic/mobile-messaging-part-5-76-mms-arch-and-transactions-reduced - has deferred instructions
+func (mediator *Mediator) handleDeferredDownload(mNotificationInd *mms.MNotificationInd, reason string, size uint64) {
+	defer mediator.beginTransaction(false)()
+
+	logger.Infof("Deferring download of message %s: %s", mNotificationInd.UUID, reason)
+	mediator.trackTransaction(mNotificationInd)
+	mediator.handleMessageDownloadError(mNotificationInd, newDeferredError(reason, size))
+}
+
+// beginTransaction waits for the transaction to be admitted, priority ones
+// ahead of the others, and returns the function to call once it is done.
+// Transactions run in parallel, sharing the MMS context.
+func (mediator *Mediator) beginTransaction(priority bool) func() {
+	if priority {
+		mediator.transactionLock.LockPriority()
+	} else {
+		mediator.transactionLock.Lock()
+	}
+	mediator.contextLock.RLock()
+	return func() {
+		mediator.contextLock.RUnlock()
+		mediator.transactionLock.Unlock()
+	}
+}
+
+// activateMMSContext returns the MMS context, activating it unless another
+// transaction uses it already, and the function to call once the transaction
+// is done with it.
+func (mediator *Mediator) activateMMSContext() (ofono.OfonoContext, func(), error) {
+	return mediator.mmsContext.acquire()
+}
+
+// activateOfonoContext activates the MMS context of the modem.
+func (mediator *Mediator) activateOfonoContext() (mmsContext ofono.OfonoContext, deactivationFunc func(), err error) {
+	if mediator.useIPBearer() {
+		logger.Info("Using the IP bearer instead of an MMS context")
+		return mediator.ipBearerContext(), func() {}, nil
+	}
 	preferredContext, _ := mediator.telepathyService.GetPreferredContext()
 	mmsContext, err = mediator.modem.ActivateMMSContext(preferredContext)
+	if errors.Is(err, ofono.ErrNoMMSContexts) {
+		if contextPath, ok := mediator.provisionMMSContext(); ok {
+			mmsContext, err = mediator.modem.ActivateMMSContext(contextPath)
+		}
+	}
 	if err != nil {
 		return
 	}
+	mediator.setActiveContext(mmsContext.ObjectPath)
 	deactivationFunc = func() {
+		mediator.setActiveContext("")
 		if err := mediator.modem.DeactivateMMSContext(mmsContext); err != nil {
-			log.Println("Issues while deactivating context:", err)
+			logger.Warn("Issues while deactivating context: ", err)
 		}
 	}
 	return
@@ -213,134 +663,425 @@ func (mediator *Mediator) debugMMSContextError(mNotificationInd *mms.MNotificati
 }
 
 func (mediator *Mediator) handleMNotificationInd(mNotificationInd *mms.MNotificationInd) {
-	mediator.contextLock.Lock()
-	defer mediator.contextLock.Unlock()
-
-	if mNotificationInd.TransactionId != "" {
-		// Add transaction to unresponded if not already in there or unresponded not in storage.
-		if uuid, ok := mediator.unrespondedTransactions[mNotificationInd.TransactionId]; !ok {
-			mediator.unrespondedTransactions[mNotificationInd.TransactionId] = mNotificationInd.UUID
-		} else {
-			if _, err := storage.GetMMSState(uuid); err != nil {
-				// This is not an error and happens after redownload is triggered by user.
-				// In MMSService if the redownload request is handled, the listeners for old message are closed and the message gets deleted from storage.
-				// If this happens, replace the UUID in unrespondedTransactions for this transaction.
-				mediator.unrespondedTransactions[mNotificationInd.TransactionId] = mNotificationInd.UUID
-			}
-		}
+	// Priority messages jump ahead of any other transaction waiting to be
+	// admitted.
+	if mNotificationInd.IsPriority() {
+		logger.Infof("Handling priority message %s", mNotificationInd.UUID)
 	}
+	defer mediator.beginTransaction(mNotificationInd.IsPriority())()
+
+	mediator.trackTransaction(mNotificationInd)
 
 	var proxy ofono.ProxyInfo
 	var mmsContext ofono.OfonoContext
 	if mNotificationInd.IsDebug() {
-		log.Print("This is a local test, skipping context activation and proxy settings")
+		logger.Info("This is a local test, skipping context activation and proxy settings")
+		metrics.Inc(metrics.DownloadsAttempted)
 		if err := mediator.debugMMSContextError(mNotificationInd); err != nil {
-			log.Printf("Forcing debug error: %#v", err)
-			storage.UpdateMNotificationInd(mNotificationInd)
-			mediator.handleMessageDownloadError(mNotificationInd, err)
+			logger.Errorf("Forcing debug error: %#v", err)
+			mediator.storage.UpdateMNotificationInd(mNotificationInd)
+			mediator.failDownload(mNotificationInd, err)
 			return
 		}
 	} else {
+		if !mediator.online() {
+			mediator.parkDownload(mNotificationInd)
+			return
+		}
+		metrics.Inc(metrics.DownloadsAttempted)
 		var err error
 		var deactivateMMSContext func()
 		mmsContext, deactivateMMSContext, err = mediator.activateMMSContext()
 		if err != nil {
-			log.Print("Cannot activate ofono context: ", err)
-			mediator.handleMessageDownloadError(mNotificationInd, downloadError{standartizedError{err, ErrorActivateContext}})
+			logger.Error("Cannot activate ofono context: ", err)
+			mediator.failDownload(mNotificationInd, downloadError{standartizedError{err, ErrorActivateContext}})
 			return
 		}
 		if deactivateMMSContext != nil {
 			defer deactivateMMSContext()
 		}
 
-		if err := mediator.telepathyService.SetPreferredContext(mmsContext.ObjectPath); err != nil {
-			log.Println("Unable to store the preferred context for MMS:", err)
+		if mmsContext.ObjectPath != "" {
+			if err := mediator.telepathyService.SetPreferredContext(mmsContext.ObjectPath); err != nil {
+				logger.Error("Unable to store the preferred context for MMS: ", err)
+			}
 		}
-		proxy, err = mmsContext.GetProxy()
+		mediator.journal(mNotificationInd.UUID, "context", diagnostics.ContextParameters(mmsContext.Properties))
+		proxy, err = mediator.getProxy(mmsContext)
 		if err != nil {
-			log.Print("Error retrieving proxy: ", err)
-			mediator.handleMessageDownloadError(mNotificationInd, downloadError{standartizedError{err, ErrorGetProxy}})
+			logger.Error("Error retrieving proxy: ", err)
+			mediator.failDownload(mNotificationInd, downloadError{standartizedError{err, ErrorGetProxy}})
 			return
 		}
 	}
 
-	// Download message content.
-	if filePath, err := mNotificationInd.DownloadContent(proxy.Host, int32(proxy.Port)); err != nil {
-		log.Print("Download issues: ", err)
-		mediator.handleMessageDownloadError(mNotificationInd, downloadError{standartizedError{err, ErrorDownloadContent}})
+	// Download message content, a redownload is cancelled with the message it
+	// replaces.
+	uuids := []string{mNotificationInd.UUID}
+	if mNotificationInd.RedownloadOfUUID != "" {
+		uuids = append(uuids, mNotificationInd.RedownloadOfUUID)
+	}
+	ctx, done := mediator.startTransaction("download", uuids...)
+	defer done()
+	start := time.Now()
+	mmsProxy := mediator.mmsProxy(proxy, mmsContext)
+	mmsProxy.Progress, mmsProxy.ExpectedSize = mediator.transferProgress(mNotificationInd.UUID), mNotificationInd.Size
+	filePath, err := mediator.transport().Download(ctx, mNotificationInd.ContentLocation, mmsProxy)
+	mediator.journalHTTP(mNotificationInd.UUID, "download", mNotificationInd.ContentLocation, proxy, start, err)
+	if err != nil && ctx.Err() != nil {
+		logger.Infof("Download of %s was cancelled", mNotificationInd.UUID)
 		return
-	} else {
-		// Save message to storage and update state to DOWNLOADED.
-		if _, err := storage.UpdateDownloaded(mNotificationInd.UUID, filePath); err != nil {
-			log.Println("Error updating storage (UpdateDownloaded): ", err)
-			mediator.handleMessageDownloadError(mNotificationInd, downloadError{standartizedError{err, ErrorStorage}})
-			return
+	} else if err != nil {
+		logger.Warn("Download issues: ", err)
+		resolution := mmsProxy.Resolve(mediator.ctx, mNotificationInd.ContentLocation)
+		mediator.journalResolution(mNotificationInd.UUID, resolution)
+		mediator.failDownload(mNotificationInd, newDownloadContentError(fmt.Errorf("%w (%s)", err, resolution)))
+		return
+	}
+	// A message the MMS center failed to retrieve stays a notification, so
+	// it can be downloaded again if the failure is transient.
+	if err := retrieveStatusError(filePath); err != nil {
+		logger.Warnf("Message %s was not retrieved: %v", mNotificationInd.UUID, err)
+		os.Remove(filePath)
+		mediator.failDownload(mNotificationInd, newRetrieveError(*err))
+		return
+	}
+	// Save message to storage and update state to DOWNLOADED.
+	if _, err := mediator.storage.UpdateDownloaded(mNotificationInd.UUID, filePath); err != nil {
+		logger.Error("Error updating storage (UpdateDownloaded):  ", err)
+		mediator.failDownload(mNotificationInd, downloadError{standartizedError{err, ErrorStorage}})
+		return
+	}
+
+	// Some MMS centers want the download answered before the message is
+	// handed on.
+	responded := false
+	if profile, ok := mediator.carrierProfile(); ok && profile.EarlyNotifyResp && !mNotificationInd.FromMMBox && !mNotificationInd.IsDebug() {
+		mNotifyRespInd := mNotificationInd.NewMNotifyRespInd(mms.STATUS_RETRIEVED, settings.Get().UseDeliveryReports)
+		if filePath := mediator.handleMNotifyRespInd(mNotifyRespInd); filePath == "" {
+			logger.Errorf("Cannot answer download of %s early, answering it once handed on", mNotificationInd.UUID)
+		} else if err := mediator.sendMNotifyRespInd(mNotificationInd.UUID, filePath, &mmsContext); err != nil {
+			logger.Errorf("Cannot answer download of %s early, answering it once handed on: %v", mNotificationInd.UUID, err)
+		} else {
+			responded = true
 		}
 	}
 
 	// Forward message to telepathy service.
 	mRetrieveConf, err := mediator.getAndHandleMRetrieveConf(mNotificationInd)
 	if err != nil {
-		log.Printf("Handling MRetrieveConf error: %v", err)
-		mediator.handleMessageDownloadError(mNotificationInd, standartizedError{err, ErrorForward})
+		logger.Errorf("Handling MRetrieveConf error: %v", err)
+		mediator.storeDecodeLog(mNotificationInd.UUID)
+		mediator.failDownload(mNotificationInd, standartizedError{err, ErrorForward})
 		return
 	}
 	// Update message state in storage to RECEIVED.
-	if _, err := storage.UpdateReceived(mRetrieveConf.UUID); err != nil {
-		log.Println("Error updating storage (UpdateRetrieved): ", err)
+	if _, err := mediator.storage.UpdateReceived(mRetrieveConf.UUID); err != nil {
+		logger.Error("Error updating storage (UpdateRetrieved):  ", err)
 		return
 	}
+	metrics.Inc(metrics.DownloadsSucceeded)
 
 	// Notify MMS center about successful download.
-	mNotifyRespInd := mRetrieveConf.NewMNotifyRespInd(useDeliveryReports)
-	if !mNotificationInd.IsDebug() {
+	mNotifyRespInd := mRetrieveConf.NewMNotifyRespInd(settings.Get().UseDeliveryReports)
+	if responded {
+		logger.Infof("Download of %s was answered before it was handed on", mNotificationInd.UUID)
+	} else if mNotificationInd.FromMMBox {
+		logger.Infof("Message %s was retrieved from the MMBox, skipping m-notifyresp.ind", mNotificationInd.UUID)
+	} else if !mNotificationInd.IsDebug() {
 		// TODO deferred case
 		filePath := mediator.handleMNotifyRespInd(mNotifyRespInd)
 		if filePath == "" {
 			return
 		}
-		if err := mediator.sendMNotifyRespInd(filePath, &mmsContext); err != nil {
-			log.Println("Error sending m-notifyresp.ind: ", err)
+		if err := mediator.sendMNotifyRespInd(mNotificationInd.UUID, filePath, &mmsContext); err != nil {
+			logger.Error("Error sending m-notifyresp.ind:  ", err)
 			return
 		}
 	} else {
-		log.Print("This is a local test, skipping m-notifyresp.ind")
+		logger.Info("This is a local test, skipping m-notifyresp.ind")
 		if err := mNotificationInd.PopDebugError(mms.DebugErrorRespondHandle); err != nil {
-			log.Printf("Forcing debug error: %#v", err)
-			storage.UpdateMNotificationInd(mNotificationInd)
+			logger.Errorf("Forcing debug error: %#v", err)
+			mediator.storage.UpdateMNotificationInd(mNotificationInd)
 			return
 		}
 	}
 	// MMS center is notified, that the message was downloaded, we can remove the TransactionId from unrespondedTransactions.
-	delete(mediator.unrespondedTransactions, mNotificationInd.TransactionId)
+	mediator.unrespondedTransactions.remove(mNotificationInd.TransactionId)
 	// Update message state in storage to RESPONDED.
-	if _, err := storage.UpdateResponded(mNotifyRespInd.UUID); err != nil {
-		log.Println("Error updating storage (UpdateResponded): ", err)
+	if _, err := mediator.storage.UpdateResponded(mNotifyRespInd.UUID); err != nil {
+		logger.Error("Error updating storage (UpdateResponded):  ", err)
+		return
+	}
+}
+
+// handleRejectedMNotificationInd tells the MMS center that the message of
+// mNotificationInd, deleted by the user before being downloaded, is rejected,
+// so it stops pushing it, and then removes the message. If the modem is
+// offline the message is kept in storage until it is rejected.
+func (mediator *Mediator) handleRejectedMNotificationInd(mNotificationInd *mms.MNotificationInd) {
+	// Stop a download in progress first.
+	if mediator.cancelTransaction(mNotificationInd.UUID) {
+		logger.Infof("Cancelled download of deleted message %s", mNotificationInd.UUID)
+	}
+	defer mediator.beginTransaction(false)()
+
+	if err := mediator.rejectMNotificationInd(mNotificationInd); err == errOffline {
+		mediator.parkRejection(mNotificationInd)
+		return
+	} else if err != nil {
+		// The user asked for the message to go away, remove it anyway.
+		logger.Errorf("Cannot reject message %s, removing it without notifying the MMS center: %v", mNotificationInd.UUID, err)
+	} else {
+		logger.Infof("Message %s was rejected", mNotificationInd.UUID)
+	}
+	mediator.unrespondedTransactions.remove(mNotificationInd.TransactionId)
+
+	if mmsState, err := mediator.storage.GetMMSState(mNotificationInd.UUID); err == nil && mmsState.RejectPending {
+		// The message left the bus when its rejection was parked.
+		if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+			logger.Errorf("Error removing rejected message %s from storage: %v", mNotificationInd.UUID, err)
+		}
+		return
+	}
+	if err := mediator.telepathyService.MessageRemoved(mediator.telepathyService.GenMessagePath(mNotificationInd.UUID)); err != nil {
+		logger.Errorf("Error removing rejected message %s: %v", mNotificationInd.UUID, err)
+	}
+}
+
+// parkRejection removes the message of mNotificationInd from the bus, as the
+// user deleted it, but keeps it in storage marked as to be rejected, and
+// rejects it once the modem is back online. Until then repeated pushes of
+// the message are dropped.
+func (mediator *Mediator) parkRejection(mNotificationInd *mms.MNotificationInd) {
+	logger.Infof("Modem is offline, parking rejection of %s", mNotificationInd.UUID)
+	mmsState, err := mediator.storage.GetMMSState(mNotificationInd.UUID)
+	if err != nil {
+		logger.Errorf("Cannot park rejection of message %s: %v", mNotificationInd.UUID, err)
+		return
+	}
+	if !mmsState.RejectPending {
+		if _, err := mediator.storage.SetRejectPending(mNotificationInd.UUID); err != nil {
+			logger.Errorf("Cannot mark message %s as to be rejected: %v", mNotificationInd.UUID, err)
+		}
+		if err := mediator.telepathyService.MessageDestroy(mNotificationInd.UUID); err != nil {
+			logger.Debugf("Message %s to be rejected is not on the bus: %v", mNotificationInd.UUID, err)
+		} else if err := mediator.telepathyService.SingnalMessageRemoved(mediator.telepathyService.GenMessagePath(mNotificationInd.UUID)); err != nil {
+			logger.Errorf("Error signalling removal of message %s: %v", mNotificationInd.UUID, err)
+		}
+	}
+	mediator.park(func() {
+		if _, err := mediator.storage.GetMMSState(mNotificationInd.UUID); err != nil {
+			// The message expired meanwhile.
+			logger.Infof("Parked rejection of %s is gone: %v", mNotificationInd.UUID, err)
+			return
+		}
+		mediator.RejectMNotificationInd <- mNotificationInd
+	})
+}
+
+// correctReceived sets the missing received date of the stored notification
+// first of the message uuid to the one of the repeated push, and updates the
+// Received property of the message if it is on the bus.
+func (mediator *Mediator) correctReceived(uuid string, first, repeated *mms.MNotificationInd) {
+	first.Received = repeated.Received
+	first.ReceivedBoot = repeated.ReceivedBoot
+	first.ReceivedUptime = repeated.ReceivedUptime
+	if _, err := mediator.storage.UpdateMNotificationInd(first); err != nil {
+		logger.Errorf("Cannot store received date of message %s: %v", uuid, err)
+		return
+	}
+	if mediator.telepathyService == nil {
+		return
+	}
+	if err := mediator.telepathyService.MessageReceived(uuid, first.Received); err != nil {
+		logger.Debugf("Received date of message %s not signalled: %v", uuid, err)
+	}
+}
+
+// rejectMNotificationInd sends an m-notifyresp.ind with the rejected status
+// for mNotificationInd, or the retrieved one if the RejectStatus of the
+// carrier profile says so. It returns errOffline if the modem is offline.
+func (mediator *Mediator) rejectMNotificationInd(mNotificationInd *mms.MNotificationInd) error {
+	if mNotificationInd.IsDebug() {
+		logger.Info("This is a local test, skipping rejecting m-notifyresp.ind")
+		return nil
+	}
+	if mNotificationInd.TransactionId == "" {
+		return errors.New("no transaction id to respond to")
+	}
+	if !mmsEnabled() {
+		return errors.New("MMS is disabled")
+	}
+	if !mediator.online() {
+		return errOffline
+	}
+
+	mmsContext, deactivateMMSContext, err := mediator.activateMMSContext()
+	if err != nil {
+		return fmt.Errorf("cannot activate ofono context: %w", err)
+	}
+	if deactivateMMSContext != nil {
+		defer deactivateMMSContext()
+	}
+
+	status := byte(mms.STATUS_REJECTED)
+	if profile, ok := mediator.carrierProfile(); ok && profile.RejectStatus == carrier.RejectRetrieved {
+		status = mms.STATUS_RETRIEVED
+	}
+	mNotifyRespInd := mNotificationInd.NewMNotifyRespInd(status, false)
+	filePath := mediator.handleMNotifyRespInd(mNotifyRespInd)
+	if filePath == "" {
+		return errors.New("cannot create m-notifyresp.ind")
+	}
+	return mediator.sendMNotifyRespInd(mNotificationInd.UUID, filePath, &mmsContext)
+}
+
+// handleMarkRead sends a read report for the message with uuid, which the
+// user read, if its sender asked for one.
+func (mediator *Mediator) handleMarkRead(uuid string) {
+	defer mediator.beginTransaction(false)()
+
+	mediator.markRead(uuid)
+	if err := mediator.sendReadReport(uuid); err == errOffline {
+		logger.Infof("Modem is offline, parking read report of %s", uuid)
+		mediator.park(func() { mediator.MarkRead <- uuid })
+	} else if err != nil {
+		logger.Errorf("Cannot send read report of message %s: %v", uuid, err)
+	}
+}
+
+// markRead stores that the user read the incoming message with uuid and sets
+// its Read property, once.
+func (mediator *Mediator) markRead(uuid string) {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil || mmsState.Read || !mmsState.IsIncoming() {
+		return
+	}
+	if _, err := mediator.storage.SetRead(uuid); err != nil {
+		logger.Errorf("Cannot store message %s as read: %v", uuid, err)
+		return
+	}
+	if err := mediator.telepathyService.MessageRead(uuid); err != nil {
+		logger.Errorf("Cannot signal message %s as read: %v", uuid, err)
+	}
+}
+
+func (mediator *Mediator) sendReadReport(uuid string) error {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil {
+		return err
+	}
+	if mmsState.State != storage.RECEIVED && mmsState.State != storage.RESPONDED {
+		return fmt.Errorf("message in %s state cannot be read", mmsState.State)
+	}
+	if mmsState.ReadReportSent {
+		return nil
+	}
+	mRetrieveConf, err := mediator.getMRetrieveConf(uuid)
+	if err != nil {
+		return err
+	}
+	if !mRetrieveConf.ReadReportRequested() {
+		return nil
+	}
+	if mmsState.MNotificationInd.IsDebug() {
+		logger.Info("This is a local test, skipping m-read-rec.ind")
+		return nil
+	}
+	if !mmsEnabled() {
+		return errors.New("MMS is disabled")
+	}
+	if !mediator.online() {
+		return errOffline
+	}
+
+	mmsContext, deactivateMMSContext, err := mediator.activateMMSContext()
+	if err != nil {
+		return fmt.Errorf("cannot activate ofono context: %w", err)
+	}
+	if deactivateMMSContext != nil {
+		defer deactivateMMSContext()
+	}
+
+	f, err := mediator.storage.CreateReadReportFile(uuid)
+	if err != nil {
+		return err
+	}
+	enc := mms.NewEncoder(f)
+	if err := enc.Encode(mRetrieveConf.NewMReadRecInd(time.Now())); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot encode m-read-rec.ind: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := mediator.sendPDUFile(uuid, "m-read-rec.ind", f.Name(), &mmsContext); err != nil {
+		return err
+	}
+	logger.Infof("Sent read report of message %s", uuid)
+	_, err = mediator.storage.SetReadReportSent(uuid)
+	return err
+}
+
+// trackTransaction adds the transaction of mNotificationInd to the unresponded
+// transactions.
+func (mediator *Mediator) trackTransaction(mNotificationInd *mms.MNotificationInd) {
+	if mNotificationInd.TransactionId == "" {
 		return
 	}
+	// Add transaction to unresponded if not already in there or unresponded not in storage.
+	mediator.unrespondedTransactions.track(mNotificationInd.TransactionId, mNotificationInd.UUID)
+}
+
+// failDownload counts the failed download of mNotificationInd and handles
+// err.
+func (mediator *Mediator) failDownload(mNotificationInd *mms.MNotificationInd, err error) {
+	code := ""
+	if e, ok := err.(interface{ Code() string }); ok {
+		code = e.Code()
+	}
+	metrics.IncCode(metrics.DownloadsFailed, code)
+	mediator.handleMessageDownloadError(mNotificationInd, err)
 }
 
 // Communicates the download error "err" of mNotificationInd to telepathy service.
 // Some operators repeatedly push mNotificationInd with the same transaction id, if download not acknowledged by mNotifyRespInd. So we have to make sure, to communicate the download error just once.
 func (mediator *Mediator) handleMessageDownloadError(mNotificationInd *mms.MNotificationInd, err error) {
-	unrespondedUUID, inUnresponded := mediator.unrespondedTransactions[mNotificationInd.TransactionId]
+	details := map[string]string{"Error": err.Error()}
+	if e, ok := err.(interface{ Code() string }); ok {
+		details["Code"] = e.Code()
+	}
+	mediator.journal(mNotificationInd.UUID, "error", details)
+
+	unrespondedUUID, inUnresponded := mediator.unrespondedTransactions.get(mNotificationInd.TransactionId)
 
 	if mNotificationInd.TransactionId != "" && mNotificationInd.RedownloadOfUUID == "" && inUnresponded && unrespondedUUID != mNotificationInd.UUID {
 		// This download error "err" happened not after redownload and not after first download fail (there was another mNotificationInd with the same transaction id before).
 		// See if telepathy was notified (with error or message) before and if yes, don't send this error to telepathy and delete this message from storage.
-		if unrespondedState, err := storage.GetMMSState(unrespondedUUID); err == nil {
+		downloadError := err
+		if unrespondedState, err := mediator.storage.GetMMSState(unrespondedUUID); err == nil {
 			if unrespondedState.TelepathyErrorNotified || unrespondedState.State == storage.RECEIVED || unrespondedState.State == storage.RESPONDED {
-				log.Printf("Message or handling error for MNotificationInd with TransactionId: \"%s\" was already communicated by UUID: \"%s\"", mNotificationInd.TransactionId, unrespondedUUID)
+				logger.Warnf("Message or handling error for MNotificationInd with TransactionId: \"%s\" was already communicated by UUID: \"%s\"", mNotificationInd.TransactionId, unrespondedUUID)
+				if unrespondedState.State == storage.NOTIFICATION && unrespondedState.MNotificationInd != nil {
+					// The message is still shown as failed, show why the
+					// download failed this time.
+					if err := mediator.telepathyService.MessageErrorChanged(unrespondedState.MNotificationInd, downloadError); err != nil {
+						logger.Errorf("Cannot update download error of message %s: %v", unrespondedUUID, err)
+					}
+				}
 				// Delete this message from storage.
-				if err := storage.Destroy(mNotificationInd.UUID); err != nil {
-					log.Printf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
+				if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+					logger.Errorf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
 					return
 				}
-				log.Printf("Message %s was removed from storage", mNotificationInd.UUID)
+				logger.Infof("Message %s was removed from storage", mNotificationInd.UUID)
 				return
 			}
 		} else {
-			log.Printf("Error getting MMSState of unresponded message %s: %v", unrespondedUUID, err)
+			logger.Errorf("Error getting MMSState of unresponded message %s: %v", unrespondedUUID, err)
 		}
 
 	}
@@ -349,29 +1090,29 @@ func (mediator *Mediator) handleMessageDownloadError(mNotificationInd *mms.MNoti
 	// Send error message to telepathy service.
 	if addErr := mediator.telepathyService.IncomingMessageFailAdded(mNotificationInd, err); addErr != nil {
 		// Couldn't inform telepathy about download fail.
-		log.Printf("Sending download error message to telepathy has failed with error: %v", addErr)
+		logger.Errorf("Sending download error message to telepathy has failed with error: %v", addErr)
 		if mNotificationInd.TransactionId != "" && mNotificationInd.RedownloadOfUUID == "" && inUnresponded && unrespondedUUID != mNotificationInd.UUID {
 			// This is not after redownload and not after first download fail (there was another mNotificationInd with the same transaction id before).
 			// Delete this message from storage.
-			if err := storage.Destroy(mNotificationInd.UUID); err != nil {
-				log.Printf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
+			if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+				logger.Errorf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
 				return
 			}
-			log.Printf("Message %s was removed from storage", mNotificationInd.UUID)
+			logger.Infof("Message %s was removed from storage", mNotificationInd.UUID)
 		}
 		return
 	}
 
-	if _, err := storage.SetTelepathyErrorNotified(mNotificationInd.UUID); err != nil {
-		log.Printf("Error updating storage for message %s that telepahy was notified", mNotificationInd.UUID)
+	if _, err := mediator.storage.SetTelepathyErrorNotified(mNotificationInd.UUID); err != nil {
+		logger.Errorf("Error updating storage for message %s that telepahy was notified", mNotificationInd.UUID)
 		if mNotificationInd.TransactionId != "" && mNotificationInd.RedownloadOfUUID == "" && inUnresponded && unrespondedUUID != mNotificationInd.UUID {
 			// This is not after redownload and not after first download fail (there was another mNotificationInd with the same transaction id before).
 			// Delete this message from storage.
-			if err := storage.Destroy(mNotificationInd.UUID); err != nil {
-				log.Printf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
+			if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+				logger.Errorf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
 				return
 			}
-			log.Printf("Message %s was removed from storage", mNotificationInd.UUID)
+			logger.Infof("Message %s was removed from storage", mNotificationInd.UUID)
 		}
 		return
 	}
@@ -381,62 +1122,124 @@ func (mediator *Mediator) handleMessageDownloadError(mNotificationInd *mms.MNoti
 		// Close listener and delete the previous message communicated to telepathy.
 		if err := mediator.telepathyService.MessageRemoved(mediator.telepathyService.GenMessagePath(unrespondedUUID)); err != nil {
 			// Just log possible errors.
-			log.Printf("Error closing meesage %s handlers: %v", unrespondedUUID, err)
+			logger.Errorf("Error closing meesage %s handlers: %v", unrespondedUUID, err)
 		} else {
 			// Delete this message from storage for sure.
-			if err := storage.Destroy(mNotificationInd.UUID); err != nil {
-				log.Printf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
+			if err := mediator.storage.Destroy(mNotificationInd.UUID); err != nil {
+				logger.Errorf("Error removing message %s from storage: %v", mNotificationInd.UUID, err)
 			}
 		}
 		// Force this message to be unhandled.
-		mediator.unrespondedTransactions[mNotificationInd.TransactionId] = mNotificationInd.UUID
+		mediator.unrespondedTransactions.set(mNotificationInd.TransactionId, mNotificationInd.UUID)
 	}
 }
 
 // Decodes previously stored message (using UpdateDownloaded) to MRetrieveConf structure.
 func (mediator *Mediator) getMRetrieveConf(uuid string) (*mms.MRetrieveConf, error) {
-	filePath, err := storage.GetMMS(uuid)
+	mmsData, err := mediator.storage.ReadMMS(uuid)
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve MMS: %s", err)
 	}
 
-	mmsData, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("issues while reading from downloaded file: %s", err)
-	}
-
 	mRetrieveConf := mms.NewMRetrieveConf(uuid)
 	dec := mms.NewDecoder(mmsData)
 	if err := dec.Decode(mRetrieveConf); err != nil {
+		metrics.IncCode(metrics.DecodeFailures, "m-retrieve.conf")
 		return nil, fmt.Errorf("unable to decode m-retrieve.conf: %s with log %s", err, dec.GetLog())
 	}
 
 	return mRetrieveConf, nil
 }
 
+// maxDecodeLog caps the decoder log stored for a message, the log of a large
+// message with many parts could bloat its state.
+const maxDecodeLog = 64 * 1024
+
+// storeDecodeLog stores the log of decoding the downloaded m-retrieve.conf of
+// the message uuid, which could not be passed on to telepathy, so a faulty
+// encoding of the carrier can be reported with it.
+func (mediator *Mediator) storeDecodeLog(uuid string) {
+	data, err := mediator.storage.ReadMMS(uuid)
+	if err != nil {
+		logger.Errorf("Cannot read message %s to store its decoder log: %v", uuid, err)
+		return
+	}
+	log := diagnostics.DecodeLog(data, mms.NewMRetrieveConf(uuid))
+	if len(log) > maxDecodeLog {
+		log = log[:maxDecodeLog] + "...\n"
+	}
+	if _, err := mediator.storage.SetDecodeLog(uuid, log); err != nil {
+		logger.Errorf("Cannot store decoder log of message %s: %v", uuid, err)
+	}
+}
+
+// retrieveStatusError returns the failure the downloaded m-retrieve.conf in
+// filePath reports in its X-Mms-Retrieve-Status, nil if it reports none. Any
+// other problem with the PDU is left for getMRetrieveConf to report.
+func retrieveStatusError(filePath string) *mms.ErrorRetrieveStatus {
+	mmsData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+	mRetrieveConf := &mms.MRetrieveConf{}
+	// An error response usually has no content, the headers decoded before
+	// the decoder gives up are enough.
+	mms.NewDecoder(mmsData).Decode(mRetrieveConf)
+	if err, ok := mRetrieveConf.RetrieveError().(mms.ErrorRetrieveStatus); ok {
+		return &err
+	}
+	return nil
+}
+
 func (mediator *Mediator) getAndHandleMRetrieveConf(mNotificationInd *mms.MNotificationInd) (*mms.MRetrieveConf, error) {
 	mRetrieveConf, err := mediator.getMRetrieveConf(mNotificationInd.UUID)
 	if err != nil {
 		return nil, err
 	}
 
-	unrespondedUUID, inUnresponded := mediator.unrespondedTransactions[mNotificationInd.TransactionId]
+	// Run the content processors, a quarantined message is kept in storage
+	// but not forwarded to telepathy.
+	result := mediator.processMessage(mRetrieveConf)
+	if result.Quarantine {
+		logger.Infof("Message %s was quarantined: %s", mRetrieveConf.UUID, result.Reason)
+		if _, err := mediator.storage.SetQuarantined(mRetrieveConf.UUID, result.Reason); err != nil {
+			return nil, fmt.Errorf("cannot store quarantined message: %w", err)
+		}
+		return mRetrieveConf, nil
+	}
+	// Suspicious messages of unknown senders still reach telepathy, which
+	// files them as spam going by the state in storage.
+	if reason := mediator.spamReason(mRetrieveConf); reason != "" {
+		logger.Infof("Message %s is spam: %s", mRetrieveConf.UUID, reason)
+		if _, err := mediator.storage.SetSpam(mRetrieveConf.UUID, reason); err != nil {
+			return nil, fmt.Errorf("cannot store spam message: %w", err)
+		}
+		metrics.Inc(metrics.MessagesSpam)
+	}
+	if parts := sanitizedParts(settings.Get(), mRetrieveConf); len(parts) > 0 {
+		logger.Infof("Removing %d parts of message %s whose media type is not allowed", len(parts), mRetrieveConf.UUID)
+		if _, err := mediator.storage.SetSanitized(mRetrieveConf.UUID, parts); err != nil {
+			return nil, fmt.Errorf("cannot store removed parts: %w", err)
+		}
+	}
+
+	unrespondedUUID, inUnresponded := mediator.unrespondedTransactions.get(mNotificationInd.TransactionId)
 	removeUnresponded := false
 	// Check if there was some download error communicated for TransactionId before and no redownload was triggered.
 	if mNotificationInd.TransactionId != "" && mNotificationInd.RedownloadOfUUID == "" && inUnresponded && unrespondedUUID != mNotificationInd.UUID {
-		if unrespondedState, err := storage.GetMMSState(unrespondedUUID); err == nil {
+		if unrespondedState, err := mediator.storage.GetMMSState(unrespondedUUID); err == nil {
 			if unrespondedState.TelepathyErrorNotified {
 				// There was an error message communicated to telepathy before, mark it to delete it by telepathy when communicating this message.
 				mNotificationInd.RedownloadOfUUID = unrespondedUUID
 				removeUnresponded = true
 			}
 		} else {
-			log.Printf("Error getting MMSState of unresponded message %s: %v", unrespondedUUID, err)
+			logger.Errorf("Error getting MMSState of unresponded message %s: %v", unrespondedUUID, err)
 		}
 	}
 
 	// Forward message to telepathy service.
-	if err := mediator.telepathyService.IncomingMessageAdded(mRetrieveConf, mNotificationInd); err != nil {
+	if err := mediator.telepathyService.IncomingMessageAdded(mRetrieveConf, mNotificationInd, result.Annotations); err != nil {
 		return nil, fmt.Errorf("cannot notify telepathy about new message: %v", err)
 	}
 
@@ -444,56 +1247,88 @@ func (mediator *Mediator) getAndHandleMRetrieveConf(mNotificationInd *mms.MNotif
 		// Close listener and delete the previous message communicated to telepathy.
 		if err := mediator.telepathyService.MessageRemoved(mediator.telepathyService.GenMessagePath(unrespondedUUID)); err != nil {
 			// Just log possible errors.
-			log.Printf("Error closing meesage %s handlers: %v", unrespondedUUID, err)
+			logger.Errorf("Error closing meesage %s handlers: %v", unrespondedUUID, err)
 		}
 	}
 
 	return mRetrieveConf, nil
 }
 
+// processMessage runs the configured content processing pipeline on
+// mRetrieveConf.
+func (mediator *Mediator) processMessage(mRetrieveConf *mms.MRetrieveConf) processor.Result {
+	pipeline, err := processor.LoadPipeline()
+	if err != nil {
+		logger.Warn("Cannot load content processors, skipping processing: ", err)
+		return processor.Result{}
+	}
+	return pipeline.Process(mRetrieveConf)
+}
+
+// sanitizedParts returns the data parts of mRetrieveConf whose media type
+// the AllowedMediaTypes setting does not let through.
+func sanitizedParts(s config.Settings, mRetrieveConf *mms.MRetrieveConf) []storage.SanitizedPart {
+	var parts []storage.SanitizedPart
+	for _, part := range mRetrieveConf.GetDataParts() {
+		if !s.MediaTypeAllowed(part.MediaType) {
+			parts = append(parts, storage.SanitizedPart{Id: part.ContentId, MediaType: part.MediaType})
+		}
+	}
+	return parts
+}
+
 func (mediator *Mediator) handleMNotifyRespInd(mNotifyRespInd *mms.MNotifyRespInd) string {
-	f, err := storage.CreateResponseFile(mNotifyRespInd.UUID)
+	f, err := mediator.storage.CreateResponseFile(mNotifyRespInd.UUID)
 	if err != nil {
-		log.Print("Unable to create m-notifyresp.ind file for ", mNotifyRespInd.UUID)
+		logger.Error("Unable to create m-notifyresp.ind file for ", mNotifyRespInd.UUID)
 		return ""
 	}
 	enc := mms.NewEncoder(f)
 	if err := enc.Encode(mNotifyRespInd); err != nil {
-		log.Print("Unable to encode m-notifyresp.ind for ", mNotifyRespInd.UUID)
+		logger.Error("Unable to encode m-notifyresp.ind for ", mNotifyRespInd.UUID)
 		f.Close()
 		return ""
 	}
 	filePath := f.Name()
 	if err := f.Sync(); err != nil {
-		log.Print("Error while syncing", f.Name(), ": ", err)
+		logger.Error("Error while syncing", f.Name(), ": ", err)
 		return ""
 	}
 	if err := f.Close(); err != nil {
-		log.Print("Error while closing", f.Name(), ": ", err)
+		logger.Error("Error while closing", f.Name(), ": ", err)
 		return ""
 	}
-	log.Printf("Created %s to handle m-notifyresp.ind for %s", filePath, mNotifyRespInd.UUID)
+	logger.Infof("Created %s to handle m-notifyresp.ind for %s", filePath, mNotifyRespInd.UUID)
 	return filePath
 }
 
-func (mediator *Mediator) sendMNotifyRespInd(filePath string, mmsContext *ofono.OfonoContext) error {
+func (mediator *Mediator) sendMNotifyRespInd(uuid, filePath string, mmsContext *ofono.OfonoContext) error {
+	return mediator.sendPDUFile(uuid, "m-notifyresp.ind", filePath, mmsContext)
+}
+
+// sendPDUFile uploads the encoded pdu of the message with uuid in filePath to
+// the message center and removes the file.
+func (mediator *Mediator) sendPDUFile(uuid, pdu, filePath string, mmsContext *ofono.OfonoContext) error {
 	defer func() {
 		if err := os.Remove(filePath); err != nil {
-			log.Printf("cannot remove m-notifyresp.ind encoded file %s: %s", filePath, err)
+			logger.Errorf("cannot remove %s encoded file %s: %s", pdu, filePath, err)
 		}
 	}()
 
-	proxy, err := mmsContext.GetProxy()
+	proxy, err := mediator.getProxy(*mmsContext)
 	if err != nil {
 		return fmt.Errorf("cannot retrieve MMS proxy setting: %w", err)
 	}
-	msc, err := mmsContext.GetMessageCenter()
+	msc, err := mediator.getMessageCenter(*mmsContext)
 	if err != nil {
 		return fmt.Errorf("cannot retrieve MMSC setting: %w", err)
 	}
 
-	if _, err := mms.Upload(filePath, msc, proxy.Host, int32(proxy.Port)); err != nil {
-		return fmt.Errorf("cannot upload m-notifyresp.ind encoded file %s to message center: %w", filePath, err)
+	start := time.Now()
+	_, err = mediator.transport().Upload(mediator.ctx, filePath, msc, mediator.mmsProxy(proxy, *mmsContext))
+	mediator.journalHTTP(uuid, pdu, msc, proxy, start, err)
+	if err != nil {
+		return fmt.Errorf("cannot upload %s encoded file %s to message center: %w", pdu, filePath, err)
 	}
 
 	return nil
@@ -504,84 +1339,234 @@ func (mediator *Mediator) handleOutgoingMessage(msg *telepathy.OutgoingMessage)
 	for _, att := range msg.Attachments {
 		ct, err := mms.NewAttachment(att.Id, att.ContentType, att.FilePath)
 		if err != nil {
-			log.Print(err)
+			logger.Error(err)
 			//TODO reply to telepathy ofono with an error
 			return
 		}
 		cts = append(cts, ct)
 	}
-	mSendReq := mms.NewMSendReq(msg.Recipients, cts, useDeliveryReports)
-	if _, err := mediator.telepathyService.ReplySendMessage(msg.Reply, mSendReq.UUID); err != nil {
-		log.Print(err)
+	if msg.Smil != "" {
+		smil, err := mms.NewSmilAttachment(msg.Smil)
+		if err != nil {
+			logger.Error("Cannot use the SMIL of the outgoing message: ", err)
+			//TODO reply to telepathy ofono with an error
+			return
+		}
+		cts = append(cts, smil)
+	}
+	var compression media.Compression
+	if limit, source := mediator.maxMessageSize(); limit > 0 && settings.Get().ResizeImages {
+		compression = media.Fit(cts, limit)
+		if compression.Images > 0 {
+			logger.Infof("Resized %d images from %d to %d bytes to fit into %d bytes allowed by %s", compression.Images, compression.OriginalSize, compression.Size, limit, source)
+		}
+	}
+	if settings.Get().GenerateSmil && len(cts) > 0 && !mms.HasSmil(cts) {
+		cts = append(cts, mms.NewSmil(cts))
+	}
+	deliveryReport := settings.Get().UseDeliveryReports
+	if msg.DeliveryReport != nil {
+		deliveryReport = *msg.DeliveryReport
+	}
+	mSendReq := mms.NewMSendReq(msg.Recipients, cts, deliveryReport)
+	mSendReq.AddCopyRecipients(msg.Cc, msg.Bcc)
+	mSendReq.Subject = strings.TrimSpace(msg.Subject)
+	mSendReq.Priority = msg.Priority
+	mSendReq.SenderVisibility = msg.SenderVisibility
+	if msg.Class != 0 {
+		mSendReq.Class = msg.Class
+	}
+	if msg.Store {
+		// X-Mms-Store was introduced with MMS 1.2.
+		mSendReq.Store = mms.StoreYes
+		mSendReq.Version = mms.MMS_MESSAGE_VERSION_1_2
+	}
+	if !msg.Group {
+		mSendReq.Ungroup()
+	}
+	if _, err := mediator.telepathyService.ReplySendMessage(msg.Reply, mSendReq.UUID, deliveryReport); err != nil {
+		logger.Error(err)
 		return
 	}
+	if compression.Images > 0 {
+		if err := mediator.telepathyService.MessageCompressed(mSendReq.UUID, compression); err != nil {
+			logger.Error(err)
+		}
+	}
 	mediator.NewMSendReq <- mSendReq
 }
 
 func (mediator *Mediator) handleMSendReq(mSendReq *mms.MSendReq) {
-	log.Print("Encoding M-Send.Req")
-	f, err := storage.CreateSendFile(mSendReq.UUID)
+	logger.Debug("Encoding M-Send.Req")
+	var recipients []string
+	for _, to := range mSendReq.Recipients() {
+		recipients = append(recipients, address.Normalize(to))
+	}
+	f, err := mediator.storage.CreateSendFile(mediator.modem.Identity(), mSendReq.UUID, recipients)
 	if err != nil {
-		log.Print("Unable to create m-send.req file for ", mSendReq.UUID)
+		logger.Error("Unable to create m-send.req file for ", mSendReq.UUID)
 		return
 	}
 	defer f.Close()
+	if mSendReq.DeliveryReport == mms.DeliveryReportYes {
+		if _, err := mediator.storage.SetDeliveryReportRequested(mSendReq.UUID); err != nil {
+			logger.Errorf("Cannot store the delivery report request of %s: %v", mSendReq.UUID, err)
+		}
+	}
+	filePath := f.Name()
 	enc := mms.NewEncoder(f)
 	if err := enc.Encode(mSendReq); err != nil {
-		log.Print("Unable to encode m-send.req for ", mSendReq.UUID)
-		if err := mediator.telepathyService.MessageStatusChanged(mSendReq.UUID, telepathy.PERMANENT_ERROR); err != nil {
-			log.Println(err)
-		}
-		f.Close()
+		logger.Error("Unable to encode m-send.req for ", mSendReq.UUID)
+		mediator.failSend(mSendReq.UUID, filePath, telepathy.PERMANENT_ERROR)
 		return
 	}
-	filePath := f.Name()
 	if err := f.Sync(); err != nil {
-		log.Print("Error while syncing", f.Name(), ": ", err)
+		logger.Error("Error while syncing", f.Name(), ": ", err)
+		mediator.failSend(mSendReq.UUID, filePath, telepathy.PERMANENT_ERROR)
 		return
 	}
 	if err := f.Close(); err != nil {
-		log.Print("Error while closing", f.Name(), ": ", err)
+		logger.Error("Error while closing", f.Name(), ": ", err)
+		mediator.failSend(mSendReq.UUID, filePath, telepathy.PERMANENT_ERROR)
+		return
+	}
+	logger.Infof("Created %s to handle m-send.req for %s", filePath, mSendReq.UUID)
+	var sendErr error
+	if profile, ok := mediator.carrierProfile(); ok {
+		sendErr = recipientLimitError(mSendReq, profile)
+	}
+	if limit, source := mediator.maxMessageSize(); sendErr == nil && limit > 0 {
+		if fi, err := os.Stat(filePath); err == nil && uint64(fi.Size()) > limit {
+			sendErr = newTooLargeError(uint64(fi.Size()), limit, source)
+		}
+	}
+	if sendErr != nil {
+		logger.Infof("Not sending m-send.req for %s: %v", mSendReq.UUID, sendErr)
+		if err := mediator.telepathyService.MessageSendFailed(mSendReq.UUID, sendErr); err != nil {
+			logger.Error(err)
+		}
+		mediator.failSend(mSendReq.UUID, filePath, "")
 		return
 	}
-	log.Printf("Created %s to handle m-send.req for %s", filePath, mSendReq.UUID)
 	mediator.sendMSendReq(filePath, mSendReq.UUID)
 }
 
 func (mediator *Mediator) sendMSendReq(mSendReqFile, uuid string) {
-	defer os.Remove(mSendReqFile)
-	defer mediator.telepathyService.MessageDestroy(uuid)
-	mSendConfFile, err := mediator.uploadFile(mSendReqFile)
-	if err != nil {
+	// pending is set if the send continues later, resendable if it failed
+	// with a transient error and is kept for the user to resend it.
+	pending, resendable := false, false
+	defer func() {
+		if pending {
+			return
+		}
+		// A send which was neither sent nor cancelled failed.
+		if mmsState, err := mediator.storage.GetMMSState(uuid); err == nil && mmsState.State == storage.SENDING {
+			metrics.Inc(metrics.SendsFailed)
+			if _, err := mediator.storage.UpdateFailed(uuid); err != nil {
+				logger.Errorf("Error updating storage for failed message %s: %v", uuid, err)
+			}
+		}
+		if resendable {
+			return
+		}
+		os.Remove(mSendReqFile)
+		mediator.telepathyService.MessageDestroy(uuid)
+	}()
+	ctx, done := mediator.startTransaction("upload", uuid)
+	defer done()
+	if mmsState, err := mediator.storage.GetMMSState(uuid); err == nil && mmsState.State == storage.CANCELLED {
+		logger.Infof("Send of %s was cancelled", uuid)
+		return
+	}
+	if _, err := mediator.storage.UpdateSending(uuid); err != nil {
+		logger.Errorf("Error updating storage for message %s being sent: %v", uuid, err)
+	}
+	metrics.Inc(metrics.SendsAttempted)
+	mSendConfFile, err := mediator.uploadFile(ctx, uuid, mSendReqFile)
+	if err != nil && mediator.ctx.Err() != nil && !mediator.sendCancelled(uuid) {
+		// Shutting down, the send is resumed on the next start.
+		logger.Infof("Send of %s was interrupted, leaving it pending", uuid)
+		pending = true
+		if _, err := mediator.storage.UpdateSendPending(uuid); err != nil {
+			logger.Errorf("Error updating storage for message %s being interrupted: %v", uuid, err)
+		}
+		return
+	} else if err != nil && ctx.Err() != nil {
+		logger.Infof("Send of %s was cancelled during upload", uuid)
+		if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.CANCELLED); err != nil {
+			logger.Error(err)
+		}
+		return
+	} else if err == errOffline {
+		pending = true
+		mediator.parkSend(mSendReqFile, uuid)
+		return
+	} else if err != nil {
+		logger.Errorf("Cannot upload m-send.req encoded file %s to message center: %s", mSendReqFile, err)
+		if pending = mediator.retrySendLater(mSendReqFile, uuid); pending {
+			return
+		}
+		resendable = true
+		if errors.As(err, &mms.ErrorTLS{}) {
+			if err := mediator.telepathyService.MessageSendFailed(uuid, newTLSError(err)); err != nil {
+				logger.Error(err)
+			}
+			return
+		}
 		if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
-			log.Println(err)
+			logger.Error(err)
 		}
-		log.Printf("Cannot upload m-send.req encoded file %s to message center: %s", mSendReqFile, err)
 		return
 	}
 
 	defer os.Remove(mSendConfFile)
 	mSendConf, err := parseMSendConfFile(mSendConfFile)
 	if err != nil {
-		log.Println("Error while decoding m-send.conf:", err)
+		logger.Error("Error while decoding m-send.conf: ", err)
+		metrics.IncCode(metrics.DecodeFailures, "m-send.conf")
+		resendable = true
 		if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
-			log.Println(err)
+			logger.Error(err)
 		}
 		return
 	}
 
-	log.Println("m-send.conf ResponseStatus for", uuid, "is", mSendConf.ResponseStatus)
+	logger.Info("m-send.conf ResponseStatus for ", uuid, " is ", mSendConf.ResponseStatus)
+	mediator.journal(uuid, "send-conf", map[string]string{
+		"ResponseStatus": fmt.Sprintf("%#x", mSendConf.ResponseStatus),
+		"MessageId":      mSendConf.MessageId,
+	})
+	if err := mediator.telepathyService.MessageResponseStatus(uuid, mSendConf.ResponseStatus, mSendConf.ResponseText); err != nil {
+		logger.Error(err)
+	}
 	var status string
 	switch mSendConf.Status() {
 	case nil:
 		status = telepathy.SENT
-	case mms.ErrPermanent:
-		status = telepathy.PERMANENT_ERROR
-	case mms.ErrTransient:
-		status = telepathy.TRANSIENT_ERROR
+		metrics.Inc(metrics.SendsSucceeded)
+		if _, err := mediator.storage.UpdateSent(uuid, mSendConf.MessageId); err != nil {
+			logger.Errorf("Error updating storage for sent message %s: %v", uuid, err)
+		}
+		if mSendConf.MessageId != "" {
+			if err := mediator.telepathyService.MessageIdChanged(uuid, mSendConf.MessageId); err != nil {
+				logger.Error(err)
+			}
+		}
+	default:
+		sendErr := newResponseError(mSendConf.ResponseError().(mms.ErrorResponseStatus))
+		if sendErr.Transient() {
+			if pending = mediator.retrySendLater(mSendReqFile, uuid); pending {
+				return
+			}
+			resendable = true
+		}
+		if err := mediator.telepathyService.MessageSendFailed(uuid, sendErr); err != nil {
+			logger.Error(err)
+		}
+		return
 	}
 	if err := mediator.telepathyService.MessageStatusChanged(uuid, status); err != nil {
-		log.Println(err)
+		logger.Error(err)
 	}
 }
 
@@ -600,9 +1585,15 @@ func parseMSendConfFile(mSendConfFile string) (*mms.MSendConf, error) {
 	return mSendConf, nil
 }
 
-func (mediator *Mediator) uploadFile(filePath string) (string, error) {
-	mediator.contextLock.Lock()
-	defer mediator.contextLock.Unlock()
+func (mediator *Mediator) uploadFile(ctx context.Context, uuid, filePath string) (string, error) {
+	defer mediator.beginTransaction(false)()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if !mediator.online() {
+		return "", errOffline
+	}
 
 	mmsContext, deactivateMMSContext, err := mediator.activateMMSContext()
 	if err != nil {
@@ -610,111 +1601,396 @@ func (mediator *Mediator) uploadFile(filePath string) (string, error) {
 	}
 	defer deactivateMMSContext()
 
-	if err := mediator.telepathyService.SetPreferredContext(mmsContext.ObjectPath); err != nil {
-		log.Println("Unable to store the preferred context for MMS:", err)
+	if mmsContext.ObjectPath != "" {
+		if err := mediator.telepathyService.SetPreferredContext(mmsContext.ObjectPath); err != nil {
+			logger.Error("Unable to store the preferred context for MMS: ", err)
+		}
 	}
+	mediator.journal(uuid, "context", diagnostics.ContextParameters(mmsContext.Properties))
 
-	proxy, err := mmsContext.GetProxy()
+	proxy, err := mediator.getProxy(mmsContext)
 	if err != nil {
 		return "", err
 	}
-	msc, err := mmsContext.GetMessageCenter()
+	msc, err := mediator.getMessageCenter(mmsContext)
 	if err != nil {
 		return "", err
 	}
-	mSendRespFile, uploadErr := mms.Upload(filePath, msc, proxy.Host, int32(proxy.Port))
+	start := time.Now()
+	mmsProxy := mediator.mmsProxy(proxy, mmsContext)
+	if uuid != "" {
+		mmsProxy.Progress = mediator.transferProgress(uuid)
+	}
+	mSendRespFile, uploadErr := mediator.transport().Upload(ctx, filePath, msc, mmsProxy)
+	mediator.journalHTTP(uuid, "m-send.req", msc, proxy, start, uploadErr)
 
 	return mSendRespFile, uploadErr
 }
 
-// By default this method returns true, unless it is strictly requested to disable.
-func mmsEnabled() bool {
-	conn, err := dbus.Connect(dbus.SystemBus)
+// exchangePDU uploads req, a request of no stored message, to the MMS center
+// and decodes its answer into conf.
+func (mediator *Mediator) exchangePDU(req mms.MMSWriter, conf mms.MMSReader) error {
+	f, err := ioutil.TempFile("", "nuntium-pdu-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := mms.NewEncoder(f).Encode(req); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot encode the request: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	confFile, err := mediator.uploadFile(mediator.ctx, "", f.Name())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(confFile)
+	data, err := ioutil.ReadFile(confFile)
+	if err != nil {
+		return err
+	}
+	if err := mms.NewDecoder(data).Decode(conf); err != nil {
+		return fmt.Errorf("cannot decode the response: %w", err)
+	}
+	return nil
+}
+
+// journal records event with details in the audit trail of the message with
+// uuid, see the diagnostics package.
+func (mediator *Mediator) journal(uuid, event string, details map[string]string) {
+	// Transactions of no message, e.g. MMBox views, have no journal.
+	if uuid == "" {
+		return
+	}
+	if err := mediator.storage.AppendJournal(uuid, event, details); err != nil {
+		logger.Errorf("Cannot add %s to the journal of message %s: %v", event, uuid, err)
+	}
+}
+
+// journalHTTP records the timing and outcome of an HTTP transaction with
+// rawURL started at start.
+func (mediator *Mediator) journalHTTP(uuid, event, rawURL string, proxy ofono.ProxyInfo, start time.Time, err error) {
+	details := map[string]string{
+		"URL":      diagnostics.SanitizeURL(rawURL),
+		"Proxy":    proxy.String(),
+		"Start":    start.UTC().Format(time.RFC3339Nano),
+		"Duration": time.Since(start).String(),
+	}
+	if err != nil {
+		details["Error"] = err.Error()
+	}
+	mediator.journal(uuid, event, details)
+}
+
+// journalResolution journals how the host of a failed transaction of the
+// message with uuid resolves.
+func (mediator *Mediator) journalResolution(uuid string, resolution mms.Resolution) {
+	details := map[string]string{
+		"Host":      resolution.Host,
+		"Resolver":  "system",
+		"Addresses": strings.Join(resolution.Addresses, ", "),
+	}
+	if len(resolution.Servers) > 0 {
+		details["Resolver"] = strings.Join(resolution.Servers, ", ")
+	}
+	if resolution.Err != nil {
+		details["Error"] = resolution.Err.Error()
+	}
+	mediator.journal(uuid, "resolve", details)
+}
+
+// errOffline is returned for transactions attempted while the modem is
+// offline.
+var errOffline = errors.New("modem is offline")
+
+// park queues f to be run once the modem is back online.
+func (mediator *Mediator) park(f func()) {
+	mediator.parkedLock.Lock()
+	defer mediator.parkedLock.Unlock()
+	mediator.parked = append(mediator.parked, f)
+}
+
+// flushParked runs the transactions parked while the modem was offline.
+func (mediator *Mediator) flushParked() {
+	mediator.parkedLock.Lock()
+	parked := mediator.parked
+	mediator.parked = nil
+	mediator.parkedLock.Unlock()
+
+	if len(parked) > 0 {
+		logger.Infof("Modem is online, resuming %d parked transactions", len(parked))
+	}
+	for _, f := range parked {
+		go f()
+	}
+}
+
+// parkDownload tells telepathy the message of mNotificationInd waits for the
+// network and downloads it once the modem is back online.
+func (mediator *Mediator) parkDownload(mNotificationInd *mms.MNotificationInd) {
+	logger.Infof("Modem is offline, parking download of %s", mNotificationInd.UUID)
+	mediator.handleMessageDownloadError(mNotificationInd, waitingError{standartizedError{errOffline, ErrorWaiting}})
+	mediator.park(func() {
+		if _, err := mediator.storage.GetMMSState(mNotificationInd.UUID); err != nil {
+			// The message was dropped meanwhile, e.g. as a duplicate.
+			logger.Infof("Parked download of %s is gone: %v", mNotificationInd.UUID, err)
+			return
+		}
+		if err := mediator.telepathyService.RedownloadMessage(mNotificationInd.UUID); err != nil {
+			// Telepathy was not told about the message, download it as is.
+			logger.Errorf("Cannot restart the parked download of %s as a redownload: %v", mNotificationInd.UUID, err)
+			mediator.NewMNotificationInd <- mNotificationInd
+		}
+	})
+}
+
+// parkSend marks the message uuid as waiting for the network and sends it
+// once the modem is back online.
+func (mediator *Mediator) parkSend(mSendReqFile, uuid string) {
+	logger.Infof("Modem is offline, parking send of %s", uuid)
+	if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.WAITING_FOR_NETWORK); err != nil {
+		logger.Error(err)
+	}
+	mediator.park(func() {
+		mediator.NewMSendReqFile <- struct{ filePath, uuid string }{mSendReqFile, uuid}
+	})
+}
+
+// carrierProfile returns the carrier overrides for the SIM in use, or for
+// the network the modem is registered to if there are none for the SIM or
+// its operator is unknown, ok is false if there are none.
+func (mediator *Mediator) carrierProfile() (profile carrier.Profile, ok bool) {
+	overrides, err := carrier.LoadOverrides()
 	if err != nil {
-		log.Printf("mmsEnabled: connecting to dbus failed: %v", err)
-		return true
+		logger.Error("Cannot load carrier overrides: ", err)
+		return profile, false
 	}
+	if mcc, mnc, err := mediator.modem.OperatorCode(); err != nil {
+		logger.Error("Cannot determine operator code: ", err)
+	} else if profile, ok := overrides.Lookup(mcc, mnc); ok {
+		return profile, true
+	}
+	mcc, mnc, err := mediator.modem.NetworkOperatorCode()
+	if err != nil {
+		logger.Debug("Cannot determine network operator code: ", err)
+		return profile, false
+	}
+	return overrides.Lookup(mcc, mnc)
+}
 
-	usr, err := user.Current()
+// provisionMMSContext makes a context of the modem usable for MMS with the
+// access point the access point database holds for the SIM in use, if any.
+// It returns the object path of the context and false if there is none.
+func (mediator *Mediator) provisionMMSContext() (contextPath dbus.ObjectPath, ok bool) {
+	mcc, mnc, err := mediator.modem.OperatorCode()
+	if err != nil {
+		logger.Error("Cannot determine operator code: ", err)
+		return "", false
+	}
+	accessPoints, err := carrier.LoadAccessPoints()
+	if err != nil {
+		logger.Error("Cannot load access points: ", err)
+		return "", false
+	}
+	found := accessPoints.Lookup(mcc, mnc)
+	if len(found) == 0 {
+		logger.Warnf("No mms context configured and no access point known for %s/%s", mcc, mnc)
+		return "", false
+	}
+	ap := found[0]
+	logger.Infof("No mms context configured, provisioning %s", ap)
+	contextPath, err = mediator.modem.ProvisionMMSContext(ofono.ContextSettings{
+		Name:            ap.Name,
+		AccessPointName: ap.AccessPointName,
+		Username:        ap.Username,
+		Password:        ap.Password,
+		MessageCenter:   ap.MessageCenter,
+		MessageProxy:    ap.MessageProxy,
+	})
 	if err != nil {
-		log.Printf("mmsEnabled: getting user failed: %v", err)
-		return true
+		logger.Errorf("Cannot provision mms context for %s: %v", ap, err)
+		return "", false
 	}
-	activeUser := dbus.ObjectPath("/org/freedesktop/Accounts/User" + usr.Uid)
+	return contextPath, true
+}
 
-	obj := conn.Object("org.freedesktop.Accounts", activeUser)
-	reply, err := obj.Call("org.freedesktop.DBus.Properties", "Get", "com.ubuntu.touch.AccountsService.Phone", "MmsEnabled")
-	if err != nil || reply.Type == dbus.TypeError {
-		log.Printf("mmsEnabled: failed to get mms option: %v", err)
-		return true
+// maxMessageSize returns the largest m-send.req in bytes which can be sent, 0
+// if there is no limit, and what sets the limit. The smaller limit of the
+// settings and the carrier overrides applies.
+func (mediator *Mediator) maxMessageSize() (limit uint64, source string) {
+	limit, source = settings.Get().MaxMessageSize, "the settings"
+	if profile, ok := mediator.carrierProfile(); ok && profile.MaxMessageSize > 0 && (limit == 0 || profile.MaxMessageSize < limit) {
+		limit, source = profile.MaxMessageSize, profile.String()
+	}
+	return limit, source
+}
+
+// recipientLimitError returns the error mSendReq fails with if it has more
+// recipients, or longer recipient addresses, than profile allows, nil
+// otherwise.
+func recipientLimitError(mSendReq *mms.MSendReq, profile carrier.Profile) error {
+	if count := uint64(len(mSendReq.Recipients())); profile.MaxRecipients > 0 && count > uint64(profile.MaxRecipients) {
+		return newTooManyRecipientsError(count, uint64(profile.MaxRecipients), profile.String())
+	}
+	if size := mSendReq.AddressSize(); profile.MaxAddressSize > 0 && size > profile.MaxAddressSize {
+		return newAddressesTooLongError(size, profile.MaxAddressSize, profile.String())
 	}
+	return nil
+}
 
-	mms := dbus.Variant{true}
-	if err := reply.Args(&mms); err != nil {
-		log.Printf("mmsEnabled: failed to get mms option reply: %v", err)
-		return true
+// transport returns the transport selected by the carrier overrides, MM1 if
+// none is or it cannot be created.
+func (mediator *Mediator) transport() transport.Transport {
+	if profile, ok := mediator.carrierProfile(); ok && profile.Transport != "" {
+		t, err := mediator.newTransport(profile.Transport, profile.TransportOptions)
+		if err == nil {
+			logger.Infof("Using transport %s from carrier overrides for %s", profile.Transport, profile)
+			return t
+		}
+		logger.Errorf("Cannot use transport from carrier overrides for %s, using %s: %v", profile, transport.MM1, err)
 	}
+	t, _ := mediator.newTransport(transport.MM1, nil)
+	return t
+}
 
-	return mms.Value.(bool)
+// getProxy returns the proxy to use with mmsContext, a proxy forced by the
+// carrier overrides takes precedence over the one provisioned in ofono, and so
+// do proxy credentials.
+func (mediator *Mediator) getProxy(mmsContext ofono.OfonoContext) (ofono.ProxyInfo, error) {
+	profile, ok := mediator.carrierProfile()
+	var proxy ofono.ProxyInfo
+	if ok && profile.Proxy != "" {
+		proxy = ofono.ParseProxy(profile.Proxy, 80)
+		logger.Infof("Using proxy %s from carrier overrides for %s", proxy, profile)
+	} else {
+		var err error
+		if proxy, err = mmsContext.GetProxy(); err != nil {
+			return proxy, err
+		}
+	}
+	if ok && profile.ProxyUsername != "" {
+		logger.Infof("Using proxy credentials from carrier overrides for %s", profile)
+		proxy.Username, proxy.Password = profile.ProxyUsername, profile.ProxyPassword
+	}
+	return proxy, nil
+}
+
+// mmsProxy returns proxy of mmsContext in the form transports expect, with
+// the device headers of the settings, those of the carrier overrides taking
+// precedence, and the certificates of the carrier overrides.
+func (mediator *Mediator) mmsProxy(proxy ofono.ProxyInfo, mmsContext ofono.OfonoContext) mms.Proxy {
+	s := settings.Get()
+	p := mms.Proxy{
+		Host:      proxy.Host,
+		Port:      int32(proxy.Port),
+		Username:  proxy.Username,
+		Password:  proxy.Password,
+		Interface: mmsContext.GetInterface(),
+		UserAgent: s.UserAgent,
+		UAProf:    s.UAProf,
+	}
+	if s.ContextDNS {
+		p.DNSServers = mmsContext.GetDomainNameServers()
+	}
+	if profile, ok := mediator.carrierProfile(); ok {
+		if profile.UserAgent != "" {
+			p.UserAgent = profile.UserAgent
+		}
+		if profile.UAProf != "" {
+			p.UAProf = profile.UAProf
+		}
+		p.UAProfHeader = profile.UAProfHeader
+		p.Redirects = mms.RedirectPolicy(profile.Redirects)
+		p.CACertificates, p.CertificatePins = profile.CACertificates, profile.CertificatePins
+	}
+	return p
+}
+
+// transferProgress returns the progress of the download or upload of the
+// message with uuid, which sets its Progress property.
+func (mediator *Mediator) transferProgress(uuid string) mms.Progress {
+	return func(transferred, total uint64) {
+		if err := mediator.telepathyService.MessageProgress(uuid, transferred, total); err != nil {
+			logger.Debugf("Cannot signal the progress of %s: %v", uuid, err)
+		}
+	}
+}
+
+// getMessageCenter returns the MMSC to use with mmsContext, an MMSC forced by
+// the carrier overrides takes precedence over the one provisioned in ofono.
+func (mediator *Mediator) getMessageCenter(mmsContext ofono.OfonoContext) (string, error) {
+	if profile, ok := mediator.carrierProfile(); ok && profile.MessageCenter != "" {
+		logger.Infof("Using MMSC %s from carrier overrides for %s", profile.MessageCenter, profile)
+		return profile.MessageCenter, nil
+	}
+	return mmsContext.GetMessageCenter()
+}
+
+// mmsEnabled returns false if the user of the active session turned MMS off
+// in the phone settings.
+func mmsEnabled() bool {
+	return accountsMonitor.Enabled()
 }
 
 func (mediator *Mediator) initializeMessages(modemId string) {
 	historyService := mediator.telepathyService.HistoryService()
 	handledTransactions := map[string]string{}
-	uuids := storage.GetStoredUUIDs()
-	log.Printf("Initializing %d messages from storage", len(uuids))
+	// Housekeeping. Delete all old stored incoming messages, which are missing the ModemId.
+	modems.cleanupUnassigned(mediator.storage)
+	// Sent and cancelled messages need no handling.
+	uuids := mediator.storage.GetModemUUIDs(modemId, storage.DRAFT, storage.SEND_PENDING, storage.SENDING, storage.FAILED, storage.NOTIFICATION, storage.DOWNLOADED, storage.RECEIVED, storage.RESPONDED)
+	logger.Infof("Initializing %d messages of modem %s from storage", len(uuids), modemId)
 	for _, uuid := range uuids {
-		mmsState, err := storage.GetMMSState(uuid)
+		mmsState, err := mediator.storage.GetMMSState(uuid)
 		if err != nil {
-			log.Printf("Error checking state of message stored under UUID: %s : %v", uuid, err)
-			if err := storage.Destroy(uuid); err != nil {
-				log.Printf("Error destroying faulty message: %v", err)
+			logger.Errorf("Error checking state of message stored under UUID: %s : %v", uuid, err)
+			if err := mediator.storage.Destroy(uuid); err != nil {
+				logger.Errorf("Error destroying faulty message: %v", err)
 			}
 			continue
 		}
 
-		if !mmsState.IsIncoming() {
-			log.Printf("Message %s is not an incoming message. State: %s", uuid, mmsState.State)
+		if mmsState.State == storage.DRAFT || mmsState.State == storage.SEND_PENDING || mmsState.State == storage.SENDING {
+			logger.Infof("Resuming %s send of message %s", mmsState.State, uuid)
+			mediator.resumeSend(mmsState, uuid)
 			continue
 		}
-
-		// Housekeeping. Delete all old stored incoming messages, which are missing the ModemId.
-		if mmsState.ModemId == "" {
-			log.Printf("Message %s is an old incoming message with state %s, no need to store, deleting", uuid, mmsState.State)
-			if err := storage.Destroy(uuid); err != nil {
-				log.Printf("Error destroying old message: %v", err)
-			}
+		if mmsState.State == storage.FAILED {
+			mediator.restoreFailedSend(uuid)
 			continue
 		}
 
-		if modemId != mmsState.ModemId {
+		if !mmsState.IsIncoming() {
+			logger.Infof("Message %s is not an incoming message. State: %s", uuid, mmsState.State)
 			continue
 		}
+
 		// Just log any irregularities here.
 		if mmsState.MNotificationInd == nil {
-			log.Printf("Stored message doesn't contain MNotificationInd, can't do anything with it, deleting")
-			if err := storage.Destroy(uuid); err != nil {
-				log.Printf("Error destroying faulty message: %v", err)
+			logger.Errorf("Stored message doesn't contain MNotificationInd, can't do anything with it, deleting")
+			if err := mediator.storage.Destroy(uuid); err != nil {
+				logger.Errorf("Error destroying faulty message: %v", err)
 			}
 			continue
 		}
 		if mmsState.MNotificationInd.TransactionId == "" {
-			log.Printf("Stored message's MNotificationInd's TransactionId is empty")
+			logger.Infof("Stored message's MNotificationInd's TransactionId is empty")
 		}
 
 		if mmsState.MNotificationInd.TransactionId != "" {
 			if _, ok := handledTransactions[mmsState.MNotificationInd.TransactionId]; ok {
 				// TransactionId was already handled. This message is duplicate and obsolete. Delete and handle next.
-				log.Printf("Message %s is an duplicate incoming message with transaction ID %s that was already handled, no need to store, deleting", uuid, mmsState.MNotificationInd.TransactionId)
-				if err := storage.Destroy(uuid); err != nil {
-					log.Printf("Error destroying duplicate message: %v", err)
+				logger.Warnf("Message %s is an duplicate incoming message with transaction ID %s that was already handled, no need to store, deleting", uuid, mmsState.MNotificationInd.TransactionId)
+				if err := mediator.storage.Destroy(uuid); err != nil {
+					logger.Errorf("Error destroying duplicate message: %v", err)
 				}
 				continue
 			}
 			// Mark TransactionId as handled, to not handle possible messages with the same TransactionId.
 			handledTransactions[mmsState.MNotificationInd.TransactionId] = uuid
-			// Add to unresponded, to not communicate possible error to telepathy again, on possible message notification from MMS center.
-			mediator.unrespondedTransactions[mmsState.MNotificationInd.TransactionId] = uuid
 		}
 
 		checkExpiredAndHandle := func() bool {
@@ -723,11 +1999,11 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 			}
 
 			// MNotificationInd is expired, destroy in storage & notify telepathy service.
-			if err := storage.Destroy(uuid); err != nil {
-				log.Printf("Error destroying expired message: %v", err)
+			if err := mediator.storage.Destroy(uuid); err != nil {
+				logger.Errorf("Error destroying expired message: %v", err)
 			}
 			if err := mediator.telepathyService.SingnalMessageRemoved(mediator.telepathyService.GenMessagePath(uuid)); err != nil {
-				log.Printf("Error sending signal that message was removed: %v", err)
+				logger.Errorf("Error sending signal that message was removed: %v", err)
 			}
 			return true
 		}
@@ -739,9 +2015,24 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 			// Message download failed, error was probably communicated to telepathy.
 			// It is now up to user to initiate redownload or there is a possibility, that a new notification with the same TransactionId arrives from MMS center.
 
+			if mmsState.RejectPending {
+				// The user deleted the message while the modem was offline, it is not on the bus anymore.
+				if mmsState.MNotificationInd.Expired() {
+					if err := mediator.storage.Destroy(uuid); err != nil {
+						logger.Errorf("Error destroying expired message: %v", err)
+					}
+					break
+				}
+				logger.Infof("Resuming rejection of message %s", uuid)
+				go mediator.handleRejectedMNotificationInd(mmsState.MNotificationInd)
+				break
+			}
+
 			if mmsState.TelepathyErrorNotified == false { // Telepathy service wasn't notified of the download error.
 				// Handle as new MNotificationInd and send to NewMNotificationInd channel.
+				// This is a background retry, don't drain a critical battery with it.
 				go func() {
+					powerMonitor.WaitBackground()
 					mediator.NewMNotificationInd <- mmsState.MNotificationInd
 				}()
 				break
@@ -749,7 +2040,7 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 				if checkExpiredAndHandle() {
 					// Message is expired (and was deleted from storage), don't continue.
 					// Remove from unrespondedTransactions.
-					delete(mediator.unrespondedTransactions, mmsState.MNotificationInd.TransactionId)
+					mediator.unrespondedTransactions.remove(mmsState.MNotificationInd.TransactionId)
 					break
 				}
 
@@ -764,11 +2055,11 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 			// Try to forward the downloaded and stored message to telepathy again.
 			mRetrieveConf, err := mediator.getAndHandleMRetrieveConf(mmsState.MNotificationInd)
 			if err != nil {
-				log.Printf("Handling MRetrieveConf error: %v", err)
+				logger.Errorf("Handling MRetrieveConf error: %v", err)
 			} else {
 				// Update message state in storage to RECEIVED.
-				if mmsState, err = storage.UpdateReceived(mRetrieveConf.UUID); err != nil {
-					log.Println("Error updating storage (UpdateReceived): ", err)
+				if mmsState, err = mediator.storage.UpdateReceived(mRetrieveConf.UUID); err != nil {
+					logger.Error("Error updating storage (UpdateReceived):  ", err)
 				} else {
 					// Message was forwarded to telepathy and state in storage was updated.
 					forwardedUpdated = true
@@ -796,11 +2087,11 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 
 			respondedUpdated := false
 			if respondErr != nil {
-				log.Printf("Error responding to MMS center: %s", err)
+				logger.Errorf("Error responding to MMS center: %s", err)
 			} else {
 				// Store that message was responded.
-				if mmsState, err = storage.UpdateResponded(mmsState.MNotificationInd.UUID); err != nil {
-					log.Println("Error updating storage (UpdateResponded): ", err)
+				if mmsState, err = mediator.storage.UpdateResponded(mmsState.MNotificationInd.UUID); err != nil {
+					logger.Error("Error updating storage (UpdateResponded):  ", err)
 				} else {
 					respondedUpdated = true
 				}
@@ -816,31 +2107,37 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 			// Message download was successful, the message was decoded and forwarded to telepathy and MMS center was notified.
 
 			// Remove from unrespondedTransactions.
-			delete(mediator.unrespondedTransactions, mmsState.MNotificationInd.TransactionId)
+			mediator.unrespondedTransactions.remove(mmsState.MNotificationInd.TransactionId)
+
+			if mmsState.Quarantined {
+				// Quarantined messages are never in the history service, keep them stored.
+				logger.Infof("Message %s is quarantined: %s", uuid, mmsState.QuarantineReason)
+				break
+			}
 
 			if checkInHistoryService {
 				// Get message from history service and if read or not exist, delete and don't spawn handlers.
 				eventId := string(mediator.telepathyService.GenMessagePath(uuid))
 				hsMessage, err := historyService.GetMessage(eventId)
 				if err != nil {
-					log.Printf("Error getting message %s from HistoryService: %v", eventId, err)
+					logger.Errorf("Error getting message %s from HistoryService: %v", eventId, err)
 				} else {
 					// If message is doesn't exist, break (don't spawn handlers).
 					if !hsMessage.Exists() {
-						log.Printf("Message %s doesn't exist in HistoryService, no need to store, deleting.", uuid)
-						if err := storage.Destroy(uuid); err != nil {
-							log.Printf("Error destroying message: %v", err)
+						logger.Infof("Message %s doesn't exist in HistoryService, no need to store, deleting.", uuid)
+						if err := mediator.storage.Destroy(uuid); err != nil {
+							logger.Errorf("Error destroying message: %v", err)
 						}
 						break
 					}
 
 					// If message is marked as read (is not new), break (don't spawn handlers).
 					if isnew, err := hsMessage.IsNew(); err != nil {
-						log.Printf("Error checking if message is new in HistoryService: %s", err)
+						logger.Errorf("Error checking if message is new in HistoryService: %s", err)
 					} else if isnew == false {
-						log.Printf("Message %s is marked as read in HistoryService, no need to store, deleting.", uuid)
-						if err := storage.Destroy(uuid); err != nil {
-							log.Printf("Error destroying message: %v", err)
+						logger.Infof("Message %s is marked as read in HistoryService, no need to store, deleting.", uuid)
+						if err := mediator.storage.Destroy(uuid); err != nil {
+							logger.Errorf("Error destroying message: %v", err)
 						}
 						break
 					}
@@ -850,14 +2147,14 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 			startTelepathyHandlers = true
 
 		default:
-			log.Printf("Unknown MMSState.State: %s", mmsState.State)
+			logger.Warnf("Unknown MMSState.State: %s", mmsState.State)
 			break
 		}
 
-		if startTelepathyHandlers {
+		if startTelepathyHandlers && !mmsState.Quarantined {
 			mRetrieveConf, _ := mediator.getMRetrieveConf(uuid)
 			if err := mediator.telepathyService.InitializationMessageAdded(mRetrieveConf, mmsState.MNotificationInd); err != nil {
-				log.Printf("Error adding initialization message for message %s: %v", uuid, err)
+				logger.Errorf("Error adding initialization message for message %s: %v", uuid, err)
 			}
 		}
 	}
@@ -866,16 +2163,17 @@ func (mediator *Mediator) initializeMessages(modemId string) {
 
 // Responds to MMS center, that message was successfully downloaded.
 func (mediator *Mediator) respondMessage(mmsState storage.MMSState) error {
-	mediator.contextLock.Lock()
-	defer mediator.contextLock.Unlock()
+	defer mediator.beginTransaction(false)()
 
 	mRetrieveConf, err := mediator.getMRetrieveConf(mmsState.MNotificationInd.UUID)
 	if err != nil {
 		return err
 	}
 	// Notify MMS center about successful download.
-	mNotifyRespInd := mRetrieveConf.NewMNotifyRespInd(useDeliveryReports)
-	if !mmsState.MNotificationInd.IsDebug() {
+	mNotifyRespInd := mRetrieveConf.NewMNotifyRespInd(settings.Get().UseDeliveryReports)
+	if mmsState.MNotificationInd.FromMMBox {
+		return nil
+	} else if !mmsState.MNotificationInd.IsDebug() {
 		mmsContext, deactivateMMSContext, err := mediator.activateMMSContext()
 		if err != nil {
 			return fmt.Errorf("error activating ofono context: %w", err)
@@ -888,14 +2186,14 @@ func (mediator *Mediator) respondMessage(mmsState storage.MMSState) error {
 		if filePath == "" {
 			return fmt.Errorf("Getting file for m-notifyresp.ind failed")
 		}
-		if err := mediator.sendMNotifyRespInd(filePath, &mmsContext); err != nil {
+		if err := mediator.sendMNotifyRespInd(mmsState.MNotificationInd.UUID, filePath, &mmsContext); err != nil {
 			return fmt.Errorf("error sending m-notifyresp.ind: %w", err)
 		}
 	} else {
-		log.Print("This is a local test, skipping m-notifyresp.ind")
+		logger.Info("This is a local test, skipping m-notifyresp.ind")
 		if err := mmsState.MNotificationInd.PopDebugError(mms.DebugErrorRespondHandle); err != nil {
-			log.Printf("Forcing debug error: %#v", err)
-			storage.UpdateMNotificationInd(mmsState.MNotificationInd)
+			logger.Errorf("Forcing debug error: %#v", err)
+			mediator.storage.UpdateMNotificationInd(mmsState.MNotificationInd)
 			return err
 		}
 	}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/network"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/policy"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+	"launchpad.net/go-xdg/v0"
+)
+
+func TestReleaseDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+
+	var held []string
+	for _, modemId := range []string{"modem", "other"} {
+		mNotificationInd := mms.NewMNotificationInd(time.Now())
+		if _, err := store.Create(modemId, mNotificationInd); err != nil {
+			t.Fatal(err)
+		}
+		mediator.holdDisabled(mNotificationInd)
+		held = append(held, mNotificationInd.UUID)
+	}
+
+	go mediator.releaseDisabled("modem")
+	select {
+	case mNotificationInd := <-mediator.NewMNotificationInd:
+		if mNotificationInd.UUID != held[0] {
+			t.Errorf("released %s, want %s", mNotificationInd.UUID, held[0])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message was released")
+	}
+	if state, _ := store.GetMMSState(held[0]); state.State != storage.NOTIFICATION {
+		t.Errorf("released message is %s, want %s", state.State, storage.NOTIFICATION)
+	}
+	if state, _ := store.GetMMSState(held[1]); state.State != storage.DISABLED {
+		t.Errorf("message of another modem is %s, want %s", state.State, storage.DISABLED)
+	}
+}
+
+func TestDeferReason(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Only the download policies of the test apply.
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	if err := policy.Save(policy.Policies{"+34600000001": policy.Defer}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if path, err := xdg.Config.Find(policy.UserPath); err == nil {
+			os.Remove(path)
+		}
+	}()
+	defer func(previous *config.Store) { settings = previous }(settings)
+	defer func(previous *network.Monitor) { networkMonitor = previous }(networkMonitor)
+	networkMonitor = network.NewMonitor(nil)
+
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, storage.NewMemory(dir))
+	service := &telepathy.MMSService{}
+
+	testCases := []struct {
+		name     string
+		from     string
+		size     uint64
+		priority byte
+		// metered is the NMMetered state of the connection and
+		// meteredLimit the MeteredDownloadLimit.
+		metered      uint32
+		meteredLimit uint64
+		// noService is true for pushes while no service is registered.
+		noService bool
+		deferred  bool
+	}{
+		{"below the limit", "+34600000000", 500, mms.PriorityNormal, network.MeteredNo, 0, false, false},
+		{"over the limit", "+34600000000", 5000, mms.PriorityNormal, network.MeteredNo, 0, false, true},
+		{"high priority over the limit", "+34600000000", 5000, mms.PriorityHigh, network.MeteredNo, 0, false, false},
+		{"deferred sender", "+34600000001", 500, mms.PriorityNormal, network.MeteredNo, 0, false, true},
+		{"high priority from a deferred sender", "+34600000001", 500, mms.PriorityHigh, network.MeteredNo, 0, false, false},
+		{"over the limit without a service", "+34600000000", 5000, mms.PriorityNormal, network.MeteredNo, 0, true, false},
+		{"metered below the metered limit", "+34600000000", 200, mms.PriorityNormal, network.MeteredGuessYes, 300, false, false},
+		{"metered over the metered limit", "+34600000000", 500, mms.PriorityNormal, network.MeteredGuessYes, 300, false, true},
+		{"high priority metered over the metered limit", "+34600000000", 500, mms.PriorityHigh, network.MeteredGuessYes, 300, false, false},
+		{"data saver below the metered limit", "+34600000000", 200, mms.PriorityNormal, network.MeteredYes, 300, false, false},
+		{"data saver", "+34600000000", 200, mms.PriorityNormal, network.MeteredYes, 0, false, true},
+		{"high priority with data saver", "+34600000000", 200, mms.PriorityHigh, network.MeteredYes, 0, false, false},
+		{"guessed metered without a metered limit", "+34600000000", 200, mms.PriorityNormal, network.MeteredGuessYes, 0, false, false},
+	}
+	for _, tc := range testCases {
+		limits := config.Defaults
+		limits.AutoDownloadLimit = 1000
+		limits.MeteredDownloadLimit = tc.meteredLimit
+		settings = config.NewStore(limits)
+		networkMonitor.SetMetered(tc.metered)
+		mediator.telepathyService = service
+		if tc.noService {
+			mediator.telepathyService = nil
+		}
+		mNotificationInd := mms.NewMNotificationInd(time.Now())
+		mNotificationInd.From = tc.from + "/TYPE=PLMN"
+		mNotificationInd.Size = tc.size
+		mNotificationInd.Priority = tc.priority
+		if reason, _ := mediator.deferReason(mNotificationInd); (reason != "") != tc.deferred {
+			t.Errorf("%s: deferReason = %q, want deferred %v", tc.name, reason, tc.deferred)
+		}
+	}
+}
+
+// TestHandleMDeliveryIndWithoutService delivers an m-delivery.ind while no
+// telepathy service is registered, the report is only stored.
+func TestHandleMDeliveryIndWithoutService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+
+	uuid := mms.GenUUID()
+	f, err := store.CreateSendFile("modem", uuid, []string{"+1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := store.UpdateSent(uuid, "msg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	mediator.handleMDeliveryInd([]byte{
+		// X-Mms-Message-Type: m-delivery-ind
+		0x8c, 0x86,
+		// X-Mms-MMS-Version: 1.2
+		0x8d, 0x92,
+		// Message-ID: msg1
+		0x8b, 'm', 's', 'g', '1', 0x00,
+		// To: +1234/TYPE=PLMN
+		0x97, '+', '1', '2', '3', '4', '/', 'T', 'Y', 'P', 'E', '=', 'P', 'L', 'M', 'N', 0x00,
+		// Date: 1075445393
+		0x85, 0x04, 0x40, 0x19, 0xfe, 0x91,
+		// X-Mms-Status: Retrieved
+		0x95, 0x81,
+	})
+	state, err := store.GetMMSState(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := state.SendState["+1234"]; status != storage.RETRIEVED {
+		t.Errorf("send state of +1234 is %q, want %q", status, storage.RETRIEVED)
+	}
+}
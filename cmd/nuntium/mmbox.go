@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// handleMMBoxRequest runs the MMBox transaction of a MMBoxStore or MMBoxView
+// call and answers it.
+func (mediator *Mediator) handleMMBoxRequest(request *telepathy.MMBoxRequest) {
+	service := mediator.telepathyService
+	if service == nil {
+		return
+	}
+	if request.IsView() {
+		descriptions, total, err := mediator.viewMMBox(request)
+		if err != nil {
+			logger.Errorf("Cannot list the MMBox: %v", err)
+		}
+		if err := service.ReplyMMBoxView(request, descriptions, total, err); err != nil {
+			logger.Error("Could not send reply: ", err)
+		}
+		return
+	}
+	location, err := mediator.storeInMMBox(request)
+	if err != nil {
+		logger.Errorf("Cannot store %s in the MMBox: %v", request.ContentLocation, err)
+	}
+	if err := service.ReplyMMBoxStore(request, location, err); err != nil {
+		logger.Error("Could not send reply: ", err)
+	}
+}
+
+// storeInMMBox asks the MMS center to keep the message of request in the
+// MMBox and returns its location there.
+func (mediator *Mediator) storeInMMBox(request *telepathy.MMBoxRequest) (string, error) {
+	mMboxStoreConf := mms.NewMMboxStoreConf()
+	if err := mediator.exchangePDU(mms.NewMMboxStoreReq(request.ContentLocation, request.State), mMboxStoreConf); err != nil {
+		return "", err
+	}
+	if err := mMboxStoreConf.StoreError(); err != nil {
+		return "", err
+	}
+	// The message is usually stored where it is.
+	if mMboxStoreConf.ContentLocation == "" {
+		return request.ContentLocation, nil
+	}
+	return mMboxStoreConf.ContentLocation, nil
+}
+
+// viewMMBox asks the MMS center for the messages in the MMBox request
+// selects. It returns their descriptions and the number of messages in the
+// MMBox, or of those listed if the MMS center does not tell.
+func (mediator *Mediator) viewMMBox(request *telepathy.MMBoxRequest) ([]*mms.MMboxDescr, uint64, error) {
+	mMboxViewConf := mms.NewMMboxViewConf()
+	if err := mediator.exchangePDU(mms.NewMMboxViewReq(request.Start, request.Limit, request.States), mMboxViewConf); err != nil {
+		return nil, 0, err
+	}
+	if err := mMboxViewConf.ResponseError(); err != nil {
+		return nil, 0, err
+	}
+	descriptions, err := mMboxViewConf.Descriptions()
+	if err != nil {
+		return nil, 0, err
+	}
+	total := mMboxViewConf.MessageTotal
+	if total == 0 {
+		total = uint64(len(descriptions))
+	}
+	return descriptions, total, nil
+}
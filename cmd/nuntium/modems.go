@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// modems coordinates the mediators of all modems, which share the storage.
+var modems = newModemRouter()
+
+// modemRouter keeps a mediator per modem and makes sure every modem identity,
+// i.e. SIM, is served by a single mediator at a time.
+type modemRouter struct {
+	lock       sync.Mutex
+	mediators  map[dbus.ObjectPath]*Mediator
+	identities map[string]*Mediator
+	cleanup    sync.Once
+}
+
+func newModemRouter() *modemRouter {
+	return &modemRouter{
+		mediators:  make(map[dbus.ObjectPath]*Mediator),
+		identities: make(map[string]*Mediator),
+	}
+}
+
+// add starts a mediator for modem, which keeps its messages in store.
+func (router *modemRouter) add(ctx context.Context, modem *ofono.Modem, mmsManager *telepathy.MMSManager, store storage.Storage) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	if _, ok := router.mediators[modem.Modem]; ok {
+		logger.Warnf("Modem %s was already added", modem.Modem)
+		return
+	}
+	mediator := NewMediator(ctx, modem, store)
+	router.mediators[modem.Modem] = mediator
+	go mediator.init(mmsManager)
+}
+
+// remove stops the mediator of modem.
+func (router *modemRouter) remove(modem *ofono.Modem) {
+	router.lock.Lock()
+	mediator, ok := router.mediators[modem.Modem]
+	delete(router.mediators, modem.Modem)
+	for identity, m := range router.identities {
+		if m == mediator {
+			delete(router.identities, identity)
+		}
+	}
+	router.lock.Unlock()
+	if !ok {
+		logger.Infof("Modem %s was not added", modem.Modem)
+		return
+	}
+	mediator.Delete()
+}
+
+// claim makes mediator the one serving the modem identity. It returns false if
+// another mediator serves it already.
+func (router *modemRouter) claim(mediator *Mediator, identity string) bool {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	if m, ok := router.identities[identity]; ok && m != mediator {
+		return false
+	}
+	router.identities[identity] = mediator
+	return true
+}
+
+// release ends serving the modem identity by mediator.
+func (router *modemRouter) release(mediator *Mediator, identity string) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	if router.identities[identity] == mediator {
+		delete(router.identities, identity)
+	}
+}
+
+// cleanupUnassigned deletes stored incoming messages which belong to no
+// modem, they were stored by old versions or cannot be read. It runs once, for
+// the first modem identity which shows up.
+func (router *modemRouter) cleanupUnassigned(store storage.Storage) {
+	router.cleanup.Do(func() {
+		for _, uuid := range store.GetModemUUIDs("") {
+			mmsState, err := store.GetMMSState(uuid)
+			if err == nil && !mmsState.IsIncoming() {
+				continue
+			}
+			if err != nil {
+				logger.Errorf("Error checking state of message stored under UUID: %s : %v", uuid, err)
+			} else {
+				logger.Infof("Message %s is an old incoming message with state %s, no need to store, deleting", uuid, mmsState.State)
+			}
+			if err := store.Destroy(uuid); err != nil {
+				logger.Errorf("Error destroying message: %v", err)
+			}
+		}
+	})
+}
+
+// transactionTable maps the transaction ids of messages not yet acknowledged
+// to the MMS center to their UUIDs. They are kept in storage, so they survive
+// restarts, and are removed with their messages.
+type transactionTable struct {
+	storage storage.Storage // holds the messages and their transactions
+}
+
+func newTransactionTable(store storage.Storage) *transactionTable {
+	return &transactionTable{storage: store}
+}
+
+func (table *transactionTable) get(transactionId string) (string, bool) {
+	uuid, err := table.storage.GetTransaction(transactionId)
+	if err != nil {
+		logger.Errorf("Cannot look up transaction %s: %v", transactionId, err)
+	}
+	return uuid, uuid != ""
+}
+
+func (table *transactionTable) set(transactionId, uuid string) {
+	if err := table.storage.SetTransaction(transactionId, uuid); err != nil {
+		logger.Errorf("Cannot store transaction %s of message %s: %v", transactionId, uuid, err)
+	}
+}
+
+func (table *transactionTable) remove(transactionId string) {
+	if err := table.storage.RemoveTransaction(transactionId); err != nil {
+		logger.Errorf("Cannot remove transaction %s: %v", transactionId, err)
+	}
+}
+
+// snapshot returns the transactions of the messages of modemId.
+func (table *transactionTable) snapshot(modemId string) map[string]string {
+	transactions, err := table.storage.GetTransactions(modemId)
+	if err != nil {
+		logger.Errorf("Cannot look up transactions of modem %s: %v", modemId, err)
+	}
+	return transactions
+}
+
+// track maps transactionId to uuid unless it maps to a message which is
+// still stored.
+func (table *transactionTable) track(transactionId, uuid string) {
+	// This is not an error and happens after redownload is triggered by user.
+	// In MMSService if the redownload request is handled, the listeners for old message are closed and the message gets deleted from storage.
+	// If this happens, the UUID for this transaction is replaced.
+	if _, err := table.storage.TrackTransaction(transactionId, uuid); err != nil {
+		logger.Errorf("Cannot track transaction %s of message %s: %v", transactionId, uuid, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestModemRouterClaim(t *testing.T) {
+	router := newModemRouter()
+	first, second := &Mediator{}, &Mediator{}
+	if !router.claim(first, "sim") {
+		t.Fatal("claim of a free identity failed")
+	}
+	if !router.claim(first, "sim") {
+		t.Error("claim of an identity served by the same mediator failed")
+	}
+	if router.claim(second, "sim") {
+		t.Error("claim of an identity served by another mediator succeeded")
+	}
+	router.release(second, "sim")
+	if router.claim(second, "sim") {
+		t.Error("release by another mediator freed the identity")
+	}
+	router.release(first, "sim")
+	if !router.claim(second, "sim") {
+		t.Error("claim of a released identity failed")
+	}
+}
+
+func TestTransactionTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	for _, uuid := range []string{"stored", "other"} {
+		if _, err := store.Create("sim", &mms.MNotificationInd{UUID: uuid, TransactionId: "t1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table := newTransactionTable(store)
+	table.set("t1", "stored")
+	table.set("t2", "gone")
+	table.track("t1", "other")
+	if uuid, _ := table.get("t1"); uuid != "stored" {
+		t.Errorf("track replaced a stored message by %s", uuid)
+	}
+	table.track("t2", "other")
+	if uuid, _ := table.get("t2"); uuid != "other" {
+		t.Errorf("track kept the message %s which is not stored", uuid)
+	}
+	if transactions := table.snapshot("sim"); len(transactions) != 2 {
+		t.Errorf("snapshot = %v", transactions)
+	}
+
+	// The transactions survive the mediator, e.g. a restart.
+	if uuid, ok := newTransactionTable(store).get("t1"); !ok || uuid != "stored" {
+		t.Errorf("stored transaction = %q, %v", uuid, ok)
+	}
+	if err := store.Destroy("stored"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := table.get("t1"); ok {
+		t.Error("transaction of a destroyed message was kept")
+	}
+	table.remove("t2")
+	if _, ok := table.get("t2"); ok {
+		t.Error("removed transaction was kept")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// pushHandler handles a WAP push received on the modem modemId which does
+// not carry an MMS PDU. service is the telepathy service of the modem, it is
+// nil while MMS is not available on it.
+type pushHandler func(service *telepathy.MMSService, modemId string, push *ofono.PushPDU)
+
+var (
+	pushHandlersMutex sync.RWMutex
+	// pushHandlers are the handlers of WAP pushes by content type. Pushes
+	// of other types are signaled by signalPush.
+	pushHandlers = map[string]pushHandler{}
+)
+
+// registerPushHandler makes handler handle the WAP pushes of contentType
+// instead of the handler registered for it before, if any. A nil handler
+// restores signalPush.
+func registerPushHandler(contentType string, handler pushHandler) {
+	pushHandlersMutex.Lock()
+	defer pushHandlersMutex.Unlock()
+	if handler == nil {
+		delete(pushHandlers, pushContentType(contentType))
+	} else {
+		pushHandlers[pushContentType(contentType)] = handler
+	}
+}
+
+// lookupPushHandler returns the handler of WAP pushes of contentType.
+func lookupPushHandler(contentType string) pushHandler {
+	pushHandlersMutex.RLock()
+	defer pushHandlersMutex.RUnlock()
+	if handler, ok := pushHandlers[pushContentType(contentType)]; ok {
+		return handler
+	}
+	return signalPush
+}
+
+// pushContentType returns the media type of contentType without
+// parameters, in lower case.
+func pushContentType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+}
+
+// signalPush passes the push on to the clients of service with a
+// PushReceived signal, so e.g. service indications, service loading and
+// provisioning documents are not lost.
+func signalPush(service *telepathy.MMSService, modemId string, push *ofono.PushPDU) {
+	if service == nil {
+		logger.Warnf("Dropping %s push on modem %s without MMS service", push.ContentType, modemId)
+		return
+	}
+	if err := service.PushReceived(push.ContentType, push.ApplicationId, push.Data); err != nil {
+		logger.Errorf("Cannot signal %s push on modem %s: %v", push.ContentType, modemId, err)
+	}
+}
+
+// handlePush dispatches a WAP push which does not carry an MMS PDU to its
+// handler.
+func (mediator *Mediator) handlePush(service *telepathy.MMSService, push *ofono.PushPDU) {
+	modemId := mediator.modem.Identity()
+	logger.Infow("Received push", "modem", modemId, "contentType", push.ContentType, "applicationId", push.ApplicationId)
+	if mediator.spamPush(push) {
+		return
+	}
+	lookupPushHandler(push.ContentType)(service, modemId, push)
+}
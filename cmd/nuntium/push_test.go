@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+func TestLookupPushHandler(t *testing.T) {
+	var handled []string
+	handler := func(service *telepathy.MMSService, modemId string, push *ofono.PushPDU) {
+		handled = append(handled, modemId+" "+push.ContentType)
+	}
+	registerPushHandler(ofono.VND_WAP_SIC, handler)
+	defer registerPushHandler(ofono.VND_WAP_SIC, nil)
+
+	lookupPushHandler("Application/vnd.wap.sic; charset=utf-8")(nil, "modem", &ofono.PushPDU{ContentType: ofono.VND_WAP_SIC})
+	if want := []string{"modem " + ofono.VND_WAP_SIC}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+	if h := lookupPushHandler(ofono.VND_WAP_SLC); reflect.ValueOf(h).Pointer() != reflect.ValueOf(signalPush).Pointer() {
+		t.Error("SL push is not signaled")
+	}
+	registerPushHandler(ofono.VND_WAP_SIC, nil)
+	if h := lookupPushHandler(ofono.VND_WAP_SIC); reflect.ValueOf(h).Pointer() != reflect.ValueOf(signalPush).Pointer() {
+		t.Error("SI push is not signaled after removing its handler")
+	}
+	// Without service the push is dropped.
+	signalPush(nil, "modem", &ofono.PushPDU{ContentType: ofono.VND_WAP_SLC})
+}
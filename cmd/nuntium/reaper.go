@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ubports/nuntium/storage"
+)
+
+// reapExpired removes expired messages which were not downloaded every
+// ExpiryScanInterval, until the mediator stops. Otherwise they would only be
+// removed when nuntium starts.
+func (mediator *Mediator) reapExpired() {
+	for {
+		changed := settings.Changed()
+		var scan <-chan time.Time
+		if interval := settings.Get().ExpiryScanIntervalDuration(); interval > 0 {
+			scan = time.After(interval)
+		}
+		select {
+		case <-mediator.ctx.Done():
+			return
+		case <-changed:
+			// Pick up a new interval.
+			continue
+		case <-scan:
+		}
+		// This is background work, don't drain a critical battery with it.
+		powerMonitor.WaitBackground()
+		mediator.reap()
+	}
+}
+
+// reap removes the expired messages of the modem which were not downloaded,
+// with their transactions.
+func (mediator *Mediator) reap() {
+	service := mediator.telepathyService
+	if service == nil {
+		return
+	}
+	for _, uuid := range mediator.storage.GetModemUUIDs(mediator.modem.Identity(), storage.NOTIFICATION) {
+		mmsState, err := mediator.storage.GetMMSState(uuid)
+		if err != nil || mmsState.State != storage.NOTIFICATION || mmsState.MNotificationInd == nil || !mmsState.MNotificationInd.Expired() {
+			continue
+		}
+		if mediator.inTransaction(uuid) {
+			// Let the download in progress finish or fail by itself.
+			continue
+		}
+		logger.Infof("Message %s expired before it was downloaded, removing it", uuid)
+		if err := service.MessageRemoved(service.GenMessagePath(uuid)); err != nil {
+			// Telepathy has no handler for it, e.g. it was just stored.
+			if err := mediator.storage.Destroy(uuid); err != nil {
+				logger.Errorf("Error destroying expired message: %v", err)
+			}
+			if mmsState.TelepathyErrorNotified {
+				if err := service.SingnalMessageRemoved(service.GenMessagePath(uuid)); err != nil {
+					logger.Errorf("Error sending signal that message was removed: %v", err)
+				}
+			}
+		}
+	}
+}
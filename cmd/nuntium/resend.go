@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// resend sends the outgoing message uuid, which failed with a transient
+// error, again with its stored m-send.req. Its attempts start over.
+func (mediator *Mediator) resend(uuid string) {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil {
+		logger.Errorf("Cannot resend message %s: %v", uuid, err)
+		return
+	}
+	if mmsState.State != storage.FAILED {
+		logger.Errorf("Cannot resend message %s in %s state", uuid, mmsState.State)
+		return
+	}
+	mSendReqFile, err := mediator.storage.GetSendFile(uuid)
+	if err != nil {
+		logger.Errorf("Cannot resend message %s: %v", uuid, err)
+		return
+	}
+	if _, err := mediator.storage.UpdateDraft(uuid); err != nil {
+		logger.Errorf("Cannot resend message %s: %v", uuid, err)
+		return
+	}
+	mediator.journal(uuid, "resend", nil)
+
+	logger.Infof("Resending message %s", uuid)
+	if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.DRAFT); err != nil {
+		logger.Error(err)
+	}
+	mediator.NewMSendReqFile <- struct{ filePath, uuid string }{mSendReqFile, uuid}
+}
+
+// restoreFailedSend adds the message interface of the outgoing message uuid,
+// which failed with a transient error before nuntium stopped, so it can be
+// resent. Messages which failed for good have no m-send.req anymore.
+func (mediator *Mediator) restoreFailedSend(uuid string) {
+	if _, err := mediator.storage.GetSendFile(uuid); err != nil {
+		return
+	}
+	mediator.telepathyService.RestoreOutgoingMessage(uuid)
+	if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/ubports/nuntium/metrics"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// maxSendRetryDelay caps the delay between send retries, which doubles with
+// every attempt.
+const maxSendRetryDelay = 30 * time.Minute
+
+// sendBackoff returns the delay before retrying a send which failed attempts
+// times, with delay before the first retry.
+func sendBackoff(attempts int, delay time.Duration) time.Duration {
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxSendRetryDelay {
+			return maxSendRetryDelay
+		}
+	}
+	return delay
+}
+
+// retrySendLater stores the message uuid as SEND_PENDING and schedules
+// another upload of mSendReqFile. It returns false if the send should fail
+// instead, because it was attempted too often or cannot be stored.
+func (mediator *Mediator) retrySendLater(mSendReqFile, uuid string) bool {
+	mmsState, err := mediator.storage.GetMMSState(uuid)
+	if err != nil {
+		logger.Warnf("Cannot retry send of %s: %v", uuid, err)
+		return false
+	}
+	if mmsState.SendAttempts+1 >= int(settings.Get().SendAttempts) {
+		logger.Infof("Giving up send of %s after %d attempts", uuid, mmsState.SendAttempts+1)
+		return false
+	}
+	if mmsState, err = mediator.storage.UpdateSendPending(uuid); err != nil {
+		logger.Warnf("Cannot retry send of %s: %v", uuid, err)
+		return false
+	}
+	metrics.Inc(metrics.SendRetries)
+	mediator.scheduleSend(mSendReqFile, uuid, mmsState.SendAttempts)
+	return true
+}
+
+// scheduleSend uploads mSendReqFile of the message uuid again once the
+// backoff for attempts failed uploads elapsed, unless the message was deleted
+// meanwhile.
+func (mediator *Mediator) scheduleSend(mSendReqFile, uuid string, attempts int) {
+	s := settings.Get()
+	delay := sendBackoff(attempts, s.SendRetryDelayDuration())
+	logger.Infof("Retrying send of %s in %s, attempt %d of %d", uuid, delay, attempts+1, s.SendAttempts)
+	go func() {
+		time.Sleep(delay)
+		// This is a background retry, don't drain a critical battery with it.
+		powerMonitor.WaitBackground()
+		if mmsState, err := mediator.storage.GetMMSState(uuid); err != nil || (mmsState.State != storage.SEND_PENDING && mmsState.State != storage.DRAFT) {
+			logger.Infof("Pending send of %s is gone", uuid)
+			return
+		}
+		mediator.NewMSendReqFile <- struct{ filePath, uuid string }{mSendReqFile, uuid}
+	}()
+}
+
+// resumeSend restores the outgoing message uuid, which was not sent yet when
+// nuntium stopped, and schedules its upload. A message which was being sent
+// may have reached the MMS center or not, it is retried like a failed upload.
+// A message which cannot be sent anymore fails.
+func (mediator *Mediator) resumeSend(mmsState storage.MMSState, uuid string) {
+	mediator.telepathyService.RestoreOutgoingMessage(uuid)
+	mSendReqFile, err := mediator.storage.GetSendFile(uuid)
+	if err != nil {
+		logger.Infof("Pending send of %s has no m-send.req: %v", uuid, err)
+		mediator.failSend(uuid, "", telepathy.PERMANENT_ERROR)
+		return
+	}
+	if mmsState.State == storage.SENDING {
+		if !mediator.retrySendLater(mSendReqFile, uuid) {
+			metrics.Inc(metrics.SendsFailed)
+			if _, err := mediator.storage.UpdateFailed(uuid); err != nil {
+				logger.Errorf("Error updating storage for failed message %s: %v", uuid, err)
+			}
+			if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
+				logger.Error(err)
+			}
+		}
+		return
+	}
+	mediator.scheduleSend(mSendReqFile, uuid, mmsState.SendAttempts)
+}
+
+// failSend stores the outgoing message uuid as FAILED, removes its
+// m-send.req and, unless status is empty, changes its status to it before
+// removing it from the bus.
+func (mediator *Mediator) failSend(uuid, mSendReqFile, status string) {
+	metrics.Inc(metrics.SendsFailed)
+	if _, err := mediator.storage.UpdateFailed(uuid); err != nil {
+		logger.Errorf("Error updating storage for failed message %s: %v", uuid, err)
+	}
+	if mSendReqFile != "" {
+		os.Remove(mSendReqFile)
+	}
+	if status != "" {
+		if err := mediator.telepathyService.MessageStatusChanged(uuid, status); err != nil {
+			logger.Error(err)
+		}
+	}
+	mediator.telepathyService.MessageDestroy(uuid)
+}
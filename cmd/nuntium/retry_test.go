@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{7, 30 * time.Minute},
+		{20, 30 * time.Minute},
+	} {
+		if delay := sendBackoff(tc.attempts, 30*time.Second); delay != tc.delay {
+			t.Errorf("sendBackoff(%d, 30s) = %s, want %s", tc.attempts, delay, tc.delay)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/telepathy"
+)
+
+// drainTimeout is how long shutting down waits for the transactions in
+// progress to end before cancelling them.
+const drainTimeout = 10 * time.Second
+
+// shutdown stops nuntium for it to exit: no more pushes are taken, the
+// transactions in progress get drainTimeout to end before the remaining ones
+// are cancelled, which leaves their messages to be resumed on the next start,
+// then the D-Bus objects are removed and the storage is closed.
+func shutdown(cancel context.CancelFunc, mmsManager *telepathy.MMSManager) {
+	logger.Info("Shutting down")
+	modems.stopPushes()
+	waitTransactions(drainTimeout)
+	// Cancelling the transactions makes the download manager stop them too.
+	cancel()
+	waitTransactions(shutdownTimeout)
+	modems.shutdown()
+	if mmsManager != nil {
+		mmsManager.Close()
+	}
+	flushStatistics()
+	if err := storage.Close(); err != nil {
+		logger.Error("Cannot close the storage: ", err)
+	}
+}
+
+// stopPushes unregisters the push agents of all modems, so no new messages
+// arrive while shutting down.
+func (router *modemRouter) stopPushes() {
+	for _, mediator := range router.all() {
+		mediator.stopPushes()
+	}
+}
+
+// shutdown stops the mediators of all modems.
+func (router *modemRouter) shutdown() {
+	for _, mediator := range router.all() {
+		mediator.Shutdown()
+	}
+}
+
+// all returns the mediators of all modems.
+func (router *modemRouter) all() []*Mediator {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	mediators := make([]*Mediator, 0, len(router.mediators))
+	for _, mediator := range router.mediators {
+		mediators = append(mediators, mediator)
+	}
+	return mediators
+}
+
+// stopPushes unregisters the push agent of the modem and keeps it from being
+// registered again.
+func (mediator *Mediator) stopPushes() {
+	mediator.pushLock.Lock()
+	mediator.pushesStopped = true
+	mediator.pushLock.Unlock()
+	if mediator.modem.PushAgent == nil {
+		return
+	}
+	if err := mediator.modem.PushAgent.Unregister(); err != nil {
+		logger.Errorf("Cannot unregister the push agent of %s: %v", mediator.modem.Modem, err)
+	}
+}
+
+func (mediator *Mediator) pushesAreStopped() bool {
+	mediator.pushLock.Lock()
+	defer mediator.pushLock.Unlock()
+	return mediator.pushesStopped
+}
+
+// Shutdown stops the loop of mediator, which removes its service from the bus
+// and deactivates the MMS context. It returns once the loop ended.
+func (mediator *Mediator) Shutdown() {
+	done := make(chan struct{})
+	select {
+	case mediator.shutdown <- done:
+		<-done
+	case <-mediator.done:
+	}
+}
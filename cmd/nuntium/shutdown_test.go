@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestMediatorShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, storage.NewMemory(dir))
+	mediator.stopPushes()
+	if !mediator.pushesAreStopped() {
+		t.Error("expected the pushes to be stopped")
+	}
+
+	// A mediator whose loop ended already does not block shutting down.
+	close(mediator.done)
+	shutDown := make(chan struct{})
+	go func() {
+		mediator.Shutdown()
+		close(shutDown)
+	}()
+	select {
+	case <-shutDown:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown blocked on a stopped mediator")
+	}
+}
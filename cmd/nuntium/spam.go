@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/metrics"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/policy"
+	"github.com/ubports/nuntium/spam"
+)
+
+// knownSender returns true if the user trusts sender, because it has an auto
+// download policy or because the user sent it a message which is still
+// stored.
+func (mediator *Mediator) knownSender(sender string) bool {
+	sender = address.Normalize(sender)
+	if sender == "" {
+		return false
+	}
+	if policies, err := policy.Load(); err != nil {
+		logger.Warn("Cannot load download policies, ignoring them: ", err)
+	} else if action, ok := policies.Lookup(sender); ok && action == policy.Auto {
+		return true
+	}
+	for _, uuid := range mediator.storage.GetStoredUUIDs() {
+		mmsState, err := mediator.storage.GetMMSState(uuid)
+		if err != nil || mmsState.IsIncoming() {
+			continue
+		}
+		if _, ok := mmsState.SendState[sender]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// spamReason returns why mRetrieveConf is filed as spam, empty if the spam
+// filter is off, the sender is known or the message does not look suspicious.
+func (mediator *Mediator) spamReason(mRetrieveConf *mms.MRetrieveConf) string {
+	if !settings.Get().SpamFilter {
+		return ""
+	}
+	reason := spam.Reason(mRetrieveConf)
+	if reason == "" || mediator.knownSender(mRetrieveConf.From) {
+		return ""
+	}
+	return reason
+}
+
+// spamPush returns true if push, which does not carry an MMS PDU, looks
+// like spam and is to be held back.
+func (mediator *Mediator) spamPush(push *ofono.PushPDU) bool {
+	if !settings.Get().SpamFilter {
+		return false
+	}
+	reason := spam.PushReason(push.ContentType)
+	if reason == "" || mediator.knownSender(push.Sender) {
+		return false
+	}
+	logger.Infof("Holding back push from %q: %s", push.Sender, reason)
+	metrics.Inc(metrics.MessagesSpam)
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+)
+
+func TestKnownSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+
+	f, err := store.CreateSendFile("modem", "out", []string{"+491701234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// Incoming messages do not make their senders known.
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+491707654321/TYPE=PLMN"
+	if _, err := store.Create("modem", mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		sender string
+		want   bool
+	}{
+		{"+491701234567/TYPE=PLMN", true},
+		{"+491701234567", true},
+		{"+491707654321/TYPE=PLMN", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		if got := mediator.knownSender(tc.sender); got != tc.want {
+			t.Errorf("knownSender(%q) = %v, want %v", tc.sender, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/ubports/nuntium/metrics"
+	"launchpad.net/go-xdg/v0"
+)
+
+// statisticsInterval is how often the statistics file is updated.
+const statisticsInterval = time.Minute
+
+// statisticsPath is the statistics file relative to the XDG data directory.
+var statisticsPath = filepath.Join("nuntium", "statistics.json")
+
+// statistics writes the statistics file.
+var statistics metrics.Writer
+
+// writeStatistics keeps the statistics file up to date while the
+// WriteStatistics setting is on.
+func writeStatistics() {
+	for {
+		time.Sleep(statisticsInterval)
+		flushStatistics()
+	}
+}
+
+// flushStatistics writes the statistics file if WriteStatistics is on and
+// the statistics changed.
+func flushStatistics() {
+	if !settings.Get().WriteStatistics {
+		return
+	}
+	if statistics.Path == "" {
+		path, err := xdg.Data.Ensure(statisticsPath)
+		if err != nil {
+			logger.Error("Cannot write statistics: ", err)
+			return
+		}
+		statistics.Path = path
+	}
+	if err := statistics.Write(); err != nil {
+		logger.Error("Cannot write statistics: ", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// transactions counts the transactions in progress over all mediators, so
+// shutting down can wait for them to be cancelled.
+var transactions sync.WaitGroup
+
+// transaction is a download or upload in progress.
+type transaction struct {
+	cancel  context.CancelFunc
+	kind    string // "download" or "upload"
+	started time.Time
+}
+
+// startTransaction registers a transaction of kind of the messages with
+// uuids, e.g. a redownload also under the message it replaces, so it can be
+// cancelled with cancelTransaction. The returned context is done once the
+// transaction is cancelled or the mediator stops, done must be called when
+// the transaction is over.
+func (mediator *Mediator) startTransaction(kind string, uuids ...string) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(mediator.ctx)
+	transactions.Add(1)
+	mediator.transactionsLock.Lock()
+	for _, uuid := range uuids {
+		mediator.transactions[uuid] = transaction{cancel: cancel, kind: kind, started: time.Now()}
+	}
+	mediator.transactionsLock.Unlock()
+	return ctx, func() {
+		mediator.transactionsLock.Lock()
+		for _, uuid := range uuids {
+			delete(mediator.transactions, uuid)
+		}
+		mediator.transactionsLock.Unlock()
+		cancel()
+		transactions.Done()
+	}
+}
+
+// cancelTransaction cancels the transaction in progress of the message uuid.
+// It returns false if there is none.
+func (mediator *Mediator) cancelTransaction(uuid string) bool {
+	mediator.transactionsLock.Lock()
+	t, ok := mediator.transactions[uuid]
+	mediator.transactionsLock.Unlock()
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
+
+// inTransaction returns true if the message uuid has a transaction in
+// progress.
+func (mediator *Mediator) inTransaction(uuid string) bool {
+	mediator.transactionsLock.Lock()
+	defer mediator.transactionsLock.Unlock()
+	_, ok := mediator.transactions[uuid]
+	return ok
+}
+
+// waitTransactions waits at most timeout for the transactions in progress to
+// end.
+func waitTransactions(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		transactions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warn("Transactions did not end in time")
+	}
+}
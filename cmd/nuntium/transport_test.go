@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/ofono"
+	"github.com/ubports/nuntium/storage"
+	"github.com/ubports/nuntium/transport/transporttest"
+)
+
+func TestDownloadThroughTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	retrieveConf, err := ioutil.ReadFile("../../mms/test_payloads/m-retrieve.conf_success")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemory(dir)
+	mediator := NewMediator(context.Background(), &ofono.Modem{Modem: "/ril_0"}, store)
+	recorder := &transporttest.Recorder{Dir: dir}
+	mediator.newTransport = recorder.New
+	recorder.Respond(transporttest.Response{Err: errors.New("MMSC unreachable")}, transporttest.Response{PDU: retrieveConf})
+
+	testCases := []struct {
+		transactionId string
+		want          string
+	}{
+		// The download fails and the message waits for a redownload.
+		{"t1", storage.NOTIFICATION},
+		// The message is downloaded, there is no telepathy to hand it on.
+		{"t2", storage.DOWNLOADED},
+	}
+	for _, tc := range testCases {
+		mNotificationInd := mms.NewMNotificationInd(time.Now())
+		// A local test, no context is activated.
+		mNotificationInd.ContentLocation = "http://localhost:9191/mms/" + tc.transactionId
+		mNotificationInd.TransactionId = tc.transactionId
+		if _, err := store.Create("modem", mNotificationInd); err != nil {
+			t.Fatal(err)
+		}
+		mediator.handleMNotificationInd(mNotificationInd)
+
+		transactions := recorder.Transactions()
+		if last := transactions[len(transactions)-1]; last.Upload || last.Location != mNotificationInd.ContentLocation {
+			t.Errorf("transport got %+v, want a download of %s", last, mNotificationInd.ContentLocation)
+		}
+		if state, err := store.GetMMSState(mNotificationInd.UUID); err != nil || state.State != tc.want {
+			t.Errorf("message %s is %s, %v, want %s", tc.transactionId, state.State, err, tc.want)
+		}
+	}
+	if n := len(recorder.Transactions()); n != 2 {
+		t.Errorf("transport got %d transactions, want 2", n)
+	}
+}
@@ -0,0 +1,414 @@
+// Package config holds the nuntium options.
+//
+// Options are read from a system wide file (SystemPath) and from a user file
+// in the XDG config directory (UserPath), the latter taking precedence on a
+// per option basis. Both are JSON objects mapping option names, the field
+// names of Settings, to values, options which are not set keep their
+// default. Options changed at runtime, e.g. over D-Bus, are stored in the
+// user file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/logging"
+	"launchpad.net/go-xdg/v0"
+)
+
+// SystemPath is the location of the system wide configuration file.
+var SystemPath = "/etc/nuntium/nuntium.conf"
+
+// UserPath is the location of the user configuration file relative to the
+// XDG config directory.
+var UserPath = filepath.Join("nuntium", "nuntium.conf")
+
+// Settings are the nuntium options.
+type Settings struct {
+	// DeferredDownload leaves messages, except priority ones, for the user
+	// to download.
+	DeferredDownload bool
+	// AutoDownloadWhileRoaming downloads messages automatically while the
+	// modem is roaming, otherwise they are left for the user to download.
+	AutoDownloadWhileRoaming bool
+	// MeteredDownloadLimit is the size in bytes above which messages are
+	// not downloaded automatically over a metered connection, 0 means no
+	// limit. With a limit, data saver only defers messages above it.
+	MeteredDownloadLimit uint64
+	// AutoDownloadLimit is the size in bytes above which messages, except
+	// priority ones, are not downloaded automatically, 0 means no limit.
+	// It is the AutoDownloadLimit property of the telepathy service.
+	AutoDownloadLimit uint64
+	// SpamFilter files suspicious messages from unknown senders, see package
+	// spam, in a spam folder instead of the inbox.
+	SpamFilter bool
+	// AllowedMediaTypes is a comma separated list of the media types of
+	// received parts which are passed on, where '*' matches any run of
+	// characters, e.g. "text/*,image/*". Other parts are removed. Empty
+	// allows every media type.
+	AllowedMediaTypes string
+	// UseDeliveryReports requests delivery reports for sent messages.
+	UseDeliveryReports bool
+	// SendAttempts is how many times a message is uploaded before sending
+	// fails.
+	SendAttempts uint32
+	// SendRetryDelay is the delay in seconds before a failed upload is
+	// retried, it doubles with every further attempt.
+	SendRetryDelay uint32
+	// ConnectTimeout is the time in seconds a download or upload may take to
+	// make progress after it started before it fails, 0 uses DownloadTimeout
+	// and UploadTimeout from the start.
+	ConnectTimeout uint32
+	// DownloadTimeout is the time in seconds a download may stall before it
+	// fails.
+	DownloadTimeout uint32
+	// UploadTimeout is the time in seconds an upload may stall before it
+	// fails.
+	UploadTimeout uint32
+	// ResumeDownloads keeps the part of a download which broke off and asks
+	// the MMSC only for the rest on the next attempt, using nuntium's own
+	// HTTP client instead of the download manager.
+	ResumeDownloads bool
+	// ContextKeepAlive is the time in seconds the MMS context stays active
+	// after the last transaction, so transactions following shortly after
+	// share its activation, 0 deactivates it right away.
+	ContextKeepAlive uint32
+	// MaxMessageSize is the largest m-send.req in bytes which is sent, 0
+	// means no limit. A smaller limit of the carrier overrides takes
+	// precedence.
+	MaxMessageSize uint64
+	// ExpiryScanInterval is the time in seconds between scans for expired
+	// messages which were not downloaded, 0 disables them.
+	ExpiryScanInterval uint32
+	// DuplicateWindow is the time in seconds during which a notification
+	// with the content location, sender, size and expiry of an earlier one
+	// but another transaction id is dropped as a duplicate, 0 disables it.
+	DuplicateWindow uint32
+	// GCMaxSize is the size in bytes of the storage above which read
+	// messages are removed, the least recently used first, 0 means no
+	// limit.
+	GCMaxSize uint64
+	// GCMaxAge is the age in days after which read messages are removed, 0
+	// means no limit.
+	GCMaxAge uint32
+	// EncryptStorage stores downloaded messages encrypted with a key kept in
+	// the system keyring, it takes effect on restart.
+	EncryptStorage bool
+	// GenerateSmil adds a SMIL presentation of the attachments to outgoing
+	// messages which have none.
+	GenerateSmil bool
+	// ResizeImages downscales the images of outgoing messages which do not
+	// fit into MaxMessageSize or the limit of the carrier.
+	ResizeImages bool
+	// LogLevel is the level of the log, optionally followed by levels of
+	// single modules, e.g. "warn,ofono=debug", see logging.Configure.
+	LogLevel string
+	// AllowIPBearer exchanges messages over the default route, e.g. Wi-Fi,
+	// without activating an MMS context for the operators whose carrier
+	// profile sets IPBearer.
+	AllowIPBearer bool
+	// ContextDNS resolves the host names of the MMSC and proxy with the DNS
+	// servers of the MMS context instead of the system resolver.
+	ContextDNS bool
+	// WriteStatistics writes the statistics of the handled messages to a
+	// file in the XDG data directory, see metrics.Writer.
+	WriteStatistics bool
+	// UserAgent is the User-Agent sent to the MMSC, empty for
+	// mms.DefaultUserAgent. The one of the carrier takes precedence.
+	UserAgent string
+	// UAProf is the User Agent Profile URL sent to the MMSC as
+	// X-Wap-Profile, empty for none. The one of the carrier takes
+	// precedence.
+	UAProf string
+	// MmsdCompatibility serves the org.ofono.mms API the way upstream mmsd
+	// does, for mmsd clients and test suites: every stored message is an
+	// object from the start and SendMessage also takes the recipients, a
+	// SMIL presentation and the attachments. Services added before it
+	// changed keep their mode.
+	MmsdCompatibility bool
+}
+
+// Defaults are the settings used for options which are not configured.
+var Defaults = Settings{
+	AutoDownloadWhileRoaming: true,
+	SendAttempts:             6,
+	SendRetryDelay:           30,
+	ConnectTimeout:           60,
+	DownloadTimeout:          180,
+	UploadTimeout:            600,
+	ContextKeepAlive:         10,
+	ExpiryScanInterval:       3600,
+	DuplicateWindow:          600,
+	GCMaxSize:                50 << 20,
+	GCMaxAge:                 30,
+	GenerateSmil:             true,
+	ResizeImages:             true,
+	LogLevel:                 "info",
+}
+
+// SendRetryDelayDuration returns SendRetryDelay as a duration.
+func (s Settings) SendRetryDelayDuration() time.Duration {
+	return time.Duration(s.SendRetryDelay) * time.Second
+}
+
+// ConnectTimeoutDuration returns ConnectTimeout as a duration.
+func (s Settings) ConnectTimeoutDuration() time.Duration {
+	return time.Duration(s.ConnectTimeout) * time.Second
+}
+
+// DownloadTimeoutDuration returns DownloadTimeout as a duration.
+func (s Settings) DownloadTimeoutDuration() time.Duration {
+	return time.Duration(s.DownloadTimeout) * time.Second
+}
+
+// UploadTimeoutDuration returns UploadTimeout as a duration.
+func (s Settings) UploadTimeoutDuration() time.Duration {
+	return time.Duration(s.UploadTimeout) * time.Second
+}
+
+// ContextKeepAliveDuration returns ContextKeepAlive as a duration.
+func (s Settings) ContextKeepAliveDuration() time.Duration {
+	return time.Duration(s.ContextKeepAlive) * time.Second
+}
+
+// ExpiryScanIntervalDuration returns ExpiryScanInterval as a duration.
+func (s Settings) ExpiryScanIntervalDuration() time.Duration {
+	return time.Duration(s.ExpiryScanInterval) * time.Second
+}
+
+// DuplicateWindowDuration returns DuplicateWindow as a duration.
+func (s Settings) DuplicateWindowDuration() time.Duration {
+	return time.Duration(s.DuplicateWindow) * time.Second
+}
+
+// GCMaxAgeDuration returns GCMaxAge as a duration.
+func (s Settings) GCMaxAgeDuration() time.Duration {
+	return time.Duration(s.GCMaxAge) * 24 * time.Hour
+}
+
+// MediaTypeAllowed returns true if AllowedMediaTypes lets received parts of
+// mediaType through. Parameters of mediaType are ignored.
+func (s Settings) MediaTypeAllowed(mediaType string) bool {
+	if strings.TrimSpace(s.AllowedMediaTypes) == "" {
+		return true
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0]))
+	for _, pattern := range strings.Split(s.AllowedMediaTypes, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, err := path.Match(pattern, mediaType); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if an option has a value nuntium cannot work
+// with.
+func (s Settings) Validate() error {
+	if s.SendAttempts == 0 {
+		return errors.New("SendAttempts must be at least 1")
+	}
+	if s.DownloadTimeout == 0 || s.UploadTimeout == 0 {
+		return errors.New("timeouts must not be 0")
+	}
+	if err := logging.CheckSpec(s.LogLevel); err != nil {
+		return fmt.Errorf("LogLevel: %w", err)
+	}
+	for _, pattern := range strings.Split(s.AllowedMediaTypes, ",") {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return fmt.Errorf("AllowedMediaTypes: invalid pattern %q", pattern)
+		}
+	}
+	if s.UAProf != "" {
+		if u, err := url.Parse(s.UAProf); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("UAProf %q is not an HTTP URL", s.UAProf)
+		}
+	}
+	return nil
+}
+
+// Names returns the sorted option names.
+func Names() []string {
+	var names []string
+	t := reflect.TypeOf(Settings{})
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, t.Field(i).Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of the option name, ok is false if there is no such
+// option.
+func (s Settings) Get(name string) (value interface{}, ok bool) {
+	v := reflect.ValueOf(s).FieldByName(name)
+	if !v.IsValid() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// Set sets the option name to value. Integer values are converted to the type
+// of the option if they fit.
+func (s *Settings) Set(name string, value interface{}) error {
+	field := reflect.ValueOf(s).Elem().FieldByName(name)
+	if !field.IsValid() {
+		return fmt.Errorf("unknown option %q", name)
+	}
+	v := reflect.ValueOf(value)
+	switch field.Kind() {
+	case reflect.Bool:
+		if v.Kind() != reflect.Bool {
+			return fmt.Errorf("%s must be a boolean", name)
+		}
+		field.SetBool(v.Bool())
+	case reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch v.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u = v.Uint()
+		case reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() < 0 {
+				return fmt.Errorf("%s must not be negative", name)
+			}
+			u = uint64(v.Int())
+		default:
+			return fmt.Errorf("%s must be an integer", name)
+		}
+		if field.OverflowUint(u) {
+			return fmt.Errorf("%s is out of range", name)
+		}
+		field.SetUint(u)
+	case reflect.String:
+		if v.Kind() != reflect.String {
+			return fmt.Errorf("%s must be a string", name)
+		}
+		field.SetString(v.String())
+	}
+	return nil
+}
+
+// Read applies the options set in the file at path to settings. A missing
+// file is not an error and changes nothing.
+func Read(path string, settings *Settings) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	read := *settings
+	if err := json.Unmarshal(data, &read); err != nil {
+		return fmt.Errorf("cannot parse configuration %s: %w", path, err)
+	}
+	if err := read.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	*settings = read
+	return nil
+}
+
+// Load returns the default settings with the system and user configuration
+// applied.
+func Load() (Settings, error) {
+	settings := Defaults
+	if err := Read(SystemPath, &settings); err != nil {
+		return Defaults, err
+	}
+	if userPath, err := xdg.Config.Find(UserPath); err == nil {
+		if err := Read(userPath, &settings); err != nil {
+			return Defaults, err
+		}
+	}
+	return settings, nil
+}
+
+// saveUserOption stores the option name with value in the user
+// configuration, keeping the other options set there.
+func saveUserOption(name string, value interface{}) error {
+	userPath, err := xdg.Config.Ensure(UserPath)
+	if err != nil {
+		return err
+	}
+	options := make(map[string]interface{})
+	if data, err := ioutil.ReadFile(userPath); err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &options); err != nil {
+			return fmt.Errorf("cannot parse configuration %s: %w", userPath, err)
+		}
+	}
+	options[name] = value
+	data, err := json.MarshalIndent(options, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(userPath, append(data, '\n'), 0644)
+}
+
+// Store holds the settings in use and lets them change at runtime.
+type Store struct {
+	lock     sync.Mutex
+	settings Settings
+	changed  chan struct{}
+}
+
+// NewStore creates a store holding settings.
+func NewStore(settings Settings) *Store {
+	return &Store{settings: settings, changed: make(chan struct{})}
+}
+
+// Get returns the settings in use. A nil store holds the defaults.
+func (store *Store) Get() Settings {
+	if store == nil {
+		return Defaults
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.settings
+}
+
+// Set changes the option name to value and stores it in the user
+// configuration. It returns the new value of the option.
+func (store *Store) Set(name string, value interface{}) (interface{}, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	settings := store.settings
+	if err := settings.Set(name, value); err != nil {
+		return nil, err
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	newValue, _ := settings.Get(name)
+	if settings == store.settings {
+		return newValue, nil
+	}
+	if err := saveUserOption(name, newValue); err != nil {
+		return nil, fmt.Errorf("cannot save %s: %w", name, err)
+	}
+	store.settings = settings
+	close(store.changed)
+	store.changed = make(chan struct{})
+	return newValue, nil
+}
+
+// Changed returns a channel which is closed on the next change of settings.
+func (store *Store) Changed() <-chan struct{} {
+	if store == nil {
+		return nil
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.changed
+}
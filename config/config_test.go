@@ -0,0 +1,159 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	settings := Defaults
+	if err := Read(filepath.Join(dir, "missing.conf"), &settings); err != nil {
+		t.Fatalf("Read of a missing file: %v", err)
+	}
+	if settings != Defaults {
+		t.Errorf("Read of a missing file changed settings to %+v", settings)
+	}
+
+	system := filepath.Join(dir, "system.conf")
+	if err := ioutil.WriteFile(system, []byte(`{"UseDeliveryReports": true, "SendAttempts": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	user := filepath.Join(dir, "user.conf")
+	if err := ioutil.WriteFile(user, []byte(`{"SendAttempts": 2, "MaxMessageSize": 307200}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{system, user} {
+		if err := Read(path, &settings); err != nil {
+			t.Fatalf("Read(%s): %v", path, err)
+		}
+	}
+	want := Defaults
+	want.UseDeliveryReports = true
+	want.SendAttempts = 2
+	want.MaxMessageSize = 307200
+	if settings != want {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+
+	invalid := filepath.Join(dir, "invalid.conf")
+	for _, data := range []string{`{"SendAttempts": 0}`, `{"LogLevel": "verbose"}`} {
+		if err := ioutil.WriteFile(invalid, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Read(invalid, &settings); err == nil {
+			t.Errorf("Read of %s succeeded", data)
+		}
+	}
+	if settings != want {
+		t.Errorf("failed Read changed settings to %+v", settings)
+	}
+}
+
+func TestSettingsSet(t *testing.T) {
+	var settings Settings
+	for _, tc := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"DeferredDownload", true},
+		{"AutoDownloadWhileRoaming", true},
+		{"SendAttempts", int32(4)},
+		{"SendRetryDelay", uint16(60)},
+		{"MaxMessageSize", uint64(1 << 40)},
+		{"LogLevel", "warn,ofono=debug"},
+	} {
+		if err := settings.Set(tc.name, tc.value); err != nil {
+			t.Errorf("Set(%q, %v): %v", tc.name, tc.value, err)
+			continue
+		}
+		if _, ok := settings.Get(tc.name); !ok {
+			t.Errorf("Get(%q) failed after setting it", tc.name)
+		}
+	}
+	want := Settings{DeferredDownload: true, AutoDownloadWhileRoaming: true, SendAttempts: 4, SendRetryDelay: 60, MaxMessageSize: 1 << 40, LogLevel: "warn,ofono=debug"}
+	if settings != want {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"Unknown", true},
+		{"DeferredDownload", "yes"},
+		{"SendAttempts", int64(-1)},
+		{"SendAttempts", uint64(1 << 32)},
+		{"UploadTimeout", 1.5},
+		{"LogLevel", true},
+	} {
+		if err := settings.Set(tc.name, tc.value); err == nil {
+			t.Errorf("Set(%q, %v) succeeded", tc.name, tc.value)
+		}
+	}
+	if settings != want {
+		t.Errorf("failed Set changed settings to %+v", settings)
+	}
+}
+
+func TestStoreSetInvalid(t *testing.T) {
+	store := NewStore(Defaults)
+	changed := store.Changed()
+	if _, err := store.Set("SendAttempts", uint32(0)); err == nil {
+		t.Error("Set of an invalid value succeeded")
+	}
+	if _, err := store.Set("UAProf", "example.com/uaprof.xml"); err == nil {
+		t.Error("Set of a UAProf which is no URL succeeded")
+	}
+	if _, err := store.Set("AllowedMediaTypes", "image/[,text/*"); err == nil {
+		t.Error("Set of an invalid media type pattern succeeded")
+	}
+	if store.Get() != Defaults {
+		t.Errorf("failed Set changed settings to %+v", store.Get())
+	}
+	select {
+	case <-changed:
+		t.Error("failed Set signaled a change")
+	default:
+	}
+}
+
+func TestMediaTypeAllowed(t *testing.T) {
+	testCases := []struct {
+		allowed   string
+		mediaType string
+		want      bool
+	}{
+		{"", "application/x-executable", true},
+		{"text/*, image/*", "text/plain;charset=utf-8", true},
+		{"text/*, image/*", "IMAGE/JPEG", true},
+		{"text/*, image/*", "application/vnd.oma.drm.message", false},
+		{"text/*,application/smil", "application/smil", true},
+	}
+	for _, tc := range testCases {
+		s := Settings{AllowedMediaTypes: tc.allowed}
+		if got := s.MediaTypeAllowed(tc.mediaType); got != tc.want {
+			t.Errorf("MediaTypeAllowed(%q) with %q = %v, want %v", tc.mediaType, tc.allowed, got, tc.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	names := Names()
+	if len(names) != reflect.TypeOf(Settings{}).NumField() {
+		t.Fatalf("Names() = %v", names)
+	}
+	for _, name := range names {
+		if _, ok := Defaults.Get(name); !ok {
+			t.Errorf("Get(%q) of a listed option failed", name)
+		}
+	}
+}
@@ -8,6 +8,19 @@ gopkg_path=$build_dir/usr/share/gocode/src/$gopkg
 rm -r \
 	$gopkg_path/test \
 	$gopkg_path/storage \
+	$gopkg_path/carrier \
+	$gopkg_path/config \
+	$gopkg_path/diagnostics \
+	$gopkg_path/keyring \
+	$gopkg_path/media \
+	$gopkg_path/processor \
+	$gopkg_path/network \
+	$gopkg_path/policy \
+	$gopkg_path/power \
+	$gopkg_path/transport \
+	$gopkg_path/i18n \
+	$gopkg_path/po \
+	$gopkg_path/data \
 	$gopkg_path/scripts \
 	$gopkg_path/docs \
 	$gopkg_path/.travis.yml \
@@ -0,0 +1,240 @@
+// Package diagnostics bundles what is known about the handling of a message
+// into a report users can attach to carrier support cases.
+//
+// A report holds the stored state of the message with its m-notification.ind
+// headers, its journal (see storage.Storage) with the HTTP timings and
+// MMS context parameters recorded while handling it and the headers of the
+// downloaded m-retrieve.conf. Credentials and URL queries are left out, and
+// so is the content of the message.
+//
+// For bugs in decoding, ExportPDU exports the raw PDUs of a message instead.
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/storage"
+	"launchpad.net/go-xdg/v0"
+)
+
+// SUBPATH is where reports are written relative to the XDG data directory.
+const SUBPATH = "nuntium/diagnostics"
+
+// Report is the diagnostics report of a message.
+type Report struct {
+	UUID    string
+	Created time.Time
+	State   *storage.MMSState      `json:",omitempty"`
+	Journal []storage.JournalEntry `json:",omitempty"`
+	// MRetrieveConf holds the headers of the downloaded message, Parts
+	// describes its parts.
+	MRetrieveConf *mms.MRetrieveConf `json:",omitempty"`
+	Parts         []Part             `json:",omitempty"`
+	// DecoderLog is set if the downloaded message cannot be decoded.
+	DecoderLog string `json:",omitempty"`
+	// Errors lists what could not be added to the report.
+	Errors []string `json:",omitempty"`
+}
+
+// Part describes a part of a message without its data.
+type Part struct {
+	MediaType       string
+	ContentId       string `json:",omitempty"`
+	ContentLocation string `json:",omitempty"`
+	Size            int
+}
+
+// NewReport gathers the report of the message with uuid from store. It fails
+// only if nothing is known about the message.
+func NewReport(store storage.Storage, uuid string) (*Report, error) {
+	report := &Report{UUID: uuid, Created: time.Now().UTC()}
+
+	if state, err := store.GetMMSState(uuid); err == nil {
+		if state.MNotificationInd != nil {
+			// The raw notification is only exported by ExportPDU.
+			mNotificationInd := *state.MNotificationInd
+			mNotificationInd.PDU = nil
+			state.MNotificationInd = &mNotificationInd
+		}
+		report.State = &state
+	} else {
+		report.addError("state", err)
+	}
+	journal, err := store.GetJournal(uuid)
+	if err != nil {
+		report.addError("journal", err)
+	}
+	report.Journal = journal
+	if report.State == nil && len(report.Journal) == 0 {
+		return nil, fmt.Errorf("no message %s", uuid)
+	}
+
+	if data, err := store.ReadMMS(uuid); err == nil {
+		report.addMRetrieveConf(data)
+	}
+	return report, nil
+}
+
+func (report *Report) addError(what string, err error) {
+	report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", what, err))
+}
+
+// addMRetrieveConf decodes the downloaded message data and adds its headers
+// to the report.
+func (report *Report) addMRetrieveConf(data []byte) {
+	mRetrieveConf := mms.NewMRetrieveConf(report.UUID)
+	dec := mms.NewDecoder(data)
+	if err := dec.Decode(mRetrieveConf); err != nil {
+		report.addError("m-retrieve.conf", err)
+		report.DecoderLog = dec.GetLog()
+		return
+	}
+	for _, attachment := range mRetrieveConf.Attachments {
+		report.Parts = append(report.Parts, Part{attachment.MediaType, attachment.ContentId, attachment.ContentLocation, len(attachment.Data)})
+	}
+	mRetrieveConf.Attachments = nil
+	mRetrieveConf.Content = mms.Attachment{}
+	mRetrieveConf.Data = nil
+	report.MRetrieveConf = mRetrieveConf
+}
+
+// Export writes the report of the message with uuid in store to the XDG data
+// directory and returns its path.
+func Export(store storage.Storage, uuid string) (string, error) {
+	report, err := NewReport(store, uuid)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	filePath, err := xdg.Data.Ensure(filepath.Join(SUBPATH, uuid+".json"))
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filePath, append(data, '\n'), 0600); err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+// SanitizeURL strips credentials, the query and the fragment from rawURL.
+func SanitizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// secretContextParameters are not part of reports.
+var secretContextParameters = map[string]bool{
+	"Username": true,
+	"Password": true,
+}
+
+// ContextParameters returns the ofono context properties as strings, leaving
+// out credentials.
+func ContextParameters(properties map[string]dbus.Variant) map[string]string {
+	parameters := make(map[string]string)
+	for name, value := range properties {
+		if secretContextParameters[name] {
+			continue
+		}
+		parameters[name] = fmt.Sprint(value.Value())
+	}
+	return parameters
+}
+
+// Names of the files ExportPDU writes.
+const (
+	NotificationFile = "m-notification.ind"
+	RetrieveConfFile = "m-retrieve.conf"
+	SendReqFile      = "m-send.req"
+	DecoderLogFile   = "decoder.log"
+)
+
+// PDUs returns the raw PDUs stored for the message with uuid in store by
+// file name: its m-notification.ind as pushed, its downloaded m-retrieve.conf
+// or, for an outgoing message, its m-send.req, along with the log of decoding
+// the received ones. It fails if none is stored.
+func PDUs(store storage.Storage, uuid string) (map[string][]byte, error) {
+	pdus := make(map[string][]byte)
+	var log strings.Builder
+	if state, err := store.GetMMSState(uuid); err == nil && state.MNotificationInd != nil && len(state.MNotificationInd.PDU) > 0 {
+		pdus[NotificationFile] = state.MNotificationInd.PDU
+		decodeLog(&log, NotificationFile, state.MNotificationInd.PDU, mms.NewMNotificationInd(time.Time{}))
+	}
+	if data, err := store.ReadMMS(uuid); err == nil {
+		pdus[RetrieveConfFile] = data
+		decodeLog(&log, RetrieveConfFile, data, mms.NewMRetrieveConf(uuid))
+	}
+	if sendFile, err := store.GetSendFile(uuid); err == nil {
+		if data, err := ioutil.ReadFile(sendFile); err == nil {
+			pdus[SendReqFile] = data
+		}
+	}
+	if len(pdus) == 0 {
+		return nil, fmt.Errorf("no PDU of message %s is stored", uuid)
+	}
+	if log.Len() > 0 {
+		pdus[DecoderLogFile] = []byte(log.String())
+	}
+	return pdus, nil
+}
+
+// decodeLog appends the DecodeLog of data, the PDU stored as name, to log.
+func decodeLog(log *strings.Builder, name string, data []byte, pdu mms.MMSReader) {
+	fmt.Fprintf(log, "== %s\n%s\n", name, DecodeLog(data, pdu))
+}
+
+// DecodeLog decodes data into pdu and returns the decoder log, ending with
+// the decoding error if there is one.
+func DecodeLog(data []byte, pdu mms.MMSReader) string {
+	dec := mms.NewDecoder(data)
+	err := dec.Decode(pdu)
+	log := dec.GetLog()
+	if err != nil {
+		log += fmt.Sprintf("Error: %v\n", err)
+	}
+	return log
+}
+
+// ExportPDU writes the PDUs of the message with uuid in store to a directory
+// of its own in the XDG data directory and returns the paths of the written
+// files. Unlike a report, the PDUs hold the content and the addresses of the
+// message.
+func ExportPDU(store storage.Storage, uuid string) ([]string, error) {
+	pdus, err := PDUs(store, uuid)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, name := range []string{NotificationFile, RetrieveConfFile, SendReqFile, DecoderLogFile} {
+		data, ok := pdus[name]
+		if !ok {
+			continue
+		}
+		filePath, err := xdg.Data.Ensure(filepath.Join(SUBPATH, uuid+".pdu", name))
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filePath, data, 0600); err != nil {
+			return nil, err
+		}
+		paths = append(paths, filePath)
+	}
+	return paths, nil
+}
@@ -0,0 +1,120 @@
+// Package i18n maps machine readable error codes to human readable messages
+// and translates them with gettext catalogs.
+//
+// Messages are registered in English with Register, keyed by their code.
+// Translations are read from the compiled gettext catalogs for the "nuntium"
+// domain, e.g. /usr/share/locale/de/LC_MESSAGES/nuntium.mo, where the msgid is
+// the registered English message.
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Domain is the gettext domain of nuntium's catalogs.
+const Domain = "nuntium"
+
+// LocaleDir is the directory holding the compiled catalogs.
+var LocaleDir = "/usr/share/locale"
+
+// localePattern matches the locales catalogs are looked up for, a language
+// with an optional territory, codeset and modifier. Locales come from D-Bus
+// clients, so they must not name paths outside LocaleDir.
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(_[A-Za-z]{2})?([.@].*)?$`)
+
+var (
+	lock     sync.Mutex
+	messages = make(map[string]string)
+	catalogs = make(map[string]catalog)
+)
+
+// Register sets the English message for code.
+func Register(code, message string) {
+	lock.Lock()
+	defer lock.Unlock()
+	messages[code] = message
+}
+
+// Message returns the message for code translated to locale. If there is no
+// translation, or locale is not a valid locale name, the English message is
+// returned and if code is not registered an empty string is returned.
+func Message(code, locale string) string {
+	lock.Lock()
+	msgid, ok := messages[code]
+	lock.Unlock()
+	if !ok {
+		return ""
+	}
+	if !localePattern.MatchString(locale) {
+		return msgid
+	}
+	for _, name := range localeFallbacks(locale) {
+		if msgstr, ok := lookupCatalog(name)[msgid]; ok && msgstr != "" {
+			return msgstr
+		}
+	}
+	return msgid
+}
+
+// lookupCatalog returns the catalog name, loading it on first use. It is
+// read without holding the lock, a catalog loaded concurrently is replaced
+// by an identical one.
+func lookupCatalog(name string) catalog {
+	lock.Lock()
+	c, ok := catalogs[name]
+	lock.Unlock()
+	if ok {
+		return c
+	}
+	c = loadCatalog(name)
+	lock.Lock()
+	catalogs[name] = c
+	lock.Unlock()
+	return c
+}
+
+// DefaultLocale returns the locale for messages from the environment,
+// following gettext's order of precedence.
+func DefaultLocale() string {
+	for _, env := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			// LANGUAGE can be a colon separated priority list.
+			return strings.Split(v, ":")[0]
+		}
+	}
+	return "C"
+}
+
+// localeFallbacks returns the catalog names to try for locale, from the most
+// to the least specific, e.g. "pt_BR.UTF-8@euro" yields "pt_BR" and "pt".
+func localeFallbacks(locale string) []string {
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return nil
+	}
+	names := []string{locale}
+	if i := strings.Index(locale, "_"); i > 0 {
+		names = append(names, locale[:i])
+	}
+	return names
+}
+
+func loadCatalog(name string) catalog {
+	path := filepath.Join(LocaleDir, name, "LC_MESSAGES", Domain+".mo")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	c, err := readMO(f)
+	if err != nil {
+		return nil
+	}
+	return c
+}
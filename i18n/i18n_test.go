@@ -0,0 +1,105 @@
+package i18n
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// buildMO compiles translations into a little endian gettext catalog.
+func buildMO(translations map[string]string) []byte {
+	var ids []string
+	for id := range translations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	n := uint32(len(ids))
+	origTable := uint32(28)
+	transTable := origTable + n*8
+	offset := transTable + n*8
+
+	var header, tables, strs bytes.Buffer
+	for _, v := range []uint32{moMagicLittleEndian, 0, n, origTable, transTable, 0, 0} {
+		binary.Write(&header, binary.LittleEndian, v)
+	}
+	var orig, trans bytes.Buffer
+	for _, id := range ids {
+		binary.Write(&orig, binary.LittleEndian, uint32(len(id)))
+		binary.Write(&orig, binary.LittleEndian, offset+uint32(strs.Len()))
+		strs.WriteString(id + "\x00")
+	}
+	for _, id := range ids {
+		binary.Write(&trans, binary.LittleEndian, uint32(len(translations[id])))
+		binary.Write(&trans, binary.LittleEndian, offset+uint32(strs.Len()))
+		strs.WriteString(translations[id] + "\x00")
+	}
+	tables.Write(orig.Bytes())
+	tables.Write(trans.Bytes())
+	return append(append(header.Bytes(), tables.Bytes()...), strs.Bytes()...)
+}
+
+func TestReadMO(t *testing.T) {
+	want := catalog{"": "Content-Type: text/plain; charset=UTF-8\n", "Hello": "Hallo", "File\x00Files": "Datei\x00Dateien"}
+	c, err := readMO(bytes.NewReader(buildMO(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = catalog{"": "Content-Type: text/plain; charset=UTF-8\n", "Hello": "Hallo", "File": "Datei"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("readMO() = %#v, want %#v", c, want)
+	}
+}
+
+func TestReadMOInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a catalog at all"), buildMO(map[string]string{"a": "b"})[:30]} {
+		if _, err := readMO(bytes.NewReader(data)); err == nil {
+			t.Errorf("readMO(%q) expected an error", data)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origDir := LocaleDir
+	defer func() { LocaleDir = origDir }()
+	LocaleDir = dir
+
+	catalogDir := filepath.Join(dir, "de", "LC_MESSAGES")
+	if err := os.MkdirAll(catalogDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mo := buildMO(map[string]string{"The message could not be downloaded": "Die Nachricht konnte nicht heruntergeladen werden"})
+	if err := ioutil.WriteFile(filepath.Join(catalogDir, Domain+".mo"), mo, 0644); err != nil {
+		t.Fatal(err)
+	}
+	Register("test-download", "The message could not be downloaded")
+	Register("test-storage", "The message could not be saved")
+
+	testCases := []struct {
+		code, locale, want string
+	}{
+		{"test-download", "de_DE.UTF-8", "Die Nachricht konnte nicht heruntergeladen werden"},
+		{"test-download", "de", "Die Nachricht konnte nicht heruntergeladen werden"},
+		{"test-download", "fr_FR", "The message could not be downloaded"},
+		{"test-download", "C", "The message could not be downloaded"},
+		// The catalog of de is not looked up through another directory.
+		{"test-download", "xx/../de", "The message could not be downloaded"},
+		{"test-storage", "de_DE", "The message could not be saved"},
+		{"test-unregistered", "de_DE", ""},
+	}
+	for _, tc := range testCases {
+		if got := Message(tc.code, tc.locale); got != tc.want {
+			t.Errorf("Message(%q, %q) = %q, want %q", tc.code, tc.locale, got, tc.want)
+		}
+	}
+}
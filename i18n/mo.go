@@ -0,0 +1,77 @@
+package i18n
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// catalog maps msgids to their translation.
+type catalog map[string]string
+
+const (
+	moMagicLittleEndian = 0x950412de
+	moMagicBigEndian    = 0xde120495
+)
+
+// readMO reads a compiled GNU gettext catalog. Plural forms and contexts are
+// not supported, only the singular translation of a msgid is kept.
+func readMO(r io.Reader) (catalog, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 20 {
+		return nil, errors.New("catalog too short")
+	}
+
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(data) {
+	case moMagicLittleEndian:
+		order = binary.LittleEndian
+	case moMagicBigEndian:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a gettext catalog")
+	}
+
+	count := order.Uint32(data[8:])
+	origTable := order.Uint32(data[12:])
+	transTable := order.Uint32(data[16:])
+
+	str := func(table, i uint32) (string, error) {
+		entry := uint64(table) + uint64(i)*8
+		if entry+8 > uint64(len(data)) {
+			return "", errors.New("string table out of bounds")
+		}
+		length := uint64(order.Uint32(data[entry:]))
+		offset := uint64(order.Uint32(data[entry+4:]))
+		if offset+length > uint64(len(data)) {
+			return "", errors.New("string out of bounds")
+		}
+		return string(data[offset : offset+length]), nil
+	}
+
+	c := make(catalog, count)
+	for i := uint32(0); i < count; i++ {
+		msgid, err := str(origTable, i)
+		if err != nil {
+			return nil, err
+		}
+		msgstr, err := str(transTable, i)
+		if err != nil {
+			return nil, err
+		}
+		// Plural entries hold NUL separated forms, keep the singular.
+		if j := strings.IndexByte(msgid, 0); j >= 0 {
+			msgid = msgid[:j]
+		}
+		if j := strings.IndexByte(msgstr, 0); j >= 0 {
+			msgstr = msgstr[:j]
+		}
+		c[msgid] = msgstr
+	}
+	return c, nil
+}
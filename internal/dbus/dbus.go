@@ -0,0 +1,387 @@
+// Package dbus is the D-Bus layer of nuntium, on top of godbus.
+//
+// It keeps the message based model nuntium is written in: an object is
+// served by registering a channel for its path, reading the method calls
+// from it and replying with Send, which may happen later and from another
+// goroutine. Signals are received by watching match rules. Values are
+// encoded and decoded by godbus, so properties are typed: a dictionary of
+// variants is a map[string]Variant, an array of strings a []string.
+package dbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// StandardBus is the session or system bus.
+type StandardBus int
+
+const (
+	SessionBus StandardBus = iota
+	SystemBus
+)
+
+type (
+	ObjectPath = godbus.ObjectPath
+	Signature  = godbus.Signature
+	Variant    = godbus.Variant
+)
+
+// MakeVariant returns the variant of v, with the signature of its type.
+func MakeVariant(v interface{}) Variant {
+	return godbus.MakeVariant(v)
+}
+
+// NameFlags are the flags of RequestName.
+type NameFlags uint32
+
+const (
+	NameFlagAllowReplacement = NameFlags(godbus.NameFlagAllowReplacement)
+	NameFlagReplaceExisting  = NameFlags(godbus.NameFlagReplaceExisting)
+	NameFlagDoNotQueue       = NameFlags(godbus.NameFlagDoNotQueue)
+)
+
+// BusName is a name requested with RequestName. C receives nil once the
+// name is owned, an error otherwise.
+type BusName struct {
+	Name string
+	C    <-chan error
+}
+
+// Connection is a connection to a bus.
+type Connection struct {
+	UniqueName string
+	conn       *godbus.Conn
+
+	objectsLock sync.Mutex
+	objects     map[ObjectPath]*object
+
+	// replies are the calls to registered objects waiting for the reply
+	// of their handler.
+	repliesLock sync.Mutex
+	replies     map[replyKey]chan *Message
+
+	watchesLock sync.Mutex
+	watches     map[*SignalWatch]bool
+	signals     chan *godbus.Signal
+}
+
+// Connect opens a new connection to bus.
+func Connect(bus StandardBus) (*Connection, error) {
+	c := &Connection{
+		objects: make(map[ObjectPath]*object),
+		replies: make(map[replyKey]chan *Message),
+		watches: make(map[*SignalWatch]bool),
+		signals: make(chan *godbus.Signal),
+	}
+	options := []godbus.ConnOption{
+		godbus.WithHandler(handler{c}),
+		godbus.WithSignalHandler(godbus.NewSequentialSignalHandler()),
+	}
+	var err error
+	if bus == SystemBus {
+		c.conn, err = godbus.ConnectSystemBus(options...)
+	} else {
+		c.conn, err = godbus.ConnectSessionBus(options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if names := c.conn.Names(); len(names) > 0 {
+		c.UniqueName = names[0]
+	}
+	c.conn.Signal(c.signals)
+	go c.dispatchSignals()
+	return c, nil
+}
+
+// Close closes the connection. Signal watches are cancelled and calls to
+// registered objects which were not replied to fail.
+func (c *Connection) Close() error {
+	c.objectsLock.Lock()
+	objects := c.objects
+	c.objects = make(map[ObjectPath]*object)
+	c.objectsLock.Unlock()
+	for _, obj := range objects {
+		obj.unregister()
+	}
+	return c.conn.Close()
+}
+
+// Send sends msg without waiting for a reply. Replies to method calls of
+// registered objects are passed on to the caller, they are dropped if it is
+// not waiting anymore.
+func (c *Connection) Send(msg *Message) error {
+	if msg.Type == TypeMethodReturn || msg.Type == TypeError {
+		c.reply(msg)
+		return nil
+	}
+	gmsg, err := msg.toGodbus()
+	if err != nil {
+		return err
+	}
+	return c.conn.Send(gmsg, nil).Err
+}
+
+// SendWithReply sends the method call msg and waits for the reply, which
+// is an error message if the call failed on the other end.
+func (c *Connection) SendWithReply(msg *Message) (*Message, error) {
+	return c.SendWithReplyContext(context.Background(), msg)
+}
+
+// SendWithReplyContext is SendWithReply which gives up waiting for the
+// reply when ctx is done.
+func (c *Connection) SendWithReplyContext(ctx context.Context, msg *Message) (*Message, error) {
+	gmsg, err := msg.toGodbus()
+	if err != nil {
+		return nil, err
+	}
+	call := <-c.conn.SendWithContext(ctx, gmsg, make(chan *godbus.Call, 1)).Done
+	switch err := call.Err.(type) {
+	case nil:
+		return &Message{Type: TypeMethodReturn, body: call.Body}, nil
+	case godbus.Error:
+		return &Message{Type: TypeError, ErrorName: err.Name, body: err.Body}, nil
+	case *godbus.Error:
+		return &Message{Type: TypeError, ErrorName: err.Name, body: err.Body}, nil
+	default:
+		return nil, err
+	}
+}
+
+// RequestName requests the well-known name.
+func (c *Connection) RequestName(name string, flags NameFlags) *BusName {
+	result := make(chan error, 1)
+	reply, err := c.conn.RequestName(name, godbus.RequestNameFlags(flags))
+	if err == nil && reply != godbus.RequestNameReplyPrimaryOwner && reply != godbus.RequestNameReplyAlreadyOwner {
+		err = fmt.Errorf("name %s is owned by another connection", name)
+	}
+	result <- err
+	return &BusName{Name: name, C: result}
+}
+
+// ObjectProxy is a remote object.
+type ObjectProxy struct {
+	conn        *Connection
+	destination string
+	path        ObjectPath
+}
+
+// Object returns the object at path of the destination bus name.
+func (c *Connection) Object(destination string, path ObjectPath) *ObjectProxy {
+	return &ObjectProxy{conn: c, destination: destination, path: path}
+}
+
+func (o *ObjectProxy) ObjectPath() ObjectPath {
+	return o.path
+}
+
+// Call calls method of iface on the object with args. If the call failed on
+// the other end the error reply is returned along with its error.
+func (o *ObjectProxy) Call(iface, method string, args ...interface{}) (*Message, error) {
+	return o.CallWithContext(context.Background(), iface, method, args...)
+}
+
+// CallWithContext is Call which gives up waiting for the reply when ctx is
+// done.
+func (o *ObjectProxy) CallWithContext(ctx context.Context, iface, method string, args ...interface{}) (*Message, error) {
+	msg := NewMethodCallMessage(o.destination, o.path, iface, method)
+	if err := msg.AppendArgs(args...); err != nil {
+		return nil, err
+	}
+	reply, err := o.conn.SendWithReplyContext(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == TypeError {
+		return reply, reply.AsError()
+	}
+	return reply, nil
+}
+
+// WatchSignal watches the signal member of iface emitted by the object.
+func (o *ObjectProxy) WatchSignal(iface, member string) (*SignalWatch, error) {
+	return o.conn.WatchSignal(&MatchRule{
+		Type:      TypeSignal,
+		Sender:    o.destination,
+		Path:      o.path,
+		Interface: iface,
+		Member:    member,
+	})
+}
+
+// RegisterObjectPath serves the object at path: its method calls are sent
+// to ch, whatever their interface. An object registered before at path is
+// replaced.
+func (c *Connection) RegisterObjectPath(path ObjectPath, ch chan<- *Message) {
+	obj := &object{conn: c, ch: ch, done: make(chan struct{})}
+	c.objectsLock.Lock()
+	previous := c.objects[path]
+	c.objects[path] = obj
+	c.objectsLock.Unlock()
+	if previous != nil {
+		previous.unregister()
+	}
+}
+
+// UnregisterObjectPath stops serving the object at path. Once it returns
+// no more method calls are sent to its channel, which may be closed.
+func (c *Connection) UnregisterObjectPath(path ObjectPath) {
+	c.objectsLock.Lock()
+	obj := c.objects[path]
+	delete(c.objects, path)
+	c.objectsLock.Unlock()
+	if obj != nil {
+		obj.unregister()
+	}
+}
+
+var errUnregistered = errors.New("object unregistered")
+
+// replyKey identifies a method call by its sender and serial.
+type replyKey struct {
+	sender string
+	serial uint32
+}
+
+// expectReply returns the channel the reply to call is sent to.
+func (c *Connection) expectReply(call *Message) chan *Message {
+	ch := make(chan *Message, 1)
+	c.repliesLock.Lock()
+	c.replies[replyKey{call.Sender, call.serial}] = ch
+	c.repliesLock.Unlock()
+	return ch
+}
+
+func (c *Connection) forgetReply(call *Message) {
+	c.repliesLock.Lock()
+	delete(c.replies, replyKey{call.Sender, call.serial})
+	c.repliesLock.Unlock()
+}
+
+// reply passes msg on to the call it replies to.
+func (c *Connection) reply(msg *Message) {
+	key := replyKey{msg.Dest, msg.ReplySerial}
+	c.repliesLock.Lock()
+	ch, ok := c.replies[key]
+	delete(c.replies, key)
+	c.repliesLock.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// handler looks up the registered objects for godbus.
+type handler struct {
+	conn *Connection
+}
+
+func (h handler) LookupObject(path godbus.ObjectPath) (godbus.ServerObject, bool) {
+	h.conn.objectsLock.Lock()
+	defer h.conn.objectsLock.Unlock()
+	obj, ok := h.conn.objects[path]
+	return obj, ok
+}
+
+// object is a registered object. It implements every interface and method,
+// the method calls are passed on to its channel as they are.
+type object struct {
+	conn *Connection
+	ch   chan<- *Message
+
+	lock         sync.Mutex
+	unregistered bool
+	done         chan struct{}
+	// calls are the calls being sent to ch.
+	calls sync.WaitGroup
+}
+
+func (obj *object) LookupInterface(name string) (godbus.Interface, bool) {
+	return obj, true
+}
+
+func (obj *object) LookupMethod(name string) (godbus.Method, bool) {
+	return method{obj}, true
+}
+
+// unregister makes calls fail and waits until no call is being sent to
+// the channel.
+func (obj *object) unregister() {
+	obj.lock.Lock()
+	if obj.unregistered {
+		obj.lock.Unlock()
+		return
+	}
+	obj.unregistered = true
+	close(obj.done)
+	obj.lock.Unlock()
+	obj.calls.Wait()
+}
+
+// deliver sends msg to the channel, it returns false if the object was
+// unregistered.
+func (obj *object) deliver(msg *Message) bool {
+	obj.lock.Lock()
+	if obj.unregistered {
+		obj.lock.Unlock()
+		return false
+	}
+	obj.calls.Add(1)
+	obj.lock.Unlock()
+	defer obj.calls.Done()
+	select {
+	case obj.ch <- msg:
+		return true
+	case <-obj.done:
+		return false
+	}
+}
+
+// call passes the method call gmsg on to the channel and returns the reply
+// of the handler to godbus.
+func (obj *object) call(gmsg *godbus.Message) ([]interface{}, error) {
+	msg := fromGodbus(gmsg)
+	var reply chan *Message
+	if gmsg.Flags&godbus.FlagNoReplyExpected == 0 {
+		reply = obj.conn.expectReply(msg)
+		defer obj.conn.forgetReply(msg)
+	}
+	if !obj.deliver(msg) {
+		return nil, godbus.MakeNoObjectError(msg.Path)
+	}
+	if reply == nil {
+		return nil, nil
+	}
+	select {
+	case r := <-reply:
+		if r.Type == TypeError {
+			return nil, godbus.Error{Name: r.ErrorName, Body: r.body}
+		}
+		return r.body, nil
+	case <-obj.done:
+		return nil, godbus.MakeFailedError(errUnregistered)
+	}
+}
+
+// method is any method of an object. It takes the whole message as its
+// argument.
+type method struct {
+	obj *object
+}
+
+func (m method) DecodeArguments(conn *godbus.Conn, sender string, msg *godbus.Message, args []interface{}) ([]interface{}, error) {
+	return []interface{}{msg}, nil
+}
+
+func (m method) Call(args ...interface{}) ([]interface{}, error) {
+	return m.obj.call(args[0].(*godbus.Message))
+}
+
+func (m method) NumArguments() int                      { return 1 }
+func (m method) NumReturns() int                        { return 0 }
+func (m method) ArgumentValue(position int) interface{} { return nil }
+func (m method) ReturnValue(position int) interface{}   { return nil }
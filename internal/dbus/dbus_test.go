@@ -0,0 +1,148 @@
+package dbus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/internal/ofonosim"
+)
+
+const (
+	testPath  = dbus.ObjectPath("/test")
+	testIface = "org.ubports.test"
+)
+
+// connect starts a private bus and connects a server and a client to it.
+func connect(t *testing.T) (server, client *dbus.Connection, done func()) {
+	bus, err := ofonosim.StartBus()
+	if err != nil {
+		t.Skip(err)
+	}
+	if server, err = dbus.Connect(dbus.SessionBus); err != nil {
+		bus.Close()
+		t.Skip(err)
+	}
+	if client, err = dbus.Connect(dbus.SessionBus); err != nil {
+		server.Close()
+		bus.Close()
+		t.Skip(err)
+	}
+	return server, client, func() {
+		client.Close()
+		server.Close()
+		bus.Close()
+	}
+}
+
+// serve replies to the calls of the object at testPath: Echo with its
+// arguments, Fail with an error and Hang not at all.
+func serve(server *dbus.Connection) {
+	ch := make(chan *dbus.Message)
+	server.RegisterObjectPath(testPath, ch)
+	go func() {
+		for msg := range ch {
+			var reply *dbus.Message
+			switch msg.Member {
+			case "Echo":
+				reply = dbus.NewMethodReturnMessage(msg)
+				reply.AppendArgs(msg.AllArgs()...)
+			case "Fail":
+				reply = dbus.NewErrorMessage(msg, "org.ubports.test.Error", "failed")
+			default:
+				continue
+			}
+			server.Send(reply)
+		}
+	}()
+}
+
+func TestCall(t *testing.T) {
+	server, client, done := connect(t)
+	defer done()
+	serve(server)
+	obj := client.Object(server.UniqueName, testPath)
+
+	props := map[string]dbus.Variant{"Count": dbus.MakeVariant(uint32(2))}
+	reply, err := obj.Call(testIface, "Echo", "text", props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	var echoed map[string]dbus.Variant
+	if err := reply.Args(&text, &echoed); err != nil {
+		t.Fatal(err)
+	}
+	if text != "text" || echoed["Count"].Value() != uint32(2) {
+		t.Errorf("Echo returned %q, %v", text, echoed)
+	}
+
+	reply, err = obj.Call(testIface, "Fail")
+	if err == nil || reply == nil || reply.Type != dbus.TypeError {
+		t.Fatalf("Fail returned %v, %v", reply, err)
+	}
+	if e, ok := err.(*dbus.Error); !ok || e.Name != "org.ubports.test.Error" || e.Message != "failed" {
+		t.Errorf("Fail returned error %#v", err)
+	}
+}
+
+func TestCallWithContext(t *testing.T) {
+	server, client, done := connect(t)
+	defer done()
+	serve(server)
+	obj := client.Object(server.UniqueName, testPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := obj.CallWithContext(ctx, testIface, "Hang"); err == nil {
+		t.Error("Hang did not time out")
+	}
+}
+
+func TestUnregisterObjectPath(t *testing.T) {
+	server, client, done := connect(t)
+	defer done()
+	serve(server)
+	server.UnregisterObjectPath(testPath)
+
+	if _, err := client.Object(server.UniqueName, testPath).Call(testIface, "Echo"); err == nil {
+		t.Error("call to an unregistered object succeeded")
+	}
+}
+
+func TestWatchSignal(t *testing.T) {
+	server, client, done := connect(t)
+	defer done()
+
+	w, err := client.Object(server.UniqueName, testPath).WatchSignal(testIface, "Changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{"Other", "Changed", "Changed"} {
+		signal := dbus.NewSignalMessage(testPath, testIface, member)
+		if err := signal.AppendArgs(member); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Send(signal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-w.C:
+			if msg.Member != "Changed" || msg.Sender != server.UniqueName {
+				t.Errorf("got signal %s from %s", msg.Member, msg.Sender)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no signal")
+		}
+	}
+
+	if err := w.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-w.C; ok {
+		t.Error("signal after Cancel")
+	}
+}
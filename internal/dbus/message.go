@@ -0,0 +1,171 @@
+package dbus
+
+import (
+	"fmt"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// MessageType is the type of a message.
+type MessageType uint8
+
+const (
+	TypeInvalid      = MessageType(0)
+	TypeMethodCall   = MessageType(godbus.TypeMethodCall)
+	TypeMethodReturn = MessageType(godbus.TypeMethodReply)
+	TypeError        = MessageType(godbus.TypeError)
+	TypeSignal       = MessageType(godbus.TypeSignal)
+)
+
+// Message is a method call, method return, error or signal.
+type Message struct {
+	Type        MessageType
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Dest        string
+	Sender      string
+	Signature   Signature
+
+	serial uint32
+	body   []interface{}
+}
+
+func NewMethodCallMessage(destination string, path ObjectPath, iface string, member string) *Message {
+	return &Message{Type: TypeMethodCall, Dest: destination, Path: path, Interface: iface, Member: member}
+}
+
+// NewMethodReturnMessage returns the reply to methodCall, to be sent with
+// Connection.Send.
+func NewMethodReturnMessage(methodCall *Message) *Message {
+	return &Message{Type: TypeMethodReturn, Dest: methodCall.Sender, ReplySerial: methodCall.serial}
+}
+
+func NewSignalMessage(path ObjectPath, iface string, member string) *Message {
+	return &Message{Type: TypeSignal, Path: path, Interface: iface, Member: member}
+}
+
+// NewErrorMessage returns the error reply to methodCall, to be sent with
+// Connection.Send.
+func NewErrorMessage(methodCall *Message, errorName string, message string) *Message {
+	return &Message{
+		Type:        TypeError,
+		ErrorName:   errorName,
+		Dest:        methodCall.Sender,
+		ReplySerial: methodCall.serial,
+		body:        []interface{}{message},
+	}
+}
+
+// AppendArgs appends args to the arguments of the message. It fails if one
+// of them cannot be represented in D-Bus.
+func (m *Message) AppendArgs(args ...interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dbus: cannot append arguments: %v", r)
+		}
+	}()
+	godbus.SignatureOf(args...)
+	m.body = append(m.body, args...)
+	return nil
+}
+
+// Args stores the arguments of the message in the pointers args, converting
+// them as godbus.Store does. Arguments beyond args are ignored.
+func (m *Message) Args(args ...interface{}) error {
+	if len(args) > len(m.body) {
+		return fmt.Errorf("dbus: %d arguments expected, %s has %d", len(args), m.Signature, len(m.body))
+	}
+	return godbus.Store(m.body[:len(args)], args...)
+}
+
+// AllArgs returns the arguments of the message as decoded by godbus.
+func (m *Message) AllArgs() []interface{} {
+	return m.body
+}
+
+// Error is the error of an error message.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// AsError returns the error of an error message, nil for other messages.
+func (m *Message) AsError() error {
+	if m.Type != TypeError {
+		return nil
+	}
+	e := &Error{Name: m.ErrorName}
+	if len(m.body) > 0 {
+		e.Message, _ = m.body[0].(string)
+	}
+	return e
+}
+
+// toGodbus returns the godbus message of m.
+func (m *Message) toGodbus() (*godbus.Message, error) {
+	gmsg := &godbus.Message{
+		Type:    godbus.Type(m.Type),
+		Headers: make(map[godbus.HeaderField]godbus.Variant),
+		Body:    m.body,
+	}
+	for field, value := range map[godbus.HeaderField]string{
+		godbus.FieldInterface:   m.Interface,
+		godbus.FieldMember:      m.Member,
+		godbus.FieldErrorName:   m.ErrorName,
+		godbus.FieldDestination: m.Dest,
+	} {
+		if value != "" {
+			gmsg.Headers[field] = godbus.MakeVariant(value)
+		}
+	}
+	if m.Path != "" {
+		gmsg.Headers[godbus.FieldPath] = godbus.MakeVariant(m.Path)
+	}
+	if m.ReplySerial != 0 {
+		gmsg.Headers[godbus.FieldReplySerial] = godbus.MakeVariant(m.ReplySerial)
+	}
+	if len(m.body) > 0 {
+		gmsg.Headers[godbus.FieldSignature] = godbus.MakeVariant(godbus.SignatureOf(m.body...))
+	}
+	if err := gmsg.IsValid(); err != nil {
+		return nil, err
+	}
+	return gmsg, nil
+}
+
+// fromGodbus returns the message of gmsg.
+func fromGodbus(gmsg *godbus.Message) *Message {
+	m := &Message{
+		Type:   MessageType(gmsg.Type),
+		serial: gmsg.Serial(),
+		body:   gmsg.Body,
+	}
+	for field, value := range map[godbus.HeaderField]*string{
+		godbus.FieldInterface:   &m.Interface,
+		godbus.FieldMember:      &m.Member,
+		godbus.FieldErrorName:   &m.ErrorName,
+		godbus.FieldDestination: &m.Dest,
+		godbus.FieldSender:      &m.Sender,
+	} {
+		if v, ok := gmsg.Headers[field]; ok {
+			*value, _ = v.Value().(string)
+		}
+	}
+	if v, ok := gmsg.Headers[godbus.FieldPath]; ok {
+		m.Path, _ = v.Value().(ObjectPath)
+	}
+	if v, ok := gmsg.Headers[godbus.FieldReplySerial]; ok {
+		m.ReplySerial, _ = v.Value().(uint32)
+	}
+	if v, ok := gmsg.Headers[godbus.FieldSignature]; ok {
+		m.Signature, _ = v.Value().(Signature)
+	}
+	return m
+}
@@ -0,0 +1,171 @@
+package dbus
+
+import (
+	"strings"
+
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// MatchRule selects the signals of a watch. Empty fields match anything.
+type MatchRule struct {
+	Type          MessageType
+	Sender        string
+	Path          ObjectPath
+	Interface     string
+	Member        string
+	Arg0namespace string
+}
+
+// options returns the options of the rule for the bus.
+func (rule *MatchRule) options() []godbus.MatchOption {
+	var options []godbus.MatchOption
+	if rule.Sender != "" {
+		options = append(options, godbus.WithMatchSender(rule.Sender))
+	}
+	if rule.Path != "" {
+		options = append(options, godbus.WithMatchObjectPath(rule.Path))
+	}
+	if rule.Interface != "" {
+		options = append(options, godbus.WithMatchInterface(rule.Interface))
+	}
+	if rule.Member != "" {
+		options = append(options, godbus.WithMatchMember(rule.Member))
+	}
+	if rule.Arg0namespace != "" {
+		options = append(options, godbus.WithMatchOption("arg0namespace", rule.Arg0namespace))
+	}
+	return options
+}
+
+// matches returns true if the signal msg matches the rule. The bus only
+// sends the signals matching one of the rules of a connection, this sorts
+// them out between the watches. Signals carry the unique name of their
+// sender, so a well-known sender name is left to the bus.
+func (rule *MatchRule) matches(msg *Message) bool {
+	switch {
+	case rule.Sender != "" && strings.HasPrefix(rule.Sender, ":") && rule.Sender != msg.Sender:
+		return false
+	case rule.Path != "" && rule.Path != msg.Path:
+		return false
+	case rule.Interface != "" && rule.Interface != msg.Interface:
+		return false
+	case rule.Member != "" && rule.Member != msg.Member:
+		return false
+	}
+	if rule.Arg0namespace != "" {
+		var arg0 string
+		if args := msg.AllArgs(); len(args) > 0 {
+			arg0, _ = args[0].(string)
+		}
+		if arg0 != rule.Arg0namespace && !strings.HasPrefix(arg0, rule.Arg0namespace+".") {
+			return false
+		}
+	}
+	return true
+}
+
+// SignalWatch receives the signals matching a rule on C, in the order they
+// were received, until it is cancelled. A slow reader does not hold up the
+// other watches of the connection.
+type SignalWatch struct {
+	C         chan *Message
+	conn      *Connection
+	rule      MatchRule
+	in        chan *Message
+	cancelled chan struct{}
+}
+
+// WatchSignal adds rule to the bus and watches the signals matching it.
+func (c *Connection) WatchSignal(rule *MatchRule) (*SignalWatch, error) {
+	if err := c.conn.AddMatchSignal(rule.options()...); err != nil {
+		return nil, err
+	}
+	w := &SignalWatch{
+		C:         make(chan *Message),
+		conn:      c,
+		rule:      *rule,
+		in:        make(chan *Message),
+		cancelled: make(chan struct{}),
+	}
+	go w.queue()
+	c.watchesLock.Lock()
+	c.watches[w] = true
+	c.watchesLock.Unlock()
+	return w, nil
+}
+
+// Cancel removes the rule of the watch from the bus and closes C.
+func (w *SignalWatch) Cancel() error {
+	if !w.stop() {
+		return nil
+	}
+	return w.conn.conn.RemoveMatchSignal(w.rule.options()...)
+}
+
+// stop stops the watch, it returns false if it was stopped already.
+func (w *SignalWatch) stop() bool {
+	w.conn.watchesLock.Lock()
+	defer w.conn.watchesLock.Unlock()
+	if !w.conn.watches[w] {
+		return false
+	}
+	delete(w.conn.watches, w)
+	close(w.cancelled)
+	return true
+}
+
+// queue passes the signals received on in on to C.
+func (w *SignalWatch) queue() {
+	defer close(w.C)
+	var queued []*Message
+	for {
+		var out chan *Message
+		var next *Message
+		if len(queued) > 0 {
+			out, next = w.C, queued[0]
+		}
+		select {
+		case msg := <-w.in:
+			queued = append(queued, msg)
+		case out <- next:
+			queued[0] = nil
+			queued = queued[1:]
+		case <-w.cancelled:
+			return
+		}
+	}
+}
+
+// dispatchSignals passes the signals received on the connection on to the
+// matching watches, until the connection is closed.
+func (c *Connection) dispatchSignals() {
+	for signal := range c.signals {
+		msg := &Message{Type: TypeSignal, Sender: signal.Sender, Path: signal.Path, body: signal.Body}
+		if i := strings.LastIndex(signal.Name, "."); i >= 0 {
+			msg.Interface, msg.Member = signal.Name[:i], signal.Name[i+1:]
+		}
+		c.watchesLock.Lock()
+		var watches []*SignalWatch
+		for w := range c.watches {
+			if w.rule.matches(msg) {
+				watches = append(watches, w)
+			}
+		}
+		c.watchesLock.Unlock()
+		for _, w := range watches {
+			select {
+			case w.in <- msg:
+			case <-w.cancelled:
+			}
+		}
+	}
+	c.watchesLock.Lock()
+	var watches []*SignalWatch
+	for w := range c.watches {
+		watches = append(watches, w)
+	}
+	c.watchesLock.Unlock()
+	for _, w := range watches {
+		w.stop()
+	}
+}
@@ -0,0 +1,576 @@
+// Package ofonosim simulates ofono on a private D-Bus daemon, so the modem
+// handling, context activation and push agent logic of nuntium can be
+// integration tested without phonesim or hardware.
+//
+// StartBus starts the daemon and points the session and system bus of the
+// process at it, New serves the org.ofono name on it. Modems are added with
+// AddModem and driven by the test: their properties are changed with
+// SetProperty, context activation failures are queued with FailActivation
+// and pushes are delivered to the registered agent with Push.
+package ofonosim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// D-Bus names of the ofono API, see ofono's doc directory.
+const (
+	Name                           = "org.ofono"
+	ManagerInterface               = "org.ofono.Manager"
+	ModemInterface                 = "org.ofono.Modem"
+	SimManagerInterface            = "org.ofono.SimManager"
+	ConnectionManagerInterface     = "org.ofono.ConnectionManager"
+	ConnectionContextInterface     = "org.ofono.ConnectionContext"
+	NetworkRegistrationInterface   = "org.ofono.NetworkRegistration"
+	PushNotificationInterface      = "org.ofono.PushNotification"
+	PushNotificationAgentInterface = "org.ofono.PushNotificationAgent"
+)
+
+// Errors ofono answers calls with.
+const (
+	ErrorInProgress       = "org.ofono.Error.InProgress"
+	ErrorNotAttached      = "org.ofono.Error.NotAttached"
+	ErrorFailed           = "org.ofono.Error.Failed"
+	ErrorInvalidArguments = "org.ofono.Error.InvalidArguments"
+	ErrorNotFound         = "org.ofono.Error.NotFound"
+)
+
+// busAddressVariables are pointed at the private bus by StartBus.
+var busAddressVariables = []string{"DBUS_SESSION_BUS_ADDRESS", "DBUS_SYSTEM_BUS_ADDRESS"}
+
+// Bus is a private dbus-daemon.
+type Bus struct {
+	Address string
+	cmd     *exec.Cmd
+	env     map[string]*string
+}
+
+// StartBus starts a private dbus-daemon and points the session and system
+// bus addresses of the process at it until Close. It fails if dbus-daemon is
+// not installed.
+func StartBus() (*Bus, error) {
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start dbus-daemon: %w", err)
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("cannot read the address of dbus-daemon: %w", err)
+	}
+	bus := &Bus{Address: strings.TrimSpace(address), cmd: cmd, env: make(map[string]*string)}
+	for _, variable := range busAddressVariables {
+		if value, ok := os.LookupEnv(variable); ok {
+			bus.env[variable] = &value
+		} else {
+			bus.env[variable] = nil
+		}
+		os.Setenv(variable, bus.Address)
+	}
+	return bus, nil
+}
+
+// Close stops the daemon and restores the bus addresses.
+func (bus *Bus) Close() error {
+	for variable, value := range bus.env {
+		if value != nil {
+			os.Setenv(variable, *value)
+		} else {
+			os.Unsetenv(variable)
+		}
+	}
+	if err := bus.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	bus.cmd.Wait()
+	return nil
+}
+
+// Ofono serves the org.ofono name.
+type Ofono struct {
+	conn  *dbus.Connection
+	calls chan *dbus.Message
+
+	lock     sync.Mutex
+	modems   map[dbus.ObjectPath]*Modem
+	contexts map[dbus.ObjectPath]*Context
+	order    []dbus.ObjectPath
+}
+
+// New connects to the system bus, normally the one of StartBus, and serves
+// the org.ofono name there, with no modems.
+func New() (*Ofono, error) {
+	conn, err := dbus.Connect(dbus.SystemBus)
+	if err != nil {
+		return nil, err
+	}
+	name := conn.RequestName(Name, dbus.NameFlagDoNotQueue)
+	if err := <-name.C; err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot own %s: %w", Name, err)
+	}
+	ofono := &Ofono{
+		conn:     conn,
+		calls:    make(chan *dbus.Message),
+		modems:   make(map[dbus.ObjectPath]*Modem),
+		contexts: make(map[dbus.ObjectPath]*Context),
+	}
+	go ofono.serve()
+	conn.RegisterObjectPath("/", ofono.calls)
+	return ofono, nil
+}
+
+// Close releases the name and stops serving calls.
+func (ofono *Ofono) Close() error {
+	return ofono.conn.Close()
+}
+
+// Modem is a simulated modem. It has the Modem, SimManager,
+// ConnectionManager, NetworkRegistration and PushNotification interfaces,
+// whatever its Interfaces property says.
+type Modem struct {
+	Path  dbus.ObjectPath
+	ofono *Ofono
+
+	// Guarded by ofono.lock.
+	properties       map[string]map[string]dbus.Variant
+	contexts         []*Context
+	activationErrors []string
+	agent            *agent
+}
+
+// agent is a registered push notification agent.
+type agent struct {
+	name string
+	path dbus.ObjectPath
+}
+
+// Context is a connection context of a modem.
+type Context struct {
+	Path  dbus.ObjectPath
+	modem *Modem
+
+	// Guarded by ofono.lock.
+	properties map[string]dbus.Variant
+}
+
+// AddModem adds an online modem with a SIM card of identity, attached to
+// the network with push notifications available, and announces it with
+// ModemAdded.
+func (ofono *Ofono) AddModem(path dbus.ObjectPath, identity string) *Modem {
+	modem := &Modem{
+		Path:  path,
+		ofono: ofono,
+		properties: map[string]map[string]dbus.Variant{
+			ModemInterface: {
+				"Online":  dbus.MakeVariant(true),
+				"Powered": dbus.MakeVariant(true),
+				"Interfaces": dbus.MakeVariant([]string{
+					SimManagerInterface,
+					ConnectionManagerInterface,
+					NetworkRegistrationInterface,
+					PushNotificationInterface,
+				}),
+			},
+			SimManagerInterface: {
+				"SubscriberIdentity":  dbus.MakeVariant(identity),
+				"MobileCountryCode":   dbus.MakeVariant("001"),
+				"MobileNetworkCode":   dbus.MakeVariant("01"),
+				"PreferredLanguages":  dbus.MakeVariant([]string{"en"}),
+				"SubscriberNumbers":   dbus.MakeVariant([]string{}),
+				"CardIdentifier":      dbus.MakeVariant("89" + identity),
+				"ServiceProviderName": dbus.MakeVariant("ofonosim"),
+			},
+			ConnectionManagerInterface: {
+				"Attached":       dbus.MakeVariant(true),
+				"Powered":        dbus.MakeVariant(true),
+				"RoamingAllowed": dbus.MakeVariant(false),
+				"Bearer":         dbus.MakeVariant("lte"),
+				"Suspended":      dbus.MakeVariant(false),
+			},
+			NetworkRegistrationInterface: {
+				"Status":            dbus.MakeVariant("registered"),
+				"MobileCountryCode": dbus.MakeVariant("001"),
+				"MobileNetworkCode": dbus.MakeVariant("01"),
+				"Name":              dbus.MakeVariant("ofonosim"),
+			},
+		},
+	}
+	ofono.lock.Lock()
+	ofono.modems[path] = modem
+	ofono.order = append(ofono.order, path)
+	properties := copyProperties(modem.properties[ModemInterface])
+	ofono.lock.Unlock()
+
+	ofono.conn.RegisterObjectPath(path, ofono.calls)
+	ofono.signal("/", ManagerInterface, "ModemAdded", path, properties)
+	return modem
+}
+
+// RemoveModem removes modem and announces it with ModemRemoved.
+func (ofono *Ofono) RemoveModem(modem *Modem) {
+	ofono.lock.Lock()
+	delete(ofono.modems, modem.Path)
+	for i, path := range ofono.order {
+		if path == modem.Path {
+			ofono.order = append(ofono.order[:i], ofono.order[i+1:]...)
+			break
+		}
+	}
+	for _, context := range modem.contexts {
+		delete(ofono.contexts, context.Path)
+		ofono.conn.UnregisterObjectPath(context.Path)
+	}
+	ofono.lock.Unlock()
+
+	ofono.conn.UnregisterObjectPath(modem.Path)
+	ofono.signal("/", ManagerInterface, "ModemRemoved", modem.Path)
+}
+
+// AddContext adds a context of contextType, "internet" or "mms", to modem
+// with properties on top of the defaults of an inactive context.
+func (modem *Modem) AddContext(contextType string, properties map[string]dbus.Variant) *Context {
+	ofono := modem.ofono
+	ofono.lock.Lock()
+	context := &Context{
+		Path:  dbus.ObjectPath(fmt.Sprintf("%s/context%d", modem.Path, len(modem.contexts)+1)),
+		modem: modem,
+		properties: map[string]dbus.Variant{
+			"Name":            dbus.MakeVariant(contextType),
+			"Type":            dbus.MakeVariant(contextType),
+			"Active":          dbus.MakeVariant(false),
+			"Preferred":       dbus.MakeVariant(false),
+			"AccessPointName": dbus.MakeVariant(""),
+			"Username":        dbus.MakeVariant(""),
+			"Password":        dbus.MakeVariant(""),
+			"Protocol":        dbus.MakeVariant("ip"),
+			"MessageCenter":   dbus.MakeVariant(""),
+			"MessageProxy":    dbus.MakeVariant(""),
+			"Settings":        dbus.MakeVariant(map[string]dbus.Variant{}),
+		},
+	}
+	for name, value := range properties {
+		context.properties[name] = value
+	}
+	modem.contexts = append(modem.contexts, context)
+	ofono.contexts[context.Path] = context
+	added := copyProperties(context.properties)
+	ofono.lock.Unlock()
+
+	ofono.conn.RegisterObjectPath(context.Path, ofono.calls)
+	ofono.signal(modem.Path, ConnectionManagerInterface, "ContextAdded", context.Path, added)
+	return context
+}
+
+// Contexts returns the contexts of modem.
+func (modem *Modem) Contexts() []*Context {
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	return append([]*Context(nil), modem.contexts...)
+}
+
+// SetProperty sets the property name of the iface interface of modem, e.g.
+// Online of ModemInterface or Attached of ConnectionManagerInterface, and
+// emits PropertyChanged.
+func (modem *Modem) SetProperty(iface, name string, value interface{}) {
+	modem.ofono.lock.Lock()
+	properties, ok := modem.properties[iface]
+	if !ok {
+		properties = make(map[string]dbus.Variant)
+		modem.properties[iface] = properties
+	}
+	properties[name] = dbus.MakeVariant(value)
+	modem.ofono.lock.Unlock()
+	modem.ofono.signal(modem.Path, iface, "PropertyChanged", name, dbus.MakeVariant(value))
+}
+
+// Property returns the property name of the iface interface of modem.
+func (modem *Modem) Property(iface, name string) interface{} {
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	return modem.properties[iface][name].Value()
+}
+
+// FailActivation makes the next activations of contexts of modem fail, one
+// per error name, e.g. ErrorInProgress, before they succeed again.
+func (modem *Modem) FailActivation(errorNames ...string) {
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	modem.activationErrors = append(modem.activationErrors, errorNames...)
+}
+
+// AgentRegistered returns true if a push notification agent is registered
+// for modem.
+func (modem *Modem) AgentRegistered() bool {
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	return modem.agent != nil
+}
+
+// Push delivers the WAP push data from sender to the agent registered for
+// modem, as ofono does for an SMS carrying a push, and returns the error
+// the agent answers with.
+func (modem *Modem) Push(data []byte, sender string) error {
+	modem.ofono.lock.Lock()
+	agent := modem.agent
+	modem.ofono.lock.Unlock()
+	if agent == nil {
+		return errors.New("no push notification agent is registered")
+	}
+	info := map[string]dbus.Variant{
+		"Sender":        dbus.MakeVariant(sender),
+		"LocalSentTime": dbus.MakeVariant("2021-03-05T19:00:00+0100"),
+		"SentTime":      dbus.MakeVariant("2021-03-05T19:00:00+0100"),
+	}
+	reply, err := modem.ofono.conn.Object(agent.name, agent.path).Call(PushNotificationAgentInterface, "ReceiveNotification", data, info)
+	if err != nil {
+		return err
+	}
+	if reply.Type == dbus.TypeError {
+		return reply.AsError()
+	}
+	return nil
+}
+
+// ReleaseAgent tells the agent registered for modem that it is no longer
+// used, as ofono does when the modem goes away.
+func (modem *Modem) ReleaseAgent() error {
+	modem.ofono.lock.Lock()
+	agent := modem.agent
+	modem.agent = nil
+	modem.ofono.lock.Unlock()
+	if agent == nil {
+		return errors.New("no push notification agent is registered")
+	}
+	_, err := modem.ofono.conn.Object(agent.name, agent.path).Call(PushNotificationAgentInterface, "Release")
+	return err
+}
+
+// Property returns the property name of context.
+func (context *Context) Property(name string) interface{} {
+	context.modem.ofono.lock.Lock()
+	defer context.modem.ofono.lock.Unlock()
+	return context.properties[name].Value()
+}
+
+// Active returns true if context is active.
+func (context *Context) Active() bool {
+	active, _ := context.Property("Active").(bool)
+	return active
+}
+
+// serve answers the method calls on all objects.
+func (ofono *Ofono) serve() {
+	for msg := range ofono.calls {
+		reply := ofono.call(msg)
+		if err := ofono.conn.Send(reply); err != nil {
+			fmt.Fprintln(os.Stderr, "ofonosim: cannot send reply:", err)
+		}
+	}
+}
+
+// call handles the method call msg and returns the reply.
+func (ofono *Ofono) call(msg *dbus.Message) *dbus.Message {
+	ofono.lock.Lock()
+	modem := ofono.modems[msg.Path]
+	context := ofono.contexts[msg.Path]
+	ofono.lock.Unlock()
+
+	switch {
+	case msg.Path == "/" && msg.Interface == ManagerInterface && msg.Member == "GetModems":
+		return ofono.getModems(msg)
+	case modem != nil && msg.Member == "GetProperties":
+		return modem.getProperties(msg)
+	case modem != nil && msg.Interface == ConnectionManagerInterface && msg.Member == "GetContexts":
+		return modem.getContexts(msg)
+	case modem != nil && msg.Interface == ConnectionManagerInterface && msg.Member == "AddContext":
+		return modem.addContext(msg)
+	case modem != nil && msg.Interface == PushNotificationInterface && msg.Member == "RegisterAgent":
+		return modem.registerAgent(msg)
+	case modem != nil && msg.Interface == PushNotificationInterface && msg.Member == "UnregisterAgent":
+		return modem.unregisterAgent(msg)
+	case context != nil && msg.Interface == ConnectionContextInterface && msg.Member == "GetProperties":
+		reply := dbus.NewMethodReturnMessage(msg)
+		ofono.lock.Lock()
+		properties := copyProperties(context.properties)
+		ofono.lock.Unlock()
+		reply.AppendArgs(properties)
+		return reply
+	case context != nil && msg.Interface == ConnectionContextInterface && msg.Member == "SetProperty":
+		return context.setProperty(msg)
+	}
+	return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", fmt.Sprintf("%s.%s is not simulated on %s", msg.Interface, msg.Member, msg.Path))
+}
+
+// objectProperties is an element of the a(oa{sv}) replies of GetModems and
+// GetContexts.
+type objectProperties struct {
+	Path       dbus.ObjectPath
+	Properties map[string]dbus.Variant
+}
+
+func (ofono *Ofono) getModems(msg *dbus.Message) *dbus.Message {
+	ofono.lock.Lock()
+	modems := []objectProperties{}
+	for _, path := range ofono.order {
+		modems = append(modems, objectProperties{path, copyProperties(ofono.modems[path].properties[ModemInterface])})
+	}
+	ofono.lock.Unlock()
+	reply := dbus.NewMethodReturnMessage(msg)
+	reply.AppendArgs(modems)
+	return reply
+}
+
+func (modem *Modem) getProperties(msg *dbus.Message) *dbus.Message {
+	modem.ofono.lock.Lock()
+	properties, ok := modem.properties[msg.Interface]
+	properties = copyProperties(properties)
+	modem.ofono.lock.Unlock()
+	if !ok {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownInterface", msg.Interface+" is not available")
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	reply.AppendArgs(properties)
+	return reply
+}
+
+func (modem *Modem) getContexts(msg *dbus.Message) *dbus.Message {
+	modem.ofono.lock.Lock()
+	contexts := []objectProperties{}
+	for _, context := range modem.contexts {
+		contexts = append(contexts, objectProperties{context.Path, copyProperties(context.properties)})
+	}
+	modem.ofono.lock.Unlock()
+	reply := dbus.NewMethodReturnMessage(msg)
+	reply.AppendArgs(contexts)
+	return reply
+}
+
+func (modem *Modem) addContext(msg *dbus.Message) *dbus.Message {
+	var contextType string
+	if err := msg.Args(&contextType); err != nil {
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, err.Error())
+	}
+	context := modem.AddContext(contextType, nil)
+	reply := dbus.NewMethodReturnMessage(msg)
+	reply.AppendArgs(context.Path)
+	return reply
+}
+
+func (modem *Modem) registerAgent(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	if err := msg.Args(&path); err != nil {
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, err.Error())
+	}
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	if modem.agent != nil {
+		return dbus.NewErrorMessage(msg, "org.ofono.Error.InUse", "an agent is already registered")
+	}
+	modem.agent = &agent{name: msg.Sender, path: path}
+	return dbus.NewMethodReturnMessage(msg)
+}
+
+func (modem *Modem) unregisterAgent(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	if err := msg.Args(&path); err != nil {
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, err.Error())
+	}
+	modem.ofono.lock.Lock()
+	defer modem.ofono.lock.Unlock()
+	if modem.agent == nil || modem.agent.name != msg.Sender || modem.agent.path != path {
+		return dbus.NewErrorMessage(msg, ErrorNotFound, "the agent is not registered")
+	}
+	modem.agent = nil
+	return dbus.NewMethodReturnMessage(msg)
+}
+
+// setProperty sets a property of context. Activating it fails with the
+// queued activation errors of its modem and if the modem is not attached.
+func (context *Context) setProperty(msg *dbus.Message) *dbus.Message {
+	var name string
+	var value dbus.Variant
+	if err := msg.Args(&name, &value); err != nil {
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, err.Error())
+	}
+	modem := context.modem
+	ofono := modem.ofono
+	ofono.lock.Lock()
+	current, ok := context.properties[name]
+	if !ok || name == "Settings" {
+		ofono.lock.Unlock()
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, "invalid property "+name)
+	}
+	if fmt.Sprintf("%T", current.Value()) != fmt.Sprintf("%T", value.Value()) {
+		ofono.lock.Unlock()
+		return dbus.NewErrorMessage(msg, ErrorInvalidArguments, fmt.Sprintf("%s is a %T", name, current.Value()))
+	}
+	var settings *dbus.Variant
+	if name == "Active" && value.Value() == true && current.Value() != true {
+		if len(modem.activationErrors) > 0 {
+			errorName := modem.activationErrors[0]
+			modem.activationErrors = modem.activationErrors[1:]
+			ofono.lock.Unlock()
+			return dbus.NewErrorMessage(msg, errorName, "simulated activation failure")
+		}
+		if attached, _ := modem.properties[ConnectionManagerInterface]["Attached"].Value().(bool); !attached {
+			ofono.lock.Unlock()
+			return dbus.NewErrorMessage(msg, ErrorNotAttached, "not attached")
+		}
+		values := map[string]dbus.Variant{
+			"Interface": dbus.MakeVariant("rmnet_sim0"),
+			"Method":    dbus.MakeVariant("static"),
+			"Address":   dbus.MakeVariant("10.0.0.2"),
+		}
+		if proxy, _ := context.properties["MessageProxy"].Value().(string); proxy != "" {
+			values["Proxy"] = dbus.MakeVariant(proxy)
+		}
+		v := dbus.MakeVariant(values)
+		settings = &v
+		context.properties["Settings"] = v
+	}
+	if name == "Active" && value.Value() == false {
+		context.properties["Settings"] = dbus.MakeVariant(map[string]dbus.Variant{})
+	}
+	context.properties[name] = value
+	ofono.lock.Unlock()
+
+	if settings != nil {
+		ofono.signal(context.Path, ConnectionContextInterface, "PropertyChanged", "Settings", *settings)
+	}
+	ofono.signal(context.Path, ConnectionContextInterface, "PropertyChanged", name, value)
+	return dbus.NewMethodReturnMessage(msg)
+}
+
+// signal emits the signal member of iface on path with args.
+func (ofono *Ofono) signal(path dbus.ObjectPath, iface, member string, args ...interface{}) {
+	signal := dbus.NewSignalMessage(path, iface, member)
+	if err := signal.AppendArgs(args...); err != nil {
+		fmt.Fprintf(os.Stderr, "ofonosim: cannot append arguments of %s: %v\n", member, err)
+		return
+	}
+	if err := ofono.conn.Send(signal); err != nil {
+		fmt.Fprintf(os.Stderr, "ofonosim: cannot emit %s: %v\n", member, err)
+	}
+}
+
+func copyProperties(properties map[string]dbus.Variant) map[string]dbus.Variant {
+	c := make(map[string]dbus.Variant, len(properties))
+	for name, value := range properties {
+		c[name] = value
+	}
+	return c
+}
@@ -0,0 +1,162 @@
+// Package keyring keeps the key nuntium encrypts stored messages with in the
+// system keyring, using the freedesktop Secret Service API on the session
+// bus.
+//
+// The key is a secret of the default collection with the attributes
+// application=nuntium and purpose=storage. A locked keyring is unlocked if
+// that does not require prompting the user, otherwise the key is not
+// available.
+package keyring
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+const (
+	secretsName         = "org.freedesktop.secrets"
+	secretsPath         = dbus.ObjectPath("/org/freedesktop/secrets")
+	defaultCollection   = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	serviceInterface    = "org.freedesktop.Secret.Service"
+	collectionInterface = "org.freedesktop.Secret.Collection"
+	itemInterface       = "org.freedesktop.Secret.Item"
+	sessionInterface    = "org.freedesktop.Secret.Session"
+	noPrompt            = dbus.ObjectPath("/")
+)
+
+// KeySize is the size of the key in bytes, it is an AES-256 key.
+const KeySize = 32
+
+// ErrNoKey is returned by Lookup if the keyring holds no key.
+var ErrNoKey = errors.New("no storage key in the keyring")
+
+// attributes identify the key among the secrets of the keyring.
+var attributes = map[string]string{"application": "nuntium", "purpose": "storage"}
+
+// secret is the Secret struct of the Secret Service API.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// session is a session with the Secret Service.
+type session struct {
+	conn *dbus.Connection
+	path dbus.ObjectPath
+}
+
+// open opens a session with the Secret Service on the session bus conn, which
+// transfers secrets as they are.
+func open(conn *dbus.Connection) (*session, error) {
+	reply, err := conn.Object(secretsName, secretsPath).Call(serviceInterface, "OpenSession", "plain", dbus.MakeVariant(""))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keyring session: %w", err)
+	}
+	var output dbus.Variant
+	session := &session{conn: conn}
+	if err := reply.Args(&output, &session.path); err != nil {
+		return nil, fmt.Errorf("cannot parse keyring session: %w", err)
+	}
+	return session, nil
+}
+
+func (session *session) close() {
+	session.conn.Object(secretsName, session.path).Call(sessionInterface, "Close")
+}
+
+// lookup returns the key, or ErrNoKey if there is none.
+func (session *session) lookup() ([]byte, error) {
+	service := session.conn.Object(secretsName, secretsPath)
+	reply, err := service.Call(serviceInterface, "SearchItems", attributes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search keyring: %w", err)
+	}
+	var unlocked, locked []dbus.ObjectPath
+	if err := reply.Args(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("cannot parse keyring search: %w", err)
+	}
+	if len(unlocked) == 0 && len(locked) > 0 {
+		reply, err := service.Call(serviceInterface, "Unlock", locked)
+		if err != nil {
+			return nil, fmt.Errorf("cannot unlock keyring: %w", err)
+		}
+		var prompt dbus.ObjectPath
+		if err := reply.Args(&unlocked, &prompt); err != nil {
+			return nil, fmt.Errorf("cannot parse keyring unlock: %w", err)
+		}
+		if len(unlocked) == 0 {
+			return nil, errors.New("keyring is locked")
+		}
+	}
+	if len(unlocked) == 0 {
+		return nil, ErrNoKey
+	}
+	reply, err = session.conn.Object(secretsName, unlocked[0]).Call(itemInterface, "GetSecret", session.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get storage key: %w", err)
+	}
+	var s secret
+	if err := reply.Args(&s); err != nil {
+		return nil, fmt.Errorf("cannot parse storage key: %w", err)
+	}
+	if len(s.Value) != KeySize {
+		return nil, fmt.Errorf("storage key has %d bytes instead of %d", len(s.Value), KeySize)
+	}
+	return s.Value, nil
+}
+
+// create stores a new random key and returns it.
+func (session *session) create() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	properties := map[string]dbus.Variant{
+		itemInterface + ".Label":      dbus.MakeVariant("nuntium message storage"),
+		itemInterface + ".Attributes": dbus.MakeVariant(attributes),
+	}
+	s := secret{Session: session.path, Parameters: []byte{}, Value: key, ContentType: "application/octet-stream"}
+	reply, err := session.conn.Object(secretsName, defaultCollection).Call(collectionInterface, "CreateItem", properties, s, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot store storage key: %w", err)
+	}
+	var item, prompt dbus.ObjectPath
+	if err := reply.Args(&item, &prompt); err != nil {
+		return nil, fmt.Errorf("cannot parse stored storage key: %w", err)
+	}
+	if prompt != noPrompt {
+		return nil, errors.New("keyring is locked")
+	}
+	return key, nil
+}
+
+// Lookup returns the key kept in the keyring on the session bus conn, or
+// ErrNoKey if there is none.
+func Lookup(conn *dbus.Connection) ([]byte, error) {
+	session, err := open(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+	return session.lookup()
+}
+
+// Key returns the key kept in the keyring on the session bus conn, creating
+// it if there is none yet.
+func Key(conn *dbus.Connection) ([]byte, error) {
+	session, err := open(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+	key, err := session.lookup()
+	if err == ErrNoKey {
+		return session.create()
+	}
+	return key, err
+}
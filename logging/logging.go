@@ -0,0 +1,316 @@
+// Package logging writes the nuntium log as lines of key=value pairs, e.g.
+//
+//	time=2021-03-04T10:11:12.345Z level=info module=mediator msg="Sending message" uuid=1234
+//
+// so it can be filtered by level and module with grep. Every package logs
+// with its own Logger, whose level can be changed at runtime with Configure,
+// e.g. to debug a single module in the field.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+// DefaultLevel is the level of modules which are not configured otherwise.
+const DefaultLevel = Info
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < Debug || level > Error {
+		return "level(" + strconv.Itoa(int(level)) + ")"
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the level named name.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", name)
+}
+
+var (
+	mutex  sync.Mutex
+	output io.Writer = os.Stderr
+	// level is the level of the modules not in levels.
+	level  = DefaultLevel
+	levels = map[string]Level{}
+	// debug logs every module at Debug level regardless of the levels.
+	debug bool
+	now   = time.Now
+)
+
+// SetOutput makes the log go to w instead of standard error.
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+}
+
+// Configure sets the levels of the modules from spec, a comma separated list
+// of a default level and module=level pairs, e.g. "warn,ofono=debug". Modules
+// which are not listed log at the default level, an empty spec logs every
+// module at DefaultLevel.
+func Configure(spec string) error {
+	newLevel, newLevels, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	level, levels = newLevel, newLevels
+	return nil
+}
+
+// CheckSpec returns an error if spec is not a valid spec of Configure.
+func CheckSpec(spec string) error {
+	_, _, err := parseSpec(spec)
+	return err
+}
+
+// parseSpec parses a spec of Configure.
+func parseSpec(spec string) (Level, map[string]Level, error) {
+	defaultLevel, moduleLevels := DefaultLevel, map[string]Level{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		module, name := "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			module, name = strings.TrimSpace(item[:i]), item[i+1:]
+			if module == "" {
+				return defaultLevel, nil, fmt.Errorf("missing module in %q", item)
+			}
+		}
+		l, err := ParseLevel(name)
+		if err != nil {
+			return defaultLevel, nil, err
+		}
+		if module == "" {
+			defaultLevel = l
+		} else {
+			moduleLevels[module] = l
+		}
+	}
+	return defaultLevel, moduleLevels, nil
+}
+
+// Spec returns the levels of the modules as a spec of Configure.
+func Spec() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	items := []string{level.String()}
+	for module, l := range levels {
+		items = append(items, module+"="+l.String())
+	}
+	sort.Strings(items[1:])
+	return strings.Join(items, ",")
+}
+
+// ToggleDebug switches between logging every module at Debug level and the
+// configured levels. It returns true if debugging is on.
+func ToggleDebug() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	debug = !debug
+	return debug
+}
+
+// enabled returns true if module logs at l.
+func enabled(module string, l Level) bool {
+	if debug {
+		return true
+	}
+	if moduleLevel, ok := levels[module]; ok {
+		return l >= moduleLevel
+	}
+	return l >= level
+}
+
+// Logger logs the lines of a module, with the fields it was created with.
+type Logger struct {
+	module string
+	fields []interface{}
+}
+
+// New returns the logger of module.
+func New(module string) *Logger {
+	return &Logger{module: module}
+}
+
+// With returns a logger adding the key value pairs of keysAndValues to every
+// line, e.g. the UUID of a message.
+func (logger *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(logger.fields)+len(keysAndValues))
+	fields = append(append(fields, logger.fields...), keysAndValues...)
+	return &Logger{module: logger.module, fields: fields}
+}
+
+// Enabled returns true if lines of l are logged, so what they are built of
+// is only gathered if so.
+func (logger *Logger) Enabled(l Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return enabled(logger.module, l)
+}
+
+// Log writes a line of l with msg and the key value pairs of keysAndValues.
+func (logger *Logger) Log(l Level, msg string, keysAndValues ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !enabled(logger.module, l) {
+		return
+	}
+	var line strings.Builder
+	line.WriteString("time=")
+	line.WriteString(now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	writeField(&line, "level", l.String())
+	writeField(&line, "module", logger.module)
+	writeField(&line, "msg", strings.TrimRight(msg, "\n"))
+	writeFields(&line, logger.fields)
+	writeFields(&line, keysAndValues)
+	line.WriteByte('\n')
+	io.WriteString(output, line.String())
+}
+
+// writeFields writes the key value pairs of keysAndValues, a key without
+// value gets an empty one.
+func writeFields(line *strings.Builder, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = ""
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		writeField(line, fmt.Sprint(keysAndValues[i]), fmt.Sprint(value))
+	}
+}
+
+// writeField writes key=value, quoting value if it is empty or has spaces,
+// quotes, equal signs or control characters.
+func writeField(line *strings.Builder, key, value string) {
+	line.WriteByte(' ')
+	line.WriteString(key)
+	line.WriteByte('=')
+	if value == "" || strings.IndexFunc(value, needsQuote) >= 0 {
+		value = strconv.Quote(value)
+	}
+	line.WriteString(value)
+}
+
+func needsQuote(r rune) bool {
+	return r == '"' || r == '=' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+}
+
+// Debug logs its arguments formatted as by fmt.Sprint at Debug level.
+func (logger *Logger) Debug(args ...interface{}) {
+	logger.Log(Debug, fmt.Sprint(args...))
+}
+
+// Debugf logs its arguments formatted as by fmt.Sprintf at Debug level.
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.Log(Debug, fmt.Sprintf(format, args...))
+}
+
+// Debugw logs msg with the key value pairs of keysAndValues at Debug level.
+func (logger *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	logger.Log(Debug, msg, keysAndValues...)
+}
+
+// Info logs its arguments formatted as by fmt.Sprint at Info level.
+func (logger *Logger) Info(args ...interface{}) {
+	logger.Log(Info, fmt.Sprint(args...))
+}
+
+// Infof logs its arguments formatted as by fmt.Sprintf at Info level.
+func (logger *Logger) Infof(format string, args ...interface{}) {
+	logger.Log(Info, fmt.Sprintf(format, args...))
+}
+
+// Infow logs msg with the key value pairs of keysAndValues at Info level.
+func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	logger.Log(Info, msg, keysAndValues...)
+}
+
+// Warn logs its arguments formatted as by fmt.Sprint at Warn level.
+func (logger *Logger) Warn(args ...interface{}) {
+	logger.Log(Warn, fmt.Sprint(args...))
+}
+
+// Warnf logs its arguments formatted as by fmt.Sprintf at Warn level.
+func (logger *Logger) Warnf(format string, args ...interface{}) {
+	logger.Log(Warn, fmt.Sprintf(format, args...))
+}
+
+// Warnw logs msg with the key value pairs of keysAndValues at Warn level.
+func (logger *Logger) Warnw(msg string, keysAndValues ...interface{}) {
+	logger.Log(Warn, msg, keysAndValues...)
+}
+
+// Error logs its arguments formatted as by fmt.Sprint at Error level.
+func (logger *Logger) Error(args ...interface{}) {
+	logger.Log(Error, fmt.Sprint(args...))
+}
+
+// Errorf logs its arguments formatted as by fmt.Sprintf at Error level.
+func (logger *Logger) Errorf(format string, args ...interface{}) {
+	logger.Log(Error, fmt.Sprintf(format, args...))
+}
+
+// Errorw logs msg with the key value pairs of keysAndValues at Error level.
+func (logger *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	logger.Log(Error, msg, keysAndValues...)
+}
+
+// Fatal logs its arguments formatted as by fmt.Sprint at Error level and
+// exits.
+func (logger *Logger) Fatal(args ...interface{}) {
+	logger.Log(Error, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+// Fatalf logs its arguments formatted as by fmt.Sprintf at Error level and
+// exits.
+func (logger *Logger) Fatalf(format string, args ...interface{}) {
+	logger.Log(Error, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Writer returns a writer logging every line written to it at Info level, to
+// pass the output of the standard log package, see log.SetOutput.
+func (logger *Logger) Writer() io.Writer {
+	return writer{logger}
+}
+
+type writer struct {
+	logger *Logger
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Log(Info, line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// capture makes the log go to a buffer at a fixed time. The returned
+// function restores it with the levels.
+func capture() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	now = func() time.Time { return time.Date(2021, 3, 4, 10, 11, 12, 345e6, time.UTC) }
+	return &buf, func() {
+		SetOutput(os.Stderr)
+		now = time.Now
+		Configure("")
+		debug = false
+	}
+}
+
+func TestLog(t *testing.T) {
+	buf, restore := capture()
+	defer restore()
+	logger := New("mediator").With("uuid", "1234")
+	logger.Infow("Sending message", "to", "+1 555", "size", 300)
+	logger.Errorf("Cannot send: %v", "timeout")
+	logger.Debug("not logged")
+	want := `time=2021-03-04T10:11:12.345Z level=info module=mediator msg="Sending message" uuid=1234 to="+1 555" size=300
+time=2021-03-04T10:11:12.345Z level=error module=mediator msg="Cannot send: timeout" uuid=1234
+`
+	if buf.String() != want {
+		t.Errorf("log is\n%swant\n%s", buf, want)
+	}
+
+	buf.Reset()
+	New("mms").Warnw("Odd header", "value", `a="b"`, "empty", "", "dangling")
+	want = `time=2021-03-04T10:11:12.345Z level=warn module=mms msg="Odd header" value="a=\"b\"" empty="" dangling=""` + "\n"
+	if buf.String() != want {
+		t.Errorf("log is\n%swant\n%s", buf, want)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	buf, restore := capture()
+	defer restore()
+	if err := Configure("warn, ofono=debug"); err != nil {
+		t.Fatal(err)
+	}
+	if spec := Spec(); spec != "warn,ofono=debug" {
+		t.Errorf("Spec() = %q", spec)
+	}
+	ofono, mms := New("ofono"), New("mms")
+	if !ofono.Enabled(Debug) || mms.Enabled(Info) || !mms.Enabled(Warn) {
+		t.Error("levels are not applied")
+	}
+	mms.Info("not logged")
+	if buf.Len() != 0 {
+		t.Errorf("logged %q", buf)
+	}
+	if !ToggleDebug() || !mms.Enabled(Debug) {
+		t.Error("ToggleDebug did not enable debugging")
+	}
+	if ToggleDebug() || mms.Enabled(Info) {
+		t.Error("ToggleDebug did not restore the levels")
+	}
+
+	for _, spec := range []string{"verbose", "ofono=", "=debug"} {
+		if err := Configure(spec); err == nil {
+			t.Errorf("Configure(%q) succeeded", spec)
+		}
+	}
+	if spec := Spec(); spec != "warn,ofono=debug" {
+		t.Errorf("failed Configure changed the levels to %q", spec)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buf, restore := capture()
+	defer restore()
+	std := log.New(New("nuntium").Writer(), "", 0)
+	std.Print("Using system bus")
+	want := `time=2021-03-04T10:11:12.345Z level=info module=nuntium msg="Using system bus"` + "\n"
+	if buf.String() != want {
+		t.Errorf("log is\n%swant\n%s", buf, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Package media prepares the attachments of outgoing messages for sending.
+//
+// Carriers refuse messages above a size limit, often 300KB to 1MB, which a
+// single photo of a phone camera exceeds. Fit downscales and re-encodes JPEG
+// and PNG images as JPEG so that a message fits into such a limit, keeping
+// the other attachments as they are.
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+const (
+	// headerAllowance is how many bytes of a limit are kept for the
+	// headers of the m-send.req, partAllowance for those of every part.
+	headerAllowance = 1024
+	partAllowance   = 256
+	// maxDimension is the longest side images are scaled to at first,
+	// minDimension the shortest side they are not scaled below.
+	maxDimension = 1600
+	minDimension = 160
+	// scaleStep is what the size of an image is multiplied with when it
+	// does not fit at any of qualities.
+	scaleStep = 0.75
+)
+
+// qualities are the JPEG qualities an image is tried with at every size.
+var qualities = []int{85, 70, 50}
+
+// Compression describes what Fit did to the attachments of a message.
+type Compression struct {
+	// Images is the number of images which were re-encoded.
+	Images int
+	// OriginalSize and Size are the sizes of these images before and
+	// after.
+	OriginalSize, Size uint64
+}
+
+// resizable returns true for the media types of images Fit re-encodes.
+func resizable(mediaType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])) {
+	case "image/jpeg", "image/jpg", "image/png":
+		return true
+	}
+	return false
+}
+
+// Fit re-encodes the images of attachments which don't fit into limit bytes
+// together with the other attachments. The smaller images are handled
+// first, the room they leave goes to the larger ones. An image is left as it
+// is if it cannot be decoded or re-encoding does not make it smaller, so the
+// message may still be above limit.
+func Fit(attachments []*mms.Attachment, limit uint64) Compression {
+	var compression Compression
+	var images []*mms.Attachment
+	used := uint64(headerAllowance)
+	for _, a := range attachments {
+		used += partAllowance
+		if resizable(a.MediaType) {
+			images = append(images, a)
+		} else {
+			used += uint64(len(a.Data))
+		}
+	}
+	if limit == 0 || len(images) == 0 {
+		return compression
+	}
+	sort.SliceStable(images, func(i, j int) bool { return len(images[i].Data) < len(images[j].Data) })
+	for i, a := range images {
+		var share int
+		if used < limit {
+			share = int(limit-used) / (len(images) - i)
+		}
+		if len(a.Data) > share {
+			if data, ok := fitImage(a.Data, share); ok {
+				compression.Images++
+				compression.OriginalSize += uint64(len(a.Data))
+				compression.Size += uint64(len(data))
+				a.Data, a.MediaType, a.Charset = data, "image/jpeg", ""
+			}
+		}
+		used += uint64(len(a.Data))
+	}
+	return compression
+}
+
+// fitImage re-encodes the image data as a JPEG of at most size bytes if
+// possible, or else as small as it gets. It returns false if the image
+// cannot be decoded or does not get smaller.
+func fitImage(data []byte, size int) ([]byte, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	img = orient(img, exifOrientation(data))
+
+	bounds := img.Bounds()
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+	scale := 1.0
+	if longest := math.Max(width, height); longest > maxDimension {
+		scale = maxDimension / longest
+	}
+	best := data
+	for {
+		w, h := int(width*scale), int(height*scale)
+		scaled := downscale(img, w, h)
+		for _, quality := range qualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, false
+			}
+			if buf.Len() < len(best) {
+				best = buf.Bytes()
+			}
+			if buf.Len() <= size {
+				return best, true
+			}
+		}
+		if w < minDimension || h < minDimension || w == 1 || h == 1 {
+			break
+		}
+		scale *= scaleStep
+	}
+	return best, len(best) < len(data)
+}
@@ -0,0 +1,135 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// noise returns a PNG of width by height random pixels, which does not
+// compress well.
+func noise(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	r := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = byte(r.Intn(256))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFit(t *testing.T) {
+	small := noise(t, 100, 100)
+	large := noise(t, 2000, 1000)
+	text := bytes.Repeat([]byte("a"), 10000)
+	attachments := []*mms.Attachment{
+		{MediaType: "text/plain", Charset: "utf-8", Data: text},
+		{MediaType: "image/png", Data: large},
+		{MediaType: "image/png", Data: small},
+	}
+	const limit = 300 * 1024
+
+	if compression := Fit(attachments, 0); compression.Images != 0 {
+		t.Errorf("Fit without limit = %+v", compression)
+	}
+	compression := Fit(attachments, limit)
+	if compression.Images != 1 || compression.OriginalSize != uint64(len(large)) {
+		t.Errorf("Fit = %+v", compression)
+	}
+	if !bytes.Equal(attachments[0].Data, text) || attachments[0].Charset != "utf-8" {
+		t.Error("Fit changed the text attachment")
+	}
+	if !bytes.Equal(attachments[2].Data, small) || attachments[2].MediaType != "image/png" {
+		t.Error("Fit changed the small image")
+	}
+	resized := attachments[1]
+	if resized.MediaType != "image/jpeg" || uint64(len(resized.Data)) != compression.Size {
+		t.Errorf("resized image is %s of %d bytes", resized.MediaType, len(resized.Data))
+	}
+	var total int
+	for _, a := range attachments {
+		total += len(a.Data)
+	}
+	if total > limit {
+		t.Errorf("attachments have %d bytes after Fit", total)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(resized.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() > maxDimension || bounds.Dx() != 2*bounds.Dy() {
+		t.Errorf("resized image is %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	broken := &mms.Attachment{MediaType: "image/jpeg", Data: bytes.Repeat([]byte{0xff}, 2*limit)}
+	if compression := Fit([]*mms.Attachment{broken}, limit); compression.Images != 0 || broken.MediaType != "image/jpeg" {
+		t.Errorf("Fit of an undecodable image = %+v", compression)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.NRGBA{0, 0, 0, 0xff})
+	img.Set(1, 0, color.NRGBA{0, 0, 0, 0xff})
+	img.Set(0, 1, color.NRGBA{0, 0, 0, 0xff})
+	img.Set(1, 1, color.NRGBA{0, 0, 0, 0xff})
+	// The right half is transparent and becomes white.
+	scaled := downscale(img, 2, 1)
+	if c := scaled.RGBAAt(0, 0); c != (color.RGBA{0, 0, 0, 0xff}) {
+		t.Errorf("left pixel = %v", c)
+	}
+	if c := scaled.RGBAAt(1, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("right pixel = %v", c)
+	}
+}
+
+// exifJPEG returns the start of a JPEG with an EXIF Orientation tag.
+func exifJPEG(orientation byte, bigEndian bool) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0}
+	if bigEndian {
+		tiff = []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0}
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+	data := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 0, 0, 0xff, 0xe1, byte(length >> 8), byte(length)}
+	return append(append(data, segment...), 0xff, 0xda, 0, 2)
+}
+
+func TestOrientation(t *testing.T) {
+	if o := exifOrientation(exifJPEG(6, false)); o != orientationRotate90 {
+		t.Errorf("little endian orientation = %d", o)
+	}
+	if o := exifOrientation(exifJPEG(8, true)); o != orientationRotate270 {
+		t.Errorf("big endian orientation = %d", o)
+	}
+	if o := exifOrientation(noise(t, 1, 1)); o != orientationNormal {
+		t.Errorf("PNG orientation = %d", o)
+	}
+	truncated := exifJPEG(6, false)
+	if o := exifOrientation(truncated[:20]); o != orientationNormal {
+		t.Errorf("truncated orientation = %d", o)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	rotated := orient(img, orientationRotate90).(*image.RGBA)
+	if bounds := rotated.Bounds(); bounds.Dx() != 1 || bounds.Dy() != 2 {
+		t.Fatalf("rotated bounds = %v", bounds)
+	}
+	if c := rotated.RGBAAt(0, 0); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("rotated top pixel = %v", c)
+	}
+	rotated = orient(img, orientationRotate270).(*image.RGBA)
+	if c := rotated.RGBAAt(0, 1); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("rotated bottom pixel = %v", c)
+	}
+}
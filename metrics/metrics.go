@@ -0,0 +1,124 @@
+// Package metrics counts how the messages nuntium handles fare, e.g. how many
+// downloads fail and why, so the rates can be shown to users and attached to
+// bug reports. The counters start at zero with every start of nuntium.
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Names of the counters. A counter counted by IncCode is also counted per
+// code, as the name followed by a dot and the code.
+const (
+	DownloadsAttempted = "DownloadsAttempted"
+	DownloadsSucceeded = "DownloadsSucceeded"
+	DownloadsFailed    = "DownloadsFailed"
+	SendsAttempted     = "SendsAttempted"
+	SendsSucceeded     = "SendsSucceeded"
+	SendsFailed        = "SendsFailed"
+	SendRetries        = "SendRetries"
+	PushesDecoded      = "PushesDecoded"
+	DecodeFailures     = "DecodeFailures"
+	MessagesBlocked    = "MessagesBlocked"
+	MessagesSpam       = "MessagesSpam"
+)
+
+// Statistics are the counters at a point in time.
+type Statistics struct {
+	// Since is when counting started.
+	Since    time.Time
+	Counters map[string]uint64
+}
+
+var (
+	lock     sync.Mutex
+	since    = time.Now()
+	counters = map[string]uint64{}
+	// generation changes with every count, so unchanged counters need
+	// not be written again.
+	generation uint64
+)
+
+// Inc counts one for the counter name.
+func Inc(name string) {
+	lock.Lock()
+	defer lock.Unlock()
+	counters[name]++
+	generation++
+}
+
+// IncCode counts one for the counter name and for it per code, e.g. the code
+// of the error a download failed with.
+func IncCode(name, code string) {
+	lock.Lock()
+	defer lock.Unlock()
+	counters[name]++
+	if code != "" {
+		counters[name+"."+code]++
+	}
+	generation++
+}
+
+// Get returns the current counters.
+func Get() Statistics {
+	statistics, _ := get()
+	return statistics
+}
+
+func get() (Statistics, uint64) {
+	lock.Lock()
+	defer lock.Unlock()
+	statistics := Statistics{Since: since, Counters: make(map[string]uint64, len(counters))}
+	for name, count := range counters {
+		statistics.Counters[name] = count
+	}
+	return statistics, generation
+}
+
+// Reset sets all counters to zero and restarts counting.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	since = time.Now()
+	counters = map[string]uint64{}
+	generation++
+}
+
+// Writer writes the counters to a JSON file when they changed.
+type Writer struct {
+	// Path is the file written.
+	Path    string
+	written uint64
+	once    bool
+}
+
+// Write writes the counters to the file if they changed since the last
+// write. The file is replaced atomically, so it can be read at any time.
+func (w *Writer) Write() error {
+	statistics, current := get()
+	if w.once && current == w.written {
+		return nil
+	}
+	data, err := json.MarshalIndent(statistics, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.Path), 0755); err != nil {
+		return err
+	}
+	tmp := w.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	w.written, w.once = current, true
+	return nil
+}
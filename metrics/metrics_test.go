@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	Reset()
+	Inc(DownloadsAttempted)
+	Inc(DownloadsAttempted)
+	IncCode(DownloadsFailed, "x-ubports-nuntium-mms-error-get-proxy")
+	IncCode(DownloadsFailed, "")
+
+	statistics := Get()
+	for name, want := range map[string]uint64{
+		DownloadsAttempted: 2,
+		DownloadsFailed:    2,
+		DownloadsFailed + ".x-ubports-nuntium-mms-error-get-proxy": 1,
+	} {
+		if count := statistics.Counters[name]; count != want {
+			t.Errorf("%s = %d, want %d", name, count, want)
+		}
+	}
+	if len(statistics.Counters) != 3 {
+		t.Errorf("counters = %v", statistics.Counters)
+	}
+
+	Reset()
+	if statistics := Get(); len(statistics.Counters) != 0 {
+		t.Errorf("counters after Reset = %v", statistics.Counters)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Reset()
+	Inc(SendsAttempted)
+
+	w := Writer{Path: filepath.Join(dir, "nuntium", "statistics.json")}
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Statistics
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Counters[SendsAttempted] != 1 || written.Since.IsZero() {
+		t.Errorf("written %+v", written)
+	}
+
+	// Unchanged counters are not written again.
+	os.Remove(w.Path)
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(w.Path); !os.IsNotExist(err) {
+		t.Error("unchanged counters were written")
+	}
+	Inc(SendsSucceeded)
+	if err := w.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(w.Path); err != nil {
+		t.Error("changed counters were not written: ", err)
+	}
+}
@@ -25,7 +25,6 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"reflect"
 	"strings"
 )
@@ -76,10 +75,14 @@ func NewAttachment(id, contentType, filePath string) (*Attachment, error) {
 			case "charset":
 				ct.Charset = strings.TrimSpace(field[1])
 			default:
-				log.Println("Unhandled field in attachment", field[0])
+				logger.Debug("Unhandled field in attachment ", field[0])
 			}
 		}
 	}
+	// Text handed over by clients is UTF-8 unless they say otherwise.
+	if ct.Charset == "" && strings.HasPrefix(ct.MediaType, "text/") {
+		ct.Charset = "utf-8"
+	}
 
 	if contentType == "application/smil" {
 		start, err := getSmilStart(data)
@@ -103,6 +106,40 @@ func getSmilStart(smilData []byte) (string, error) {
 	return smilStart[:i+1], nil
 }
 
+// encryptedMediaTypes are the media types of S/MIME, PGP and other enveloped
+// content which cannot be rendered without being decrypted first.
+var encryptedMediaTypes = map[string]bool{
+	"application/pkcs7-mime":    true,
+	"application/x-pkcs7-mime":  true,
+	"application/pgp-encrypted": true,
+	"multipart/encrypted":       true,
+}
+
+// IsEncryptedMediaType returns true if mediaType, with or without parameters,
+// describes encrypted content.
+func IsEncryptedMediaType(mediaType string) bool {
+	mediaType = strings.SplitN(mediaType, ";", 2)[0]
+	return encryptedMediaTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// IsEncrypted returns true if the attachment holds encrypted content.
+func (a Attachment) IsEncrypted() bool {
+	return IsEncryptedMediaType(a.MediaType)
+}
+
+// IsEncrypted returns true if the message or any of its parts is encrypted.
+func (pdu *MRetrieveConf) IsEncrypted() bool {
+	if pdu.Content.IsEncrypted() {
+		return true
+	}
+	for i := range pdu.Attachments {
+		if pdu.Attachments[i].IsEncrypted() {
+			return true
+		}
+	}
+	return false
+}
+
 //GetSmil returns the text corresponding to the ContentType that holds the SMIL
 func (pdu *MRetrieveConf) GetSmil() (string, error) {
 	for i := range pdu.Attachments {
@@ -113,14 +150,20 @@ func (pdu *MRetrieveConf) GetSmil() (string, error) {
 	return "", errors.New("cannot find SMIL data part")
 }
 
-//GetDataParts returns the non SMIL ContentType data parts
+//GetDataParts returns the non SMIL ContentType data parts. Parts carrying a
+//contact or a calendar get the VCARD or VCALENDAR media type, whatever the
+//sender labeled them with.
 func (pdu *MRetrieveConf) GetDataParts() []Attachment {
 	var dataParts []Attachment
 	for i := range pdu.Attachments {
 		if pdu.Attachments[i].MediaType == "application/smil" {
 			continue
 		}
-		dataParts = append(dataParts, pdu.Attachments[i])
+		part := pdu.Attachments[i]
+		if mediaType := part.cardMediaType(); mediaType != "" {
+			part.MediaType = mediaType
+		}
+		dataParts = append(dataParts, part)
 	}
 	return dataParts
 }
@@ -131,6 +174,9 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 	if parts, err = dec.ReadUintVar(nil, ""); err != nil {
 		return err
 	}
+	if parts > MaxParts {
+		return fmt.Errorf("%d parts are more than the %d supported", parts, MaxParts)
+	}
 	var dataParts []Attachment
 	dec.log = dec.log + fmt.Sprintf("Number of parts: %d\n", parts)
 	for i := uint64(0); i < parts; i++ {
@@ -142,6 +188,9 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 		if err != nil {
 			return err
 		}
+		if err := dec.checkLength(headerLen + dataLen); err != nil {
+			return err
+		}
 		headerEnd := dec.Offset + int(headerLen)
 		dec.log = dec.log + fmt.Sprintf("Attachament len(header): %d - len(data) %d\n", headerLen, dataLen)
 		var ct Attachment
@@ -158,7 +207,9 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 		if _, err := dec.ReadBoundedBytes(&ctReflected, "Data", dec.Offset+int(dataLen)); err != nil {
 			return err
 		}
-		if ct.MediaType == "application/smil" || strings.HasPrefix(ct.MediaType, "text/plain") || ct.MediaType == "" {
+		if ct.IsEncrypted() {
+			dec.log = dec.log + fmt.Sprintf("Encrypted part of %d bytes\n", len(ct.Data))
+		} else if ct.MediaType == "application/smil" || strings.HasPrefix(ct.MediaType, "text/plain") || ct.MediaType == "" {
 			dec.log = dec.log + fmt.Sprintf("%s\n", ct.Data)
 		}
 		if ct.Charset != "" {
@@ -166,16 +217,43 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 		}
 		dataParts = append(dataParts, ct)
 	}
-	dataPartsR := reflect.ValueOf(dataParts)
-	reflectedPdu.FieldByName("Attachments").Set(dataPartsR)
+	if err := setAttachments(reflectedPdu, dataParts); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReadEncryptedContent reads a body which is encrypted as a whole as a single
+// opaque part, so it can be handed as is to a viewer capable of decrypting it.
+func (dec *MMSDecoder) ReadEncryptedContent(reflectedPdu *reflect.Value, mediaType string) error {
+	dec.Offset++
+	if dec.Offset >= len(dec.Data) {
+		return fmt.Errorf("message ended prematurely, offset: %d and payload length is %d", dec.Offset, len(dec.Data))
+	}
+	ct := Attachment{MediaType: mediaType, Offset: dec.Offset}
+	ct.Data = dec.Data[dec.Offset:]
+	dec.Offset = len(dec.Data) - 1
+	dec.log = dec.log + fmt.Sprintf("Encrypted body of %d bytes\n", len(ct.Data))
+	return setAttachments(reflectedPdu, []Attachment{ct})
+}
 
+// setAttachments sets the Attachments of the PDU decoded to attachments.
+func setAttachments(reflectedPdu *reflect.Value, attachments []Attachment) error {
+	field, ok := pduField(reflectedPdu, "Attachments")
+	if !ok || !assignable(field, attachments) {
+		return errors.New("decoding structure has no attachments")
+	}
+	field.Set(reflect.ValueOf(attachments))
 	return nil
 }
 
 func (dec *MMSDecoder) ReadMMSHeaders(ctMember *reflect.Value, headerEnd int) error {
 	for dec.Offset < headerEnd {
-		var err error
-		param, _ := dec.ReadInteger(nil, "")
+		param, err := dec.ReadInteger(nil, "")
+		if err != nil {
+			return err
+		}
 		switch param {
 		case MMS_PART_CONTENT_LOCATION:
 			_, err = dec.ReadString(ctMember, "ContentLocation")
@@ -215,7 +293,10 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 	}
 
 	for dec.Offset < len(dec.Data) && dec.Offset < endOffset {
-		param, _ := dec.ReadInteger(nil, "")
+		param, err := dec.ReadInteger(nil, "")
+		if err != nil {
+			return err
+		}
 		switch param {
 		case WSP_PARAMETER_TYPE_Q:
 			err = dec.ReadQ(ctMember)
@@ -226,10 +307,10 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 		case WSP_PARAMETER_TYPE_TYPE:
 			_, err = dec.ReadInteger(ctMember, "Type")
 		case WSP_PARAMETER_TYPE_NAME_DEFUNCT:
-			log.Println("Using deprecated Name header")
+			logger.Debug("Using deprecated Name header")
 			_, err = dec.ReadString(ctMember, "Name")
 		case WSP_PARAMETER_TYPE_FILENAME_DEFUNCT:
-			log.Println("Using deprecated FileName header")
+			logger.Debug("Using deprecated FileName header")
 			_, err = dec.ReadString(ctMember, "FileName")
 		case WSP_PARAMETER_TYPE_DIFFERENCES:
 			err = errors.New("Unhandled Differences")
@@ -238,27 +319,27 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 		case WSP_PARAMETER_TYPE_CONTENT_TYPE:
 			_, err = dec.ReadString(ctMember, "Type")
 		case WSP_PARAMETER_TYPE_START_DEFUNCT:
-			log.Println("Using deprecated Start header")
+			logger.Debug("Using deprecated Start header")
 			_, err = dec.ReadString(ctMember, "Start")
 		case WSP_PARAMETER_TYPE_START_INFO_DEFUNCT:
-			log.Println("Using deprecated StartInfo header")
+			logger.Debug("Using deprecated StartInfo header")
 			_, err = dec.ReadString(ctMember, "StartInfo")
 		case WSP_PARAMETER_TYPE_COMMENT_DEFUNCT:
-			log.Println("Using deprecated Comment header")
+			logger.Debug("Using deprecated Comment header")
 			_, err = dec.ReadString(ctMember, "Comment")
 		case WSP_PARAMETER_TYPE_DOMAIN_DEFUNCT:
-			log.Println("Using deprecated Domain header")
+			logger.Debug("Using deprecated Domain header")
 			_, err = dec.ReadString(ctMember, "Domain")
 		case WSP_PARAMETER_TYPE_MAX_AGE:
 			err = errors.New("Unhandled Max Age")
 		case WSP_PARAMETER_TYPE_PATH_DEFUNCT:
-			log.Println("Using deprecated Path header")
+			logger.Debug("Using deprecated Path header")
 			_, err = dec.ReadString(ctMember, "Path")
 		case WSP_PARAMETER_TYPE_SECURE:
-			log.Println("Unhandled Secure header detected")
+			logger.Debug("Unhandled Secure header detected")
 		case WSP_PARAMETER_TYPE_SEC:
 			v, _ := dec.ReadShortInteger(nil, "")
-			log.Println("Using deprecated and unhandled Sec header with value", v)
+			logger.Debug("Using deprecated and unhandled Sec header with value ", v)
 		case WSP_PARAMETER_TYPE_MAC:
 			err = errors.New("Unhandled MAC")
 		case WSP_PARAMETER_TYPE_CREATION_DATE:
@@ -283,7 +364,7 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 			_, err = dec.ReadString(ctMember, "Path")
 		case WSP_PARAMETER_TYPE_UNTYPED:
 			v, _ := dec.ReadString(nil, "")
-			log.Println("Unhandled Secure header detected with value", v)
+			logger.Debug("Unhandled Secure header detected with value ", v)
 		default:
 			err = fmt.Errorf("Unhandled parameter %#x == %d at offset %d", param, param, dec.Offset)
 		}
@@ -0,0 +1,64 @@
+package mms
+
+// Cancel status defined in OMA-MMS-ENC-V1_3 section 7.3.7 for
+// X-Mms-Cancel-Status
+const (
+	CancelStatusReceived  byte = 128
+	CancelStatusCorrupted byte = 129
+)
+
+// MCancelReq holds a m-cancel.req message defined in OMA-MMS-ENC-V1_3
+// section 6.13. The MMSC pushes it to withdraw the message it notified with
+// CancelId as its X-Mms-Transaction-Id or X-Mms-Content-Location, and it is
+// sent to the MMSC to cancel the delivery of a sent message, CancelId being
+// its Message-ID.
+type MCancelReq struct {
+	UUID          string `encode:"no"`
+	Type          byte
+	TransactionId string
+	Version       byte
+	CancelId      string
+}
+
+func NewMCancelReq() *MCancelReq {
+	return &MCancelReq{Type: TYPE_CANCEL_REQ}
+}
+
+// NewMCancelReqFor creates the request to cancel the sent message with the
+// Message-ID messageId.
+func NewMCancelReqFor(messageId string) *MCancelReq {
+	uuid := GenUUID()
+	return &MCancelReq{
+		UUID:          uuid,
+		Type:          TYPE_CANCEL_REQ,
+		TransactionId: uuid,
+		Version:       MMS_MESSAGE_VERSION_1_3,
+		CancelId:      messageId,
+	}
+}
+
+// MCancelConf holds a m-cancel.conf message defined in OMA-MMS-ENC-V1_3
+// section 6.14, the answer to a m-cancel.req.
+type MCancelConf struct {
+	UUID          string `encode:"no"`
+	Type          byte
+	TransactionId string
+	Version       byte
+	CancelStatus  byte
+}
+
+func NewMCancelConf() *MCancelConf {
+	return &MCancelConf{Type: TYPE_CANCEL_CONF}
+}
+
+// NewMCancelConf creates the answer to the m-cancel.req pushed by the MMSC,
+// with the received or corrupted status.
+func (mCancelReq *MCancelReq) NewMCancelConf(status byte) *MCancelConf {
+	return &MCancelConf{
+		UUID:          GenUUID(),
+		Type:          TYPE_CANCEL_CONF,
+		TransactionId: mCancelReq.TransactionId,
+		Version:       MMS_MESSAGE_VERSION_1_3,
+		CancelStatus:  status,
+	}
+}
@@ -0,0 +1,76 @@
+package mms
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeMCancelReq(t *testing.T) {
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_CANCEL_REQ,
+		0x80 + X_MMS_TRANSACTION_ID, 't', '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_3,
+		0x80 + X_MMS_CANCEL_ID, 'n', '1', 0,
+	}
+	mCancelReq := NewMCancelReq()
+	if err := NewDecoder(inputBytes).Decode(mCancelReq); err != nil {
+		t.Fatal(err)
+	}
+	if mCancelReq.TransactionId != "t1" || mCancelReq.CancelId != "n1" {
+		t.Errorf("TransactionId, CancelId = %q, %q, want t1, n1", mCancelReq.TransactionId, mCancelReq.CancelId)
+	}
+
+	var outBytes bytes.Buffer
+	if err := NewEncoder(&outBytes).Encode(mCancelReq.NewMCancelConf(CancelStatusReceived)); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_CANCEL_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, 't', '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_3,
+		0x80 + X_MMS_CANCEL_STATUS, CancelStatusReceived,
+	}
+	if !bytes.Equal(outBytes.Bytes(), want) {
+		t.Errorf("encoded m-cancel.conf = %#x, want %#x", outBytes.Bytes(), want)
+	}
+}
+
+func TestEncodeMCancelReq(t *testing.T) {
+	mCancelReq := NewMCancelReqFor("m1")
+	mCancelReq.TransactionId = "1"
+
+	var outBytes bytes.Buffer
+	if err := NewEncoder(&outBytes).Encode(mCancelReq); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_CANCEL_REQ,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_3,
+		0x80 + X_MMS_CANCEL_ID, 'm', '1', 0,
+	}
+	if !bytes.Equal(outBytes.Bytes(), want) {
+		t.Errorf("encoded m-cancel.req = %#x, want %#x", outBytes.Bytes(), want)
+	}
+}
+
+func TestDecodeMCancelConf(t *testing.T) {
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_CANCEL_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_3,
+		0x80 + X_MMS_CANCEL_STATUS, CancelStatusCorrupted,
+	}
+	mCancelConf := NewMCancelConf()
+	if err := NewDecoder(inputBytes).Decode(mCancelConf); err != nil {
+		t.Fatal(err)
+	}
+	if mCancelConf.CancelStatus != CancelStatusCorrupted {
+		t.Errorf("CancelStatus = %#x, want %#x", mCancelConf.CancelStatus, CancelStatusCorrupted)
+	}
+
+	// A MMSC without m-cancel support answering with another message.
+	if err := NewDecoder([]byte{0x80 + X_MMS_MESSAGE_TYPE, TYPE_SEND_CONF}).Decode(NewMCancelConf()); err == nil {
+		t.Error("decoding a m-send.conf as m-cancel.conf succeeded")
+	}
+}
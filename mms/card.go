@@ -0,0 +1,231 @@
+package mms
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"path"
+	"strings"
+)
+
+// Media types data parts carrying a contact or a calendar are mapped to by
+// GetDataParts, whatever the sender labeled them with.
+const (
+	VCARD     = "text/x-vcard"
+	VCALENDAR = "text/x-vcalendar"
+)
+
+// Kinds of cards, see CardKind.
+const (
+	CardContact = "contact"
+	CardEvent   = "event"
+)
+
+var (
+	vcardTypes     = map[string]bool{"text/x-vcard": true, "text/vcard": true, "text/directory": true, "application/vcard": true}
+	vcalendarTypes = map[string]bool{"text/x-vcalendar": true, "text/calendar": true, "application/ics": true}
+)
+
+// cardMediaType returns VCARD or VCALENDAR if a carries a contact or a
+// calendar, going by its media type or, for generic ones, the extension of
+// its name or the start of its content. Otherwise it returns an empty string.
+func (a Attachment) cardMediaType() string {
+	t := strings.ToLower(strings.TrimSpace(strings.SplitN(a.MediaType, ";", 2)[0]))
+	switch {
+	case vcardTypes[t]:
+		return VCARD
+	case vcalendarTypes[t]:
+		return VCALENDAR
+	case t != "" && t != "application/octet-stream" && t != "text/plain":
+		return ""
+	}
+	for _, name := range []string{a.Name, a.FileName, a.ContentLocation} {
+		switch strings.ToLower(path.Ext(name)) {
+		case ".vcf":
+			return VCARD
+		case ".vcs", ".ics":
+			return VCALENDAR
+		}
+	}
+	if t == "text/plain" {
+		return ""
+	}
+	start := bytes.ToUpper(bytes.TrimSpace(a.Data[:min(len(a.Data), 64)]))
+	switch {
+	case bytes.HasPrefix(start, []byte("BEGIN:VCARD")):
+		return VCARD
+	case bytes.HasPrefix(start, []byte("BEGIN:VCALENDAR")):
+		return VCALENDAR
+	}
+	return ""
+}
+
+// CardKind returns CardContact for a vCard and CardEvent for a vCalendar
+// part, as mapped by GetDataParts, and an empty string for other parts.
+func (a Attachment) CardKind() string {
+	switch a.cardMediaType() {
+	case VCARD:
+		return CardContact
+	case VCALENDAR:
+		return CardEvent
+	}
+	return ""
+}
+
+// CardText returns the content of a vCard or vCalendar part in UTF-8, with
+// folded lines joined and the values vCard 2.1 encodes in quoted-printable or
+// other charsets, going by their CHARSET and ENCODING parameters, decoded.
+func (a Attachment) CardText() (string, error) {
+	text, err := a.Text()
+	var lines []string
+	for _, line := range unfoldCard(text) {
+		lines = append(lines, normalizeCardLine(line))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n", err
+}
+
+// CardTitle returns what the UI shows for a card: the formatted name of a
+// contact or the summary of the first event or todo of a calendar. It is
+// empty if the card has none.
+func (a Attachment) CardTitle() string {
+	text, _ := a.CardText()
+	var name, title string
+	inEntry := false
+	for _, line := range strings.Split(text, "\r\n") {
+		property, value := cardProperty(line)
+		switch property {
+		case "FN":
+			return unescapeCardValue(value)
+		case "N":
+			if name == "" {
+				fields := strings.Split(value, ";")
+				// Given name first, then the family name.
+				if len(fields) > 1 {
+					fields[0], fields[1] = fields[1], fields[0]
+				}
+				name = strings.Join(strings.Fields(unescapeCardValue(strings.Join(fields, " "))), " ")
+			}
+		case "BEGIN":
+			upper := strings.ToUpper(value)
+			inEntry = inEntry || upper == "VEVENT" || upper == "VTODO"
+		case "SUMMARY":
+			if inEntry && title == "" {
+				title = unescapeCardValue(value)
+			}
+		}
+	}
+	if title != "" {
+		return title
+	}
+	return name
+}
+
+// unfoldCard splits text into lines, joining folded lines and the soft line
+// breaks of quoted-printable values.
+func unfoldCard(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if n := len(lines); n > 0 {
+			last := lines[n-1]
+			if quotedPrintable(last) && strings.HasSuffix(last, "=") {
+				lines[n-1] = last[:len(last)-1] + line
+				continue
+			}
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				lines[n-1] = last + line[1:]
+				continue
+			}
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// quotedPrintable returns true if the value of the property line is encoded
+// in quoted-printable.
+func quotedPrintable(line string) bool {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return false
+	}
+	for _, param := range strings.Split(line[:i], ";")[1:] {
+		param = strings.ToUpper(strings.TrimSpace(param))
+		if param == "QUOTED-PRINTABLE" || param == "ENCODING=QUOTED-PRINTABLE" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCardLine decodes the value of the property line if it is in
+// quoted-printable or a charset other than UTF-8 and drops the parameters
+// telling so.
+func normalizeCardLine(line string) string {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return line
+	}
+	params := strings.Split(line[:i], ";")
+	value := line[i+1:]
+	var kept []string
+	var charset string
+	qp := false
+	for _, param := range params[1:] {
+		upper := strings.ToUpper(strings.TrimSpace(param))
+		switch {
+		case upper == "QUOTED-PRINTABLE" || upper == "ENCODING=QUOTED-PRINTABLE":
+			qp = true
+		case strings.HasPrefix(upper, "CHARSET="):
+			charset = strings.TrimSpace(param)[len("CHARSET="):]
+		default:
+			kept = append(kept, param)
+		}
+	}
+	if !qp && charset == "" {
+		return line
+	}
+	data := []byte(value)
+	if qp {
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+		if err != nil {
+			return line
+		}
+		data = decoded
+	}
+	text, err := DecodeText(data, charset)
+	if err != nil {
+		return line
+	}
+	// Decoded line breaks are escaped as in vCard 3.0.
+	text = strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(text)
+	return strings.Join(append(params[:1], kept...), ";") + ":" + text
+}
+
+// cardProperty returns the upper case name and the value of the property
+// line, without parameters and groups.
+func cardProperty(line string) (name, value string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+	name = strings.SplitN(line[:i], ";", 2)[0]
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return strings.ToUpper(strings.TrimSpace(name)), line[i+1:]
+}
+
+// unescapeCardValue undoes the escaping of text values.
+func unescapeCardValue(value string) string {
+	return strings.TrimSpace(strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
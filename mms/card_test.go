@@ -0,0 +1,75 @@
+package mms
+
+import "testing"
+
+func TestGetDataPartsCards(t *testing.T) {
+	pdu := &MRetrieveConf{Attachments: []Attachment{
+		{MediaType: "application/smil"},
+		{MediaType: "text/vcard", Data: []byte("BEGIN:VCARD\r\n")},
+		{MediaType: "text/directory"},
+		{MediaType: "application/octet-stream", Name: "Party.VCS"},
+		{MediaType: "application/octet-stream", Data: []byte("\r\nbegin:vcard\r\nVERSION:2.1\r\n")},
+		{MediaType: "text/plain", ContentLocation: "contact.vcf"},
+		{MediaType: "text/plain", Data: []byte("BEGIN:VCARD is how it starts")},
+		{MediaType: "text/calendar"},
+		{MediaType: "application/octet-stream", Data: []byte{0xff, 0xd8}},
+	}}
+	want := []string{VCARD, VCARD, VCALENDAR, VCARD, VCARD, "text/plain", VCALENDAR, "application/octet-stream"}
+	parts := pdu.GetDataParts()
+	if len(parts) != len(want) {
+		t.Fatalf("GetDataParts() returned %d parts, want %d", len(parts), len(want))
+	}
+	for i, part := range parts {
+		if part.MediaType != want[i] {
+			t.Errorf("part %d has media type %q, want %q", i, part.MediaType, want[i])
+		}
+	}
+	if pdu.Attachments[1].MediaType != "text/vcard" {
+		t.Error("GetDataParts() changed the attachments of the message")
+	}
+}
+
+func TestCardText(t *testing.T) {
+	card := Attachment{MediaType: VCARD, Charset: "utf-8", Data: []byte("BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"N;CHARSET=ISO-8859-1;ENCODING=QUOTED-PRINTABLE:M=FCller;J=FCrgen\r\n" +
+		"ADR;HOME;CHARSET=ISO-8859-1;QUOTED-PRINTABLE:;;Hauptstra=DFe 1=0D=0A=\r\n" +
+		"Berlin\r\n" +
+		"NOTE:folded\r\n" +
+		" note\r\n" +
+		"END:VCARD\r\n")}
+	want := "BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"N:Müller;Jürgen\r\n" +
+		"ADR;HOME:;;Hauptstraße 1\\nBerlin\r\n" +
+		"NOTE:foldednote\r\n" +
+		"END:VCARD\r\n"
+	if got, err := card.CardText(); err != nil || got != want {
+		t.Errorf("CardText() = %q, %v, want %q", got, err, want)
+	}
+	if got := card.CardTitle(); got != "Jürgen Müller" {
+		t.Errorf("CardTitle() = %q", got)
+	}
+}
+
+func TestCardTitle(t *testing.T) {
+	testCases := []struct {
+		data string
+		kind string
+		want string
+	}{
+		{"BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe;Jane;;;\r\nFN:Jane Doe\\, PhD\r\nEND:VCARD\r\n", CardContact, "Jane Doe, PhD"},
+		{"BEGIN:VCARD\nitem1.FN:Grouped\nEND:VCARD\n", CardContact, "Grouped"},
+		{"BEGIN:VCALENDAR\r\nVERSION:1.0\r\nSUMMARY:Calendar\r\nBEGIN:VEVENT\r\nSUMMARY:Dinner\r\nDTSTART:20210305T190000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n", CardEvent, "Dinner"},
+		{"BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n", CardEvent, ""},
+	}
+	for _, tc := range testCases {
+		part := Attachment{MediaType: "application/octet-stream", Data: []byte(tc.data)}
+		if kind := part.CardKind(); kind != tc.kind {
+			t.Errorf("CardKind() of %q = %q, want %q", tc.data, kind, tc.kind)
+		}
+		if got := part.CardTitle(); got != tc.want {
+			t.Errorf("CardTitle() of %q = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
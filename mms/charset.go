@@ -0,0 +1,152 @@
+package mms
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// gsmAlphabet is the GSM 03.38 default alphabet, indexed by septet. The
+// escape septet 0x1b selects gsmExtension for the next septet.
+var gsmAlphabet = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+var gsmExtension = map[byte]rune{
+	0x0a: '\f',
+	0x14: '^',
+	0x28: '{',
+	0x29: '}',
+	0x2f: '\\',
+	0x3c: '[',
+	0x3d: '~',
+	0x3e: ']',
+	0x40: '|',
+	0x65: '€',
+}
+
+// charsetAliases maps other names of the charsets DecodeText handles to the
+// names used in CHARSETS.
+var charsetAliases = map[string]string{
+	"ascii":                "us-ascii",
+	"utf8":                 "utf-8",
+	"ucs-2":                "iso-10646-ucs-2",
+	"utf16":                "utf-16",
+	"latin1":               "iso-8859-1",
+	"cp1252":               "windows-1252",
+	"gsm":                  "gsm-default-alphabet",
+	"gsm-7bit":             "gsm-default-alphabet",
+	"x-gsm-7bit":           "gsm-default-alphabet",
+	"gsm-default-alphabet": "gsm-default-alphabet",
+}
+
+// NormalizeCharset returns the canonical name of charset, lower case and as
+// listed in CHARSETS where it is one of them.
+func NormalizeCharset(charset string) string {
+	charset = strings.Replace(strings.ToLower(strings.TrimSpace(charset)), "_", "-", -1)
+	if strings.HasPrefix(charset, "iso8859-") {
+		charset = "iso-8859-" + strings.TrimPrefix(charset, "iso8859-")
+	}
+	if alias, ok := charsetAliases[charset]; ok {
+		return alias
+	}
+	return charset
+}
+
+// IsUTF8Charset returns true if text in charset needs no transcoding, which
+// is the case for UTF-8, its US-ASCII subset and text without a charset.
+func IsUTF8Charset(charset string) bool {
+	switch NormalizeCharset(charset) {
+	case "", "*", "utf-8", "us-ascii":
+		return true
+	}
+	return false
+}
+
+// DecodeText transcodes data in charset to UTF-8. Text without a charset is
+// taken to be UTF-8 and invalid UTF-8 sequences are replaced by U+FFFD. For a
+// charset it cannot transcode it returns data unchanged and an error.
+func DecodeText(data []byte, charset string) (string, error) {
+	switch charset = NormalizeCharset(charset); charset {
+	case "", "*", "utf-8", "us-ascii":
+		if utf8.Valid(data) {
+			return string(data), nil
+		}
+		return strings.ToValidUTF8(string(data), string(utf8.RuneError)), nil
+	case "iso-8859-1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case "iso-10646-ucs-2", "utf-16", "utf-16be":
+		return decodeUTF16(data, true), nil
+	case "utf-16le":
+		return decodeUTF16(data, false), nil
+	case "gsm-default-alphabet":
+		return decodeGSM(data), nil
+	}
+	if table, ok := singleByteCharsets[charset]; ok {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if b < 0x80 {
+				runes[i] = rune(b)
+			} else {
+				runes[i] = table[b-0x80]
+			}
+		}
+		return string(runes), nil
+	}
+	return string(data), fmt.Errorf("cannot transcode from charset %q", charset)
+}
+
+// decodeUTF16 decodes UTF-16, big endian unless bigEndian is false or a byte
+// order mark says otherwise. An odd trailing octet is dropped.
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xfe && data[1] == 0xff:
+			data, bigEndian = data[2:], true
+		case data[0] == 0xff && data[1] == 0xfe:
+			data, bigEndian = data[2:], false
+		}
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeGSM decodes unpacked GSM 03.38 septets, one per octet.
+func decodeGSM(data []byte) string {
+	runes := make([]rune, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		septet := data[i] & 0x7f
+		if septet == 0x1b && i+1 < len(data) {
+			i++
+			if r, ok := gsmExtension[data[i]&0x7f]; ok {
+				runes = append(runes, r)
+				continue
+			}
+			// An unknown extension falls back to the default alphabet.
+			septet = data[i] & 0x7f
+		}
+		if septet == 0x1b {
+			runes = append(runes, ' ')
+			continue
+		}
+		runes = append(runes, gsmAlphabet[septet])
+	}
+	return string(runes)
+}
+
+// Text returns the content of a text part transcoded from its charset to
+// UTF-8, see DecodeText.
+func (a Attachment) Text() (string, error) {
+	return DecodeText(a.Data, a.Charset)
+}
@@ -0,0 +1,88 @@
+package mms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		charset string
+		want    string
+		wantErr bool
+	}{
+		{"utf-8", []byte("héllo"), "utf-8", "héllo", false},
+		{"no charset", []byte("hi"), "", "hi", false},
+		{"invalid utf-8", []byte{'a', 0xff, 'b'}, "utf-8", "a�b", false},
+		{"latin1", []byte{'c', 0xe9}, "iso-8859-1", "cé", false},
+		{"alias", []byte{0xe9}, "ISO_8859-1", "é", false},
+		{"latin2", []byte{0xa3, 0xb1}, "iso-8859-2", "Łą", false},
+		{"greek", []byte{0xe1, 0xe2}, "iso-8859-7", "αβ", false},
+		{"cyrillic", []byte{0xbf, 0xe0}, "iso-8859-5", "Пр", false},
+		{"euro", []byte{0xa4}, "iso-8859-15", "€", false},
+		{"windows-1252", []byte{0x80, 0x93}, "windows-1252", "€“", false},
+		{"ucs-2", []byte{0x00, 'h', 0x04, 0x10}, "iso-10646-ucs-2", "hА", false},
+		{"utf-16 bom", []byte{0xff, 0xfe, 'h', 0x00, 0x3d, 0xd8, 0x00, 0xde}, "utf-16", "h😀", false},
+		{"utf-16le", []byte{'o', 0x00, 'k', 0x00}, "utf-16le", "ok", false},
+		{"gsm", []byte{0x00, 0x48, 0x69, 0x1b, 0x65, 0x11}, "gsm-7bit", "@Hi€_", false},
+		{"unsupported", []byte("raw"), "big5", "raw", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeText(tt.data, tt.charset)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DecodeText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGSMAlphabet(t *testing.T) {
+	if len(gsmAlphabet) != 128 {
+		t.Errorf("the GSM alphabet has %d characters", len(gsmAlphabet))
+	}
+}
+
+func TestReadEncodedStringCharsets(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"plain", []byte{0x00, 's', 'u', 'b', 0x00}, "sub"},
+		{"utf-8", []byte{0x00, 0x06, 0xea, 'h', 0xc3, 0xa9, 'y', 0x00}, "héy"},
+		{"latin1", []byte{0x00, 0x04, 0x84, 'h', 0xe9, 0x00}, "hé"},
+		{"utf-16", []byte{0x00, 0x09, 0x02, 0x03, 0xf7, 0x00, 'h', 0x04, 0x10, 0x00, 0x00}, "hА"},
+		{"text charset", []byte{0x00, 0x09, 'l', 'a', 't', 'i', 'n', '1', 0x00, 0xe9, 0x00}, "é"},
+		{"unknown charset", []byte{0x00, 0x06, 0x02, 0x27, 0x10, 'o', 'k', 0x00}, "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := MRetrieveConf{}
+			reflected := reflect.ValueOf(&pdu).Elem()
+			dec := NewDecoder(tt.data)
+			got, err := dec.ReadEncodedString(&reflected, "Subject")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || pdu.Subject != tt.want {
+				t.Errorf("ReadEncodedString() = %q, Subject %q, want %q", got, pdu.Subject, tt.want)
+			}
+			if dec.Offset != len(tt.data)-1 {
+				t.Errorf("decoding ended at %d of %d", dec.Offset, len(tt.data))
+			}
+		})
+	}
+}
+
+func TestAttachmentText(t *testing.T) {
+	part := Attachment{MediaType: "text/plain", Charset: "utf-16be", Data: []byte{0x00, 'o', 0x00, 'k'}}
+	if text, err := part.Text(); err != nil || text != "ok" {
+		t.Errorf("Text() = %q, %v", text, err)
+	}
+}
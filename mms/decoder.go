@@ -22,12 +22,28 @@
 package mms
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"reflect"
+	"strings"
 	"time"
 )
 
+// Limits of the decoder. PDUs come straight off the network, a crafted one
+// must not have it spend unbounded memory and time on headers and parts no
+// genuine message has.
+const (
+	// MaxHeaders is the most headers a PDU may have.
+	MaxHeaders = 1024
+	// MaxParts is the most parts a multipart body may have.
+	MaxParts = 256
+	// maxUintVarLength is the most octets of a uintvar.
+	maxUintVarLength = 5
+	// maxLongIntegerLength is the most octets of a long integer which fit
+	// into an uint64.
+	maxLongIntegerLength = 8
+)
+
 func NewDecoder(data []byte) *MMSDecoder {
 	return &MMSDecoder{Data: data}
 }
@@ -42,16 +58,61 @@ func (dec *MMSDecoder) setPduField(pdu *reflect.Value, name string, v interface{
 	setter func(*reflect.Value, interface{})) {
 
 	if name != "" {
-		field := pdu.FieldByName(name)
-		if field.IsValid() {
+		field, ok := pduField(pdu, name)
+		if ok && assignable(field, v) {
 			setter(&field, v)
 			dec.log = dec.log + fmt.Sprintf("Setting %s to %v\n", name, v)
 		} else {
-			log.Println("Field", name, "not in decoding structure")
+			logger.Debug("Field ", name, " not in decoding structure")
 		}
 	}
 }
 
+// CheckOffset returns an ErrorDecodeShortData if offset is outside of the
+// data, it is checked before reading at offset.
+func (dec *MMSDecoder) CheckOffset(offset int) error {
+	if offset < 0 || offset >= len(dec.Data) {
+		return ErrorDecodeShortData{len(dec.Data), offset}
+	}
+	return nil
+}
+
+// checkLength returns an ErrorDecodeShortData if the length bytes after the
+// current offset are not in the data.
+func (dec *MMSDecoder) checkLength(length uint64) error {
+	if remaining := uint64(len(dec.Data) - dec.Offset - 1); length > remaining {
+		return ErrorDecodeShortData{len(dec.Data), dec.Offset + 1 + int(remaining)}
+	}
+	return nil
+}
+
+// pduField returns the field name of pdu, ok is false if there is none, e.g.
+// for a header which does not belong to the PDU decoded.
+func pduField(pdu *reflect.Value, name string) (field reflect.Value, ok bool) {
+	if pdu == nil {
+		return reflect.Value{}, false
+	}
+	field = pdu.FieldByName(name)
+	return field, field.IsValid() && field.CanSet()
+}
+
+// assignable returns true if the decoded value v can be set to field.
+func assignable(field reflect.Value, v interface{}) bool {
+	switch v.(type) {
+	case string:
+		return field.Kind() == reflect.String
+	case uint64:
+		switch field.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return true
+		}
+		return false
+	case float64:
+		return field.Kind() == reflect.Float32 || field.Kind() == reflect.Float64
+	}
+	return field.Type() == reflect.TypeOf(v)
+}
+
 func setterString(field *reflect.Value, v interface{}) { field.SetString(v.(string)) }
 func setterUint64(field *reflect.Value, v interface{}) { field.SetUint(v.(uint64)) }
 func setterSlice(field *reflect.Value, v interface{})  { field.SetBytes(v.([]byte)) }
@@ -60,6 +121,9 @@ func setterTime(field *reflect.Value, v interface{})   { field.Set(reflect.Value
 func (dec *MMSDecoder) ReadEncodedString(reflectedPdu *reflect.Value, hdr string) (string, error) {
 	var length uint64
 	var err error
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return "", err
+	}
 	switch {
 	case dec.Data[dec.Offset+1] < SHORT_LENGTH_MAX:
 		var l byte
@@ -72,17 +136,31 @@ func (dec *MMSDecoder) ReadEncodedString(reflectedPdu *reflect.Value, hdr string
 	if err != nil {
 		return "", err
 	}
-	if length != 0 {
-		charset, err := dec.ReadCharset(nil, "")
-		if err != nil {
-			return "", err
-		}
-		dec.log = dec.log + fmt.Sprintf("Next string encoded with: %s\n", charset)
+	if length == 0 {
+		return dec.ReadString(reflectedPdu, hdr)
+	}
+	if err := dec.checkLength(length); err != nil {
+		return "", err
 	}
-	var str string
-	if str, err = dec.ReadString(reflectedPdu, hdr); err != nil {
+	end := dec.Offset + int(length)
+	charset, err := dec.ReadCharset(nil, "")
+	if err != nil {
 		return "", err
 	}
+	if dec.Offset > end {
+		return "", fmt.Errorf("charset of encoded string overruns its length of %d @%d", length, end)
+	}
+	dec.log = dec.log + fmt.Sprintf("Next string encoded with: %s\n", charset)
+	// The text is read up to the end of the value rather than to the first
+	// NUL as UTF-16 text is full of them.
+	raw := dec.Data[dec.Offset+1 : end+1]
+	dec.Offset = end
+	str, err := DecodeText(raw, charset)
+	if err != nil {
+		logger.Warnf("Passing through encoded string: %v", err)
+	}
+	str = strings.TrimPrefix(strings.TrimRight(str, "\x00"), "\x7f")
+	dec.setPduField(reflectedPdu, hdr, str, setterString)
 	return str, nil
 }
 
@@ -97,7 +175,9 @@ func (dec *MMSDecoder) ReadQ(reflectedPdu *reflect.Value) error {
 	} else {
 		q = (q - 1) / 100
 	}
-	reflectedPdu.FieldByName("Q").SetFloat(q)
+	if field, ok := pduField(reflectedPdu, "Q"); ok && assignable(field, q) {
+		field.SetFloat(q)
+	}
 	return nil
 }
 
@@ -110,12 +190,15 @@ func (dec *MMSDecoder) ReadQ(reflectedPdu *reflect.Value) error {
 // Length-quote = <Octet 31>
 // Length = Uintvar-integer
 func (dec *MMSDecoder) ReadLength(reflectedPdu *reflect.Value) (length uint64, err error) {
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return 0, err
+	}
 	switch {
 	case dec.Data[dec.Offset+1]&0x7f <= SHORT_LENGTH_MAX:
 		l, err := dec.ReadShortInteger(nil, "")
 		v := uint64(l)
-		if reflectedPdu != nil {
-			reflectedPdu.FieldByName("Length").SetUint(v)
+		if field, ok := pduField(reflectedPdu, "Length"); ok && assignable(field, v) {
+			field.SetUint(v)
 		}
 		return v, err
 	case dec.Data[dec.Offset+1] == LENGTH_QUOTE:
@@ -129,24 +212,37 @@ func (dec *MMSDecoder) ReadLength(reflectedPdu *reflect.Value) (length uint64, e
 	return 0, fmt.Errorf("Unhandled length %#x @%d", dec.Data[dec.Offset+1], dec.Offset)
 }
 
+// ReadCharset reads a charset parameter value, either a well known charset
+// encoded as an integer or a charset name as text. Unknown charset codes are
+// logged and yield an empty charset, their text is passed through as is.
 func (dec *MMSDecoder) ReadCharset(reflectedPdu *reflect.Value, hdr string) (string, error) {
 	var charset string
 
-	if dec.Data[dec.Offset] == ANY_CHARSET {
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return "", err
+	}
+	switch next := dec.Data[dec.Offset+1]; {
+	case next == ANY_CHARSET:
 		dec.Offset++
 		charset = "*"
-	} else {
+	case next >= TEXT_MIN && next <= TEXT_MAX:
+		name, err := dec.ReadString(nil, "")
+		if err != nil {
+			return "", err
+		}
+		charset = NormalizeCharset(name)
+	default:
 		charCode, err := dec.ReadInteger(nil, "")
 		if err != nil {
 			return "", err
 		}
 		var ok bool
 		if charset, ok = CHARSETS[charCode]; !ok {
-			return "", fmt.Errorf("Cannot find matching charset for %#x == %d", charCode, charCode)
+			logger.Warnf("Cannot find matching charset for %#x == %d", charCode, charCode)
 		}
 	}
-	if hdr != "" {
-		reflectedPdu.FieldByName("Charset").SetString(charset)
+	if field, ok := pduField(reflectedPdu, "Charset"); hdr != "" && ok && assignable(field, charset) {
+		field.SetString(charset)
 	}
 	return charset, nil
 }
@@ -156,19 +252,27 @@ func (dec *MMSDecoder) ReadMediaType(reflectedPdu *reflect.Value, hdr string) (e
 	var endOffset int
 	origOffset := dec.Offset
 
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return err
+	}
 	if dec.Data[dec.Offset+1] <= SHORT_LENGTH_MAX || dec.Data[dec.Offset+1] == LENGTH_QUOTE {
 		if length, err := dec.ReadLength(nil); err != nil {
 			return err
+		} else if err := dec.checkLength(length); err != nil {
+			return err
 		} else {
 			endOffset = int(length) + dec.Offset
 		}
 	}
 
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return err
+	}
 	if dec.Data[dec.Offset+1] >= TEXT_MIN && dec.Data[dec.Offset+1] <= TEXT_MAX {
 		if mediaType, err = dec.ReadString(nil, ""); err != nil {
 			return err
 		}
-	} else if mt, err := dec.ReadInteger(nil, ""); err == nil && len(CONTENT_TYPES) > int(mt) {
+	} else if mt, err := dec.ReadInteger(nil, ""); err == nil && mt < uint64(len(CONTENT_TYPES)) {
 		mediaType = CONTENT_TYPES[mt]
 	} else {
 		return fmt.Errorf("cannot decode media type for field beginning with %#x@%d", dec.Data[origOffset], origOffset)
@@ -179,7 +283,9 @@ func (dec *MMSDecoder) ReadMediaType(reflectedPdu *reflect.Value, hdr string) (e
 		dec.Offset = endOffset
 	}
 
-	reflectedPdu.FieldByName(hdr).SetString(mediaType)
+	if field, ok := pduField(reflectedPdu, hdr); ok && assignable(field, mediaType) {
+		field.SetString(mediaType)
+	}
 	dec.log = dec.log + fmt.Sprintf("%s: %s\n", hdr, mediaType)
 
 	return nil
@@ -192,14 +298,20 @@ func (dec *MMSDecoder) ReadTo(reflectedPdu *reflect.Value) error {
 		return err
 	}
 	// field in the golang structure
-	to := reflectedPdu.FieldByName("To")
-	toSlice := reflect.Append(to, reflect.ValueOf(toField))
-	reflectedPdu.FieldByName("To").Set(toSlice)
+	to, ok := pduField(reflectedPdu, "To")
+	if !ok || to.Type() != reflect.TypeOf([]string(nil)) {
+		logger.Debug("Field To not in decoding structure")
+		return nil
+	}
+	to.Set(reflect.Append(to, reflect.ValueOf(toField)))
 	return err
 }
 
 func (dec *MMSDecoder) ReadString(reflectedPdu *reflect.Value, hdr string) (string, error) {
 	dec.Offset++
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return "", err
+	}
 	if dec.Data[dec.Offset] == 34 { // Skip the quote char(34) == "
 		dec.Offset++
 	}
@@ -220,6 +332,9 @@ func (dec *MMSDecoder) ReadString(reflectedPdu *reflect.Value, hdr string) (stri
 
 func (dec *MMSDecoder) ReadShortInteger(reflectedPdu *reflect.Value, hdr string) (byte, error) {
 	dec.Offset++
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return 0, err
+	}
 	/*
 		TODO fix use of short when not short
 		if dec.Data[dec.Offset] & 0x80 == 0 {
@@ -234,6 +349,9 @@ func (dec *MMSDecoder) ReadShortInteger(reflectedPdu *reflect.Value, hdr string)
 
 func (dec *MMSDecoder) ReadByte(reflectedPdu *reflect.Value, hdr string) (byte, error) {
 	dec.Offset++
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return 0, err
+	}
 	v := dec.Data[dec.Offset]
 	dec.setPduField(reflectedPdu, hdr, uint64(v), setterUint64)
 
@@ -241,6 +359,9 @@ func (dec *MMSDecoder) ReadByte(reflectedPdu *reflect.Value, hdr string) (byte,
 }
 
 func (dec *MMSDecoder) ReadBoundedBytes(reflectedPdu *reflect.Value, hdr string, end int) ([]byte, error) {
+	if end < dec.Offset || end > len(dec.Data) {
+		return nil, ErrorDecodeShortData{len(dec.Data), end}
+	}
 	v := []byte(dec.Data[dec.Offset:end])
 	dec.setPduField(reflectedPdu, hdr, v, setterSlice)
 	dec.Offset = end - 1
@@ -253,10 +374,19 @@ func (dec *MMSDecoder) ReadBoundedBytes(reflectedPdu *reflect.Value, hdr string,
 // set to 1
 func (dec *MMSDecoder) ReadUintVar(reflectedPdu *reflect.Value, hdr string) (value uint64, err error) {
 	dec.Offset++
-	for dec.Data[dec.Offset]>>7 == 0x01 {
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return 0, err
+	}
+	for octets := 1; dec.Data[dec.Offset]>>7 == 0x01; octets++ {
+		if octets == maxUintVarLength {
+			return 0, fmt.Errorf("uintvar longer than %d octets @%d", maxUintVarLength, dec.Offset)
+		}
 		value = value << 7
 		value |= uint64(dec.Data[dec.Offset] & 0x7F)
 		dec.Offset++
+		if err := dec.CheckOffset(dec.Offset); err != nil {
+			return 0, err
+		}
 	}
 
 	value = value << 7
@@ -267,6 +397,9 @@ func (dec *MMSDecoder) ReadUintVar(reflectedPdu *reflect.Value, hdr string) (val
 }
 
 func (dec *MMSDecoder) ReadInteger(reflectedPdu *reflect.Value, hdr string) (uint64, error) {
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return 0, err
+	}
 	param := dec.Data[dec.Offset+1]
 	var v uint64
 	var err error
@@ -285,10 +418,19 @@ func (dec *MMSDecoder) ReadInteger(reflectedPdu *reflect.Value, hdr string) (uin
 
 func (dec *MMSDecoder) ReadLongInteger(reflectedPdu *reflect.Value, hdr string) (uint64, error) {
 	dec.Offset++
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return 0, err
+	}
 	size := int(dec.Data[dec.Offset])
 	if size > SHORT_LENGTH_MAX {
 		return 0, fmt.Errorf("cannot encode long integer, length was %d but expected %d", size, SHORT_LENGTH_MAX)
 	}
+	if size > maxLongIntegerLength {
+		return 0, fmt.Errorf("long integer of %d octets @%d does not fit", size, dec.Offset)
+	}
+	if err := dec.checkLength(uint64(size)); err != nil {
+		return 0, err
+	}
 	dec.Offset++
 	end := dec.Offset + size
 	var v uint64
@@ -349,7 +491,7 @@ func (dec *MMSDecoder) ReadExpiry(reflectedPdu *reflect.Value, received time.Tim
 	if token == ExpiryTokenRelative {
 		expiry = received.Add(time.Duration(value) * time.Second)
 	} else {
-		expiry = time.Unix(int64(value), 0)
+		expiry = EpochTime(int64(value))
 	}
 
 	if reflectedPdu != nil {
@@ -358,10 +500,10 @@ func (dec *MMSDecoder) ReadExpiry(reflectedPdu *reflect.Value, received time.Tim
 			if field.Type() == reflect.TypeOf(time.Time{}) {
 				dec.setPduField(reflectedPdu, "Expiry", expiry, setterTime)
 			} else {
-				log.Printf("Field Expiry in decoding structure is not a time.Time type")
+				logger.Debugf("Field Expiry in decoding structure is not a time.Time type")
 			}
 		} else {
-			log.Printf("Field Expiry is not in decoding structure")
+			logger.Debugf("Field Expiry is not in decoding structure")
 		}
 	}
 	dec.log = dec.log + fmt.Sprintf("Message Expiry %v\n", expiry)
@@ -372,6 +514,9 @@ func (dec *MMSDecoder) ReadExpiry(reflectedPdu *reflect.Value, received time.Tim
 //or just decodes and discards if it's application specific, if the latter is
 //the case it also returns false
 func (dec *MMSDecoder) getParam() (byte, bool, error) {
+	if err := dec.CheckOffset(dec.Offset); err != nil {
+		return 0, false, err
+	}
 	if dec.Data[dec.Offset]&0x80 != 0 {
 		return dec.Data[dec.Offset] & 0x7f, true, nil
 	} else {
@@ -392,6 +537,9 @@ func (dec *MMSDecoder) getParam() (byte, bool, error) {
 }
 
 func (dec *MMSDecoder) skipFieldValue() error {
+	if err := dec.CheckOffset(dec.Offset + 1); err != nil {
+		return err
+	}
 	switch {
 	case dec.Data[dec.Offset+1] < LENGTH_QUOTE:
 		l, err := dec.ReadByte(nil, "")
@@ -432,10 +580,14 @@ func (dec *MMSDecoder) skipFieldValue() error {
 func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 	reflectedPdu := reflect.ValueOf(pdu).Elem()
 	moreHdrToRead := true
+	headers := 0
 	//fmt.Printf("len data: %d, data: %x\n", len(dec.Data), dec.Data)
 	for ; (dec.Offset < len(dec.Data)) && moreHdrToRead; dec.Offset++ {
 		//fmt.Printf("offset %d, value: %x\n", dec.Offset, dec.Data[dec.Offset])
 		err = nil
+		if headers++; headers > MaxHeaders {
+			return fmt.Errorf("more than %d headers", MaxHeaders)
+		}
 		param, needsDecoding, err := dec.getParam()
 		if err != nil {
 			return err
@@ -445,13 +597,23 @@ func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 		switch param {
 		case X_MMS_MESSAGE_TYPE:
 			dec.Offset++
-			expectedType := byte(reflectedPdu.FieldByName("Type").Uint())
+			if err := dec.CheckOffset(dec.Offset); err != nil {
+				return err
+			}
+			typeField, ok := pduField(&reflectedPdu, "Type")
+			if !ok || !assignable(typeField, uint64(0)) {
+				return errors.New("decoding structure has no message type")
+			}
+			expectedType := byte(typeField.Uint())
 			parsedType := dec.Data[dec.Offset]
 			//Unknown message types will be discarded. OMA-WAP-MMS-ENC-v1.1 section 7.2.16
 			if parsedType != expectedType {
 				err = fmt.Errorf("Expected message type %x got %x", expectedType, parsedType)
 			}
 		case FROM:
+			if err := dec.CheckOffset(dec.Offset + 2); err != nil {
+				return err
+			}
 			dec.Offset++
 			size := int(dec.Data[dec.Offset])
 			valStart := dec.Offset
@@ -476,30 +638,39 @@ func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 				if rec, ok := rf.Interface().(time.Time); ok {
 					received = rec
 				} else {
-					log.Printf("Field Received in decoding structure is not a time.Time type")
+					logger.Debugf("Field Received in decoding structure is not a time.Time type")
 				}
 			} else {
-				log.Printf("Field Received is not in decoding structure")
+				logger.Debugf("Field Received is not in decoding structure")
 			}
 			_, err = dec.ReadExpiry(&reflectedPdu, received)
 		case X_MMS_TRANSACTION_ID:
 			_, err = dec.ReadString(&reflectedPdu, "TransactionId")
 		case CONTENT_TYPE:
-			ctMember := reflectedPdu.FieldByName("Content")
+			ctMember, ok := pduField(&reflectedPdu, "Content")
+			if !ok || ctMember.Type() != reflect.TypeOf(Attachment{}) {
+				return errors.New("unexpected content in a PDU without body")
+			}
 			if err = dec.ReadAttachment(&ctMember); err != nil {
 				return err
 			}
 			//application/vnd.wap.multipart.related and others
-			if ctMember.FieldByName("MediaType").String() != "text/plain" {
-				err = dec.ReadAttachmentParts(&reflectedPdu)
-			} else {
+			if mediaType := ctMember.FieldByName("MediaType").String(); mediaType == "text/plain" {
 				dec.Offset++
 				_, err = dec.ReadBoundedBytes(&reflectedPdu, "Data", len(dec.Data))
+			} else if IsEncryptedMediaType(mediaType) {
+				err = dec.ReadEncryptedContent(&reflectedPdu, mediaType)
+			} else {
+				err = dec.ReadAttachmentParts(&reflectedPdu)
 			}
 			moreHdrToRead = false
 		case X_MMS_CONTENT_LOCATION:
 			_, err = dec.ReadString(&reflectedPdu, "ContentLocation")
-			moreHdrToRead = false
+			// It is the last header of a m-notification.ind, other PDUs,
+			// e.g. m-mbox-store.conf, have more headers after it.
+			if _, ok := pdu.(*MNotificationInd); ok {
+				moreHdrToRead = false
+			}
 		case MESSAGE_ID:
 			_, err = dec.ReadString(&reflectedPdu, "MessageId")
 		case SUBJECT:
@@ -511,7 +682,7 @@ func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 		case X_MMS_REPLY_CHARGING_ID:
 			_, err = dec.ReadString(&reflectedPdu, "ReplyChargingId")
 		case X_MMS_RETRIEVE_TEXT:
-			_, err = dec.ReadString(&reflectedPdu, "RetrieveText")
+			_, err = dec.ReadEncodedString(&reflectedPdu, "RetrieveText")
 		case X_MMS_MMS_VERSION:
 			// TODO This should be ReadShortInteger instead, but we read it
 			// as a byte because we are not properly encoding the version
@@ -532,8 +703,12 @@ func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 			_, err = dec.ReadByte(&reflectedPdu, "RetrieveStatus")
 		case X_MMS_RESPONSE_STATUS:
 			_, err = dec.ReadByte(&reflectedPdu, "ResponseStatus")
+		case X_MMS_STATUS:
+			_, err = dec.ReadByte(&reflectedPdu, "Status")
+		case X_MMS_READ_STATUS:
+			_, err = dec.ReadByte(&reflectedPdu, "ReadStatus")
 		case X_MMS_RESPONSE_TEXT:
-			_, err = dec.ReadString(&reflectedPdu, "ResponseText")
+			_, err = dec.ReadEncodedString(&reflectedPdu, "ResponseText")
 		case X_MMS_DELIVERY_REPORT:
 			_, err = dec.ReadByte(&reflectedPdu, "DeliveryReport")
 		case X_MMS_READ_REPORT:
@@ -542,8 +717,24 @@ func (dec *MMSDecoder) Decode(pdu MMSReader) (err error) {
 			_, err = dec.ReadLongInteger(&reflectedPdu, "Size")
 		case DATE:
 			_, err = dec.ReadLongInteger(&reflectedPdu, "Date")
+		case X_MMS_MM_STATE:
+			_, err = dec.ReadByte(&reflectedPdu, "MMState")
+		case X_MMS_STORE_STATUS:
+			_, err = dec.ReadByte(&reflectedPdu, "StoreStatus")
+		case X_MMS_STORE_STATUS_TEXT:
+			_, err = dec.ReadEncodedString(&reflectedPdu, "StoreStatusText")
+		case X_MMS_MESSAGE_COUNT:
+			_, err = dec.ReadInteger(&reflectedPdu, "MessageCount")
+		case X_MMS_START:
+			_, err = dec.ReadInteger(&reflectedPdu, "StartIndex")
+		case X_MMS_MBOX_TOTALS:
+			err = dec.readMboxTotals(&reflectedPdu)
+		case X_MMS_CANCEL_ID:
+			_, err = dec.ReadString(&reflectedPdu, "CancelId")
+		case X_MMS_CANCEL_STATUS:
+			_, err = dec.ReadByte(&reflectedPdu, "CancelStatus")
 		default:
-			log.Printf("Skipping unrecognized header 0x%02x", param)
+			logger.Warnf("Skipping unrecognized header 0x%02x", param)
 			err = dec.skipFieldValue()
 		}
 		if err != nil {
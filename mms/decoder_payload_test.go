@@ -71,6 +71,28 @@ func (s *PayloadDecoderTestSuite) TestDecodeInvalidMSendConf(c *C) {
 	mSendConf.Status()
 }
 
+func (s *PayloadDecoderTestSuite) TestDecodeRejectedMSendConf(c *C) {
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_SEND_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, 0x31, 0x00,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_1,
+		0x80 + X_MMS_RESPONSE_STATUS, ResponseStatusErrorPermanentContentNotAccepted,
+		// utf-8 "Too big"
+		0x80 + X_MMS_RESPONSE_TEXT, 0x09, 0xea, 0x54, 0x6f, 0x6f, 0x20, 0x62, 0x69, 0x67, 0x00,
+	}
+	mSendConf := NewMSendConf()
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mSendConf), IsNil)
+	c.Check(mSendConf.ResponseText, Equals, "Too big")
+	c.Check(mSendConf.Status(), Equals, ErrPermanent)
+	c.Check(mSendConf.ResponseError(), Equals, ErrorResponseStatus{ResponseStatusErrorPermanentContentNotAccepted, "Too big"})
+
+	mSendConf.ResponseStatus = ResponseStatusErrorTransientNetworkProblem
+	c.Check(mSendConf.ResponseError().(ErrorResponseStatus).Transient(), Equals, true)
+	mSendConf.ResponseStatus = ResponseStatusOk
+	c.Check(mSendConf.ResponseError(), IsNil)
+}
+
 type testDecodeMNotificationInd_missingReceived struct {
 	Version, Class  byte
 	ContentLocation string
@@ -160,3 +182,56 @@ func TestMMSDecoder_Decode_MNotificationInd(t *testing.T) {
 		})
 	}
 }
+
+// TestDecodeTruncatedPayloads decodes every prefix of the test payloads as
+// every PDU received, which must fail with an error rather than panic.
+func TestDecodeTruncatedPayloads(t *testing.T) {
+	for _, file := range []string{"test_payloads/m-notification.ind_success", "test_payloads/m-retrieve.conf_success", "test_payloads/m-send.conf_success"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for end := 0; end < len(data); end++ {
+			for _, pdu := range []MMSReader{NewMNotificationInd(time.Time{}), NewMRetrieveConf("uuid"), NewMSendConf(), NewMDeliveryInd(), NewMReadOrigInd()} {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Fatalf("decoding %s up to %d as %T panicked: %v", file, end, pdu, r)
+						}
+					}()
+					NewDecoder(data[:end]).Decode(pdu)
+				}()
+			}
+		}
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	headers := make([]byte, 0, 2*(MaxHeaders+1))
+	for i := 0; i <= MaxHeaders; i++ {
+		headers = append(headers, X_MMS_PRIORITY|0x80, PriorityNormal)
+	}
+	if err := NewDecoder(headers).Decode(NewMSendConf()); err == nil {
+		t.Errorf("decoding %d headers succeeded", MaxHeaders+1)
+	}
+
+	// A multipart body claiming a part more than supported.
+	parts := []byte{CONTENT_TYPE | 0x80, 0xb3, 0x82, 0x02}
+	if err := NewDecoder(parts).Decode(NewMRetrieveConf("uuid")); err == nil {
+		t.Errorf("decoding %d parts succeeded", MaxParts+1)
+	}
+
+	// A part claiming more data than there is.
+	part := []byte{CONTENT_TYPE | 0x80, 0xb3, 0x01, 0x01, 0x8f, 0xff, 0x7f, 0x83}
+	if err := NewDecoder(part).Decode(NewMRetrieveConf("uuid")); err == nil {
+		t.Error("decoding a part beyond the data succeeded")
+	}
+}
+
+func TestDecodeUnexpectedHeader(t *testing.T) {
+	// A To header in an m-send.conf, which has no recipients.
+	data := []byte{TO | 0x80, '+', '1', '2', 0x00}
+	if err := NewDecoder(data).Decode(NewMSendConf()); err != nil {
+		t.Errorf("decoding an unexpected header failed: %v", err)
+	}
+}
@@ -49,6 +49,74 @@ func (s *DecoderTestSuite) TestDecodeStringNoNullByteTerminator(c *C) {
 	c.Check(err, DeepEquals, expectedErr)
 }
 
+func (s *DecoderTestSuite) TestDecodeMDeliveryInd(c *C) {
+	inputBytes := []byte{
+		// X-Mms-Message-Type: m-delivery-ind
+		0x8c, 0x86,
+		// X-Mms-MMS-Version: 1.2
+		0x8d, 0x92,
+		// Message-ID: msg1
+		0x8b, 'm', 's', 'g', '1', 0x00,
+		// To: +1234/TYPE=PLMN
+		0x97, '+', '1', '2', '3', '4', '/', 'T', 'Y', 'P', 'E', '=', 'P', 'L', 'M', 'N', 0x00,
+		// Date: 1075445393
+		0x85, 0x04, 0x40, 0x19, 0xfe, 0x91,
+		// X-Mms-Status: Retrieved
+		0x95, 0x81,
+	}
+	typ, err := MessageType(inputBytes)
+	c.Assert(err, IsNil)
+	c.Check(typ, Equals, byte(TYPE_DELIVERY_IND))
+
+	mDeliveryInd := NewMDeliveryInd()
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mDeliveryInd), IsNil)
+	c.Check(mDeliveryInd, DeepEquals, &MDeliveryInd{
+		Type:      TYPE_DELIVERY_IND,
+		Version:   MMS_MESSAGE_VERSION_1_2,
+		MessageId: "msg1",
+		To:        []string{"+1234/TYPE=PLMN"},
+		Date:      1075445393,
+		Status:    STATUS_RETRIEVED,
+	})
+}
+
+func (s *DecoderTestSuite) TestDecodeMReadOrigInd(c *C) {
+	inputBytes := []byte{
+		// X-Mms-Message-Type: m-read-orig-ind
+		0x8c, 0x88,
+		// X-Mms-MMS-Version: 1.2
+		0x8d, 0x92,
+		// Message-ID: msg1
+		0x8b, 'm', 's', 'g', '1', 0x00,
+		// To: +1000/TYPE=PLMN
+		0x97, '+', '1', '0', '0', '0', '/', 'T', 'Y', 'P', 'E', '=', 'P', 'L', 'M', 'N', 0x00,
+		// From: +1234/TYPE=PLMN
+		0x89, 0x11, 0x80, '+', '1', '2', '3', '4', '/', 'T', 'Y', 'P', 'E', '=', 'P', 'L', 'M', 'N', 0x00,
+		// Date: 1075445393
+		0x85, 0x04, 0x40, 0x19, 0xfe, 0x91,
+		// X-Mms-Read-Status: Read
+		0x9b, 0x80,
+	}
+	mReadOrigInd := NewMReadOrigInd()
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mReadOrigInd), IsNil)
+	c.Check(mReadOrigInd, DeepEquals, &MReadOrigInd{
+		Type:       TYPE_READ_ORIG_IND,
+		Version:    MMS_MESSAGE_VERSION_1_2,
+		MessageId:  "msg1",
+		To:         []string{"+1000/TYPE=PLMN"},
+		From:       "+1234/TYPE=PLMN",
+		Date:       1075445393,
+		ReadStatus: ReadStatusRead,
+	})
+}
+
+func (s *DecoderTestSuite) TestMessageTypeInvalid(c *C) {
+	_, err := MessageType([]byte{0x8d, 0x92})
+	c.Check(err, NotNil)
+}
+
 func (s *DecoderTestSuite) TestDecodeStringWithNullByteTerminator(c *C) {
 	inputBytes := []byte{
 		//stub byte
@@ -98,7 +166,7 @@ func TestMMSDecoder_ReadExpiry(t *testing.T) {
 		{
 			"absolute-date",
 			[]byte{0x88, 0x06, 0x80, 0x04, 0x40, 0x19, 0xfe, 0x91}, 0, &MNotificationInd{}, time20000101,
-			time.Unix(1075445393, 0), nil, 7, nil,
+			time.Unix(1075445393, 0).UTC(), nil, 7, nil,
 		},
 		{
 			"error-expiry-length",
@@ -108,7 +176,7 @@ func TestMMSDecoder_ReadExpiry(t *testing.T) {
 		{
 			"error-value-length",
 			[]byte{0x88, 0x04, 0x81, 0x03, 0x01, 0x2c}, 0, &MNotificationInd{}, time20000101,
-			time.Time{}, ErrorDecodeShortData{4, 6}, 1, "runtime error: index out of range [6] with length 6",
+			time.Time{}, ErrorDecodeShortData{6, 6}, 3, nil,
 		},
 		{
 			"error-unknown-token",
@@ -128,7 +196,7 @@ func TestMMSDecoder_ReadExpiry(t *testing.T) {
 		{
 			"absolute-nodestination-noreceived",
 			[]byte{0x88, 0x06, 0x80, 0x04, 0x40, 0x19, 0xfe, 0x91}, 0, nil, time.Time{},
-			time.Unix(1075445393, 0), nil, 7, nil,
+			time.Unix(1075445393, 0).UTC(), nil, 7, nil,
 		},
 		{
 			"relative-5minutes-nodestination-noreceived",
@@ -182,3 +250,90 @@ func TestMMSDecoder_ReadExpiry(t *testing.T) {
 		})
 	}
 }
+
+func (s *DecoderTestSuite) TestDecodeEncryptedMRetrieveConf(c *C) {
+	inputBytes := []byte{
+		// m-retrieve.conf
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_RETRIEVE_CONF,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_1,
+		0x80 + CONTENT_TYPE,
+		// application/pkcs7-mime
+		0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f,
+		0x70, 0x6b, 0x63, 0x73, 0x37, 0x2d, 0x6d, 0x69, 0x6d, 0x65, 0x00,
+		// enveloped data
+		0x30, 0x82, 0x01, 0x02,
+	}
+	mRetrieveConf := NewMRetrieveConf("uuid")
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mRetrieveConf), IsNil)
+	c.Check(mRetrieveConf.IsEncrypted(), Equals, true)
+	c.Assert(mRetrieveConf.Attachments, HasLen, 1)
+	c.Check(mRetrieveConf.Attachments[0].MediaType, Equals, "application/pkcs7-mime")
+	c.Check(mRetrieveConf.Attachments[0].Data, DeepEquals, []byte{0x30, 0x82, 0x01, 0x02})
+	c.Check(mRetrieveConf.Attachments[0].Offset, Equals, len(inputBytes)-4)
+}
+
+func (s *DecoderTestSuite) TestDecodeMRetrieveConfRetrieveStatus(c *C) {
+	inputBytes := []byte{
+		// m-retrieve.conf without content
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_RETRIEVE_CONF,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_1,
+		0x80 + X_MMS_RETRIEVE_STATUS, RetrieveStatusErrorPermanentMessageNotFound,
+		// utf-8 "Expired"
+		0x80 + X_MMS_RETRIEVE_TEXT, 0x09, 0xea, 0x45, 0x78, 0x70, 0x69, 0x72, 0x65, 0x64, 0x00,
+	}
+	mRetrieveConf := NewMRetrieveConf("uuid")
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mRetrieveConf), IsNil)
+	c.Check(mRetrieveConf.RetrieveText, Equals, "Expired")
+	err, ok := mRetrieveConf.RetrieveError().(ErrorRetrieveStatus)
+	c.Assert(ok, Equals, true)
+	c.Check(err.Status, Equals, RetrieveStatusErrorPermanentMessageNotFound)
+	c.Check(err.Text, Equals, "Expired")
+	c.Check(err.Transient(), Equals, false)
+
+	mRetrieveConf.RetrieveStatus = RetrieveStatusOk
+	c.Check(mRetrieveConf.RetrieveError(), IsNil)
+}
+
+func TestErrorRetrieveStatusReason(t *testing.T) {
+	testCases := []struct {
+		status    byte
+		reason    byte
+		transient bool
+	}{
+		{RetrieveStatusErrorTransientMessageNotFound, RetrieveStatusErrorTransientMessageNotFound, true},
+		{200, RetrieveStatusErrorTransientFailure, true},
+		{RetrieveStatusErrorPermanentServiceDenied, RetrieveStatusErrorPermanentServiceDenied, false},
+		{240, RetrieveStatusErrorPermanentFailure, false},
+		{130, RetrieveStatusErrorPermanentFailure, false},
+	}
+	for _, tc := range testCases {
+		err := ErrorRetrieveStatus{Status: tc.status}
+		if reason := err.Reason(); reason != tc.reason {
+			t.Errorf("Reason() of status %d = %d, want %d", tc.status, reason, tc.reason)
+		}
+		if transient := err.Transient(); transient != tc.transient {
+			t.Errorf("Transient() of status %d = %v, want %v", tc.status, transient, tc.transient)
+		}
+	}
+}
+
+func TestIsEncryptedMediaType(t *testing.T) {
+	testCases := []struct {
+		mediaType string
+		want      bool
+	}{
+		{"application/pkcs7-mime", true},
+		{"application/x-pkcs7-mime; smime-type=enveloped-data", true},
+		{"Multipart/Encrypted", true},
+		{"application/pkcs7-signature", false},
+		{"text/plain;charset=utf-8", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		if got := IsEncryptedMediaType(tc.mediaType); got != tc.want {
+			t.Errorf("IsEncryptedMediaType(%q) = %v, want %v", tc.mediaType, got, tc.want)
+		}
+	}
+}
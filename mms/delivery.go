@@ -0,0 +1,37 @@
+package mms
+
+import "errors"
+
+// Status values only used in m-delivery.ind, following the ones defined in
+// OMA-WAP-MMS section 7.2.23
+const (
+	STATUS_INDETERMINATE = 133
+	STATUS_FORWARDED     = 134
+	STATUS_UNREACHABLE   = 135
+)
+
+// MDeliveryInd holds a m-delivery.ind message defined in
+// OMA-WAP-MMS-ENC-v1.1 section 6.6. The MMSC pushes it when a message sent
+// with a delivery report requested reaches, or fails to reach, a recipient.
+type MDeliveryInd struct {
+	MMSReader
+	Type, Version byte
+	MessageId     string
+	To            []string
+	Date          uint64
+	Status        byte
+}
+
+func NewMDeliveryInd() *MDeliveryInd {
+	return &MDeliveryInd{Type: TYPE_DELIVERY_IND}
+}
+
+// MessageType returns the type of the PDU in data, e.g. TYPE_NOTIFICATION_IND
+// for a pushed m-notification.ind. The message type is always the first
+// header of a PDU.
+func MessageType(data []byte) (byte, error) {
+	if len(data) < 2 || data[0] != X_MMS_MESSAGE_TYPE|0x80 {
+		return 0, errors.New("PDU does not start with a message type")
+	}
+	return data[1], nil
+}
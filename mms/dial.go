@@ -0,0 +1,63 @@
+package mms
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// fallbackDelay is how long dialing an address over IPv6 gets before IPv4 is
+// tried in parallel, as in RFC 6555.
+const fallbackDelay = 300 * time.Millisecond
+
+// newDialer returns a dialer for transactions over the MMS context. Hosts
+// with both IPv6 and IPv4 addresses are dialed dual stack, preferring IPv6.
+// Connections are bound to iface unless it is empty, so they don't leak to
+// another bearer such as Wi-Fi. Host names are resolved with dnsServers,
+// see newResolver.
+func newDialer(timeout time.Duration, iface string, dnsServers []string) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout, FallbackDelay: fallbackDelay}
+	if iface != "" {
+		dialer.Control = bindControl(iface)
+	}
+	dialer.Resolver = newResolver(&net.Dialer{Timeout: timeout, Control: dialer.Control}, dnsServers)
+	return dialer
+}
+
+// bindControl returns a dialer control binding connections to iface.
+func bindControl(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		if err := c.Control(func(fd uintptr) {
+			bindErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); err != nil {
+			return err
+		}
+		if bindErr != nil {
+			// Binding needs CAP_NET_RAW, the routes ofono sets up for the
+			// context are still there without it.
+			logger.Errorf("Cannot bind connection to %s to %s: %v", address, iface, bindErr)
+		}
+		return nil
+	}
+}
+
+// newResolver returns a resolver which queries the DNS servers, in turn,
+// with dialer instead of the ones of the system, nil for the system resolver
+// if there are none. MMSC host names of some operators only resolve with the
+// DNS servers of the MMS context.
+func newResolver(dialer *net.Dialer, servers []string) *net.Resolver {
+	if len(servers) == 0 {
+		return nil
+	}
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(atomic.AddUint32(&next, 1)-1)%len(servers)]
+			return dialer.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+}
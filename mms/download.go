@@ -22,56 +22,116 @@
 package mms
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"launchpad.net/udm"
 )
 
-func (pdu *MNotificationInd) DownloadContent(proxyHost string, proxyPort int32) (string, error) {
+// Time a download or upload may take to make progress after it started and
+// time it may go without progress later on before it fails.
+var (
+	connectTimeout  = int64(time.Minute)
+	downloadTimeout = int64(3 * time.Minute)
+	uploadTimeout   = int64(10 * time.Minute)
+)
+
+// SetTimeouts sets the time downloads and uploads may take to make progress
+// after they started, and the time they may go without progress later on,
+// before they fail. A connect timeout of 0 uses the later ones from the start.
+func SetTimeouts(connect, download, upload time.Duration) {
+	atomic.StoreInt64(&connectTimeout, int64(connect))
+	atomic.StoreInt64(&downloadTimeout, int64(download))
+	atomic.StoreInt64(&uploadTimeout, int64(upload))
+}
+
+// timeouts returns the connect and read timeouts with read being the
+// download or upload timeout.
+func timeouts(read *int64) (time.Duration, time.Duration) {
+	connect, r := time.Duration(atomic.LoadInt64(&connectTimeout)), time.Duration(atomic.LoadInt64(read))
+	if connect == 0 {
+		connect = r
+	}
+	return connect, r
+}
+
+// DownloadContent downloads the message at the content location through
+// proxy and returns the path of the downloaded file. The download is
+// cancelled when ctx is done. It fails with an ErrorUnexpectedContent if
+// something else than a PDU was downloaded, and with an ErrorRedirectLoop if
+// the MMSC redirects it in a loop.
+func (pdu *MNotificationInd) DownloadContent(ctx context.Context, proxy Proxy) (string, error) {
+	if proxy.authenticated() || proxy.identified() || proxy.customTLS() || len(proxy.DNSServers) > 0 || proxy.Redirects != RedirectDefault || resumingDownloads() {
+		return proxyTransfer(ctx, pdu.ContentLocation, "", proxy, &downloadTimeout)
+	}
 	downloadManager, err := udm.NewDownloadManager()
 	if err != nil {
 		return "", err
 	}
-	download, err := downloadManager.CreateMmsDownload(pdu.ContentLocation, proxyHost, proxyPort)
+	download, err := downloadManager.CreateMmsDownload(pdu.ContentLocation, proxy.Host, proxy.Port)
 	if err != nil {
 		return "", err
 	}
 	f := download.Finished()
 	p := download.DownloadProgress()
 	e := download.Error()
-	log.Print("Starting download of ", pdu.ContentLocation, " with proxy ", proxyHost, ":", proxyPort)
+	timeout, readTimeout := timeouts(&downloadTimeout)
+	logger.Info("Starting download of ", pdu.ContentLocation, " with proxy ", proxy)
 	download.Start()
 	for {
 		select {
 		case progress := <-p:
-			log.Print("Progress:", progress.Total, progress.Received)
+			logger.Debugw("Progress", "total", progress.Total, "received", progress.Received)
+			proxy.report(progress.Received, progress.Total)
+			timeout = readTimeout
 		case downloadFilePath := <-f:
-			log.Print("File downloaded to ", downloadFilePath)
+			logger.Info("File downloaded to ", downloadFilePath)
+			if err := checkPDU(downloadFilePath, ""); err != nil {
+				os.Remove(downloadFilePath)
+				return "", err
+			}
 			return downloadFilePath, nil
-		case <-time.After(3 * time.Minute):
+		case <-time.After(timeout):
+			download.Cancel()
 			return "", fmt.Errorf("Download timeout exceeded while fetching %s", pdu.ContentLocation)
+		case <-ctx.Done():
+			logger.Info("Cancelling download of ", pdu.ContentLocation)
+			if err := download.Cancel(); err != nil {
+				logger.Error("Cannot cancel download: ", err)
+			}
+			return "", ctx.Err()
 		case err := <-e:
 			return "", err
 		}
 	}
 }
 
-func Upload(file, msc, proxyHost string, proxyPort int32) (string, error) {
+// Upload posts file to msc through proxy and returns the path of the response
+// file. The upload is cancelled when ctx is done.
+func Upload(ctx context.Context, file, msc string, proxy Proxy) (string, error) {
+	if proxy.authenticated() || proxy.identified() || proxy.customTLS() || len(proxy.DNSServers) > 0 {
+		return proxyTransfer(ctx, msc, file, proxy, &uploadTimeout)
+	}
 	udm, err := udm.NewUploadManager()
 	if err != nil {
 		return "", err
 	}
-	upload, err := udm.CreateMmsUpload(msc, file, proxyHost, proxyPort)
+	upload, err := udm.CreateMmsUpload(msc, file, proxy.Host, proxy.Port)
 	if err != nil {
 		return "", err
 	}
 	f := upload.Finished()
 	p := upload.UploadProgress()
 	e := upload.Error()
-	log.Print("Starting upload of ", file, " to ", msc, " with proxy ", proxyHost, ":", proxyPort)
+	timeout, readTimeout := timeouts(&uploadTimeout)
+	logger.Info("Starting upload of ", file, " to ", msc, " with proxy ", proxy)
 	if err := upload.Start(); err != nil {
 		return "", err
 	}
@@ -79,14 +139,52 @@ func Upload(file, msc, proxyHost string, proxyPort int32) (string, error) {
 	for {
 		select {
 		case progress := <-p:
-			log.Print("Progress:", progress.Total, progress.Received)
+			logger.Debugw("Progress", "total", progress.Total, "received", progress.Received)
+			proxy.report(progress.Received, progress.Total)
+			timeout = readTimeout
 		case responseFile := <-f:
-			log.Print("File ", responseFile, " returned in upload")
+			logger.Info("File ", responseFile, " returned in upload")
 			return responseFile, nil
-		case <-time.After(10 * time.Minute):
+		case <-time.After(timeout):
+			upload.Cancel()
 			return "", errors.New("upload timeout")
+		case <-ctx.Done():
+			logger.Info("Cancelling upload of ", file)
+			if err := upload.Cancel(); err != nil {
+				logger.Error("Cannot cancel upload: ", err)
+			}
+			return "", ctx.Err()
 		case err := <-e:
 			return "", err
 		}
 	}
 }
+
+// checkPDU returns an ErrorUnexpectedContent unless the file at filePath
+// holds a PDU. Some MMSCs send PDUs with a wrong Content-Type, so this goes
+// by the content and only uses contentType, the one of the HTTP response if
+// known, to tell what was returned instead.
+func checkPDU(filePath, contentType string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	if n > 0 && head[0] == 0x80|X_MMS_MESSAGE_TYPE {
+		return nil
+	}
+	if n == 0 {
+		return ErrorUnexpectedContent{}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != mmsContentType {
+		return ErrorUnexpectedContent{ContentType: mediaType}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return ErrorUnexpectedContent{ContentType: mediaType}
+}
@@ -0,0 +1,377 @@
+package mms
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OMA DRM 1.0 media types, see OMA-Download-DRM-V1_0 and
+// OMA-Download-DRMCF-V1_0.
+const (
+	DRM_MESSAGE = "application/vnd.oma.drm.message"
+	DRM_CONTENT = "application/vnd.oma.drm.content"
+	DRM_RIGHTS  = "application/vnd.oma.drm.rights+xml"
+	// PROTECTED_CONTENT is the media type passed on for DRM protected
+	// parts which cannot be unwrapped, with the media type of the protected
+	// content in the type parameter if it is known.
+	PROTECTED_CONTENT = "application/x-ubports-nuntium-protected"
+)
+
+// ErrorDRMProtected is returned for DRM protected content whose rights do
+// not allow to unwrap it, e.g. content encrypted for rights delivered
+// separately.
+var ErrorDRMProtected = errors.New("content is DRM protected")
+
+// DRMContent is the content unwrapped from a DRM message or content format.
+type DRMContent struct {
+	MediaType string
+	Data      []byte
+}
+
+// IsDRM returns true if mediaType is a DRM message or content format.
+func IsDRM(mediaType string) bool {
+	t := strings.ToLower(strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0]))
+	return t == DRM_MESSAGE || t == DRM_CONTENT
+}
+
+// UnwrapDRM returns the content of a DRM protected attachment if its rights
+// allow to use it. Forward locked content and content delivered combined
+// with its rights are unwrapped, encrypted content only if the rights carry
+// its key. Otherwise the error is ErrorDRMProtected, wrapped, and
+// ProtectedMediaType tells how to pass the attachment on.
+func (a Attachment) UnwrapDRM() (DRMContent, error) {
+	t := strings.ToLower(strings.TrimSpace(strings.SplitN(a.MediaType, ";", 2)[0]))
+	switch t {
+	case DRM_MESSAGE:
+		return decodeDRMMessage(a.Data)
+	case DRM_CONTENT:
+		return decodeDRMContentFormat(a.Data, nil)
+	}
+	return DRMContent{}, fmt.Errorf("%s is not DRM protected", a.MediaType)
+}
+
+// ProtectedMediaType returns the media type passed on for the DRM protected
+// attachment a which cannot be unwrapped, PROTECTED_CONTENT with the media
+// type of the protected content if it can be found.
+func (a Attachment) ProtectedMediaType() string {
+	if inner := drmInnerMediaType(a.Data, a.MediaType); inner != "" {
+		return mime.FormatMediaType(PROTECTED_CONTENT, map[string]string{"type": inner})
+	}
+	return PROTECTED_CONTENT
+}
+
+// drmInnerMediaType returns the media type of the content protected in data
+// of mediaType, empty if it cannot be found.
+func drmInnerMediaType(data []byte, mediaType string) string {
+	if strings.HasPrefix(strings.ToLower(mediaType), DRM_CONTENT) {
+		if dcf, err := parseDRMContentFormat(data); err == nil {
+			return dcf.contentType
+		}
+		return ""
+	}
+	var inner string
+	walkDRMMessage(data, func(header textproto.MIMEHeader, body []byte) bool {
+		t, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		switch t {
+		case DRM_RIGHTS:
+			return true
+		case DRM_CONTENT:
+			if dcf, err := parseDRMContentFormat(body); err == nil {
+				inner = dcf.contentType
+			}
+		default:
+			inner = t
+		}
+		return false
+	})
+	return inner
+}
+
+// decodeDRMMessage unwraps the content of a DRM message, a MIME multipart
+// with the content and, for combined delivery, its rights before it.
+func decodeDRMMessage(data []byte) (DRMContent, error) {
+	var rights *drmRights
+	var content DRMContent
+	var err error
+	found := false
+	walkErr := walkDRMMessage(data, func(header textproto.MIMEHeader, body []byte) bool {
+		t, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		if t == DRM_RIGHTS {
+			if rights, err = parseDRMRights(body); err != nil {
+				return false
+			}
+			return true
+		}
+		found = true
+		if err = rights.allowed(time.Now()); err != nil {
+			return false
+		}
+		if t == DRM_CONTENT {
+			content, err = decodeDRMContentFormat(body, rights)
+			return false
+		}
+		content = DRMContent{MediaType: header.Get("Content-Type"), Data: body}
+		return false
+	})
+	if walkErr != nil {
+		return DRMContent{}, walkErr
+	}
+	if err != nil {
+		return DRMContent{}, err
+	}
+	if !found {
+		return DRMContent{}, errors.New("DRM message has no content")
+	}
+	return content, nil
+}
+
+// walkDRMMessage calls part with the header and decoded body of every part
+// of the DRM message in data until it returns false. The boundary is taken
+// from the first delimiter line, as the parameters of the media type of the
+// attachment are not kept.
+func walkDRMMessage(data []byte, part func(header textproto.MIMEHeader, body []byte) bool) error {
+	boundary, err := drmBoundary(data)
+	if err != nil {
+		return err
+	}
+	r := multipart.NewReader(bytes.NewReader(data), boundary)
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cannot read DRM message: %w", err)
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			return fmt.Errorf("cannot read DRM message part: %w", err)
+		}
+		if strings.EqualFold(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding")), "base64") {
+			if body, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), "")); err != nil {
+				return fmt.Errorf("cannot decode DRM message part: %w", err)
+			}
+		}
+		if !part(p.Header, body) {
+			return nil
+		}
+	}
+}
+
+// drmBoundary returns the boundary of the first delimiter line of data.
+func drmBoundary(data []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") || len(line) == 2 {
+			break
+		}
+		return line[2:], nil
+	}
+	return "", errors.New("DRM message does not start with a boundary")
+}
+
+// drmContentFormat is a parsed DRM content format (DCF) object.
+type drmContentFormat struct {
+	contentType string
+	contentURI  string
+	headers     map[string]string
+	data        []byte
+}
+
+// parseDRMContentFormat parses the DCF object in data.
+func parseDRMContentFormat(data []byte) (drmContentFormat, error) {
+	var dcf drmContentFormat
+	if len(data) < 3 {
+		return dcf, ErrorDecodeShortData{Length: len(data), Expected: 3}
+	}
+	if data[0] != 1 {
+		return dcf, fmt.Errorf("unsupported DCF version %d", data[0])
+	}
+	typeLen, uriLen := int(data[1]), int(data[2])
+	offset := 3
+	if len(data) < offset+typeLen+uriLen {
+		return dcf, ErrorDecodeShortData{Length: len(data), Expected: offset + typeLen + uriLen}
+	}
+	dcf.contentType = string(data[offset : offset+typeLen])
+	offset += typeLen
+	dcf.contentURI = string(data[offset : offset+uriLen])
+	offset += uriLen
+	headersLen, n, err := readUintVar(data[offset:])
+	if err != nil {
+		return dcf, err
+	}
+	offset += n
+	dataLen, n, err := readUintVar(data[offset:])
+	if err != nil {
+		return dcf, err
+	}
+	offset += n
+	if end := uint64(offset) + headersLen + dataLen; end > uint64(len(data)) {
+		return dcf, ErrorDecodeShortData{Length: len(data), Expected: int(end)}
+	}
+	dcf.headers = make(map[string]string)
+	for _, line := range strings.Split(string(data[offset:offset+int(headersLen)]), "\n") {
+		if i := strings.Index(line, ":"); i > 0 {
+			dcf.headers[strings.ToLower(strings.TrimSpace(line[:i]))] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	offset += int(headersLen)
+	dcf.data = data[offset : offset+int(dataLen)]
+	return dcf, nil
+}
+
+// decodeDRMContentFormat decrypts the content of the DCF object in data with
+// the key of rights, if they have one.
+func decodeDRMContentFormat(data []byte, rights *drmRights) (DRMContent, error) {
+	dcf, err := parseDRMContentFormat(data)
+	if err != nil {
+		return DRMContent{}, fmt.Errorf("cannot parse DCF: %w", err)
+	}
+	method := strings.ToLower(strings.SplitN(dcf.headers["encryption-method"], ";", 2)[0])
+	switch method {
+	case "", "null":
+		return DRMContent{MediaType: dcf.contentType, Data: dcf.data}, nil
+	case "aes128cbc":
+	default:
+		return DRMContent{}, fmt.Errorf("unsupported DCF encryption %q", method)
+	}
+	if rights == nil || len(rights.key) == 0 {
+		return DRMContent{}, fmt.Errorf("no key for %s: %w", dcf.contentURI, ErrorDRMProtected)
+	}
+	plain, err := decryptAES128CBC(rights.key, dcf.data)
+	if err != nil {
+		return DRMContent{}, fmt.Errorf("cannot decrypt %s: %w", dcf.contentURI, err)
+	}
+	return DRMContent{MediaType: dcf.contentType, Data: plain}, nil
+}
+
+// decryptAES128CBC decrypts data, the IV followed by the ciphertext padded
+// as in RFC 2630, with key.
+func decryptAES128CBC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext of %d bytes is not whole blocks", len(data))
+	}
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// readUintVar reads a WSP uintvar from the start of data and returns it with
+// its length.
+func readUintVar(data []byte) (value uint64, n int, err error) {
+	for n < len(data) && n < maxUintVarLength {
+		value = value<<7 | uint64(data[n]&0x7f)
+		n++
+		if data[n-1]&0x80 == 0 {
+			return value, n, nil
+		}
+	}
+	return 0, 0, errors.New("invalid uintvar")
+}
+
+// drmRights are the parts of a rights object which decide whether its
+// content can be used: the permissions, the constraints on them and the key
+// of encrypted content.
+type drmRights struct {
+	permissions []string
+	notBefore   time.Time
+	notAfter    time.Time
+	count       int
+	key         []byte
+}
+
+// parseDRMRights parses a rights object in XML form, going by the local
+// names of its elements as the namespace prefixes vary.
+func parseDRMRights(data []byte) (*drmRights, error) {
+	rights := &drmRights{count: -1}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var path []string
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse DRM rights: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			if len(path) >= 2 && path[len(path)-2] == "permission" {
+				rights.permissions = append(rights.permissions, t.Name.Local)
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			if len(path) == 0 {
+				return rights, nil
+			}
+		case xml.CharData:
+			if len(path) == 0 {
+				continue
+			}
+			text := strings.TrimSpace(string(t))
+			switch path[len(path)-1] {
+			case "start":
+				rights.notBefore, _ = time.Parse("2006-01-02T15:04:05", text)
+			case "end":
+				rights.notAfter, _ = time.Parse("2006-01-02T15:04:05", text)
+			case "count":
+				if count, err := strconv.Atoi(text); err == nil {
+					rights.count = count
+				}
+			case "KeyValue":
+				if rights.key, err = base64.StdEncoding.DecodeString(text); err != nil {
+					return nil, fmt.Errorf("cannot decode DRM key: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// allowed returns ErrorDRMProtected, wrapped, if the content of rights may
+// not be displayed or played at now. Forward locked content has no rights
+// and is always allowed.
+func (rights *drmRights) allowed(now time.Time) error {
+	if rights == nil {
+		return nil
+	}
+	usable := false
+	for _, permission := range rights.permissions {
+		if permission == "display" || permission == "play" {
+			usable = true
+		}
+	}
+	switch {
+	case !usable:
+		return fmt.Errorf("no display or play permission: %w", ErrorDRMProtected)
+	case !rights.notBefore.IsZero() && now.Before(rights.notBefore):
+		return fmt.Errorf("usable from %s: %w", rights.notBefore, ErrorDRMProtected)
+	case !rights.notAfter.IsZero() && now.After(rights.notAfter):
+		return fmt.Errorf("expired on %s: %w", rights.notAfter, ErrorDRMProtected)
+	case rights.count == 0:
+		return fmt.Errorf("no uses left: %w", ErrorDRMProtected)
+	}
+	return nil
+}
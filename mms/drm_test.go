@@ -0,0 +1,161 @@
+package mms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const testRights = `<o-ex:rights xmlns:o-ex="http://odrl.net/1.1/ODRL-EX" xmlns:o-dd="http://odrl.net/1.1/ODRL-DD" xmlns:ds="http://www.w3.org/2000/09/xmldsig#/">
+<o-ex:context><o-dd:version>1.0</o-dd:version></o-ex:context>
+<o-ex:agreement>
+<o-ex:asset><o-ex:context><o-dd:uid>cid:ringtone@example.com</o-dd:uid></o-ex:context>%s</o-ex:asset>
+<o-ex:permission><o-dd:%s><o-ex:constraint>%s</o-ex:constraint></o-dd:%[2]s></o-ex:permission>
+</o-ex:agreement>
+</o-ex:rights>`
+
+func drmMessage(parts ...string) []byte {
+	var b bytes.Buffer
+	for _, part := range parts {
+		b.WriteString("--boundary-1\r\n" + part + "\r\n")
+	}
+	b.WriteString("--boundary-1--\r\n")
+	return b.Bytes()
+}
+
+// dcf returns a DCF object of contentType, encrypted with key unless it is
+// nil.
+func dcf(t *testing.T, contentType string, content, key []byte) []byte {
+	headers := "Content-Name: ringtone\r\n"
+	if key != nil {
+		headers += "Encryption-Method: AES128CBC;padding=RFC2630\r\n"
+		padding := aes.BlockSize - len(content)%aes.BlockSize
+		plain := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+		encrypted := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+		content = append(iv, encrypted...)
+	}
+	uri := "cid:ringtone@example.com"
+	b := []byte{1, byte(len(contentType)), byte(len(uri))}
+	b = append(b, contentType+uri...)
+	b = append(b, byte(len(headers)), 0x80|byte(len(content)>>7), byte(len(content)&0x7f))
+	b = append(b, headers...)
+	return append(b, content...)
+}
+
+func TestUnwrapDRM(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	audio := []byte("MThd ringtone bytes")
+	keyInfo := fmt.Sprintf("<ds:KeyInfo><ds:KeyValue>%s</ds:KeyValue></ds:KeyInfo>", base64.StdEncoding.EncodeToString(key))
+	rights := func(keyInfo, permission, constraint string) string {
+		return "Content-Type: application/vnd.oma.drm.rights+xml\r\nContent-Transfer-Encoding: binary\r\n\r\n" + fmt.Sprintf(testRights, keyInfo, permission, constraint)
+	}
+
+	testCases := []struct {
+		name          string
+		attachment    Attachment
+		want          DRMContent
+		wantProtected bool
+		wantType      string
+	}{
+		{
+			name: "forward-lock",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				"Content-Type: audio/midi\r\nContent-ID: <ringtone@example.com>\r\nContent-Transfer-Encoding: binary\r\n\r\n" + string(audio),
+			)},
+			want: DRMContent{MediaType: "audio/midi", Data: audio},
+		},
+		{
+			name: "base64",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				"Content-Type: audio/midi\r\nContent-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString(audio),
+			)},
+			want: DRMContent{MediaType: "audio/midi", Data: audio},
+		},
+		{
+			name: "combined-delivery",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				rights("", "play", "<o-dd:count>3</o-dd:count>"),
+				"Content-Type: audio/midi\r\nContent-Transfer-Encoding: binary\r\n\r\n"+string(audio),
+			)},
+			want: DRMContent{MediaType: "audio/midi", Data: audio},
+		},
+		{
+			name: "expired",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				rights("", "play", "<o-dd:datetime><o-dd:end>2001-01-01T00:00:00</o-dd:end></o-dd:datetime>"),
+				"Content-Type: audio/midi\r\nContent-Transfer-Encoding: binary\r\n\r\n"+string(audio),
+			)},
+			wantProtected: true,
+			wantType:      `application/x-ubports-nuntium-protected; type="audio/midi"`,
+		},
+		{
+			name: "no-play",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				rights("", "print", ""),
+				"Content-Type: audio/midi\r\nContent-Transfer-Encoding: binary\r\n\r\n"+string(audio),
+			)},
+			wantProtected: true,
+			wantType:      `application/x-ubports-nuntium-protected; type="audio/midi"`,
+		},
+		{
+			name: "dcf-with-key",
+			attachment: Attachment{MediaType: DRM_MESSAGE, Data: drmMessage(
+				rights(keyInfo, "play", ""),
+				"Content-Type: application/vnd.oma.drm.content\r\nContent-Transfer-Encoding: binary\r\n\r\n"+string(dcf(t, "audio/midi", audio, key)),
+			)},
+			want: DRMContent{MediaType: "audio/midi", Data: audio},
+		},
+		{
+			name:       "dcf-plain",
+			attachment: Attachment{MediaType: DRM_CONTENT, Data: dcf(t, "audio/midi", audio, nil)},
+			want:       DRMContent{MediaType: "audio/midi", Data: audio},
+		},
+		{
+			name:          "dcf-separate-delivery",
+			attachment:    Attachment{MediaType: DRM_CONTENT, Data: dcf(t, "audio/midi", audio, key)},
+			wantProtected: true,
+			wantType:      `application/x-ubports-nuntium-protected; type="audio/midi"`,
+		},
+	}
+	for _, tc := range testCases {
+		got, err := tc.attachment.UnwrapDRM()
+		if tc.wantProtected {
+			if !errors.Is(err, ErrorDRMProtected) {
+				t.Errorf("%s: UnwrapDRM() error = %v, want ErrorDRMProtected", tc.name, err)
+			}
+			if got := tc.attachment.ProtectedMediaType(); got != tc.wantType {
+				t.Errorf("%s: ProtectedMediaType() = %q, want %q", tc.name, got, tc.wantType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: UnwrapDRM() error = %v", tc.name, err)
+		} else if got.MediaType != tc.want.MediaType || !bytes.Equal(got.Data, tc.want.Data) {
+			t.Errorf("%s: UnwrapDRM() = %s %q, want %s %q", tc.name, got.MediaType, got.Data, tc.want.MediaType, tc.want.Data)
+		}
+	}
+}
+
+func TestUnwrapDRMInvalid(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a DRM message"), {1, 200, 0}} {
+		for _, mediaType := range []string{DRM_MESSAGE, DRM_CONTENT} {
+			a := Attachment{MediaType: mediaType, Data: data}
+			if _, err := a.UnwrapDRM(); err == nil {
+				t.Errorf("UnwrapDRM() of %s %q succeeded", mediaType, data)
+			}
+			if got := a.ProtectedMediaType(); got != PROTECTED_CONTENT {
+				t.Errorf("ProtectedMediaType() of %s %q = %q", mediaType, data, got)
+			}
+		}
+	}
+}
@@ -108,3 +108,20 @@ func (s *EncodeDecodeTestSuite) TestLength(c *C) {
 		c.Check(integer, Equals, testLengths[i], Commentf("%d != %d with encoded bytes starting at %d: %d", integer, testLengths[i], s.dec.Offset, bytes))
 	}
 }
+
+func (s *EncodeDecodeTestSuite) TestEncodedString(c *C) {
+	testStrs := []string{"Hello", "Héllo", "¡Hola!", "Привет"}
+	for i := range testStrs {
+		c.Assert(s.enc.writeEncodedStringParam(SUBJECT, testStrs[i]), IsNil)
+	}
+	bytes := s.bytes.Bytes()
+	s.dec = NewDecoder(bytes)
+	for i := range testStrs {
+		param, err := s.dec.ReadByte(nil, "")
+		c.Assert(err, IsNil)
+		c.Check(param, Equals, byte(SUBJECT|0x80))
+		str, err := s.dec.ReadEncodedString(nil, "")
+		c.Assert(err, IsNil)
+		c.Check(str, Equals, testStrs[i], Commentf("encoded bytes: %#x", bytes))
+	}
+}
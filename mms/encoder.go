@@ -26,8 +26,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"reflect"
+	"strings"
 )
 
 type MMSEncoder struct {
@@ -78,9 +78,10 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			err = enc.writeStringParam(WSP_PARAMETER_TYPE_NAME_DEFUNCT, f.String())
 		case "Start":
 			err = enc.writeStringParam(WSP_PARAMETER_TYPE_START_DEFUNCT, f.String())
-		case "To":
+		case "To", "Cc", "Bcc":
+			param := map[string]byte{"To": TO, "Cc": CC, "Bcc": BCC}[fieldName]
 			for i := 0; i < f.Len(); i++ {
-				err = enc.writeStringParam(TO, f.Index(i).String())
+				err = enc.writeStringParam(param, f.Index(i).String())
 				if err != nil {
 					break
 				}
@@ -91,7 +92,7 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 				if err := enc.setParam(CONTENT_TYPE); err != nil {
 					return err
 				}
-				if err = enc.writeContentType(mSendReq.ContentType, mSendReq.ContentTypeStart, mSendReq.ContentTypeType, ""); err != nil {
+				if err = enc.writeContentType(mSendReq.ContentType, mSendReq.ContentTypeStart, mSendReq.ContentTypeType, "", ""); err != nil {
 					return err
 				}
 				err = enc.writeAttachments(mSendReq.Attachments)
@@ -100,7 +101,7 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			}
 		case "MediaType":
 			if a, ok := pdu.(*Attachment); ok {
-				if err = enc.writeContentType(a.MediaType, "", "", a.Name); err != nil {
+				if err = enc.writeContentType(a.MediaType, "", "", a.Name, a.Charset); err != nil {
 					return err
 				}
 			} else {
@@ -112,7 +113,11 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			//TODO
 			err = enc.writeCharset(f.String())
 		case "ContentLocation":
-			err = enc.writeStringParam(MMS_PART_CONTENT_LOCATION, f.String())
+			if _, ok := pdu.(*Attachment); ok {
+				err = enc.writeStringParam(MMS_PART_CONTENT_LOCATION, f.String())
+			} else {
+				err = enc.writeStringParam(X_MMS_CONTENT_LOCATION, f.String())
+			}
 		case "ContentId":
 			err = enc.writeQuotedStringParam(MMS_PART_CONTENT_ID, f.String())
 		case "Date":
@@ -122,12 +127,54 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			}
 		case "Class":
 			err = enc.writeByteParam(X_MMS_MESSAGE_CLASS, byte(f.Uint()))
+		case "Priority":
+			if priority := byte(f.Uint()); priority != 0 {
+				err = enc.writeByteParam(X_MMS_PRIORITY, priority)
+			}
+		case "SenderVisibility":
+			if visibility := byte(f.Uint()); visibility != 0 {
+				err = enc.writeByteParam(X_MMS_SENDER_VISIBILITY, visibility)
+			}
 		case "ReportAllowed":
 			err = enc.writeByteParam(X_MMS_REPORT_ALLOWED, byte(f.Uint()))
 		case "DeliveryReport":
 			err = enc.writeByteParam(X_MMS_DELIVERY_REPORT, byte(f.Uint()))
 		case "ReadReport":
 			err = enc.writeByteParam(X_MMS_READ_REPORT, byte(f.Uint()))
+		case "ReadStatus":
+			err = enc.writeByteParam(X_MMS_READ_STATUS, byte(f.Uint()))
+		case "MessageId":
+			err = enc.writeStringParam(MESSAGE_ID, f.String())
+		case "Subject":
+			err = enc.writeEncodedStringParam(SUBJECT, f.String())
+		case "Store":
+			if store := byte(f.Uint()); store != 0 {
+				err = enc.writeByteParam(X_MMS_STORE, store)
+			}
+		case "MMState":
+			if state := byte(f.Uint()); state != 0 {
+				err = enc.writeByteParam(X_MMS_MM_STATE, state)
+			}
+		case "MMStates":
+			for i := 0; i < f.Len() && err == nil; i++ {
+				err = enc.writeByteParam(X_MMS_MM_STATE, byte(f.Index(i).Uint()))
+			}
+		case "StartIndex":
+			if start := f.Uint(); start > 0 {
+				err = enc.writeIntegerParam(X_MMS_START, start)
+			}
+		case "Limit":
+			if limit := f.Uint(); limit > 0 {
+				err = enc.writeIntegerParam(X_MMS_LIMIT, limit)
+			}
+		case "Totals":
+			if totals := byte(f.Uint()); totals != 0 {
+				err = enc.writeByteParam(X_MMS_TOTALS, totals)
+			}
+		case "CancelId":
+			err = enc.writeStringParam(X_MMS_CANCEL_ID, f.String())
+		case "CancelStatus":
+			err = enc.writeByteParam(X_MMS_CANCEL_STATUS, byte(f.Uint()))
 		case "Expiry":
 			expiry := f.Uint()
 			if expiry > 0 {
@@ -135,7 +182,7 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			}
 		default:
 			if encodeTag == "optional" {
-				log.Printf("Unhandled optional field %s", fieldName)
+				logger.Warnf("Unhandled optional field %s", fieldName)
 			} else {
 				panic(fmt.Sprintf("missing encoding for mandatory field %s", fieldName))
 			}
@@ -198,13 +245,23 @@ func (enc *MMSEncoder) writeCharset(charset string) error {
 	if charset == "" {
 		return nil
 	}
-	charsetCode := uint64(ANY_CHARSET)
+	code, ok := charsetCode(charset)
+	if !ok {
+		code = ANY_CHARSET
+	}
+	return enc.writeIntegerParam(WSP_PARAMETER_TYPE_CHARSET, code)
+}
+
+// charsetCode returns the well known charset code of charset, ok is false if
+// it has none.
+func charsetCode(charset string) (code uint64, ok bool) {
+	charset = NormalizeCharset(charset)
 	for k, v := range CHARSETS {
-		if v == charset {
-			charsetCode = k
+		if strings.ToLower(v) == charset {
+			return k, true
 		}
 	}
-	return enc.writeIntegerParam(WSP_PARAMETER_TYPE_CHARSET, charsetCode)
+	return 0, false
 }
 
 func (enc *MMSEncoder) writeLength(length uint64) error {
@@ -228,12 +285,20 @@ func encodeContentType(media string) (uint64, error) {
 	return 0, errors.New("cannot binary encode media")
 }
 
-func (enc *MMSEncoder) writeContentType(media, start, ctype, name string) error {
-	if start == "" && ctype == "" && name == "" {
+func (enc *MMSEncoder) writeContentType(media, start, ctype, name, charset string) error {
+	var contentType []byte
+	if charset != "" {
+		if code, ok := charsetCode(charset); ok {
+			contentType = append(contentType, WSP_PARAMETER_TYPE_CHARSET|SHORT_FILTER)
+			contentType = append(contentType, encodeInteger(code)...)
+		} else {
+			logger.Warnf("Not encoding unknown charset %s of %s", charset, media)
+		}
+	}
+	if start == "" && ctype == "" && name == "" && len(contentType) == 0 {
 		return enc.writeMediaType(media)
 	}
 
-	var contentType []byte
 	if start != "" {
 		contentType = append(contentType, WSP_PARAMETER_TYPE_START_DEFUNCT|SHORT_FILTER)
 		contentType = append(contentType, []byte(start)...)
@@ -337,6 +402,48 @@ func (enc *MMSEncoder) writeStringParam(param byte, s string) error {
 	return enc.writeString(s)
 }
 
+// writeEncodedStringParam writes s as an Encoded-string-value, see section
+// 7.2.9 of OMA-WAP-MMS-ENC-V1_3. US-ASCII text is written as a plain
+// Text-string, any other text as UTF-8 preceded by its charset.
+func (enc *MMSEncoder) writeEncodedStringParam(param byte, s string) error {
+	if s == "" {
+		enc.log = enc.log + "Skipping empty string\n"
+		return nil
+	}
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return enc.writeStringParam(param, s)
+	}
+
+	code, _ := charsetCode("utf-8")
+	charset := encodeInteger(code)
+	// A Text-string starting with an octet above 127 is quoted.
+	var quote []byte
+	if s[0] >= 0x80 {
+		quote = []byte{TEXT_MAX}
+	}
+	if err := enc.setParam(param); err != nil {
+		return err
+	}
+	// +1 for the byte{0}
+	if err := enc.writeLength(uint64(len(charset) + len(quote) + len(s) + 1)); err != nil {
+		return err
+	}
+	if err := enc.writeBytes(charset, len(charset)); err != nil {
+		return err
+	}
+	if err := enc.writeBytes(quote, len(quote)); err != nil {
+		return err
+	}
+	return enc.writeString(s)
+}
+
 func (enc *MMSEncoder) writeByteParam(param byte, b byte) error {
 	if err := enc.setParam(param); err != nil {
 		return err
@@ -407,6 +514,15 @@ func (enc *MMSEncoder) writeLongInteger(i uint64) error {
 	return enc.writeBytes(encodedLong, len(encodedLong))
 }
 
+// encodeInteger returns i encoded as an Integer-value, see writeInteger.
+func encodeInteger(i uint64) []byte {
+	if i < 0x80 {
+		return []byte{byte(i | 0x80)}
+	}
+	encodedLong := encodeLong(i)
+	return append([]byte{byte(len(encodedLong))}, encodedLong...)
+}
+
 func encodeLong(i uint64) (encodedLong []byte) {
 	for i > 0 {
 		b := byte(0xff & i)
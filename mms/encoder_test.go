@@ -26,6 +26,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	. "launchpad.net/gocheck"
 )
@@ -64,6 +65,38 @@ func (s *EncoderTestSuite) TestEncodeMNotifyRespIndRetrievedWithReports(c *C) {
 	c.Assert(outBytes.Bytes(), DeepEquals, expectedBytes)
 }
 
+func (s *EncoderTestSuite) TestEncodeMReadRecInd(c *C) {
+	expectedBytes := []byte{
+		//Message Type m-read-rec.ind
+		0x8C, 0x87,
+		// MMS Version 1.2
+		0x8D, 0x92,
+		// Message Id
+		0x8B, 0x6d, 0x73, 0x67, 0x31, 0x00,
+		// To
+		0x97, 0x2b, 0x31, 0x32, 0x33, 0x34, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00,
+		// From insert address
+		0x89, 0x01, 0x81,
+		// Date
+		0x85, 0x04, 0x40, 0x19, 0xfe, 0x91,
+		// Read Status read
+		0x9B, 0x80,
+	}
+	mRetrieveConf := &MRetrieveConf{
+		UUID:       "1",
+		Version:    MMS_MESSAGE_VERSION_1_2,
+		MessageId:  "msg1",
+		From:       "+1234/TYPE=PLMN",
+		ReadReport: ReadReportYes,
+	}
+	c.Assert(mRetrieveConf.ReadReportRequested(), Equals, true)
+	mReadRecInd := mRetrieveConf.NewMReadRecInd(time.Unix(1075445393, 0))
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(mReadRecInd), IsNil)
+	c.Assert(outBytes.Bytes(), DeepEquals, expectedBytes)
+}
+
 func (s *EncoderTestSuite) TestEncodeMNotifyRespIndDeffered(c *C) {
 	expectedBytes := []byte{
 		//Message Type m-notifyresp.ind
@@ -139,3 +172,86 @@ func (s *EncoderTestSuite) TestEncodeMSendReq(c *C) {
 	err = enc.Encode(mSendReq)
 	c.Assert(err, IsNil)
 }
+
+func (s *EncoderTestSuite) TestEncodeMSendReqSubject(c *C) {
+	mSendReq := NewMSendReq([]string{"+1"}, []*Attachment{}, false)
+	mSendReq.Subject = "Hi"
+
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(mSendReq), IsNil)
+	c.Check(bytes.Contains(outBytes.Bytes(), []byte{SUBJECT | 0x80, 'H', 'i', 0}), Equals, true)
+
+	mSendReq.Subject = "Ça va"
+	outBytes.Reset()
+	c.Assert(enc.Encode(mSendReq), IsNil)
+	// Value-length, utf-8, Quote as the text starts above 127.
+	header := append([]byte{SUBJECT | 0x80, 0x09, 0xea, 0x7f}, "Ça va"...)
+	c.Check(bytes.Contains(outBytes.Bytes(), append(header, 0)), Equals, true, Commentf("%#x", outBytes.Bytes()))
+}
+
+func (s *EncoderTestSuite) TestEncodeMSendReqPriorityClassVisibility(c *C) {
+	mSendReq := NewMSendReq([]string{"+1"}, []*Attachment{}, false)
+	// No date, its octets could be mistaken for headers.
+	mSendReq.Date = 0
+
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(mSendReq), IsNil)
+	c.Check(bytes.Contains(outBytes.Bytes(), []byte{X_MMS_MESSAGE_CLASS | 0x80, ClassPersonal}), Equals, true)
+	for _, param := range []byte{X_MMS_PRIORITY, X_MMS_SENDER_VISIBILITY} {
+		c.Check(bytes.IndexByte(outBytes.Bytes(), param|0x80), Equals, -1, Commentf("header %#x", param))
+	}
+
+	mSendReq.Priority = PriorityHigh
+	mSendReq.Class = ClassInformational
+	mSendReq.SenderVisibility = SenderVisibilityHide
+	outBytes.Reset()
+	c.Assert(enc.Encode(mSendReq), IsNil)
+	for param, value := range map[byte]byte{X_MMS_PRIORITY: PriorityHigh, X_MMS_MESSAGE_CLASS: ClassInformational, X_MMS_SENDER_VISIBILITY: SenderVisibilityHide} {
+		c.Check(bytes.Contains(outBytes.Bytes(), []byte{param | 0x80, value}), Equals, true, Commentf("header %#x", param))
+	}
+}
+
+func (s *EncoderTestSuite) TestEncodeTextAttachmentCharset(c *C) {
+	tmp, err := ioutil.TempFile("", "")
+	c.Assert(err, IsNil)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	c.Assert(ioutil.WriteFile(tmp.Name(), []byte("hi"), 0644), IsNil)
+
+	att, err := NewAttachment("text0", "text/plain", tmp.Name())
+	c.Assert(err, IsNil)
+	c.Check(att.Charset, Equals, "utf-8")
+
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(att), IsNil)
+	// Value-length, text/plain, Charset utf-8, then the Name
+	c.Check(bytes.HasPrefix(outBytes.Bytes(), []byte{0x0a, 0x83, 0x81, 0xea, 0x85}), Equals, true, Commentf("%#x", outBytes.Bytes()))
+}
+
+func (s *EncoderTestSuite) TestEncodeMSendReqCopyRecipients(c *C) {
+	mSendReq := NewMSendReq([]string{"+1"}, []*Attachment{}, false)
+	mSendReq.AddCopyRecipients([]string{"+2"}, []string{"+3"})
+
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(mSendReq), IsNil)
+	for param, address := range map[byte]string{TO: "+1/TYPE=PLMN", CC: "+2/TYPE=PLMN", BCC: "+3/TYPE=PLMN"} {
+		header := append([]byte{param | 0x80}, address...)
+		c.Check(bytes.Contains(outBytes.Bytes(), append(header, 0)), Equals, true, Commentf("header %#x", param))
+	}
+}
+
+func (s *EncoderTestSuite) TestMSendReqAddressSize(c *C) {
+	mSendReq := NewMSendReq([]string{"+1", "+22"}, []*Attachment{}, false)
+	mSendReq.AddCopyRecipients(nil, []string{"+3"})
+	var withAddresses, withoutAddresses bytes.Buffer
+	c.Assert(NewEncoder(&withAddresses).Encode(mSendReq), IsNil)
+	mSendReq.To, mSendReq.Bcc = nil, nil
+	c.Assert(NewEncoder(&withoutAddresses).Encode(mSendReq), IsNil)
+	c.Check(NewMSendReq([]string{"+1", "+22"}, []*Attachment{}, false).AddressSize(), Equals, uint64(len("+1/TYPE=PLMN")+len("+22/TYPE=PLMN")+2*2))
+	mSendReq.AddCopyRecipients([]string{"+1", "+22"}, []string{"+3"})
+	c.Check(mSendReq.AddressSize(), Equals, uint64(withAddresses.Len()-withoutAddresses.Len()))
+}
@@ -1,6 +1,9 @@
 package mms
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ErrorDecodeShortData struct {
 	Length, Expected int
@@ -24,6 +27,95 @@ func (e ErrorDecodeInconsistentOffset) Error() string {
 	return fmt.Sprintf("Decoder offset after read [%d] is other than expected [%d]", e.Offset, e.Expected)
 }
 
+// ErrorUnexpectedContent is the error of a download which did not return a
+// PDU, ContentType is what was returned instead, as told by the HTTP
+// response or sniffed from the content.
+type ErrorUnexpectedContent struct {
+	ContentType string
+}
+
+func (e ErrorUnexpectedContent) Error() string {
+	if e.ContentType == "" {
+		return "download returned no content"
+	}
+	return fmt.Sprintf("download returned %s instead of %s", e.ContentType, mmsContentType)
+}
+
+// Gateway returns true if the content is a page of a WAP gateway or captive
+// portal, which intercepts transactions e.g. when the subscription has no
+// data or MMS service.
+func (e ErrorUnexpectedContent) Gateway() bool {
+	return strings.HasPrefix(e.ContentType, "text/") || strings.HasPrefix(e.ContentType, "application/xhtml")
+}
+
+// ErrorRetrieveStatus is the error an m-retrieve.conf reports instead of the
+// message with its X-Mms-Retrieve-Status and X-Mms-Retrieve-Text.
+type ErrorRetrieveStatus struct {
+	Status byte
+	Text   string
+}
+
+func (e ErrorRetrieveStatus) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("MMS center failed to retrieve the message with status %d: %s", e.Status, e.Text)
+	}
+	return fmt.Sprintf("MMS center failed to retrieve the message with status %d", e.Status)
+}
+
+// Transient returns true if retrieving the message may succeed later.
+func (e ErrorRetrieveStatus) Transient() bool {
+	return e.Status >= RetrieveStatusErrorTransientFailure && e.Status < RetrieveStatusErrorPermanentFailure
+}
+
+// Reason returns Status with reserved values mapped to the generic transient
+// or permanent failure they are treated as.
+func (e ErrorRetrieveStatus) Reason() byte {
+	switch {
+	case e.Status >= RetrieveStatusErrorTransientFailure && e.Status <= RetrieveStatusErrorTransientNetworkProblem:
+		return e.Status
+	case e.Transient():
+		return RetrieveStatusErrorTransientFailure
+	case e.Status >= RetrieveStatusErrorPermanentFailure && e.Status <= RetrieveStatusErrorPermanentContentUnsupported:
+		return e.Status
+	}
+	return RetrieveStatusErrorPermanentFailure
+}
+
+// ErrorResponseStatus is the error an m-send.conf reports with its
+// X-Mms-Response-Status and X-Mms-Response-Text when the MMS center did not
+// accept a message.
+type ErrorResponseStatus struct {
+	Status byte
+	Text   string
+}
+
+func (e ErrorResponseStatus) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("MMS center did not accept the message with status %d: %s", e.Status, e.Text)
+	}
+	return fmt.Sprintf("MMS center did not accept the message with status %d", e.Status)
+}
+
+// Transient returns true if sending the message again may succeed.
+func (e ErrorResponseStatus) Transient() bool {
+	return responseStatusClass(e.Status) == ErrTransient
+}
+
+// ErrorStoreStatus is the error a m-mbox-store.conf reports with its
+// X-Mms-Store-Status and X-Mms-Store-Status-Text when the MMSC did not store
+// a message in the MMBox.
+type ErrorStoreStatus struct {
+	Status byte
+	Text   string
+}
+
+func (e ErrorStoreStatus) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("MMS center did not store the message with status %d: %s", e.Status, e.Text)
+	}
+	return fmt.Sprintf("MMS center did not store the message with status %d", e.Status)
+}
+
 const (
 	DebugErrorActivateContext      = "error-activate-context"
 	DebugErrorGetProxy             = "error-get-proxy"
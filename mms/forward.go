@@ -0,0 +1,60 @@
+package mms
+
+// MForwardReq holds a m-forward.req message defined in OMA-WAP-MMS-ENC-v1.2
+// section 6.5. It asks the MMSC to forward the message at ContentLocation,
+// which is still on the MMSC, to the recipients in To without it being
+// downloaded and uploaded again.
+type MForwardReq struct {
+	UUID            string `encode:"no"`
+	Type            byte
+	TransactionId   string
+	Version         byte
+	Date            uint64 `encode:"optional"`
+	From            string
+	To              []string
+	DeliveryReport  byte
+	ReadReport      byte
+	ContentLocation string
+}
+
+// NewMForwardReq creates the request to forward the message at
+// contentLocation to recipients.
+func NewMForwardReq(contentLocation string, recipients []string, deliveryReport bool) *MForwardReq {
+	uuid := GenUUID()
+	return &MForwardReq{
+		UUID:            uuid,
+		Type:            TYPE_FORWARD_REQ,
+		TransactionId:   uuid,
+		Version:         MMS_MESSAGE_VERSION_1_2,
+		Date:            getDate(),
+		To:              encodeAddresses(recipients),
+		DeliveryReport:  getDeliveryReport(deliveryReport),
+		ReadReport:      getReadReport(false),
+		ContentLocation: contentLocation,
+	}
+}
+
+// MForwardConf holds a m-forward.conf message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.5, the answer to a m-forward.req.
+type MForwardConf struct {
+	MMSReader
+	Type, Version   byte
+	TransactionId   string
+	ResponseStatus  byte
+	ResponseText    string
+	MessageId       string
+	ContentLocation string
+}
+
+func NewMForwardConf() *MForwardConf {
+	return &MForwardConf{Type: TYPE_FORWARD_CONF}
+}
+
+// ResponseError returns an ErrorResponseStatus if the MMSC did not forward
+// the message.
+func (conf *MForwardConf) ResponseError() error {
+	if responseStatusClass(conf.ResponseStatus) == nil {
+		return nil
+	}
+	return ErrorResponseStatus{Status: conf.ResponseStatus, Text: conf.ResponseText}
+}
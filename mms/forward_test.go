@@ -0,0 +1,47 @@
+package mms
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeMForwardReq(t *testing.T) {
+	mForwardReq := NewMForwardReq("http://mms/1", []string{"+1"}, true)
+
+	var outBytes bytes.Buffer
+	if err := NewEncoder(&outBytes).Encode(mForwardReq); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range [][]byte{
+		{0x80 + X_MMS_MESSAGE_TYPE, TYPE_FORWARD_REQ},
+		append(append([]byte{0x80 + TO}, "+1/TYPE=PLMN"...), 0),
+		{0x80 + X_MMS_DELIVERY_REPORT, DeliveryReportYes},
+		append(append([]byte{0x80 + X_MMS_CONTENT_LOCATION}, "http://mms/1"...), 0),
+	} {
+		if !bytes.Contains(outBytes.Bytes(), header) {
+			t.Errorf("encoded m-forward.req %#x has no %#x", outBytes.Bytes(), header)
+		}
+	}
+}
+
+func TestDecodeMForwardConf(t *testing.T) {
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_FORWARD_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2,
+		0x80 + X_MMS_RESPONSE_STATUS, ResponseStatusOk,
+		0x80 + MESSAGE_ID, 'i', 'd', 0,
+	}
+	mForwardConf := NewMForwardConf()
+	if err := NewDecoder(inputBytes).Decode(mForwardConf); err != nil {
+		t.Fatal(err)
+	}
+	if err := mForwardConf.ResponseError(); err != nil || mForwardConf.MessageId != "id" {
+		t.Errorf("ResponseError(), MessageId = %v, %q, want nil, id", err, mForwardConf.MessageId)
+	}
+
+	mForwardConf.ResponseStatus = ResponseStatusErrorPermanentMessageNotFound
+	if err := mForwardConf.ResponseError(); err == nil {
+		t.Error("ResponseError() = nil for a message not found")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build gofuzz
+// +build gofuzz
+
+package mms
+
+import "time"
+
+// Fuzz is the go-fuzz entry point of the decoder, also usable with libFuzzer
+// through go-fuzz-build -libfuzzer, see docs/testing.md. It decodes data as
+// every PDU nuntium receives, a panic is a crash.
+func Fuzz(data []byte) int {
+	pdus := []MMSReader{
+		NewMNotificationInd(time.Time{}),
+		NewMRetrieveConf("fuzz"),
+		NewMSendConf(),
+		NewMDeliveryInd(),
+		NewMReadOrigInd(),
+	}
+	interesting := 0
+	for _, pdu := range pdus {
+		if err := NewDecoder(data).Decode(pdu); err == nil {
+			interesting = 1
+		}
+	}
+	return interesting
+}
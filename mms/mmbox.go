@@ -0,0 +1,217 @@
+package mms
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MM states defined in OMA-WAP-MMS-ENC-v1.2 section 7.2.33 for X-Mms-MM-State
+const (
+	MMStateDraft     byte = 128
+	MMStateSent      byte = 129
+	MMStateNew       byte = 130
+	MMStateRetrieved byte = 131
+	MMStateForwarded byte = 132
+)
+
+// Store status defined in OMA-WAP-MMS-ENC-v1.2 section 7.2.36 for
+// X-Mms-Store-Status
+const (
+	StoreStatusSuccess                       byte = 128
+	StoreStatusErrorTransientFailure         byte = 192
+	StoreStatusErrorTransientNetworkProblem  byte = 193
+	StoreStatusErrorPermanentFailure         byte = 224
+	StoreStatusErrorPermanentServiceDenied   byte = 225
+	StoreStatusErrorPermanentMessageFormat   byte = 226
+	StoreStatusErrorPermanentMessageNotFound byte = 227
+	StoreStatusErrorPermanentMMBoxFull       byte = 228
+)
+
+// Yes and No values of X-Mms-Store and X-Mms-Totals
+const (
+	StoreYes  byte = 128
+	StoreNo   byte = 129
+	TotalsYes byte = 128
+	TotalsNo  byte = 129
+)
+
+// Tokens of X-Mms-Mbox-Totals defined in OMA-WAP-MMS-ENC-v1.2 section 7.2.22
+const (
+	MessageTotalToken byte = 128
+	SizeTotalToken    byte = 129
+)
+
+// MMboxStoreReq holds a m-mbox-store.req message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.8. It asks the MMSC to keep the message at
+// ContentLocation in the MMBox of the user.
+type MMboxStoreReq struct {
+	UUID            string `encode:"no"`
+	Type            byte
+	TransactionId   string
+	Version         byte
+	ContentLocation string
+	MMState         byte `encode:"optional"`
+}
+
+// NewMMboxStoreReq creates the request to store the message at
+// contentLocation in the MMBox with state, 0 to let the MMSC decide.
+func NewMMboxStoreReq(contentLocation string, state byte) *MMboxStoreReq {
+	uuid := GenUUID()
+	return &MMboxStoreReq{
+		UUID:            uuid,
+		Type:            TYPE_MBOX_STORE_REQ,
+		TransactionId:   uuid,
+		Version:         MMS_MESSAGE_VERSION_1_2,
+		ContentLocation: contentLocation,
+		MMState:         state,
+	}
+}
+
+// MMboxStoreConf holds a m-mbox-store.conf message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.8, the answer to a m-mbox-store.req.
+type MMboxStoreConf struct {
+	MMSReader
+	Type, Version   byte
+	TransactionId   string
+	ContentLocation string
+	StoreStatus     byte
+	StoreStatusText string
+}
+
+func NewMMboxStoreConf() *MMboxStoreConf {
+	return &MMboxStoreConf{Type: TYPE_MBOX_STORE_CONF}
+}
+
+// StoreError returns an ErrorStoreStatus if the MMSC did not store the
+// message.
+func (conf *MMboxStoreConf) StoreError() error {
+	if conf.StoreStatus == StoreStatusSuccess {
+		return nil
+	}
+	return ErrorStoreStatus{Status: conf.StoreStatus, Text: conf.StoreStatusText}
+}
+
+// MMboxViewReq holds a m-mbox-view.req message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.9. It asks the MMSC for the list of the
+// messages in the MMBox in MMStates, or in any state if empty, starting with
+// the StartIndex one and at most Limit, 0 for no limit.
+type MMboxViewReq struct {
+	UUID          string `encode:"no"`
+	Type          byte
+	TransactionId string
+	Version       byte
+	MMStates      []byte `encode:"optional"`
+	StartIndex    uint64 `encode:"optional"`
+	Limit         uint64 `encode:"optional"`
+	Totals        byte   `encode:"optional"`
+}
+
+// NewMMboxViewReq creates the request to list limit messages in the MMBox
+// starting from start.
+func NewMMboxViewReq(start, limit uint64, states []byte) *MMboxViewReq {
+	uuid := GenUUID()
+	return &MMboxViewReq{
+		UUID:          uuid,
+		Type:          TYPE_MBOX_VIEW_REQ,
+		TransactionId: uuid,
+		Version:       MMS_MESSAGE_VERSION_1_2,
+		MMStates:      states,
+		StartIndex:    start,
+		Limit:         limit,
+		Totals:        TotalsYes,
+	}
+}
+
+// MMboxViewConf holds a m-mbox-view.conf message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.9, the answer to a m-mbox-view.req. Each
+// attachment is a m-mbox-descr describing a message, see Descriptions.
+// MessageTotal and SizeTotal are the X-Mms-Mbox-Totals of the MMBox, if the
+// MMSC tells them.
+type MMboxViewConf struct {
+	MMSReader
+	Type, Version  byte
+	TransactionId  string
+	ResponseStatus byte
+	ResponseText   string
+	MessageCount   uint64
+	StartIndex     uint64
+	MessageTotal   uint64
+	SizeTotal      uint64
+	Content        Attachment
+	Attachments    []Attachment
+}
+
+func NewMMboxViewConf() *MMboxViewConf {
+	return &MMboxViewConf{Type: TYPE_MBOX_VIEW_CONF}
+}
+
+// ResponseError returns an ErrorResponseStatus if the MMSC did not list the
+// MMBox.
+func (conf *MMboxViewConf) ResponseError() error {
+	if conf.ResponseStatus == 0 || responseStatusClass(conf.ResponseStatus) == nil {
+		return nil
+	}
+	return ErrorResponseStatus{Status: conf.ResponseStatus, Text: conf.ResponseText}
+}
+
+// Descriptions decodes the m-mbox-descr parts of the listing.
+func (conf *MMboxViewConf) Descriptions() ([]*MMboxDescr, error) {
+	var descriptions []*MMboxDescr
+	for i, part := range conf.Attachments {
+		descr := NewMMboxDescr()
+		if err := NewDecoder(part.Data).Decode(descr); err != nil {
+			return nil, fmt.Errorf("cannot decode m-mbox-descr %d: %w", i, err)
+		}
+		descriptions = append(descriptions, descr)
+	}
+	return descriptions, nil
+}
+
+// MMboxDescr holds a m-mbox-descr message defined in OMA-WAP-MMS-ENC-v1.2
+// section 6.10, the description of a message in the MMBox. The message is
+// retrieved from ContentLocation like a notified one.
+type MMboxDescr struct {
+	MMSReader
+	Type, Version, MMState byte
+	Priority, Class        byte
+	ContentLocation        string
+	MessageId              string
+	From, Subject          string
+	To                     []string
+	Date                   uint64
+	Size                   uint64
+	Content                Attachment
+	Attachments            []Attachment
+}
+
+func NewMMboxDescr() *MMboxDescr {
+	return &MMboxDescr{Type: TYPE_MBOX_DESCR}
+}
+
+// readMboxTotals reads a X-Mms-Mbox-Totals value, the number of messages or
+// their size in bytes depending on its token.
+func (dec *MMSDecoder) readMboxTotals(reflectedPdu *reflect.Value) error {
+	length, err := dec.ReadLength(nil)
+	if err != nil {
+		return err
+	}
+	if err := dec.checkLength(length); err != nil {
+		return err
+	}
+	end := dec.Offset + int(length)
+	token, err := dec.ReadByte(nil, "")
+	if err != nil {
+		return err
+	}
+	hdr := "MessageTotal"
+	if token == SizeTotalToken {
+		hdr = "SizeTotal"
+	}
+	if _, err := dec.ReadInteger(reflectedPdu, hdr); err != nil {
+		return err
+	}
+	if dec.Offset != end {
+		return fmt.Errorf("mbox totals do not match their length of %d @%d", length, end)
+	}
+	return nil
+}
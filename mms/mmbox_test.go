@@ -0,0 +1,117 @@
+package mms
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeMMboxStoreReq(t *testing.T) {
+	mMboxStoreReq := NewMMboxStoreReq("http://mms/1", MMStateNew)
+	mMboxStoreReq.TransactionId = "1"
+
+	var outBytes bytes.Buffer
+	if err := NewEncoder(&outBytes).Encode(mMboxStoreReq); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_MBOX_STORE_REQ,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2,
+		0x80 + X_MMS_CONTENT_LOCATION,
+	}
+	want = append(append(want, "http://mms/1"...), 0, 0x80+X_MMS_MM_STATE, MMStateNew)
+	if !bytes.Equal(outBytes.Bytes(), want) {
+		t.Errorf("encoded m-mbox-store.req = %#x, want %#x", outBytes.Bytes(), want)
+	}
+}
+
+func TestEncodeMMboxViewReq(t *testing.T) {
+	mMboxViewReq := NewMMboxViewReq(10, 5, []byte{MMStateNew, MMStateRetrieved})
+
+	var outBytes bytes.Buffer
+	if err := NewEncoder(&outBytes).Encode(mMboxViewReq); err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range [][]byte{
+		{0x80 + X_MMS_MM_STATE, MMStateNew, 0x80 + X_MMS_MM_STATE, MMStateRetrieved},
+		{0x80 + X_MMS_START, 0x80 + 10},
+		{0x80 + X_MMS_LIMIT, 0x80 + 5},
+		{0x80 + X_MMS_TOTALS, TotalsYes},
+	} {
+		if !bytes.Contains(outBytes.Bytes(), header) {
+			t.Errorf("encoded m-mbox-view.req %#x has no %#x", outBytes.Bytes(), header)
+		}
+	}
+}
+
+func TestDecodeMMboxStoreConf(t *testing.T) {
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_MBOX_STORE_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2,
+		0x80 + X_MMS_CONTENT_LOCATION, 'b', 'o', 'x', 0,
+		0x80 + X_MMS_STORE_STATUS, StoreStatusErrorPermanentMMBoxFull,
+		0x80 + X_MMS_STORE_STATUS_TEXT, 'F', 'u', 'l', 'l', 0,
+	}
+	mMboxStoreConf := NewMMboxStoreConf()
+	if err := NewDecoder(inputBytes).Decode(mMboxStoreConf); err != nil {
+		t.Fatal(err)
+	}
+	if mMboxStoreConf.ContentLocation != "box" {
+		t.Errorf("ContentLocation = %q, want box", mMboxStoreConf.ContentLocation)
+	}
+	want := ErrorStoreStatus{StoreStatusErrorPermanentMMBoxFull, "Full"}
+	if err := mMboxStoreConf.StoreError(); err != want {
+		t.Errorf("StoreError() = %v, want %v", err, want)
+	}
+}
+
+func TestDecodeMMboxViewConf(t *testing.T) {
+	descr := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_MBOX_DESCR,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2,
+		0x80 + X_MMS_CONTENT_LOCATION, 'm', '1', 0,
+		0x80 + X_MMS_MM_STATE, MMStateNew,
+		0x80 + SUBJECT, 'H', 'i', 0,
+		0x80 + X_MMS_MESSAGE_SIZE, 0x02, 0x01, 0x00,
+	}
+	inputBytes := []byte{
+		0x80 + X_MMS_MESSAGE_TYPE, TYPE_MBOX_VIEW_CONF,
+		0x80 + X_MMS_TRANSACTION_ID, '1', 0,
+		0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2,
+		0x80 + X_MMS_RESPONSE_STATUS, ResponseStatusOk,
+		0x80 + X_MMS_MBOX_TOTALS, 0x02, MessageTotalToken, 0x80 + 7,
+		0x80 + X_MMS_MESSAGE_COUNT, 0x80 + 1,
+		// application/vnd.wap.multipart.mixed with one
+		// application/vnd.wap.mms-message part
+		0x80 + CONTENT_TYPE, 0xa3, 0x01, 0x01, byte(len(descr)), 0xbe,
+	}
+	inputBytes = append(inputBytes, descr...)
+
+	mMboxViewConf := NewMMboxViewConf()
+	if err := NewDecoder(inputBytes).Decode(mMboxViewConf); err != nil {
+		t.Fatal(err)
+	}
+	if err := mMboxViewConf.ResponseError(); err != nil {
+		t.Errorf("ResponseError() = %v, want nil", err)
+	}
+	if mMboxViewConf.MessageTotal != 7 || mMboxViewConf.MessageCount != 1 {
+		t.Errorf("MessageTotal, MessageCount = %d, %d, want 7, 1", mMboxViewConf.MessageTotal, mMboxViewConf.MessageCount)
+	}
+	descriptions, err := mMboxViewConf.Descriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &MMboxDescr{
+		Type:            TYPE_MBOX_DESCR,
+		Version:         MMS_MESSAGE_VERSION_1_2,
+		MMState:         MMStateNew,
+		ContentLocation: "m1",
+		Subject:         "Hi",
+		Size:            256,
+	}
+	if len(descriptions) != 1 || !reflect.DeepEqual(descriptions[0], want) {
+		t.Errorf("Descriptions() = %+v, want [%+v]", descriptions, want)
+	}
+}
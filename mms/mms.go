@@ -24,14 +24,18 @@ package mms
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/logging"
 )
 
+var logger = logging.New("mms")
+
 // MMS Field names from OMA-WAP-MMS section 7.3 Table 12
 const (
 	BCC                           = 0x01
@@ -67,6 +71,22 @@ const (
 	X_MMS_REPLY_CHARGING_SIZE     = 0x1F
 	X_MMS_PREVIOUSLY_SENT_BY      = 0x20
 	X_MMS_PREVIOUSLY_SENT_DATE    = 0x21
+	X_MMS_STORE                   = 0x22
+	X_MMS_MM_STATE                = 0x23
+	X_MMS_MM_FLAGS                = 0x24
+	X_MMS_STORE_STATUS            = 0x25
+	X_MMS_STORE_STATUS_TEXT       = 0x26
+	X_MMS_STORED                  = 0x27
+	X_MMS_ATTRIBUTES              = 0x28
+	X_MMS_TOTALS                  = 0x29
+	X_MMS_MBOX_TOTALS             = 0x2A
+	X_MMS_QUOTAS                  = 0x2B
+	X_MMS_MBOX_QUOTAS             = 0x2C
+	X_MMS_MESSAGE_COUNT           = 0x2D
+	X_MMS_START                   = 0x2F
+	X_MMS_LIMIT                   = 0x33
+	X_MMS_CANCEL_ID               = 0x3E
+	X_MMS_CANCEL_STATUS           = 0x3F
 )
 
 // MMS Content Type Assignments OMA-WAP-MMS section 7.3 Table 13
@@ -83,6 +103,17 @@ const (
 	TYPE_RETRIEVE_CONF    = 0x84
 	TYPE_ACKNOWLEDGE_IND  = 0x85
 	TYPE_DELIVERY_IND     = 0x86
+	TYPE_READ_REC_IND     = 0x87
+	TYPE_READ_ORIG_IND    = 0x88
+	TYPE_FORWARD_REQ      = 0x89
+	TYPE_FORWARD_CONF     = 0x8A
+	TYPE_MBOX_STORE_REQ   = 0x8B
+	TYPE_MBOX_STORE_CONF  = 0x8C
+	TYPE_MBOX_VIEW_REQ    = 0x8D
+	TYPE_MBOX_VIEW_CONF   = 0x8E
+	TYPE_MBOX_DESCR       = 0x93
+	TYPE_CANCEL_REQ       = 0x96
+	TYPE_CANCEL_CONF      = 0x97
 )
 
 const (
@@ -118,6 +149,19 @@ const (
 	ClassAuto          byte = 131
 )
 
+// Priorities defined in OMA-WAP-MMS for X-Mms-Priority
+const (
+	PriorityLow    byte = 128
+	PriorityNormal byte = 129
+	PriorityHigh   byte = 130
+)
+
+// Sender visibilities defined in OMA-WAP-MMS for X-Mms-Sender-Visibility
+const (
+	SenderVisibilityHide byte = 128
+	SenderVisibilityShow byte = 129
+)
+
 // Report Report defined in OMA-WAP-MMS 7.2.20
 const (
 	ReadReportYes byte = 128
@@ -173,6 +217,31 @@ const (
 	ResponseStatusErrorPermamentMaxReserved byte = 255
 )
 
+// Retrieve Status defined in OMA-WAP-MMS for X-Mms-Retrieve-Status
+//
+// Values in range 195 to 223 are reserved and treated as 192
+// (Error-transient-failure), values in range 228 to 255 as 224
+// (Error-permanent-failure).
+const (
+	RetrieveStatusOk                               byte = 128
+	RetrieveStatusErrorTransientFailure            byte = 192
+	RetrieveStatusErrorTransientMessageNotFound    byte = 193
+	RetrieveStatusErrorTransientNetworkProblem     byte = 194
+	RetrieveStatusErrorPermanentFailure            byte = 224
+	RetrieveStatusErrorPermanentServiceDenied      byte = 225
+	RetrieveStatusErrorPermanentMessageNotFound    byte = 226
+	RetrieveStatusErrorPermanentContentUnsupported byte = 227
+)
+
+// RetrieveError returns an ErrorRetrieveStatus if the MMS center reported a
+// failure instead of sending the message.
+func (pdu *MRetrieveConf) RetrieveError() error {
+	if pdu.RetrieveStatus == 0 || pdu.RetrieveStatus == RetrieveStatusOk {
+		return nil
+	}
+	return ErrorRetrieveStatus{Status: pdu.RetrieveStatus, Text: pdu.RetrieveText}
+}
+
 // Status defined in OMA-WAP-MMS section 7.2.23
 const (
 	STATUS_EXPIRED      = 128
@@ -192,8 +261,8 @@ type MSendReq struct {
 	Date             uint64 `encode:"optional"`
 	From             string
 	To               []string
-	Cc               string `encode:"no"`
-	Bcc              string `encode:"no"`
+	Cc               []string
+	Bcc              []string
 	Subject          string `encode:"optional"`
 	Class            byte   `encode:"optional"`
 	Expiry           uint64 `encode:"optional"`
@@ -202,6 +271,7 @@ type MSendReq struct {
 	SenderVisibility byte   `encode:"optional"`
 	DeliveryReport   byte   `encode:"optional"`
 	ReadReport       byte   `encode:"optional"`
+	Store            byte   `encode:"optional"`
 	ContentTypeStart string `encode:"no"`
 	ContentTypeType  string `encode:"no"`
 	ContentType      string
@@ -226,6 +296,9 @@ type MNotificationInd struct {
 	UUID                                 string
 	RedownloadOfUUID                     string // If not empty, it means that the struct was created to redownload a previously failed message download with UUID stored in field.
 	Received                             time.Time
+	ReceivedBoot                         string        // Boot id at receipt, see ReceivedUptime.
+	ReceivedUptime                       time.Duration // Time since boot at receipt, a monotonic reference to Received used to compute expiry.
+	FromMMBox                            bool          // Set for a message retrieved from the MMBox on request, there is no notification to respond to.
 	Type, Version, Class, DeliveryReport byte
 	ReplyCharging, ReplyChargingDeadline byte
 	Priority                             byte
@@ -234,6 +307,7 @@ type MNotificationInd struct {
 	From, Subject                        string
 	Expiry                               time.Time
 	Size                                 uint64
+	PDU                                  []byte `json:",omitempty"` // The m-notification.ind as pushed, kept for bug reports.
 }
 
 // MNotifyRespInd holds a m-notifyresp.ind message defined in
@@ -271,9 +345,7 @@ type MMSWriter interface{}
 
 // NewMSendReq creates a personal message with a normal priority and no read report
 func NewMSendReq(recipients []string, attachments []*Attachment, deliveryReport bool) *MSendReq {
-	for i := range recipients {
-		recipients[i] += "/TYPE=PLMN"
-	}
+	recipients = encodeAddresses(recipients)
 	uuid := GenUUID()
 
 	orderedAttachments, smilStart, smilType := processAttachments(attachments)
@@ -297,6 +369,52 @@ func NewMSendReq(recipients []string, attachments []*Attachment, deliveryReport
 	}
 }
 
+// encodeAddresses returns the addresses of recipients as sent in PDUs, see
+// address.Encode.
+func encodeAddresses(recipients []string) []string {
+	addresses := make([]string, len(recipients))
+	for i := range recipients {
+		addresses[i] = address.Encode(recipients[i])
+	}
+	return addresses
+}
+
+// AddCopyRecipients adds cc to the Cc and bcc to the Bcc recipients of
+// mSendReq.
+func (mSendReq *MSendReq) AddCopyRecipients(cc, bcc []string) {
+	mSendReq.Cc = append(mSendReq.Cc, encodeAddresses(cc)...)
+	mSendReq.Bcc = append(mSendReq.Bcc, encodeAddresses(bcc)...)
+}
+
+// Ungroup makes every recipient of mSendReq a Bcc recipient, so each of them
+// gets a message from the sender alone instead of a group message which
+// shows the other recipients and is replied to all of them.
+func (mSendReq *MSendReq) Ungroup() {
+	mSendReq.Bcc = append(append(mSendReq.To, mSendReq.Cc...), mSendReq.Bcc...)
+	mSendReq.To, mSendReq.Cc = nil, nil
+}
+
+// AddressSize returns the size in bytes of the encoded To, Cc and Bcc
+// headers of mSendReq.
+func (mSendReq *MSendReq) AddressSize() uint64 {
+	var size uint64
+	for _, recipient := range mSendReq.Recipients() {
+		if recipient != "" {
+			// The field, the address and its terminating 0.
+			size += uint64(len(recipient)) + 2
+		}
+	}
+	return size
+}
+
+// Recipients returns all the To, Cc and Bcc recipients of mSendReq.
+func (mSendReq *MSendReq) Recipients() []string {
+	var recipients []string
+	recipients = append(recipients, mSendReq.To...)
+	recipients = append(recipients, mSendReq.Cc...)
+	return append(recipients, mSendReq.Bcc...)
+}
+
 func NewMSendConf() *MSendConf {
 	return &MSendConf{
 		Type: TYPE_SEND_CONF,
@@ -304,11 +422,13 @@ func NewMSendConf() *MSendConf {
 }
 
 func NewMNotificationInd(received time.Time) *MNotificationInd {
-	return &MNotificationInd{Type: TYPE_NOTIFICATION_IND, UUID: GenUUID(), Received: received}
+	mNotificationInd := &MNotificationInd{Type: TYPE_NOTIFICATION_IND, UUID: GenUUID()}
+	mNotificationInd.setReceived(received)
+	return mNotificationInd
 }
 
 func (mNotificationInd *MNotificationInd) IsLocal() bool {
-	log.Printf("MNotificationInd.IsLocal() is deprecated, use MNotificationInd.IsDebug() instead")
+	logger.Warnf("MNotificationInd.IsLocal() is deprecated, use MNotificationInd.IsDebug() instead")
 	return mNotificationInd.IsDebug()
 }
 
@@ -324,7 +444,7 @@ func (mNotificationInd *MNotificationInd) PopDebugError(name string) error {
 	}
 	uri, err := url.ParseRequestURI(mNotificationInd.ContentLocation)
 	if err != nil {
-		log.Printf("Parsing ContentLocation \"%s\" as URI error: %s", mNotificationInd.ContentLocation, err)
+		logger.Errorf("Parsing ContentLocation \"%s\" as URI error: %s", mNotificationInd.ContentLocation, err)
 		return nil
 	}
 	values := uri.Query()
@@ -339,7 +459,7 @@ func (mNotificationInd *MNotificationInd) PopDebugError(name string) error {
 	}
 	ui64, err := strconv.ParseUint(value, 10, 64)
 	if err != nil {
-		log.Printf("Parsing ContentLocation query %s value %s as uint64 error: %s", name, value, err)
+		logger.Errorf("Parsing ContentLocation query %s value %s as uint64 error: %s", name, value, err)
 		values.Del(name)
 		return nil
 	}
@@ -357,6 +477,13 @@ func (mNotificationInd *MNotificationInd) PopDebugError(name string) error {
 	return ForcedDebugError(name)
 }
 
+// IsPriority returns true if the notified message should be retrieved right
+// away, bypassing deferral and queueing. This is the case for high priority
+// messages and automatically generated ones.
+func (mNotificationInd *MNotificationInd) IsPriority() bool {
+	return mNotificationInd.Priority == PriorityHigh || mNotificationInd.Class == ClassAuto
+}
+
 // Default expire duration is 15 days.
 const ExpiryDefaultDuration = 15 * 24 * time.Hour
 
@@ -378,6 +505,8 @@ func (mNotificationInd *MNotificationInd) Expire() time.Time {
 
 // Expiry returns if MNotificationInd is expired at the time of calling this function.
 // If both Received and Expiry fields are empty/zero, function returns false.
+// The time is measured from Received with a monotonic clock when possible, so
+// changes to the wall clock don't expire the notification.
 func (mNotificationInd *MNotificationInd) Expired() bool {
 	if mNotificationInd == nil {
 		return false
@@ -386,7 +515,7 @@ func (mNotificationInd *MNotificationInd) Expired() bool {
 	if expire.IsZero() {
 		return false
 	}
-	return time.Now().After(expire)
+	return mNotificationInd.now().After(expire)
 }
 
 func (mNotificationInd *MNotificationInd) NewMNotifyRespInd(status byte, deliveryReport bool) *MNotifyRespInd {
@@ -437,7 +566,21 @@ var ErrTransient = errors.New("Error-transient-failure")
 var ErrPermanent = errors.New("Error-permament-failure")
 
 func (mSendConf *MSendConf) Status() error {
-	s := mSendConf.ResponseStatus
+	return responseStatusClass(mSendConf.ResponseStatus)
+}
+
+// ResponseError returns an ErrorResponseStatus if the MMS center did not
+// accept the message.
+func (mSendConf *MSendConf) ResponseError() error {
+	if mSendConf.Status() == nil {
+		return nil
+	}
+	return ErrorResponseStatus{Status: mSendConf.ResponseStatus, Text: mSendConf.ResponseText}
+}
+
+// responseStatusClass returns ErrTransient or ErrPermanent for the failure
+// the response status s reports, nil if it reports success.
+func responseStatusClass(s byte) error {
 	// these are case by case Response Status and we need to determine each one
 	switch s {
 	case ResponseStatusOk:
@@ -515,7 +658,7 @@ func processAttachments(a []*Attachment) (oa []*Attachment, smilStart, smilType
 			var err error
 			smilStart, err = getSmilStart(a[i].Data)
 			if err != nil {
-				log.Println("Cannot set content type start:", err)
+				logger.Error("Cannot set content type start: ", err)
 			}
 			smilType = "application/smil"
 		} else {
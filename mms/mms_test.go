@@ -229,3 +229,152 @@ func TestMNotificationInd_PopDebugError(t *testing.T) {
 		})
 	}
 }
+
+func TestMNotificationInd_IsPriority(t *testing.T) {
+	testCases := []struct {
+		name string
+		mni  *MNotificationInd
+		want bool
+	}{
+		{"empty", &MNotificationInd{}, false},
+		{"personal", &MNotificationInd{Class: ClassPersonal, Priority: PriorityNormal}, false},
+		{"low", &MNotificationInd{Class: ClassPersonal, Priority: PriorityLow}, false},
+		{"high", &MNotificationInd{Class: ClassPersonal, Priority: PriorityHigh}, true},
+		{"auto", &MNotificationInd{Class: ClassAuto}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.mni.IsPriority(); got != tc.want {
+				t.Errorf("IsPriority() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	testCases := []struct {
+		name       string
+		time       time.Time
+		wantEpoch  int64
+		wantString string
+	}{
+		{"zero", time.Time{}, 0, "0001-01-01T00:00:00Z"},
+		{"utc", time.Date(2004, 1, 30, 6, 49, 53, 0, time.UTC), 1075445393, "2004-01-30T06:49:53Z"},
+		{"offset", time.Date(2004, 1, 30, 3, 49, 53, 0, time.FixedZone("", -3*60*60)), 1075445393, "2004-01-30T06:49:53Z"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			epoch := Epoch(tc.time)
+			if epoch != tc.wantEpoch {
+				t.Errorf("Epoch(%v) = %v, want %v", tc.time, epoch, tc.wantEpoch)
+			}
+			if got := EpochTime(epoch); !got.Equal(tc.time) || got.Location() != time.UTC {
+				t.Errorf("EpochTime(%v) = %v, want %v in UTC", epoch, got, tc.time)
+			}
+			if got := FormatEpoch(epoch); got != tc.wantString {
+				t.Errorf("FormatEpoch(%v) = %q, want %q", epoch, got, tc.wantString)
+			}
+		})
+	}
+}
+
+func TestMNotificationInd_ExpiredMonotonic(t *testing.T) {
+	defer func(clock func() (string, time.Duration, error)) { monotonicClock = clock }(monotonicClock)
+	boot, uptime := "boot-1", time.Hour
+	monotonicClock = func() (string, time.Duration, error) { return boot, uptime, nil }
+
+	now := time.Now()
+	testCases := []struct {
+		name        string
+		received    time.Time
+		boot        string
+		elapsed     time.Duration
+		wantExpired bool
+	}{
+		{"wall clock jumped forward", now.Add(-ExpiryDefaultDuration - time.Hour), "boot-1", time.Minute, false},
+		{"elapsed since receipt", now, "boot-1", ExpiryDefaultDuration + time.Minute, true},
+		{"wall clock expired after reboot", now.Add(-ExpiryDefaultDuration - time.Minute), "boot-2", 0, true},
+		{"wall clock jumped backwards after reboot", now.Add(time.Minute), "boot-2", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			boot, uptime = "boot-1", time.Hour
+			// Moving Received away from the reference taken at receipt is
+			// the same as the wall clock jumping since.
+			mNotificationInd := NewMNotificationInd(now)
+			mNotificationInd.Received = tc.received.UTC()
+			if mNotificationInd.ReceivedBoot != "boot-1" {
+				t.Fatalf("ReceivedBoot = %q, want %q", mNotificationInd.ReceivedBoot, "boot-1")
+			}
+			boot, uptime = tc.boot, mNotificationInd.ReceivedUptime+tc.elapsed
+			if got := mNotificationInd.Expired(); got != tc.wantExpired {
+				t.Errorf("Expired() = %v, want %v", got, tc.wantExpired)
+			}
+		})
+	}
+}
+
+func TestMRetrieveConf_Summary(t *testing.T) {
+	smil := `<smil><body><par><img src="cat.jpg"/><text src="text_1.txt"/></par><par><text src="cid:second"/><video src="clip.3gp"/></par></body></smil>`
+	testCases := []struct {
+		name string
+		pdu  *MRetrieveConf
+		want string
+	}{
+		{"empty", &MRetrieveConf{}, ""},
+		{"subject-only", &MRetrieveConf{Subject: " Hello "}, "Hello"},
+		{
+			"smil-order",
+			&MRetrieveConf{
+				Subject: "Holidays",
+				Attachments: []Attachment{
+					{MediaType: "application/smil", Data: []byte(smil)},
+					{MediaType: "text/plain", ContentId: "<second>", Data: []byte("Second")},
+					{MediaType: "video/3gpp", ContentLocation: "clip.3gp"},
+					{MediaType: "text/plain;charset=utf-8", ContentLocation: "text_1.txt", Data: []byte("First")},
+					{MediaType: "image/jpeg", ContentLocation: "cat.jpg"},
+					{MediaType: "image/png", ContentLocation: "unreferenced.png"},
+				},
+			},
+			"Holidays\nFirst\nSecond\n2 images, 1 video",
+		},
+		{
+			"no-smil",
+			&MRetrieveConf{
+				Attachments: []Attachment{
+					{MediaType: "audio/amr"},
+					{MediaType: "text/plain", Data: []byte("One")},
+					{MediaType: "text/x-vcard"},
+					{MediaType: "text/plain", Data: []byte("Two")},
+				},
+			},
+			"One\nTwo\n1 audio clip, 1 attachment",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.pdu.Summary(); got != tc.want {
+				t.Errorf("MRetrieveConf.Summary() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func (s *MMSTestSuite) TestMSendReqRecipients(c *C) {
+	mSendReq := NewMSendReq([]string{"+11111"}, []*Attachment{}, false)
+	mSendReq.AddCopyRecipients([]string{"+22222"}, []string{"+33333"})
+	c.Check(mSendReq.To, DeepEquals, []string{"+11111/TYPE=PLMN"})
+	c.Check(mSendReq.Cc, DeepEquals, []string{"+22222/TYPE=PLMN"})
+	c.Check(mSendReq.Bcc, DeepEquals, []string{"+33333/TYPE=PLMN"})
+	all := []string{"+11111/TYPE=PLMN", "+22222/TYPE=PLMN", "+33333/TYPE=PLMN"}
+	c.Check(mSendReq.Recipients(), DeepEquals, all)
+
+	mSendReq.Ungroup()
+	c.Check(mSendReq.To, HasLen, 0)
+	c.Check(mSendReq.Cc, HasLen, 0)
+	c.Check(mSendReq.Bcc, DeepEquals, all)
+	c.Check(mSendReq.Recipients(), DeepEquals, all)
+}
@@ -138,6 +138,9 @@ var CONTENT_TYPES []string = []string{
 var CHARSETS map[uint64]string = map[uint64]string{
 	0x07EA: "big5",
 	0x03E8: "iso-10646-ucs-2",
+	0x03F5: "utf-16be",
+	0x03F6: "utf-16le",
+	0x03F7: "utf-16",
 	0x04:   "iso-8859-1",
 	0x05:   "iso-8859-2",
 	0x06:   "iso-8859-3",
@@ -147,6 +150,8 @@ var CHARSETS map[uint64]string = map[uint64]string{
 	0x0A:   "iso-8859-7",
 	0x0B:   "iso-8859-8",
 	0x0C:   "iso-8859-9",
+	0x6F:   "iso-8859-15",
+	0x08CC: "windows-1252",
 	0x11:   "shift_JIS",
 	0x03:   "us-ascii",
 	0x6A:   "utf-8",
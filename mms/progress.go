@@ -0,0 +1,82 @@
+package mms
+
+import (
+	"io"
+	"net/http"
+)
+
+// Progress is told how many bytes of a download or upload were transferred
+// so far out of total, which is 0 if it is not known.
+type Progress func(transferred, total uint64)
+
+// progressStep is how many bytes a transfer of unknown size makes between
+// reports, a transfer of known size is reported for every percent.
+const progressStep = 64 * 1024
+
+// report tells the progress of a transfer through p, if it is followed,
+// with the expected size standing in for an unknown total.
+func (p Proxy) report(transferred, total uint64) {
+	if p.Progress == nil {
+		return
+	}
+	if total == 0 {
+		total = p.ExpectedSize
+	}
+	p.Progress(transferred, total)
+}
+
+// progressReader reports the bytes read from r through proxy, starting at
+// transferred for a resumed download. Reports are throttled to steps of
+// progressStep bytes or a percent of total, and the last read is always
+// reported.
+type progressReader struct {
+	r                     io.Reader
+	proxy                 Proxy
+	transferred, reported uint64
+	total                 uint64
+}
+
+func newProgressReader(r io.Reader, proxy Proxy, transferred, total uint64) io.Reader {
+	if proxy.Progress == nil {
+		return r
+	}
+	if total == 0 {
+		total = proxy.ExpectedSize
+	}
+	proxy.report(transferred, total)
+	return &progressReader{r: r, proxy: proxy, transferred: transferred, reported: transferred, total: total}
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.transferred += uint64(n)
+	step := uint64(progressStep)
+	if pr.total > 0 {
+		step = pr.total / 100
+	}
+	if pr.transferred != pr.reported && (err != nil || pr.transferred-pr.reported >= step) {
+		pr.reported = pr.transferred
+		pr.proxy.report(pr.transferred, pr.total)
+	}
+	return n, err
+}
+
+// progressBody wraps the body of resp, the answer to a download through
+// proxy, to report its progress. The part of a resumed download which was
+// kept counts as transferred.
+func progressBody(resp *http.Response, proxy Proxy) io.ReadCloser {
+	if proxy.Progress == nil {
+		return resp.Body
+	}
+	var start, total uint64
+	if offset, ok := contentRangeStart(resp.Header.Get("Content-Range")); ok && resp.StatusCode == http.StatusPartialContent {
+		start = uint64(offset)
+	}
+	if resp.ContentLength >= 0 {
+		total = start + uint64(resp.ContentLength)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{newProgressReader(resp.Body, proxy, start, total), resp.Body}
+}
@@ -0,0 +1,77 @@
+package mms
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProgressReader(t *testing.T) {
+	var reports [][2]uint64
+	proxy := Proxy{Progress: func(transferred, total uint64) {
+		reports = append(reports, [2]uint64{transferred, total})
+	}}
+	r := newProgressReader(iotest.OneByteReader(bytes.NewReader(make([]byte, 1000))), proxy, 0, 1000)
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 101 {
+		t.Errorf("got %d reports, want one at the start and one per percent", len(reports))
+	}
+	for i, report := range reports {
+		if report[0] != uint64(i*10) || report[1] != 1000 {
+			t.Errorf("report %d = %v, want [%d 1000]", i, report, i*10)
+		}
+	}
+}
+
+func TestProgressReaderExpectedSize(t *testing.T) {
+	var last [2]uint64
+	proxy := Proxy{ExpectedSize: 4096, Progress: func(transferred, total uint64) {
+		last = [2]uint64{transferred, total}
+	}}
+	if _, err := ioutil.ReadAll(newProgressReader(bytes.NewReader(make([]byte, 100)), proxy, 50, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if want := [2]uint64{150, 4096}; last != want {
+		t.Errorf("last report = %v, want %v", last, want)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	var contentLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte("m-send.conf"))
+	}))
+	defer server.Close()
+	f, err := ioutil.TempFile("", "m-send.req")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(make([]byte, 300*1024))
+	f.Close()
+
+	var last [2]uint64
+	proxy := Proxy{UserAgent: "ExampleMMS/1.0", Progress: func(transferred, total uint64) {
+		last = [2]uint64{transferred, total}
+	}}
+	filePath, err := Upload(context.Background(), f.Name(), server.URL, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filePath)
+	if contentLength != 300*1024 {
+		t.Errorf("MMSC got Content-Length %d, want %d", contentLength, 300*1024)
+	}
+	if want := [2]uint64{300 * 1024, 300 * 1024}; last != want {
+		t.Errorf("last report = %v, want %v", last, want)
+	}
+}
@@ -0,0 +1,362 @@
+package mms
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// Proxy is the MMS proxy transactions go through, Host is empty if there is
+// none. Username and Password are set for proxies which require
+// authentication. Interface is the network interface of the MMS context
+// transactions are bound to, empty to use the routing table. UserAgent and
+// UAProf are sent as User-Agent and X-Wap-Profile headers, for MMSCs which
+// adapt content to the device, see DefaultUserAgent; UAProfHeader replaces
+// the name of the latter, as is. CACertificates and
+// CertificatePins verify MMSCs with https URLs, see tlsConfig. Progress, if
+// set, follows the transfer, whose total is ExpectedSize when the MMSC does
+// not tell it, e.g. the X-Mms-Message-Size of a notified message. Redirects
+// tells which redirects of a download are followed. DNSServers, if set,
+// resolve host names instead of the system resolver.
+type Proxy struct {
+	Host            string
+	Port            int32
+	Username        string
+	Password        string
+	Interface       string
+	UserAgent       string
+	UAProf          string
+	UAProfHeader    string
+	CACertificates  string
+	CertificatePins []string
+	Progress        Progress
+	ExpectedSize    uint64
+	Redirects       RedirectPolicy
+	DNSServers      []string
+}
+
+func (p Proxy) String() string {
+	hostPort := net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port)))
+	if p.Username != "" {
+		return p.Username + "@" + hostPort
+	}
+	return hostPort
+}
+
+// authenticated returns true if transactions through p need to authenticate.
+func (p Proxy) authenticated() bool {
+	return p.Host != "" && p.Username != ""
+}
+
+// identified returns true if transactions through p send device headers.
+func (p Proxy) identified() bool {
+	return p.UserAgent != "" || p.UAProf != ""
+}
+
+// DefaultUserAgent is the User-Agent sent by nuntium's own HTTP client when
+// no other one is configured.
+const DefaultUserAgent = "nuntium/1.0 (Linux; Ubuntu Touch)"
+
+// userAgent returns the User-Agent to send through p.
+func (p Proxy) userAgent() string {
+	if p.UserAgent != "" {
+		return p.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+// transferPath is where transfers through an authenticated proxy are stored,
+// relative to the XDG cache directory.
+var transferPath = filepath.Join("nuntium", "transfers")
+
+// mmsContentType is the content type of encoded PDUs.
+const mmsContentType = "application/vnd.wap.mms-message"
+
+// proxyTransfer performs a GET of rawURL, or a POST of the PDU in file if it
+// is not empty, through proxy and returns the path of the file holding the
+// response body, which must be a PDU for a GET. The download manager can
+// neither authenticate against a proxy, send device headers, verify an MMSC
+// with other certificates than the system ones nor resolve with other DNS
+// servers, so this uses its own HTTP client. Basic credentials are sent up
+// front, a Digest challenge is answered once per location. A GET follows
+// redirects as proxy allows, see redirectTarget.
+func proxyTransfer(ctx context.Context, rawURL, file string, proxy Proxy, timeout *int64) (string, error) {
+	connect, read := timeouts(timeout)
+	tlsConfig, err := proxy.tlsConfig()
+	if err != nil {
+		return "", err
+	}
+	transport := &http.Transport{
+		DialContext:           newDialer(connect, proxy.Interface, proxy.DNSServers).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connect,
+		ResponseHeaderTimeout: read,
+	}
+	if proxy.Host != "" {
+		transport.Proxy = http.ProxyURL(&url.URL{Scheme: "http", Host: net.JoinHostPort(proxy.Host, strconv.Itoa(int(proxy.Port)))})
+	}
+	client := &http.Client{
+		Transport: transport,
+		// Redirects are followed by proxyTransfer, which checks them and
+		// answers the proxy for every location.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	logger.Info("Starting transfer of ", rawURL, " with proxy ", proxy)
+	var authorization string
+	if proxy.authenticated() {
+		authorization = basicAuthorization(proxy)
+	}
+	var partial *os.File
+	var offset int64
+	if file == "" && resumingDownloads() {
+		var err error
+		if partial, offset, err = openPartial(rawURL); err != nil {
+			logger.Error("Cannot keep the download to resume it: ", err)
+		} else {
+			defer partial.Close()
+		}
+	}
+	location := rawURL
+	visited := map[string]bool{rawURL: true}
+	challenged := false
+	for {
+		resp, err := proxyRequest(ctx, client, location, file, proxy, authorization, offset)
+		if err != nil {
+			return "", err
+		}
+		if file == "" && redirected(resp.StatusCode) {
+			resp.Body.Close()
+			if location, err = proxy.redirectTarget(location, resp.Header.Get("Location"), visited); err != nil {
+				return "", err
+			}
+			logger.Info("Following redirect to ", location)
+			// A Digest answer is only valid for the location it was
+			// computed for.
+			if challenged {
+				authorization, challenged = basicAuthorization(proxy), false
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+			// The partial download is stale, start over.
+			resp.Body.Close()
+			offset = 0
+			continue
+		}
+		if resp.StatusCode == http.StatusProxyAuthRequired && !challenged && authorization != "" {
+			challenge := resp.Header.Get("Proxy-Authenticate")
+			resp.Body.Close()
+			if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+				return "", fmt.Errorf("proxy %s rejected the credentials", proxy)
+			}
+			if authorization, err = digestAuthorization(challenge, proxyMethod(file), location, proxy, newCnonce()); err != nil {
+				return "", err
+			}
+			challenged = true
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return "", fmt.Errorf("proxy %s rejected the credentials", proxy)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return "", fmt.Errorf("transfer of %s failed with HTTP status %s", location, resp.Status)
+		}
+		if file == "" {
+			resp.Body = progressBody(resp, proxy)
+		}
+		var filePath string
+		if partial != nil {
+			filePath, err = storePartial(partial, offset, resp)
+			if err != nil && ctx.Err() != nil {
+				os.Remove(partial.Name())
+				return "", ctx.Err()
+			}
+		} else {
+			filePath, err = storeTransfer(resp.Body)
+		}
+		if err != nil || file != "" {
+			return filePath, err
+		}
+		if err := checkPDU(filePath, resp.Header.Get("Content-Type")); err != nil {
+			os.Remove(filePath)
+			return "", err
+		}
+		return filePath, nil
+	}
+}
+
+func proxyMethod(file string) string {
+	if file == "" {
+		return http.MethodGet
+	}
+	return http.MethodPost
+}
+
+// proxyRequest sends a single request with the device headers of proxy and
+// the Proxy-Authorization header set to authorization, unless it is empty. A
+// GET asks for the content from offset on if it is not 0.
+func proxyRequest(ctx context.Context, client *http.Client, rawURL, file string, proxy Proxy, authorization string, offset int64) (*http.Response, error) {
+	var body io.Reader
+	var size int64
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		size = fi.Size()
+		body = newProgressReader(f, proxy, 0, uint64(size))
+	}
+	req, err := http.NewRequest(proxyMethod(file), rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	// The progress reader hides the size of the file from NewRequest.
+	req.ContentLength = size
+	req.Header.Set("Accept", mmsContentType)
+	if file != "" {
+		req.Header.Set("Content-Type", mmsContentType)
+	}
+	req.Header.Set("User-Agent", proxy.userAgent())
+	if proxy.UAProf != "" && proxy.UAProfHeader != "" {
+		// Not canonicalized, for MMSCs which only take their own casing.
+		req.Header[proxy.UAProfHeader] = []string{proxy.UAProf}
+	} else if proxy.UAProf != "" {
+		req.Header.Set("X-Wap-Profile", proxy.UAProf)
+	}
+	if authorization != "" {
+		req.Header.Set("Proxy-Authorization", authorization)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil && tlsFailure(err) {
+		return nil, ErrorTLS{err}
+	}
+	return resp, err
+}
+
+// storeTransfer saves body to a new file in the transfer directory and
+// returns its path.
+func storeTransfer(body io.Reader) (string, error) {
+	dir, err := xdg.Cache.Ensure(filepath.Join(transferPath, ".keep"))
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dir), "transfer-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, body); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func basicAuthorization(proxy Proxy) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.Username+":"+proxy.Password))
+}
+
+// parseChallenge returns the parameters of a Digest challenge.
+func parseChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+	challenge = strings.TrimSpace(challenge)
+	if i := strings.IndexByte(challenge, ' '); i >= 0 {
+		challenge = challenge[i+1:]
+	}
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, " ,")
+		eq := strings.IndexByte(challenge, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(challenge[:eq]))
+		challenge = challenge[eq+1:]
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := strings.IndexByte(challenge[1:], '"')
+			if end < 0 {
+				value, challenge = challenge[1:], ""
+			} else {
+				value, challenge = challenge[1:end+1], challenge[end+2:]
+			}
+		} else if end := strings.IndexByte(challenge, ','); end >= 0 {
+			value, challenge = challenge[:end], challenge[end:]
+		} else {
+			value, challenge = challenge, ""
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+// digestAuthorization answers the Digest challenge for a request of uri with
+// method, as in RFC 2617. Only the MD5 algorithm is supported.
+func digestAuthorization(challenge, method, uri string, proxy Proxy, cnonce string) (string, error) {
+	params := parseChallenge(challenge)
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported proxy digest algorithm %s", algorithm)
+	}
+	ha1 := md5Hex(proxy.Username + ":" + params["realm"] + ":" + proxy.Password)
+	ha2 := md5Hex(method + ":" + uri)
+	var response, qop string
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	const nc = "00000001"
+	if qop != "" {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	}
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		proxy.Username, params["realm"], params["nonce"], uri, response)
+	if qop != "" {
+		authorization += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return authorization, nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func newCnonce() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
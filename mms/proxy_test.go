@@ -0,0 +1,166 @@
+package mms
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDigestAuthorization(t *testing.T) {
+	// The example of RFC 2617, section 3.5.
+	challenge := `Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`
+	proxy := Proxy{Host: "10.0.0.1", Port: 80, Username: "Mufasa", Password: "Circle Of Life"}
+	authorization, err := digestAuthorization(challenge, "GET", "/dir/index.html", proxy, "0a4f113b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`username="Mufasa"`,
+		`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`,
+		`response="6629fae49393a05397450978507c4ef1"`,
+		`qop=auth, nc=00000001, cnonce="0a4f113b"`,
+		`opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+	} {
+		if !strings.Contains(authorization, want) {
+			t.Errorf("authorization %q lacks %s", authorization, want)
+		}
+	}
+
+	if _, err := digestAuthorization(`Digest realm="r", nonce="n", algorithm=SHA-256`, "GET", "/", proxy, "c"); err == nil {
+		t.Error("unsupported algorithm was accepted")
+	}
+}
+
+func TestBasicAuthorization(t *testing.T) {
+	if got := basicAuthorization(Proxy{Username: "Aladdin", Password: "open sesame"}); got != "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" {
+		t.Errorf("basicAuthorization = %q", got)
+	}
+}
+
+// retrieveConf is the start of a m-retrieve.conf.
+var retrieveConf = []byte{0x80 + X_MMS_MESSAGE_TYPE, TYPE_RETRIEVE_CONF, 0x80 + X_MMS_MMS_VERSION, MMS_MESSAGE_VERSION_1_2}
+
+func TestProxyTransferIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback: ", err)
+	}
+	var requested string
+	server := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Proxy-Authorization") != basicAuthorization(Proxy{Username: "user", Password: "secret"}) {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+			requested = r.URL.String()
+			w.Write(retrieveConf)
+		})},
+	}
+	server.Start()
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	proxy := Proxy{Host: "::1", Port: int32(p), Username: "user", Password: "secret"}
+	mmsc := "http://[2001:db8::1]:8002/mms/1"
+	filePath, err := (&MNotificationInd{ContentLocation: mmsc}).DownloadContent(context.Background(), proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filePath)
+	if requested != mmsc {
+		t.Errorf("proxy got request for %q, want %q", requested, mmsc)
+	}
+	if data, err := ioutil.ReadFile(filePath); err != nil || string(data) != string(retrieveConf) {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+}
+
+func TestTransferDeviceHeaders(t *testing.T) {
+	var userAgent, profile, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent, profile = r.Header.Get("User-Agent"), r.Header.Get("X-Wap-Profile")
+		authorization = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("m-send.conf"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		proxy         Proxy
+		wantUserAgent string
+	}{
+		{Proxy{UAProf: "http://example.com/uaprof.xml"}, DefaultUserAgent},
+		{Proxy{UserAgent: "ExampleMMS/1.0", UAProf: "http://example.com/uaprof.xml"}, "ExampleMMS/1.0"},
+	}
+	for _, tc := range testCases {
+		filePath, err := Upload(context.Background(), "/dev/null", server.URL, tc.proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filePath)
+		if userAgent != tc.wantUserAgent || profile != tc.proxy.UAProf {
+			t.Errorf("MMSC got User-Agent %q and X-Wap-Profile %q, want %q and %q", userAgent, profile, tc.wantUserAgent, tc.proxy.UAProf)
+		}
+		if authorization != "" {
+			t.Errorf("MMSC got Proxy-Authorization %q without proxy", authorization)
+		}
+	}
+}
+
+func TestTransferUAProfHeader(t *testing.T) {
+	var profile, wapProfile string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile, wapProfile = r.Header.Get("Profile"), r.Header.Get("X-Wap-Profile")
+		w.Write([]byte("m-send.conf"))
+	}))
+	defer server.Close()
+
+	proxy := Proxy{UAProf: "http://example.com/uaprof.xml", UAProfHeader: "Profile"}
+	filePath, err := Upload(context.Background(), "/dev/null", server.URL, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filePath)
+	if profile != proxy.UAProf || wapProfile != "" {
+		t.Errorf("MMSC got Profile %q and X-Wap-Profile %q, want %q and none", profile, wapProfile, proxy.UAProf)
+	}
+}
+
+func TestDownloadUnexpectedContent(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        []byte
+		want        error
+	}{
+		{"application/vnd.wap.mms-message", retrieveConf, nil},
+		// A PDU with a wrong Content-Type is still one.
+		{"application/octet-stream", retrieveConf, nil},
+		{"text/html; charset=utf-8", []byte("<html><body>Top up your plan</body></html>"), ErrorUnexpectedContent{"text/html"}},
+		{"", []byte("<!DOCTYPE html><html></html>"), ErrorUnexpectedContent{"text/html"}},
+		{"application/vnd.wap.mms-message", nil, ErrorUnexpectedContent{}},
+	}
+	for _, tc := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header()["Content-Type"] = []string{tc.contentType}
+			w.Write(tc.body)
+		}))
+		pdu := &MNotificationInd{ContentLocation: server.URL}
+		filePath, err := pdu.DownloadContent(context.Background(), Proxy{UAProf: "http://example.com/uaprof.xml"})
+		server.Close()
+		if err != tc.want {
+			t.Errorf("download of %q as %q error = %v, want %v", tc.body, tc.contentType, err, tc.want)
+		}
+		if err == nil {
+			os.Remove(filePath)
+		} else if filePath != "" {
+			t.Errorf("failed download left %s", filePath)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mms
+
+import "time"
+
+// Read status defined in OMA-WAP-MMS section 7.2.29
+const (
+	ReadStatusRead               byte = 128
+	ReadStatusDeletedWithoutRead byte = 129
+)
+
+// MReadOrigInd holds a m-read-orig.ind message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.7.2. The MMSC pushes it when a recipient of
+// a message sent with a read report requested read or deleted it.
+type MReadOrigInd struct {
+	MMSReader
+	Type, Version byte
+	MessageId     string
+	To            []string
+	From          string
+	Date          uint64
+	ReadStatus    byte
+}
+
+func NewMReadOrigInd() *MReadOrigInd {
+	return &MReadOrigInd{Type: TYPE_READ_ORIG_IND}
+}
+
+// MReadRecInd holds a m-read-rec.ind message defined in
+// OMA-WAP-MMS-ENC-v1.2 section 6.7.1. It is sent to the MMSC to report that
+// a received message, which requested a read report, was read.
+type MReadRecInd struct {
+	UUID       string `encode:"no"`
+	Type       byte
+	Version    byte
+	MessageId  string
+	To         []string
+	From       string
+	Date       uint64 `encode:"optional"`
+	ReadStatus byte
+}
+
+// NewMReadRecInd creates the read report of mRetrieveConf for its sender.
+func (mRetrieveConf *MRetrieveConf) NewMReadRecInd(date time.Time) *MReadRecInd {
+	return &MReadRecInd{
+		UUID:       mRetrieveConf.UUID,
+		Type:       TYPE_READ_REC_IND,
+		Version:    mRetrieveConf.Version,
+		MessageId:  mRetrieveConf.MessageId,
+		To:         []string{mRetrieveConf.From},
+		Date:       uint64(date.Unix()),
+		ReadStatus: ReadStatusRead,
+	}
+}
+
+// ReadReportRequested returns true if the sender asked for a read report.
+func (mRetrieveConf *MRetrieveConf) ReadReportRequested() bool {
+	return mRetrieveConf.ReadReport == ReadReportYes
+}
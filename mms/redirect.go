@@ -0,0 +1,80 @@
+package mms
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectPolicy tells which redirects of the MMSC a download follows.
+type RedirectPolicy string
+
+const (
+	// RedirectDefault leaves redirects to the download manager, nuntium's
+	// own HTTP client follows them like RedirectSameHost.
+	RedirectDefault RedirectPolicy = ""
+	// RedirectNone follows no redirect.
+	RedirectNone RedirectPolicy = "none"
+	// RedirectSameHost follows redirects to the host of the content
+	// location only.
+	RedirectSameHost RedirectPolicy = "same-host"
+	// RedirectAny follows redirects to any host.
+	RedirectAny RedirectPolicy = "any"
+)
+
+// MaxRedirects is the number of redirects a download follows at most.
+const MaxRedirects = 5
+
+// ErrorRedirectLoop is the error of a download which the MMSC redirected to a
+// location it was redirected from before, or more than MaxRedirects times.
+type ErrorRedirectLoop struct {
+	URL string
+}
+
+func (e ErrorRedirectLoop) Error() string {
+	return "redirect loop downloading " + e.URL
+}
+
+// redirected returns true if status is one of the redirects a download
+// follows.
+func redirected(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectTarget returns the URL the download of from is redirected to with
+// location, if p allows following it. visited holds the URLs of the download
+// so far, the target is added to it.
+func (p Proxy) redirectTarget(from, location string, visited map[string]bool) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("redirect of %s has no location", from)
+	}
+	base, err := url.Parse(from)
+	if err != nil {
+		return "", err
+	}
+	target, err := base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("redirect of %s to invalid location %q: %w", from, location, err)
+	}
+	to := target.String()
+	if visited[to] || len(visited) > MaxRedirects {
+		return "", ErrorRedirectLoop{URL: to}
+	}
+	switch {
+	case p.Redirects == RedirectNone:
+		return "", fmt.Errorf("redirect of %s to %s is not followed", from, to)
+	case target.Scheme != "http" && target.Scheme != "https":
+		return "", fmt.Errorf("redirect of %s to unsupported location %s", from, to)
+	case base.Scheme == "https" && target.Scheme != "https":
+		return "", fmt.Errorf("redirect of %s to insecure location %s", from, to)
+	case p.Redirects != RedirectAny && !strings.EqualFold(target.Hostname(), base.Hostname()):
+		return "", fmt.Errorf("redirect of %s to another host %s is not followed", from, to)
+	}
+	visited[to] = true
+	return to, nil
+}
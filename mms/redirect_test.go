@@ -0,0 +1,84 @@
+package mms
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDownloadRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/found", http.StatusMovedPermanently)
+		case "/found":
+			http.Redirect(w, r, "/mms/1", http.StatusSeeOther)
+		case "/loop":
+			http.Redirect(w, r, "/loop2", http.StatusFound)
+		case "/loop2":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, "http://mmsc.invalid/mms/1", http.StatusFound)
+		case "/mms/1":
+			w.Header().Set("Content-Type", mmsContentType)
+			w.Write(retrieveConf)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path      string
+		redirects RedirectPolicy
+		ok        bool
+		loop      bool
+	}{
+		{"/moved", RedirectSameHost, true, false},
+		{"/moved", RedirectAny, true, false},
+		{"/moved", RedirectNone, false, false},
+		{"/loop", RedirectSameHost, false, true},
+		{"/away", RedirectSameHost, false, false},
+	}
+	for _, tc := range testCases {
+		pdu := &MNotificationInd{ContentLocation: server.URL + tc.path}
+		filePath, err := pdu.DownloadContent(context.Background(), Proxy{Redirects: tc.redirects})
+		if _, loop := err.(ErrorRedirectLoop); loop != tc.loop {
+			t.Errorf("download of %s with %q redirects failed with %v, want a loop: %v", tc.path, tc.redirects, err, tc.loop)
+		}
+		if !tc.ok {
+			if err == nil {
+				os.Remove(filePath)
+				t.Errorf("download of %s with %q redirects succeeded", tc.path, tc.redirects)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("download of %s with %q redirects failed: %v", tc.path, tc.redirects, err)
+			continue
+		}
+		if data, err := ioutil.ReadFile(filePath); err != nil || string(data) != string(retrieveConf) {
+			t.Errorf("download of %s with %q redirects got %q, %v", tc.path, tc.redirects, data, err)
+		}
+		os.Remove(filePath)
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	visited := map[string]bool{"https://mmsc/1": true}
+	if _, err := (Proxy{}).redirectTarget("https://mmsc/1", "http://mmsc/2", visited); err == nil {
+		t.Error("redirect from https to http was followed")
+	}
+	from := "https://mmsc/1"
+	for i := 2; i <= MaxRedirects+1; i++ {
+		to, err := (Proxy{}).redirectTarget(from, "/"+string(rune('0'+i)), visited)
+		if err != nil {
+			t.Fatalf("redirect %d failed: %v", i-1, err)
+		}
+		from = to
+	}
+	if _, err := (Proxy{}).redirectTarget(from, "/x", visited); err != (ErrorRedirectLoop{URL: "https://mmsc/x"}) {
+		t.Errorf("redirect %d failed with %v, want a loop", MaxRedirects+1, err)
+	}
+}
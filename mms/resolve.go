@@ -0,0 +1,62 @@
+package mms
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// resolveTimeout bounds the lookup of Resolve.
+const resolveTimeout = 10 * time.Second
+
+// Resolution is how the host a transaction connects to resolves, for error
+// reports.
+type Resolution struct {
+	Host string
+	// Servers are the DNS servers of the MMS context the host was looked
+	// up with, none for the system resolver.
+	Servers   []string
+	Addresses []string
+	Err       error
+}
+
+func (r Resolution) String() string {
+	resolver := "the system resolver"
+	if len(r.Servers) > 0 {
+		resolver = "DNS servers " + strings.Join(r.Servers, ", ")
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%s did not resolve with %s: %v", r.Host, resolver, r.Err)
+	}
+	return fmt.Sprintf("%s resolved to %s with %s", r.Host, strings.Join(r.Addresses, ", "), resolver)
+}
+
+// Resolve looks up the host transactions of rawURL through p connect to, the
+// proxy if there is one, with the resolver they use.
+func (p Proxy) Resolve(ctx context.Context, rawURL string) Resolution {
+	r := Resolution{Host: p.Host}
+	if r.Host == "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		r.Host = u.Hostname()
+	}
+	if ip := net.ParseIP(r.Host); ip != nil {
+		r.Addresses = []string{ip.String()}
+		return r
+	}
+	r.Servers = p.DNSServers
+	resolver := newDialer(resolveTimeout, p.Interface, p.DNSServers).Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	r.Addresses, r.Err = resolver.LookupHost(ctx, r.Host)
+	return r
+}
@@ -0,0 +1,30 @@
+package mms
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	r := (Proxy{Host: "10.0.0.1"}).Resolve(context.Background(), "http://mmsc.example.com/mms")
+	if r.Host != "10.0.0.1" || !reflect.DeepEqual(r.Addresses, []string{"10.0.0.1"}) || r.Err != nil {
+		t.Errorf("resolution of proxy literal = %+v", r)
+	}
+	r = (Proxy{}).Resolve(context.Background(), "http://[2001:db8::0:1]:8002/mms")
+	if r.Host != "2001:db8::0:1" || !reflect.DeepEqual(r.Addresses, []string{"2001:db8::1"}) {
+		t.Errorf("resolution of MMSC literal = %+v", r)
+	}
+	if s := r.String(); s != "2001:db8::0:1 resolved to 2001:db8::1 with the system resolver" {
+		t.Errorf("resolution is described as %q", s)
+	}
+
+	// Nothing answers there.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	r = (Proxy{DNSServers: []string{"127.0.0.9"}}).Resolve(ctx, "http://mmsc.example.com/mms")
+	if r.Host != "mmsc.example.com" || r.Err == nil || !reflect.DeepEqual(r.Servers, []string{"127.0.0.9"}) {
+		t.Errorf("resolution with context DNS servers = %+v", r)
+	}
+}
@@ -0,0 +1,124 @@
+package mms
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// resumeDownloads is 1 if downloads are resumed, see SetResumeDownloads.
+var resumeDownloads int32
+
+// SetResumeDownloads makes downloads go through nuntium's own HTTP client,
+// which keeps the part of a download that broke off and asks for the rest
+// with a Range request on the next attempt.
+func SetResumeDownloads(resume bool) {
+	var v int32
+	if resume {
+		v = 1
+	}
+	atomic.StoreInt32(&resumeDownloads, v)
+}
+
+func resumingDownloads() bool {
+	return atomic.LoadInt32(&resumeDownloads) == 1
+}
+
+// partialMaxAge is how long a partial download is kept to be resumed, older
+// ones are started over.
+const partialMaxAge = 24 * time.Hour
+
+// openPartial opens the partial download of rawURL in the transfer directory
+// and returns it with the offset to resume from, 0 if there is nothing to
+// resume.
+func openPartial(rawURL string) (*os.File, int64, error) {
+	dir, err := xdg.Cache.Ensure(filepath.Join(transferPath, ".keep"))
+	if err != nil {
+		return nil, 0, err
+	}
+	prunePartials(filepath.Dir(dir))
+	sum := sha1.Sum([]byte(rawURL))
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(dir), "partial-"+hex.EncodeToString(sum[:])), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// prunePartials removes the partial downloads in dir which are too old to be
+// resumed, e.g. of messages which expired or were deleted.
+func prunePartials(dir string) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "partial-*"))
+	for _, path := range paths {
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > partialMaxAge {
+			os.Remove(path)
+		}
+	}
+}
+
+// storePartial writes the body of resp, the answer to a request for the rest
+// of a download from offset, to partial. A server which does not support
+// Range requests sends the whole download, which replaces what partial
+// holds. If the body breaks off partial is kept for the next attempt,
+// otherwise the download is moved to a new file in the transfer directory
+// and its path returned.
+func storePartial(partial *os.File, offset int64, resp *http.Response) (string, error) {
+	if offset > 0 {
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); resp.StatusCode == http.StatusPartialContent && ok && start == offset {
+			logger.Infof("Resuming download at byte %d", offset)
+		} else {
+			logger.Info("Download cannot be resumed, starting over")
+			offset = 0
+		}
+	}
+	if err := partial.Truncate(offset); err != nil {
+		return "", err
+	}
+	if _, err := partial.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	if n, err := io.Copy(partial, resp.Body); err != nil {
+		return "", fmt.Errorf("download broke off after %d bytes: %w", offset+n, err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(partial.Name()), "transfer-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := os.Rename(partial.Name(), f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// contentRangeStart returns the first byte of a Content-Range header, e.g.
+// 100 for "bytes 100-999/1000".
+func contentRangeStart(contentRange string) (int64, bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, false
+	}
+	r := strings.TrimPrefix(contentRange, "bytes ")
+	dash := strings.IndexByte(r, '-')
+	if dash < 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(r[:dash], 10, 64)
+	return start, err == nil
+}
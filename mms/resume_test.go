@@ -0,0 +1,86 @@
+package mms
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestContentRangeStart(t *testing.T) {
+	testCases := []struct {
+		contentRange string
+		start        int64
+		ok           bool
+	}{
+		{"bytes 100-999/1000", 100, true},
+		{"bytes 0-9/*", 0, true},
+		{"bytes */1000", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range testCases {
+		if start, ok := contentRangeStart(tc.contentRange); start != tc.start || ok != tc.ok {
+			t.Errorf("contentRangeStart(%q) = %d, %v, want %d, %v", tc.contentRange, start, ok, tc.start, tc.ok)
+		}
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	SetResumeDownloads(true)
+	defer SetResumeDownloads(false)
+
+	content := append(append([]byte{}, retrieveConf...), bytes.Repeat([]byte{'x'}, 1000)...)
+	var ranges []string
+	brokenOff := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("Content-Type", mmsContentType)
+		if !brokenOff {
+			// Break off half way through the first download.
+			brokenOff = true
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.Write(content[:500])
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err != nil {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start:])
+	}))
+	defer server.Close()
+
+	pdu := &MNotificationInd{ContentLocation: server.URL + "/resume"}
+	if _, err := pdu.DownloadContent(context.Background(), Proxy{}); err == nil {
+		t.Fatal("download which broke off succeeded")
+	}
+	filePath, err := pdu.DownloadContent(context.Background(), Proxy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filePath)
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=500-" {
+		t.Errorf("requested ranges %q, want none and bytes=500-", ranges)
+	}
+	if data, err := ioutil.ReadFile(filePath); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("resumed download has %d bytes, %v, want %d", len(data), err, len(content))
+	}
+
+	// Nothing is left to resume.
+	ranges = nil
+	filePath, err = pdu.DownloadContent(context.Background(), Proxy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filePath)
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Errorf("requested ranges %q after a complete download, want none", ranges)
+	}
+}
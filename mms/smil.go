@@ -0,0 +1,109 @@
+package mms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// smilSlideDuration is how long every slide of a generated SMIL is shown.
+const smilSlideDuration = "5000ms"
+
+// smilLayout places visual parts in the upper and text in the lower part of
+// the screen, the layout most handsets use.
+const smilLayout = `<head><layout>` +
+	`<root-layout width="100%" height="100%"/>` +
+	`<region id="Image" width="100%" height="80%" left="0" top="0" fit="meet"/>` +
+	`<region id="Text" width="100%" height="20%" left="0" top="80%" fit="scroll"/>` +
+	`</layout></head>`
+
+// HasSmil returns true if one of attachments is a SMIL presentation.
+func HasSmil(attachments []*Attachment) bool {
+	for i := range attachments {
+		if strings.HasPrefix(attachments[i].MediaType, "application/smil") {
+			return true
+		}
+	}
+	return false
+}
+
+// smilElement returns the SMIL element presenting a part of mediaType and
+// the region it is shown in, if any.
+func smilElement(mediaType string) (element, region string) {
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return "img", "Image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video", "Image"
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "audio", ""
+	case strings.HasPrefix(mediaType, "text/plain"):
+		return "text", "Text"
+	}
+	return "ref", ""
+}
+
+// NewSmil builds a SMIL presentation of attachments, referencing them by
+// their Content-ID. The parts are shown in order, a slide holds at most one
+// text part and one image, video, audio or other part.
+func NewSmil(attachments []*Attachment) *Attachment {
+	var body bytes.Buffer
+	var slide []string
+	hasMedia, hasText := false, false
+	endSlide := func() {
+		if len(slide) > 0 {
+			fmt.Fprintf(&body, `<par dur="%s">%s</par>`, smilSlideDuration, strings.Join(slide, ""))
+		}
+		slide, hasMedia, hasText = nil, false, false
+	}
+	for _, a := range attachments {
+		element, region := smilElement(a.MediaType)
+		if element == "text" {
+			if hasText {
+				endSlide()
+			}
+			hasText = true
+		} else {
+			if hasMedia {
+				endSlide()
+			}
+			hasMedia = true
+		}
+		var src bytes.Buffer
+		xml.EscapeText(&src, []byte("cid:"+strings.Trim(a.ContentId, "<>")))
+		if region != "" {
+			slide = append(slide, fmt.Sprintf(`<%s src="%s" region="%s"/>`, element, src.String(), region))
+		} else {
+			slide = append(slide, fmt.Sprintf(`<%s src="%s"/>`, element, src.String()))
+		}
+	}
+	endSlide()
+
+	data := []byte("<smil>" + smilLayout + "<body>" + body.String() + "</body></smil>")
+	start, _ := getSmilStart(data)
+	return &Attachment{
+		MediaType:       "application/smil",
+		ContentId:       start,
+		ContentLocation: "smil.xml",
+		Name:            "smil.xml",
+		Data:            data,
+	}
+}
+
+// NewSmilAttachment returns the SMIL presentation smil given by a client as
+// the SMIL part of a message.
+func NewSmilAttachment(smil string) (*Attachment, error) {
+	data := []byte(smil)
+	start, err := getSmilStart(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Attachment{
+		MediaType:       "application/smil",
+		ContentId:       start,
+		ContentLocation: "smil.xml",
+		Name:            "smil.xml",
+		Data:            data,
+	}, nil
+}
@@ -0,0 +1,55 @@
+package mms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSmil(t *testing.T) {
+	attachments := []*Attachment{
+		{MediaType: "text/plain", ContentId: "<text0.txt>"},
+		{MediaType: "image/jpeg", ContentId: "<image0.jpg>"},
+		{MediaType: "image/png", ContentId: "image1&.png"},
+		{MediaType: "text/plain", ContentId: "text1.txt"},
+		{MediaType: "text/plain", ContentId: "text2.txt"},
+		{MediaType: "text/vcard", ContentId: "contact.vcf"},
+	}
+	if HasSmil(attachments) {
+		t.Fatal("HasSmil without SMIL part")
+	}
+	smil := NewSmil(attachments)
+	if !HasSmil([]*Attachment{smil}) {
+		t.Error("HasSmil of the generated SMIL part")
+	}
+	if smil.ContentId != "<smil>" {
+		t.Errorf("ContentId = %q", smil.ContentId)
+	}
+	body := string(smil.Data)
+	body = body[strings.Index(body, "<body>"):]
+	want := `<body>` +
+		`<par dur="5000ms"><text src="cid:text0.txt" region="Text"/><img src="cid:image0.jpg" region="Image"/></par>` +
+		`<par dur="5000ms"><img src="cid:image1&amp;.png" region="Image"/><text src="cid:text1.txt" region="Text"/></par>` +
+		`<par dur="5000ms"><text src="cid:text2.txt" region="Text"/><ref src="cid:contact.vcf"/></par>` +
+		`</body></smil>`
+	if body != want {
+		t.Errorf("NewSmil body =\n%s\nwant\n%s", body, want)
+	}
+
+	mSendReq := NewMSendReq([]string{"+1"}, append(attachments, smil), false)
+	if mSendReq.Attachments[0] != smil || mSendReq.ContentTypeStart != "<smil>" || mSendReq.ContentTypeType != "application/smil" {
+		t.Errorf("generated SMIL is not the root part: %+v", mSendReq)
+	}
+}
+
+func TestNewSmilAttachment(t *testing.T) {
+	smil, err := NewSmilAttachment(`<smil><body><par><text src="cid:text0.txt"/></par></body></smil>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasSmil([]*Attachment{smil}) || smil.ContentId != "<smil>" {
+		t.Errorf("NewSmilAttachment = %+v", smil)
+	}
+	if _, err := NewSmilAttachment("no markup"); err == nil {
+		t.Error("NewSmilAttachment without a start tag succeeded")
+	}
+}
@@ -0,0 +1,120 @@
+package mms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Summary returns a plain text summary of the message meant for screen
+// readers and notification banners. It holds the subject, the text parts in
+// the order they are presented by the SMIL and a count of the other parts,
+// e.g. "2 images, 1 video", one per line.
+func (pdu *MRetrieveConf) Summary() string {
+	var lines []string
+	if subject := strings.TrimSpace(pdu.Subject); subject != "" {
+		lines = append(lines, subject)
+	}
+
+	var images, videos, audios, others int
+	for _, part := range pdu.presentationOrder() {
+		mediaType := strings.ToLower(part.MediaType)
+		switch {
+		case strings.HasPrefix(mediaType, "text/plain"):
+			text, _ := part.Text()
+			if text = strings.TrimSpace(text); text != "" {
+				lines = append(lines, text)
+			}
+		case strings.HasPrefix(mediaType, "image/"):
+			images++
+		case strings.HasPrefix(mediaType, "video/"):
+			videos++
+		case strings.HasPrefix(mediaType, "audio/"):
+			audios++
+		default:
+			others++
+		}
+	}
+
+	var counts []string
+	for _, c := range []struct {
+		n                int
+		singular, plural string
+	}{
+		{images, "image", "images"},
+		{videos, "video", "videos"},
+		{audios, "audio clip", "audio clips"},
+		{others, "attachment", "attachments"},
+	} {
+		switch {
+		case c.n == 1:
+			counts = append(counts, "1 "+c.singular)
+		case c.n > 1:
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.plural))
+		}
+	}
+	if len(counts) > 0 {
+		lines = append(lines, strings.Join(counts, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// presentationOrder returns the data parts in the order they are referenced
+// by the SMIL, followed by the parts it does not reference. Without a
+// parseable SMIL the order of the message is kept.
+func (pdu *MRetrieveConf) presentationOrder() []Attachment {
+	parts := pdu.GetDataParts()
+	smil, err := pdu.GetSmil()
+	if err != nil {
+		return parts
+	}
+
+	var ordered []Attachment
+	used := make([]bool, len(parts))
+	for _, src := range smilSources(smil) {
+		for i := range parts {
+			if !used[i] && parts[i].references(src) {
+				used[i] = true
+				ordered = append(ordered, parts[i])
+				break
+			}
+		}
+	}
+	for i := range parts {
+		if !used[i] {
+			ordered = append(ordered, parts[i])
+		}
+	}
+	return ordered
+}
+
+// smilSources returns the src attributes of the media elements of a SMIL
+// document in document order. Parsing stops at the first syntax error.
+func smilSources(smil string) []string {
+	var sources []string
+	dec := xml.NewDecoder(bytes.NewBufferString(smil))
+	dec.Strict = false
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return sources
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "src" && attr.Value != "" {
+					sources = append(sources, attr.Value)
+				}
+			}
+		}
+	}
+}
+
+// references returns true if a SMIL src refers to the attachment, either by
+// its Content-Location or, with a cid: URL, by its Content-ID.
+func (a Attachment) references(src string) bool {
+	if strings.HasPrefix(src, "cid:") {
+		return strings.Trim(a.ContentId, "<>") == strings.TrimPrefix(src, "cid:")
+	}
+	return src == a.ContentLocation || src == a.Name || src == a.FileName || src == strings.Trim(a.ContentId, "<>")
+}
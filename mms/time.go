@@ -0,0 +1,105 @@
+package mms
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamps are canonically represented as seconds since the Unix epoch in
+// UTC, as in the Date and Expiry headers. These helpers convert between that
+// representation, time.Time and the legacy RFC 3339 strings.
+
+// Epoch returns t as seconds since the Unix epoch, the zero time yields 0.
+func Epoch(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// EpochTime returns the UTC time for seconds since the Unix epoch, 0 yields
+// the zero time.
+func EpochTime(epoch int64) time.Time {
+	if epoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(epoch, 0).UTC()
+}
+
+// FormatEpoch returns epoch formatted as RFC 3339 in UTC, for properties
+// which are strings for compatibility.
+func FormatEpoch(epoch int64) string {
+	return EpochTime(epoch).Format(time.RFC3339)
+}
+
+// Wall clock changes, by the user or NTP, must not expire pending messages at
+// once. Notifications keep a monotonic reference to their receipt, the boot
+// and the time since boot, suspend included, so the time elapsed since can be
+// measured independently of the wall clock while the device was not
+// rebooted.
+
+const (
+	bootIdPath = "/proc/sys/kernel/random/boot_id"
+	uptimePath = "/proc/uptime"
+)
+
+// monotonicClock returns the current boot id and time since boot.
+var monotonicClock = func() (boot string, uptime time.Duration, err error) {
+	id, err := ioutil.ReadFile(bootIdPath)
+	if err != nil {
+		return "", 0, err
+	}
+	data, err := ioutil.ReadFile(uptimePath)
+	if err != nil {
+		return "", 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", 0, fmt.Errorf("cannot parse %s", uptimePath)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot parse %s: %w", uptimePath, err)
+	}
+	return strings.TrimSpace(string(id)), time.Duration(seconds * float64(time.Second)), nil
+}
+
+// setReceived sets the Received time and its monotonic reference.
+func (mNotificationInd *MNotificationInd) setReceived(received time.Time) {
+	mNotificationInd.Received = received.UTC()
+	if received.IsZero() {
+		return
+	}
+	boot, uptime, err := monotonicClock()
+	if err != nil {
+		return
+	}
+	if uptime -= time.Since(received); uptime < 0 {
+		// Received before this boot.
+		return
+	}
+	mNotificationInd.ReceivedBoot, mNotificationInd.ReceivedUptime = boot, uptime
+}
+
+// now returns the current time as measured from the receipt of the
+// notification. Without a monotonic reference from the current boot the wall
+// clock is used, but never earlier than the receipt.
+func (mNotificationInd *MNotificationInd) now() time.Time {
+	wall := time.Now()
+	if mNotificationInd.Received.IsZero() {
+		return wall
+	}
+	if mNotificationInd.ReceivedBoot != "" {
+		boot, uptime, err := monotonicClock()
+		if err == nil && boot == mNotificationInd.ReceivedBoot && uptime >= mNotificationInd.ReceivedUptime {
+			return mNotificationInd.Received.Add(uptime - mNotificationInd.ReceivedUptime)
+		}
+	}
+	if wall.Before(mNotificationInd.Received) {
+		return mNotificationInd.Received
+	}
+	return wall
+}
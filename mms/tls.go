@@ -0,0 +1,93 @@
+package mms
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrorTLS is the error of a transaction which failed because no secure
+// connection to the MMSC could be established, e.g. its certificate is not
+// trusted or matches none of the pins.
+type ErrorTLS struct {
+	Err error
+}
+
+func (e ErrorTLS) Error() string {
+	return "TLS handshake with the MMSC failed: " + e.Err.Error()
+}
+
+func (e ErrorTLS) Unwrap() error {
+	return e.Err
+}
+
+var errPinMismatch = errors.New("certificate matches no pin")
+
+// customTLS returns true if transactions through p verify the MMSC with
+// other certificates than the system ones.
+func (p Proxy) customTLS() bool {
+	return p.CACertificates != "" || len(p.CertificatePins) > 0
+}
+
+// tlsConfig returns the TLS configuration of transactions through p, nil for
+// the defaults. The MMSC certificate must be issued by a system CA or one in
+// the PEM file CACertificates, and if there are CertificatePins its chain
+// must include a certificate with one of them, see CertificatePin.
+func (p Proxy) tlsConfig() (*tls.Config, error) {
+	if !p.customTLS() {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if p.CACertificates != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		certs, err := ioutil.ReadFile(p.CACertificates)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificates: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(certs) {
+			return nil, fmt.Errorf("no CA certificates in %s", p.CACertificates)
+		}
+		config.RootCAs = pool
+	}
+	if len(p.CertificatePins) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range p.CertificatePins {
+			pins[pin] = true
+		}
+		config.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if pins[CertificatePin(cert)] {
+						return nil
+					}
+				}
+			}
+			return errPinMismatch
+		}
+	}
+	return config, nil
+}
+
+// CertificatePin returns the pin of cert, "sha256/" followed by the base64
+// encoded SHA-256 of its public key as in RFC 7469.
+func CertificatePin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsFailure returns true if err is the failure of a TLS handshake.
+func tlsFailure(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var record tls.RecordHeaderError
+	return errors.Is(err, errPinMismatch) || errors.As(err, &unknownAuthority) || errors.As(err, &hostname) ||
+		errors.As(err, &invalid) || errors.As(err, &record)
+}
@@ -0,0 +1,62 @@
+package mms
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestTLSTransfer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mmsContentType)
+		w.Write(retrieveConf)
+	}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	f.Close()
+	pin := CertificatePin(server.Certificate())
+
+	testCases := []struct {
+		name    string
+		proxy   Proxy
+		wantErr error
+	}{
+		{"untrusted", Proxy{CertificatePins: []string{pin}}, ErrorTLS{}},
+		{"trusted", Proxy{CACertificates: f.Name()}, nil},
+		{"pinned", Proxy{CACertificates: f.Name(), CertificatePins: []string{"sha256/other", pin}}, nil},
+		{"mismatched pin", Proxy{CACertificates: f.Name(), CertificatePins: []string{"sha256/other"}}, errPinMismatch},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath, err := (&MNotificationInd{ContentLocation: server.URL}).DownloadContent(context.Background(), tc.proxy)
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				os.Remove(filePath)
+				return
+			}
+			if !errors.As(err, &ErrorTLS{}) {
+				t.Fatalf("error = %v, want an ErrorTLS", err)
+			}
+			if tc.wantErr != (ErrorTLS{}) && !errors.Is(err, tc.wantErr) {
+				t.Errorf("error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := (Proxy{CACertificates: "/nonexistent"}).tlsConfig(); err == nil {
+		t.Error("tlsConfig() with missing CA certificates succeeded")
+	}
+}
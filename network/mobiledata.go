@@ -0,0 +1,113 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+const (
+	connectivityName      = "com.ubuntu.connectivity1"
+	connectivityPath      = dbus.ObjectPath("/com/ubuntu/connectivity1/Private")
+	connectivityInterface = "com.ubuntu.connectivity1.Private"
+)
+
+// MobileDataMonitor keeps track of the mobile data switch of the
+// connectivity service. MMS contexts cannot be activated while it is off.
+type MobileDataMonitor struct {
+	conn    *dbus.Connection
+	lock    sync.Mutex
+	enabled bool
+	known   bool
+	changed chan struct{}
+}
+
+// NewMobileDataMonitor creates a monitor using the connectivity service on
+// the session bus conn. Until its state is known mobile data is assumed to
+// be on.
+func NewMobileDataMonitor(conn *dbus.Connection) *MobileDataMonitor {
+	return &MobileDataMonitor{conn: conn, changed: make(chan struct{})}
+}
+
+// Init reads the current state and follows its changes.
+func (m *MobileDataMonitor) Init() error {
+	w, err := m.conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Sender:    connectivityName,
+		Interface: propertiesInterface,
+		Member:    "PropertiesChanged",
+		Path:      connectivityPath,
+	})
+	if err != nil {
+		return err
+	}
+	go m.watch(w)
+
+	reply, err := m.conn.Object(connectivityName, connectivityPath).Call(propertiesInterface, "Get", connectivityInterface, "MobileDataEnabled")
+	if err != nil {
+		return fmt.Errorf("cannot get the mobile data state: %w", err)
+	}
+	var enabled dbus.Variant
+	if err := reply.Args(&enabled); err != nil {
+		return fmt.Errorf("cannot parse the mobile data state: %w", err)
+	}
+	m.update(map[string]dbus.Variant{"MobileDataEnabled": enabled})
+	logger.Infof("Mobile data enabled: %v", m.Enabled())
+	return nil
+}
+
+func (m *MobileDataMonitor) watch(w *dbus.SignalWatch) {
+	for msg := range w.C {
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &props, &invalidated); err != nil {
+			logger.Error("Cannot parse connectivity PropertiesChanged: ", err)
+			continue
+		}
+		if iface != connectivityInterface {
+			continue
+		}
+		m.update(props)
+	}
+}
+
+func (m *MobileDataMonitor) update(props map[string]dbus.Variant) {
+	enabled, ok := props["MobileDataEnabled"].Value().(bool)
+	if !ok {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.known && enabled == m.enabled {
+		return
+	}
+	if m.known {
+		logger.Infof("Mobile data enabled: %v", enabled)
+	}
+	m.enabled, m.known = enabled, true
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Enabled returns false if the user turned mobile data off.
+func (m *MobileDataMonitor) Enabled() bool {
+	if m == nil {
+		return true
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.enabled || !m.known
+}
+
+// Changed returns a channel which is closed on the next change of state.
+func (m *MobileDataMonitor) Changed() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.changed
+}
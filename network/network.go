@@ -0,0 +1,145 @@
+// Package network follows the metered state NetworkManager reports for the
+// connection in use.
+//
+// Users turn on data saving for a connection by marking it as metered. Only
+// an explicit setting counts, NetworkManager guessing a mobile connection is
+// metered does not, otherwise every message would be deferred on phones. The
+// guess only counts for the size limit on metered connections, see Metered.
+package network
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/logging"
+)
+
+var logger = logging.New("network")
+
+const (
+	networkManagerName      = "org.freedesktop.NetworkManager"
+	networkManagerPath      = dbus.ObjectPath("/org/freedesktop/NetworkManager")
+	networkManagerInterface = "org.freedesktop.NetworkManager"
+	propertiesInterface     = "org.freedesktop.DBus.Properties"
+)
+
+// Values of the NMMetered enum.
+const (
+	MeteredUnknown  uint32 = 0
+	MeteredYes      uint32 = 1
+	MeteredNo       uint32 = 2
+	MeteredGuessYes uint32 = 3
+	MeteredGuessNo  uint32 = 4
+)
+
+// Monitor keeps track of the metered state.
+type Monitor struct {
+	conn    *dbus.Connection
+	lock    sync.Mutex
+	metered uint32
+	changed chan struct{}
+}
+
+// NewMonitor creates a monitor using NetworkManager on the system bus conn.
+// Until Init is called data saving is off.
+func NewMonitor(conn *dbus.Connection) *Monitor {
+	return &Monitor{conn: conn, changed: make(chan struct{})}
+}
+
+// Init reads the current state and follows its changes.
+func (m *Monitor) Init() error {
+	reply, err := m.conn.Object(networkManagerName, networkManagerPath).Call(propertiesInterface, "Get", networkManagerInterface, "Metered")
+	if err != nil {
+		return fmt.Errorf("cannot get the metered state: %w", err)
+	}
+	var metered dbus.Variant
+	if err := reply.Args(&metered); err != nil {
+		return fmt.Errorf("cannot parse the metered state: %w", err)
+	}
+	m.update(map[string]dbus.Variant{"Metered": metered})
+
+	w, err := m.conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Sender:    networkManagerName,
+		Interface: propertiesInterface,
+		Member:    "PropertiesChanged",
+		Path:      networkManagerPath,
+	})
+	if err != nil {
+		return err
+	}
+	go m.watch(w)
+	logger.Infof("Data saver enabled: %v", m.DataSaver())
+	return nil
+}
+
+func (m *Monitor) watch(w *dbus.SignalWatch) {
+	for msg := range w.C {
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &props, &invalidated); err != nil {
+			logger.Error("Cannot parse NetworkManager PropertiesChanged: ", err)
+			continue
+		}
+		m.update(props)
+	}
+}
+
+func (m *Monitor) update(props map[string]dbus.Variant) {
+	metered, ok := props["Metered"].Value().(uint32)
+	if !ok {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if metered == m.metered {
+		return
+	}
+	if (metered == MeteredYes) != (m.metered == MeteredYes) {
+		logger.Infof("Data saver enabled: %v", metered == MeteredYes)
+	}
+	m.metered = metered
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// SetMetered sets the metered state, one of the NMMetered values, as if
+// NetworkManager reported it, e.g. to run without NetworkManager in tests.
+func (m *Monitor) SetMetered(metered uint32) {
+	m.update(map[string]dbus.Variant{"Metered": dbus.MakeVariant(metered)})
+}
+
+// DataSaver returns true if the user marked the connection in use as
+// metered.
+func (m *Monitor) DataSaver() bool {
+	if m == nil {
+		return false
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.metered == MeteredYes
+}
+
+// Metered returns true if the connection in use is metered, whether the
+// user marked it so or NetworkManager guesses it is, e.g. for mobile data.
+func (m *Monitor) Metered() bool {
+	if m == nil {
+		return false
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.metered == MeteredYes || m.metered == MeteredGuessYes
+}
+
+// Changed returns a channel which is closed on the next change of state.
+func (m *Monitor) Changed() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.changed
+}
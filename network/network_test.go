@@ -0,0 +1,88 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+func TestMonitorDataSaver(t *testing.T) {
+	m := NewMonitor(nil)
+	if m.DataSaver() {
+		t.Error("DataSaver() = true before Init, want false")
+	}
+
+	testCases := []struct {
+		metered     uint32
+		want        bool
+		wantChanged bool
+	}{
+		{MeteredGuessYes, false, true},
+		{MeteredYes, true, true},
+		{MeteredYes, true, false},
+		{MeteredNo, false, true},
+	}
+	for _, tc := range testCases {
+		changed := m.Changed()
+		m.update(map[string]dbus.Variant{"Metered": dbus.MakeVariant(tc.metered)})
+		if got := m.DataSaver(); got != tc.want {
+			t.Errorf("DataSaver() with Metered %d = %v, want %v", tc.metered, got, tc.want)
+		}
+		select {
+		case <-changed:
+			if !tc.wantChanged {
+				t.Errorf("Changed() was closed for Metered %d, want it open", tc.metered)
+			}
+		default:
+			if tc.wantChanged {
+				t.Errorf("Changed() was not closed for Metered %d", tc.metered)
+			}
+		}
+	}
+
+	var nilMonitor *Monitor
+	if nilMonitor.DataSaver() || nilMonitor.Changed() != nil {
+		t.Error("nil Monitor reports data saving")
+	}
+}
+
+func TestMobileDataMonitor(t *testing.T) {
+	m := NewMobileDataMonitor(nil)
+	if !m.Enabled() {
+		t.Error("Enabled() = false before Init, want true")
+	}
+
+	testCases := []struct {
+		enabled     interface{}
+		want        bool
+		wantChanged bool
+	}{
+		{true, true, true},
+		{true, true, false},
+		{false, false, true},
+		{"on", false, false},
+		{true, true, true},
+	}
+	for _, tc := range testCases {
+		changed := m.Changed()
+		m.update(map[string]dbus.Variant{"MobileDataEnabled": dbus.MakeVariant(tc.enabled)})
+		if got := m.Enabled(); got != tc.want {
+			t.Errorf("Enabled() with MobileDataEnabled %v = %v, want %v", tc.enabled, got, tc.want)
+		}
+		select {
+		case <-changed:
+			if !tc.wantChanged {
+				t.Errorf("Changed() was closed for MobileDataEnabled %v, want it open", tc.enabled)
+			}
+		default:
+			if tc.wantChanged {
+				t.Errorf("Changed() was not closed for MobileDataEnabled %v", tc.enabled)
+			}
+		}
+	}
+
+	var nilMonitor *MobileDataMonitor
+	if !nilMonitor.Enabled() || nilMonitor.Changed() != nil {
+		t.Error("nil MobileDataMonitor reports mobile data off")
+	}
+}
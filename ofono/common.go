@@ -21,7 +21,15 @@
 
 package ofono
 
-import "launchpad.net/go-dbus/v1"
+import (
+	"context"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/logging"
+)
+
+var logger = logging.New("ofono")
 
 const (
 	AGENT_TAG                         = dbus.ObjectPath("/nuntium")
@@ -30,6 +38,7 @@ const (
 	CONNECTION_MANAGER_INTERFACE      = "org.ofono.ConnectionManager"
 	CONNECTION_CONTEXT_INTERFACE      = "org.ofono.ConnectionContext"
 	SIM_MANAGER_INTERFACE             = "org.ofono.SimManager"
+	NETWORK_REGISTRATION_INTERFACE    = "org.ofono.NetworkRegistration"
 	OFONO_MANAGER_INTERFACE           = "org.ofono.Manager"
 	OFONO_SENDER                      = "org.ofono"
 	MODEM_INTERFACE                   = "org.ofono.Modem"
@@ -37,6 +46,26 @@ const (
 
 type PropertiesType map[string]dbus.Variant
 
+// variantString returns the string in v, empty if v holds no string.
+func variantString(v dbus.Variant) string {
+	s, _ := v.Value().(string)
+	return s
+}
+
+// variantBool returns the boolean in v, false if v holds no boolean.
+func variantBool(v dbus.Variant) bool {
+	b, _ := v.Value().(bool)
+	return b
+}
+
+// callWithTimeout calls method of iface on obj and gives up waiting for the
+// reply after timeout.
+func callWithTimeout(obj *dbus.ObjectProxy, timeout time.Duration, iface, method string, args ...interface{}) (*dbus.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return obj.CallWithContext(ctx, iface, method, args...)
+}
+
 func getModems(conn *dbus.Connection) (modemPaths []dbus.ObjectPath, err error) {
 	modemsReply, err := getOfonoProps(conn, "/", OFONO_SENDER, "org.ofono.Manager", "GetModems")
 	if err != nil {
@@ -24,7 +24,7 @@ package ofono
 import (
 	"errors"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 	. "launchpad.net/gocheck"
 )
 
@@ -39,19 +39,19 @@ var proxy ProxyInfo
 
 func makeGenericContextProperty(name, cType string, active, messageCenter, messageProxy, preferred bool) PropertiesType {
 	p := make(PropertiesType)
-	p["Name"] = dbus.Variant{name}
-	p["Type"] = dbus.Variant{cType}
-	p["Active"] = dbus.Variant{active}
-	p["Preferred"] = dbus.Variant{preferred}
+	p["Name"] = dbus.MakeVariant(name)
+	p["Type"] = dbus.MakeVariant(cType)
+	p["Active"] = dbus.MakeVariant(active)
+	p["Preferred"] = dbus.MakeVariant(preferred)
 	if messageCenter {
-		p["MessageCenter"] = dbus.Variant{"http://messagecenter.com"}
+		p["MessageCenter"] = dbus.MakeVariant("http://messagecenter.com")
 	} else {
-		p["MessageCenter"] = dbus.Variant{""}
+		p["MessageCenter"] = dbus.MakeVariant("")
 	}
 	if messageProxy {
-		p["MessageProxy"] = dbus.Variant{proxy.String()}
+		p["MessageProxy"] = dbus.MakeVariant(proxy.String())
 	} else {
-		p["MessageProxy"] = dbus.Variant{""}
+		p["MessageProxy"] = dbus.MakeVariant("")
 	}
 	return p
 }
@@ -331,12 +331,10 @@ func (s *ContextTestSuite) TestGetProxy(c *C) {
 		ObjectPath: "/ril_0/context1",
 		Properties: makeGenericContextProperty("Context1", contextTypeInternet, true, true, true, false),
 	}
-	m := make(map[interface{}]interface{})
-	pr := dbus.Variant{proxy.Host}
-	pr_pt := dbus.Variant{uint16(proxy.Port)}
-	m["Proxy"] = &pr
-	m["ProxyPort"] = &pr_pt
-	context.Properties["Settings"] = dbus.Variant{m}
+	m := make(map[string]dbus.Variant)
+	m["Proxy"] = dbus.MakeVariant(proxy.Host)
+	m["ProxyPort"] = dbus.MakeVariant(uint16(proxy.Port))
+	context.Properties["Settings"] = dbus.MakeVariant(m)
 
 	p, err := context.GetProxy()
 	c.Assert(err, IsNil)
@@ -359,12 +357,78 @@ func (s *ContextTestSuite) TestGetProxyNoPort(c *C) {
 		ObjectPath: "/ril_0/context1",
 		Properties: makeGenericContextProperty("Context1", contextTypeInternet, true, true, true, false),
 	}
-	m := make(map[interface{}]interface{})
-	pr := dbus.Variant{proxy.Host}
-	m["Proxy"] = &pr
-	context.Properties["Settings"] = dbus.Variant{m}
+	m := make(map[string]dbus.Variant)
+	m["Proxy"] = dbus.MakeVariant(proxy.Host)
+	context.Properties["Settings"] = dbus.MakeVariant(m)
 
 	p, err := context.GetProxy()
 	c.Assert(err, IsNil)
 	c.Check(p, DeepEquals, ProxyInfo{Host: proxy.Host, Port: 80})
 }
+
+func (s *ContextTestSuite) TestParseProxyCredentials(c *C) {
+	c.Check(ParseProxy("user:p@ss@10.0.0.1:8080", 80), DeepEquals, ProxyInfo{Host: "10.0.0.1", Port: 8080, Username: "user", Password: "p@ss"})
+	c.Check(ParseProxy("user:secret@10.0.0.1", 80), DeepEquals, ProxyInfo{Host: "10.0.0.1", Port: 80, Username: "user", Password: "secret"})
+	c.Check(ParseProxy("user@10.0.0.1:8080", 80), DeepEquals, ProxyInfo{Host: "10.0.0.1", Port: 8080, Username: "user"})
+}
+
+func (s *ContextTestSuite) TestParseProxyIPv6(c *C) {
+	c.Check(ParseProxy("[2001:db8::1]:8080", 80), DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080})
+	c.Check(ParseProxy("[2001:db8::1]", 80), DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 80})
+	c.Check(ParseProxy("2001:db8::1", 80), DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 80})
+	c.Check(ParseProxy("user:secret@[2001:db8::1]:8080", 80), DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080, Username: "user", Password: "secret"})
+	c.Check(ProxyInfo{Host: "2001:db8::1", Port: 8080}.String(), Equals, "[2001:db8::1]:8080")
+}
+
+func (s *ContextTestSuite) TestGetProxyIPv6Only(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+	}
+	context.Properties["MessageProxy"] = dbus.MakeVariant("[2001:db8::1]:8080")
+	context.Properties["IPv6.Settings"] = dbus.MakeVariant(map[string]dbus.Variant{"Interface": dbus.MakeVariant("rmnet1")})
+
+	p, err := context.GetProxy()
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080})
+	c.Check(context.GetInterface(), Equals, "rmnet1")
+}
+
+func (s *ContextTestSuite) TestGetDomainNameServers(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+	}
+	c.Check(context.GetDomainNameServers(), IsNil)
+	dns := dbus.MakeVariant([]string{"10.0.0.1", "10.0.0.2"})
+	dns6 := dbus.MakeVariant([]string{"2001:db8::53"})
+	context.Properties["Settings"] = dbus.MakeVariant(map[string]dbus.Variant{"DomainNameServers": dns})
+	context.Properties["IPv6.Settings"] = dbus.MakeVariant(map[string]dbus.Variant{"DomainNameServers": dns6})
+	c.Check(context.GetDomainNameServers(), DeepEquals, []string{"10.0.0.1", "10.0.0.2", "2001:db8::53"})
+}
+
+func (s *ContextTestSuite) TestPatchableContext(c *C) {
+	settings := ContextSettings{AccessPointName: "internet", MessageCenter: "http://mmsc.example.com"}
+	inactive := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeInternet, false, false, false, false),
+	}
+	inactive.Properties["AccessPointName"] = dbus.MakeVariant("internet")
+	other := OfonoContext{
+		ObjectPath: "/ril_0/context2",
+		Properties: makeGenericContextProperty("Context2", contextTypeInternet, true, false, false, false),
+	}
+	other.Properties["AccessPointName"] = dbus.MakeVariant("wap")
+
+	_, ok := patchableContext([]OfonoContext{inactive, other}, settings)
+	c.Check(ok, Equals, false)
+
+	active := OfonoContext{
+		ObjectPath: "/ril_0/context3",
+		Properties: makeGenericContextProperty("Context3", contextTypeInternet, true, false, false, false),
+	}
+	active.Properties["AccessPointName"] = dbus.MakeVariant("Internet")
+	context, ok := patchableContext([]OfonoContext{inactive, other, active}, settings)
+	c.Assert(ok, Equals, true)
+	c.Check(context.ObjectPath, Equals, dbus.ObjectPath("/ril_0/context3"))
+}
@@ -0,0 +1,16 @@
+//go:build gofuzz
+// +build gofuzz
+
+package ofono
+
+import "github.com/ubports/nuntium/mms"
+
+// Fuzz is the go-fuzz entry point of the WAP push decoder, pushes which
+// decode are decoded further as the m-notification.ind they carry.
+func Fuzz(data []byte) int {
+	var push PushPDU
+	if err := NewDecoder(data).Decode(&push); err != nil {
+		return 0
+	}
+	return mms.Fuzz(push.Data)
+}
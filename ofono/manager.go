@@ -22,9 +22,7 @@
 package ofono
 
 import (
-	"log"
-
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 type Modems map[dbus.ObjectPath]*Modem
@@ -46,7 +44,7 @@ func NewModemManager(conn *dbus.Connection) *ModemManager {
 }
 
 func (mm *ModemManager) Init() error {
-	//Use a different connection for the modem signals to avoid go-dbus blocking issues
+	//Use a different connection for the modem signals
 	conn, err := dbus.Connect(dbus.SystemBus)
 	if err != nil {
 		return err
@@ -65,7 +63,7 @@ func (mm *ModemManager) Init() error {
 	//Check for existing modems
 	modemPaths, err := getModems(conn)
 	if err != nil {
-		log.Print("Cannot preemptively add modems: ", err)
+		logger.Error("Cannot preemptively add modems: ", err)
 	} else {
 		for _, objectPath := range modemPaths {
 			mm.addModem(objectPath)
@@ -81,13 +79,13 @@ func (mm *ModemManager) watchModems(modemAdded, modemRemoved *dbus.SignalWatch)
 		case m := <-modemAdded.C:
 			var signalProps PropertiesType
 			if err := m.Args(&objectPath, &signalProps); err != nil {
-				log.Print(err)
+				logger.Error(err)
 				continue
 			}
 			mm.addModem(objectPath)
 		case m := <-modemRemoved.C:
 			if err := m.Args(&objectPath); err != nil {
-				log.Print(err)
+				logger.Error(err)
 				continue
 			}
 			mm.removeModem(objectPath)
@@ -97,7 +95,7 @@ func (mm *ModemManager) watchModems(modemAdded, modemRemoved *dbus.SignalWatch)
 
 func (mm *ModemManager) addModem(objectPath dbus.ObjectPath) {
 	if modem, ok := mm.modems[objectPath]; ok {
-		log.Printf("Need to delete stale modem instance %s", modem.Modem)
+		logger.Infof("Need to delete stale modem instance %s", modem.Modem)
 		modem.Delete()
 		delete(mm.modems, objectPath)
 	}
@@ -108,10 +106,10 @@ func (mm *ModemManager) addModem(objectPath dbus.ObjectPath) {
 func (mm *ModemManager) removeModem(objectPath dbus.ObjectPath) {
 	if modem, ok := mm.modems[objectPath]; ok {
 		mm.ModemRemoved <- mm.modems[objectPath]
-		log.Printf("Deleting modem instance %s", modem.Modem)
+		logger.Infof("Deleting modem instance %s", modem.Modem)
 		modem.Delete()
 		delete(mm.modems, objectPath)
 	} else {
-		log.Printf("Cannot satisfy request to remove modem %s as it does not exist", objectPath)
+		logger.Errorf("Cannot satisfy request to remove modem %s as it does not exist", objectPath)
 	}
 }
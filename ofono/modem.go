@@ -24,13 +24,13 @@ package ofono
 import (
 	"errors"
 	"fmt"
-	"log"
-	"reflect"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 const (
@@ -45,6 +45,14 @@ const (
 	ofonoFailedError           = "org.ofono.Error.Failed"
 )
 
+// contextToggleTimeout bounds each attempt to activate or deactivate a
+// context, ofono does not reply while the network does not answer.
+const contextToggleTimeout = 60 * time.Second
+
+// ErrNoMMSContexts is returned by GetMMSContexts if no context of the modem
+// can be used for MMS.
+var ErrNoMMSContexts = errors.New("No mms contexts found")
+
 type OfonoContext struct {
 	ObjectPath dbus.ObjectPath
 	Properties PropertiesType
@@ -59,30 +67,48 @@ type Modem struct {
 	IdentityRemoved        chan string
 	endWatch               chan bool
 	PushInterfaceAvailable chan bool
+	// OnlineChanged receives the online state of the modem when it changes,
+	// e.g. when flight mode is toggled.
+	OnlineChanged chan bool
+	// AttachedChanged receives the attached state of the packet data
+	// service of the modem when it changes, e.g. when the network comes
+	// back after a loss of coverage.
+	AttachedChanged        chan bool
 	pushInterfaceAvailable bool
+	onlineLock             sync.Mutex
 	online                 bool
+	onlineKnown            bool
+	attached               bool
 	modemSignal, simSignal *dbus.SignalWatch
+	connectionSignal       *dbus.SignalWatch
 }
 
+// ProxyInfo is an MMS proxy, Username and Password are set if it requires
+// authentication.
 type ProxyInfo struct {
-	Host string
-	Port uint64
+	Host     string
+	Port     uint64
+	Username string
+	Password string
 }
 
 const PROP_SETTINGS = "Settings"
+const PROP_IPV6_SETTINGS = "IPv6.Settings"
 const SETTINGS_PROXY = "Proxy"
 const SETTINGS_PROXYPORT = "ProxyPort"
+const SETTINGS_INTERFACE = "Interface"
+const SETTINGS_DNS = "DomainNameServers"
 const DBUS_CALL_GET_PROPERTIES = "GetProperties"
 
 func (p ProxyInfo) String() string {
-	return fmt.Sprintf("%s:%d", p.Host, p.Port)
+	return net.JoinHostPort(p.Host, strconv.FormatUint(p.Port, 10))
 }
 
 func (oProp OfonoContext) String() string {
 	var s string
 	s += fmt.Sprintf("ObjectPath: %s\n", oProp.ObjectPath)
 	for k, v := range oProp.Properties {
-		s += fmt.Sprint("\t", k, ": ", v.Value, "\n")
+		s += fmt.Sprint("\t", k, ": ", v.Value(), "\n")
 	}
 	return s
 }
@@ -94,13 +120,15 @@ func NewModem(conn *dbus.Connection, objectPath dbus.ObjectPath) *Modem {
 		IdentityAdded:          make(chan string),
 		IdentityRemoved:        make(chan string),
 		PushInterfaceAvailable: make(chan bool),
+		OnlineChanged:          make(chan bool),
+		AttachedChanged:        make(chan bool),
 		endWatch:               make(chan bool),
 		PushAgent:              NewPushAgent(objectPath),
 	}
 }
 
 func (modem *Modem) Init() (err error) {
-	log.Printf("Initializing modem %s", modem.Modem)
+	logger.Infof("Initializing modem %s", modem.Modem)
 	modem.modemSignal, err = connectToPropertySignal(modem.conn, modem.Modem, MODEM_INTERFACE)
 	if err != nil {
 		return err
@@ -111,6 +139,11 @@ func (modem *Modem) Init() (err error) {
 		return err
 	}
 
+	modem.connectionSignal, err = connectToPropertySignal(modem.conn, modem.Modem, CONNECTION_MANAGER_INTERFACE)
+	if err != nil {
+		return err
+	}
+
 	// the calling order here avoids race conditions
 	go modem.watchStatus()
 	modem.fetchExistingStatus()
@@ -126,16 +159,20 @@ func (modem *Modem) fetchExistingStatus() {
 	if v, err := modem.getProperty(MODEM_INTERFACE, "Interfaces"); err == nil {
 		modem.updatePushInterfaceState(*v)
 	} else {
-		log.Print("Initial value couldn't be retrieved: ", err)
+		logger.Error("Initial value couldn't be retrieved: ", err)
 	}
 	if v, err := modem.getProperty(MODEM_INTERFACE, "Online"); err == nil {
 		modem.handleOnlineState(*v)
 	} else {
-		log.Print("Initial value couldn't be retrieved: ", err)
+		logger.Error("Initial value couldn't be retrieved: ", err)
 	}
 	if v, err := modem.getProperty(SIM_MANAGER_INTERFACE, "SubscriberIdentity"); err == nil {
 		modem.handleIdentity(*v)
 	}
+	// The connection manager only shows up once the modem is online.
+	if v, err := modem.getProperty(CONNECTION_MANAGER_INTERFACE, "Attached"); err == nil {
+		modem.handleAttachedState(*v)
+	}
 }
 
 // watchStatus monitors key states required for the modem to be considered operational
@@ -149,7 +186,7 @@ watchloop:
 	for {
 		select {
 		case <-modem.endWatch:
-			log.Printf("Ending modem watch for %s", modem.Modem)
+			logger.Infof("Ending modem watch for %s", modem.Modem)
 			break watchloop
 		case msg, ok := <-modem.modemSignal.C:
 			if !ok {
@@ -157,7 +194,7 @@ watchloop:
 				continue watchloop
 			}
 			if err := msg.Args(&propName, &propValue); err != nil {
-				log.Printf("Cannot interpret Modem Property change: %s", err)
+				logger.Errorf("Cannot interpret Modem Property change: %s", err)
 				continue watchloop
 			}
 			switch propName {
@@ -174,34 +211,80 @@ watchloop:
 				continue watchloop
 			}
 			if err := msg.Args(&propName, &propValue); err != nil {
-				log.Printf("Cannot interpret Sim Property change: %s", err)
+				logger.Errorf("Cannot interpret Sim Property change: %s", err)
 				continue watchloop
 			}
 			if propName != "SubscriberIdentity" {
 				continue watchloop
 			}
 			modem.handleIdentity(propValue)
+		case msg, ok := <-modem.connectionSignal.C:
+			if !ok {
+				modem.connectionSignal.C = nil
+				continue watchloop
+			}
+			if err := msg.Args(&propName, &propValue); err != nil {
+				logger.Errorf("Cannot interpret ConnectionManager Property change: %s", err)
+				continue watchloop
+			}
+			if propName != "Attached" {
+				continue watchloop
+			}
+			modem.handleAttachedState(propValue)
 		}
 	}
 }
 
 func (modem *Modem) handleOnlineState(propValue dbus.Variant) {
-	origState := modem.online
-	modem.online = reflect.ValueOf(propValue.Value).Bool()
-	if modem.online != origState {
-		log.Printf("Modem online: %t", modem.online)
+	modem.onlineLock.Lock()
+	origState, origKnown := modem.online, modem.onlineKnown
+	modem.online = variantBool(propValue)
+	modem.onlineKnown = true
+	online := modem.online
+	modem.onlineLock.Unlock()
+	if online != origState || !origKnown {
+		logger.Infof("Modem online: %t", online)
+		modem.OnlineChanged <- online
 	}
 }
 
+// Online returns false if the modem is known to be offline, e.g. in flight
+// mode. Until its state is known it is assumed to be online.
+func (modem *Modem) Online() bool {
+	modem.onlineLock.Lock()
+	defer modem.onlineLock.Unlock()
+	return modem.online || !modem.onlineKnown
+}
+
+func (modem *Modem) handleAttachedState(propValue dbus.Variant) {
+	modem.onlineLock.Lock()
+	origState := modem.attached
+	modem.attached = variantBool(propValue)
+	attached := modem.attached
+	modem.onlineLock.Unlock()
+	if attached != origState {
+		logger.Infof("Modem attached: %t", attached)
+		modem.AttachedChanged <- attached
+	}
+}
+
+// Attached returns true if the packet data service of the modem is attached
+// to the network, so MMS contexts can be activated.
+func (modem *Modem) Attached() bool {
+	modem.onlineLock.Lock()
+	defer modem.onlineLock.Unlock()
+	return modem.attached
+}
+
 func (modem *Modem) handleIdentity(propValue dbus.Variant) {
-	identity := reflect.ValueOf(propValue.Value).String()
+	identity := variantString(propValue)
 	if identity == "" && modem.identity != "" {
-		log.Printf("Identity before remove %s", modem.identity)
+		logger.Infof("Identity before remove %s", modem.identity)
 
 		modem.IdentityRemoved <- identity
 		modem.identity = identity
 	}
-	log.Printf("Identity added %s", identity)
+	logger.Infof("Identity added %s", identity)
 	if identity != "" && modem.identity == "" {
 		modem.identity = identity
 		modem.IdentityAdded <- identity
@@ -210,9 +293,8 @@ func (modem *Modem) handleIdentity(propValue dbus.Variant) {
 
 func (modem *Modem) updatePushInterfaceState(interfaces dbus.Variant) {
 	nextState := false
-	availableInterfaces := reflect.ValueOf(interfaces.Value)
-	for i := 0; i < availableInterfaces.Len(); i++ {
-		interfaceName := reflect.ValueOf(availableInterfaces.Index(i).Interface().(string)).String()
+	availableInterfaces, _ := interfaces.Value().([]string)
+	for _, interfaceName := range availableInterfaces {
 		if interfaceName == PUSH_NOTIFICATION_INTERFACE {
 			nextState = true
 			break
@@ -220,7 +302,7 @@ func (modem *Modem) updatePushInterfaceState(interfaces dbus.Variant) {
 	}
 	if modem.pushInterfaceAvailable != nextState {
 		modem.pushInterfaceAvailable = nextState
-		log.Printf("Push interface state: %t", modem.pushInterfaceAvailable)
+		logger.Infof("Push interface state: %t", modem.pushInterfaceAvailable)
 		if modem.pushInterfaceAvailable {
 			modem.PushInterfaceAvailable <- true
 		} else if modem.PushAgent.Registered {
@@ -259,7 +341,7 @@ func (modem *Modem) ActivateMMSContext(preferredContext dbus.ObjectPath) (OfonoC
 		if err := context.toggleActive(true, modem.conn); err == nil {
 			return context, nil
 		} else {
-			log.Println("Failed to activate for", context.ObjectPath, ":", err)
+			logger.Error("Failed to activate for ", context.ObjectPath, ": ", err)
 		}
 	}
 	return OfonoContext{}, errors.New("no context available to activate")
@@ -293,20 +375,20 @@ func (context *OfonoContext) getContextProperties(conn *dbus.Connection) {
 	ctxObj := conn.Object(OFONO_SENDER, context.ObjectPath)
 	if reply, err := ctxObj.Call(CONNECTION_CONTEXT_INTERFACE, DBUS_CALL_GET_PROPERTIES); err == nil {
 		if err := reply.Args(&context.Properties); err != nil {
-			log.Println("Cannot retrieve properties for", context.ObjectPath, err)
+			logger.Error("Cannot retrieve properties for ", context.ObjectPath, " ", err)
 		}
 	} else {
-		log.Println("Cannot get properties for", context.ObjectPath, err)
+		logger.Error("Cannot get properties for ", context.ObjectPath, " ", err)
 	}
 }
 
 func (context *OfonoContext) toggleActive(state bool, conn *dbus.Connection) error {
-	log.Println("Trying to set Active property to", state, "for context on", state, context.ObjectPath)
+	logger.Info("Trying to set Active property to ", state, " for context on ", state, " ", context.ObjectPath)
 	obj := conn.Object("org.ofono", context.ObjectPath)
 	for i := 0; i < 3; i++ {
-		_, err := obj.Call(CONNECTION_CONTEXT_INTERFACE, "SetProperty", "Active", dbus.Variant{state})
+		_, err := callWithTimeout(obj, contextToggleTimeout, CONNECTION_CONTEXT_INTERFACE, "SetProperty", "Active", dbus.MakeVariant(state))
 		if err != nil {
-			log.Printf("Cannot set Activate to %t (try %d/3) interface on %s: %s", state, i+1, context.ObjectPath, err)
+			logger.Errorf("Cannot set Activate to %t (try %d/3) interface on %s: %s", state, i+1, context.ObjectPath, err)
 			if activationErrorNeedsWait(err) {
 				time.Sleep(2 * time.Second)
 			}
@@ -316,7 +398,7 @@ func (context *OfonoContext) toggleActive(state bool, conn *dbus.Connection) err
 			// TODO get rid of nuntium's internal preferred setting
 			if !context.isPreferred() && context.isTypeMMS() {
 				obj.Call(CONNECTION_CONTEXT_INTERFACE, "SetProperty",
-					"Preferred", dbus.Variant{true})
+					"Preferred", dbus.MakeVariant(true))
 			}
 			// Refresh context properties
 			context.getContextProperties(conn)
@@ -328,24 +410,24 @@ func (context *OfonoContext) toggleActive(state bool, conn *dbus.Connection) err
 
 func (oContext OfonoContext) isTypeInternet() bool {
 	if v, ok := oContext.Properties["Type"]; ok {
-		return reflect.ValueOf(v.Value).String() == contextTypeInternet
+		return variantString(v) == contextTypeInternet
 	}
 	return false
 }
 
 func (oContext OfonoContext) isTypeMMS() bool {
 	if v, ok := oContext.Properties["Type"]; ok {
-		return reflect.ValueOf(v.Value).String() == contextTypeMMS
+		return variantString(v) == contextTypeMMS
 	}
 	return false
 }
 
 func (oContext OfonoContext) isActive() bool {
-	return reflect.ValueOf(oContext.Properties["Active"].Value).Bool()
+	return variantBool(oContext.Properties["Active"])
 }
 
 func (oContext OfonoContext) isPreferred() bool {
-	return reflect.ValueOf(oContext.Properties["Preferred"].Value).Bool()
+	return variantBool(oContext.Properties["Preferred"])
 }
 
 func (oContext OfonoContext) hasMessageCenter() bool {
@@ -354,21 +436,28 @@ func (oContext OfonoContext) hasMessageCenter() bool {
 
 func (oContext OfonoContext) messageCenter() string {
 	if v, ok := oContext.Properties["MessageCenter"]; ok {
-		return reflect.ValueOf(v.Value).String()
+		return variantString(v)
 	}
 	return ""
 }
 
 func (oContext OfonoContext) messageProxy() string {
 	if v, ok := oContext.Properties["MessageProxy"]; ok {
-		return reflect.ValueOf(v.Value).String()
+		return variantString(v)
+	}
+	return ""
+}
+
+func (oContext OfonoContext) accessPointName() string {
+	if v, ok := oContext.Properties["AccessPointName"]; ok {
+		return variantString(v)
 	}
 	return ""
 }
 
 func (oContext OfonoContext) name() string {
 	if v, ok := oContext.Properties["Name"]; ok {
-		return reflect.ValueOf(v.Value).String()
+		return variantString(v)
 	}
 	return ""
 }
@@ -379,22 +468,12 @@ func (oContext OfonoContext) settingsProxy() string {
 		return ""
 	}
 
-	settings, ok := v.Value.(map[interface{}]interface{})
-	if !ok {
-		return ""
-	}
-
-	proxy_v, ok := settings[SETTINGS_PROXY]
-	if !ok {
-		return ""
-	}
-
-	proxy, ok := proxy_v.(*dbus.Variant).Value.(string)
+	settings, ok := v.Value().(map[string]dbus.Variant)
 	if !ok {
 		return ""
 	}
 
-	return proxy
+	return variantString(settings[SETTINGS_PROXY])
 }
 
 func (oContext OfonoContext) settingsProxyPort() uint64 {
@@ -403,17 +482,12 @@ func (oContext OfonoContext) settingsProxyPort() uint64 {
 		return 80
 	}
 
-	settings, ok := v.Value.(map[interface{}]interface{})
+	settings, ok := v.Value().(map[string]dbus.Variant)
 	if !ok {
 		return 80
 	}
 
-	port_v, ok := settings[SETTINGS_PROXYPORT]
-	if !ok {
-		return 80
-	}
-
-	port, ok := port_v.(*dbus.Variant).Value.(uint16)
+	port, ok := settings[SETTINGS_PROXYPORT].Value().(uint16)
 	if !ok {
 		return 80
 	}
@@ -431,15 +505,77 @@ func (oContext OfonoContext) GetMessageCenter() (string, error) {
 
 func (oContext OfonoContext) GetProxy() (proxyInfo ProxyInfo, err error) {
 	proxy := oContext.settingsProxy()
+	if proxy == "" {
+		// ofono only resolves the proxy into the IPv4 settings, an IPv6
+		// only context just has the provisioned one.
+		proxy = oContext.messageProxy()
+	}
 	// we need to support empty proxies
 	if proxy == "" {
-		log.Println("No proxy in ofono settings")
+		logger.Info("No proxy in ofono settings")
 		return proxyInfo, nil
 	}
 
-	if strings.Contains(proxy, ":") {
-		v := strings.Split(proxy, ":")
-		host, port_str := v[0], v[1]
+	return ParseProxy(proxy, oContext.settingsProxyPort()), nil
+}
+
+// GetInterface returns the network interface of the active context, or an
+// empty string if ofono does not report one.
+func (oContext OfonoContext) GetInterface() string {
+	for _, prop := range []string{PROP_SETTINGS, PROP_IPV6_SETTINGS} {
+		v, ok := oContext.Properties[prop]
+		if !ok {
+			continue
+		}
+		settings, ok := v.Value().(map[string]dbus.Variant)
+		if !ok {
+			continue
+		}
+		if iface := variantString(settings[SETTINGS_INTERFACE]); iface != "" {
+			return iface
+		}
+	}
+	return ""
+}
+
+// GetDomainNameServers returns the DNS servers of the context, the IPv4 ones
+// first.
+func (oContext OfonoContext) GetDomainNameServers() []string {
+	var servers []string
+	for _, prop := range []string{PROP_SETTINGS, PROP_IPV6_SETTINGS} {
+		v, ok := oContext.Properties[prop]
+		if !ok {
+			continue
+		}
+		settings, ok := v.Value().(map[string]dbus.Variant)
+		if !ok {
+			continue
+		}
+		dns, _ := settings[SETTINGS_DNS].Value().([]string)
+		for _, server := range dns {
+			if server != "" {
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers
+}
+
+// ParseProxy parses a proxy defined as host or host:port, defaultPort is used
+// when the port is not part of proxy. IPv6 literals are bracketed when
+// followed by a port, e.g. [2001:db8::1]:8080. Credentials may precede it as
+// user:password@.
+func ParseProxy(proxy string, defaultPort uint64) (proxyInfo ProxyInfo) {
+	if i := strings.LastIndex(proxy, "@"); i >= 0 {
+		credentials := proxy[:i]
+		proxy = proxy[i+1:]
+		if j := strings.Index(credentials, ":"); j >= 0 {
+			proxyInfo.Username, proxyInfo.Password = credentials[:j], credentials[j+1:]
+		} else {
+			proxyInfo.Username = credentials
+		}
+	}
+	if host, port_str, err := net.SplitHostPort(proxy); err == nil {
 		port, err := strconv.ParseUint(port_str, 10, 16)
 		if err != nil {
 			port = 80
@@ -447,13 +583,13 @@ func (oContext OfonoContext) GetProxy() (proxyInfo ProxyInfo, err error) {
 
 		proxyInfo.Host = host
 		proxyInfo.Port = uint64(port)
-		return proxyInfo, nil
+		return proxyInfo
 	}
 
-	proxyInfo.Host = proxy
-	proxyInfo.Port = oContext.settingsProxyPort()
-
-	return proxyInfo, nil
+	// No port, an IPv6 literal may still be bracketed.
+	proxyInfo.Host = strings.TrimSuffix(strings.TrimPrefix(proxy, "["), "]")
+	proxyInfo.Port = defaultPort
+	return proxyInfo
 }
 
 //GetMMSContexts returns the contexts that are MMS capable; by convention it has
@@ -488,14 +624,18 @@ func (modem *Modem) GetMMSContexts(preferredContext dbus.ObjectPath) (mmsContext
 		}
 	}
 	if len(mmsContexts) == 0 {
-		log.Printf("non matching contexts:\n %+v", contexts)
-		return mmsContexts, errors.New("No mms contexts found")
+		logger.Infof("non matching contexts:\n %+v", contexts)
+		return mmsContexts, ErrNoMMSContexts
 	}
 	return mmsContexts, nil
 }
 
 func (modem *Modem) getProperty(interfaceName, propertyName string) (*dbus.Variant, error) {
 	errorString := "Cannot retrieve %s from %s for %s: %s"
+	if modem.conn == nil {
+		// A modem which is not on the bus, e.g. in tests.
+		return nil, fmt.Errorf(errorString, propertyName, interfaceName, modem.Modem, "not connected")
+	}
 	rilObj := modem.conn.Object(OFONO_SENDER, modem.Modem)
 	if reply, err := rilObj.Call(interfaceName, DBUS_CALL_GET_PROPERTIES); err == nil {
 		var property PropertiesType
@@ -511,14 +651,177 @@ func (modem *Modem) getProperty(interfaceName, propertyName string) (*dbus.Varia
 	}
 }
 
+// ContextSettings are the settings used to provision an MMS context in ofono.
+type ContextSettings struct {
+	Name            string
+	AccessPointName string
+	Username        string
+	Password        string
+	MessageCenter   string
+	MessageProxy    string
+}
+
+// AddMMSContext provisions a new context of type mms with settings and
+// returns its object path.
+func (modem *Modem) AddMMSContext(settings ContextSettings) (dbus.ObjectPath, error) {
+	obj := modem.conn.Object(OFONO_SENDER, modem.Modem)
+	reply, err := obj.Call(CONNECTION_MANAGER_INTERFACE, "AddContext", contextTypeMMS)
+	if err != nil {
+		return "", fmt.Errorf("cannot add mms context to %s: %w", modem.Modem, err)
+	}
+	var contextPath dbus.ObjectPath
+	if err := reply.Args(&contextPath); err != nil {
+		return "", fmt.Errorf("cannot add mms context to %s: %w", modem.Modem, err)
+	}
+
+	properties := []struct{ name, value string }{
+		{"Name", settings.Name},
+		{"AccessPointName", settings.AccessPointName},
+		{"Username", settings.Username},
+		{"Password", settings.Password},
+		{"MessageCenter", settings.MessageCenter},
+		{"MessageProxy", settings.MessageProxy},
+	}
+	return contextPath, modem.setContextProperties(contextPath, properties)
+}
+
+// setContextProperties sets the properties with a value on the context at
+// contextPath.
+func (modem *Modem) setContextProperties(contextPath dbus.ObjectPath, properties []struct{ name, value string }) error {
+	for _, p := range properties {
+		if p.value == "" {
+			continue
+		}
+		if err := modem.setContextProperty(contextPath, p.name, p.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setContextProperty sets the property name of the context at contextPath to
+// value.
+func (modem *Modem) setContextProperty(contextPath dbus.ObjectPath, name, value string) error {
+	ctxObj := modem.conn.Object(OFONO_SENDER, contextPath)
+	if _, err := ctxObj.Call(CONNECTION_CONTEXT_INTERFACE, "SetProperty", name, dbus.MakeVariant(value)); err != nil {
+		return fmt.Errorf("cannot set %s on %s: %w", name, contextPath, err)
+	}
+	return nil
+}
+
+// ConfigureMMSContext writes the access point, message center and proxy of
+// settings to the context used for MMS, the first of GetMMSContexts, and
+// returns its object path. The access point of an internet context is not
+// changed, a context of type mms is added for settings if it connects to
+// another one. Without a context used for MMS, one is provisioned as by
+// ProvisionMMSContext.
+func (modem *Modem) ConfigureMMSContext(preferredContext dbus.ObjectPath, settings ContextSettings) (dbus.ObjectPath, error) {
+	contexts, err := modem.GetMMSContexts(preferredContext)
+	if err == ErrNoMMSContexts {
+		return modem.ProvisionMMSContext(settings)
+	} else if err != nil {
+		return "", err
+	}
+	context := contexts[0]
+	if context.isTypeInternet() && !strings.EqualFold(context.accessPointName(), settings.AccessPointName) {
+		logger.Infof("Adding mms context for access point %s next to internet context %s", settings.AccessPointName, context.ObjectPath)
+		return modem.AddMMSContext(settings)
+	}
+	logger.Infof("Configuring context %s with access point %s and message center %s", context.ObjectPath, settings.AccessPointName, settings.MessageCenter)
+	if context.isTypeMMS() && context.accessPointName() != settings.AccessPointName {
+		if err := modem.setContextProperty(context.ObjectPath, "AccessPointName", settings.AccessPointName); err != nil {
+			return context.ObjectPath, err
+		}
+	}
+	// An empty proxy is set too, it removes the one configured before.
+	if err := modem.setContextProperty(context.ObjectPath, "MessageCenter", settings.MessageCenter); err != nil {
+		return context.ObjectPath, err
+	}
+	return context.ObjectPath, modem.setContextProperty(context.ObjectPath, "MessageProxy", settings.MessageProxy)
+}
+
+// ProvisionMMSContext makes a context usable for MMS with settings when
+// GetMMSContexts finds none. An active internet context for the same access
+// point which lacks a message center gets the message center and proxy of
+// settings, otherwise a new mms context is added. It returns the object path
+// of the context.
+func (modem *Modem) ProvisionMMSContext(settings ContextSettings) (dbus.ObjectPath, error) {
+	contexts, err := getOfonoProps(modem.conn, modem.Modem, OFONO_SENDER, CONNECTION_MANAGER_INTERFACE, "GetContexts")
+	if err != nil {
+		return "", err
+	}
+	if context, ok := patchableContext(contexts, settings); ok {
+		logger.Infof("Setting message center %s on context %s", settings.MessageCenter, context.ObjectPath)
+		properties := []struct{ name, value string }{
+			{"MessageCenter", settings.MessageCenter},
+			{"MessageProxy", settings.MessageProxy},
+		}
+		return context.ObjectPath, modem.setContextProperties(context.ObjectPath, properties)
+	}
+	logger.Infof("Adding mms context for access point %s", settings.AccessPointName)
+	return modem.AddMMSContext(settings)
+}
+
+// patchableContext returns the active internet context of contexts which
+// connects to the access point of settings but has no message center.
+func patchableContext(contexts []OfonoContext, settings ContextSettings) (OfonoContext, bool) {
+	for _, context := range contexts {
+		if context.isTypeInternet() && context.isActive() && !context.hasMessageCenter() &&
+			strings.EqualFold(context.accessPointName(), settings.AccessPointName) {
+			return context, true
+		}
+	}
+	return OfonoContext{}, false
+}
+
+// Roaming returns true if the modem is registered to a network other than
+// the home network of the SIM.
+func (modem *Modem) Roaming() (bool, error) {
+	v, err := modem.getProperty(NETWORK_REGISTRATION_INTERFACE, "Status")
+	if err != nil {
+		return false, err
+	}
+	return variantString(*v) == "roaming", nil
+}
+
+// OperatorCode returns the mobile country and network codes of the SIM in use.
+func (modem *Modem) OperatorCode() (mcc, mnc string, err error) {
+	v, err := modem.getProperty(SIM_MANAGER_INTERFACE, "MobileCountryCode")
+	if err != nil {
+		return "", "", err
+	}
+	mcc = variantString(*v)
+	v, err = modem.getProperty(SIM_MANAGER_INTERFACE, "MobileNetworkCode")
+	if err != nil {
+		return "", "", err
+	}
+	mnc = variantString(*v)
+	return mcc, mnc, nil
+}
+
+// NetworkOperatorCode returns the mobile country and network codes of the
+// network the modem is registered to.
+func (modem *Modem) NetworkOperatorCode() (mcc, mnc string, err error) {
+	v, err := modem.getProperty(NETWORK_REGISTRATION_INTERFACE, "MobileCountryCode")
+	if err != nil {
+		return "", "", err
+	}
+	mcc = variantString(*v)
+	v, err = modem.getProperty(NETWORK_REGISTRATION_INTERFACE, "MobileNetworkCode")
+	if err != nil {
+		return "", "", err
+	}
+	mnc = variantString(*v)
+	return mcc, mnc, nil
+}
+
 func (modem *Modem) Delete() {
 	if modem.identity != "" {
 		modem.IdentityRemoved <- modem.identity
 	}
 	modem.modemSignal.Cancel()
-	modem.modemSignal.C = nil
 	modem.simSignal.Cancel()
-	modem.simSignal.C = nil
+	modem.connectionSignal.Cancel()
 	modem.endWatch <- true
 }
 
@@ -31,12 +31,30 @@ import (
 
 type PDU byte
 
+// Content types of WAP pushes other than MMS notifications, which are
+// passed on to the clients.
+const (
+	VND_WAP_SIC                = "application/vnd.wap.sic"
+	VND_WAP_SLC                = "application/vnd.wap.slc"
+	VND_WAP_CONNECTIVITY_WBXML = "application/vnd.wap.connectivity-wbxml"
+)
+
 type PushPDU struct {
 	HeaderLength                             uint64
 	ContentLength                            uint64
 	ApplicationId, EncodingVersion, PushFlag byte
 	ContentType                              string
 	Data                                     []byte
+	// Sender is the address the push came from as oFono reports it, empty
+	// if it is not known.
+	Sender string
+}
+
+// IsMMS returns true if the push carries an MMS PDU, e.g. an
+// m-notification.ind, rather than a service indication, service loading or
+// provisioning document.
+func (pdu *PushPDU) IsMMS() bool {
+	return pdu.ApplicationId == mms.PUSH_APPLICATION_ID && pdu.ContentType == mms.VND_WAP_MMS_MESSAGE
 }
 
 type PushPDUDecoder struct {
@@ -57,6 +75,9 @@ func NewDecoder(data []byte) *PushPDUDecoder {
 // provided to and reported from the underlying transport. The Data field starts immediately after the Headers field and
 // ends at the end of the SDU.
 func (dec *PushPDUDecoder) Decode(pdu *PushPDU) (err error) {
+	if err := dec.CheckOffset(1); err != nil {
+		return err
+	}
 	if PDU(dec.Data[1]) != PUSH {
 		return errors.New(fmt.Sprintf("%x != %x is not a push PDU", PDU(dec.Data[1]), PUSH))
 	}
@@ -74,6 +95,9 @@ func (dec *PushPDUDecoder) Decode(pdu *PushPDU) (err error) {
 	if err = dec.decodeHeaders(pdu, remainHeaders); err != nil {
 		return err
 	}
+	if pdu.HeaderLength+3 > uint64(len(dec.Data)) {
+		return mms.ErrorDecodeShortData{Length: len(dec.Data), Expected: int(pdu.HeaderLength + 3)}
+	}
 	pdu.Data = dec.Data[(pdu.HeaderLength + 3):]
 	return nil
 }
@@ -82,6 +106,9 @@ func (dec *PushPDUDecoder) decodeHeaders(pdu *PushPDU, hdrLengthRemain int) erro
 	rValue := reflect.ValueOf(pdu).Elem()
 	var err error
 	for ; dec.Offset < (hdrLengthRemain + dec.Offset); dec.Offset++ {
+		if err := dec.CheckOffset(dec.Offset); err != nil {
+			return err
+		}
 		param := dec.Data[dec.Offset] & 0x7F
 		switch param {
 		case X_WAP_APPLICATION_ID:
@@ -90,6 +117,9 @@ func (dec *PushPDUDecoder) decodeHeaders(pdu *PushPDU, hdrLengthRemain int) erro
 			_, err = dec.ReadShortInteger(&rValue, "PushFlag")
 		case ENCODING_VERSION:
 			dec.Offset++
+			if err := dec.CheckOffset(dec.Offset); err != nil {
+				return err
+			}
 			pdu.EncodingVersion = dec.Data[dec.Offset] & 0x7F
 			dec.Offset++
 		case CONTENT_LENGTH:
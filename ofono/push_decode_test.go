@@ -41,22 +41,24 @@ func (s *PushDecodeTestSuite) SetUpTest(c *C) {
 	s.pdu = new(PushPDU)
 }
 
+// vodafoneSpainPush is an m-notification.ind pushed by Vodafone Spain.
+var vodafoneSpainPush = []byte{
+	0x00, 0x06, 0x26, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x2f, 0x76, 0x6e, 0x64, 0x2e, 0x77, 0x61, 0x70, 0x2e, 0x6d, 0x6d, 0x73,
+	0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x00, 0xaf, 0x84, 0xb4, 0x81,
+	0x8d, 0xdf, 0x8c, 0x82, 0x98, 0x4e, 0x4f, 0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30,
+	0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62, 0x77, 0x55, 0x48, 0x00, 0x8d, 0x90,
+	0x89, 0x18, 0x80, 0x2b, 0x33, 0x34, 0x36, 0x30, 0x30, 0x39, 0x34, 0x34, 0x34,
+	0x36, 0x33, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00,
+	0x8a, 0x80, 0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa3, 0x00,
+	0x83, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x31, 0x66, 0x65,
+	0x31, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x6c, 0x65, 0x74, 0x73, 0x2f, 0x4e, 0x4f,
+	0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30, 0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62,
+	0x77, 0x55, 0x48, 0x00,
+}
+
 func (s *PushDecodeTestSuite) TestDecodeVodaphoneSpain(c *C) {
-	inputBytes := []byte{
-		0x00, 0x06, 0x26, 0x61, 0x70, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f,
-		0x6e, 0x2f, 0x76, 0x6e, 0x64, 0x2e, 0x77, 0x61, 0x70, 0x2e, 0x6d, 0x6d, 0x73,
-		0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x00, 0xaf, 0x84, 0xb4, 0x81,
-		0x8d, 0xdf, 0x8c, 0x82, 0x98, 0x4e, 0x4f, 0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30,
-		0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62, 0x77, 0x55, 0x48, 0x00, 0x8d, 0x90,
-		0x89, 0x18, 0x80, 0x2b, 0x33, 0x34, 0x36, 0x30, 0x30, 0x39, 0x34, 0x34, 0x34,
-		0x36, 0x33, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00,
-		0x8a, 0x80, 0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa3, 0x00,
-		0x83, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x31, 0x66, 0x65,
-		0x31, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x6c, 0x65, 0x74, 0x73, 0x2f, 0x4e, 0x4f,
-		0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30, 0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62,
-		0x77, 0x55, 0x48, 0x00,
-	}
-	dec := NewDecoder(inputBytes)
+	dec := NewDecoder(vodafoneSpainPush)
 	c.Assert(dec.Decode(s.pdu), IsNil)
 
 	c.Check(int(s.pdu.HeaderLength), Equals, 38)
@@ -223,3 +225,41 @@ func (s *PushDecodeTestSuite) TestDecodePlayPoland(c *C) {
 	c.Check(s.pdu.ContentType, Equals, mms.VND_WAP_MMS_MESSAGE)
 	c.Check(len(s.pdu.Data), Equals, 102)
 }
+
+func (s *PushDecodeTestSuite) TestDecodeServiceIndication(c *C) {
+	inputBytes := []byte{
+		0x01, 0x06, 0x03, 0xae, 0xaf, 0x82, 0x02, 0x05, 0x6a, 0x00, 0x45, 0xc6,
+		0x01,
+	}
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(s.pdu), IsNil)
+
+	c.Check(int(s.pdu.ApplicationId), Equals, 2)
+	c.Check(s.pdu.ContentType, Equals, VND_WAP_SIC)
+	c.Check(len(s.pdu.Data), Equals, 7)
+	c.Check(s.pdu.IsMMS(), Equals, false)
+}
+
+func (s *PushDecodeTestSuite) TestIsMMS(c *C) {
+	s.pdu.ApplicationId = mms.PUSH_APPLICATION_ID
+	s.pdu.ContentType = mms.VND_WAP_MMS_MESSAGE
+	c.Check(s.pdu.IsMMS(), Equals, true)
+	s.pdu.ContentType = VND_WAP_SLC
+	c.Check(s.pdu.IsMMS(), Equals, false)
+}
+
+func (s *PushDecodeTestSuite) TestDecodeTruncated(c *C) {
+	inputs := [][]byte{
+		{},
+		{0x01},
+		{0x01, 0x06},
+		// The header length claims more bytes than there are.
+		{0x01, 0x06, 0x22, 0xbe, 0xaf},
+		{0x01, 0x06, 0x04, 0xbe, 0xaf, 0x8d},
+	}
+	for _, input := range inputs {
+		dec := NewDecoder(input)
+		err := dec.Decode(s.pdu)
+		c.Check(err, NotNil, Commentf("%#v", input))
+	}
+}
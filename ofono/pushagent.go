@@ -24,11 +24,11 @@ package ofono
 import (
 	"encoding/hex"
 	"fmt"
-	"log"
 	"sync"
 
-	"github.com/ubports/nuntium/mms"
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/logging"
+	"github.com/ubports/nuntium/metrics"
 )
 
 /*
@@ -36,7 +36,7 @@ import (
 */
 type OfonoPushNotification struct {
 	Data []byte
-	Info map[string]*dbus.Variant
+	Info map[string]dbus.Variant
 }
 
 type PushAgent struct {
@@ -61,11 +61,11 @@ func (agent *PushAgent) Register() (err error) {
 		}
 	}
 	if agent.Registered {
-		log.Printf("Agent already registered for %s", agent.modem)
+		logger.Warnf("Agent already registered for %s", agent.modem)
 		return nil
 	}
 	agent.Registered = true
-	log.Print("Registering agent for ", agent.modem, " on path ", AGENT_TAG, " and name ", agent.conn.UniqueName)
+	logger.Info("Registering agent for ", agent.modem, " on path ", AGENT_TAG, " and name ", agent.conn.UniqueName)
 	obj := agent.conn.Object("org.ofono", agent.modem)
 	_, err = obj.Call(PUSH_NOTIFICATION_INTERFACE, "RegisterAgent", AGENT_TAG)
 	if err != nil {
@@ -75,7 +75,7 @@ func (agent *PushAgent) Register() (err error) {
 	agent.messageChannel = make(chan *dbus.Message)
 	go agent.watchDBusMethodCalls()
 	agent.conn.RegisterObjectPath(AGENT_TAG, agent.messageChannel)
-	log.Print("Agent Registered for ", agent.modem, " on path ", AGENT_TAG)
+	logger.Info("Agent Registered for ", agent.modem, " on path ", AGENT_TAG)
 	return nil
 }
 
@@ -83,14 +83,14 @@ func (agent *PushAgent) Unregister() error {
 	agent.m.Lock()
 	defer agent.m.Unlock()
 	if !agent.Registered {
-		log.Printf("Agent no registered for %s", agent.modem)
+		logger.Warnf("Agent no registered for %s", agent.modem)
 		return nil
 	}
-	log.Print("Unregistering agent on ", agent.modem)
+	logger.Info("Unregistering agent on ", agent.modem)
 	obj := agent.conn.Object("org.ofono", agent.modem)
 	_, err := obj.Call(PUSH_NOTIFICATION_INTERFACE, "UnregisterAgent", AGENT_TAG)
 	if err != nil {
-		log.Print("Unregister failed ", err)
+		logger.Error("Unregister failed ", err)
 		return err
 	}
 	agent.release()
@@ -115,15 +115,15 @@ func (agent *PushAgent) watchDBusMethodCalls() {
 		case msg.Interface == PUSH_NOTIFICATION_AGENT_INTERFACE && msg.Member == "ReceiveNotification":
 			reply = agent.notificationReceived(msg)
 		case msg.Interface == PUSH_NOTIFICATION_AGENT_INTERFACE && msg.Member == "Release":
-			log.Printf("Push Agent on %s received Release", agent.modem)
+			logger.Infof("Push Agent on %s received Release", agent.modem)
 			reply = dbus.NewMethodReturnMessage(msg)
 			agent.release()
 		default:
-			log.Print("Received unkown method call on", msg.Interface, msg.Member)
+			logger.Warn("Received unkown method call on", msg.Interface, msg.Member)
 			reply = dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", "Unknown method")
 		}
 		if err := agent.conn.Send(reply); err != nil {
-			log.Print("Could not send reply: ", err)
+			logger.Error("Could not send reply: ", err)
 		}
 	}
 }
@@ -131,23 +131,25 @@ func (agent *PushAgent) watchDBusMethodCalls() {
 func (agent *PushAgent) notificationReceived(msg *dbus.Message) (reply *dbus.Message) {
 	var push OfonoPushNotification
 	if err := msg.Args(&(push.Data), &(push.Info)); err != nil {
-		log.Print("Error in received ReceiveNotification() method call ", msg)
+		logger.Error("Error in received ReceiveNotification() method call ", msg)
 		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error", "FormatError")
 	} else {
-		log.Print("Received ReceiveNotification() method call from ", push.Info["Sender"].Value)
-		log.Print("Push data\n", hex.Dump(push.Data))
+		logger.Info("Received ReceiveNotification() method call from ", push.Info["Sender"].Value())
+		if logger.Enabled(logging.Debug) {
+			logger.Debug("Push data\n", hex.Dump(push.Data))
+		}
 		dec := NewDecoder(push.Data)
 		pdu := new(PushPDU)
 		if err := dec.Decode(pdu); err != nil {
-			log.Print("Error ", err)
+			logger.Error("Error ", err)
+			metrics.IncCode(metrics.DecodeFailures, "push")
 			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error", "DecodeError")
 		}
-		// TODO later switch on ApplicationId and ContentType to different channels
-		if pdu.ApplicationId == mms.PUSH_APPLICATION_ID && pdu.ContentType == mms.VND_WAP_MMS_MESSAGE {
-			agent.Push <- pdu
-		} else {
-			log.Print("Unhandled push pdu", pdu)
-		}
+		metrics.Inc(metrics.PushesDecoded)
+		pdu.Sender = variantString(push.Info["Sender"])
+		// Every push is passed on, the receiver dispatches on the
+		// content type, see IsMMS.
+		agent.Push <- pdu
 		return dbus.NewMethodReturnMessage(msg)
 	}
 }
@@ -0,0 +1,156 @@
+package ofono
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/internal/ofonosim"
+	. "launchpad.net/gocheck"
+)
+
+// SimTestSuite runs the ofono client code against ofonosim on a private bus.
+// It is skipped where dbus-daemon is not available.
+type SimTestSuite struct {
+	bus   *ofonosim.Bus
+	sim   *ofonosim.Ofono
+	conn  *dbus.Connection
+	modem *ofonosim.Modem
+	count int
+}
+
+var _ = Suite(&SimTestSuite{})
+
+// ofonoProps is the getOfonoProps ContextTestSuite replaces.
+var ofonoProps = getOfonoProps
+
+func (s *SimTestSuite) SetUpSuite(c *C) {
+	bus, err := ofonosim.StartBus()
+	if err != nil {
+		c.Skip(err.Error())
+	}
+	s.bus = bus
+	if s.sim, err = ofonosim.New(); err != nil {
+		c.Skip(err.Error())
+	}
+	if s.conn, err = dbus.Connect(dbus.SystemBus); err != nil {
+		c.Skip(err.Error())
+	}
+}
+
+func (s *SimTestSuite) TearDownSuite(c *C) {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.sim != nil {
+		s.sim.Close()
+	}
+	if s.bus != nil {
+		s.bus.Close()
+	}
+}
+
+func (s *SimTestSuite) SetUpTest(c *C) {
+	getOfonoProps = ofonoProps
+	s.count++
+	s.modem = s.sim.AddModem(dbus.ObjectPath(fmt.Sprintf("/ril_%d", s.count)), fmt.Sprintf("00101%010d", s.count))
+}
+
+func (s *SimTestSuite) TearDownTest(c *C) {
+	s.sim.RemoveModem(s.modem)
+}
+
+func receiveBool(c *C, ch <-chan bool) bool {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out")
+	}
+	return false
+}
+
+func (s *SimTestSuite) TestModemStatus(c *C) {
+	modem := NewModem(s.conn, s.modem.Path)
+	identity := make(chan string, 1)
+	go func() { identity <- <-modem.IdentityAdded }()
+	go func() {
+		c.Check(modem.Init(), IsNil)
+	}()
+	c.Check(receiveBool(c, modem.PushInterfaceAvailable), Equals, true)
+	c.Check(receiveBool(c, modem.OnlineChanged), Equals, true)
+	select {
+	case id := <-identity:
+		c.Check(id, Equals, s.modem.Property(ofonosim.SimManagerInterface, "SubscriberIdentity"))
+	case <-time.After(5 * time.Second):
+		c.Fatal("no identity")
+	}
+	c.Check(receiveBool(c, modem.AttachedChanged), Equals, true)
+
+	s.modem.SetProperty(ofonosim.ModemInterface, "Online", false)
+	c.Check(receiveBool(c, modem.OnlineChanged), Equals, false)
+	c.Check(modem.Online(), Equals, false)
+	s.modem.SetProperty(ofonosim.ConnectionManagerInterface, "Attached", false)
+	c.Check(receiveBool(c, modem.AttachedChanged), Equals, false)
+
+	go func() { <-modem.IdentityRemoved }()
+	modem.Delete()
+}
+
+func (s *SimTestSuite) TestActivateMMSContext(c *C) {
+	s.modem.AddContext(contextTypeInternet, nil)
+	context := s.modem.AddContext(contextTypeMMS, map[string]dbus.Variant{
+		"AccessPointName": dbus.MakeVariant("mms.example.com"),
+		"MessageCenter":   dbus.MakeVariant("http://mmsc.example.com"),
+		"MessageProxy":    dbus.MakeVariant("10.0.0.1:8080"),
+	})
+	// The first attempt fails, the retry succeeds.
+	s.modem.FailActivation(ofonosim.ErrorInProgress)
+
+	modem := NewModem(s.conn, s.modem.Path)
+	mmsContext, err := modem.ActivateMMSContext("")
+	c.Assert(err, IsNil)
+	c.Check(mmsContext.ObjectPath, Equals, context.Path)
+	c.Check(context.Active(), Equals, true)
+	c.Check(context.Property("Preferred"), Equals, true)
+	proxy, err := mmsContext.GetProxy()
+	c.Check(err, IsNil)
+	c.Check(proxy.Host, Equals, "10.0.0.1")
+	c.Check(proxy.Port, Equals, uint64(8080))
+
+	c.Check(modem.DeactivateMMSContext(mmsContext), IsNil)
+	c.Check(context.Active(), Equals, false)
+}
+
+func (s *SimTestSuite) TestActivateMMSContextFails(c *C) {
+	context := s.modem.AddContext(contextTypeMMS, map[string]dbus.Variant{
+		"MessageCenter": dbus.MakeVariant("http://mmsc.example.com"),
+	})
+	s.modem.FailActivation(ofonosim.ErrorFailed, ofonosim.ErrorFailed, ofonosim.ErrorFailed)
+
+	_, err := NewModem(s.conn, s.modem.Path).ActivateMMSContext("")
+	c.Check(err, NotNil)
+	c.Check(context.Active(), Equals, false)
+}
+
+func (s *SimTestSuite) TestPushAgent(c *C) {
+	agent := NewPushAgent(s.modem.Path)
+	c.Assert(agent.Register(), IsNil)
+	c.Check(s.modem.AgentRegistered(), Equals, true)
+
+	pushes := make(chan *PushPDU, 1)
+	go func() { pushes <- <-agent.Push }()
+	c.Assert(s.modem.Push(vodafoneSpainPush, "+34600944463"), IsNil)
+	select {
+	case pdu := <-pushes:
+		c.Check(pdu.IsMMS(), Equals, true)
+		c.Check(pdu.Sender, Equals, "+34600944463")
+	case <-time.After(5 * time.Second):
+		c.Fatal("no push")
+	}
+	c.Check(s.modem.Push([]byte{0, 1, 2}, "+34600944463"), NotNil)
+
+	c.Assert(agent.Unregister(), IsNil)
+	c.Check(s.modem.AgentRegistered(), Equals, false)
+	c.Check(s.modem.Push(vodafoneSpainPush, "+34600944463"), NotNil)
+}
@@ -0,0 +1,151 @@
+// Package policy decides per sender whether incoming messages are downloaded
+// automatically, left for the user to download or blocked.
+//
+// Policies map sender patterns to an Action. A pattern is a phone number or
+// address where '*' matches any run of characters and '?' a single one, e.g.
+// "+4917*". When several patterns match a sender, the most specific one, the
+// one with the most literal characters, wins. The policies are stored in a
+// user file in the XDG config directory (UserPath) and managed over D-Bus.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// UserPath is the location of the policy file relative to the XDG config
+// directory.
+var UserPath = filepath.Join("nuntium", "download-policies.json")
+
+// Action is what to do with messages from a sender.
+type Action string
+
+const (
+	// Auto downloads messages automatically, even if the automatic download
+	// is disabled or they are over the size limit.
+	Auto Action = "auto"
+	// Defer leaves messages for the user to download. Priority messages are
+	// downloaded anyway, as they are under every other deferral.
+	Defer Action = "defer"
+	// Block rejects messages without showing them.
+	Block Action = "block"
+)
+
+// Valid returns true if a is a known action.
+func (a Action) Valid() bool {
+	return a == Auto || a == Defer || a == Block
+}
+
+// Policies maps sender patterns to actions.
+type Policies map[string]Action
+
+// Lookup returns the action of the most specific pattern matching sender. If
+// no pattern matches, ok is false.
+func (policies Policies) Lookup(sender string) (action Action, ok bool) {
+	var best string
+	bestLiterals := -1
+	for pattern, a := range policies {
+		if matched, err := path.Match(pattern, sender); err != nil || !matched {
+			continue
+		}
+		literals := len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")
+		if literals > bestLiterals || (literals == bestLiterals && pattern < best) {
+			best, bestLiterals, action, ok = pattern, literals, a, true
+		}
+	}
+	return action, ok
+}
+
+// Set validates pattern and action and adds them to policies.
+func (policies Policies) Set(pattern string, action Action) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if !action.Valid() {
+		return fmt.Errorf("invalid action %q for %q", action, pattern)
+	}
+	policies[pattern] = action
+	return nil
+}
+
+// Patterns returns the sorted patterns of policies.
+func (policies Policies) Patterns() []string {
+	var patterns []string
+	for pattern := range policies {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Blocked returns the sorted patterns of policies which block senders.
+func (policies Policies) Blocked() []string {
+	var patterns []string
+	for _, pattern := range policies.Patterns() {
+		if policies[pattern] == Block {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Read reads the policies stored at path. A missing file yields no policies
+// and no error.
+func Read(path string) (Policies, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Policies{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored map[string]Action
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("cannot parse download policies %s: %w", path, err)
+	}
+	policies := Policies{}
+	for pattern, action := range stored {
+		if err := policies.Set(pattern, action); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return policies, nil
+}
+
+// Write stores policies at path.
+func Write(path string, policies Policies) error {
+	data, err := json.MarshalIndent(policies, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Load reads the user policies.
+func Load() (Policies, error) {
+	path, err := xdg.Config.Find(UserPath)
+	if err != nil {
+		return Policies{}, nil
+	}
+	return Read(path)
+}
+
+// Save stores policies as the user policies.
+func Save(policies Policies) error {
+	path, err := xdg.Config.Ensure(UserPath)
+	if err != nil {
+		return err
+	}
+	return Write(path, policies)
+}
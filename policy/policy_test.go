@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPoliciesLookup(t *testing.T) {
+	policies := Policies{
+		"*":             Defer,
+		"+4917*":        Auto,
+		"+491701234567": Block,
+		"+49170123456?": Defer,
+	}
+	testCases := []struct {
+		sender     string
+		wantAction Action
+		wantOk     bool
+	}{
+		{"+491701234567", Block, true},
+		{"+491701234568", Defer, true},
+		{"+4917199", Auto, true},
+		{"+4430", Defer, true},
+	}
+	for _, tc := range testCases {
+		action, ok := policies.Lookup(tc.sender)
+		if action != tc.wantAction || ok != tc.wantOk {
+			t.Errorf("Lookup(%q) = (%q, %v), want (%q, %v)", tc.sender, action, ok, tc.wantAction, tc.wantOk)
+		}
+	}
+
+	if action, ok := (Policies{"+4917*": Auto}).Lookup("+4430"); ok {
+		t.Errorf("Lookup(\"+4430\") = (%q, %v), want no match", action, ok)
+	}
+}
+
+func TestPoliciesSet(t *testing.T) {
+	policies := Policies{}
+	if err := policies.Set("+4917*", Auto); err != nil {
+		t.Errorf("Set(\"+4917*\", auto) = %v, want nil", err)
+	}
+	for _, tc := range []struct {
+		pattern string
+		action  Action
+	}{
+		{"", Auto},
+		{"[", Auto},
+		{"+4917*", "download"},
+	} {
+		if err := policies.Set(tc.pattern, tc.action); err == nil {
+			t.Errorf("Set(%q, %q) = nil, want an error", tc.pattern, tc.action)
+		}
+	}
+	if patterns := policies.Patterns(); !reflect.DeepEqual(patterns, []string{"+4917*"}) {
+		t.Errorf("Patterns() = %v, want [+4917*]", patterns)
+	}
+}
+
+func TestPoliciesBlocked(t *testing.T) {
+	policies := Policies{"+4917*": Auto, "+491701234567": Block, "+49300*": Block, "*": Defer}
+	if blocked := policies.Blocked(); !reflect.DeepEqual(blocked, []string{"+491701234567", "+49300*"}) {
+		t.Errorf("Blocked() = %v, want [+491701234567 +49300*]", blocked)
+	}
+	if blocked := (Policies{}).Blocked(); len(blocked) != 0 {
+		t.Errorf("Blocked() of no policies = %v, want none", blocked)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "download-policies.json")
+
+	if policies, err := Read(path); err != nil || len(policies) != 0 {
+		t.Errorf("Read(missing) = (%v, %v), want no policies", policies, err)
+	}
+
+	want := Policies{"*": Defer, "+4917*": Auto}
+	if err := Write(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Read(path); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = (%v, %v), want (%v, nil)", got, err, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"*": "download"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Error("Read(invalid action) = nil error, want an error")
+	}
+}
@@ -0,0 +1,205 @@
+// Package power follows the battery state reported by UPower so background
+// work can be scheduled around it.
+//
+// Background retries are held back while the battery is critical and heavy
+// maintenance, such as cleaning up storage, preferably runs while charging.
+// Without UPower, or with a nil Monitor, everything is allowed to run.
+package power
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/logging"
+)
+
+var logger = logging.New("power")
+
+const (
+	upowerName          = "org.freedesktop.UPower"
+	upowerPath          = dbus.ObjectPath("/org/freedesktop/UPower")
+	upowerInterface     = "org.freedesktop.UPower"
+	displayDevicePath   = dbus.ObjectPath("/org/freedesktop/UPower/devices/DisplayDevice")
+	deviceInterface     = "org.freedesktop.UPower.Device"
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+)
+
+// Values of the State and WarningLevel properties of org.freedesktop.UPower.Device.
+const (
+	deviceStateCharging      = 1
+	deviceStateFullyCharged  = 4
+	warningLevelCritical     = 4
+	warningLevelShutdownSoon = 5
+)
+
+// State is the power state of the device.
+type State struct {
+	// OnBattery is true when the device runs from its battery.
+	OnBattery bool
+	// Charging is true when the battery is charging or full and plugged.
+	Charging bool
+	// Critical is true when the battery is critically low.
+	Critical bool
+	// Percentage is the battery charge.
+	Percentage float64
+}
+
+// BackgroundAllowed returns true if background work such as retries can run.
+func (s State) BackgroundAllowed() bool {
+	return !s.Critical || s.Charging
+}
+
+// MaintenancePreferred returns true if it is a good time for heavy
+// maintenance work.
+func (s State) MaintenancePreferred() bool {
+	return s.Charging || !s.OnBattery
+}
+
+// Monitor keeps track of the power state.
+type Monitor struct {
+	conn    *dbus.Connection
+	lock    sync.Mutex
+	state   State
+	changed chan struct{}
+}
+
+// NewMonitor creates a monitor using UPower on the system bus conn. Until
+// Init is called the device is assumed to be plugged.
+func NewMonitor(conn *dbus.Connection) *Monitor {
+	return &Monitor{conn: conn, changed: make(chan struct{})}
+}
+
+// Init reads the current state and follows its changes.
+func (m *Monitor) Init() error {
+	upowerProps, err := m.getAll(upowerPath, upowerInterface)
+	if err != nil {
+		return err
+	}
+	deviceProps, err := m.getAll(displayDevicePath, deviceInterface)
+	if err != nil {
+		return err
+	}
+	m.update(upowerProps)
+	m.update(deviceProps)
+
+	for _, path := range []dbus.ObjectPath{upowerPath, displayDevicePath} {
+		w, err := m.conn.WatchSignal(&dbus.MatchRule{
+			Type:      dbus.TypeSignal,
+			Sender:    upowerName,
+			Interface: propertiesInterface,
+			Member:    "PropertiesChanged",
+			Path:      path,
+		})
+		if err != nil {
+			return err
+		}
+		go m.watch(w)
+	}
+	logger.Infof("Power state: %+v", m.State())
+	return nil
+}
+
+func (m *Monitor) getAll(path dbus.ObjectPath, iface string) (map[string]dbus.Variant, error) {
+	reply, err := m.conn.Object(upowerName, path).Call(propertiesInterface, "GetAll", iface)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s properties: %w", iface, err)
+	}
+	var props map[string]dbus.Variant
+	if err := reply.Args(&props); err != nil {
+		return nil, fmt.Errorf("cannot parse %s properties: %w", iface, err)
+	}
+	return props, nil
+}
+
+func (m *Monitor) watch(w *dbus.SignalWatch) {
+	for msg := range w.C {
+		var iface string
+		var props map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &props, &invalidated); err != nil {
+			logger.Warn("Cannot parse UPower PropertiesChanged: ", err)
+			continue
+		}
+		m.update(props)
+	}
+}
+
+// update applies changed UPower or display device properties to the state.
+func (m *Monitor) update(props map[string]dbus.Variant) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.state
+	if v, ok := props["OnBattery"].Value().(bool); ok {
+		state.OnBattery = v
+	}
+	if v, ok := props["State"].Value().(uint32); ok {
+		state.Charging = v == deviceStateCharging || v == deviceStateFullyCharged
+	}
+	if v, ok := props["WarningLevel"].Value().(uint32); ok {
+		state.Critical = v == warningLevelCritical || v == warningLevelShutdownSoon
+	}
+	if v, ok := props["Percentage"].Value().(float64); ok {
+		state.Percentage = v
+	}
+	if state == m.state {
+		return
+	}
+	if state.BackgroundAllowed() != m.state.BackgroundAllowed() || state.MaintenancePreferred() != m.state.MaintenancePreferred() {
+		logger.Infof("Power state changed: %+v", state)
+	}
+	m.state = state
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// State returns the current power state.
+func (m *Monitor) State() State {
+	if m == nil {
+		return State{}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.state
+}
+
+// WaitBackground blocks until background work is allowed.
+func (m *Monitor) WaitBackground() {
+	m.wait(State.BackgroundAllowed, 0)
+}
+
+// WaitMaintenance blocks until it is a good time for heavy maintenance or
+// at most maxDelay, but never while background work is not allowed.
+func (m *Monitor) WaitMaintenance(maxDelay time.Duration) {
+	m.wait(State.MaintenancePreferred, maxDelay)
+	m.WaitBackground()
+}
+
+// wait blocks until cond holds for the state or, if timeout is not 0, it
+// elapsed.
+func (m *Monitor) wait(cond func(State) bool, timeout time.Duration) {
+	if m == nil {
+		return
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		m.lock.Lock()
+		ok, changed := cond(m.state), m.changed
+		m.lock.Unlock()
+		if ok {
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package power
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+func TestMonitorUpdate(t *testing.T) {
+	m := NewMonitor(nil)
+	if s := m.State(); !s.BackgroundAllowed() || !s.MaintenancePreferred() {
+		t.Errorf("initial State() = %+v, want background and maintenance allowed", s)
+	}
+
+	m.update(map[string]dbus.Variant{"OnBattery": dbus.MakeVariant(true)})
+	m.update(map[string]dbus.Variant{"State": dbus.MakeVariant(uint32(2)), "WarningLevel": dbus.MakeVariant(uint32(4)), "Percentage": dbus.MakeVariant(3.0)})
+	want := State{OnBattery: true, Critical: true, Percentage: 3}
+	if s := m.State(); s != want {
+		t.Errorf("State() = %+v, want %+v", s, want)
+	}
+	if s := m.State(); s.BackgroundAllowed() || s.MaintenancePreferred() {
+		t.Errorf("critical State() = %+v, want background and maintenance held back", s)
+	}
+
+	m.update(map[string]dbus.Variant{"State": dbus.MakeVariant(uint32(1))})
+	if s := m.State(); !s.BackgroundAllowed() || !s.MaintenancePreferred() {
+		t.Errorf("charging State() = %+v, want background and maintenance allowed", s)
+	}
+}
+
+func TestMonitorWait(t *testing.T) {
+	m := NewMonitor(nil)
+	m.update(map[string]dbus.Variant{"OnBattery": dbus.MakeVariant(true), "WarningLevel": dbus.MakeVariant(uint32(4))})
+
+	done := make(chan struct{})
+	go func() {
+		m.WaitBackground()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("WaitBackground() returned while the battery is critical")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.update(map[string]dbus.Variant{"WarningLevel": dbus.MakeVariant(uint32(1))})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WaitBackground() did not return once the battery is not critical")
+	}
+
+	start := time.Now()
+	m.WaitMaintenance(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("WaitMaintenance(50ms) on battery returned after %v", elapsed)
+	}
+
+	var nilMonitor *Monitor
+	nilMonitor.WaitBackground()
+	nilMonitor.WaitMaintenance(time.Hour)
+}
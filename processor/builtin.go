@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func init() {
+	Register("links", newLinkExtractor)
+	Register("keywords", newKeywordFilter)
+	Register("exec", newExecProcessor)
+}
+
+// textParts returns the contents of the plain text parts of a message.
+func textParts(mRetrieveConf *mms.MRetrieveConf) []string {
+	var texts []string
+	for _, part := range mRetrieveConf.GetDataParts() {
+		if strings.HasPrefix(part.MediaType, "text/plain") {
+			// Text falls back to the raw bytes for unknown charsets.
+			text, _ := part.Text()
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// linkExtractor annotates messages with the links found in their text parts
+// under the "links" key, space separated.
+type linkExtractor struct{}
+
+func newLinkExtractor(options map[string]string) (Processor, error) {
+	return linkExtractor{}, nil
+}
+
+func (linkExtractor) Process(mRetrieveConf *mms.MRetrieveConf) (Result, error) {
+	var links []string
+	for _, text := range textParts(mRetrieveConf) {
+		links = append(links, linkRegexp.FindAllString(text, -1)...)
+	}
+	if len(links) == 0 {
+		return Result{}, nil
+	}
+	return Result{Annotations: map[string]string{"links": strings.Join(links, " ")}}, nil
+}
+
+// keywordFilter quarantines messages whose subject or text parts contain any
+// of the comma separated "words" option, case insensitively.
+type keywordFilter struct {
+	words []string
+}
+
+func newKeywordFilter(options map[string]string) (Processor, error) {
+	var f keywordFilter
+	for _, w := range strings.Split(options["words"], ",") {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words = append(f.words, w)
+		}
+	}
+	if len(f.words) == 0 {
+		return nil, errors.New("no words configured")
+	}
+	return f, nil
+}
+
+func (f keywordFilter) Process(mRetrieveConf *mms.MRetrieveConf) (Result, error) {
+	texts := append(textParts(mRetrieveConf), mRetrieveConf.Subject)
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, w := range f.words {
+			if strings.Contains(text, w) {
+				return Result{Quarantine: true, Reason: fmt.Sprintf("contains %q", w)}, nil
+			}
+		}
+	}
+	return Result{}, nil
+}
+
+// execProcessor runs the "command" option for every data part of a message,
+// feeding the part on stdin. The command exits with 0 when the part is
+// acceptable, with 1 to quarantine the message and with anything else on
+// failure. The first line of output of a quarantining command is used as the
+// reason. This is meant for external scanners such as antivirus.
+type execProcessor struct {
+	command []string
+}
+
+func newExecProcessor(options map[string]string) (Processor, error) {
+	command := strings.Fields(options["command"])
+	if len(command) == 0 {
+		return nil, errors.New("no command configured")
+	}
+	return execProcessor{command}, nil
+}
+
+func (p execProcessor) Process(mRetrieveConf *mms.MRetrieveConf) (Result, error) {
+	for _, part := range mRetrieveConf.GetDataParts() {
+		cmd := exec.Command(p.command[0], p.command[1:]...)
+		cmd.Stdin = bytes.NewReader(part.Data)
+		cmd.Env = append(os.Environ(), "NUNTIUM_UUID="+mRetrieveConf.UUID, "NUNTIUM_MEDIA_TYPE="+part.MediaType)
+		out, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			reason := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+			return Result{Quarantine: true, Reason: reason}, nil
+		} else if err != nil {
+			return Result{}, fmt.Errorf("%s failed on part %s: %w", p.command[0], part.ContentId, err)
+		}
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,190 @@
+// Package processor implements a pipeline of content processors applied to
+// decoded messages before they are handed to telepathy.
+//
+// Processors can annotate a message, the annotations are exposed to the UI,
+// or quarantine it so it is held back. Typical processors are malware
+// scanners, link extractors or content filters for parental controls.
+//
+// Processors register a Factory under a name with Register, the pipeline is
+// then built from the processors listed in the configuration file, see
+// LoadPipeline.
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ubports/nuntium/logging"
+	"github.com/ubports/nuntium/mms"
+	"launchpad.net/go-xdg/v0"
+)
+
+var logger = logging.New("processor")
+
+// SystemConfigPath is the location of the system wide pipeline configuration.
+var SystemConfigPath = "/etc/nuntium/processors.json"
+
+// UserConfigPath is the location of the user pipeline configuration relative
+// to the XDG config directory. If present, it replaces the system one.
+var UserConfigPath = filepath.Join("nuntium", "processors.json")
+
+// Result is the outcome of processing a message.
+type Result struct {
+	// Annotations are key/values attached to the message.
+	Annotations map[string]string
+	// Quarantine holds the message back from being delivered.
+	Quarantine bool
+	// Reason explains why the message was quarantined.
+	Reason string
+}
+
+// Processor inspects a decoded message.
+type Processor interface {
+	Process(mRetrieveConf *mms.MRetrieveConf) (Result, error)
+}
+
+// Factory creates a processor from its configured options.
+type Factory func(options map[string]string) (Processor, error)
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]Factory)
+)
+
+// Register makes a processor available to the pipeline configuration under
+// name.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of the registered processors.
+func Registered() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config configures a processor in the pipeline.
+type Config struct {
+	// Name is the name the processor was registered with.
+	Name string
+	// Options are passed to the processor factory.
+	Options map[string]string `json:",omitempty"`
+	// Required quarantines messages the processor fails on instead of
+	// ignoring the failure.
+	Required bool `json:",omitempty"`
+}
+
+type stage struct {
+	Config
+	processor Processor
+}
+
+// Pipeline is an ordered list of processors.
+type Pipeline struct {
+	stages []stage
+}
+
+// NewPipeline creates the processors described by configs, in order.
+func NewPipeline(configs []Config) (*Pipeline, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	pipeline := &Pipeline{}
+	for _, config := range configs {
+		factory, ok := registry[config.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor %q", config.Name)
+		}
+		p, err := factory(config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create processor %q: %w", config.Name, err)
+		}
+		pipeline.stages = append(pipeline.stages, stage{config, p})
+	}
+	return pipeline, nil
+}
+
+// Len returns the number of processors in the pipeline.
+func (pipeline *Pipeline) Len() int {
+	if pipeline == nil {
+		return 0
+	}
+	return len(pipeline.stages)
+}
+
+// Process runs every processor on mRetrieveConf and merges their results.
+// Processing stops at the first processor that quarantines the message.
+func (pipeline *Pipeline) Process(mRetrieveConf *mms.MRetrieveConf) Result {
+	result := Result{Annotations: make(map[string]string)}
+	if pipeline == nil {
+		return result
+	}
+	for _, s := range pipeline.stages {
+		r, err := s.processor.Process(mRetrieveConf)
+		if err != nil {
+			if s.Required {
+				logger.Errorf("Required processor %s failed on %s, quarantining: %v", s.Name, mRetrieveConf.UUID, err)
+				result.Quarantine = true
+				result.Reason = fmt.Sprintf("%s: %v", s.Name, err)
+				return result
+			}
+			logger.Warnf("Processor %s failed on %s: %v", s.Name, mRetrieveConf.UUID, err)
+			continue
+		}
+		for k, v := range r.Annotations {
+			result.Annotations[k] = v
+		}
+		if r.Quarantine {
+			result.Quarantine = true
+			result.Reason = s.Name
+			if r.Reason != "" {
+				result.Reason += ": " + r.Reason
+			}
+			return result
+		}
+	}
+	return result
+}
+
+// ReadConfig reads a pipeline configuration file, a JSON list of Config.
+func ReadConfig(path string) ([]Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var configs []Config
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("cannot parse processor configuration %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// LoadPipeline builds the pipeline from the user configuration or, if there
+// is none, the system one. Without any configuration the pipeline is empty.
+func LoadPipeline() (*Pipeline, error) {
+	path := SystemConfigPath
+	if userPath, err := xdg.Config.Find(UserConfigPath); err == nil {
+		path = userPath
+	}
+	configs, err := ReadConfig(path)
+	if os.IsNotExist(err) {
+		return &Pipeline{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return NewPipeline(configs)
+}
@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func testMessage() *mms.MRetrieveConf {
+	return &mms.MRetrieveConf{
+		UUID:    "uuid",
+		Subject: "Weekend",
+		Attachments: []mms.Attachment{
+			{MediaType: "application/smil", Data: []byte("<smil></smil>")},
+			{MediaType: "text/plain;charset=utf-8", Data: []byte("See https://example.com/a and www.example.org")},
+			{MediaType: "image/jpeg", Data: []byte{0xff, 0xd8}},
+		},
+	}
+}
+
+type fakeProcessor struct {
+	result Result
+	err    error
+	calls  *int
+}
+
+func (p fakeProcessor) Process(*mms.MRetrieveConf) (Result, error) {
+	if p.calls != nil {
+		*p.calls++
+	}
+	return p.result, p.err
+}
+
+func TestPipelineProcess(t *testing.T) {
+	var lastCalls int
+	testCases := []struct {
+		name      string
+		stages    []stage
+		want      Result
+		wantCalls int
+	}{
+		{
+			"empty",
+			nil,
+			Result{Annotations: map[string]string{}},
+			0,
+		},
+		{
+			"annotations-merged",
+			[]stage{
+				{Config{Name: "a"}, fakeProcessor{result: Result{Annotations: map[string]string{"a": "1"}}}},
+				{Config{Name: "b"}, fakeProcessor{result: Result{Annotations: map[string]string{"b": "2"}}}},
+			},
+			Result{Annotations: map[string]string{"a": "1", "b": "2"}},
+			0,
+		},
+		{
+			"quarantine-stops",
+			[]stage{
+				{Config{Name: "scanner"}, fakeProcessor{result: Result{Quarantine: true, Reason: "infected"}}},
+				{Config{Name: "last"}, fakeProcessor{calls: &lastCalls}},
+			},
+			Result{Annotations: map[string]string{}, Quarantine: true, Reason: "scanner: infected"},
+			0,
+		},
+		{
+			"optional-failure-ignored",
+			[]stage{
+				{Config{Name: "broken"}, fakeProcessor{err: errors.New("broken")}},
+				{Config{Name: "last"}, fakeProcessor{calls: &lastCalls}},
+			},
+			Result{Annotations: map[string]string{}},
+			1,
+		},
+		{
+			"required-failure-quarantines",
+			[]stage{
+				{Config{Name: "broken", Required: true}, fakeProcessor{err: errors.New("broken")}},
+				{Config{Name: "last"}, fakeProcessor{calls: &lastCalls}},
+			},
+			Result{Annotations: map[string]string{}, Quarantine: true, Reason: "broken: broken"},
+			0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lastCalls = 0
+			pipeline := &Pipeline{stages: tc.stages}
+			if got := pipeline.Process(testMessage()); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Process() = %#v, want %#v", got, tc.want)
+			}
+			if lastCalls != tc.wantCalls {
+				t.Errorf("last processor called %d times, want %d", lastCalls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNewPipelineUnknown(t *testing.T) {
+	if _, err := NewPipeline([]Config{{Name: "does-not-exist"}}); err == nil {
+		t.Error("expected an error for an unknown processor")
+	}
+}
+
+func TestBuiltinProcessors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		configs []Config
+		want    Result
+	}{
+		{
+			"links",
+			[]Config{{Name: "links"}},
+			Result{Annotations: map[string]string{"links": "https://example.com/a www.example.org"}},
+		},
+		{
+			"keywords-no-match",
+			[]Config{{Name: "keywords", Options: map[string]string{"words": "lottery, prize"}}},
+			Result{Annotations: map[string]string{}},
+		},
+		{
+			"keywords-subject-match",
+			[]Config{{Name: "keywords", Options: map[string]string{"words": "WEEKEND"}}},
+			Result{Annotations: map[string]string{}, Quarantine: true, Reason: `keywords: contains "weekend"`},
+		},
+		{
+			"exec-clean",
+			[]Config{{Name: "exec", Options: map[string]string{"command": "true"}}},
+			Result{Annotations: map[string]string{}},
+		},
+		{
+			"exec-quarantine",
+			[]Config{{Name: "exec", Options: map[string]string{"command": "false"}}},
+			Result{Annotations: map[string]string{}, Quarantine: true, Reason: "exec"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(tc.configs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pipeline.Process(testMessage()); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Process() = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package spam recognizes suspicious messages, which are filed in a spam
+// folder instead of the inbox when they come from unknown senders.
+//
+// The heuristics only look at the content, whether the sender is known is up
+// to the caller. A message is suspicious if it carries executable content,
+// e.g. an Android package or a Windows program, or if its text is nothing but
+// links. Service indication and service loading pushes, which make the phone
+// offer or open a link, are suspicious as well.
+package spam
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// executableTypes are the media types of programs and scripts.
+var executableTypes = map[string]bool{
+	"application/vnd.android.package-archive":       true,
+	"application/java-archive":                      true,
+	"text/vnd.sun.j2me.app-descriptor":              true,
+	"application/x-msdownload":                      true,
+	"application/x-msdos-program":                   true,
+	"application/x-ms-installer":                    true,
+	"application/x-executable":                      true,
+	"application/x-sharedlib":                       true,
+	"application/x-sh":                              true,
+	"application/x-shellscript":                     true,
+	"text/x-sh":                                     true,
+	"application/x-bat":                             true,
+	"application/vnd.microsoft.portable-executable": true,
+}
+
+// executableExtensions are the file name extensions of programs and
+// scripts, for parts sent as application/octet-stream.
+var executableExtensions = map[string]bool{
+	".apk": true, ".jar": true, ".jad": true, ".exe": true, ".com": true,
+	".scr": true, ".msi": true, ".dll": true, ".bat": true, ".cmd": true,
+	".sh": true, ".js": true, ".vbs": true,
+}
+
+// pushTypes are the media types of service indication and service loading
+// pushes, textual and WBXML encoded.
+var pushTypes = map[string]bool{
+	"text/vnd.wap.si":         true,
+	"application/vnd.wap.sic": true,
+	"text/vnd.wap.sl":         true,
+	"application/vnd.wap.slc": true,
+}
+
+var linkRegexp = regexp.MustCompile(`(?i)^(?:https?://|www\.)[^\s<>"]+$`)
+
+// Reason returns why mRetrieveConf looks like spam, empty if it does not.
+func Reason(mRetrieveConf *mms.MRetrieveConf) string {
+	var words []string
+	for _, part := range mRetrieveConf.GetDataParts() {
+		if executable(part) {
+			name := part.Name
+			if name == "" {
+				name = part.ContentLocation
+			}
+			return fmt.Sprintf("executable content %q (%s)", name, mediaType(part.MediaType))
+		}
+		if strings.HasPrefix(part.MediaType, "text/plain") {
+			// Text falls back to the raw bytes for unknown charsets.
+			text, _ := part.Text()
+			words = append(words, strings.Fields(text)...)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	for _, word := range words {
+		if !linkRegexp.MatchString(word) {
+			return ""
+		}
+	}
+	return "text is only links"
+}
+
+// PushReason returns why a WAP push of contentType, which does not carry an
+// MMS PDU, looks like spam, empty if it does not.
+func PushReason(contentType string) string {
+	if t := mediaType(contentType); pushTypes[t] {
+		return fmt.Sprintf("%s push", t)
+	}
+	return ""
+}
+
+// executable returns true if part is a program or script, going by its
+// media type or, failing that, the extension of its name.
+func executable(part mms.Attachment) bool {
+	if executableTypes[mediaType(part.MediaType)] {
+		return true
+	}
+	for _, name := range []string{part.Name, part.FileName, part.ContentLocation} {
+		if executableExtensions[strings.ToLower(path.Ext(name))] {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the media type of contentType without parameters, in
+// lower case.
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+}
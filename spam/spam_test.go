@@ -0,0 +1,75 @@
+package spam
+
+import (
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func TestReason(t *testing.T) {
+	testCases := []struct {
+		name        string
+		attachments []mms.Attachment
+		want        string
+	}{
+		{
+			"text",
+			[]mms.Attachment{
+				{MediaType: "application/smil", Data: []byte("<smil></smil>")},
+				{MediaType: "text/plain;charset=utf-8", Data: []byte("See https://example.com/a")},
+			},
+			"",
+		},
+		{
+			"links-only",
+			[]mms.Attachment{
+				{MediaType: "text/plain;charset=utf-8", Data: []byte(" https://example.com/a\nwww.example.org ")},
+			},
+			"text is only links",
+		},
+		{
+			"links-and-image",
+			[]mms.Attachment{
+				{MediaType: "image/jpeg", Data: []byte{0xff, 0xd8}},
+				{MediaType: "text/plain", Data: []byte("http://example.com")},
+			},
+			"text is only links",
+		},
+		{
+			"image-only",
+			[]mms.Attachment{{MediaType: "image/jpeg", Data: []byte{0xff, 0xd8}}},
+			"",
+		},
+		{
+			"package",
+			[]mms.Attachment{{MediaType: "application/vnd.android.package-archive", Name: "update.apk"}},
+			`executable content "update.apk" (application/vnd.android.package-archive)`,
+		},
+		{
+			"extension",
+			[]mms.Attachment{{MediaType: "application/octet-stream", ContentLocation: "Setup.EXE"}},
+			`executable content "Setup.EXE" (application/octet-stream)`,
+		},
+	}
+	for _, tc := range testCases {
+		if got := Reason(&mms.MRetrieveConf{Attachments: tc.attachments}); got != tc.want {
+			t.Errorf("%s: Reason() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPushReason(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		want        string
+	}{
+		{"application/vnd.wap.sic", "application/vnd.wap.sic push"},
+		{"Text/vnd.wap.sl; charset=utf-8", "text/vnd.wap.sl push"},
+		{"application/vnd.wap.connectivity-wbxml", ""},
+	}
+	for _, tc := range testCases {
+		if got := PushReason(tc.contentType); got != tc.want {
+			t.Errorf("PushReason(%q) = %q, want %q", tc.contentType, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Stored states start with a header line holding the format version and the
+// CRC-32 of the JSON encoded state which follows, so a torn or corrupt write
+// is detected. States without the header were written by older versions.
+const (
+	stateHeader  = "nuntium-state"
+	stateVersion = 1
+)
+
+// Suffixes of the files kept next to a state file: the new state while it is
+// written and the previous state.
+const (
+	tmpSuffix    = ".tmp"
+	backupSuffix = ".bak"
+)
+
+// encodeState returns state with the header in the stored form.
+func encodeState(state MMSState) ([]byte, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("%s %d %08x\n", stateHeader, stateVersion, crc32.ChecksumIEEE(data))
+	return append([]byte(header), data...), nil
+}
+
+// decodeState parses a stored state, with or without the header.
+func decodeState(data []byte) (MMSState, error) {
+	if bytes.HasPrefix(data, []byte(stateHeader+" ")) {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return MMSState{}, fmt.Errorf("truncated state header")
+		}
+		var version int
+		var crc uint32
+		if _, err := fmt.Sscanf(string(data[:nl]), stateHeader+" %d %x", &version, &crc); err != nil {
+			return MMSState{}, fmt.Errorf("invalid state header: %w", err)
+		}
+		if version > stateVersion {
+			return MMSState{}, fmt.Errorf("unsupported state version %d", version)
+		}
+		data = data[nl+1:]
+		if crc32.ChecksumIEEE(data) != crc {
+			return MMSState{}, fmt.Errorf("state checksum mismatch")
+		}
+	}
+	mmsState := MMSState{}
+	if err := json.Unmarshal(data, &mmsState); err != nil {
+		return MMSState{}, err
+	}
+	return mmsState, nil
+}
+
+// readState reads the state stored in a file at storePath by an older
+// version. If it is corrupt, e.g. as that version crashed while writing it,
+// the state is recovered from a completed but not yet renamed write or from
+// the previous state.
+func readState(storePath string) (MMSState, error) {
+	data, err := ioutil.ReadFile(storePath)
+	if err != nil {
+		return MMSState{}, err
+	}
+	mmsState, err := decodeState(data)
+	if err == nil {
+		return mmsState, nil
+	}
+	for _, suffix := range []string{tmpSuffix, backupSuffix} {
+		data, readErr := ioutil.ReadFile(storePath + suffix)
+		if readErr != nil {
+			continue
+		}
+		recovered, decodeErr := decodeState(data)
+		if decodeErr != nil {
+			continue
+		}
+		logger.Infof("Recovered corrupt state %s from %s: %v", storePath, storePath+suffix, err)
+		return recovered, nil
+	}
+	return MMSState{}, err
+}
+
+// writeFileAtomic replaces the file at path with data such that a crash
+// leaves either the previous or the new content. With backup the previous
+// content is kept next to it.
+func writeFileAtomic(path string, data []byte, backup bool) error {
+	tmpPath := path + tmpSuffix
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if backup {
+		backupPath := path + backupSuffix
+		os.Remove(backupPath)
+		if err := os.Link(path, backupPath); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Cannot keep the previous %s: %v", path, err)
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Persist the rename itself.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeState(t *testing.T) {
+	state := MMSState{Id: "tid", State: RESPONDED, ModemId: "modem", SendState: SendInfo{}}
+	data, err := encodeState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := decodeState(data); err != nil || !reflect.DeepEqual(decoded, state) {
+		t.Errorf("decodeState(encodeState(state)) = %+v, %v", decoded, err)
+	}
+
+	legacy := []byte(`{"Id":"tid","State":"responded","ModemId":"modem","SendState":{}}` + "\n")
+	if decoded, err := decodeState(legacy); err != nil || !reflect.DeepEqual(decoded, state) {
+		t.Errorf("decodeState of a legacy state = %+v, %v", decoded, err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-3] = 'x'
+	for name, data := range map[string][]byte{
+		"corrupt":   corrupt,
+		"truncated": data[:len(data)-5],
+		"legacy":    legacy[:20],
+		"header":    data[:10],
+	} {
+		if _, err := decodeState(data); err == nil {
+			t.Errorf("decodeState of a %s state succeeded", name)
+		}
+	}
+}
+
+func TestReadStateRecovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storePath := filepath.Join(dir, "uuid.db")
+
+	for _, state := range []MMSState{{State: NOTIFICATION}, {State: DOWNLOADED}} {
+		data, err := encodeState(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(storePath, data, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state, err := readState(storePath); err != nil || state.State != DOWNLOADED {
+		t.Fatalf("readState = %+v, %v", state, err)
+	}
+
+	// A torn write of an older version.
+	if err := ioutil.WriteFile(storePath, []byte(`{"State":"rec`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := readState(storePath); err != nil || state.State != NOTIFICATION {
+		t.Errorf("readState of a torn state = %+v, %v, want the previous state", state, err)
+	}
+
+	os.Remove(storePath + backupSuffix)
+	if err := ioutil.WriteFile(storePath, []byte(`{"State":"rec`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readState(storePath); err == nil {
+		t.Error("readState of an unrecoverable state succeeded")
+	}
+}
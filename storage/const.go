@@ -32,11 +32,21 @@ const (
 	UNREACHABLE   = "unreachable"
 )
 
+const (
+	READ           = "read"
+	DELETED_UNREAD = "deleted-unread"
+)
+
 const (
 	NOTIFICATION = "notification"
+	DISABLED     = "disabled"
 	DOWNLOADED   = "downloaded"
 	RECEIVED     = "received"
 	RESPONDED    = "responded"
 	DRAFT        = "draft"
+	SEND_PENDING = "send-pending"
+	SENDING      = "sending"
 	SENT         = "sent"
+	FAILED       = "failed"
+	CANCELLED    = "cancelled"
 )
@@ -22,16 +22,13 @@
 package storage
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"os"
 	"path/filepath"
 	"sync"
 
-	"log"
-
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 	"launchpad.net/go-xdg/v0"
 )
 
@@ -87,26 +84,17 @@ func readContext(storePath string) (cs contextSettingMap, err error) {
 func writeContext(identity string, pc dbus.ObjectPath, storePath string) error {
 	cs, readErr := readContext(storePath)
 	if readErr != nil {
-		log.Println("Cannot read previous context state")
+		logger.Error("Cannot read previous context state")
 	}
 
-	file, err := os.Create(storePath)
+	cs[identity] = pc
+	data, err := json.Marshal(cs)
 	if err != nil {
-		log.Println(err)
+		logger.Error(err)
 		return err
 	}
-	defer func() {
-		file.Close()
-		if err != nil {
-			os.Remove(storePath)
-		}
-	}()
-	w := bufio.NewWriter(file)
-	defer w.Flush()
-	cs[identity] = pc
-	jsonWriter := json.NewEncoder(w)
-	if err := jsonWriter.Encode(cs); err != nil {
-		log.Println(err)
+	if err := writeFileAtomic(storePath, append(data, '\n'), false); err != nil {
+		logger.Error(err)
 		return err
 	}
 	return nil
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Encrypted PDUs start with encryptedHeader, followed by the nonce and the
+// AES-GCM sealed PDU. PDUs without it are stored as they are.
+const encryptedHeader = "nuntium-encrypted 1\n"
+
+// errNoKey is returned when reading an encrypted PDU without the key.
+var errNoKey = errors.New("PDU is encrypted and the storage key is not available")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts pdu with key into its stored form.
+func seal(key, pdu []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data := append([]byte(encryptedHeader), nonce...)
+	return aead.Seal(data, nonce, pdu, []byte(encryptedHeader)), nil
+}
+
+// isEncrypted returns true if the stored data is sealed.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// unseal returns the PDU stored as data, decrypting it with key if it is
+// sealed.
+func unseal(key, data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, errNoKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(encryptedHeader):]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("truncated encrypted PDU")
+	}
+	pdu, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(encryptedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt PDU: %w", err)
+	}
+	return pdu, nil
+}
+
+// runtimeDir returns where decrypted PDUs are handed to other processes, it
+// is not kept across reboots.
+func runtimeDir() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "nuntium")
+}
+
+// decryptedPath returns the path of a plain copy of the PDU stored at path,
+// which is decrypted with key unless it was decrypted before.
+func decryptedPath(key []byte, path string) (string, error) {
+	plainPath := filepath.Join(runtimeDir(), filepath.Base(path))
+	if _, err := os.Stat(plainPath); err == nil {
+		return plainPath, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	pdu, err := unseal(key, data)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(runtimeDir(), 0700); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(plainPath, pdu, false); err != nil {
+		return "", err
+	}
+	return plainPath, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	pdu := []byte("m-retrieve.conf")
+	data, err := seal(key, pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(data) || bytes.Contains(data, pdu) {
+		t.Fatalf("seal did not encrypt: %q", data)
+	}
+	if unsealed, err := unseal(key, data); err != nil || !bytes.Equal(unsealed, pdu) {
+		t.Errorf("unseal(seal(pdu)) = %q, %v", unsealed, err)
+	}
+	if unsealed, err := unseal(key, pdu); err != nil || !bytes.Equal(unsealed, pdu) {
+		t.Errorf("unseal of a plain PDU = %q, %v", unsealed, err)
+	}
+	if _, err := unseal(nil, data); err != errNoKey {
+		t.Errorf("unseal without key = %v, want %v", err, errNoKey)
+	}
+	for name, data := range map[string][]byte{
+		"truncated": data[:len(encryptedHeader)+3],
+		"corrupt":   append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^1),
+	} {
+		if _, err := unseal(key, data); err == nil {
+			t.Errorf("unseal of a %s PDU succeeded", name)
+		}
+	}
+	if _, err := unseal(bytes.Repeat([]byte{2}, 32), data); err == nil {
+		t.Error("unseal with the wrong key succeeded")
+	}
+}
+
+func TestDecryptedPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_RUNTIME_DIR", os.Getenv("XDG_RUNTIME_DIR"))
+	os.Setenv("XDG_RUNTIME_DIR", filepath.Join(dir, "run"))
+
+	key := bytes.Repeat([]byte{1}, 32)
+	pdu := []byte("m-retrieve.conf")
+	data, err := seal(key, pdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "uuid.mms")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptedPath(nil, path); err != errNoKey {
+		t.Errorf("decryptedPath without key = %v, want %v", err, errNoKey)
+	}
+	plainPath, err := decryptedPath(key, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainPath != filepath.Join(dir, "run", "nuntium", "uuid.mms") {
+		t.Errorf("decryptedPath = %s", plainPath)
+	}
+	if plain, err := ioutil.ReadFile(plainPath); err != nil || !bytes.Equal(plain, pdu) {
+		t.Errorf("decrypted copy = %q, %v", plain, err)
+	}
+}
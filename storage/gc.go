@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// GCPolicy limits the storage. Only messages the caller deems evictable are
+// collected, the oldest first: those older than MaxAge and, while the
+// storage holds more than MaxSize bytes, the least recently used. A zero
+// limit is not enforced.
+type GCPolicy struct {
+	MaxSize uint64
+	MaxAge  time.Duration
+}
+
+// GCStatus describes the storage and the last garbage collection.
+type GCStatus struct {
+	// Size is the number of bytes stored.
+	Size uint64
+	// Messages is the number of messages stored.
+	Messages int
+	// LastRun is when garbage was last collected, zero if never.
+	LastRun time.Time
+	// Collected is the number of messages collected by the last run.
+	Collected int
+	// Freed is the number of bytes freed by the last run.
+	Freed uint64
+}
+
+var (
+	gcMutex   sync.Mutex
+	gcLastRun GCStatus
+)
+
+// storedMessage is a message considered by the garbage collection.
+type storedMessage struct {
+	uuid     string
+	size     uint64
+	lastUsed time.Time
+}
+
+// usage returns the stored messages with their sizes, a message is last used
+// when its state last changed. Files which belong to no stored message, like
+// the database holding the states, count towards the size of the storage
+// only.
+func usage() (map[string]*storedMessage, error) {
+	d, err := database()
+	if err != nil {
+		return nil, err
+	}
+	messages := make(map[string]*storedMessage)
+	rows, err := d.Query(`SELECT uuid, updated FROM messages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		message := &storedMessage{}
+		var updated int64
+		if err := rows.Scan(&message.uuid, &updated); err != nil {
+			return nil, err
+		}
+		message.lastUsed = time.Unix(0, updated)
+		messages[message.uuid] = message
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	if dir, err := xdg.Data.Find(SUBPATH); err == nil {
+		dirs = append(dirs, dir)
+	}
+	if dir, err := xdg.Cache.Find(SUBPATH); err == nil {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			name := filepath.Base(path)
+			uuid := strings.SplitN(name, ".", 2)[0]
+			message, ok := messages[uuid]
+			if !ok {
+				message = &storedMessage{uuid: uuid}
+				messages[uuid] = message
+			}
+			message.size += uint64(info.Size())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// Status returns the current size of the storage and the outcome of the
+// last garbage collection.
+func (store SQLite) Status() (GCStatus, error) {
+	messages, err := usage()
+	if err != nil {
+		return GCStatus{}, err
+	}
+	gcMutex.Lock()
+	status := gcLastRun
+	gcMutex.Unlock()
+	status.Size, status.Messages = sizeOf(messages)
+	return status, nil
+}
+
+// GC collects messages as limited by policy. Only messages for which
+// evictable returns true are collected, by calling remove.
+func (store SQLite) GC(policy GCPolicy, evictable func(uuid string, mmsState MMSState) bool, remove func(uuid string) error) (GCStatus, error) {
+	gcMutex.Lock()
+	defer gcMutex.Unlock()
+
+	messages, err := usage()
+	if err != nil {
+		return GCStatus{}, err
+	}
+	gcLastRun = collect(messages, store.GetMMSState, policy, evictable, remove)
+	return gcLastRun, nil
+}
+
+// sizeOf returns the size of the storage holding messages and how many of
+// them are messages.
+func sizeOf(messages map[string]*storedMessage) (size uint64, count int) {
+	for _, message := range messages {
+		size += message.size
+		if !message.lastUsed.IsZero() {
+			count++
+		}
+	}
+	return size, count
+}
+
+// collect removes messages, whose states are read with getState, as limited
+// by policy.
+func collect(messages map[string]*storedMessage, getState func(uuid string) (MMSState, error), policy GCPolicy, evictable func(uuid string, mmsState MMSState) bool, remove func(uuid string) error) GCStatus {
+	size, count := sizeOf(messages)
+	var candidates []*storedMessage
+	for _, message := range messages {
+		if message.lastUsed.IsZero() {
+			// Not a message, e.g. the database.
+			continue
+		}
+		if mmsState, err := getState(message.uuid); err == nil && evictable(message.uuid, mmsState) {
+			candidates = append(candidates, message)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	status := GCStatus{LastRun: time.Now()}
+	for _, message := range candidates {
+		expired := policy.MaxAge > 0 && time.Since(message.lastUsed) > policy.MaxAge
+		over := policy.MaxSize > 0 && size > policy.MaxSize
+		if !expired && !over {
+			break
+		}
+		if err := remove(message.uuid); err != nil {
+			continue
+		}
+		size -= message.size
+		status.Collected++
+		status.Freed += message.size
+	}
+	status.Size = size
+	status.Messages = count - status.Collected
+	return status
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"os"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// Storage persists the messages nuntium handles: their states, the PDUs
+// exchanged for them and their journals. SQLite is the storage nuntium uses,
+// Memory keeps messages for tests. A Storage is safe for concurrent use.
+type Storage interface {
+	// Create stores a new incoming message of modemId for mNotificationInd.
+	Create(modemId string, mNotificationInd *mms.MNotificationInd) (MMSState, error)
+	// CreateSendFile stores a new outgoing DRAFT message of modemId to
+	// recipients and returns the file to write its m-send.req to.
+	CreateSendFile(modemId, uuid string, recipients []string) (*os.File, error)
+	// CreateResponseFile returns the file to write the m-notifyresp.ind of
+	// the message uuid to.
+	CreateResponseFile(uuid string) (*os.File, error)
+	// CreateReadReportFile returns the file to write the m-read-rec.ind of
+	// the message uuid to.
+	CreateReadReportFile(uuid string) (*os.File, error)
+	// Destroy removes the message uuid with all of its files and
+	// transactions.
+	Destroy(uuid string) error
+
+	UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, error)
+	// UpdateDisabled sets the NOTIFICATION message uuid aside while MMS is
+	// disabled, UpdateNotification makes it a NOTIFICATION again.
+	UpdateDisabled(uuid string) (MMSState, error)
+	UpdateNotification(uuid string) (MMSState, error)
+	UpdateDownloaded(uuid, filePath string) (MMSState, error)
+	UpdateReceived(uuid string) (MMSState, error)
+	UpdateResponded(uuid string) (MMSState, error)
+	UpdateSendPending(uuid string) (MMSState, error)
+	UpdateDraft(uuid string) (MMSState, error)
+	UpdateSending(uuid string) (MMSState, error)
+	UpdateSent(uuid, messageId string) (MMSState, error)
+	UpdateFailed(uuid string) (MMSState, error)
+	UpdateCancelled(uuid string) (MMSState, error)
+	UpdateSendState(uuid, recipient, status string) (MMSState, error)
+	UpdateReadState(uuid, recipient, status string) (MMSState, error)
+	SetTelepathyErrorNotified(uuid string) (MMSState, error)
+	SetReadReportSent(uuid string) (MMSState, error)
+	SetRead(uuid string) (MMSState, error)
+	SetQuarantined(uuid, reason string) (MMSState, error)
+	SetSpam(uuid, reason string) (MMSState, error)
+	SetSanitized(uuid string, parts []SanitizedPart) (MMSState, error)
+	SetDeliveryReportRequested(uuid string) (MMSState, error)
+	SetDecodeLog(uuid, log string) (MMSState, error)
+	SetRejectPending(uuid string) (MMSState, error)
+
+	// GetMMSState returns the state of the message uuid.
+	GetMMSState(uuid string) (MMSState, error)
+	// GetMNotificationInd returns the m-notification.ind of the message
+	// uuid if it was not downloaded yet, nil otherwise.
+	GetMNotificationInd(uuid string) *mms.MNotificationInd
+	// GetMMS returns the path of the downloaded m-retrieve.conf of the
+	// message uuid, for other processes to read.
+	GetMMS(uuid string) (string, error)
+	// ReadMMS returns the downloaded m-retrieve.conf of the message uuid.
+	ReadMMS(uuid string) ([]byte, error)
+	// WriteText stores text, the UTF-8 transcoding of the text part index of
+	// the message uuid, and returns its path for other processes to read.
+	WriteText(uuid string, index int, text string) (string, error)
+	// WritePart stores data, the unwrapped content of the DRM protected
+	// part index of the message uuid, and returns its path for other
+	// processes to read.
+	WritePart(uuid string, index int, data []byte) (string, error)
+	// GetSendFile returns the path of the m-send.req of the message uuid.
+	GetSendFile(uuid string) (string, error)
+	// GetStoredUUIDs returns the UUIDs of all messages, oldest first.
+	GetStoredUUIDs() []string
+	// GetModemUUIDs returns the UUIDs of the messages of modemId in one of
+	// states, or in any state if none are given, oldest first.
+	GetModemUUIDs(modemId string, states ...string) []string
+	// FindSent returns the UUID of the SENT message with messageId.
+	FindSent(messageId string) (string, error)
+
+	// SetTransaction maps transactionId, of an incoming message not yet
+	// acknowledged to the MMS center, to the message uuid.
+	SetTransaction(transactionId, uuid string) error
+	// TrackTransaction maps transactionId to the message uuid unless it maps
+	// to another message which is still stored, and returns the UUID it
+	// maps to.
+	TrackTransaction(transactionId, uuid string) (string, error)
+	// RemoveTransaction forgets transactionId once its message was
+	// acknowledged to the MMS center.
+	RemoveTransaction(transactionId string) error
+	// GetTransaction returns the UUID transactionId maps to, empty if it is
+	// not tracked.
+	GetTransaction(transactionId string) (string, error)
+	// GetTransactions returns the transactions of the stored messages of
+	// modemId, mapping transaction ids to UUIDs.
+	GetTransactions(modemId string) (map[string]string, error)
+
+	// AppendJournal appends an entry for event with details to the journal
+	// of the message uuid.
+	AppendJournal(uuid, event string, details map[string]string) error
+	// GetJournal returns the journal of the message uuid, oldest entry
+	// first.
+	GetJournal(uuid string) ([]JournalEntry, error)
+
+	// GC collects messages as limited by policy, see GCPolicy.
+	GC(policy GCPolicy, evictable func(uuid string, mmsState MMSState) bool, remove func(uuid string) error) (GCStatus, error)
+	// Status returns the size of the storage and the outcome of the last
+	// garbage collection.
+	Status() (GCStatus, error)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+// JournalEntry is a step in the handling of a message. The journal of a
+// message is its audit trail, kept to be reviewed in carrier support cases.
+type JournalEntry struct {
+	Time    time.Time
+	Event   string
+	Details map[string]string `json:",omitempty"`
+}
+
+func journalPath(uuid string) string {
+	return path.Join(SUBPATH, uuid+".journal")
+}
+
+// AppendJournal appends an entry for event with details to the journal of
+// the message with uuid.
+func (store SQLite) AppendJournal(uuid, event string, details map[string]string) error {
+	data, err := json.Marshal(JournalEntry{time.Now().UTC(), event, details})
+	if err != nil {
+		return err
+	}
+	filePath, err := xdg.Data.Ensure(journalPath(uuid))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// GetJournal returns the journal of the message with uuid, oldest entry
+// first. A message without a journal yields no entries and no error.
+func (store SQLite) GetJournal(uuid string) ([]JournalEntry, error) {
+	filePath, err := xdg.Data.Find(journalPath(uuid))
+	if err != nil {
+		return nil, nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("cannot parse journal %s: %w", filePath, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
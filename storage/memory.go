@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// Memory is a Storage which keeps message states and journals in memory and
+// the PDUs in a directory, for tests. States are stored encoded like in
+// SQLite, so callers get copies which don't change with the stored state.
+type Memory struct {
+	dir          string
+	lock         sync.Mutex
+	messages     map[string]*memoryMessage
+	transactions map[string]string // transaction id: UUID
+	created      int
+	gcLock       sync.Mutex
+	gcStatus     GCStatus
+}
+
+type memoryMessage struct {
+	data    []byte
+	state   MMSState // decoded data, to look messages up by
+	created int
+	updated time.Time
+	journal []JournalEntry
+}
+
+// NewMemory creates an empty Memory which keeps PDUs in dir.
+func NewMemory(dir string) *Memory {
+	return &Memory{dir: dir, messages: make(map[string]*memoryMessage), transactions: make(map[string]string)}
+}
+
+func (store *Memory) path(uuid, suffix string) string {
+	return filepath.Join(store.dir, uuid+suffix)
+}
+
+// put stores state under uuid, store.lock must be held.
+func (store *Memory) put(uuid string, state MMSState) error {
+	data, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+	message, ok := store.messages[uuid]
+	if !ok {
+		store.created++
+		message = &memoryMessage{created: store.created}
+		store.messages[uuid] = message
+	}
+	message.data, message.state, message.updated = data, state, time.Now()
+	return nil
+}
+
+// get returns the state stored under uuid, store.lock must be held.
+func (store *Memory) get(uuid string) (MMSState, error) {
+	message, ok := store.messages[uuid]
+	if !ok {
+		return MMSState{}, fmt.Errorf("%s: %w", uuid, errNotStored)
+	}
+	return decodeState(message.data)
+}
+
+// update applies change to the state of the stored message uuid, unless
+// debugError is forced by its m-notification.ind.
+func (store *Memory) update(uuid, debugError string, change func(*MMSState) error) (MMSState, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	oldState, err := store.get(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+	if debugError != "" {
+		if err := oldState.MNotificationInd.PopDebugError(debugError); err != nil {
+			logger.Errorf("Forcing debug error: %#v", err)
+			store.put(uuid, oldState)
+			return oldState, err
+		}
+	}
+	newState, _ := store.get(uuid)
+	if err := change(&newState); err != nil {
+		return oldState, err
+	}
+	if err := store.put(uuid, newState); err != nil {
+		return oldState, err
+	}
+	return newState, nil
+}
+
+// uuids returns the UUIDs of the messages for which match returns true,
+// oldest first.
+func (store *Memory) uuids(match func(MMSState) bool) []string {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	byCreation := make(map[int]string)
+	for uuid, message := range store.messages {
+		if match(message.state) {
+			byCreation[message.created] = uuid
+		}
+	}
+	var uuids []string
+	for created := 1; created <= store.created; created++ {
+		if uuid, ok := byCreation[created]; ok {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func (store *Memory) Create(modemId string, mNotificationInd *mms.MNotificationInd) (MMSState, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	state := notificationState(modemId, mNotificationInd)
+	if err := store.put(mNotificationInd.UUID, state); err != nil {
+		return MMSState{}, err
+	}
+	return state, nil
+}
+
+func (store *Memory) CreateSendFile(modemId, uuid string, recipients []string) (*os.File, error) {
+	store.lock.Lock()
+	err := store.put(uuid, draftState(modemId, recipients))
+	store.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(store.path(uuid, ".m-send.req"))
+}
+
+func (store *Memory) createFile(uuid, suffix string) (*os.File, error) {
+	if _, err := store.GetMMSState(uuid); err != nil {
+		return nil, fmt.Errorf("error retrieving message state: %w", err)
+	}
+	return os.Create(store.path(uuid, suffix))
+}
+
+func (store *Memory) CreateResponseFile(uuid string) (*os.File, error) {
+	return store.createFile(uuid, ".m-notifyresp.ind")
+}
+
+func (store *Memory) CreateReadReportFile(uuid string) (*os.File, error) {
+	return store.createFile(uuid, ".m-read-rec.ind")
+}
+
+func (store *Memory) Destroy(uuid string) error {
+	errs := Multierror{}
+	store.lock.Lock()
+	if _, ok := store.messages[uuid]; ok {
+		delete(store.messages, uuid)
+	} else {
+		errs = append(errs, fmt.Errorf("%s: %w", uuid, errNotStored))
+	}
+	for transactionId, transactionUUID := range store.transactions {
+		if transactionUUID == uuid {
+			delete(store.transactions, transactionId)
+		}
+	}
+	store.lock.Unlock()
+	for _, suffix := range []string{".mms", ".m-notifyresp.ind", ".m-read-rec.ind", ".m-send.req"} {
+		path := store.path(uuid, suffix)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, ErrorRemovingFile{path, err})
+		}
+	}
+	texts, _ := filepath.Glob(filepath.Join(store.dir, textName(uuid, -1)))
+	parts, _ := filepath.Glob(filepath.Join(store.dir, partName(uuid, -1)))
+	for _, path := range append(texts, parts...) {
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, ErrorRemovingFile{path, err})
+		}
+	}
+	return errs.Result()
+}
+
+func (store *Memory) UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, error) {
+	return store.update(mNotificationInd.UUID, "", func(state *MMSState) error {
+		state.MNotificationInd = mNotificationInd
+		return nil
+	})
+}
+
+func (store *Memory) UpdateDisabled(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = DISABLED
+		return nil
+	})
+}
+
+func (store *Memory) UpdateNotification(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = NOTIFICATION
+		return nil
+	})
+}
+
+func (store *Memory) UpdateDownloaded(uuid, filePath string) (MMSState, error) {
+	return store.update(uuid, mms.DebugErrorDownloadStorage, func(state *MMSState) error {
+		state.State = DOWNLOADED
+		return os.Rename(filePath, store.path(uuid, ".mms"))
+	})
+}
+
+func (store *Memory) UpdateReceived(uuid string) (MMSState, error) {
+	return store.update(uuid, mms.DebugErrorReceiveStorage, func(state *MMSState) error {
+		state.State = RECEIVED
+		return nil
+	})
+}
+
+func (store *Memory) UpdateResponded(uuid string) (MMSState, error) {
+	return store.update(uuid, mms.DebugErrorRespondStorage, func(state *MMSState) error {
+		state.State = RESPONDED
+		return nil
+	})
+}
+
+func (store *Memory) UpdateSendPending(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = SEND_PENDING
+		state.SendAttempts++
+		return nil
+	})
+}
+
+func (store *Memory) UpdateDraft(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = DRAFT
+		state.SendAttempts = 0
+		return nil
+	})
+}
+
+func (store *Memory) UpdateSending(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = SENDING
+		return nil
+	})
+}
+
+func (store *Memory) UpdateFailed(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = FAILED
+		return nil
+	})
+}
+
+func (store *Memory) UpdateSent(uuid, messageId string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = SENT
+		state.Id = messageId
+		return nil
+	})
+}
+
+func (store *Memory) UpdateCancelled(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.State = CANCELLED
+		return nil
+	})
+}
+
+func (store *Memory) UpdateSendState(uuid, recipient, status string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		if state.SendState == nil {
+			state.SendState = SendInfo{}
+		}
+		state.SendState[recipient] = status
+		return nil
+	})
+}
+
+func (store *Memory) UpdateReadState(uuid, recipient, status string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		if state.ReadState == nil {
+			state.ReadState = make(map[string]string)
+		}
+		state.ReadState[recipient] = status
+		return nil
+	})
+}
+
+func (store *Memory) SetTelepathyErrorNotified(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.TelepathyErrorNotified = true
+		return nil
+	})
+}
+
+func (store *Memory) SetReadReportSent(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.ReadReportSent = true
+		return nil
+	})
+}
+
+func (store *Memory) SetRead(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.Read = true
+		return nil
+	})
+}
+
+func (store *Memory) SetDeliveryReportRequested(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.DeliveryReportRequested = true
+		return nil
+	})
+}
+
+func (store *Memory) SetDecodeLog(uuid, log string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.DecodeLog = log
+		return nil
+	})
+}
+
+func (store *Memory) SetRejectPending(uuid string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.RejectPending = true
+		return nil
+	})
+}
+
+func (store *Memory) SetQuarantined(uuid, reason string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.Quarantined = true
+		state.QuarantineReason = reason
+		return nil
+	})
+}
+
+func (store *Memory) SetSpam(uuid, reason string) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.Spam = true
+		state.SpamReason = reason
+		return nil
+	})
+}
+
+func (store *Memory) SetSanitized(uuid string, parts []SanitizedPart) (MMSState, error) {
+	return store.update(uuid, "", func(state *MMSState) error {
+		state.SanitizedParts = append([]SanitizedPart(nil), parts...)
+		return nil
+	})
+}
+
+func (store *Memory) GetMMSState(uuid string) (MMSState, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.get(uuid)
+}
+
+func (store *Memory) GetMNotificationInd(uuid string) *mms.MNotificationInd {
+	mmsState, err := store.GetMMSState(uuid)
+	if err != nil || mmsState.State != NOTIFICATION {
+		return nil
+	}
+	return mmsState.MNotificationInd
+}
+
+func (store *Memory) existing(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (store *Memory) GetMMS(uuid string) (string, error) {
+	return store.existing(store.path(uuid, ".mms"))
+}
+
+func (store *Memory) ReadMMS(uuid string) ([]byte, error) {
+	return ioutil.ReadFile(store.path(uuid, ".mms"))
+}
+
+func (store *Memory) WriteText(uuid string, index int, text string) (string, error) {
+	if _, err := store.GetMMSState(uuid); err != nil {
+		return "", fmt.Errorf("error retrieving message state: %w", err)
+	}
+	path := filepath.Join(store.dir, textName(uuid, index))
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (store *Memory) WritePart(uuid string, index int, data []byte) (string, error) {
+	if _, err := store.GetMMSState(uuid); err != nil {
+		return "", fmt.Errorf("error retrieving message state: %w", err)
+	}
+	path := filepath.Join(store.dir, partName(uuid, index))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (store *Memory) GetSendFile(uuid string) (string, error) {
+	return store.existing(store.path(uuid, ".m-send.req"))
+}
+
+func (store *Memory) GetStoredUUIDs() []string {
+	return store.uuids(func(MMSState) bool { return true })
+}
+
+func (store *Memory) GetModemUUIDs(modemId string, states ...string) []string {
+	return store.uuids(func(state MMSState) bool {
+		if state.ModemId != modemId {
+			return false
+		}
+		for _, s := range states {
+			if state.State == s {
+				return true
+			}
+		}
+		return len(states) == 0
+	})
+}
+
+func (store *Memory) FindSent(messageId string) (string, error) {
+	if messageId == "" {
+		return "", fmt.Errorf("empty message id")
+	}
+	uuids := store.uuids(func(state MMSState) bool {
+		return state.State == SENT && state.Id == messageId
+	})
+	if len(uuids) == 0 {
+		return "", fmt.Errorf("no sent message with message id %s", messageId)
+	}
+	return uuids[0], nil
+}
+
+func (store *Memory) SetTransaction(transactionId, uuid string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.transactions[transactionId] = uuid
+	return nil
+}
+
+func (store *Memory) TrackTransaction(transactionId, uuid string) (string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if tracked, ok := store.transactions[transactionId]; ok {
+		if _, stored := store.messages[tracked]; stored {
+			return tracked, nil
+		}
+	}
+	store.transactions[transactionId] = uuid
+	return uuid, nil
+}
+
+func (store *Memory) RemoveTransaction(transactionId string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.transactions, transactionId)
+	return nil
+}
+
+func (store *Memory) GetTransaction(transactionId string) (string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.transactions[transactionId], nil
+}
+
+func (store *Memory) GetTransactions(modemId string) (map[string]string, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	transactions := make(map[string]string)
+	for transactionId, uuid := range store.transactions {
+		if message, ok := store.messages[uuid]; ok && message.state.ModemId == modemId {
+			transactions[transactionId] = uuid
+		}
+	}
+	return transactions, nil
+}
+
+func (store *Memory) AppendJournal(uuid, event string, details map[string]string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	message, ok := store.messages[uuid]
+	if !ok {
+		return fmt.Errorf("%s: %w", uuid, errNotStored)
+	}
+	message.journal = append(message.journal, JournalEntry{time.Now().UTC(), event, details})
+	return nil
+}
+
+func (store *Memory) GetJournal(uuid string) ([]JournalEntry, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if message, ok := store.messages[uuid]; ok {
+		return append([]JournalEntry(nil), message.journal...), nil
+	}
+	return nil, nil
+}
+
+// usage returns the stored messages with the size of their states and PDUs.
+func (store *Memory) usage() map[string]*storedMessage {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	messages := make(map[string]*storedMessage)
+	for uuid, message := range store.messages {
+		stored := &storedMessage{uuid: uuid, size: uint64(len(message.data)), lastUsed: message.updated}
+		for _, suffix := range []string{".mms", ".m-notifyresp.ind", ".m-read-rec.ind", ".m-send.req"} {
+			if info, err := os.Stat(store.path(uuid, suffix)); err == nil {
+				stored.size += uint64(info.Size())
+			}
+		}
+		messages[uuid] = stored
+	}
+	return messages
+}
+
+func (store *Memory) GC(policy GCPolicy, evictable func(uuid string, mmsState MMSState) bool, remove func(uuid string) error) (GCStatus, error) {
+	store.gcLock.Lock()
+	defer store.gcLock.Unlock()
+	store.gcStatus = collect(store.usage(), store.GetMMSState, policy, evictable, remove)
+	return store.gcStatus, nil
+}
+
+func (store *Memory) Status() (GCStatus, error) {
+	store.gcLock.Lock()
+	status := store.gcStatus
+	store.gcLock.Unlock()
+	status.Size, status.Messages = sizeOf(store.usage())
+	return status, nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func TestMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var store Storage = NewMemory(dir)
+
+	mNotificationInd := &mms.MNotificationInd{UUID: "in", TransactionId: "tid", ContentLocation: "http://mmsc/1"}
+	if _, err := store.Create("modem", mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.CreateSendFile("modem", "out", []string{"+1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if state, err := store.SetDeliveryReportRequested("out"); err != nil || !state.DeliveryReportRequested {
+		t.Fatalf("SetDeliveryReportRequested = %+v, %v", state, err)
+	}
+	if _, err := store.UpdateSent("out", "mid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if uuids := store.GetModemUUIDs("modem"); !reflect.DeepEqual(uuids, []string{"in", "out"}) {
+		t.Errorf("GetModemUUIDs = %v", uuids)
+	}
+	if uuids := store.GetModemUUIDs("modem", NOTIFICATION); !reflect.DeepEqual(uuids, []string{"in"}) {
+		t.Errorf("GetModemUUIDs(NOTIFICATION) = %v", uuids)
+	}
+	if state, err := store.UpdateDisabled("in"); err != nil || state.State != DISABLED || !state.IsIncoming() {
+		t.Fatalf("UpdateDisabled = %+v, %v", state, err)
+	}
+	if uuids := store.GetModemUUIDs("modem", NOTIFICATION); len(uuids) != 0 {
+		t.Errorf("GetModemUUIDs(NOTIFICATION) of a disabled message = %v", uuids)
+	}
+	if state, err := store.UpdateNotification("in"); err != nil || state.State != NOTIFICATION {
+		t.Fatalf("UpdateNotification = %+v, %v", state, err)
+	}
+	if state, err := store.SetRejectPending("in"); err != nil || !state.RejectPending || state.State != NOTIFICATION {
+		t.Fatalf("SetRejectPending = %+v, %v", state, err)
+	}
+	if uuid, err := store.FindSent("mid"); err != nil || uuid != "out" {
+		t.Errorf("FindSent = %q, %v", uuid, err)
+	}
+
+	f, err = store.CreateSendFile("modem", "failed", []string{"+1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if state, err := store.UpdateSending("failed"); err != nil || state.State != SENDING {
+		t.Fatalf("UpdateSending = %+v, %v", state, err)
+	}
+	if state, err := store.UpdateFailed("failed"); err != nil || state.State != FAILED {
+		t.Fatalf("UpdateFailed = %+v, %v", state, err)
+	}
+	if uuids := store.GetModemUUIDs("modem", DRAFT, SENDING, FAILED); !reflect.DeepEqual(uuids, []string{"failed"}) {
+		t.Errorf("GetModemUUIDs(DRAFT, SENDING, FAILED) = %v", uuids)
+	}
+
+	// Returned states are copies.
+	state, _ := store.GetMMSState("out")
+	state.SendState["+1"] = RETRIEVED
+	if state, _ := store.GetMMSState("out"); state.SendState["+1"] != NONE {
+		t.Errorf("stored send state changed to %q", state.SendState["+1"])
+	}
+
+	pdu := filepath.Join(dir, "download")
+	if err := ioutil.WriteFile(pdu, []byte("pdu"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := store.UpdateDownloaded("in", pdu); err != nil || state.State != DOWNLOADED {
+		t.Fatalf("UpdateDownloaded = %+v, %v", state, err)
+	}
+	if state, err := store.SetSpam("in", "text is only links"); err != nil || !state.Spam || state.SpamReason != "text is only links" || state.State != DOWNLOADED {
+		t.Fatalf("SetSpam = %+v, %v", state, err)
+	}
+	parts := []SanitizedPart{{Id: "<app>", MediaType: "application/vnd.android.package-archive"}}
+	if state, err := store.SetSanitized("in", parts); err != nil || !state.Sanitized("<app>", "application/vnd.android.package-archive") || state.Sanitized("<app>", "image/jpeg") {
+		t.Fatalf("SetSanitized = %+v, %v", state, err)
+	}
+	if state, err := store.SetRead("in"); err != nil || !state.Read || state.State != DOWNLOADED {
+		t.Fatalf("SetRead = %+v, %v", state, err)
+	}
+	if state, err := store.SetDecodeLog("in", "Expecting header\n"); err != nil || state.DecodeLog != "Expecting header\n" || state.State != DOWNLOADED {
+		t.Fatalf("SetDecodeLog = %+v, %v", state, err)
+	}
+	if path, err := store.GetMMS("in"); err != nil || path != filepath.Join(dir, "in.mms") {
+		t.Errorf("GetMMS = %q, %v", path, err)
+	}
+	text, err := store.WriteText("in", 1, "héllo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(text); err != nil || string(b) != "héllo" {
+		t.Errorf("WriteText wrote %q, %v", b, err)
+	}
+
+	if uuid, err := store.TrackTransaction("tid", "in"); err != nil || uuid != "in" {
+		t.Fatalf("TrackTransaction = %q, %v", uuid, err)
+	}
+	if uuid, err := store.TrackTransaction("tid", "repeated"); err != nil || uuid != "in" {
+		t.Errorf("TrackTransaction of a stored message = %q, %v", uuid, err)
+	}
+	if transactions, err := store.GetTransactions("modem"); err != nil || !reflect.DeepEqual(transactions, map[string]string{"tid": "in"}) {
+		t.Errorf("GetTransactions = %v, %v", transactions, err)
+	}
+
+	if err := store.Destroy("in"); err != nil {
+		t.Fatal(err)
+	}
+	if uuid, err := store.GetTransaction("tid"); err != nil || uuid != "" {
+		t.Errorf("transaction of destroyed message = %q, %v", uuid, err)
+	}
+	if _, err := os.Stat(text); !os.IsNotExist(err) {
+		t.Error("text part of destroyed message is still stored")
+	}
+	if _, err := store.GetMMSState("in"); err == nil {
+		t.Error("destroyed message is still stored")
+	}
+	if _, err := store.GetMMS("in"); err == nil {
+		t.Error("PDU of destroyed message is still stored")
+	}
+	if err := store.Destroy("in"); err == nil {
+		t.Error("destroying a message twice succeeded")
+	}
+}
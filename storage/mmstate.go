@@ -37,36 +37,93 @@ type SendInfo map[string]string
 
 //Status represents an MMS' state
 //
-// Id represents the transaction ID for the MMS if using delivery request reports
+// Id represents the transaction ID for the MMS if using delivery request reports,
+// for SENT messages it is the Message-ID given by the MMS center, which delivery reports refer to.
 //
 // State can be:
 // - For incoming messages:
 //   - NOTIFICATION : m-Notify.Ind PDU not yet downloaded.
+//   - DISABLED     : m-Notify.Ind PDU received while MMS was disabled, handled once it is enabled.
 //   - DOWNLOADED   : m-Retrieve.Conf PDU downloaded, but not yet communicated to telepathy or acknowledged to MMS provider.
 //   - RECEIVED     : m-Retrieve.Conf PDU downloaded and successfully communicated to telepathy, but not acknowledged to MMS provider.
 //   - RESPONDED    : m-Retrieve.Conf PDU downloaded and successfully communicated to telepathy and acknowledged to MMS provider.
 // - For outgoing messages:
-//   - DRAFT : m-Send.Req PDU ready for sending.
-//   - SENT  : m-Send.Req PDU successfully sent.
+//   - DRAFT        : m-Send.Req PDU ready for sending.
+//   - SEND_PENDING : m-Send.Req PDU upload failed and is retried.
+//   - SENDING      : m-Send.Req PDU being uploaded or waiting for the network.
+//   - SENT         : m-Send.Req PDU successfully sent.
+//   - FAILED       : m-Send.Req PDU not sent and not retried anymore.
+//   - CANCELLED    : m-Send.Req PDU not sent, the user cancelled it.
 //
 // SendState contains the sent state for each delivered message associated to
 // a particular MMS
 //
 // ModemId represents ID of modem to which the message belongs
 //
+// SendAttempts counts the failed uploads of an outgoing message.
+//
 // MNotificationInd holds the received m-Notify.Ind until PDU downloaded (is not nil when State is "notification").
 //
 // TelepathyErrorNotified holds information whether telepathy-ofono was notified of some message handling error.
+//
+// ReadState contains for each recipient who sent a read report whether the
+// message was READ or DELETED_UNREAD.
+//
+// ReadReportSent is set once the read report of an incoming message was sent.
+//
+// Read is set once the user read an incoming message.
+//
+// Quarantined is set when a content processor held the message back from telepathy, QuarantineReason tells why.
+//
+// Spam is set for a suspicious message from an unknown sender, which telepathy files in a spam folder, SpamReason tells why.
+//
+// SanitizedParts lists the data parts of a received message which are not passed on to telepathy as their media type is not allowed.
+//
+// DeliveryReportRequested is set for an outgoing message sent requesting a delivery report.
+//
+// DecodeLog holds the decoder log of a downloaded message which could not be passed on to telepathy.
+//
+// RejectPending is set on a message the user deleted before it was downloaded while its rejection could not be sent to the MMS center, it is removed once it is.
 type MMSState struct {
-	Id                     string
-	State                  string
-	ContentLocation        string
-	SendState              SendInfo
-	ModemId                string
-	MNotificationInd       *mms.MNotificationInd
-	TelepathyErrorNotified bool
+	Id                      string
+	State                   string
+	ContentLocation         string
+	SendState               SendInfo
+	ModemId                 string
+	MNotificationInd        *mms.MNotificationInd
+	TelepathyErrorNotified  bool
+	SendAttempts            int               `json:",omitempty"`
+	ReadState               map[string]string `json:",omitempty"`
+	ReadReportSent          bool              `json:",omitempty"`
+	Read                    bool              `json:",omitempty"`
+	Quarantined             bool              `json:",omitempty"`
+	QuarantineReason        string            `json:",omitempty"`
+	Spam                    bool              `json:",omitempty"`
+	SpamReason              string            `json:",omitempty"`
+	SanitizedParts          []SanitizedPart   `json:",omitempty"`
+	DeliveryReportRequested bool              `json:",omitempty"`
+	DecodeLog               string            `json:",omitempty"`
+	RejectPending           bool              `json:",omitempty"`
+}
+
+// SanitizedPart is a data part of a received message which was removed,
+// identified by its Content-ID and media type.
+type SanitizedPart struct {
+	Id        string
+	MediaType string
+}
+
+// Sanitized returns true if the data part with Content-ID id and mediaType
+// was removed.
+func (m MMSState) Sanitized(id, mediaType string) bool {
+	for _, part := range m.SanitizedParts {
+		if part.Id == id && part.MediaType == mediaType {
+			return true
+		}
+	}
+	return false
 }
 
 func (m MMSState) IsIncoming() bool {
-	return m.State == NOTIFICATION || m.State == DOWNLOADED || m.State == RECEIVED || m.State == RESPONDED
+	return m.State == NOTIFICATION || m.State == DISABLED || m.State == DOWNLOADED || m.State == RECEIVED || m.State == RESPONDED
 }
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"launchpad.net/go-xdg/v0"
+)
+
+// databaseName is the SQLite database holding the message states, in SUBPATH
+// of the XDG data directory. The PDUs and journals stay files next to it.
+const databaseName = "messages.sqlite"
+
+// schemaVersion is the version of the database schema, kept in the
+// user_version of the database.
+const schemaVersion = 2
+
+// schema creates the messages and transactions tables. Besides the encoded
+// state in data, the columns of messages hold what messages are looked up by.
+// Timestamps are in nanoseconds since the epoch. transactions maps the
+// transaction ids of incoming messages not yet acknowledged to the MMS center
+// to the UUIDs of their messages.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		uuid TEXT PRIMARY KEY,
+		modem_id TEXT NOT NULL,
+		state TEXT NOT NULL,
+		transaction_id TEXT NOT NULL,
+		data BLOB NOT NULL,
+		created INTEGER NOT NULL,
+		updated INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS messages_modem_id ON messages (modem_id, created)`,
+	`CREATE INDEX IF NOT EXISTS messages_state ON messages (state)`,
+	`CREATE INDEX IF NOT EXISTS messages_transaction_id ON messages (transaction_id)`,
+	`CREATE TABLE IF NOT EXISTS transactions (
+		transaction_id TEXT PRIMARY KEY,
+		uuid TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS transactions_uuid ON transactions (uuid)`,
+}
+
+// unrespondedStates are the states of incoming messages which were not yet
+// acknowledged to the MMS center, the transactions of messages stored before
+// schema version 2 in them are tracked on migration.
+var unrespondedStates = []string{NOTIFICATION, DOWNLOADED, RECEIVED}
+
+// SQLite is the Storage nuntium uses. Message states are kept in a SQLite
+// database in the XDG data directory, the PDUs and journals in files next to
+// it or, for outgoing PDUs, in the XDG cache directory. All SQLite values
+// share the same database.
+type SQLite struct {
+	// Key is the AES-256 key encrypted PDUs are read with.
+	Key []byte
+	// Encrypt stores the PDUs of downloaded messages encrypted with Key.
+	// They are decrypted to the runtime directory for GetMMS.
+	Encrypt bool
+}
+
+// errNotStored is returned for a message which is not in the database.
+var errNotStored = errors.New("message not stored")
+
+// stateMutex serializes the updates of stored message states, so mediators of
+// several modems sharing the storage don't lose each others updates.
+var stateMutex sync.Mutex
+
+var (
+	dbMutex sync.Mutex
+	db      *sql.DB
+)
+
+// database returns the message database. It is opened on first use, which
+// also migrates the states stored by older versions.
+func database() (*sql.DB, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db != nil {
+		return db, nil
+	}
+	dbPath, err := xdg.Data.Ensure(path.Join(SUBPATH, databaseName))
+	if err != nil {
+		return nil, err
+	}
+	opened, err := openDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	migrateLegacy(opened, filepath.Dir(dbPath))
+	db = opened
+	return db, nil
+}
+
+// Close checkpoints and closes the message database, so nothing is left to
+// recover on the next start. It waits for the updates in progress, the
+// database is opened again on its next use.
+func Close() error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db == nil {
+		return nil
+	}
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		logger.Warn("Cannot checkpoint the message database: ", err)
+	}
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// openDatabase opens the database at dbPath and creates its schema.
+func openDatabase(dbPath string) (*sql.DB, error) {
+	d, err := sql.Open("sqlite3", "file:"+dbPath+"?_busy_timeout=5000&_journal_mode=WAL&_synchronous=FULL")
+	if err != nil {
+		return nil, err
+	}
+	// Updates are serialized by stateMutex anyway, a single connection
+	// avoids busy errors between connections.
+	d.SetMaxOpenConns(1)
+	var version int
+	if err := d.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("cannot open %s: %w", dbPath, err)
+	}
+	if version > schemaVersion {
+		d.Close()
+		return nil, fmt.Errorf("cannot open %s: unsupported schema version %d", dbPath, version)
+	}
+	for _, statement := range schema {
+		if _, err := d.Exec(statement); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("cannot create schema of %s: %w", dbPath, err)
+		}
+	}
+	if version < 2 {
+		// Older versions kept the transactions in memory only and
+		// guessed them from the stored messages on start.
+		_, err := d.Exec(`INSERT OR IGNORE INTO transactions (transaction_id, uuid)
+			SELECT transaction_id, uuid FROM messages WHERE transaction_id != '' AND state IN (?, ?, ?) ORDER BY created, rowid`,
+			unrespondedStates[0], unrespondedStates[1], unrespondedStates[2])
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("cannot migrate transactions of %s: %w", dbPath, err)
+		}
+	}
+	if _, err := d.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("cannot create schema of %s: %w", dbPath, err)
+	}
+	return d, nil
+}
+
+// migrateLegacy moves the states older versions stored in a .db file per
+// message in dir into the database and removes the files. A state which
+// cannot be read is kept as it is, so it is reported and handled like any
+// other faulty message.
+func migrateLegacy(d *sql.DB, dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.db"))
+	if err != nil || len(paths) == 0 {
+		return
+	}
+	logger.Infof("Migrating %d messages to %s", len(paths), databaseName)
+	for _, storePath := range paths {
+		uuid := strings.TrimSuffix(filepath.Base(storePath), ".db")
+		info, err := os.Stat(storePath)
+		if err != nil {
+			continue
+		}
+		ctime := info.ModTime()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			ctime = time.Unix(stat.Ctim.Unix())
+		}
+		var data []byte
+		var mmsState MMSState
+		if mmsState, err = readState(storePath); err == nil {
+			data, err = encodeState(mmsState)
+		} else {
+			logger.Errorf("Cannot read state %s, keeping it as is: %v", storePath, err)
+			data, err = ioutil.ReadFile(storePath)
+		}
+		if err != nil {
+			logger.Errorf("Cannot migrate %s: %v", storePath, err)
+			continue
+		}
+		_, err = d.Exec(`INSERT OR IGNORE INTO messages (uuid, modem_id, state, transaction_id, data, created, updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			uuid, mmsState.ModemId, mmsState.State, mmsState.Id, data, ctime.UnixNano(), info.ModTime().UnixNano())
+		if err != nil {
+			logger.Errorf("Cannot migrate %s: %v", storePath, err)
+			continue
+		}
+		if mmsState.Id != "" && isUnresponded(mmsState.State) {
+			if _, err := d.Exec(`INSERT OR IGNORE INTO transactions (transaction_id, uuid) VALUES (?, ?)`, mmsState.Id, uuid); err != nil {
+				logger.Errorf("Cannot migrate transaction of %s: %v", storePath, err)
+			}
+		}
+		for _, suffix := range []string{"", tmpSuffix, backupSuffix} {
+			if err := os.Remove(storePath + suffix); err != nil && !os.IsNotExist(err) {
+				logger.Errorf("Cannot remove migrated %s: %v", storePath+suffix, err)
+			}
+		}
+	}
+}
+
+// insertState stores the state of the new message uuid, created at created.
+// A message already stored under uuid is replaced but keeps its creation
+// time.
+func insertState(d *sql.DB, uuid string, state MMSState, created time.Time) error {
+	data, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`INSERT INTO messages (uuid, modem_id, state, transaction_id, data, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET modem_id = excluded.modem_id, state = excluded.state,
+			transaction_id = excluded.transaction_id, data = excluded.data, updated = excluded.updated`,
+		uuid, state.ModemId, state.State, state.Id, data, created.UnixNano(), time.Now().UnixNano())
+	return err
+}
+
+// updateState replaces the state of the stored message uuid.
+func updateState(d *sql.DB, uuid string, state MMSState) error {
+	data, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+	result, err := d.Exec(`UPDATE messages SET modem_id = ?, state = ?, transaction_id = ?, data = ?, updated = ? WHERE uuid = ?`,
+		state.ModemId, state.State, state.Id, data, time.Now().UnixNano(), uuid)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", uuid, errNotStored)
+	}
+	return nil
+}
+
+// selectState returns the state of the stored message uuid.
+func selectState(d *sql.DB, uuid string) (MMSState, error) {
+	var data []byte
+	if err := d.QueryRow(`SELECT data FROM messages WHERE uuid = ?`, uuid).Scan(&data); err == sql.ErrNoRows {
+		return MMSState{}, fmt.Errorf("%s: %w", uuid, errNotStored)
+	} else if err != nil {
+		return MMSState{}, err
+	}
+	return decodeState(data)
+}
+
+// deleteState removes the state and the transactions of the stored message
+// uuid.
+func deleteState(d *sql.DB, uuid string) error {
+	if _, err := d.Exec(`DELETE FROM transactions WHERE uuid = ?`, uuid); err != nil {
+		return err
+	}
+	result, err := d.Exec(`DELETE FROM messages WHERE uuid = ?`, uuid)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s: %w", uuid, errNotStored)
+	}
+	return nil
+}
+
+// selectUUIDs returns the UUIDs of the stored messages matching the SQL
+// condition where, sorted by creation date ascending.
+func selectUUIDs(d *sql.DB, where string, args ...interface{}) ([]string, error) {
+	rows, err := d.Query(`SELECT uuid FROM messages WHERE `+where+` ORDER BY created, rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+	return uuids, rows.Err()
+}
+
+// storeState replaces the state of the stored message uuid in the database.
+func storeState(uuid string, state MMSState) error {
+	d, err := database()
+	if err != nil {
+		return err
+	}
+	return updateState(d, uuid, state)
+}
+
+// Returns the UUIDs of the stored messages which belong to the modem modemId,
+// sorted by creation date ascending. If states are given, only messages in
+// one of them are returned. An empty modemId returns the messages which
+// belong to no modem, including those whose state cannot be read.
+func (store SQLite) GetModemUUIDs(modemId string, states ...string) []string {
+	d, err := database()
+	if err != nil {
+		logger.Errorf("Cannot open storage: %v", err)
+		return nil
+	}
+	where, args := "modem_id = ?", []interface{}{modemId}
+	if len(states) > 0 {
+		where += " AND state IN (?" + strings.Repeat(", ?", len(states)-1) + ")"
+		for _, state := range states {
+			args = append(args, state)
+		}
+	}
+	uuids, err := selectUUIDs(d, where, args...)
+	if err != nil {
+		logger.Errorf("Cannot query messages of modem %s: %v", modemId, err)
+		return nil
+	}
+	return uuids
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDatabaseQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := openDatabase(filepath.Join(dir, databaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	now := time.Now()
+	for i, message := range []struct {
+		uuid  string
+		state MMSState
+	}{
+		{"c", MMSState{ModemId: "modem1", State: NOTIFICATION, Id: "tid1"}},
+		{"a", MMSState{ModemId: "modem1", State: SENT, Id: "mid1"}},
+		{"b", MMSState{ModemId: "modem2", State: NOTIFICATION, Id: "tid2"}},
+		{"d", MMSState{ModemId: "modem1", State: RESPONDED, Id: "tid3"}},
+	} {
+		if err := insertState(d, message.uuid, message.state, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		where string
+		args  []interface{}
+		want  []string
+	}{
+		{"modem_id = ?", []interface{}{"modem1"}, []string{"c", "a", "d"}},
+		{"modem_id = ? AND state IN (?, ?)", []interface{}{"modem1", NOTIFICATION, RESPONDED}, []string{"c", "d"}},
+		{"state = ? AND transaction_id = ?", []interface{}{SENT, "mid1"}, []string{"a"}},
+		{"modem_id = ?", []interface{}{"modem3"}, nil},
+	} {
+		if uuids, err := selectUUIDs(d, test.where, test.args...); err != nil || !reflect.DeepEqual(uuids, test.want) {
+			t.Errorf("selectUUIDs(%q, %v) = %v, %v, want %v", test.where, test.args, uuids, err, test.want)
+		}
+	}
+
+	// Updates keep the creation order.
+	if err := updateState(d, "c", MMSState{ModemId: "modem1", State: DOWNLOADED, Id: "tid1"}); err != nil {
+		t.Fatal(err)
+	}
+	if uuids, _ := selectUUIDs(d, "modem_id = ?", "modem1"); !reflect.DeepEqual(uuids, []string{"c", "a", "d"}) {
+		t.Errorf("order after update = %v", uuids)
+	}
+	if state, err := selectState(d, "c"); err != nil || state.State != DOWNLOADED {
+		t.Errorf("selectState = %+v, %v", state, err)
+	}
+
+	if err := deleteState(d, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := selectState(d, "c"); !errors.Is(err, errNotStored) {
+		t.Errorf("selectState of a deleted message = %v", err)
+	}
+	if err := updateState(d, "c", MMSState{}); !errors.Is(err, errNotStored) {
+		t.Errorf("updateState of a deleted message = %v", err)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, databaseName)
+
+	// A database of schema version 1 has no transactions.
+	d, err := openDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for uuid, state := range map[string]MMSState{
+		"notification": {ModemId: "modem", State: NOTIFICATION, Id: "tid1"},
+		"responded":    {ModemId: "modem", State: RESPONDED, Id: "tid2"},
+	} {
+		if err := insertState(d, uuid, state, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, statement := range []string{"DROP TABLE transactions", "PRAGMA user_version = 1"} {
+		if _, err := d.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	if d, err = openDatabase(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if transactions, err := selectTransactions(d, "modem"); err != nil || !reflect.DeepEqual(transactions, map[string]string{"tid1": "notification"}) {
+		t.Errorf("migrated transactions = %v, %v", transactions, err)
+	}
+
+	if uuid, err := trackTransaction(d, "tid1", "other"); err != nil || uuid != "notification" {
+		t.Errorf("trackTransaction of a stored message = %q, %v", uuid, err)
+	}
+	if err := deleteState(d, "notification"); err != nil {
+		t.Fatal(err)
+	}
+	if uuid, err := selectTransaction(d, "tid1"); err != nil || uuid != "" {
+		t.Errorf("transaction of a deleted message = %q, %v", uuid, err)
+	}
+	if uuid, err := trackTransaction(d, "tid2", "responded"); err != nil || uuid != "responded" {
+		t.Errorf("trackTransaction = %q, %v", uuid, err)
+	}
+	if _, err := trackTransaction(d, "tid2", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if transactions, err := selectTransactions(d, "modem"); err != nil || !reflect.DeepEqual(transactions, map[string]string{"tid2": "responded"}) {
+		t.Errorf("transactions = %v, %v", transactions, err)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	legacy := MMSState{ModemId: "modem", State: RESPONDED, Id: "tid", SendState: SendInfo{}}
+	data, err := encodeState(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"good.db":  data,
+		"torn.db":  []byte(`{"State":"rec`),
+		"good.mms": []byte("pdu"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "good.db"+backupSuffix), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := openDatabase(filepath.Join(dir, databaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	migrateLegacy(d, dir)
+
+	if state, err := selectState(d, "good"); err != nil || !reflect.DeepEqual(state, legacy) {
+		t.Errorf("migrated state = %+v, %v", state, err)
+	}
+	if uuids, _ := selectUUIDs(d, "modem_id = ? AND state = ?", "modem", RESPONDED); !reflect.DeepEqual(uuids, []string{"good"}) {
+		t.Errorf("migrated message not indexed: %v", uuids)
+	}
+	// A state which cannot be read is still listed, to be cleaned up.
+	if uuids, _ := selectUUIDs(d, "modem_id = ?", ""); !reflect.DeepEqual(uuids, []string{"torn"}) {
+		t.Errorf("unreadable messages = %v", uuids)
+	}
+	if _, err := selectState(d, "torn"); err == nil {
+		t.Error("unreadable state was read")
+	}
+	for _, name := range []string{"good.db", "good.db" + backupSuffix, "torn.db"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "good.mms")); err != nil {
+		t.Errorf("PDU was removed: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nuntium-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := openDatabase(filepath.Join(dir, databaseName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbMutex.Lock()
+	db = d
+	dbMutex.Unlock()
+
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db != nil {
+		t.Error("expected the database to be reopened on its next use")
+	}
+	if err := d.Ping(); err == nil {
+		t.Error("expected the database to be closed")
+	}
+	if err := Close(); err != nil {
+		t.Errorf("Close of a closed database: %v", err)
+	}
+}
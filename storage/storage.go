@@ -22,39 +22,57 @@
 package storage
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
-	"strings"
-	"syscall"
 	"time"
 
+	"github.com/ubports/nuntium/logging"
 	"github.com/ubports/nuntium/mms"
 	"launchpad.net/go-xdg/v0"
 )
 
+var logger = logging.New("storage")
+
 const SUBPATH = "nuntium/store"
 
-// Creates an .db file in storage with message state stored.
-// Returns an empty state and not nil error if message not stored successfully.
-func Create(modemId string, mNotificationInd *mms.MNotificationInd) (MMSState, error) {
-	state := MMSState{
+// notificationState returns the state of a new incoming message of modemId.
+func notificationState(modemId string, mNotificationInd *mms.MNotificationInd) MMSState {
+	return MMSState{
 		Id:               mNotificationInd.TransactionId,
 		State:            NOTIFICATION,
 		ContentLocation:  mNotificationInd.ContentLocation,
 		ModemId:          modemId,
 		MNotificationInd: mNotificationInd,
 	}
-	storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, mNotificationInd.UUID+".db"))
+}
+
+// draftState returns the state of a new outgoing message of modemId, the
+// send state of every recipient is NONE.
+func draftState(modemId string, recipients []string) MMSState {
+	state := MMSState{
+		State:     DRAFT,
+		SendState: SendInfo{},
+		ModemId:   modemId,
+	}
+	for _, recipient := range recipients {
+		state.SendState[recipient] = NONE
+	}
+	return state
+}
+
+// Stores the state of a new message in storage.
+// Returns an empty state and not nil error if message not stored successfully.
+func (store SQLite) Create(modemId string, mNotificationInd *mms.MNotificationInd) (MMSState, error) {
+	state := notificationState(modemId, mNotificationInd)
+	d, err := database()
 	if err != nil {
 		return MMSState{}, err
 	}
-	if err := writeState(state, storePath); err != nil {
+	if err := insertState(d, mNotificationInd.UUID, state, time.Now()); err != nil {
 		return MMSState{}, err
 	}
 	return state, nil
@@ -63,29 +81,53 @@ func Create(modemId string, mNotificationInd *mms.MNotificationInd) (MMSState, e
 // Removes message with UUID from storage.
 // Returns a not nil error if any/more of the stored files are failed to remove.
 // The returned error (if not nil) is always an Multierror type.
-func Destroy(uuid string) (err error) {
+func (store SQLite) Destroy(uuid string) (err error) {
 	errs := Multierror{}
 
-	if path, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db")); err == nil {
+	if d, err := database(); err != nil {
+		errs = append(errs, err)
+	} else if err := deleteState(d, uuid); err != nil {
+		errs = append(errs, err)
+	}
+
+	if path, err := xdg.Data.Find(journalPath(uuid)); err == nil {
 		if err := os.Remove(path); err != nil {
 			errs = append(errs, ErrorRemovingFile{path, err})
 		}
-	} else {
-		errs = append(errs, err)
 	}
 
-	if path, err := GetMMS(uuid); err == nil {
+	if path, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".mms")); err == nil {
 		if err := os.Remove(path); err != nil {
 			errs = append(errs, ErrorRemovingFile{path, err})
 		}
 	}
 
+	if err := os.Remove(filepath.Join(runtimeDir(), uuid+".mms")); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+
+	texts, _ := filepath.Glob(filepath.Join(runtimeDir(), textName(uuid, -1)))
+	parts, _ := filepath.Glob(filepath.Join(runtimeDir(), partName(uuid, -1)))
+	if paths := append(texts, parts...); len(paths) > 0 {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				errs = append(errs, ErrorRemovingFile{path, err})
+			}
+		}
+	}
+
 	if path, err := xdg.Cache.Find(path.Join(SUBPATH, uuid+".m-notifyresp.ind")); err == nil {
 		if err := os.Remove(path); err != nil {
 			errs = append(errs, ErrorRemovingFile{path, err})
 		}
 	}
 
+	if path, err := xdg.Cache.Find(path.Join(SUBPATH, uuid+".m-read-rec.ind")); err == nil {
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, ErrorRemovingFile{path, err})
+		}
+	}
+
 	if path, err := xdg.Cache.Find(path.Join(SUBPATH, uuid+".m-send.req")); err == nil {
 		if err := os.Remove(path); err != nil {
 			errs = append(errs, ErrorRemovingFile{path, err})
@@ -98,8 +140,8 @@ func Destroy(uuid string) (err error) {
 // Creates an empty .m-notifyresp.ind file in storage for message with provided uuid.
 // Returns a nil file descriptor and a non nil error if no message stored uuid or file creation failed.
 // On success returns an open file descriptor and nil error.
-func CreateResponseFile(uuid string) (*os.File, error) {
-	_, err := GetMMSState(uuid)
+func (store SQLite) CreateResponseFile(uuid string) (*os.File, error) {
+	_, err := store.GetMMSState(uuid)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving message state: %w", err)
 	}
@@ -111,11 +153,34 @@ func CreateResponseFile(uuid string) (*os.File, error) {
 	return os.Create(filePath)
 }
 
+// Creates an empty .m-read-rec.ind file in storage for message with provided uuid.
+// Returns a nil file descriptor and a non nil error if no message stored uuid or file creation failed.
+// On success returns an open file descriptor and nil error.
+func (store SQLite) CreateReadReportFile(uuid string) (*os.File, error) {
+	_, err := store.GetMMSState(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	filePath, err := xdg.Cache.Ensure(path.Join(SUBPATH, uuid+".m-read-rec.ind"))
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(filePath)
+}
+
 // Updates MNotificationInd field in stored MMSState.
 // Returns the stored message state and a nil error on success.
 // If message not in storage or other fail it returns empty or previous state and a non nil error.
-func UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, error) {
-	oldState, err := GetMMSState(mNotificationInd.UUID)
+func (store SQLite) UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return store.updateMNotificationInd(mNotificationInd)
+}
+
+// updateMNotificationInd is UpdateMNotificationInd with stateMutex held.
+func (store SQLite) updateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, error) {
+	oldState, err := store.GetMMSState(mNotificationInd.UUID)
 	if err != nil {
 		return oldState, fmt.Errorf("error retrieving message state: %w", err)
 	}
@@ -123,11 +188,51 @@ func UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, e
 	newState := oldState
 	newState.MNotificationInd = mNotificationInd
 
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, mNotificationInd.UUID+".db"))
+	if err := storeState(mNotificationInd.UUID, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to DISABLED, to handle it once MMS is enabled.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateDisabled(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = DISABLED
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
-	if err := writeState(newState, storePath); err != nil {
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to NOTIFICATION.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateNotification(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = NOTIFICATION
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
 
@@ -138,16 +243,19 @@ func UpdateMNotificationInd(mNotificationInd *mms.MNotificationInd) (MMSState, e
 // Returns the stored message state and a nil error on success.
 // If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
 // Note: Can return a forced debug error if MNotificationInd has the right ContentLocation parameters.
-func UpdateDownloaded(uuid, filePath string) (MMSState, error) {
-	oldState, err := GetMMSState(uuid)
+func (store SQLite) UpdateDownloaded(uuid, filePath string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
 		return oldState, fmt.Errorf("error retrieving message state: %w", err)
 	}
 
 	// Debug error forcing if wanted.
 	if err := oldState.MNotificationInd.PopDebugError(mms.DebugErrorDownloadStorage); err != nil {
-		log.Printf("Forcing debug error: %#v", err)
-		UpdateMNotificationInd(oldState.MNotificationInd)
+		logger.Errorf("Forcing debug error: %#v", err)
+		store.updateMNotificationInd(oldState.MNotificationInd)
 		return oldState, err
 	}
 
@@ -156,9 +264,9 @@ func UpdateDownloaded(uuid, filePath string) (MMSState, error) {
 	if err != nil {
 		return oldState, err
 	}
-	if err := os.Rename(filePath, mmsPath); err != nil {
+	if err := store.movePDU(filePath, mmsPath); err != nil {
 		if err := os.Remove(mmsPath); err != nil {
-			log.Printf("Error removing file \"%s\": %s", mmsPath, err)
+			logger.Errorf("Error removing file \"%s\": %s", mmsPath, err)
 		}
 		return oldState, err
 	}
@@ -166,11 +274,7 @@ func UpdateDownloaded(uuid, filePath string) (MMSState, error) {
 	newState := oldState
 	newState.State = DOWNLOADED
 
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
-	if err != nil {
-		return oldState, err
-	}
-	if err := writeState(newState, storePath); err != nil {
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
 
@@ -181,27 +285,26 @@ func UpdateDownloaded(uuid, filePath string) (MMSState, error) {
 // Returns the stored message state and a nil error on success.
 // If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
 // Note: Can return a forced debug error if MNotificationInd has the right ContentLocation parameters.
-func UpdateReceived(uuid string) (MMSState, error) {
-	oldState, err := GetMMSState(uuid)
+func (store SQLite) UpdateReceived(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
 		return oldState, fmt.Errorf("error retrieving message state: %w", err)
 	}
 
 	// Debug error forcing if wanted.
 	if err := oldState.MNotificationInd.PopDebugError(mms.DebugErrorReceiveStorage); err != nil {
-		log.Printf("Forcing debug error: %#v", err)
-		UpdateMNotificationInd(oldState.MNotificationInd)
+		logger.Errorf("Forcing debug error: %#v", err)
+		store.updateMNotificationInd(oldState.MNotificationInd)
 		return oldState, err
 	}
 
 	newState := oldState
 	newState.State = RECEIVED
 
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
-	if err != nil {
-		return oldState, err
-	}
-	if err := writeState(newState, storePath); err != nil {
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
 
@@ -212,50 +315,433 @@ func UpdateReceived(uuid string) (MMSState, error) {
 // Returns the stored message state and a nil error on success.
 // If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
 // Note: Can return a forced debug error if MNotificationInd has the right ContentLocation parameters.
-func UpdateResponded(uuid string) (MMSState, error) {
-	oldState, err := GetMMSState(uuid)
+func (store SQLite) UpdateResponded(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
 		return oldState, fmt.Errorf("error retrieving message state: %w", err)
 	}
 
 	// Debug error forcing if wanted.
 	if err := oldState.MNotificationInd.PopDebugError(mms.DebugErrorRespondStorage); err != nil {
-		log.Printf("Forcing debug error: %#v", err)
-		UpdateMNotificationInd(oldState.MNotificationInd)
+		logger.Errorf("Forcing debug error: %#v", err)
+		store.updateMNotificationInd(oldState.MNotificationInd)
 		return oldState, err
 	}
 
 	newState := oldState
 	newState.State = RESPONDED
 
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) TelepathyErrorNotified to true.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetTelepathyErrorNotified(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.TelepathyErrorNotified = true
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) ReadReportSent to true.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetReadReportSent(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.ReadReportSent = true
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
-	if err := writeState(newState, storePath); err != nil {
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) Read to true.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetRead(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.Read = true
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
 
 	return newState, nil
 }
 
-// Updates the stored message (identified by uuid) TelepathyErrorNotified to true.
+// Updates the stored message (identified by uuid) DeliveryReportRequested to true.
 // Returns the stored message state and a nil error on success.
 // If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
-func SetTelepathyErrorNotified(uuid string) (MMSState, error) {
-	oldState, err := GetMMSState(uuid)
+func (store SQLite) SetDeliveryReportRequested(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
 		return oldState, fmt.Errorf("error retrieving message state: %w", err)
 	}
 
 	newState := oldState
-	newState.TelepathyErrorNotified = true
+	newState.DeliveryReportRequested = true
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) DecodeLog to log.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetDecodeLog(uuid, log string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
 
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
+	oldState, err := store.GetMMSState(uuid)
 	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.DecodeLog = log
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
-	if err := writeState(newState, storePath); err != nil {
+
+	return newState, nil
+}
+
+// Marks the stored message (identified by uuid), deleted by the user before it was downloaded, as still to be rejected.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetRejectPending(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.RejectPending = true
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Marks the stored message (identified by uuid) as quarantined for reason.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetQuarantined(uuid, reason string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.Quarantined = true
+	newState.QuarantineReason = reason
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Marks the stored message (identified by uuid) as spam for reason.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetSpam(uuid, reason string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.Spam = true
+	newState.SpamReason = reason
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Records the data parts of the stored message (identified by uuid) which were removed because their media type is not allowed.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) SetSanitized(uuid string, parts []SanitizedPart) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.SanitizedParts = parts
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to SENT and sets its Id to the Message-ID given by the MMS center.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateSent(uuid, messageId string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = SENT
+	newState.Id = messageId
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to DRAFT and resets its SendAttempts, to send it again.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateDraft(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = DRAFT
+	newState.SendAttempts = 0
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to SENDING.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateSending(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = SENDING
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to FAILED.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateFailed(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = FAILED
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the stored message (identified by uuid) state to CANCELLED.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateCancelled(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = CANCELLED
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the send state of recipient in the stored message (identified by uuid) to status.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateSendState(uuid, recipient, status string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.SendState = SendInfo{}
+	for r, s := range oldState.SendState {
+		newState.SendState[r] = s
+	}
+	newState.SendState[recipient] = status
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Updates the read state of recipient in the stored message (identified by uuid) to status.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateReadState(uuid, recipient, status string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.ReadState = make(map[string]string)
+	for r, s := range oldState.ReadState {
+		newState.ReadState[r] = s
+	}
+	newState.ReadState[recipient] = status
+
+	if err := storeState(uuid, newState); err != nil {
+		return oldState, err
+	}
+
+	return newState, nil
+}
+
+// Returns the UUID of the stored SENT message with the Message-ID messageId.
+// If there is none, a non nil error is returned.
+func (store SQLite) FindSent(messageId string) (string, error) {
+	if messageId == "" {
+		return "", fmt.Errorf("empty message id")
+	}
+	d, err := database()
+	if err != nil {
+		return "", err
+	}
+	uuids, err := selectUUIDs(d, "state = ? AND transaction_id = ?", SENT, messageId)
+	if err != nil {
+		return "", err
+	}
+	if len(uuids) == 0 {
+		return "", fmt.Errorf("no sent message with message id %s", messageId)
+	}
+	return uuids[0], nil
+}
+
+// Updates the stored message (identified by uuid) state to SEND_PENDING and counts a failed upload in its SendAttempts.
+// Returns the stored message state and a nil error on success.
+// If message not in storage or other error occurs, it returns empty or previous state and a non nil error.
+func (store SQLite) UpdateSendPending(uuid string) (MMSState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	oldState, err := store.GetMMSState(uuid)
+	if err != nil {
+		return oldState, fmt.Errorf("error retrieving message state: %w", err)
+	}
+
+	newState := oldState
+	newState.State = SEND_PENDING
+	newState.SendAttempts++
+
+	if err := storeState(uuid, newState); err != nil {
 		return oldState, err
 	}
 
@@ -263,19 +749,17 @@ func SetTelepathyErrorNotified(uuid string) (MMSState, error) {
 }
 
 // Saves an message with DRAFT state to storage and creates an empty .m-send.req file in storage for message with provided uuid.
+// The message belongs to the modem modemId and the send state of every recipient is NONE.
 // Returns a nil file descriptor and a non nil error if message store error or send file creation failed.
 // On success returns an open file descriptor to the send file and nil error.
 // Note: If there is an message stored under uuid, the message is rewritten.
-func CreateSendFile(uuid string) (*os.File, error) {
-	state := MMSState{
-		State: DRAFT,
-	}
-	storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".db"))
+func (store SQLite) CreateSendFile(modemId, uuid string, recipients []string) (*os.File, error) {
+	state := draftState(modemId, recipients)
+	d, err := database()
 	if err != nil {
 		return nil, err
 	}
-	if err := writeState(state, storePath); err != nil {
-		os.Remove(storePath)
+	if err := insertState(d, uuid, state, time.Now()); err != nil {
 		return nil, err
 	}
 	filePath, err := xdg.Cache.Ensure(path.Join(SUBPATH, uuid+".m-send.req"))
@@ -285,122 +769,152 @@ func CreateSendFile(uuid string) (*os.File, error) {
 	return os.Create(filePath)
 }
 
-// Returns .mms file path to message identified by uuid.
+// Returns .m-send.req file path to message identified by uuid.
 // If file doesn't exists, a non nil error is returned.
-func GetMMS(uuid string) (string, error) {
-	return xdg.Data.Find(path.Join(SUBPATH, uuid+".mms"))
+func (store SQLite) GetSendFile(uuid string) (string, error) {
+	return xdg.Cache.Find(path.Join(SUBPATH, uuid+".m-send.req"))
 }
 
-// Gets message state from storage stored under uuid.
-// Returns empty state and a non nil error if message not stored or load failed.
-func GetMMSState(uuid string) (MMSState, error) {
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
+// Returns .mms file path to message identified by uuid, an encrypted file is decrypted to the runtime directory first.
+// If file doesn't exists, a non nil error is returned.
+func (store SQLite) GetMMS(uuid string) (string, error) {
+	mmsPath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".mms"))
 	if err != nil {
-		return MMSState{}, err
+		return "", err
 	}
-
-	f, err := os.Open(storePath)
+	f, err := os.Open(mmsPath)
 	if err != nil {
-		return MMSState{}, err
+		return "", err
+	}
+	header := make([]byte, len(encryptedHeader))
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	if !isEncrypted(header[:n]) {
+		return mmsPath, nil
 	}
-	defer f.Close()
+	return decryptedPath(store.Key, mmsPath)
+}
 
-	mmsState := MMSState{}
-	jsonReader := json.NewDecoder(f)
-	if err := jsonReader.Decode(&mmsState); err != nil {
-		return MMSState{}, err
+// Writes the transcoded text part index of message identified by uuid to the runtime directory, next to decrypted PDUs.
+// Returns the path of the written file.
+func (store SQLite) WriteText(uuid string, index int, text string) (string, error) {
+	if _, err := store.GetMMSState(uuid); err != nil {
+		return "", fmt.Errorf("error retrieving message state: %w", err)
+	}
+	if err := os.MkdirAll(runtimeDir(), 0700); err != nil {
+		return "", err
 	}
+	textPath := filepath.Join(runtimeDir(), textName(uuid, index))
+	if err := writeFileAtomic(textPath, []byte(text), false); err != nil {
+		return "", err
+	}
+	return textPath, nil
+}
 
-	return mmsState, nil
+// Writes data, the unwrapped content of the DRM protected part index of message identified by uuid, to the runtime directory, next to decrypted PDUs.
+// Returns the path of the written file.
+func (store SQLite) WritePart(uuid string, index int, data []byte) (string, error) {
+	if _, err := store.GetMMSState(uuid); err != nil {
+		return "", fmt.Errorf("error retrieving message state: %w", err)
+	}
+	if err := os.MkdirAll(runtimeDir(), 0700); err != nil {
+		return "", err
+	}
+	partPath := filepath.Join(runtimeDir(), partName(uuid, index))
+	if err := writeFileAtomic(partPath, data, false); err != nil {
+		return "", err
+	}
+	return partPath, nil
 }
 
-// Returns stored MNotificationInd for message identified by uuid.
-// If message not in storage or message state is not NOTIFICATION, nil is returned.
-func GetMNotificationInd(uuid string) *mms.MNotificationInd {
-	mmsState, err := GetMMSState(uuid)
-	if err != nil {
-		log.Print("MMS state retrieving error:", err)
-		return nil
+// partName returns the file name of the unwrapped part index of the message
+// uuid, or a pattern matching those of all of its parts if index is negative.
+func partName(uuid string, index int) string {
+	if index < 0 {
+		return uuid + ".*.part"
 	}
+	return fmt.Sprintf("%s.%d.part", uuid, index)
+}
 
-	if mmsState.State != NOTIFICATION {
-		log.Print("MMS was already downloaded")
-		return nil
+// textName returns the file name of the text part index of the message uuid,
+// or a pattern matching those of all of its parts if index is negative.
+func textName(uuid string, index int) string {
+	if index < 0 {
+		return uuid + ".*.txt"
 	}
+	return fmt.Sprintf("%s.%d.txt", uuid, index)
+}
 
-	return mmsState.MNotificationInd
+// Returns the content of the .mms file of message identified by uuid, decrypted if it is encrypted.
+func (store SQLite) ReadMMS(uuid string) ([]byte, error) {
+	mmsPath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".mms"))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(mmsPath)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(store.Key, data)
 }
 
-func writeState(state MMSState, storePath string) error {
-	file, err := os.Create(storePath)
+// movePDU moves the PDU at filePath to mmsPath, encrypting it if the storage encrypts PDUs.
+func (store SQLite) movePDU(filePath, mmsPath string) error {
+	if !store.Encrypt {
+		return os.Rename(filePath, mmsPath)
+	}
+	pdu, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		file.Close()
-		if err != nil {
-			os.Remove(storePath)
-		}
-	}()
-	w := bufio.NewWriter(file)
-	defer w.Flush()
-	jsonWriter := json.NewEncoder(w)
-	if err := jsonWriter.Encode(state); err != nil {
+	data, err := seal(store.Key, pdu)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(mmsPath, data, false); err != nil {
 		return err
 	}
-	return nil
+	return os.Remove(filePath)
 }
 
-// Returns list of UUID strings stored in storage, sorted by creation date ascending.
-// Note: If creation date is not supported by filesystem, UUIDs are sorted by modificatin date.
-func GetStoredUUIDs() []string {
-	// Search for all *.db files in xdg data directory in SUBPATH subfolder and extract UUID from filenames.
+// Gets message state from storage stored under uuid.
+// Returns empty state and a non nil error if message not stored or load failed.
+func (store SQLite) GetMMSState(uuid string) (MMSState, error) {
+	d, err := database()
+	if err != nil {
+		return MMSState{}, err
+	}
+	return selectState(d, uuid)
+}
 
-	storeDir, err := xdg.Data.Find(SUBPATH)
+// Returns stored MNotificationInd for message identified by uuid.
+// If message not in storage or message state is not NOTIFICATION, nil is returned.
+func (store SQLite) GetMNotificationInd(uuid string) *mms.MNotificationInd {
+	mmsState, err := store.GetMMSState(uuid)
 	if err != nil {
-		log.Printf("Storage directory %s not found in xdg data directories", SUBPATH)
+		logger.Error("MMS state retrieving error:", err)
 		return nil
 	}
 
-	uuidsWithTime := make([]struct {
-		uuid  string
-		ctime time.Time
-	}, 0) // For sorting.
-	err = filepath.Walk(storeDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if matched, err := filepath.Match("*.db", filepath.Base(path)); err != nil {
-			return err
-		} else if matched {
-			ctime := info.ModTime()
-			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-				ctime = time.Unix(stat.Ctim.Unix())
-			}
-			uuidsWithTime = append(uuidsWithTime, struct {
-				uuid  string
-				ctime time.Time
-			}{
-				strings.TrimSuffix(filepath.Base(path), ".db"),
-				ctime,
-			})
-		}
+	if mmsState.State != NOTIFICATION {
+		logger.Warn("MMS was already downloaded")
 		return nil
-	})
+	}
+
+	return mmsState.MNotificationInd
+}
+
+// Returns list of UUID strings stored in storage, sorted by creation date ascending.
+func (store SQLite) GetStoredUUIDs() []string {
+	d, err := database()
 	if err != nil {
+		logger.Errorf("Cannot open storage: %v", err)
 		return nil
 	}
-
-	// Sort uuids by cdates ascending.
-	sort.SliceStable(uuidsWithTime, func(i, j int) bool {
-		return uuidsWithTime[i].ctime.Before(uuidsWithTime[j].ctime)
-	})
-	uuids := make([]string, len(uuidsWithTime))
-	for i, uuidWithTime := range uuidsWithTime {
-		uuids[i] = uuidWithTime.uuid
+	uuids, err := selectUUIDs(d, "1")
+	if err != nil {
+		logger.Errorf("Cannot query stored messages: %v", err)
+		return nil
 	}
 	return uuids
 }
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+// isUnresponded returns true for the states in unrespondedStates.
+func isUnresponded(state string) bool {
+	for _, s := range unrespondedStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Maps transactionId to the message uuid, replacing the message it mapped to.
+func (store SQLite) SetTransaction(transactionId, uuid string) error {
+	d, err := database()
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`INSERT INTO transactions (transaction_id, uuid) VALUES (?, ?)
+		ON CONFLICT (transaction_id) DO UPDATE SET uuid = excluded.uuid`, transactionId, uuid)
+	return err
+}
+
+// Maps transactionId to the message uuid, unless it maps to another message
+// which is still stored. Returns the UUID transactionId maps to.
+func (store SQLite) TrackTransaction(transactionId, uuid string) (string, error) {
+	d, err := database()
+	if err != nil {
+		return "", err
+	}
+	return trackTransaction(d, transactionId, uuid)
+}
+
+// trackTransaction is TrackTransaction on the database d.
+func trackTransaction(d *sql.DB, transactionId, uuid string) (string, error) {
+	// Keeps the update and the lookup of the result together.
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	_, err := d.Exec(`INSERT INTO transactions (transaction_id, uuid) VALUES (?, ?)
+		ON CONFLICT (transaction_id) DO UPDATE SET uuid = excluded.uuid
+		WHERE transactions.uuid NOT IN (SELECT uuid FROM messages)`, transactionId, uuid)
+	if err != nil {
+		return "", err
+	}
+	return selectTransaction(d, transactionId)
+}
+
+// Removes transactionId, once the MMS center was told about its message.
+// Removing a transaction which is not tracked is no error.
+func (store SQLite) RemoveTransaction(transactionId string) error {
+	d, err := database()
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`DELETE FROM transactions WHERE transaction_id = ?`, transactionId)
+	return err
+}
+
+// Returns the UUID of the message transactionId maps to, an empty UUID if it
+// is not tracked.
+func (store SQLite) GetTransaction(transactionId string) (string, error) {
+	d, err := database()
+	if err != nil {
+		return "", err
+	}
+	return selectTransaction(d, transactionId)
+}
+
+// selectTransaction returns the UUID transactionId maps to in d, empty if
+// none.
+func selectTransaction(d *sql.DB, transactionId string) (string, error) {
+	var uuid string
+	err := d.QueryRow(`SELECT uuid FROM transactions WHERE transaction_id = ?`, transactionId).Scan(&uuid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return uuid, err
+}
+
+// Returns the transactions of the stored messages of modemId, mapping
+// transaction ids to UUIDs.
+func (store SQLite) GetTransactions(modemId string) (map[string]string, error) {
+	d, err := database()
+	if err != nil {
+		return nil, err
+	}
+	return selectTransactions(d, modemId)
+}
+
+// selectTransactions returns the transactions of the messages of modemId
+// stored in d.
+func selectTransactions(d *sql.DB, modemId string) (map[string]string, error) {
+	rows, err := d.Query(`SELECT transactions.transaction_id, transactions.uuid FROM transactions
+		JOIN messages ON messages.uuid = transactions.uuid WHERE messages.modem_id = ?`, modemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	transactions := make(map[string]string)
+	for rows.Next() {
+		var transactionId, uuid string
+		if err := rows.Scan(&transactionId, &uuid); err != nil {
+			return nil, err
+		}
+		transactions[transactionId] = uuid
+	}
+	return transactions, rows.Err()
+}
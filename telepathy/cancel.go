@@ -0,0 +1,52 @@
+package telepathy
+
+import (
+	"fmt"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/storage"
+)
+
+// CancelDeliveryRequest is a CancelDelivery call, to be run as a transaction
+// with the MMS center of the modem.
+type CancelDeliveryRequest struct {
+	// UUID is the sent message to cancel.
+	UUID string
+	// MessageId is the Message-ID the MMS center gave the message.
+	MessageId string
+	// call is the CancelDelivery call, see ReplyCancelDelivery.
+	call *dbus.Message
+}
+
+// cancelDelivery passes the CancelDelivery call msg on to the mediator. It
+// returns the error reply if its arguments are invalid, nil if the call is
+// answered once the MMS center confirmed the cancel.
+func (service *MMSService) cancelDelivery(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	if err := msg.Args(&path); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	uuid, err := getUUIDFromObjectPath(path)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	mmsState, err := service.storage.GetMMSState(uuid)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if mmsState.State != storage.SENT || mmsState.Id == "" {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("%s is not a sent message", path))
+	}
+	service.cancelDeliveryChan <- &CancelDeliveryRequest{UUID: uuid, MessageId: mmsState.Id, call: msg}
+	return nil
+}
+
+// ReplyCancelDelivery answers the CancelDelivery call of request, with err
+// if the MMS center did not accept the cancel.
+func (service *MMSService) ReplyCancelDelivery(request *CancelDeliveryRequest, err error) error {
+	reply := dbus.NewMethodReturnMessage(request.call)
+	if err != nil {
+		reply = dbus.NewErrorMessage(request.call, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return service.conn.Send(reply)
+}
@@ -21,31 +21,76 @@
 
 package telepathy
 
+import "github.com/ubports/nuntium/logging"
+
+var logger = logging.New("telepathy")
+
 const (
 	MMS_DBUS_NAME          = "org.ofono.mms"
 	MMS_DBUS_PATH          = "/org/ofono/mms"
 	MMS_MESSAGE_DBUS_IFACE = "org.ofono.mms.Message"
 	MMS_SERVICE_DBUS_IFACE = "org.ofono.mms.Service"
 	MMS_MANAGER_DBUS_IFACE = "org.ofono.mms.Manager"
+	// MMS_SETTINGS_DBUS_IFACE is implemented next to MMS_MANAGER_DBUS_IFACE.
+	MMS_SETTINGS_DBUS_IFACE = "org.ofono.mms.nuntium.Settings"
+	// MMS_STORAGE_DBUS_IFACE is implemented next to MMS_MANAGER_DBUS_IFACE.
+	MMS_STORAGE_DBUS_IFACE = "org.ofono.mms.nuntium.Storage"
+	// MMS_DEBUG_DBUS_IFACE is implemented next to MMS_MANAGER_DBUS_IFACE.
+	MMS_DEBUG_DBUS_IFACE = "org.ofono.mms.nuntium.Debug"
+	// MMS_STATISTICS_DBUS_IFACE is implemented next to MMS_MANAGER_DBUS_IFACE.
+	MMS_STATISTICS_DBUS_IFACE = "org.ofono.mms.nuntium.Statistics"
+	// DBUS_INTROSPECTABLE_IFACE and DBUS_PROPERTIES_IFACE are implemented
+	// next to MMS_SERVICE_DBUS_IFACE and MMS_MESSAGE_DBUS_IFACE.
+	DBUS_INTROSPECTABLE_IFACE = "org.freedesktop.DBus.Introspectable"
+	DBUS_PROPERTIES_IFACE     = "org.freedesktop.DBus.Properties"
 )
 
 const (
-	identityProperty           string = "Identity"
-	useDeliveryReportsProperty string = "UseDeliveryReports"
-	modemObjectPathProperty    string = "ModemObjectPath"
-	messageAddedSignal         string = "MessageAdded"
-	messageRemovedSignal       string = "MessageRemoved"
-	serviceAddedSignal         string = "ServiceAdded"
-	serviceRemovedSignal       string = "ServiceRemoved"
-	preferredContextProperty   string = "PreferredContext"
-	propertyChangedSignal      string = "PropertyChanged"
-	statusProperty             string = "Status"
+	identityProperty                string = "Identity"
+	useDeliveryReportsProperty      string = "UseDeliveryReports"
+	modemObjectPathProperty         string = "ModemObjectPath"
+	messageAddedSignal              string = "MessageAdded"
+	messageRemovedSignal            string = "MessageRemoved"
+	serviceAddedSignal              string = "ServiceAdded"
+	serviceRemovedSignal            string = "ServiceRemoved"
+	preferredContextProperty        string = "PreferredContext"
+	propertyChangedSignal           string = "PropertyChanged"
+	propertiesChangedSignal         string = "PropertiesChanged"
+	statusProperty                  string = "Status"
+	localeProperty                  string = "Locale"
+	interfaceVersionProperty        string = "InterfaceVersion"
+	autoDownloadLimitProperty       string = "AutoDownloadLimit"
+	dataSaverProperty               string = "DataSaver"
+	deliveryReportSignal            string = "DeliveryReport"
+	compressionProperty             string = "Compression"
+	messageIdProperty               string = "MessageId"
+	deliveryReportRequestedProperty string = "DeliveryReportRequested"
+	responseStatusProperty          string = "ResponseStatus"
+	responseTextProperty            string = "ResponseText"
+	pushReceivedSignal              string = "PushReceived"
+	progressProperty                string = "Progress"
+	spamProperty                    string = "Spam"
+	spamReasonProperty              string = "SpamReason"
+	sanitizedPartsProperty          string = "SanitizedParts"
+	cardsProperty                   string = "Cards"
+	receivedProperty                string = "Received"
+	readProperty                    string = "Read"
+	debugInfoProperty               string = "DebugInfo"
 )
 
+// InterfaceVersion is the version of the org.ofono.mms interfaces exposed,
+// it is increased whenever methods, signals or properties are added so
+// clients can detect them at runtime. See docs/dbus.md for the history.
+const InterfaceVersion uint32 = 43
+
 const (
-	PERMANENT_ERROR = "PermanentError"
-	SENT            = "Sent"
-	TRANSIENT_ERROR = "TransientError"
+	DRAFT               = "draft"
+	PERMANENT_ERROR     = "PermanentError"
+	SENT                = "Sent"
+	READ_BY_RECIPIENT   = "ReadByRecipient"
+	TRANSIENT_ERROR     = "TransientError"
+	WAITING_FOR_NETWORK = "WaitingForNetwork"
+	CANCELLED           = "Cancelled"
 )
 
 const (
@@ -0,0 +1,129 @@
+package telepathy
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+const (
+	busDaemonName  = "org.freedesktop.DBus"
+	busDaemonIface = "org.freedesktop.DBus"
+)
+
+// consumers tracks the clients attached to a service by their unique bus
+// name, e.g. messaging-app and a sync daemon. Signals are broadcast on the
+// bus so every consumer receives them, attaching only makes nuntium keep a
+// message until every consumer holding it deleted it or went away.
+type consumers struct {
+	lock  sync.Mutex
+	names map[string]bool
+	watch *dbus.SignalWatch
+}
+
+func (c *consumers) add(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.names == nil {
+		c.names = make(map[string]bool)
+	}
+	c.names[name] = true
+}
+
+// remove returns false if name was not attached.
+func (c *consumers) remove(name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.names[name] {
+		return false
+	}
+	delete(c.names, name)
+	return true
+}
+
+// list returns the sorted names of the attached consumers.
+func (c *consumers) list() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var names []string
+	for name := range c.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newMessageInterface creates a message handler held by the consumers
+// currently attached.
+func (service *MMSService) newMessageInterface(objectPath dbus.ObjectPath, deleteChan, redownloadChan, markReadChan, cancelChan, resendChan chan dbus.ObjectPath) *MessageInterface {
+	msgInterface := NewMessageInterface(service.conn, objectPath, deleteChan, redownloadChan, markReadChan, cancelChan, resendChan)
+	msgInterface.hold(service.consumers.list())
+	return msgInterface
+}
+
+// attach registers the sender of msg as a consumer of the service.
+func (service *MMSService) attach(msg *dbus.Message) {
+	if msg.Sender == "" {
+		return
+	}
+	service.consumers.add(msg.Sender)
+	logger.Infof("Consumer %s attached to %s", msg.Sender, service.payload.Path)
+	service.watchConsumers()
+}
+
+// detach unregisters a consumer and releases the messages it holds. Messages
+// which were deleted by every other consumer holding them are removed.
+func (service *MMSService) detach(name string) {
+	if !service.consumers.remove(name) {
+		return
+	}
+	logger.Infof("Consumer %s detached from %s", name, service.payload.Path)
+	for objectPath, msgInterface := range service.messageHandlers.list() {
+		if msgInterface.release(name, false) {
+			service.msgDeleteChan <- objectPath
+		}
+	}
+}
+
+// watchConsumers detaches consumers which leave the bus without detaching.
+func (service *MMSService) watchConsumers() {
+	service.consumers.lock.Lock()
+	defer service.consumers.lock.Unlock()
+	if service.consumers.watch != nil {
+		return
+	}
+	w, err := service.conn.WatchSignal(&dbus.MatchRule{
+		Type:      dbus.TypeSignal,
+		Sender:    busDaemonName,
+		Interface: busDaemonIface,
+		Member:    "NameOwnerChanged",
+	})
+	if err != nil {
+		logger.Error("Cannot watch for consumers leaving the bus: ", err)
+		return
+	}
+	service.consumers.watch = w
+	go func() {
+		for msg := range w.C {
+			var name, oldOwner, newOwner string
+			if err := msg.Args(&name, &oldOwner, &newOwner); err != nil {
+				logger.Error("Cannot parse NameOwnerChanged: ", err)
+				continue
+			}
+			if newOwner == "" {
+				service.detach(name)
+			}
+		}
+	}()
+}
+
+// stopWatchingConsumers cancels the watch set up by watchConsumers.
+func (service *MMSService) stopWatchingConsumers() {
+	service.consumers.lock.Lock()
+	defer service.consumers.lock.Unlock()
+	if service.consumers.watch != nil {
+		service.consumers.watch.Cancel()
+		service.consumers.watch = nil
+	}
+}
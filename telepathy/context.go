@@ -0,0 +1,114 @@
+package telepathy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// maxAccessPointNameLength is the longest APN 3GPP TS 23.003 allows.
+const maxAccessPointNameLength = 100
+
+// ContextConfiguration are the MMS context settings of a ConfigureMMSContext
+// call, to be written to the ofono context of the modem.
+type ContextConfiguration struct {
+	// AccessPointName is the APN to connect to.
+	AccessPointName string
+	// MessageCenter is the MMSC URL.
+	MessageCenter string
+	// Proxy is the host of the MMS proxy, empty for none, Port is its port.
+	Proxy string
+	Port  uint16
+	// call is the ConfigureMMSContext call, see ReplyConfigureMMSContext.
+	call *dbus.Message
+}
+
+// MessageProxy returns the MMS proxy in host:port form, as ofono expects it,
+// or an empty string if there is no proxy.
+func (configuration ContextConfiguration) MessageProxy() string {
+	if configuration.Proxy == "" {
+		return ""
+	}
+	return net.JoinHostPort(configuration.Proxy, strconv.Itoa(int(configuration.Port)))
+}
+
+// validate returns an error describing the first invalid setting.
+func (configuration ContextConfiguration) validate() error {
+	apn := configuration.AccessPointName
+	if apn == "" {
+		return errors.New("the access point name is empty")
+	}
+	if len(apn) > maxAccessPointNameLength {
+		return fmt.Errorf("the access point name is longer than %d characters", maxAccessPointNameLength)
+	}
+	for _, r := range apn {
+		if !isHostChar(r) && r != '_' {
+			return fmt.Errorf("the access point name %q has invalid characters", apn)
+		}
+	}
+
+	mmsc, err := url.Parse(configuration.MessageCenter)
+	if err != nil || (mmsc.Scheme != "http" && mmsc.Scheme != "https") || mmsc.Host == "" {
+		return fmt.Errorf("the message center %q is not an http URL", configuration.MessageCenter)
+	}
+
+	if configuration.Proxy == "" {
+		if configuration.Port != 0 {
+			return errors.New("a proxy port is set without a proxy")
+		}
+		return nil
+	}
+	if net.ParseIP(configuration.Proxy) == nil && strings.IndexFunc(configuration.Proxy, func(r rune) bool { return !isHostChar(r) }) >= 0 {
+		return fmt.Errorf("the proxy %q is neither a host name nor an IP address", configuration.Proxy)
+	}
+	if configuration.Port == 0 {
+		return errors.New("the proxy port is not set")
+	}
+	return nil
+}
+
+// isHostChar returns true for the characters of host names.
+func isHostChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.'
+}
+
+// configureMMSContext validates the settings of the ConfigureMMSContext call
+// msg and passes them on to be written to the context. It returns the error
+// reply if they are invalid, nil if the call is answered once the context is
+// written.
+func (service *MMSService) configureMMSContext(msg *dbus.Message) *dbus.Message {
+	var configuration ContextConfiguration
+	var port uint32
+	if err := msg.Args(&configuration.AccessPointName, &configuration.MessageCenter, &configuration.Proxy, &port); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if port > 65535 {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("the proxy port %d is out of range", port))
+	}
+	configuration.Port = uint16(port)
+	configuration.AccessPointName = strings.TrimSpace(configuration.AccessPointName)
+	configuration.MessageCenter = strings.TrimSpace(configuration.MessageCenter)
+	configuration.Proxy = strings.Trim(strings.TrimSpace(configuration.Proxy), "[]")
+	if err := configuration.validate(); err != nil {
+		logger.Errorf("Invalid MMS context settings: %v", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	configuration.call = msg
+	service.configureContextChan <- &configuration
+	return nil
+}
+
+// ReplyConfigureMMSContext answers the ConfigureMMSContext call of
+// configuration, with an error if the context could not be written.
+func (service *MMSService) ReplyConfigureMMSContext(configuration *ContextConfiguration, err error) error {
+	reply := dbus.NewMethodReturnMessage(configuration.call)
+	if err != nil {
+		reply = dbus.NewErrorMessage(configuration.call, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return service.conn.Send(reply)
+}
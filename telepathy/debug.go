@@ -0,0 +1,35 @@
+package telepathy
+
+import (
+	"encoding/json"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// SetDebugState makes the Debug interface dump what state returns, which
+// is encoded as JSON.
+func (manager *MMSManager) SetDebugState(state func() interface{}) {
+	manager.debugLock.Lock()
+	defer manager.debugLock.Unlock()
+	manager.debugState = state
+}
+
+// dumpDebugState replies with the JSON encoded state of nuntium.
+func (manager *MMSManager) dumpDebugState(msg *dbus.Message) *dbus.Message {
+	manager.debugLock.Lock()
+	state := manager.debugState
+	manager.debugLock.Unlock()
+	if state == nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", "no state to dump")
+	}
+	data, err := json.MarshalIndent(state(), "", "\t")
+	if err != nil {
+		logger.Error("Cannot encode debug state: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(string(data)); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
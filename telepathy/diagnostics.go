@@ -0,0 +1,53 @@
+package telepathy
+
+import (
+	"github.com/ubports/nuntium/diagnostics"
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// exportDiagnostics writes the diagnostics report of the message with the
+// uuid given in msg and replies with the path of the report.
+func (service *MMSService) exportDiagnostics(msg *dbus.Message) *dbus.Message {
+	var uuid string
+	if err := msg.Args(&uuid); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if id, err := getUUIDFromObjectPath(dbus.ObjectPath(uuid)); err != nil || id != uuid {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", "invalid message uuid "+uuid)
+	}
+	filePath, err := diagnostics.Export(service.storage, uuid)
+	if err != nil {
+		logger.Errorf("Cannot export diagnostics of message %s: %v", uuid, err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	logger.Infof("Exported diagnostics of message %s to %s", uuid, filePath)
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(filePath); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
+
+// exportMessagePDU writes the raw PDUs of the message with the object path
+// given in msg and replies with the paths of the written files.
+func (service *MMSService) exportMessagePDU(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	if err := msg.Args(&path); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	uuid, err := getUUIDFromObjectPath(path)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	paths, err := diagnostics.ExportPDU(service.storage, uuid)
+	if err != nil {
+		logger.Errorf("Cannot export PDUs of message %s: %v", uuid, err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	logger.Infof("Exported PDUs of message %s to %v", uuid, paths)
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(paths); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
@@ -1,6 +1,18 @@
 package telepathy
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/ubports/nuntium/i18n"
+)
 
 var ErrorNilMMSService = fmt.Errorf("no MMS service")
 var ErrorNilMNotificationInd = fmt.Errorf("nil MNotificationInd")
+
+// ErrorUnknown is the error code used for message handling errors which do
+// not carry a code.
+const ErrorUnknown = "x-ubports-nuntium-mms-error-unknown"
+
+func init() {
+	i18n.Register(ErrorUnknown, "The message could not be handled")
+}
@@ -0,0 +1,62 @@
+package telepathy
+
+import (
+	"fmt"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// ForwardRequest is a Forward call, to be run as a transaction with the MMS
+// center of the modem.
+type ForwardRequest struct {
+	// UUID is the message forwarded.
+	UUID string
+	// ContentLocation is the location of the message on the MMS center.
+	ContentLocation string
+	// Recipients are the addresses to forward the message to.
+	Recipients []string
+	// call is the Forward call, see ReplyForward.
+	call *dbus.Message
+}
+
+// forward passes the Forward call msg on to the mediator. It returns the
+// error reply if its arguments are invalid, nil if the call is answered once
+// the message is forwarded.
+func (service *MMSService) forward(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	request := ForwardRequest{call: msg}
+	if err := msg.Args(&path, &request.Recipients); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if len(request.Recipients) == 0 {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", "no recipients")
+	}
+	uuid, err := getUUIDFromObjectPath(path)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	mmsState, err := service.storage.GetMMSState(uuid)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if !mmsState.IsIncoming() || mmsState.ContentLocation == "" {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("%s is not a message of the MMS center", path))
+	}
+	request.UUID = uuid
+	request.ContentLocation = mmsState.ContentLocation
+	service.forwardChan <- &request
+	return nil
+}
+
+// ReplyForward answers the Forward call of request with the Message-ID of
+// the forwarded message, or with err if it was not forwarded.
+func (service *MMSService) ReplyForward(request *ForwardRequest, messageId string, err error) error {
+	reply := dbus.NewMethodReturnMessage(request.call)
+	if err == nil {
+		err = reply.AppendArgs(messageId)
+	}
+	if err != nil {
+		reply = dbus.NewErrorMessage(request.call, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return service.conn.Send(reply)
+}
@@ -0,0 +1,93 @@
+package telepathy
+
+import (
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/storage"
+)
+
+// CollectGarbage removes downloaded messages which were read in the history
+// service, as limited by the GCMaxSize and GCMaxAge settings. The history
+// service keeps its own copy of them.
+func (manager *MMSManager) CollectGarbage() (storage.GCStatus, error) {
+	settings := manager.settings.Get()
+	policy := storage.GCPolicy{MaxSize: settings.GCMaxSize, MaxAge: settings.GCMaxAgeDuration()}
+	services := make(map[string]*MMSService)
+	for _, service := range manager.services {
+		services[service.identity] = service
+	}
+	evictable := func(uuid string, mmsState storage.MMSState) bool {
+		service, ok := services[mmsState.ModemId]
+		if !ok || mmsState.State != storage.RESPONDED || mmsState.Quarantined {
+			return false
+		}
+		eventId := string(service.GenMessagePath(uuid))
+		hsMessage, err := service.HistoryService().GetMessage(eventId)
+		if err != nil {
+			logger.Errorf("Error getting message %s from HistoryService: %v", eventId, err)
+			return false
+		}
+		if !hsMessage.Exists() {
+			return true
+		}
+		isNew, err := hsMessage.IsNew()
+		return err == nil && !isNew
+	}
+	remove := func(uuid string) error {
+		mmsState, err := manager.storage.GetMMSState(uuid)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Collecting message %s", uuid)
+		service := services[mmsState.ModemId]
+		if err := service.MessageRemoved(service.GenMessagePath(uuid)); err != nil {
+			// Telepathy has no handler for it anymore.
+			return manager.storage.Destroy(uuid)
+		}
+		return nil
+	}
+	status, err := manager.storage.GC(policy, evictable, remove)
+	if err == nil && status.Collected > 0 {
+		logger.Infof("Collected %d messages freeing %d bytes, %d bytes stored", status.Collected, status.Freed, status.Size)
+	}
+	return status, err
+}
+
+// gcStatusProperties returns status in the form it is exposed on D-Bus.
+func gcStatusProperties(status storage.GCStatus) map[string]dbus.Variant {
+	var lastRun int64
+	if !status.LastRun.IsZero() {
+		lastRun = status.LastRun.Unix()
+	}
+	return map[string]dbus.Variant{
+		"Size":      dbus.MakeVariant(status.Size),
+		"Messages":  dbus.MakeVariant(uint32(status.Messages)),
+		"LastRun":   dbus.MakeVariant(lastRun),
+		"Collected": dbus.MakeVariant(uint32(status.Collected)),
+		"Freed":     dbus.MakeVariant(status.Freed),
+	}
+}
+
+// collectGarbage runs a garbage collection and replies with its status.
+func (manager *MMSManager) collectGarbage(msg *dbus.Message) *dbus.Message {
+	status, err := manager.CollectGarbage()
+	return manager.gcReply(msg, status, err)
+}
+
+// getStorageStatus replies with the status of the storage.
+func (manager *MMSManager) getStorageStatus(msg *dbus.Message) *dbus.Message {
+	status, err := manager.storage.Status()
+	return manager.gcReply(msg, status, err)
+}
+
+func (manager *MMSManager) gcReply(msg *dbus.Message, status storage.GCStatus, err error) *dbus.Message {
+	if err != nil {
+		logger.Error("Cannot inspect storage: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(gcStatusProperties(status)); err != nil {
+		logger.Error("Cannot append storage status: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
@@ -0,0 +1,71 @@
+package telepathy
+
+import (
+	"sync"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// messageHandlers holds the message interfaces of a service by object path.
+// Messages are added and removed by the mediator, by the D-Bus method calls
+// on the service and its messages and by consumers leaving, concurrently.
+// Only the lookup is locked: the interfaces are closed and signal outside of
+// the lock, so a handler removed by one goroutine may still be used by
+// another which looked it up before, which only sends a stale signal.
+type messageHandlers struct {
+	lock     sync.Mutex
+	handlers map[dbus.ObjectPath]*MessageInterface
+}
+
+// get returns the handler of path.
+func (h *messageHandlers) get(path dbus.ObjectPath) (*MessageInterface, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	msgInterface, ok := h.handlers[path]
+	return msgInterface, ok
+}
+
+// add makes msgInterface the handler of path, replacing any previous one.
+func (h *messageHandlers) add(path dbus.ObjectPath, msgInterface *MessageInterface) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[dbus.ObjectPath]*MessageInterface)
+	}
+	h.handlers[path] = msgInterface
+}
+
+// remove removes the handler of path and returns it, to be closed by the
+// caller. Of concurrent removals of a path only one gets the handler.
+func (h *messageHandlers) remove(path dbus.ObjectPath) (*MessageInterface, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	msgInterface, ok := h.handlers[path]
+	if ok {
+		delete(h.handlers, path)
+	}
+	return msgInterface, ok
+}
+
+// removeAll removes every handler and returns them.
+func (h *messageHandlers) removeAll() []*MessageInterface {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var removed []*MessageInterface
+	for _, msgInterface := range h.handlers {
+		removed = append(removed, msgInterface)
+	}
+	h.handlers = nil
+	return removed
+}
+
+// list returns a copy of the handlers.
+func (h *messageHandlers) list() map[dbus.ObjectPath]*MessageInterface {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	handlers := make(map[dbus.ObjectPath]*MessageInterface, len(h.handlers))
+	for path, msgInterface := range h.handlers {
+		handlers[path] = msgInterface
+	}
+	return handlers
+}
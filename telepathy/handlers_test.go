@@ -0,0 +1,57 @@
+package telepathy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// TestMessageHandlersConcurrent adds, looks up and removes handlers from
+// many goroutines, meant to be run with the race detector.
+func TestMessageHandlersConcurrent(t *testing.T) {
+	const paths, removers = 64, 4
+	var h messageHandlers
+	var removed [paths]int32
+	var wg sync.WaitGroup
+	for i := 0; i < paths; i++ {
+		path := dbus.ObjectPath(fmt.Sprintf("/org/ofono/mms/%d", i))
+		msgInterface := &MessageInterface{objectPath: path, status: DRAFT, properties: make(map[string]dbus.Variant)}
+		h.add(path, msgInterface)
+		for j := 0; j < removers; j++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, ok := h.remove(path); ok {
+					atomic.AddInt32(&removed[i], 1)
+				}
+			}(i)
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if msgInterface, ok := h.get(path); ok {
+				msgInterface.Status()
+				msgInterface.GetPayload()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for _, msgInterface := range h.list() {
+				msgInterface.Status()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, n := range removed {
+		if n != 1 {
+			t.Errorf("handler %d was removed %d times", i, n)
+		}
+	}
+	if left := h.removeAll(); len(left) != 0 {
+		t.Errorf("%d handlers left", len(left))
+	}
+}
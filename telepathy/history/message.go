@@ -3,7 +3,7 @@ package history
 import (
 	"fmt"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 type Message map[string]dbus.Variant
@@ -38,9 +38,9 @@ func (m Message) IsNew() (bool, error) {
 		return false, ErrorMessagePropertyMissing("newEvent")
 	}
 
-	newEvent, ok := v.Value.(bool)
+	newEvent, ok := v.Value().(bool)
 	if !ok {
-		return false, ErrorMessagePropertyType{"newEvent", false, v.Value}
+		return false, ErrorMessagePropertyType{"newEvent", false, v.Value()}
 	}
 
 	return newEvent, nil
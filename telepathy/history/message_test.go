@@ -4,7 +4,7 @@ import (
 	"reflect"
 	"testing"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 func TestMessage_Exists(t *testing.T) {
@@ -16,7 +16,7 @@ func TestMessage_Exists(t *testing.T) {
 		{},
 		{"nil", Message(nil), false},
 		{"empty", Message{}, true},
-		{"not empty", Message{"aaa": dbus.Variant{nil}}, true},
+		{"not empty", Message{"aaa": dbus.Variant{}}, true},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -37,11 +37,11 @@ func TestMessage_IsNew(t *testing.T) {
 	}{
 		{"nil", Message(nil), false, ErrorNonExistentMessage},
 		{"empty", Message{}, false, ErrorMessagePropertyMissing("newEvent")},
-		{"missing", Message{"aaa": dbus.Variant{nil}}, false, ErrorMessagePropertyMissing("newEvent")},
-		{"wrong type nil", Message{"newEvent": dbus.Variant{nil}}, false, ErrorMessagePropertyType{"newEvent", false, nil}},
-		{"wrong type int", Message{"newEvent": dbus.Variant{10}}, false, ErrorMessagePropertyType{"newEvent", false, 10}},
-		{"true", Message{"newEvent": dbus.Variant{true}}, true, nil},
-		{"false", Message{"newEvent": dbus.Variant{false}}, false, nil},
+		{"missing", Message{"aaa": dbus.Variant{}}, false, ErrorMessagePropertyMissing("newEvent")},
+		{"wrong type nil", Message{"newEvent": dbus.Variant{}}, false, ErrorMessagePropertyType{"newEvent", false, nil}},
+		{"wrong type int", Message{"newEvent": dbus.MakeVariant(10)}, false, ErrorMessagePropertyType{"newEvent", false, 10}},
+		{"true", Message{"newEvent": dbus.MakeVariant(true)}, true, nil},
+		{"false", Message{"newEvent": dbus.MakeVariant(false)}, false, nil},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"log"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 // HistoryService allows to communicate with message history service through dbus.
@@ -50,10 +50,10 @@ func (service *HistoryService) GetMessage(eventId string) (Message, error) {
 	eventType := int32(0) // History::EventTypeText
 	sort := map[string]dbus.Variant(nil)
 	filter := map[string]dbus.Variant{
-		"filterType":     dbus.Variant{int32(0)}, // FilterTypeStandard
-		"filterProperty": dbus.Variant{"eventId"},
-		"filterValue":    dbus.Variant{eventId},
-		"matchFlags":     dbus.Variant{int32(1)}, // MatchCaseSensitive
+		"filterType":     dbus.MakeVariant(int32(0)), // FilterTypeStandard
+		"filterProperty": dbus.MakeVariant("eventId"),
+		"filterValue":    dbus.MakeVariant(eventId),
+		"matchFlags":     dbus.MakeVariant(int32(1)), // MatchCaseSensitive
 	}
 	call.AppendArgs(eventType, sort, filter)
 	reply, err := service.conn.SendWithReply(call)
@@ -23,28 +23,35 @@ package telepathy
 
 import (
 	"fmt"
-	"log"
+	"sync"
 
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/internal/dbus"
 	"github.com/ubports/nuntium/mms"
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/storage"
 )
 
 type MMSManager struct {
 	conn     *dbus.Connection
 	msgChan  chan *dbus.Message
 	services []*MMSService
+	settings *config.Store
+	storage  storage.Storage
+	// debugState returns what the Debug interface dumps.
+	debugLock  sync.Mutex
+	debugState func() interface{}
 }
 
-func NewMMSManager(conn *dbus.Connection) (*MMSManager, error) {
+func NewMMSManager(conn *dbus.Connection, settings *config.Store, store storage.Storage) (*MMSManager, error) {
 	name := conn.RequestName(MMS_DBUS_NAME, dbus.NameFlagDoNotQueue)
 	err := <-name.C
 	if err != nil {
 		return nil, fmt.Errorf("Could not aquire name %s", MMS_DBUS_NAME)
 	}
 
-	log.Printf("Registered %s on bus as %s", conn.UniqueName, name.Name)
+	logger.Infof("Registered %s on bus as %s", conn.UniqueName, name.Name)
 
-	manager := MMSManager{conn: conn, msgChan: make(chan *dbus.Message)}
+	manager := MMSManager{conn: conn, msgChan: make(chan *dbus.Message), settings: settings, storage: store}
 	go manager.watchDBusMethodCalls()
 	conn.RegisterObjectPath(MMS_DBUS_PATH, manager.msgChan)
 	return &manager, nil
@@ -56,14 +63,40 @@ func (manager *MMSManager) watchDBusMethodCalls() {
 	for msg := range manager.msgChan {
 		switch {
 		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "GetServices":
-			log.Print("Received GetServices()")
+			logger.Debug("Received GetServices()")
 			reply = manager.getServices(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "GetDownloadPolicies":
+			reply = manager.getDownloadPolicies(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "SetDownloadPolicy":
+			reply = manager.setDownloadPolicy(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "RemoveDownloadPolicy":
+			reply = manager.removeDownloadPolicy(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "GetBlockedSenders":
+			reply = manager.getBlockedSenders(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "BlockSender":
+			reply = manager.blockSender(msg)
+		case msg.Interface == MMS_MANAGER_DBUS_IFACE && msg.Member == "UnblockSender":
+			reply = manager.unblockSender(msg)
+		case msg.Interface == MMS_SETTINGS_DBUS_IFACE && msg.Member == "GetProperties":
+			reply = manager.getSettings(msg)
+		case msg.Interface == MMS_SETTINGS_DBUS_IFACE && msg.Member == "SetProperty":
+			reply = manager.setSetting(msg)
+		case msg.Interface == MMS_STORAGE_DBUS_IFACE && msg.Member == "CollectGarbage":
+			reply = manager.collectGarbage(msg)
+		case msg.Interface == MMS_STORAGE_DBUS_IFACE && msg.Member == "GetStatus":
+			reply = manager.getStorageStatus(msg)
+		case msg.Interface == MMS_DEBUG_DBUS_IFACE && msg.Member == "Dump":
+			reply = manager.dumpDebugState(msg)
+		case msg.Interface == MMS_STATISTICS_DBUS_IFACE && msg.Member == "GetStatistics":
+			reply = manager.getStatistics(msg)
+		case msg.Interface == MMS_STATISTICS_DBUS_IFACE && msg.Member == "ResetStatistics":
+			reply = manager.resetStatistics(msg)
 		default:
-			log.Println("Received unkown method call on", msg.Interface, msg.Member)
+			logger.Warn("Received unkown method call on ", msg.Interface, " ", msg.Member)
 			reply = dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", "Unknown method")
 		}
 		if err := manager.conn.Send(reply); err != nil {
-			log.Print("Could not send reply: ", err)
+			logger.Error("Could not send reply: ", err)
 		}
 	}
 }
@@ -75,14 +108,14 @@ func (manager *MMSManager) getServices(msg *dbus.Message) *dbus.Message {
 	}
 	reply := dbus.NewMethodReturnMessage(msg)
 	if err := reply.AppendArgs(payloads); err != nil {
-		log.Print("Cannot parse payload data from services")
+		logger.Error("Cannot parse payload data from services")
 		return dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse services")
 	}
 	return reply
 }
 
 func (manager *MMSManager) serviceAdded(payload *Payload) error {
-	log.Print("Service added ", payload.Path)
+	logger.Info("Service added ", payload.Path)
 	signal := dbus.NewSignalMessage(MMS_DBUS_PATH, MMS_MANAGER_DBUS_IFACE, serviceAddedSignal)
 	if err := signal.AppendArgs(payload.Path, payload.Properties); err != nil {
 		return err
@@ -93,13 +126,13 @@ func (manager *MMSManager) serviceAdded(payload *Payload) error {
 	return nil
 }
 
-func (manager *MMSManager) AddService(identity string, modemObjPath dbus.ObjectPath, outgoingChannel chan *OutgoingMessage, useDeliveryReports bool, mNotificationIndChan chan<- *mms.MNotificationInd) (*MMSService, error) {
+func (manager *MMSManager) AddService(identity string, modemObjPath dbus.ObjectPath, outgoingChannel chan *OutgoingMessage, useDeliveryReports, mmsdCompatible bool, mNotificationIndChan, mNotificationIndRejectChan chan<- *mms.MNotificationInd, markReadChan, cancelChan, resendChan chan<- string, configureContextChan chan<- *ContextConfiguration, mmboxChan chan<- *MMBoxRequest, forwardChan chan<- *ForwardRequest, cancelDeliveryChan chan<- *CancelDeliveryRequest) (*MMSService, error) {
 	for i := range manager.services {
 		if manager.services[i].isService(identity) {
 			return manager.services[i], nil
 		}
 	}
-	service := NewMMSService(manager.conn, modemObjPath, identity, outgoingChannel, useDeliveryReports, mNotificationIndChan)
+	service := NewMMSService(manager.conn, manager.storage, manager.settings, modemObjPath, identity, outgoingChannel, useDeliveryReports, mmsdCompatible, mNotificationIndChan, mNotificationIndRejectChan, markReadChan, cancelChan, resendChan, configureContextChan, mmboxChan, forwardChan, cancelDeliveryChan)
 	if err := manager.serviceAdded(&service.payload); err != nil {
 		return &MMSService{}, err
 	}
@@ -108,7 +141,7 @@ func (manager *MMSManager) AddService(identity string, modemObjPath dbus.ObjectP
 }
 
 func (manager *MMSManager) serviceRemoved(payload *Payload) error {
-	log.Print("Service removed ", payload.Path)
+	logger.Info("Service removed ", payload.Path)
 	signal := dbus.NewSignalMessage(MMS_DBUS_PATH, MMS_MANAGER_DBUS_IFACE, serviceRemovedSignal)
 	if err := signal.AppendArgs(payload.Path); err != nil {
 		return err
@@ -125,9 +158,19 @@ func (manager *MMSManager) RemoveService(identity string) error {
 			manager.serviceRemoved(&manager.services[i].payload)
 			manager.services[i].Close()
 			manager.services = append(manager.services[:i], manager.services[i+1:]...)
-			log.Print("Service left: ", len(manager.services))
+			logger.Info("Service left: ", len(manager.services))
 			return nil
 		}
 	}
 	return fmt.Errorf("Cannot find service serving %s", identity)
 }
+
+// Close removes the remaining services and the manager from the bus.
+func (manager *MMSManager) Close() {
+	for _, service := range manager.services {
+		manager.serviceRemoved(&service.payload)
+		service.Close()
+	}
+	manager.services = nil
+	manager.conn.UnregisterObjectPath(MMS_DBUS_PATH)
+}
@@ -23,16 +23,16 @@ package telepathy
 
 import (
 	"fmt"
-	"log"
 	"sort"
+	"sync"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 var validStatus sort.StringSlice
 
 func init() {
-	validStatus = sort.StringSlice{SENT, PERMANENT_ERROR, TRANSIENT_ERROR}
+	validStatus = sort.StringSlice{DRAFT, SENT, PERMANENT_ERROR, TRANSIENT_ERROR, WAITING_FOR_NETWORK, READ_BY_RECIPIENT, CANCELLED}
 	sort.Strings(validStatus)
 }
 
@@ -42,42 +42,140 @@ type MessageInterface struct {
 	msgChan        chan *dbus.Message
 	deleteChan     chan dbus.ObjectPath
 	redownloadChan chan dbus.ObjectPath
-	status         string
+	markReadChan   chan dbus.ObjectPath
+	cancelChan     chan dbus.ObjectPath
+	resendChan     chan dbus.ObjectPath
+	// done is closed by Close to give up the sends of the method calls
+	// being handled, exited once watchDBusMethodCalls returned.
+	done   chan struct{}
+	exited chan struct{}
+
+	// stateLock guards status and properties, which the mediator changes
+	// while clients list the messages.
+	stateLock sync.Mutex
+	status    string
+	// properties are the properties which changed since the message was
+	// added.
+	properties map[string]dbus.Variant
+	// added are the properties the message was added with.
+	added map[string]dbus.Variant
+
+	// holders are the consumers which did not delete the message yet.
+	holdLock        sync.Mutex
+	holders         map[string]bool
+	deleteRequested bool
+
+	// redownloadLock guards redownloadChan, which changes with the
+	// download error of the message.
+	redownloadLock sync.Mutex
+
+	// standard answers the Introspectable and Properties calls.
+	standard standardInterfaces
+
+	// restored is true for the handlers the mmsd compatibility mode serves
+	// for stored messages, which are replaced when the message is added
+	// again. It is set before the handler is shared.
+	restored bool
 }
 
-func NewMessageInterface(conn *dbus.Connection, objectPath dbus.ObjectPath, deleteChan chan dbus.ObjectPath, redownloadChan chan dbus.ObjectPath) *MessageInterface {
+func NewMessageInterface(conn *dbus.Connection, objectPath dbus.ObjectPath, deleteChan, redownloadChan, markReadChan, cancelChan, resendChan chan dbus.ObjectPath) *MessageInterface {
 	msgInterface := MessageInterface{
 		conn:           conn,
 		objectPath:     objectPath,
 		deleteChan:     deleteChan,
 		redownloadChan: redownloadChan,
+		markReadChan:   markReadChan,
+		cancelChan:     cancelChan,
+		resendChan:     resendChan,
 		msgChan:        make(chan *dbus.Message),
+		done:           make(chan struct{}),
+		exited:         make(chan struct{}),
 		status:         "draft",
+		properties:     make(map[string]dbus.Variant),
+	}
+	msgInterface.standard = standardInterfaces{
+		iface:      MMS_MESSAGE_DBUS_IFACE,
+		members:    messageMembers,
+		properties: msgInterface.allProperties,
 	}
 	go msgInterface.watchDBusMethodCalls()
 	conn.RegisterObjectPath(msgInterface.objectPath, msgInterface.msgChan)
 	return &msgInterface
 }
 
+// Close unregisters the message from the bus and stops handling its method
+// calls. Once it returns nothing is sent on the channels of the service
+// anymore. It must be called once.
 func (msgInterface *MessageInterface) Close() {
-	close(msgInterface.msgChan)
-	msgInterface.msgChan = nil
 	msgInterface.conn.UnregisterObjectPath(msgInterface.objectPath)
+	close(msgInterface.done)
+	close(msgInterface.msgChan)
+	<-msgInterface.exited
+}
+
+// send passes the message on to the service through ch, unless it is
+// closed meanwhile.
+func (msgInterface *MessageInterface) send(ch chan dbus.ObjectPath) {
+	select {
+	case ch <- msgInterface.objectPath:
+	case <-msgInterface.done:
+		logger.Infof("%s was closed before the call was handled", msgInterface.objectPath)
+	}
+}
+
+// hold makes the message kept until every one of consumers released it.
+func (msgInterface *MessageInterface) hold(consumers []string) {
+	msgInterface.holdLock.Lock()
+	defer msgInterface.holdLock.Unlock()
+	msgInterface.holders = make(map[string]bool)
+	for _, name := range consumers {
+		msgInterface.holders[name] = true
+	}
+}
+
+// release drops the hold of consumer on the message, deleting requests the
+// deletion of the message. It returns true once the deletion was requested
+// and no consumer holds the message anymore.
+func (msgInterface *MessageInterface) release(consumer string, deleting bool) bool {
+	msgInterface.holdLock.Lock()
+	defer msgInterface.holdLock.Unlock()
+	if deleting {
+		msgInterface.deleteRequested = true
+	} else if !msgInterface.holders[consumer] {
+		return false
+	}
+	delete(msgInterface.holders, consumer)
+	return msgInterface.deleteRequested && len(msgInterface.holders) == 0
+}
+
+// setRedownloadChan allows the redownload of the message through
+// redownloadChan, or forbids it if nil.
+func (msgInterface *MessageInterface) setRedownloadChan(redownloadChan chan dbus.ObjectPath) {
+	msgInterface.redownloadLock.Lock()
+	defer msgInterface.redownloadLock.Unlock()
+	msgInterface.redownloadChan = redownloadChan
 }
 
 func (msgInterface *MessageInterface) watchDBusMethodCalls() {
+	defer close(msgInterface.exited)
 	var reply *dbus.Message
 
 	for msg := range msgInterface.msgChan {
+		if reply = msgInterface.standard.reply(msg); reply != nil {
+			if err := msgInterface.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+			continue
+		}
 		if msg.Interface != MMS_MESSAGE_DBUS_IFACE {
-			log.Println("Received unknown interface call on", msg.Interface, msg.Member)
+			logger.Warn("Received unknown interface call on ", msg.Interface, " ", msg.Member)
 			reply = dbus.NewErrorMessage(
 				msg,
 				"org.freedesktop.DBus.Error.UnknownInterface",
 				fmt.Sprintf("No such interface '%s' at object path '%s'", msg.Interface, msg.Path),
 			)
 			if err := msgInterface.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 			continue
 		}
@@ -86,33 +184,70 @@ func (msgInterface *MessageInterface) watchDBusMethodCalls() {
 			reply = dbus.NewMethodReturnMessage(msg)
 			//TODO implement store and forward
 			if err := msgInterface.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 			if msgInterface.deleteChan == nil {
-				log.Printf("Deletion of %s is not allowed", msg.Path)
+				logger.Infof("Deletion of %s is not allowed", msg.Path)
+				continue
+			}
+			if !msgInterface.release(msg.Sender, true) {
+				logger.Infof("Deletion of %s by %s postponed, other consumers hold it", msg.Path, msg.Sender)
 				continue
 			}
-			msgInterface.deleteChan <- msgInterface.objectPath
+			msgInterface.send(msgInterface.deleteChan)
 		case "Redownload":
 			reply = dbus.NewMethodReturnMessage(msg)
 			//TODO implement store and forward
 			if err := msgInterface.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
-			if msgInterface.redownloadChan == nil {
-				log.Printf("Redownload of %s is not allowed", msg.Path)
+			msgInterface.redownloadLock.Lock()
+			redownloadChan := msgInterface.redownloadChan
+			msgInterface.redownloadLock.Unlock()
+			if redownloadChan == nil {
+				logger.Infof("Redownload of %s is not allowed", msg.Path)
 				continue
 			}
-			msgInterface.redownloadChan <- msgInterface.objectPath
+			msgInterface.send(redownloadChan)
+		case "MarkRead":
+			reply = dbus.NewMethodReturnMessage(msg)
+			if err := msgInterface.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+			if msgInterface.markReadChan == nil {
+				logger.Infof("Marking %s as read is not allowed", msg.Path)
+				continue
+			}
+			msgInterface.send(msgInterface.markReadChan)
+		case "Cancel":
+			reply = dbus.NewMethodReturnMessage(msg)
+			if err := msgInterface.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+			if msgInterface.cancelChan == nil {
+				logger.Infof("Cancelling %s is not allowed", msg.Path)
+				continue
+			}
+			msgInterface.send(msgInterface.cancelChan)
+		case "Resend":
+			reply = dbus.NewMethodReturnMessage(msg)
+			if err := msgInterface.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+			if msgInterface.resendChan == nil {
+				logger.Infof("Resending %s is not allowed", msg.Path)
+				continue
+			}
+			msgInterface.send(msgInterface.resendChan)
 		default:
-			log.Println("Received unknown method call on", msg.Interface, msg.Member)
+			logger.Warn("Received unknown method call on ", msg.Interface, " ", msg.Member)
 			reply = dbus.NewErrorMessage(
 				msg,
 				"org.freedesktop.DBus.Error.UnknownMethod",
 				fmt.Sprintf("No such method '%s' at object path '%s'", msg.Member, msg.Path),
 			)
 			if err := msgInterface.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 		}
 	}
@@ -121,25 +256,76 @@ func (msgInterface *MessageInterface) watchDBusMethodCalls() {
 func (msgInterface *MessageInterface) StatusChanged(status string) error {
 	i := validStatus.Search(status)
 	if i < validStatus.Len() && validStatus[i] == status {
+		msgInterface.stateLock.Lock()
 		msgInterface.status = status
-		signal := dbus.NewSignalMessage(msgInterface.objectPath, MMS_MESSAGE_DBUS_IFACE, propertyChangedSignal)
-		if err := signal.AppendArgs(statusProperty, dbus.Variant{status}); err != nil {
-			return err
-		}
-		if err := msgInterface.conn.Send(signal); err != nil {
-			return err
-		}
-		log.Print("Status changed for ", msgInterface.objectPath, " to ", status)
-		return nil
+		msgInterface.properties[statusProperty] = dbus.MakeVariant(status)
+		msgInterface.stateLock.Unlock()
+		return signalStatusChanged(msgInterface.conn, msgInterface.objectPath, status)
 	}
 	return fmt.Errorf("status %s is not a valid status", status)
 }
 
+// signalStatusChanged signals the status change of the message at
+// objectPath.
+func signalStatusChanged(conn *dbus.Connection, objectPath dbus.ObjectPath, status string) error {
+	if err := signalPropertyChanged(conn, objectPath, MMS_MESSAGE_DBUS_IFACE, statusProperty, dbus.MakeVariant(status)); err != nil {
+		return err
+	}
+	logger.Info("Status changed for ", objectPath, " to ", status)
+	return nil
+}
+
+// PropertyChanged sets the property name of the message to value and
+// signals the change.
+func (msgInterface *MessageInterface) PropertyChanged(name string, value dbus.Variant) error {
+	msgInterface.stateLock.Lock()
+	msgInterface.properties[name] = value
+	msgInterface.stateLock.Unlock()
+	return signalPropertyChanged(msgInterface.conn, msgInterface.objectPath, MMS_MESSAGE_DBUS_IFACE, name, value)
+}
+
+// Status returns the current status of the message.
+func (msgInterface *MessageInterface) Status() string {
+	msgInterface.stateLock.Lock()
+	defer msgInterface.stateLock.Unlock()
+	return msgInterface.status
+}
+
 func (msgInterface *MessageInterface) GetPayload() *Payload {
+	msgInterface.stateLock.Lock()
+	defer msgInterface.stateLock.Unlock()
 	properties := make(map[string]dbus.Variant)
-	properties["Status"] = dbus.Variant{msgInterface.status}
+	for name, value := range msgInterface.properties {
+		properties[name] = value
+	}
+	properties["Status"] = dbus.MakeVariant(msgInterface.status)
 	return &Payload{
 		Path:       msgInterface.objectPath,
 		Properties: properties,
 	}
 }
+
+// setAdded records the properties the message was added with.
+func (msgInterface *MessageInterface) setAdded(properties map[string]dbus.Variant) {
+	msgInterface.stateLock.Lock()
+	defer msgInterface.stateLock.Unlock()
+	msgInterface.added = properties
+}
+
+// allProperties returns every property of the message, those it was added
+// with updated with the ones which changed since.
+func (msgInterface *MessageInterface) allProperties() map[string]dbus.Variant {
+	msgInterface.stateLock.Lock()
+	defer msgInterface.stateLock.Unlock()
+	properties := make(map[string]dbus.Variant, len(msgInterface.added)+len(msgInterface.properties))
+	for name, value := range msgInterface.added {
+		properties[name] = value
+	}
+	for name, value := range msgInterface.properties {
+		properties[name] = value
+	}
+	if _, ok := properties[statusProperty]; !ok {
+		properties[statusProperty] = dbus.MakeVariant(msgInterface.status)
+	}
+	return properties
+}
@@ -0,0 +1,180 @@
+package telepathy
+
+import (
+	"sort"
+
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/storage"
+)
+
+// storedMessages returns the payloads of the messages of the service kept in
+// storage, so clients can resynchronize with GetMessages. Quarantined
+// messages, those held back while MMS is disabled and those deleted by the
+// user whose rejection waits for the network are left out, they are not
+// shown to clients.
+func (service *MMSService) storedMessages() []Payload {
+	var payloads []Payload
+	for _, uuid := range service.storage.GetStoredUUIDs() {
+		mmsState, err := service.storage.GetMMSState(uuid)
+		if err != nil {
+			logger.Errorf("Cannot get state of stored message %s: %v", uuid, err)
+			continue
+		}
+		if !service.shown(mmsState) {
+			continue
+		}
+		payloads = append(payloads, service.storedMessage(uuid, mmsState))
+	}
+	return payloads
+}
+
+// shown returns true if the stored message in state mmsState is one of the
+// service shown to clients.
+func (service *MMSService) shown(mmsState storage.MMSState) bool {
+	return mmsState.ModemId == service.identity && !mmsState.Quarantined && !mmsState.RejectPending && mmsState.State != storage.DISABLED
+}
+
+// registerStoredMessages serves the stored messages which have no object
+// yet, as upstream mmsd does on startup, so clients can delete the messages
+// GetMessages returns. No MessageAdded is signalled, clients learn about
+// them from GetMessages.
+func (service *MMSService) registerStoredMessages() {
+	for _, uuid := range service.storage.GetStoredUUIDs() {
+		mmsState, err := service.storage.GetMMSState(uuid)
+		if err != nil {
+			logger.Errorf("Cannot get state of stored message %s: %v", uuid, err)
+			continue
+		}
+		if !service.shown(mmsState) {
+			continue
+		}
+		path := service.GenMessagePath(uuid)
+		if _, ok := service.messageHandlers.get(path); ok {
+			continue
+		}
+		payload := service.storedMessage(uuid, mmsState)
+		var msgInterface *MessageInterface
+		switch {
+		case !mmsState.IsIncoming():
+			msgInterface = service.newMessageInterface(path, service.msgDeleteChan, nil, nil, service.msgCancelChan, service.msgResendChan)
+			// The status the message had, the interface is not shared yet.
+			msgInterface.status, _ = payload.Properties[statusProperty].Value().(string)
+		case mmsState.State == storage.RECEIVED || mmsState.State == storage.RESPONDED:
+			msgInterface = service.newMessageInterface(path, service.msgDeleteChan, nil, service.msgMarkReadChan, nil, nil)
+		default:
+			msgInterface = service.newMessageInterface(path, service.msgDeleteChan, nil, nil, nil, nil)
+		}
+		msgInterface.setAdded(payload.Properties)
+		msgInterface.restored = true
+		service.messageHandlers.add(path, msgInterface)
+	}
+}
+
+// dropRestored closes the handler the mmsd compatibility mode serves for
+// the stored message at path, if any, before the message is added again.
+func (service *MMSService) dropRestored(path dbus.ObjectPath) {
+	if msgInterface, ok := service.messageHandlers.get(path); !ok || !msgInterface.restored {
+		return
+	}
+	if msgInterface, ok := service.messageHandlers.remove(path); ok {
+		msgInterface.Close()
+	}
+}
+
+// storedMessage returns the payload of the stored message uuid in state
+// mmsState. Messages which were downloaded get the properties they were
+// added with, others the properties which are known from storage.
+func (service *MMSService) storedMessage(uuid string, mmsState storage.MMSState) Payload {
+	path := service.GenMessagePath(uuid)
+	if mmsState.State == storage.RECEIVED || mmsState.State == storage.RESPONDED {
+		if mRetConf, err := service.storedMRetrieveConf(uuid); err != nil {
+			logger.Errorf("Cannot decode stored message %s: %v", uuid, err)
+		} else if payload, err := service.parseMessage(mRetConf); err != nil {
+			logger.Errorf("Cannot parse stored message %s: %v", uuid, err)
+		} else {
+			if mmsState.MNotificationInd != nil && !mmsState.MNotificationInd.Received.IsZero() {
+				payload.Properties["Received"] = dbus.MakeVariant(mms.Epoch(mmsState.MNotificationInd.Received))
+			}
+			payload.Properties[readProperty] = dbus.MakeVariant(mmsState.Read)
+			spamProperties(mmsState, payload.Properties)
+			return payload
+		}
+	}
+
+	properties := make(map[string]dbus.Variant)
+	if mmsState.IsIncoming() {
+		properties["Status"] = dbus.MakeVariant("received")
+		if mmsState.MNotificationInd != nil {
+			properties["Sender"] = dbus.MakeVariant(address.Normalize(mmsState.MNotificationInd.From))
+			if !mmsState.MNotificationInd.Received.IsZero() {
+				properties["Received"] = dbus.MakeVariant(mms.Epoch(mmsState.MNotificationInd.Received))
+			}
+			notificationProperties(mmsState.MNotificationInd, properties)
+		}
+		debugInfoProperties(mmsState, properties)
+		return Payload{Path: path, Properties: properties}
+	}
+
+	status := "draft"
+	if msgInterface, ok := service.messageHandlers.get(path); ok {
+		status = msgInterface.Status()
+	} else if mmsState.State == storage.SENT {
+		status = SENT
+	} else if mmsState.State == storage.CANCELLED {
+		status = CANCELLED
+	} else if mmsState.State == storage.FAILED {
+		status = PERMANENT_ERROR
+	}
+	properties["Status"] = dbus.MakeVariant(status)
+	properties[deliveryReportRequestedProperty] = dbus.MakeVariant(mmsState.DeliveryReportRequested)
+	if mmsState.State == storage.SENT && mmsState.Id != "" {
+		properties[messageIdProperty] = dbus.MakeVariant(mmsState.Id)
+	}
+	var recipients []string
+	for recipient := range mmsState.SendState {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+	properties["Recipients"] = dbus.MakeVariant(recipients)
+	return Payload{Path: path, Properties: properties}
+}
+
+// spamProperties adds the Spam and SpamReason properties of a message filed
+// as spam in mmsState to properties.
+func spamProperties(mmsState storage.MMSState, properties map[string]dbus.Variant) {
+	if !mmsState.Spam {
+		return
+	}
+	properties[spamProperty] = dbus.MakeVariant(true)
+	properties[spamReasonProperty] = dbus.MakeVariant(mmsState.SpamReason)
+}
+
+// debugInfoProperties adds the DebugInfo property, the decoder log of a
+// message which could not be passed on, to properties.
+func debugInfoProperties(mmsState storage.MMSState, properties map[string]dbus.Variant) {
+	if mmsState.DecodeLog != "" {
+		properties[debugInfoProperty] = dbus.MakeVariant(mmsState.DecodeLog)
+	}
+}
+
+// addSpamProperties adds the spam properties of the stored message uuid to
+// properties.
+func (service *MMSService) addSpamProperties(uuid string, properties map[string]dbus.Variant) {
+	if mmsState, err := service.storage.GetMMSState(uuid); err == nil {
+		spamProperties(mmsState, properties)
+	}
+}
+
+func (service *MMSService) storedMRetrieveConf(uuid string) (*mms.MRetrieveConf, error) {
+	data, err := service.storage.ReadMMS(uuid)
+	if err != nil {
+		return nil, err
+	}
+	mRetConf := mms.NewMRetrieveConf(uuid)
+	if err := mms.NewDecoder(data).Decode(mRetConf); err != nil {
+		return nil, err
+	}
+	return mRetConf, nil
+}
@@ -0,0 +1,213 @@
+package telepathy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/mms"
+)
+
+// Names of the MM states of the MMBoxStore and MMBoxView calls.
+var mmStateValues = map[string]byte{
+	"draft":     mms.MMStateDraft,
+	"sent":      mms.MMStateSent,
+	"new":       mms.MMStateNew,
+	"retrieved": mms.MMStateRetrieved,
+	"forwarded": mms.MMStateForwarded,
+}
+
+// MMBoxRequest is a MMBoxStore or MMBoxView call, to be run as a transaction
+// with the MMS center of the modem.
+type MMBoxRequest struct {
+	// ContentLocation is the location of the message to store, set for
+	// MMBoxStore only.
+	ContentLocation string
+	// State is the MM state to store the message with, 0 to let the MMS
+	// center decide.
+	State byte
+	// Start, Limit and States select the messages MMBoxView lists, Limit
+	// is 0 for all and States empty for any state.
+	Start, Limit uint64
+	States       []byte
+	// call is the MMBoxStore or MMBoxView call, see ReplyMMBoxStore and
+	// ReplyMMBoxView.
+	call *dbus.Message
+}
+
+// IsView returns true for a MMBoxView call, false for a MMBoxStore one.
+func (request *MMBoxRequest) IsView() bool {
+	return request.call.Member == "MMBoxView"
+}
+
+// mmboxStore passes the MMBoxStore call msg on to the mediator. It returns
+// the error reply if its arguments are invalid, nil if the call is answered
+// once the message is stored.
+func (service *MMSService) mmboxStore(msg *dbus.Message) *dbus.Message {
+	var path dbus.ObjectPath
+	var state string
+	if err := msg.Args(&path, &state); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	request := MMBoxRequest{call: msg}
+	if state != "" {
+		var ok bool
+		if request.State, ok = mmStateValues[state]; !ok {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("unknown state %q", state))
+		}
+	}
+	mmsState, err := service.getMMSState(path)
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if !mmsState.IsIncoming() || mmsState.ContentLocation == "" {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("%s is not a message of the MMS center", path))
+	}
+	request.ContentLocation = mmsState.ContentLocation
+	service.mmboxChan <- &request
+	return nil
+}
+
+// mmboxView passes the MMBoxView call msg on to the mediator. It returns the
+// error reply if its options are invalid, nil if the call is answered once
+// the MMBox is listed.
+func (service *MMSService) mmboxView(msg *dbus.Message) *dbus.Message {
+	var options map[string]dbus.Variant
+	if err := msg.Args(&options); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	request := MMBoxRequest{call: msg}
+	for name, value := range options {
+		var ok bool
+		switch name {
+		case "Start":
+			var start uint32
+			start, ok = value.Value().(uint32)
+			request.Start = uint64(start)
+		case "Limit":
+			var limit uint32
+			limit, ok = value.Value().(uint32)
+			request.Limit = uint64(limit)
+		case "States":
+			var states []string
+			if states, ok = variantStrings(value); !ok {
+				break
+			}
+			for _, state := range states {
+				var mmState byte
+				if mmState, ok = mmStateValues[state]; !ok {
+					break
+				}
+				request.States = append(request.States, mmState)
+			}
+		default:
+			logger.Warnf("Ignoring unknown MMBoxView option %s", name)
+			ok = true
+		}
+		if !ok {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", fmt.Sprintf("invalid MMBoxView option %s: %v", name, value.Value()))
+		}
+	}
+	service.mmboxChan <- &request
+	return nil
+}
+
+// ReplyMMBoxStore answers the MMBoxStore call of request with the location
+// of the stored message, or with err if it was not stored.
+func (service *MMSService) ReplyMMBoxStore(request *MMBoxRequest, location string, err error) error {
+	reply := dbus.NewMethodReturnMessage(request.call)
+	if err == nil {
+		err = reply.AppendArgs(location)
+	}
+	if err != nil {
+		reply = dbus.NewErrorMessage(request.call, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return service.conn.Send(reply)
+}
+
+// ReplyMMBoxView answers the MMBoxView call of request with the messages
+// described in the MMBox listing and the total number of messages in the
+// MMBox, or with err if the MMBox could not be listed.
+func (service *MMSService) ReplyMMBoxView(request *MMBoxRequest, descriptions []*mms.MMboxDescr, total uint64, err error) error {
+	reply := dbus.NewMethodReturnMessage(request.call)
+	if err == nil {
+		messages := make([]map[string]dbus.Variant, 0, len(descriptions))
+		for _, descr := range descriptions {
+			messages = append(messages, mmboxProperties(descr))
+		}
+		err = reply.AppendArgs(messages, uint32(total))
+	}
+	if err != nil {
+		reply = dbus.NewErrorMessage(request.call, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return service.conn.Send(reply)
+}
+
+// mmboxProperties returns the properties MMBoxView lists for the message
+// described by descr.
+func mmboxProperties(descr *mms.MMboxDescr) map[string]dbus.Variant {
+	properties := map[string]dbus.Variant{
+		"ContentLocation": dbus.MakeVariant(descr.ContentLocation),
+	}
+	for name, value := range mmStateValues {
+		if value == descr.MMState {
+			properties["State"] = dbus.MakeVariant(name)
+		}
+	}
+	if descr.MessageId != "" {
+		properties[messageIdProperty] = dbus.MakeVariant(descr.MessageId)
+	}
+	if descr.Date != 0 {
+		properties["Date"] = dbus.MakeVariant(mms.FormatEpoch(int64(descr.Date)))
+	}
+	if descr.From != "" {
+		properties["Sender"] = dbus.MakeVariant(address.Normalize(descr.From))
+	}
+	if len(descr.To) > 0 {
+		var recipients []string
+		for _, to := range descr.To {
+			recipients = append(recipients, address.Normalize(to))
+		}
+		properties["Recipients"] = dbus.MakeVariant(recipients)
+	}
+	if descr.Subject != "" {
+		properties["Subject"] = dbus.MakeVariant(descr.Subject)
+	}
+	if descr.Size != 0 {
+		properties["MessageSize"] = dbus.MakeVariant(descr.Size)
+	}
+	if class := className(descr.Class); class != "" {
+		properties["MessageClass"] = dbus.MakeVariant(class)
+	}
+	if priority := priorityName(descr.Priority); priority != "" {
+		properties["Priority"] = dbus.MakeVariant(priority)
+	}
+	return properties
+}
+
+// mmboxRetrieve downloads the message at the content location of the
+// MMBoxRetrieve call msg like a notified one and returns the reply with the
+// path of the message.
+func (service *MMSService) mmboxRetrieve(msg *dbus.Message) *dbus.Message {
+	var location string
+	if err := msg.Args(&location); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if location == "" {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", "the content location is empty")
+	}
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.Version = mms.MMS_MESSAGE_VERSION_1_2
+	mNotificationInd.ContentLocation = location
+	mNotificationInd.FromMMBox = true
+	if _, err := service.storage.Create(service.identity, mNotificationInd); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(service.GenMessagePath(mNotificationInd.UUID)); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	service.mNotificationIndChan <- mNotificationInd
+	return reply
+}
@@ -0,0 +1,115 @@
+package telepathy
+
+import (
+	"fmt"
+
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/policy"
+)
+
+// getDownloadPolicies replies with the download policies as a map of sender
+// patterns to actions.
+func (manager *MMSManager) getDownloadPolicies(msg *dbus.Message) *dbus.Message {
+	policies, err := policy.Load()
+	if err != nil {
+		logger.Error("Cannot load download policies: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	actions := make(map[string]string)
+	for pattern, action := range policies {
+		actions[pattern] = string(action)
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(actions); err != nil {
+		logger.Error("Cannot append download policies: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
+
+// setDownloadPolicy sets the action for a sender pattern.
+func (manager *MMSManager) setDownloadPolicy(msg *dbus.Message) *dbus.Message {
+	var pattern, action string
+	if err := msg.Args(&pattern, &action); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	return manager.updateDownloadPolicies(msg, func(policies policy.Policies) error {
+		return policies.Set(pattern, policy.Action(action))
+	})
+}
+
+// removeDownloadPolicy removes the action for a sender pattern.
+func (manager *MMSManager) removeDownloadPolicy(msg *dbus.Message) *dbus.Message {
+	var pattern string
+	if err := msg.Args(&pattern); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	return manager.updateDownloadPolicies(msg, func(policies policy.Policies) error {
+		delete(policies, pattern)
+		return nil
+	})
+}
+
+// getBlockedSenders replies with the sender patterns whose messages are
+// blocked.
+func (manager *MMSManager) getBlockedSenders(msg *dbus.Message) *dbus.Message {
+	policies, err := policy.Load()
+	if err != nil {
+		logger.Error("Cannot load download policies: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	blocked := policies.Blocked()
+	if blocked == nil {
+		blocked = []string{}
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(blocked); err != nil {
+		logger.Error("Cannot append blocked senders: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
+
+// blockSender blocks the messages of a sender pattern, replacing any other
+// download policy of the pattern.
+func (manager *MMSManager) blockSender(msg *dbus.Message) *dbus.Message {
+	var pattern string
+	if err := msg.Args(&pattern); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	return manager.updateDownloadPolicies(msg, func(policies policy.Policies) error {
+		return policies.Set(pattern, policy.Block)
+	})
+}
+
+// unblockSender removes the block of a sender pattern, other download
+// policies of the pattern are left alone.
+func (manager *MMSManager) unblockSender(msg *dbus.Message) *dbus.Message {
+	var pattern string
+	if err := msg.Args(&pattern); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	return manager.updateDownloadPolicies(msg, func(policies policy.Policies) error {
+		if policies[pattern] != policy.Block {
+			return fmt.Errorf("%q is not blocked", pattern)
+		}
+		delete(policies, pattern)
+		return nil
+	})
+}
+
+func (manager *MMSManager) updateDownloadPolicies(msg *dbus.Message, update func(policy.Policies) error) *dbus.Message {
+	policies, err := policy.Load()
+	if err != nil {
+		logger.Error("Cannot load download policies: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	if err := update(policies); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if err := policy.Save(policies); err != nil {
+		logger.Error("Cannot save download policies: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return dbus.NewMethodReturnMessage(msg)
+}
@@ -25,36 +25,78 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ubports/nuntium/address"
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/i18n"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/media"
 	"github.com/ubports/nuntium/mms"
 	"github.com/ubports/nuntium/storage"
 	"github.com/ubports/nuntium/telepathy/history"
-	"launchpad.net/go-dbus/v1"
 )
 
-//Payload is used to build the dbus messages; this is a workaround as v1 of go-dbus
-//tries to encode and decode private fields.
+// Payload is an object path with its properties, the argument of the
+// MessageAdded and ServiceAdded signals and of the GetMessages and
+// GetServices replies.
 type Payload struct {
 	Path       dbus.ObjectPath
 	Properties map[string]dbus.Variant
 }
 
 type MMSService struct {
-	payload              Payload
+	payload Payload
+	// propertiesLock guards Properties, which the mediator changes while
+	// D-Bus calls read and set them.
+	propertiesLock       sync.Mutex
 	Properties           map[string]dbus.Variant
 	conn                 *dbus.Connection
 	msgChan              chan *dbus.Message
-	messageHandlers      map[dbus.ObjectPath]*MessageInterface
+	messageHandlers      messageHandlers
 	msgDeleteChan        chan dbus.ObjectPath
 	msgRedownloadChan    chan dbus.ObjectPath
+	msgMarkReadChan      chan dbus.ObjectPath
+	msgCancelChan        chan dbus.ObjectPath
+	msgResendChan        chan dbus.ObjectPath
 	identity             string
 	outMessage           chan *OutgoingMessage
 	mNotificationIndChan chan<- *mms.MNotificationInd
+	// mNotificationIndRejectChan receives the notifications of messages
+	// deleted before being downloaded, to be rejected.
+	mNotificationIndRejectChan chan<- *mms.MNotificationInd
+	// markReadChan receives the UUIDs of the messages the user read.
+	markReadChan chan<- string
+	// cancelChan receives the UUIDs of the outgoing messages the user
+	// cancelled.
+	cancelChan chan<- string
+	// resendChan receives the UUIDs of the failed outgoing messages the
+	// user wants to send again.
+	resendChan chan<- string
+	// configureContextChan receives the MMS context settings of
+	// ConfigureMMSContext calls, to be written to the context.
+	configureContextChan chan<- *ContextConfiguration
+	consumers            consumers
+	// mmboxChan receives the MMBoxStore and MMBoxView calls.
+	mmboxChan chan<- *MMBoxRequest
+	// forwardChan receives the Forward calls.
+	forwardChan chan<- *ForwardRequest
+	// cancelDeliveryChan receives the CancelDelivery calls.
+	cancelDeliveryChan chan<- *CancelDeliveryRequest
+	// storage holds the messages of the service.
+	storage storage.Storage
+	// settings holds the AutoDownloadLimit property, nil for the defaults
+	// which cannot be changed.
+	settings *config.Store
+	// standard answers the Introspectable and Properties calls.
+	standard standardInterfaces
+	// mmsdCompatible serves the API the way upstream mmsd does, see
+	// config.Settings.MmsdCompatibility.
+	mmsdCompatible bool
 }
 
 type Attachment struct {
@@ -65,6 +107,15 @@ type Attachment struct {
 	Length    uint64
 }
 
+// Card is a contact or calendar attachment of a received message, so
+// clients can offer to add it, with its Kind, "contact" or "event", and the
+// name or summary it shows.
+type Card struct {
+	Id    string
+	Kind  string
+	Title string
+}
+
 type OutAttachment struct {
 	Id          string
 	ContentType string
@@ -75,164 +126,427 @@ type OutgoingMessage struct {
 	Recipients  []string
 	Attachments []OutAttachment
 	Reply       *dbus.Message
+	// Cc and Bcc are the copy recipients given in the SendMessage options.
+	Cc, Bcc []string
+	// Group is false if the recipients must not see each other, it
+	// defaults to true.
+	Group bool
+	// Subject is the subject given in the SendMessage options.
+	Subject string
+	// DeliveryReport is the DeliveryReport SendMessage option, nil if it
+	// was not given and UseDeliveryReports applies.
+	DeliveryReport *bool
+	// Priority, Class and SenderVisibility are the X-Mms-Priority,
+	// X-Mms-Message-Class and X-Mms-Sender-Visibility values given in the
+	// SendMessage options, 0 if not given.
+	Priority, Class, SenderVisibility byte
+	// Store is the Store SendMessage option, the message is kept in the
+	// MMBox if true.
+	Store bool
+	// Smil is the SMIL presentation given to the SendMessage of mmsd
+	// clients, empty for none.
+	Smil string
+}
+
+// Names of the values of the Priority, Class and SenderVisibility
+// SendMessage options.
+var (
+	priorityValues = map[string]byte{
+		"low":    mms.PriorityLow,
+		"normal": mms.PriorityNormal,
+		"high":   mms.PriorityHigh,
+	}
+	classValues = map[string]byte{
+		"personal":      mms.ClassPersonal,
+		"advertisement": mms.ClassAdvertisement,
+		"informational": mms.ClassInformational,
+		"auto":          mms.ClassAuto,
+	}
+	senderVisibilityValues = map[string]byte{
+		"hide": mms.SenderVisibilityHide,
+		"show": mms.SenderVisibilityShow,
+	}
+)
+
+// parseSendOptions sets the options of the SendMessage call of outMessage.
+func (outMessage *OutgoingMessage) parseSendOptions(options map[string]dbus.Variant) error {
+	outMessage.Group = true
+	for name, value := range options {
+		var ok bool
+		switch name {
+		case "Cc":
+			outMessage.Cc, ok = variantStrings(value)
+		case "Bcc":
+			outMessage.Bcc, ok = variantStrings(value)
+		case "Group":
+			outMessage.Group, ok = value.Value().(bool)
+		case "Subject":
+			outMessage.Subject, ok = value.Value().(string)
+		case "DeliveryReport":
+			var deliveryReport bool
+			deliveryReport, ok = value.Value().(bool)
+			outMessage.DeliveryReport = &deliveryReport
+		case "Priority":
+			outMessage.Priority, ok = variantValue(value, priorityValues)
+		case "Class":
+			outMessage.Class, ok = variantValue(value, classValues)
+		case "SenderVisibility":
+			outMessage.SenderVisibility, ok = variantValue(value, senderVisibilityValues)
+		case "Store":
+			outMessage.Store, ok = value.Value().(bool)
+		default:
+			logger.Warnf("Ignoring unknown SendMessage option %s", name)
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("invalid SendMessage option %s: %v", name, value.Value())
+		}
+	}
+	return nil
+}
+
+// variantValue returns the header value named by the string in value.
+func variantValue(value dbus.Variant, values map[string]byte) (byte, bool) {
+	name, ok := value.Value().(string)
+	if !ok {
+		return 0, false
+	}
+	v, ok := values[strings.ToLower(name)]
+	return v, ok
+}
+
+// variantStrings returns the strings of the array of strings in value.
+func variantStrings(value dbus.Variant) ([]string, bool) {
+	switch v := value.Value().(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		strs := make([]string, len(v))
+		for i := range v {
+			s, ok := v[i].(string)
+			if !ok {
+				return nil, false
+			}
+			strs[i] = s
+		}
+		return strs, true
+	}
+	return nil, false
 }
 
-func NewMMSService(conn *dbus.Connection, modemObjPath dbus.ObjectPath, identity string, outgoingChannel chan *OutgoingMessage, useDeliveryReports bool, mNotificationIndChan chan<- *mms.MNotificationInd) *MMSService {
+func NewMMSService(conn *dbus.Connection, store storage.Storage, settings *config.Store, modemObjPath dbus.ObjectPath, identity string, outgoingChannel chan *OutgoingMessage, useDeliveryReports, mmsdCompatible bool, mNotificationIndChan, mNotificationIndRejectChan chan<- *mms.MNotificationInd, markReadChan, cancelChan, resendChan chan<- string, configureContextChan chan<- *ContextConfiguration, mmboxChan chan<- *MMBoxRequest, forwardChan chan<- *ForwardRequest, cancelDeliveryChan chan<- *CancelDeliveryRequest) *MMSService {
 	properties := make(map[string]dbus.Variant)
-	properties[identityProperty] = dbus.Variant{identity}
+	properties[identityProperty] = dbus.MakeVariant(identity)
+	properties[interfaceVersionProperty] = dbus.MakeVariant(InterfaceVersion)
 	serviceProperties := make(map[string]dbus.Variant)
-	serviceProperties[useDeliveryReportsProperty] = dbus.Variant{useDeliveryReports}
-	serviceProperties[modemObjectPathProperty] = dbus.Variant{modemObjPath}
+	serviceProperties[interfaceVersionProperty] = dbus.MakeVariant(InterfaceVersion)
+	serviceProperties[useDeliveryReportsProperty] = dbus.MakeVariant(useDeliveryReports)
+	serviceProperties[modemObjectPathProperty] = dbus.MakeVariant(modemObjPath)
+	serviceProperties[localeProperty] = dbus.MakeVariant(i18n.DefaultLocale())
+	serviceProperties[dataSaverProperty] = dbus.MakeVariant(false)
 	payload := Payload{
 		Path:       dbus.ObjectPath(MMS_DBUS_PATH + "/" + identity),
 		Properties: properties,
 	}
 	service := MMSService{
-		payload:              payload,
-		Properties:           serviceProperties,
-		conn:                 conn,
-		msgChan:              make(chan *dbus.Message),
-		msgDeleteChan:        make(chan dbus.ObjectPath),
-		msgRedownloadChan:    make(chan dbus.ObjectPath),
-		messageHandlers:      make(map[dbus.ObjectPath]*MessageInterface),
-		outMessage:           outgoingChannel,
-		identity:             identity,
-		mNotificationIndChan: mNotificationIndChan,
+		payload:                    payload,
+		Properties:                 serviceProperties,
+		conn:                       conn,
+		msgChan:                    make(chan *dbus.Message),
+		msgDeleteChan:              make(chan dbus.ObjectPath),
+		msgRedownloadChan:          make(chan dbus.ObjectPath),
+		msgMarkReadChan:            make(chan dbus.ObjectPath),
+		msgCancelChan:              make(chan dbus.ObjectPath),
+		msgResendChan:              make(chan dbus.ObjectPath),
+		outMessage:                 outgoingChannel,
+		identity:                   identity,
+		mNotificationIndChan:       mNotificationIndChan,
+		mNotificationIndRejectChan: mNotificationIndRejectChan,
+		markReadChan:               markReadChan,
+		cancelChan:                 cancelChan,
+		resendChan:                 resendChan,
+		configureContextChan:       configureContextChan,
+		mmboxChan:                  mmboxChan,
+		forwardChan:                forwardChan,
+		cancelDeliveryChan:         cancelDeliveryChan,
+		storage:                    store,
+		settings:                   settings,
+		mmsdCompatible:             mmsdCompatible,
+	}
+	service.standard = standardInterfaces{
+		iface:      MMS_SERVICE_DBUS_IFACE,
+		members:    serviceMembers,
+		properties: service.properties,
+		set:        service.setPropertyValue,
+		writable: map[string]bool{
+			preferredContextProperty:  true,
+			localeProperty:            true,
+			autoDownloadLimitProperty: true,
+		},
+		children: service.messageNodes,
 	}
 	go service.watchDBusMethodCalls()
 	go service.watchMessageDeleteCalls()
 	go service.watchMessageRedownloadCalls()
+	go service.watchMessageMarkReadCalls()
+	go service.watchMessageCancelCalls()
+	go service.watchMessageResendCalls()
+	if mmsdCompatible {
+		service.registerStoredMessages()
+	}
 	conn.RegisterObjectPath(payload.Path, service.msgChan)
 	return &service
 }
 
-func (*MMSService) getMMSState(objectPath dbus.ObjectPath) (storage.MMSState, error) {
+func (service *MMSService) getMMSState(objectPath dbus.ObjectPath) (storage.MMSState, error) {
 	uuid, err := getUUIDFromObjectPath(objectPath)
 	if err != nil {
 		return storage.MMSState{}, err
 	}
 
-	return storage.GetMMSState(uuid)
+	return service.storage.GetMMSState(uuid)
 }
 
 func (service *MMSService) watchMessageDeleteCalls() {
 	for msgObjectPath := range service.msgDeleteChan {
 		if mmsState, err := service.getMMSState(msgObjectPath); err == nil {
+			if mmsState.State == storage.NOTIFICATION && mmsState.MNotificationInd != nil && !mmsState.MNotificationInd.Expired() {
+				// The message center keeps pushing the notification until
+				// it gets a response, let the mediator reject it first.
+				logger.Infof("Message %s is not downloaded, rejecting it before deleting.", string(msgObjectPath))
+				service.mNotificationIndRejectChan <- mmsState.MNotificationInd
+				continue
+			}
 			if mmsState.State != storage.RESPONDED && mmsState.MNotificationInd != nil && !mmsState.MNotificationInd.Expired() {
-				log.Printf("Message %s is not responded and not expired, not deleting.", string(msgObjectPath))
+				logger.Infof("Message %s is not responded and not expired, not deleting.", string(msgObjectPath))
 				continue
 			}
 		}
 
 		if err := service.MessageRemoved(msgObjectPath); err != nil {
-			log.Print("Failed to delete ", msgObjectPath, ": ", err)
+			logger.Error("Failed to delete ", msgObjectPath, ": ", err)
 		}
 	}
 }
 
 func (service *MMSService) watchMessageRedownloadCalls() {
 	for msgObjectPath := range service.msgRedownloadChan {
-		mmsState, err := service.getMMSState(msgObjectPath)
+		if err := service.redownload(msgObjectPath); err != nil {
+			logger.Errorf("Redownload of %s error: %v", string(msgObjectPath), err)
+		}
+	}
+}
+
+func (service *MMSService) watchMessageMarkReadCalls() {
+	for msgObjectPath := range service.msgMarkReadChan {
+		uuid, err := getUUIDFromObjectPath(msgObjectPath)
 		if err != nil {
-			log.Printf("Redownload of %s error: retrieving message state error: %v", string(msgObjectPath), err)
+			logger.Errorf("Marking %s as read error: %v", string(msgObjectPath), err)
 			continue
 		}
-		if mmsState.State != storage.NOTIFICATION {
-			log.Printf("Redownload of %s error: message was already downloaded", string(msgObjectPath))
+		service.markReadChan <- uuid
+	}
+}
+
+func (service *MMSService) watchMessageCancelCalls() {
+	for msgObjectPath := range service.msgCancelChan {
+		uuid, err := getUUIDFromObjectPath(msgObjectPath)
+		if err != nil {
+			logger.Errorf("Cancelling %s error: %v", string(msgObjectPath), err)
 			continue
 		}
-		if mmsState.MNotificationInd == nil {
-			log.Printf("Redownload of %s error: no mNotificationInd found", string(msgObjectPath))
+		service.cancelChan <- uuid
+	}
+}
+
+func (service *MMSService) watchMessageResendCalls() {
+	for msgObjectPath := range service.msgResendChan {
+		uuid, err := getUUIDFromObjectPath(msgObjectPath)
+		if err != nil {
+			logger.Errorf("Resending %s error: %v", string(msgObjectPath), err)
 			continue
 		}
+		service.resendChan <- uuid
+	}
+}
 
-		// Stop previous message handling, remove and notify.
-		if err := service.MessageRemoved(msgObjectPath); err != nil {
-			log.Printf("Redownload of %s warning: removing message error: %v", string(msgObjectPath), err)
-		}
+// RedownloadMessage restarts the download of the undownloaded message uuid,
+// as if the user asked for it.
+func (service *MMSService) RedownloadMessage(uuid string) error {
+	return service.redownload(service.GenMessagePath(uuid))
+}
 
-		// Start new mNotificationInd handling as if pushed from MMS service, but with info about redownload.
-		newMNotificationInd := mmsState.MNotificationInd
-		newMNotificationInd.RedownloadOfUUID = mmsState.MNotificationInd.UUID
-		newMNotificationInd.UUID = mms.GenUUID()
-		storage.Create(mmsState.ModemId, newMNotificationInd)
-		service.mNotificationIndChan <- newMNotificationInd
+func (service *MMSService) redownload(msgObjectPath dbus.ObjectPath) error {
+	mmsState, err := service.getMMSState(msgObjectPath)
+	if err != nil {
+		return fmt.Errorf("retrieving message state error: %w", err)
+	}
+	if mmsState.State != storage.NOTIFICATION {
+		return errors.New("message was already downloaded")
 	}
+	if mmsState.MNotificationInd == nil {
+		return errors.New("no mNotificationInd found")
+	}
+
+	// Stop previous message handling, remove and notify.
+	if err := service.MessageRemoved(msgObjectPath); err != nil {
+		logger.Errorf("Redownload of %s warning: removing message error: %v", string(msgObjectPath), err)
+	}
+
+	// Start new mNotificationInd handling as if pushed from MMS service, but with info about redownload.
+	newMNotificationInd := mmsState.MNotificationInd
+	newMNotificationInd.RedownloadOfUUID = mmsState.MNotificationInd.UUID
+	newMNotificationInd.UUID = mms.GenUUID()
+	service.storage.Create(mmsState.ModemId, newMNotificationInd)
+	service.mNotificationIndChan <- newMNotificationInd
+	return nil
 }
 
 func (service *MMSService) watchDBusMethodCalls() {
 	for msg := range service.msgChan {
 		var reply *dbus.Message
+		if reply = service.standard.reply(msg); reply != nil {
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+			continue
+		}
 		if msg.Interface != MMS_SERVICE_DBUS_IFACE {
-			log.Println("Received unknown interface call on", msg.Interface, msg.Member)
+			logger.Warn("Received unknown interface call on ", msg.Interface, " ", msg.Member)
 			reply = dbus.NewErrorMessage(
 				msg,
 				"org.freedesktop.DBus.Error.UnknownInterface",
 				fmt.Sprintf("No such interface '%s' at object path '%s'", msg.Interface, msg.Path),
 			)
 			if err := service.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 			continue
 		}
 		switch msg.Member {
 		case "GetMessages":
 			reply = dbus.NewMethodReturnMessage(msg)
-			//TODO implement store and forward
-			var payload []Payload
+			payload := service.storedMessages()
 			if err := reply.AppendArgs(payload); err != nil {
-				log.Print("Cannot parse payload data from services")
+				logger.Error("Cannot parse payload data from services")
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse services")
 			}
 			if err := service.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 		case "GetProperties":
 			reply = dbus.NewMethodReturnMessage(msg)
-			if pc, err := service.GetPreferredContext(); err == nil {
-				service.Properties[preferredContextProperty] = dbus.Variant{pc}
-			} else {
-				// Using "/" as an invalid 'path' even though it could be considered 'incorrect'
-				service.Properties[preferredContextProperty] = dbus.Variant{dbus.ObjectPath("/")}
-			}
-			if err := reply.AppendArgs(service.Properties); err != nil {
-				log.Print("Cannot parse payload data from services")
+			if err := reply.AppendArgs(service.properties()); err != nil {
+				logger.Error("Cannot parse payload data from services")
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse services")
 			}
 			if err := service.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 		case "SetProperty":
 			if err := service.setProperty(msg); err != nil {
-				log.Println("Property set failed:", err)
+				logger.Error("Property set failed: ", err)
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", err.Error())
 			} else {
 				reply = dbus.NewMethodReturnMessage(msg)
 			}
 			if err := service.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
+			}
+		case "Attach":
+			service.attach(msg)
+			reply = dbus.NewMethodReturnMessage(msg)
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+		case "Detach":
+			service.detach(msg.Sender)
+			reply = dbus.NewMethodReturnMessage(msg)
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+		case "ConfigureMMSContext":
+			if reply = service.configureMMSContext(msg); reply != nil {
+				if err := service.conn.Send(reply); err != nil {
+					logger.Error("Could not send reply: ", err)
+				}
+			}
+		case "MMBoxStore":
+			if reply = service.mmboxStore(msg); reply != nil {
+				if err := service.conn.Send(reply); err != nil {
+					logger.Error("Could not send reply: ", err)
+				}
+			}
+		case "MMBoxView":
+			if reply = service.mmboxView(msg); reply != nil {
+				if err := service.conn.Send(reply); err != nil {
+					logger.Error("Could not send reply: ", err)
+				}
+			}
+		case "Forward":
+			if reply = service.forward(msg); reply != nil {
+				if err := service.conn.Send(reply); err != nil {
+					logger.Error("Could not send reply: ", err)
+				}
+			}
+		case "CancelDelivery":
+			if reply = service.cancelDelivery(msg); reply != nil {
+				if err := service.conn.Send(reply); err != nil {
+					logger.Error("Could not send reply: ", err)
+				}
+			}
+		case "MMBoxRetrieve":
+			reply = service.mmboxRetrieve(msg)
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+		case "ExportDiagnostics":
+			reply = service.exportDiagnostics(msg)
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
+			}
+		case "ExportMessagePDU":
+			reply = service.exportMessagePDU(msg)
+			if err := service.conn.Send(reply); err != nil {
+				logger.Error("Could not send reply: ", err)
 			}
 		case "SendMessage":
 			var outMessage OutgoingMessage
 			outMessage.Reply = dbus.NewMethodReturnMessage(msg)
-			if err := msg.Args(&outMessage.Recipients, &outMessage.Attachments); err != nil {
-				log.Print("Cannot parse payload data from services")
+			// The options argument is optional, clients older than
+			// version 16 call SendMessage with the recipients and
+			// attachments only.
+			var options map[string]dbus.Variant
+			err := msg.Args(&outMessage.Recipients, &options, &outMessage.Attachments)
+			if err != nil {
+				err = msg.Args(&outMessage.Recipients, &outMessage.Attachments)
+			}
+			if err != nil && service.mmsdCompatible {
+				// mmsd takes a SMIL presentation in place of the
+				// options.
+				err = msg.Args(&outMessage.Recipients, &outMessage.Smil, &outMessage.Attachments)
+			}
+			if err == nil {
+				err = outMessage.parseSendOptions(options)
+			}
+			if err != nil {
+				logger.Error("Cannot parse payload data from services: ", err)
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse New Message")
 				if err := service.conn.Send(reply); err != nil {
-					log.Println("Could not send reply:", err)
+					logger.Error("Could not send reply: ", err)
 				}
 			} else {
 				service.outMessage <- &outMessage
 			}
 		default:
-			log.Println("Received unknown method call on", msg.Interface, msg.Member)
+			logger.Warn("Received unknown method call on ", msg.Interface, " ", msg.Member)
 			reply = dbus.NewErrorMessage(
 				msg,
 				"org.freedesktop.DBus.Error.UnknownMethod",
 				fmt.Sprintf("No such method '%s' at object path '%s'", msg.Member, msg.Path),
 			)
 			if err := service.conn.Send(reply); err != nil {
-				log.Println("Could not send reply:", err)
+				logger.Error("Could not send reply: ", err)
 			}
 		}
 	}
@@ -260,35 +574,159 @@ func (service *MMSService) SetPreferredContext(context dbus.ObjectPath) error {
 	if err := storage.SetPreferredContext(service.identity, context); err != nil {
 		return err
 	}
-	signal := dbus.NewSignalMessage(service.payload.Path, MMS_SERVICE_DBUS_IFACE, propertyChangedSignal)
-	if err := signal.AppendArgs(preferredContextProperty, dbus.Variant{context}); err != nil {
-		return err
-	}
-	return service.conn.Send(signal)
+	return service.propertyChanged(preferredContextProperty, dbus.MakeVariant(context))
 }
 
 func (service *MMSService) GetPreferredContext() (dbus.ObjectPath, error) {
 	return storage.GetPreferredContext(service.identity)
 }
 
+// properties returns the properties of the service, with the preferred
+// context and the automatic download limit as stored.
+func (service *MMSService) properties() map[string]dbus.Variant {
+	// Using "/" as an invalid 'path' even though it could be considered 'incorrect'
+	preferredContext := dbus.ObjectPath("/")
+	if pc, err := service.GetPreferredContext(); err == nil {
+		preferredContext = pc
+	}
+	autoDownloadLimit := service.AutoDownloadLimit()
+	service.propertiesLock.Lock()
+	defer service.propertiesLock.Unlock()
+	service.Properties[preferredContextProperty] = dbus.MakeVariant(preferredContext)
+	service.Properties[autoDownloadLimitProperty] = dbus.MakeVariant(autoDownloadLimit)
+	properties := make(map[string]dbus.Variant, len(service.Properties))
+	for name, value := range service.Properties {
+		properties[name] = value
+	}
+	return properties
+}
+
+// property returns the property name of the service.
+func (service *MMSService) property(name string) dbus.Variant {
+	service.propertiesLock.Lock()
+	defer service.propertiesLock.Unlock()
+	return service.Properties[name]
+}
+
+// changeProperty sets the property name of the service to value and returns
+// whether it changed. The caller signals the change, outside of the lock.
+func (service *MMSService) changeProperty(name string, value dbus.Variant) bool {
+	service.propertiesLock.Lock()
+	defer service.propertiesLock.Unlock()
+	if current, ok := service.Properties[name]; ok && reflect.DeepEqual(current.Value(), value.Value()) {
+		return false
+	}
+	service.Properties[name] = value
+	return true
+}
+
+// propertyChanged signals the change of the property name of the service.
+func (service *MMSService) propertyChanged(name string, value dbus.Variant) error {
+	return signalPropertyChanged(service.conn, service.payload.Path, MMS_SERVICE_DBUS_IFACE, name, value)
+}
+
 func (service *MMSService) setProperty(msg *dbus.Message) error {
 	var propertyName string
 	var propertyValue dbus.Variant
 	if err := msg.Args(&propertyName, &propertyValue); err != nil {
 		return err
 	}
+	return service.setPropertyValue(propertyName, propertyValue)
+}
 
+// setPropertyValue sets the writable property propertyName of the service,
+// for SetProperty and the Set method of the standard properties interface.
+func (service *MMSService) setPropertyValue(propertyName string, propertyValue dbus.Variant) error {
 	switch propertyName {
 	case preferredContextProperty:
-		preferredContextObjectPath := dbus.ObjectPath(reflect.ValueOf(propertyValue.Value).String())
-		service.Properties[preferredContextProperty] = dbus.Variant{preferredContextObjectPath}
+		var preferredContextObjectPath dbus.ObjectPath
+		switch path := propertyValue.Value().(type) {
+		case dbus.ObjectPath:
+			preferredContextObjectPath = path
+		case string:
+			preferredContextObjectPath = dbus.ObjectPath(path)
+		default:
+			return errors.New("preferred context must be an object path")
+		}
+		service.changeProperty(preferredContextProperty, dbus.MakeVariant(preferredContextObjectPath))
 		return service.SetPreferredContext(preferredContextObjectPath)
+	case localeProperty:
+		locale, ok := propertyValue.Value().(string)
+		if !ok {
+			return errors.New("locale must be a string")
+		}
+		if !service.changeProperty(localeProperty, dbus.MakeVariant(locale)) {
+			return nil
+		}
+		return service.propertyChanged(localeProperty, dbus.MakeVariant(locale))
+	case autoDownloadLimitProperty:
+		var newLimit uint64
+		limit := reflect.ValueOf(propertyValue.Value())
+		switch limit.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			newLimit = limit.Uint()
+		case reflect.Int16, reflect.Int32, reflect.Int64:
+			if limit.Int() < 0 {
+				return errors.New("automatic download limit must not be negative")
+			}
+			newLimit = uint64(limit.Int())
+		default:
+			return errors.New("automatic download limit must be an integer")
+		}
+		if service.settings == nil {
+			return errors.New("automatic download limit cannot be changed")
+		}
+		if newLimit == service.AutoDownloadLimit() {
+			return nil
+		}
+		if _, err := service.settings.Set("AutoDownloadLimit", newLimit); err != nil {
+			return err
+		}
+		return service.propertyChanged(autoDownloadLimitProperty, dbus.MakeVariant(newLimit))
 	default:
 		errors.New("property cannot be set")
 	}
 	return errors.New("unhandled property")
 }
 
+// locale returns the locale the UI requested for human readable messages.
+func (service *MMSService) locale() string {
+	if locale, ok := service.property(localeProperty).Value().(string); ok {
+		return locale
+	}
+	return i18n.DefaultLocale()
+}
+
+// AutoDownloadLimit returns the size in bytes above which messages are not
+// downloaded automatically, 0 means no limit. It is stored in the settings.
+func (service *MMSService) AutoDownloadLimit() uint64 {
+	return service.settings.Get().AutoDownloadLimit
+}
+
+// SetDataSaver updates the DataSaver property, which tells whether automatic
+// downloads are deferred to save data.
+func (service *MMSService) SetDataSaver(enabled bool) error {
+	if !service.changeProperty(dataSaverProperty, dbus.MakeVariant(enabled)) {
+		return nil
+	}
+	return service.propertyChanged(dataSaverProperty, dbus.MakeVariant(enabled))
+}
+
+// setUseDeliveryReports updates the UseDeliveryReports property after the
+// setting changed.
+func (service *MMSService) setUseDeliveryReports(enabled bool) error {
+	if !service.changeProperty(useDeliveryReportsProperty, dbus.MakeVariant(enabled)) {
+		return nil
+	}
+	return service.propertyChanged(useDeliveryReportsProperty, dbus.MakeVariant(enabled))
+}
+
+// DataSaver returns the DataSaver property.
+func (service *MMSService) DataSaver() bool {
+	enabled, _ := service.property(dataSaverProperty).Value().(bool)
+	return enabled
+}
+
 // MessageRemoved closes message handlers, removes message from storage and emits the MessageRemoved signal to mms service dbus interface for message identified by objectPath parameter in this order.
 // If message is not handled, removing from storage or sending signal fails, error is returned.
 func (service *MMSService) MessageRemoved(objectPath dbus.ObjectPath) error {
@@ -296,18 +734,17 @@ func (service *MMSService) MessageRemoved(objectPath dbus.ObjectPath) error {
 		return ErrorNilMMSService
 	}
 
-	if _, ok := service.messageHandlers[objectPath]; !ok {
+	msgInterface, ok := service.messageHandlers.remove(objectPath)
+	if !ok {
 		return fmt.Errorf("message not handled")
 	}
-
-	service.messageHandlers[objectPath].Close()
-	delete(service.messageHandlers, objectPath)
+	msgInterface.Close()
 
 	uuid, err := getUUIDFromObjectPath(objectPath)
 	if err != nil {
 		return err
 	}
-	if err := storage.Destroy(uuid); err != nil {
+	if err := service.storage.Destroy(uuid); err != nil {
 		return err
 	}
 
@@ -336,18 +773,50 @@ func (service *MMSService) IncomingMessageFailAdded(mNotificationInd *mms.MNotif
 	}
 
 	if err := mNotificationInd.PopDebugError(mms.DebugErrorTelepathyErrorNotify); err != nil {
-		log.Printf("Forcing IncomingMessageFailAdded debug error: %#v", err)
-		storage.UpdateMNotificationInd(mNotificationInd)
+		logger.Errorf("Forcing IncomingMessageFailAdded debug error: %#v", err)
+		service.storage.UpdateMNotificationInd(mNotificationInd)
 		return err
 	}
 
 	params := make(map[string]dbus.Variant)
 
-	params["Status"] = dbus.Variant{"received"}
-	params["Date"] = dbus.Variant{time.Now().Format(time.RFC3339)}
-	params["Sender"] = dbus.Variant{strings.TrimSuffix(mNotificationInd.From, PLMN)}
+	now := time.Now().Unix()
+	params["Status"] = dbus.MakeVariant("received")
+	params["Date"] = dbus.MakeVariant(mms.FormatEpoch(now))
+	params["Timestamp"] = dbus.MakeVariant(now)
+	params["Sender"] = dbus.MakeVariant(address.Normalize(mNotificationInd.From))
+
+	allowRedownload := service.downloadErrorProperties(mNotificationInd, downloadError, params)
 
-	errorCode := "x-ubports-nuntium-mms-error-unknown"
+	if mNotificationInd.RedownloadOfUUID != "" {
+		params["DeleteEvent"] = dbus.MakeVariant(string(service.GenMessagePath(mNotificationInd.RedownloadOfUUID)))
+	}
+	if !mNotificationInd.Received.IsZero() {
+		params["Received"] = dbus.MakeVariant(mms.Epoch(mNotificationInd.Received))
+	}
+	notificationProperties(mNotificationInd, params)
+	if mmsState, err := service.storage.GetMMSState(mNotificationInd.UUID); err == nil {
+		debugInfoProperties(mmsState, params)
+	}
+
+	payload := Payload{Path: service.GenMessagePath(mNotificationInd.UUID), Properties: params}
+
+	// Don't pass a redownload channel to NewMessageInterface if redownload not allowed.
+	redownloadChan := service.msgRedownloadChan
+	if !allowRedownload {
+		redownloadChan = nil
+	}
+	service.dropRestored(payload.Path)
+	service.messageHandlers.add(payload.Path, service.newMessageInterface(payload.Path, service.msgDeleteChan, redownloadChan, nil, nil, nil))
+	return service.MessageAdded(&payload)
+}
+
+// downloadErrorProperties sets the Error, AllowRedownload, Deferred and
+// WaitingForNetwork properties of the message of mNotificationInd, which
+// failed to download with downloadError, in properties and returns whether
+// it can be redownloaded.
+func (service *MMSService) downloadErrorProperties(mNotificationInd *mms.MNotificationInd, downloadError error, properties map[string]dbus.Variant) bool {
+	errorCode := ErrorUnknown
 	if eci, ok := downloadError.(interface{ Code() string }); ok {
 		errorCode = eci.Code()
 	}
@@ -357,10 +826,10 @@ func (service *MMSService) IncomingMessageFailAdded(mNotificationInd *mms.MNotif
 		allowRedownload = ari.AllowRedownload()
 	}
 
-	expire := mNotificationInd.Expire().Format(time.RFC3339)
+	expire := mms.Epoch(mNotificationInd.Expire())
 	if allowRedownload && mNotificationInd.Expired() {
 		// Expired, don't allow redownload.
-		log.Printf("Message expired at %s", mNotificationInd.Expire())
+		logger.Infof("Message expired at %s", mNotificationInd.Expire())
 		allowRedownload = false
 	}
 
@@ -368,51 +837,52 @@ func (service *MMSService) IncomingMessageFailAdded(mNotificationInd *mms.MNotif
 	if enabled, err := service.MobileDataEnabled(); err == nil {
 		mobileData = &enabled
 	} else {
-		log.Printf("Error detecting if mobile data is enabled: %v", err)
+		logger.Errorf("Error detecting if mobile data is enabled: %v", err)
 	}
 
+	// Message holds the raw error for debugging, Text is what to show to users.
+	// Expire is kept as a string for compatibility, ExpireTimestamp is canonical.
+	text := i18n.Message(errorCode, service.locale())
+	if tai, ok := downloadError.(interface{ TextArgs() []interface{} }); ok && text != "" {
+		if args := tai.TextArgs(); len(args) > 0 {
+			text = fmt.Sprintf(text, args...)
+		}
+	}
 	errorMessage, err := json.Marshal(&struct {
-		Code       string
-		Message    string
-		Expire     string `json:",omitempty"`
-		Size       uint64 `json:",omitempty"`
-		MobileData *bool  `json:",omitempty"`
-	}{errorCode, downloadError.Error(), expire, mNotificationInd.Size, mobileData})
+		Code            string
+		Message         string
+		Text            string `json:",omitempty"`
+		Expire          string `json:",omitempty"`
+		ExpireTimestamp int64  `json:",omitempty"`
+		Size            uint64 `json:",omitempty"`
+		MobileData      *bool  `json:",omitempty"`
+		DataSaver       bool   `json:",omitempty"`
+	}{errorCode, downloadError.Error(), text, mms.FormatEpoch(expire), expire, mNotificationInd.Size, mobileData, service.DataSaver()})
 	if err != nil {
-		log.Printf("Error marshaling download error message to json: %v", err)
+		logger.Errorf("Error marshaling download error message to json: %v", err)
 		errorMessage = []byte("{}")
 	}
-	params["Error"] = dbus.Variant{string(errorMessage)}
-	params["AllowRedownload"] = dbus.Variant{allowRedownload}
-
-	if mNotificationInd.RedownloadOfUUID != "" {
-		params["DeleteEvent"] = dbus.Variant{string(service.GenMessagePath(mNotificationInd.RedownloadOfUUID))}
-	}
-	if !mNotificationInd.Received.IsZero() {
-		params["Received"] = dbus.Variant{uint32(mNotificationInd.Received.Unix())}
+	properties["Error"] = dbus.MakeVariant(string(errorMessage))
+	properties["AllowRedownload"] = dbus.MakeVariant(allowRedownload)
+	if di, ok := downloadError.(interface{ Deferred() bool }); ok && di.Deferred() {
+		properties["Deferred"] = dbus.MakeVariant(true)
 	}
-
-	payload := Payload{Path: service.GenMessagePath(mNotificationInd.UUID), Properties: params}
-
-	// Don't pass a redownload channel to NewMessageInterface if redownload not allowed.
-	redownloadChan := service.msgRedownloadChan
-	if !allowRedownload {
-		redownloadChan = nil
+	if wi, ok := downloadError.(interface{ WaitingForNetwork() bool }); ok && wi.WaitingForNetwork() {
+		properties["WaitingForNetwork"] = dbus.MakeVariant(true)
 	}
-	service.messageHandlers[payload.Path] = NewMessageInterface(service.conn, payload.Path, service.msgDeleteChan, redownloadChan)
-	return service.MessageAdded(&payload)
+	return allowRedownload
 }
 
 //IncomingMessageAdded emits a MessageAdded with the path to the added message which
 //is taken as a parameter and creates an object path on the message interface.
-func (service *MMSService) IncomingMessageAdded(mRetConf *mms.MRetrieveConf, mNotificationInd *mms.MNotificationInd) error {
+func (service *MMSService) IncomingMessageAdded(mRetConf *mms.MRetrieveConf, mNotificationInd *mms.MNotificationInd, annotations map[string]string) error {
 	if service == nil {
 		return ErrorNilMMSService
 	}
 
 	if err := mNotificationInd.PopDebugError(mms.DebugErrorReceiveHandle); err != nil {
-		log.Printf("Forcing getAndHandleMRetrieveConf debug error: %#v", err)
-		storage.UpdateMNotificationInd(mNotificationInd)
+		logger.Errorf("Forcing getAndHandleMRetrieveConf debug error: %#v", err)
+		service.storage.UpdateMNotificationInd(mNotificationInd)
 		return err
 	}
 
@@ -422,13 +892,19 @@ func (service *MMSService) IncomingMessageAdded(mRetConf *mms.MRetrieveConf, mNo
 	}
 
 	if mNotificationInd.RedownloadOfUUID != "" {
-		payload.Properties["DeleteEvent"] = dbus.Variant{string(service.GenMessagePath(mNotificationInd.RedownloadOfUUID))}
+		payload.Properties["DeleteEvent"] = dbus.MakeVariant(string(service.GenMessagePath(mNotificationInd.RedownloadOfUUID)))
 	}
 	if !mNotificationInd.Received.IsZero() {
-		payload.Properties["Received"] = dbus.Variant{mNotificationInd.Received.Unix()}
+		payload.Properties["Received"] = dbus.MakeVariant(mms.Epoch(mNotificationInd.Received))
 	}
+	if len(annotations) > 0 {
+		payload.Properties["Annotations"] = dbus.MakeVariant(annotations)
+	}
+	payload.Properties[readProperty] = dbus.MakeVariant(false)
+	service.addSpamProperties(mRetConf.UUID, payload.Properties)
 
-	service.messageHandlers[payload.Path] = NewMessageInterface(service.conn, payload.Path, service.msgDeleteChan, nil)
+	service.dropRestored(payload.Path)
+	service.messageHandlers.add(payload.Path, service.newMessageInterface(payload.Path, service.msgDeleteChan, nil, service.msgMarkReadChan, nil, nil))
 	return service.MessageAdded(&payload)
 }
 
@@ -442,16 +918,17 @@ func (service *MMSService) InitializationMessageAdded(mRetConf *mms.MRetrieveCon
 	}
 
 	path := service.GenMessagePath(mNotificationInd.UUID)
-	if _, ok := service.messageHandlers[path]; ok {
+	service.dropRestored(path)
+	if _, ok := service.messageHandlers.get(path); ok {
 		return fmt.Errorf("message is already handled")
 	}
 
 	// Initialization message only needs these properties to spawn proper handles in telepathy.
 	payload := Payload{Path: path, Properties: map[string]dbus.Variant{
-		"Status":  dbus.Variant{"received"},
-		"Sender":  dbus.Variant{strings.TrimSuffix(mNotificationInd.From, PLMN)},
-		"Rescued": dbus.Variant{true},
-		"Silent":  dbus.Variant{true},
+		"Status":  dbus.MakeVariant("received"),
+		"Sender":  dbus.MakeVariant(address.Normalize(mNotificationInd.From)),
+		"Rescued": dbus.MakeVariant(true),
+		"Silent":  dbus.MakeVariant(true),
 	}}
 
 	// Extract "Sender" and "Recipients" property from mRetConf, if any.
@@ -464,17 +941,21 @@ func (service *MMSService) InitializationMessageAdded(mRetConf *mms.MRetrieveCon
 				payload.Properties["Recipients"] = pl.Properties["Recipients"]
 			}
 		} else {
-			log.Printf("Error parsing mRetConf for initialization message %s: %v", path, err)
+			logger.Errorf("Error parsing mRetConf for initialization message %s: %v", path, err)
 		}
 	}
+	service.addSpamProperties(mNotificationInd.UUID, payload.Properties)
 
-	service.messageHandlers[path] = NewMessageInterface(service.conn, path, service.msgDeleteChan, service.msgRedownloadChan)
+	service.messageHandlers.add(path, service.newMessageInterface(path, service.msgDeleteChan, service.msgRedownloadChan, service.msgMarkReadChan, nil, nil))
 	return service.MessageAdded(&payload)
 }
 
 //MessageAdded emits a MessageAdded with the path to the added message which
 //is taken as a parameter
 func (service *MMSService) MessageAdded(msgPayload *Payload) error {
+	if msgInterface, ok := service.messageHandlers.get(msgPayload.Path); ok {
+		msgInterface.setAdded(msgPayload.Properties)
+	}
 	signal := dbus.NewSignalMessage(service.payload.Path, MMS_SERVICE_DBUS_IFACE, messageAddedSignal)
 	if err := signal.AppendArgs(msgPayload.Path, msgPayload.Properties); err != nil {
 		return err
@@ -491,31 +972,53 @@ func (service *MMSService) isService(identity string) bool {
 }
 
 func (service *MMSService) Close() {
+	service.stopWatchingConsumers()
+	// The message interfaces send on the channels closed below, Close
+	// waits for them to stop.
+	for _, msgInterface := range service.messageHandlers.removeAll() {
+		msgInterface.Close()
+	}
 	service.conn.UnregisterObjectPath(service.payload.Path)
 	close(service.msgChan)
 	close(service.msgDeleteChan)
 	close(service.msgRedownloadChan)
+	close(service.msgMarkReadChan)
+	close(service.msgCancelChan)
+	close(service.msgResendChan)
 }
 
 func (service *MMSService) parseMessage(mRetConf *mms.MRetrieveConf) (Payload, error) {
 	params := make(map[string]dbus.Variant)
-	params["Status"] = dbus.Variant{"received"}
-	//TODO retrieve date correctly
-	params["Date"] = dbus.Variant{parseDate(mRetConf.Date)}
-	params["Sender"] = dbus.Variant{strings.TrimSuffix(mRetConf.From, PLMN)}
+	params["Status"] = dbus.MakeVariant("received")
+	params["Date"] = dbus.MakeVariant(mms.FormatEpoch(int64(mRetConf.Date)))
+	params["Timestamp"] = dbus.MakeVariant(int64(mRetConf.Date))
+	params["Sender"] = dbus.MakeVariant(address.Normalize(mRetConf.From))
 	if mRetConf.Subject != "" {
-		params["Subject"] = dbus.Variant{mRetConf.Subject}
+		params["Subject"] = dbus.MakeVariant(mRetConf.Subject)
+	}
+	if priority := priorityName(mRetConf.Priority); priority != "" {
+		params["Priority"] = dbus.MakeVariant(priority)
+	}
+	if mRetConf.IsEncrypted() {
+		params["Encrypted"] = dbus.MakeVariant(true)
 	}
 
-	params["Recipients"] = dbus.Variant{parseRecipients(strings.Join(mRetConf.To, ","))}
+	params["Recipients"] = dbus.MakeVariant(parseRecipients(strings.Join(mRetConf.To, ",")))
 	if smil, err := mRetConf.GetSmil(); err == nil {
-		params["Smil"] = dbus.Variant{smil}
+		params["Smil"] = dbus.MakeVariant(smil)
 	}
 	var attachments []Attachment
+	var sanitized []storage.SanitizedPart
+	var cards []Card
+	mmsState, _ := service.storage.GetMMSState(mRetConf.UUID)
 	dataParts := mRetConf.GetDataParts()
 	for i := range dataParts {
+		if mmsState.Sanitized(dataParts[i].ContentId, dataParts[i].MediaType) {
+			sanitized = append(sanitized, storage.SanitizedPart{Id: dataParts[i].ContentId, MediaType: dataParts[i].MediaType})
+			continue
+		}
 		var filePath string
-		if f, err := storage.GetMMS(mRetConf.UUID); err == nil {
+		if f, err := service.storage.GetMMS(mRetConf.UUID); err == nil {
 			filePath = f
 		} else {
 			return Payload{}, err
@@ -527,34 +1030,155 @@ func (service *MMSService) parseMessage(mRetConf *mms.MRetrieveConf) (Payload, e
 			Offset:    uint64(dataParts[i].Offset),
 			Length:    uint64(len(dataParts[i].Data)),
 		}
+		if kind := dataParts[i].CardKind(); kind != "" {
+			if err := service.normalizeCard(mRetConf.UUID, i, dataParts[i], &attachment); err != nil {
+				logger.Errorf("Passing through card %s of %s: %v", attachment.Id, mRetConf.UUID, err)
+			}
+			cards = append(cards, Card{Id: attachment.Id, Kind: kind, Title: dataParts[i].CardTitle()})
+		} else if mms.IsDRM(dataParts[i].MediaType) {
+			if err := service.unwrapDRM(mRetConf.UUID, i, dataParts[i], &attachment); errors.Is(err, mms.ErrorDRMProtected) {
+				logger.Infof("Passing on part %s of %s as protected content: %v", attachment.Id, mRetConf.UUID, err)
+			} else if err != nil {
+				logger.Errorf("Passing on part %s of %s as protected content: %v", attachment.Id, mRetConf.UUID, err)
+			}
+		} else if err := service.transcodeText(mRetConf.UUID, i, dataParts[i], &attachment); err != nil {
+			logger.Errorf("Passing through text part %s of %s: %v", attachment.Id, mRetConf.UUID, err)
+		}
 		attachments = append(attachments, attachment)
 	}
-	params["Attachments"] = dbus.Variant{attachments}
+	params["Attachments"] = dbus.MakeVariant(attachments)
+	if len(sanitized) > 0 {
+		params[sanitizedPartsProperty] = dbus.MakeVariant(sanitized)
+	}
+	if len(cards) > 0 {
+		params[cardsProperty] = dbus.MakeVariant(cards)
+	}
+	if summary := mRetConf.Summary(); summary != "" {
+		params["Summary"] = dbus.MakeVariant(summary)
+	}
 	payload := Payload{Path: service.GenMessagePath(mRetConf.UUID), Properties: params}
 	return payload, nil
 }
 
-func parseDate(unixTime uint64) string {
-	const layout = "2014-03-30T18:15:30-0300"
-	date := time.Unix(int64(unixTime), 0)
-	return date.Format(time.RFC3339)
+// transcodeText points attachment to a UTF-8 copy of part if it is text in
+// another charset, clients read attachments as they are stored.
+func (service *MMSService) transcodeText(uuid string, index int, part mms.Attachment, attachment *Attachment) error {
+	if !strings.HasPrefix(strings.ToLower(part.MediaType), "text/") || mms.IsUTF8Charset(part.Charset) {
+		return nil
+	}
+	text, err := part.Text()
+	if err != nil {
+		return err
+	}
+	filePath, err := service.storage.WriteText(uuid, index, text)
+	if err != nil {
+		return err
+	}
+	attachment.MediaType = strings.TrimSpace(strings.SplitN(part.MediaType, ";", 2)[0]) + ";charset=utf-8"
+	attachment.FilePath = filePath
+	attachment.Offset = 0
+	attachment.Length = uint64(len(text))
+	return nil
+}
+
+// normalizeCard points attachment to a UTF-8 copy of part, a vCard or
+// vCalendar, with its values decoded as mms.Attachment.CardText does.
+func (service *MMSService) normalizeCard(uuid string, index int, part mms.Attachment, attachment *Attachment) error {
+	text, err := part.CardText()
+	if err != nil {
+		return err
+	}
+	filePath, err := service.storage.WriteText(uuid, index, text)
+	if err != nil {
+		return err
+	}
+	attachment.MediaType = part.MediaType + ";charset=utf-8"
+	attachment.FilePath = filePath
+	attachment.Offset = 0
+	attachment.Length = uint64(len(text))
+	return nil
+}
+
+// unwrapDRM points attachment to the content of part, which is DRM
+// protected, if its rights allow to use it. Otherwise the attachment keeps
+// pointing to part, with a media type telling it is protected content.
+func (service *MMSService) unwrapDRM(uuid string, index int, part mms.Attachment, attachment *Attachment) error {
+	content, err := part.UnwrapDRM()
+	if err != nil {
+		attachment.MediaType = part.ProtectedMediaType()
+		return err
+	}
+	filePath, err := service.storage.WritePart(uuid, index, content.Data)
+	if err != nil {
+		attachment.MediaType = part.ProtectedMediaType()
+		return err
+	}
+	attachment.MediaType = content.MediaType
+	attachment.FilePath = filePath
+	attachment.Offset = 0
+	attachment.Length = uint64(len(content.Data))
+	return nil
+}
+
+// notificationProperties sets the properties of a message which was not
+// downloaded yet from what its m-notification.ind tells, so clients can show
+// a placeholder for it.
+func notificationProperties(mNotificationInd *mms.MNotificationInd, properties map[string]dbus.Variant) {
+	properties["TransactionId"] = dbus.MakeVariant(mNotificationInd.TransactionId)
+	if subject := strings.TrimSpace(mNotificationInd.Subject); subject != "" {
+		properties["Subject"] = dbus.MakeVariant(subject)
+	}
+	if class := className(mNotificationInd.Class); class != "" {
+		properties["MessageClass"] = dbus.MakeVariant(class)
+	}
+	if mNotificationInd.Size > 0 {
+		properties["MessageSize"] = dbus.MakeVariant(mNotificationInd.Size)
+	}
+	if expire := mNotificationInd.Expire(); !expire.IsZero() {
+		properties["Expiry"] = dbus.MakeVariant(mms.Epoch(expire))
+	}
+	if priority := priorityName(mNotificationInd.Priority); priority != "" {
+		properties["Priority"] = dbus.MakeVariant(priority)
+	}
+}
+
+// className returns the MessageClass property value for an
+// X-Mms-Message-Class header value, or an empty string if it is not set.
+func className(class byte) string {
+	for name, value := range classValues {
+		if value == class {
+			return name
+		}
+	}
+	return ""
+}
+
+// priorityName returns the Priority property value for an X-Mms-Priority
+// header value, or an empty string if it is not set.
+func priorityName(priority byte) string {
+	switch priority {
+	case mms.PriorityLow:
+		return "low"
+	case mms.PriorityNormal:
+		return "normal"
+	case mms.PriorityHigh:
+		return "high"
+	}
+	return ""
 }
 
 func parseRecipients(to string) []string {
 	recipients := strings.Split(to, ",")
 	for i := range recipients {
-		if strings.HasSuffix(recipients[i], PLMN) {
-			recipients[i] = recipients[i][:len(recipients[i])-len(PLMN)]
-		}
+		recipients[i] = address.Normalize(recipients[i])
 	}
 	return recipients
 }
 
 func (service *MMSService) MessageDestroy(uuid string) error {
 	msgObjectPath := service.GenMessagePath(uuid)
-	if msgInterface, ok := service.messageHandlers[msgObjectPath]; ok {
+	if msgInterface, ok := service.messageHandlers.remove(msgObjectPath); ok {
 		msgInterface.Close()
-		delete(service.messageHandlers, msgObjectPath)
 		return nil
 	}
 	return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
@@ -562,24 +1186,219 @@ func (service *MMSService) MessageDestroy(uuid string) error {
 
 func (service *MMSService) MessageStatusChanged(uuid, status string) error {
 	msgObjectPath := service.GenMessagePath(uuid)
-	if msgInterface, ok := service.messageHandlers[msgObjectPath]; ok {
+	if msgInterface, ok := service.messageHandlers.get(msgObjectPath); ok {
 		return msgInterface.StatusChanged(status)
 	}
 	return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
 }
 
-func (service *MMSService) ReplySendMessage(reply *dbus.Message, uuid string) (dbus.ObjectPath, error) {
+// MessageResponseStatus sets the ResponseStatus and ResponseText properties
+// of the outgoing message with uuid to the X-Mms-Response-Status and
+// X-Mms-Response-Text of its m-send.conf.
+func (service *MMSService) MessageResponseStatus(uuid string, status byte, text string) error {
+	if err := service.messagePropertyChanged(uuid, responseStatusProperty, dbus.MakeVariant(status)); err != nil {
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+	return service.messagePropertyChanged(uuid, responseTextProperty, dbus.MakeVariant(text))
+}
+
+// MessageSendFailed sets the Error property of the outgoing message with
+// uuid to sendError, like for incoming messages, and changes its status to
+// PERMANENT_ERROR, or TRANSIENT_ERROR if sendError is transient.
+func (service *MMSService) MessageSendFailed(uuid string, sendError error) error {
+	msgObjectPath := service.GenMessagePath(uuid)
+	msgInterface, ok := service.messageHandlers.get(msgObjectPath)
+	if !ok {
+		return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
+	}
+	errorCode := ErrorUnknown
+	if eci, ok := sendError.(interface{ Code() string }); ok {
+		errorCode = eci.Code()
+	}
+	var size uint64
+	if si, ok := sendError.(interface{ Size() uint64 }); ok {
+		size = si.Size()
+	}
+	text := i18n.Message(errorCode, service.locale())
+	if tai, ok := sendError.(interface{ TextArgs() []interface{} }); ok && text != "" {
+		if args := tai.TextArgs(); len(args) > 0 {
+			text = fmt.Sprintf(text, args...)
+		}
+	}
+	errorMessage, err := json.Marshal(&struct {
+		Code    string
+		Message string
+		Text    string `json:",omitempty"`
+		Size    uint64 `json:",omitempty"`
+	}{errorCode, sendError.Error(), text, size})
+	if err != nil {
+		logger.Errorf("Error marshaling send error message to json: %v", err)
+		errorMessage = []byte("{}")
+	}
+	if err := msgInterface.PropertyChanged("Error", dbus.MakeVariant(string(errorMessage))); err != nil {
+		return err
+	}
+	if ti, ok := sendError.(interface{ Transient() bool }); ok && ti.Transient() {
+		return msgInterface.StatusChanged(TRANSIENT_ERROR)
+	}
+	return msgInterface.StatusChanged(PERMANENT_ERROR)
+}
+
+// messagePropertyChanged sets the property name of the message with uuid
+// to value.
+func (service *MMSService) messagePropertyChanged(uuid, name string, value dbus.Variant) error {
+	msgObjectPath := service.GenMessagePath(uuid)
+	msgInterface, ok := service.messageHandlers.get(msgObjectPath)
+	if !ok {
+		return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
+	}
+	return msgInterface.PropertyChanged(name, value)
+}
+
+// MessageErrorChanged sets the Error, AllowRedownload, Deferred and
+// WaitingForNetwork properties of the message of mNotificationInd, which was
+// added as failed to download, after its download failed again with
+// downloadError. Redownload follows the new AllowRedownload.
+func (service *MMSService) MessageErrorChanged(mNotificationInd *mms.MNotificationInd, downloadError error) error {
+	if service == nil {
+		return ErrorNilMMSService
+	}
+
+	msgObjectPath := service.GenMessagePath(mNotificationInd.UUID)
+	msgInterface, ok := service.messageHandlers.get(msgObjectPath)
+	if !ok {
+		return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
+	}
+	properties := map[string]dbus.Variant{
+		"Deferred":          dbus.MakeVariant(false),
+		"WaitingForNetwork": dbus.MakeVariant(false),
+	}
+	if service.downloadErrorProperties(mNotificationInd, downloadError, properties) {
+		msgInterface.setRedownloadChan(service.msgRedownloadChan)
+	} else {
+		msgInterface.setRedownloadChan(nil)
+	}
+	for _, name := range []string{"Error", "AllowRedownload", "Deferred", "WaitingForNetwork"} {
+		if err := msgInterface.PropertyChanged(name, properties[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MessageReceived sets the Received property of the message with uuid to
+// received, when its receipt time is corrected after it was added.
+func (service *MMSService) MessageReceived(uuid string, received time.Time) error {
+	return service.messagePropertyChanged(uuid, receivedProperty, dbus.MakeVariant(mms.Epoch(received)))
+}
+
+// MessageRead sets the Read property of the incoming message with uuid,
+// which the user marked read.
+func (service *MMSService) MessageRead(uuid string) error {
+	return service.messagePropertyChanged(uuid, readProperty, dbus.MakeVariant(true))
+}
+
+// MessageCompressed sets the Compression property of the outgoing message
+// with uuid, whose images were re-encoded as described by compression.
+func (service *MMSService) MessageCompressed(uuid string, compression media.Compression) error {
+	return service.messagePropertyChanged(uuid, compressionProperty, dbus.MakeVariant(map[string]dbus.Variant{
+		"Images":       dbus.MakeVariant(uint32(compression.Images)),
+		"OriginalSize": dbus.MakeVariant(compression.OriginalSize),
+		"Size":         dbus.MakeVariant(compression.Size),
+	}))
+}
+
+// MessageIdChanged sets the MessageId property of the sent message with uuid
+// to the Message-ID the MMS center assigned to it.
+func (service *MMSService) MessageIdChanged(uuid, messageId string) error {
+	return service.messagePropertyChanged(uuid, messageIdProperty, dbus.MakeVariant(messageId))
+}
+
+// MessageProgress sets the Progress property of the message with uuid to the
+// bytes of its download or upload transferred so far and their total, 0 if
+// unknown. A message being downloaded has no object yet, its progress is
+// signalled on the path it is added with once downloaded.
+func (service *MMSService) MessageProgress(uuid string, transferred, total uint64) error {
+	progress := dbus.MakeVariant(map[string]dbus.Variant{
+		"Transferred": dbus.MakeVariant(transferred),
+		"Total":       dbus.MakeVariant(total),
+	})
+	msgObjectPath := service.GenMessagePath(uuid)
+	if msgInterface, ok := service.messageHandlers.get(msgObjectPath); ok {
+		return msgInterface.PropertyChanged(progressProperty, progress)
+	}
+	return signalPropertyChanged(service.conn, msgObjectPath, MMS_MESSAGE_DBUS_IFACE, progressProperty, progress)
+}
+
+// PushReceived signals a WAP push received for the service which is not an
+// MMS notification, like a service indication, with its content type, WAP
+// application id and data.
+func (service *MMSService) PushReceived(contentType string, applicationId byte, data []byte) error {
+	signal := dbus.NewSignalMessage(service.payload.Path, MMS_SERVICE_DBUS_IFACE, pushReceivedSignal)
+	if err := signal.AppendArgs(contentType, applicationId, data); err != nil {
+		return err
+	}
+	return service.conn.Send(signal)
+}
+
+// MessageDelivered signals on the message with uuid that the MMS center
+// reported its delivery status for recipient. The message interface is
+// usually gone by then, the signal is sent regardless.
+func (service *MMSService) MessageDelivered(uuid, recipient, status string) error {
+	msgObjectPath := service.GenMessagePath(uuid)
+	signal := dbus.NewSignalMessage(msgObjectPath, MMS_MESSAGE_DBUS_IFACE, deliveryReportSignal)
+	if err := signal.AppendArgs(recipient, status); err != nil {
+		return err
+	}
+	if err := service.conn.Send(signal); err != nil {
+		return err
+	}
+	logger.Infow("Delivery report", "message", msgObjectPath, "recipient", recipient, "status", status)
+	return nil
+}
+
+// MessageReadByRecipient changes the status of the message with uuid to
+// READ_BY_RECIPIENT. As with MessageDelivered, the change is signaled even
+// if the message interface is gone.
+func (service *MMSService) MessageReadByRecipient(uuid string) error {
+	msgObjectPath := service.GenMessagePath(uuid)
+	if msgInterface, ok := service.messageHandlers.get(msgObjectPath); ok {
+		return msgInterface.StatusChanged(READ_BY_RECIPIENT)
+	}
+	return signalStatusChanged(service.conn, msgObjectPath, READ_BY_RECIPIENT)
+}
+
+func (service *MMSService) ReplySendMessage(reply *dbus.Message, uuid string, deliveryReport bool) (dbus.ObjectPath, error) {
 	msgObjectPath := service.GenMessagePath(uuid)
 	reply.AppendArgs(msgObjectPath)
 	if err := service.conn.Send(reply); err != nil {
 		return "", err
 	}
-	msg := NewMessageInterface(service.conn, msgObjectPath, service.msgDeleteChan, nil)
-	service.messageHandlers[msgObjectPath] = msg
-	service.MessageAdded(msg.GetPayload())
+	service.addOutgoingMessage(msgObjectPath, deliveryReport)
 	return msgObjectPath, nil
 }
 
+// RestoreOutgoingMessage adds the message interface of the outgoing message
+// with uuid, which is still being sent after a restart.
+func (service *MMSService) RestoreOutgoingMessage(uuid string) dbus.ObjectPath {
+	msgObjectPath := service.GenMessagePath(uuid)
+	if _, ok := service.messageHandlers.get(msgObjectPath); !ok {
+		mmsState, _ := service.storage.GetMMSState(uuid)
+		service.addOutgoingMessage(msgObjectPath, mmsState.DeliveryReportRequested)
+	}
+	return msgObjectPath
+}
+
+func (service *MMSService) addOutgoingMessage(msgObjectPath dbus.ObjectPath, deliveryReport bool) {
+	msg := service.newMessageInterface(msgObjectPath, service.msgDeleteChan, nil, nil, service.msgCancelChan, service.msgResendChan)
+	msg.properties[deliveryReportRequestedProperty] = dbus.MakeVariant(deliveryReport)
+	service.messageHandlers.add(msgObjectPath, msg)
+	service.MessageAdded(msg.GetPayload())
+}
+
 //TODO randomly creating a uuid until the download manager does this for us
 func (service *MMSService) GenMessagePath(uuid string) dbus.ObjectPath {
 	if service == nil {
@@ -589,6 +1408,18 @@ func (service *MMSService) GenMessagePath(uuid string) dbus.ObjectPath {
 	return dbus.ObjectPath(MMS_DBUS_PATH + "/" + service.identity + "/" + uuid)
 }
 
+// messageNodes returns the names of the message objects of the service
+// relative to its path, for introspection.
+func (service *MMSService) messageNodes() []string {
+	var nodes []string
+	for path := range service.messageHandlers.list() {
+		if name := strings.TrimPrefix(string(path), string(service.payload.Path)+"/"); name != string(path) {
+			nodes = append(nodes, name)
+		}
+	}
+	return nodes
+}
+
 // Returns if mobile data is enabled right now.
 // Under the hood, DBus service property is read, if something fails, error is returned.
 //
@@ -614,7 +1445,7 @@ func (service *MMSService) MobileDataEnabled() (bool, error) {
 		return false, fmt.Errorf("reply decoding error: %w", err)
 	}
 
-	enabled, ok := msg.Value.(bool)
+	enabled, ok := msg.Value().(bool)
 	if !ok {
 		return false, fmt.Errorf("decoded variant does not contain bool vale: %#v", msg)
 	}
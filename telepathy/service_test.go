@@ -0,0 +1,869 @@
+package telepathy
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/internal/ofonosim"
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/storage"
+)
+
+// The contract tests call an MMSService served on a private session bus as
+// clients do, so changes to the signatures of its methods and signals or to
+// the properties it emits break them. They are skipped where dbus-daemon is
+// not available.
+
+const contractIdentity = "001010123456789"
+
+// contract is an MMSService on a private bus with a client connection.
+type contract struct {
+	bus     *ofonosim.Bus
+	conn    *dbus.Connection
+	client  *dbus.Connection
+	dir     string
+	store   storage.Storage
+	service *MMSService
+	signals chan *dbus.Message
+	// settings are stored in the XDG config directory replaced by dir,
+	// configHome is the one to restore.
+	settings   *config.Store
+	configHome string
+
+	outgoing      chan *OutgoingMessage
+	notifications chan *mms.MNotificationInd
+	rejects       chan *mms.MNotificationInd
+}
+
+func newContract(t *testing.T) *contract {
+	bus, err := ofonosim.StartBus()
+	if err != nil {
+		t.Skip(err)
+	}
+	c := &contract{
+		bus:           bus,
+		signals:       make(chan *dbus.Message, 16),
+		outgoing:      make(chan *OutgoingMessage, 1),
+		notifications: make(chan *mms.MNotificationInd, 1),
+		rejects:       make(chan *mms.MNotificationInd, 1),
+	}
+	if c.conn, err = dbus.Connect(dbus.SessionBus); err != nil {
+		c.close()
+		t.Skip(err)
+	}
+	if c.client, err = dbus.Connect(dbus.SessionBus); err != nil {
+		c.close()
+		t.Skip(err)
+	}
+	if c.dir, err = ioutil.TempDir("", "nuntium"); err != nil {
+		c.close()
+		t.Fatal(err)
+	}
+	for _, iface := range []string{MMS_SERVICE_DBUS_IFACE, MMS_MESSAGE_DBUS_IFACE} {
+		w, err := c.client.WatchSignal(&dbus.MatchRule{Type: dbus.TypeSignal, Sender: c.conn.UniqueName, Interface: iface})
+		if err != nil {
+			c.close()
+			t.Fatal(err)
+		}
+		go func() {
+			for msg := range w.C {
+				c.signals <- msg
+			}
+		}()
+	}
+	c.configHome = os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", c.dir)
+	c.store = storage.NewMemory(c.dir)
+	c.settings = config.NewStore(config.Defaults)
+	c.newService(false)
+	return c
+}
+
+// newService serves a new service on the storage of the contract.
+func (c *contract) newService(mmsdCompatible bool) {
+	c.service = NewMMSService(c.conn, c.store, c.settings, "/ril_0", contractIdentity, c.outgoing, false, mmsdCompatible, c.notifications, c.rejects,
+		make(chan string, 1), make(chan string, 1), make(chan string, 1), nil, nil, nil, nil)
+}
+
+// restart replaces the service by a new one on the same storage, as
+// nuntium starting again does.
+func (c *contract) restart(mmsdCompatible bool) {
+	c.service.Close()
+	c.newService(mmsdCompatible)
+}
+
+func (c *contract) close() {
+	if c.service != nil {
+		c.service.Close()
+	}
+	for _, conn := range []*dbus.Connection{c.client, c.conn} {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	if c.dir != "" {
+		os.Setenv("XDG_CONFIG_HOME", c.configHome)
+		os.RemoveAll(c.dir)
+	}
+	c.bus.Close()
+}
+
+// call calls method of iface on path of the service and returns the reply,
+// which may be an error.
+func (c *contract) call(t *testing.T, path dbus.ObjectPath, iface, method string, args ...interface{}) *dbus.Message {
+	t.Helper()
+	// Error replies are returned for the caller to check.
+	reply, err := c.client.Object(c.conn.UniqueName, path).Call(iface, method, args...)
+	if reply == nil {
+		t.Fatalf("%s.%s: %v", iface, method, err)
+	}
+	return reply
+}
+
+// signal waits for the next signal and checks it is member.
+func (c *contract) signal(t *testing.T, member string) *dbus.Message {
+	t.Helper()
+	select {
+	case msg := <-c.signals:
+		if msg.Member != member {
+			t.Fatalf("got signal %s, want %s", msg.Member, member)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s signal", member)
+	}
+	return nil
+}
+
+// messageAdded waits for a MessageAdded signal, checks its arguments are
+// an object path and properties and returns them.
+func (c *contract) messageAdded(t *testing.T) (dbus.ObjectPath, map[string]dbus.Variant) {
+	t.Helper()
+	var path dbus.ObjectPath
+	var properties map[string]dbus.Variant
+	if err := c.signal(t, messageAddedSignal).Args(&path, &properties); err != nil {
+		t.Fatalf("MessageAdded arguments are not oa{sv}: %v", err)
+	}
+	return path, properties
+}
+
+// messageRemoved waits for a MessageRemoved signal and returns its path.
+func (c *contract) messageRemoved(t *testing.T) dbus.ObjectPath {
+	t.Helper()
+	var path dbus.ObjectPath
+	if err := c.signal(t, messageRemovedSignal).Args(&path); err != nil {
+		t.Fatalf("MessageRemoved arguments are not o: %v", err)
+	}
+	return path
+}
+
+// checkSchema checks that properties has all the properties of schema with
+// values of their kinds.
+func checkSchema(t *testing.T, properties map[string]dbus.Variant, schema map[string]reflect.Kind) {
+	t.Helper()
+	for name, kind := range schema {
+		value, ok := properties[name]
+		if !ok {
+			t.Errorf("property %s is missing", name)
+			continue
+		}
+		if got := reflect.ValueOf(value.Value()).Kind(); got != kind {
+			t.Errorf("property %s is a %s, want a %s", name, got, kind)
+		}
+	}
+}
+
+// propertyChanged waits for a PropertyChanged signal of the message at path
+// and returns the property name and value.
+func (c *contract) propertyChanged(t *testing.T, path dbus.ObjectPath) (string, dbus.Variant) {
+	t.Helper()
+	var name string
+	var value dbus.Variant
+	msg := c.signal(t, propertyChangedSignal)
+	if msg.Path != path || msg.Interface != MMS_MESSAGE_DBUS_IFACE {
+		t.Fatalf("PropertyChanged of %s %s, want %s %s", msg.Interface, msg.Path, MMS_MESSAGE_DBUS_IFACE, path)
+	}
+	if err := msg.Args(&name, &value); err != nil {
+		t.Fatalf("PropertyChanged arguments are not sv: %v", err)
+	}
+	return name, value
+}
+
+// redownloadableError is a download error which allows redownloading.
+type redownloadableError struct{}
+
+func (redownloadableError) Error() string         { return "download failed" }
+func (redownloadableError) Code() string          { return ErrorUnknown }
+func (redownloadableError) AllowRedownload() bool { return true }
+
+func TestServiceGetProperties(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	reply := c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "GetProperties")
+	var properties map[string]dbus.Variant
+	if err := reply.Args(&properties); err != nil {
+		t.Fatalf("GetProperties reply is not a{sv}: %v", err)
+	}
+	checkSchema(t, properties, map[string]reflect.Kind{
+		interfaceVersionProperty:   reflect.Uint32,
+		useDeliveryReportsProperty: reflect.Bool,
+		modemObjectPathProperty:    reflect.String,
+		preferredContextProperty:   reflect.String,
+		localeProperty:             reflect.String,
+		autoDownloadLimitProperty:  reflect.Uint64,
+		dataSaverProperty:          reflect.Bool,
+	})
+	if version, _ := properties[interfaceVersionProperty].Value().(uint32); version != InterfaceVersion {
+		t.Errorf("InterfaceVersion is %d, want %d", version, InterfaceVersion)
+	}
+
+	reply = c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "SetProperty", autoDownloadLimitProperty, dbus.MakeVariant("big"))
+	if reply.Type != dbus.TypeError {
+		t.Error("SetProperty accepted a string AutoDownloadLimit")
+	}
+	reply = c.call(t, c.service.payload.Path, "org.ofono.mms.Unknown", "GetProperties")
+	if reply.Type != dbus.TypeError || reply.ErrorName != "org.freedesktop.DBus.Error.UnknownInterface" {
+		t.Errorf("call on an unknown interface replied %v %s", reply.Type, reply.ErrorName)
+	}
+}
+
+// TestServiceDataSaverConcurrent toggles the data saver and delivery reports
+// as the mediator does while a client gets and sets the properties, meant to
+// be run with the race detector.
+func TestServiceDataSaverConcurrent(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+	path := c.service.payload.Path
+
+	const toggles = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < toggles; i++ {
+			if err := c.service.SetDataSaver(i%2 == 0); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := c.service.setUseDeliveryReports(i%2 == 0); err != nil {
+				t.Error(err)
+				return
+			}
+			c.service.AutoDownloadLimit()
+		}
+	}()
+	for i := 0; i < toggles; i++ {
+		if reply := c.call(t, path, MMS_SERVICE_DBUS_IFACE, "GetProperties"); reply.Type == dbus.TypeError {
+			t.Fatalf("GetProperties failed: %s", reply.ErrorName)
+		}
+		if reply := c.call(t, path, MMS_SERVICE_DBUS_IFACE, "SetProperty", autoDownloadLimitProperty, dbus.MakeVariant(uint64(i+1))); reply.Type == dbus.TypeError {
+			t.Fatalf("SetProperty failed: %s", reply.ErrorName)
+		}
+	}
+	<-done
+
+	if c.service.DataSaver() {
+		t.Error("DataSaver is on after it was turned off last")
+	}
+	if limit := c.service.AutoDownloadLimit(); limit != toggles {
+		t.Errorf("AutoDownloadLimit is %d, want %d", limit, toggles)
+	}
+}
+
+// TestServiceAutoDownloadLimitStored sets the automatic download limit and
+// checks it is kept in the settings for the service of the next start.
+func TestServiceAutoDownloadLimitStored(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	if reply := c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "SetProperty", autoDownloadLimitProperty, dbus.MakeVariant(uint64(300000))); reply.Type == dbus.TypeError {
+		t.Fatalf("SetProperty failed: %s", reply.ErrorName)
+	}
+	if limit := c.settings.Get().AutoDownloadLimit; limit != 300000 {
+		t.Errorf("AutoDownloadLimit setting is %d, want 300000", limit)
+	}
+	c.restart(false)
+	properties := c.getAll(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE)
+	if limit, _ := properties[autoDownloadLimitProperty].Value().(uint64); limit != 300000 {
+		t.Errorf("AutoDownloadLimit is %d after a restart, want 300000", limit)
+	}
+}
+
+func TestServiceSendMessage(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+	path := c.service.payload.Path
+	attachments := []OutAttachment{{"text", "text/plain", "/tmp/text"}}
+
+	invalid := []struct {
+		name string
+		args []interface{}
+	}{
+		{"no arguments", nil},
+		{"recipient not an array", []interface{}{"+34600000000", attachments}},
+		{"invalid option", []interface{}{[]string{"+34600000000"}, map[string]dbus.Variant{"Priority": dbus.MakeVariant("urgent")}, attachments}},
+		{"option of the wrong type", []interface{}{[]string{"+34600000000"}, map[string]dbus.Variant{"Group": dbus.MakeVariant("yes")}, attachments}},
+	}
+	for _, tc := range invalid {
+		if reply := c.call(t, path, MMS_SERVICE_DBUS_IFACE, "SendMessage", tc.args...); reply.Type != dbus.TypeError {
+			t.Errorf("SendMessage with %s did not fail", tc.name)
+		}
+	}
+
+	valid := []struct {
+		name    string
+		args    []interface{}
+		subject string
+	}{
+		{"options", []interface{}{[]string{"+34600000000"}, map[string]dbus.Variant{"Subject": dbus.MakeVariant("Hi")}, attachments}, "Hi"},
+		{"no options", []interface{}{[]string{"+34600000000"}, attachments}, ""},
+	}
+	for _, tc := range valid {
+		replies := make(chan *dbus.Message, 1)
+		go func(args []interface{}) {
+			reply, _ := c.client.Object(c.conn.UniqueName, path).Call(MMS_SERVICE_DBUS_IFACE, "SendMessage", args...)
+			replies <- reply
+		}(tc.args)
+		var out *OutgoingMessage
+		select {
+		case out = <-c.outgoing:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("SendMessage with %s was not passed on", tc.name)
+		}
+		if !reflect.DeepEqual(out.Recipients, []string{"+34600000000"}) || !reflect.DeepEqual(out.Attachments, attachments) || out.Subject != tc.subject {
+			t.Errorf("SendMessage with %s passed on %+v", tc.name, out)
+		}
+		uuid := mms.GenUUID()
+		if _, err := c.service.ReplySendMessage(out.Reply, uuid, false); err != nil {
+			t.Fatal(err)
+		}
+		var msgPath dbus.ObjectPath
+		if reply := <-replies; reply == nil || reply.Args(&msgPath) != nil || msgPath != c.service.GenMessagePath(uuid) {
+			t.Errorf("SendMessage with %s replied %v, want %s", tc.name, reply, c.service.GenMessagePath(uuid))
+		}
+		added, properties := c.messageAdded(t)
+		if added != msgPath {
+			t.Errorf("MessageAdded for %s, want %s", added, msgPath)
+		}
+		checkSchema(t, properties, map[string]reflect.Kind{
+			statusProperty:                  reflect.String,
+			deliveryReportRequestedProperty: reflect.Bool,
+		})
+	}
+}
+
+func TestServiceDelete(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.store.UpdateResponded(mNotificationInd.UUID); err != nil {
+		t.Fatal(err)
+	}
+	mRetConf := mms.NewMRetrieveConf(mNotificationInd.UUID)
+	mRetConf.From = mNotificationInd.From
+	mRetConf.To = []string{"+34600000001" + PLMN}
+	if err := c.service.IncomingMessageAdded(mRetConf, mNotificationInd, nil); err != nil {
+		t.Fatal(err)
+	}
+	path, properties := c.messageAdded(t)
+	if path != c.service.GenMessagePath(mNotificationInd.UUID) {
+		t.Errorf("MessageAdded for %s, want %s", path, c.service.GenMessagePath(mNotificationInd.UUID))
+	}
+	checkSchema(t, properties, map[string]reflect.Kind{
+		"Status":      reflect.String,
+		"Date":        reflect.String,
+		"Timestamp":   reflect.Int64,
+		"Sender":      reflect.String,
+		"Recipients":  reflect.Slice,
+		"Attachments": reflect.Slice,
+		"Received":    reflect.Int64,
+		"Read":        reflect.Bool,
+	})
+	if sender, _ := properties["Sender"].Value().(string); sender != "+34600000000" {
+		t.Errorf("Sender is %q", sender)
+	}
+
+	if reply := c.call(t, path, MMS_MESSAGE_DBUS_IFACE, "Redownload"); reply.Type == dbus.TypeError {
+		t.Errorf("Redownload failed: %s", reply.ErrorName)
+	}
+	if reply := c.call(t, path, MMS_MESSAGE_DBUS_IFACE, "Delete"); reply.Type == dbus.TypeError {
+		t.Fatalf("Delete failed: %s", reply.ErrorName)
+	}
+	// Redownload is not allowed for a received message, so the next
+	// signal is the removal.
+	if removed := c.messageRemoved(t); removed != path {
+		t.Errorf("MessageRemoved for %s, want %s", removed, path)
+	}
+	if _, err := c.store.GetMMSState(mNotificationInd.UUID); err == nil {
+		t.Error("the deleted message is still stored")
+	}
+}
+
+func TestServiceDeleteNotification(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.service.IncomingMessageFailAdded(mNotificationInd, redownloadableError{}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := c.messageAdded(t)
+
+	// A message which was not downloaded is rejected before it is removed.
+	if reply := c.call(t, path, MMS_MESSAGE_DBUS_IFACE, "Delete"); reply.Type == dbus.TypeError {
+		t.Fatalf("Delete failed: %s", reply.ErrorName)
+	}
+	select {
+	case rejected := <-c.rejects:
+		if rejected.UUID != mNotificationInd.UUID {
+			t.Errorf("%s was rejected, want %s", rejected.UUID, mNotificationInd.UUID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the deleted notification was not rejected")
+	}
+}
+
+// TestServiceCloseWhileDeleting closes the service while a Delete call of a
+// message waits for the service to take it, meant to be run with the race
+// detector.
+func TestServiceCloseWhileDeleting(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	var paths []dbus.ObjectPath
+	for i := 0; i < 2; i++ {
+		mNotificationInd := mms.NewMNotificationInd(time.Now())
+		mNotificationInd.From = "+34600000000" + PLMN
+		if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.service.IncomingMessageFailAdded(mNotificationInd, redownloadableError{}); err != nil {
+			t.Fatal(err)
+		}
+		path, _ := c.messageAdded(t)
+		paths = append(paths, path)
+	}
+
+	// The mediator is busy: the first deletion waits for the rejection to
+	// be taken and the second one for the first.
+	c.rejects <- nil
+	for _, path := range paths {
+		if reply := c.call(t, path, MMS_MESSAGE_DBUS_IFACE, "Delete"); reply.Type == dbus.TypeError {
+			t.Fatalf("Delete failed: %s", reply.ErrorName)
+		}
+	}
+	// Delete replies before passing the call on.
+	time.Sleep(100 * time.Millisecond)
+	service := c.service
+	c.service = nil
+	service.Close()
+	<-c.rejects
+	select {
+	case rejected := <-c.rejects:
+		if rejected == nil {
+			t.Error("the first deleted notification was not rejected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the first deleted notification was not rejected")
+	}
+}
+
+func TestServiceRedownload(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	mNotificationInd.Size = 1024
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	uuid := mNotificationInd.UUID
+	if err := c.service.IncomingMessageFailAdded(mNotificationInd, redownloadableError{}); err != nil {
+		t.Fatal(err)
+	}
+	path, properties := c.messageAdded(t)
+	checkSchema(t, properties, map[string]reflect.Kind{
+		"Status":          reflect.String,
+		"Date":            reflect.String,
+		"Timestamp":       reflect.Int64,
+		"Sender":          reflect.String,
+		"Error":           reflect.String,
+		"AllowRedownload": reflect.Bool,
+	})
+	if allow, _ := properties["AllowRedownload"].Value().(bool); !allow {
+		t.Error("AllowRedownload is false")
+	}
+
+	if reply := c.call(t, path, MMS_MESSAGE_DBUS_IFACE, "Redownload"); reply.Type == dbus.TypeError {
+		t.Fatalf("Redownload failed: %s", reply.ErrorName)
+	}
+	if removed := c.messageRemoved(t); removed != path {
+		t.Errorf("MessageRemoved for %s, want %s", removed, path)
+	}
+	select {
+	case redownload := <-c.notifications:
+		if redownload.RedownloadOfUUID != uuid || redownload.UUID == uuid {
+			t.Errorf("redownload of %s has UUID %s and RedownloadOfUUID %s", uuid, redownload.UUID, redownload.RedownloadOfUUID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the redownload was not started")
+	}
+}
+
+// finalError is a download error which does not allow redownloading.
+type finalError struct{}
+
+func (finalError) Error() string { return "message not found" }
+func (finalError) Code() string  { return ErrorUnknown }
+
+func TestServiceMessagePropertyChanged(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.service.IncomingMessageFailAdded(mNotificationInd, redownloadableError{}); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := c.messageAdded(t)
+
+	if err := c.service.MessageErrorChanged(mNotificationInd, finalError{}); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(map[string]dbus.Variant)
+	for i := 0; i < 4; i++ {
+		name, value := c.propertyChanged(t, path)
+		changed[name] = value
+	}
+	checkSchema(t, changed, map[string]reflect.Kind{
+		"Error":             reflect.String,
+		"AllowRedownload":   reflect.Bool,
+		"Deferred":          reflect.Bool,
+		"WaitingForNetwork": reflect.Bool,
+	})
+	if allow, _ := changed["AllowRedownload"].Value().(bool); allow {
+		t.Error("AllowRedownload is still true")
+	}
+
+	received := time.Unix(1614967200, 0)
+	if err := c.service.MessageReceived(mNotificationInd.UUID, received); err != nil {
+		t.Fatal(err)
+	}
+	if name, value := c.propertyChanged(t, path); name != "Received" || value.Value() != received.Unix() {
+		t.Errorf("PropertyChanged(%s, %v), want Received %d", name, value.Value(), received.Unix())
+	}
+
+	if err := c.service.MessageRead(mNotificationInd.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if name, value := c.propertyChanged(t, path); name != "Read" || value.Value() != true {
+		t.Errorf("PropertyChanged(%s, %v), want Read true", name, value.Value())
+	}
+}
+
+// TestServiceConcurrentMessages adds, changes the status of, lists and
+// destroys outgoing messages from many goroutines, as the mediator and the
+// D-Bus method calls do, meant to be run with the race detector.
+// introspection is the part of the introspection XML the tests check.
+type introspection struct {
+	Interfaces []struct {
+		Name    string `xml:"name,attr"`
+		Methods []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"method"`
+		Properties []struct {
+			Name   string `xml:"name,attr"`
+			Type   string `xml:"type,attr"`
+			Access string `xml:"access,attr"`
+		} `xml:"property"`
+	} `xml:"interface"`
+	Nodes []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"node"`
+}
+
+// introspect introspects the object at path and returns the methods and
+// the property types and access of iface, and the child nodes.
+func (c *contract) introspect(t *testing.T, path dbus.ObjectPath, iface string) (methods map[string]bool, properties map[string]string, nodes []string) {
+	t.Helper()
+	var data string
+	if err := c.call(t, path, DBUS_INTROSPECTABLE_IFACE, "Introspect").Args(&data); err != nil {
+		t.Fatalf("Introspect reply is not s: %v", err)
+	}
+	var node introspection
+	if err := xml.Unmarshal([]byte(data), &node); err != nil {
+		t.Fatalf("Introspect returned invalid XML: %v\n%s", err, data)
+	}
+	methods = make(map[string]bool)
+	properties = make(map[string]string)
+	for _, i := range node.Interfaces {
+		if i.Name != iface {
+			continue
+		}
+		for _, method := range i.Methods {
+			methods[method.Name] = true
+		}
+		for _, property := range i.Properties {
+			properties[property.Name] = property.Type + " " + property.Access
+		}
+	}
+	for _, n := range node.Nodes {
+		nodes = append(nodes, n.Name)
+	}
+	return methods, properties, nodes
+}
+
+// getAll returns the properties of iface on the object at path with the
+// standard properties interface.
+func (c *contract) getAll(t *testing.T, path dbus.ObjectPath, iface string) map[string]dbus.Variant {
+	t.Helper()
+	var properties map[string]dbus.Variant
+	if err := c.call(t, path, DBUS_PROPERTIES_IFACE, "GetAll", iface).Args(&properties); err != nil {
+		t.Fatalf("GetAll reply is not a{sv}: %v", err)
+	}
+	return properties
+}
+
+func TestServiceStandardInterfaces(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+	path := c.service.payload.Path
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.service.IncomingMessageFailAdded(mNotificationInd, redownloadableError{}); err != nil {
+		t.Fatal(err)
+	}
+	msgPath, _ := c.messageAdded(t)
+
+	methods, properties, nodes := c.introspect(t, path, MMS_SERVICE_DBUS_IFACE)
+	for _, method := range []string{"GetMessages", "GetProperties", "SetProperty", "SendMessage"} {
+		if !methods[method] {
+			t.Errorf("method %s is not introspected", method)
+		}
+	}
+	if properties[interfaceVersionProperty] != "u read" || properties[localeProperty] != "s readwrite" {
+		t.Errorf("introspected properties %v", properties)
+	}
+	if !reflect.DeepEqual(nodes, []string{mNotificationInd.UUID}) {
+		t.Errorf("introspected nodes %v, want the message %s", nodes, mNotificationInd.UUID)
+	}
+
+	var getProperties map[string]dbus.Variant
+	if err := c.call(t, path, MMS_SERVICE_DBUS_IFACE, "GetProperties").Args(&getProperties); err != nil {
+		t.Fatal(err)
+	}
+	if all := c.getAll(t, path, MMS_SERVICE_DBUS_IFACE); !reflect.DeepEqual(all, getProperties) {
+		t.Errorf("GetAll returned %v, GetProperties %v", all, getProperties)
+	}
+	var version dbus.Variant
+	if err := c.call(t, path, DBUS_PROPERTIES_IFACE, "Get", MMS_SERVICE_DBUS_IFACE, interfaceVersionProperty).Args(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Value() != InterfaceVersion {
+		t.Errorf("Get InterfaceVersion returned %v", version.Value())
+	}
+
+	w, err := c.client.WatchSignal(&dbus.MatchRule{Type: dbus.TypeSignal, Sender: c.conn.UniqueName, Interface: DBUS_PROPERTIES_IFACE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	if reply := c.call(t, path, DBUS_PROPERTIES_IFACE, "Set", MMS_SERVICE_DBUS_IFACE, localeProperty, dbus.MakeVariant("fr_FR")); reply.Type == dbus.TypeError {
+		t.Fatalf("Set Locale failed: %v", reply.AsError())
+	}
+	select {
+	case msg := <-w.C:
+		var iface string
+		var changed map[string]dbus.Variant
+		var invalidated []string
+		if err := msg.Args(&iface, &changed, &invalidated); err != nil {
+			t.Fatalf("PropertiesChanged arguments are not sa{sv}as: %v", err)
+		}
+		if msg.Path != path || iface != MMS_SERVICE_DBUS_IFACE || changed[localeProperty].Value() != "fr_FR" {
+			t.Errorf("PropertiesChanged(%s, %v) on %s", iface, changed, msg.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no PropertiesChanged signal")
+	}
+	var locale dbus.Variant
+	if err := c.call(t, path, DBUS_PROPERTIES_IFACE, "Get", MMS_SERVICE_DBUS_IFACE, localeProperty).Args(&locale); err != nil {
+		t.Fatal(err)
+	}
+	if locale.Value() != "fr_FR" {
+		t.Errorf("Locale is %v after Set", locale.Value())
+	}
+
+	errors := []struct {
+		name      string
+		method    string
+		args      []interface{}
+		errorName string
+	}{
+		{"read-only property", "Set", []interface{}{MMS_SERVICE_DBUS_IFACE, interfaceVersionProperty, dbus.MakeVariant(uint32(1))}, "org.freedesktop.DBus.Error.PropertyReadOnly"},
+		{"unknown property", "Get", []interface{}{MMS_SERVICE_DBUS_IFACE, "Unknown"}, "org.freedesktop.DBus.Error.UnknownProperty"},
+		{"unknown interface", "GetAll", []interface{}{"org.ofono.mms.Unknown"}, "org.freedesktop.DBus.Error.UnknownInterface"},
+		{"invalid value", "Set", []interface{}{MMS_SERVICE_DBUS_IFACE, autoDownloadLimitProperty, dbus.MakeVariant("big")}, "org.freedesktop.DBus.Error.InvalidArgs"},
+	}
+	for _, tc := range errors {
+		if reply := c.call(t, path, DBUS_PROPERTIES_IFACE, tc.method, tc.args...); reply.ErrorName != tc.errorName {
+			t.Errorf("%s %s replied %q, want %s", tc.method, tc.name, reply.ErrorName, tc.errorName)
+		}
+	}
+
+	methods, properties, _ = c.introspect(t, msgPath, MMS_MESSAGE_DBUS_IFACE)
+	if !methods["Delete"] || !methods["Redownload"] || properties[statusProperty] != "s read" {
+		t.Errorf("introspected message methods %v, properties %v", methods, properties)
+	}
+	message := c.getAll(t, msgPath, MMS_MESSAGE_DBUS_IFACE)
+	checkSchema(t, message, map[string]reflect.Kind{
+		statusProperty:    reflect.String,
+		"Sender":          reflect.String,
+		"Error":           reflect.String,
+		"AllowRedownload": reflect.Bool,
+	})
+	if reply := c.call(t, msgPath, DBUS_PROPERTIES_IFACE, "Set", MMS_MESSAGE_DBUS_IFACE, statusProperty, dbus.MakeVariant(SENT)); reply.ErrorName != "org.freedesktop.DBus.Error.PropertyReadOnly" {
+		t.Errorf("Set Status of a message replied %q", reply.ErrorName)
+	}
+}
+
+func TestServiceConcurrentMessages(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-c.signals:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		uuid := mms.GenUUID()
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			c.service.RestoreOutgoingMessage(uuid)
+		}()
+		go func() {
+			defer wg.Done()
+			c.service.MessageStatusChanged(uuid, SENT)
+		}()
+		go func() {
+			defer wg.Done()
+			c.service.storedMessages()
+		}()
+		go func() {
+			defer wg.Done()
+			c.service.MessageDestroy(uuid)
+		}()
+	}
+	wg.Wait()
+	c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "GetMessages")
+}
+
+func TestServiceMmsdCompatibility(t *testing.T) {
+	c := newContract(t)
+	defer c.close()
+
+	mNotificationInd := mms.NewMNotificationInd(time.Now())
+	mNotificationInd.From = "+34600000000" + PLMN
+	if _, err := c.store.Create(contractIdentity, mNotificationInd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.store.UpdateResponded(mNotificationInd.UUID); err != nil {
+		t.Fatal(err)
+	}
+	received := c.service.GenMessagePath(mNotificationInd.UUID)
+	sentUUID := mms.GenUUID()
+	f, err := c.store.CreateSendFile(contractIdentity, sentUUID, []string{"+34600000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := c.store.UpdateSent(sentUUID, "message-id"); err != nil {
+		t.Fatal(err)
+	}
+	sent := c.service.GenMessagePath(sentUUID)
+	smil := `<smil><body><par><text src="cid:text"/></par></body></smil>`
+	args := []interface{}{[]string{"+34600000000"}, smil, []OutAttachment{{"text", "text/plain", "/tmp/text"}}}
+
+	// By default stored messages are objects once they are added and the
+	// SendMessage of mmsd is refused.
+	if reply := c.call(t, received, MMS_MESSAGE_DBUS_IFACE, "Delete"); reply.Type != dbus.TypeError {
+		t.Error("Delete of a stored message succeeded without compatibility")
+	}
+	if reply := c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "SendMessage", args...); reply.Type != dbus.TypeError {
+		t.Error("SendMessage with a SMIL string succeeded without compatibility")
+	}
+
+	c.restart(true)
+	var messages []Payload
+	if err := c.call(t, c.service.payload.Path, MMS_SERVICE_DBUS_IFACE, "GetMessages").Args(&messages); err != nil {
+		t.Fatalf("GetMessages reply is not a(oa{sv}): %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("GetMessages returned %d messages, want 2", len(messages))
+	}
+	if status := c.getAll(t, sent, MMS_MESSAGE_DBUS_IFACE)[statusProperty].Value(); status != SENT {
+		t.Errorf("Status of the sent message is %v, want %s", status, SENT)
+	}
+	if reply := c.call(t, received, MMS_MESSAGE_DBUS_IFACE, "Delete"); reply.Type == dbus.TypeError {
+		t.Fatalf("Delete failed: %s", reply.ErrorName)
+	}
+	if removed := c.messageRemoved(t); removed != received {
+		t.Errorf("MessageRemoved for %s, want %s", removed, received)
+	}
+	if _, err := c.store.GetMMSState(mNotificationInd.UUID); err == nil {
+		t.Error("the deleted message is still stored")
+	}
+
+	replies := make(chan *dbus.Message, 1)
+	go func() {
+		reply, _ := c.client.Object(c.conn.UniqueName, c.service.payload.Path).Call(MMS_SERVICE_DBUS_IFACE, "SendMessage", args...)
+		replies <- reply
+	}()
+	select {
+	case out := <-c.outgoing:
+		if out.Smil != smil || len(out.Attachments) != 1 || !out.Group {
+			t.Errorf("SendMessage with a SMIL string passed on %+v", out)
+		}
+		if _, err := c.service.ReplySendMessage(out.Reply, mms.GenUUID(), false); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendMessage with a SMIL string was not passed on")
+	}
+	if reply := <-replies; reply == nil || reply.Type == dbus.TypeError {
+		t.Errorf("SendMessage with a SMIL string replied %v", reply)
+	}
+	c.messageAdded(t)
+}
@@ -0,0 +1,55 @@
+package telepathy
+
+import (
+	"github.com/ubports/nuntium/config"
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// getSettings replies with the nuntium options as properties.
+func (manager *MMSManager) getSettings(msg *dbus.Message) *dbus.Message {
+	settings := manager.settings.Get()
+	properties := make(map[string]dbus.Variant)
+	for _, name := range config.Names() {
+		value, _ := settings.Get(name)
+		properties[name] = dbus.MakeVariant(value)
+	}
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(properties); err != nil {
+		logger.Error("Cannot append settings: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
+
+// setSetting changes a nuntium option and announces it with PropertyChanged.
+func (manager *MMSManager) setSetting(msg *dbus.Message) *dbus.Message {
+	var name string
+	var value dbus.Variant
+	if err := msg.Args(&name, &value); err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if manager.settings == nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", "settings cannot be changed")
+	}
+	newValue, err := manager.settings.Set(name, value.Value())
+	if err != nil {
+		logger.Errorf("Cannot set %s: %v", name, err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	logger.Infof("Setting %s changed to %v", name, newValue)
+
+	signal := dbus.NewSignalMessage(MMS_DBUS_PATH, MMS_SETTINGS_DBUS_IFACE, propertyChangedSignal)
+	if err := signal.AppendArgs(name, dbus.MakeVariant(newValue)); err != nil {
+		logger.Error("Cannot append changed setting: ", err)
+	} else if err := manager.conn.Send(signal); err != nil {
+		logger.Error("Cannot send PropertyChanged for settings: ", err)
+	}
+	if enabled, ok := newValue.(bool); ok && name == "UseDeliveryReports" {
+		for _, service := range manager.services {
+			if err := service.setUseDeliveryReports(enabled); err != nil {
+				logger.Error("Cannot update UseDeliveryReports: ", err)
+			}
+		}
+	}
+	return dbus.NewMethodReturnMessage(msg)
+}
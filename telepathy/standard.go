@@ -0,0 +1,268 @@
+package telepathy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ubports/nuntium/internal/dbus"
+)
+
+// standardInterfaces answers the calls of the Introspectable and Properties
+// interfaces for an object implementing iface, so generic D-Bus tools can
+// inspect services and messages.
+type standardInterfaces struct {
+	iface string
+	// members is the introspection XML of the methods and signals of iface.
+	members string
+	// properties returns the current properties of iface.
+	properties func() map[string]dbus.Variant
+	// set sets one of the writable properties.
+	set      func(name string, value dbus.Variant) error
+	writable map[string]bool
+	// children returns the names of the child objects, nil if there are
+	// none.
+	children func() []string
+}
+
+const introspectionHeader = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="xml" type="s" direction="out"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="name" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <method name="Set">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="name" type="s" direction="in"/>
+      <arg name="value" type="v" direction="in"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface" type="s"/>
+      <arg name="changed" type="a{sv}"/>
+      <arg name="invalidated" type="as"/>
+    </signal>
+  </interface>
+  <interface name="org.freedesktop.DBus.Peer">
+    <method name="Ping"/>
+    <method name="GetMachineId">
+      <arg name="id" type="s" direction="out"/>
+    </method>
+  </interface>
+`
+
+const serviceMembers = `    <method name="GetMessages">
+      <arg name="messages" type="a(oa{sv})" direction="out"/>
+    </method>
+    <method name="GetProperties">
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <method name="SetProperty">
+      <arg name="name" type="s" direction="in"/>
+      <arg name="value" type="v" direction="in"/>
+    </method>
+    <method name="SendMessage">
+      <arg name="recipients" type="as" direction="in"/>
+      <arg name="options" type="a{sv}" direction="in"/>
+      <arg name="attachments" type="a(sss)" direction="in"/>
+      <arg name="path" type="o" direction="out"/>
+    </method>
+    <method name="Attach"/>
+    <method name="Detach"/>
+    <method name="ConfigureMMSContext">
+      <arg name="apn" type="s" direction="in"/>
+      <arg name="mmsc" type="s" direction="in"/>
+      <arg name="proxy" type="s" direction="in"/>
+      <arg name="port" type="u" direction="in"/>
+    </method>
+    <method name="MMBoxStore">
+      <arg name="message" type="o" direction="in"/>
+      <arg name="state" type="s" direction="in"/>
+      <arg name="location" type="s" direction="out"/>
+    </method>
+    <method name="MMBoxView">
+      <arg name="options" type="a{sv}" direction="in"/>
+      <arg name="messages" type="aa{sv}" direction="out"/>
+      <arg name="total" type="u" direction="out"/>
+    </method>
+    <method name="MMBoxRetrieve">
+      <arg name="location" type="s" direction="in"/>
+      <arg name="path" type="o" direction="out"/>
+    </method>
+    <method name="Forward">
+      <arg name="message" type="o" direction="in"/>
+      <arg name="recipients" type="as" direction="in"/>
+      <arg name="messageId" type="s" direction="out"/>
+    </method>
+    <method name="CancelDelivery">
+      <arg name="message" type="o" direction="in"/>
+    </method>
+    <method name="ExportDiagnostics">
+      <arg name="uuid" type="s" direction="in"/>
+      <arg name="path" type="s" direction="out"/>
+    </method>
+    <method name="ExportMessagePDU">
+      <arg name="message" type="o" direction="in"/>
+      <arg name="paths" type="as" direction="out"/>
+    </method>
+    <signal name="MessageAdded">
+      <arg name="path" type="o"/>
+      <arg name="properties" type="a{sv}"/>
+    </signal>
+    <signal name="MessageRemoved">
+      <arg name="path" type="o"/>
+    </signal>
+    <signal name="PropertyChanged">
+      <arg name="name" type="s"/>
+      <arg name="value" type="v"/>
+    </signal>
+    <signal name="PushReceived">
+      <arg name="contentType" type="s"/>
+      <arg name="applicationId" type="y"/>
+      <arg name="data" type="ay"/>
+    </signal>
+`
+
+const messageMembers = `    <method name="Delete"/>
+    <method name="Redownload"/>
+    <method name="MarkRead"/>
+    <method name="Cancel"/>
+    <method name="Resend"/>
+    <signal name="PropertyChanged">
+      <arg name="name" type="s"/>
+      <arg name="value" type="v"/>
+    </signal>
+    <signal name="DeliveryReport">
+      <arg name="recipient" type="s"/>
+      <arg name="status" type="s"/>
+    </signal>
+`
+
+// reply returns the reply to msg if it is a call of the Introspectable or
+// Properties interfaces, nil otherwise.
+func (s *standardInterfaces) reply(msg *dbus.Message) *dbus.Message {
+	switch {
+	case msg.Interface == DBUS_INTROSPECTABLE_IFACE && msg.Member == "Introspect":
+		reply := dbus.NewMethodReturnMessage(msg)
+		if err := reply.AppendArgs(s.introspect()); err != nil {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+		}
+		return reply
+	case msg.Interface == DBUS_PROPERTIES_IFACE:
+		return s.propertiesReply(msg)
+	}
+	return nil
+}
+
+func (s *standardInterfaces) propertiesReply(msg *dbus.Message) *dbus.Message {
+	var iface, name string
+	var value dbus.Variant
+	var err error
+	switch msg.Member {
+	case "Get":
+		err = msg.Args(&iface, &name)
+	case "GetAll":
+		err = msg.Args(&iface)
+	case "Set":
+		err = msg.Args(&iface, &name, &value)
+	default:
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod",
+			fmt.Sprintf("No such method '%s' at object path '%s'", msg.Member, msg.Path))
+	}
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	if iface != s.iface {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownInterface",
+			fmt.Sprintf("No such interface '%s' at object path '%s'", iface, msg.Path))
+	}
+
+	reply := dbus.NewMethodReturnMessage(msg)
+	switch msg.Member {
+	case "Get":
+		current, ok := s.properties()[name]
+		if !ok {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownProperty",
+				fmt.Sprintf("No such property '%s'", name))
+		}
+		err = reply.AppendArgs(current)
+	case "GetAll":
+		err = reply.AppendArgs(s.properties())
+	case "Set":
+		if _, ok := s.properties()[name]; !ok {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownProperty",
+				fmt.Sprintf("No such property '%s'", name))
+		}
+		if !s.writable[name] {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.PropertyReadOnly",
+				fmt.Sprintf("Property '%s' is read-only", name))
+		}
+		err = s.set(name, value)
+	}
+	if err != nil {
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.Error())
+	}
+	return reply
+}
+
+// introspect returns the introspection XML of the object. The properties are
+// listed with the types of their current values, messages do not all have
+// the same properties.
+func (s *standardInterfaces) introspect() string {
+	var xml strings.Builder
+	xml.WriteString(introspectionHeader)
+	fmt.Fprintf(&xml, "  <interface name=\"%s\">\n", s.iface)
+	xml.WriteString(s.members)
+	properties := s.properties()
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		access := "read"
+		if s.writable[name] {
+			access = "readwrite"
+		}
+		fmt.Fprintf(&xml, "    <property name=\"%s\" type=\"%s\" access=\"%s\"/>\n", name, properties[name].Signature(), access)
+	}
+	xml.WriteString("  </interface>\n")
+	if s.children != nil {
+		children := s.children()
+		sort.Strings(children)
+		for _, child := range children {
+			fmt.Fprintf(&xml, "  <node name=\"%s\"/>\n", child)
+		}
+	}
+	xml.WriteString("</node>\n")
+	return xml.String()
+}
+
+// signalPropertyChanged signals that the property name of iface on the
+// object at path changed to value, with the PropertyChanged signal of iface
+// and the standard PropertiesChanged signal.
+func signalPropertyChanged(conn *dbus.Connection, path dbus.ObjectPath, iface, name string, value dbus.Variant) error {
+	signal := dbus.NewSignalMessage(path, iface, propertyChangedSignal)
+	if err := signal.AppendArgs(name, value); err != nil {
+		return err
+	}
+	if err := conn.Send(signal); err != nil {
+		return err
+	}
+	signal = dbus.NewSignalMessage(path, DBUS_PROPERTIES_IFACE, propertiesChangedSignal)
+	if err := signal.AppendArgs(iface, map[string]dbus.Variant{name: value}, []string{}); err != nil {
+		return err
+	}
+	return conn.Send(signal)
+}
@@ -0,0 +1,33 @@
+package telepathy
+
+import (
+	"github.com/ubports/nuntium/internal/dbus"
+	"github.com/ubports/nuntium/metrics"
+)
+
+// statisticsProperties returns the counters of statistics as properties,
+// with the Unix time counting started at as Since.
+func statisticsProperties(statistics metrics.Statistics) map[string]dbus.Variant {
+	properties := map[string]dbus.Variant{"Since": dbus.MakeVariant(statistics.Since.Unix())}
+	for name, count := range statistics.Counters {
+		properties[name] = dbus.MakeVariant(count)
+	}
+	return properties
+}
+
+// getStatistics replies with the counters of the handled messages.
+func (manager *MMSManager) getStatistics(msg *dbus.Message) *dbus.Message {
+	reply := dbus.NewMethodReturnMessage(msg)
+	if err := reply.AppendArgs(statisticsProperties(metrics.Get())); err != nil {
+		logger.Error("Cannot append statistics: ", err)
+		return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.Failed", err.Error())
+	}
+	return reply
+}
+
+// resetStatistics sets the counters to zero.
+func (manager *MMSManager) resetStatistics(msg *dbus.Message) *dbus.Message {
+	metrics.Reset()
+	logger.Info("Statistics were reset")
+	return dbus.NewMethodReturnMessage(msg)
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 
-	"launchpad.net/go-dbus/v1"
+	"github.com/ubports/nuntium/internal/dbus"
 )
 
 func main() {
@@ -84,8 +84,8 @@ func main() {
 		fmt.Println("Choose between argentina-personal and spain-vodaphone")
 	}
 
-	info := map[string]*dbus.Variant{"LocalSentTime": &dbus.Variant{"2014-02-05T08:29:55-0300"},
-		"Sender": &dbus.Variant{"+543515924906"}}
+	info := map[string]dbus.Variant{"LocalSentTime": dbus.MakeVariant("2014-02-05T08:29:55-0300"),
+		"Sender": dbus.MakeVariant("+543515924906")}
 
 	reply, err := obj.Call("org.ofono.PushNotificationAgent", "ReceiveNotification", data, info)
 	if err != nil || reply.Type == dbus.TypeError {
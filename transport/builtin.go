@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func init() {
+	Register(MM1, newMM1)
+	Register("exec", newExecTransport)
+}
+
+// mm1 is the MM1 transport, HTTP through the download manager.
+type mm1 struct{}
+
+func newMM1(options map[string]string) (Transport, error) {
+	return mm1{}, nil
+}
+
+func (mm1) Download(ctx context.Context, contentLocation string, proxy mms.Proxy) (string, error) {
+	return (&mms.MNotificationInd{ContentLocation: contentLocation}).DownloadContent(ctx, proxy)
+}
+
+func (mm1) Upload(ctx context.Context, file, messageCenter string, proxy mms.Proxy) (string, error) {
+	return mms.Upload(ctx, file, messageCenter, proxy)
+}
+
+// execTransport hands transactions to the "download" and "upload" command
+// options, e.g. scripts talking to an MM4/SMTP gateway or serving PDUs from a
+// directory in a lab. The content location, or the PDU file and the message
+// center, are passed as arguments and the proxy in the NUNTIUM_PROXY
+// environment variable, its credentials in NUNTIUM_PROXY_USERNAME and
+// NUNTIUM_PROXY_PASSWORD. The command prints the path of the resulting file
+// and exits with 0 on success.
+type execTransport struct {
+	download, upload []string
+}
+
+func newExecTransport(options map[string]string) (Transport, error) {
+	t := execTransport{strings.Fields(options["download"]), strings.Fields(options["upload"])}
+	if len(t.download) == 0 || len(t.upload) == 0 {
+		return nil, errors.New("no download or upload command configured")
+	}
+	return t, nil
+}
+
+func (t execTransport) Download(ctx context.Context, contentLocation string, proxy mms.Proxy) (string, error) {
+	return t.run(ctx, t.download, proxy, contentLocation)
+}
+
+func (t execTransport) Upload(ctx context.Context, file, messageCenter string, proxy mms.Proxy) (string, error) {
+	return t.run(ctx, t.upload, proxy, file, messageCenter)
+}
+
+func (t execTransport) run(ctx context.Context, command []string, proxy mms.Proxy, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], args...)...)
+	cmd.Env = os.Environ()
+	if proxy.Host != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("NUNTIUM_PROXY=%s:%d", proxy.Host, proxy.Port))
+	}
+	if proxy.Username != "" {
+		cmd.Env = append(cmd.Env, "NUNTIUM_PROXY_USERNAME="+proxy.Username, "NUNTIUM_PROXY_PASSWORD="+proxy.Password)
+	}
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	} else if err != nil {
+		return "", fmt.Errorf("%s failed: %w", command[0], err)
+	}
+	filePath := strings.TrimSpace(string(out))
+	if filePath == "" {
+		return "", fmt.Errorf("%s returned no file", command[0])
+	}
+	return filePath, nil
+}
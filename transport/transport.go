@@ -0,0 +1,79 @@
+// Package transport abstracts how PDUs are exchanged with the MMSC, so the
+// mediator does not depend on a particular one.
+//
+// The default transport, MM1, is HTTP through the download manager as
+// specified for handsets. Alternative transports, such as a lab MM4/SMTP
+// gateway or a mock MMSC, register a Factory under a name with Register and
+// are selected per operator in the carrier profile, see New.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+// MM1 is the name of the default transport.
+const MM1 = "mm1"
+
+// Transport exchanges encoded PDUs with the MMSC. Proxies are the ones of the
+// MMS context, their Host is empty when there is none.
+type Transport interface {
+	// Download retrieves the m-retrieve.conf at contentLocation and returns
+	// the path of the downloaded file. It is aborted with the error of ctx
+	// once ctx is done.
+	Download(ctx context.Context, contentLocation string, proxy mms.Proxy) (string, error)
+	// Upload posts the PDU in file to messageCenter and returns the path of
+	// the file holding the response, e.g. an m-send.conf. It is aborted with
+	// the error of ctx once ctx is done.
+	Upload(ctx context.Context, file, messageCenter string, proxy mms.Proxy) (string, error)
+}
+
+// Factory creates a transport from its configured options.
+type Factory func(options map[string]string) (Transport, error)
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]Factory)
+)
+
+// Register makes a transport available to carrier profiles under name.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of the registered transports.
+func Registered() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the transport registered under name with options. An empty
+// name selects MM1.
+func New(name string, options map[string]string) (Transport, error) {
+	if name == "" {
+		name = MM1
+	}
+	registryLock.Lock()
+	factory, ok := registry[name]
+	registryLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", name)
+	}
+	t, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create transport %q: %w", name, err)
+	}
+	return t, nil
+}
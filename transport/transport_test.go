@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ubports/nuntium/mms"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options map[string]string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{MM1, nil, false},
+		{"exec", map[string]string{"download": "cat"}, true},
+		{"unknown", nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.name, tc.options)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("New(%q) error = %v, want error %v", tc.name, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "gateway")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$NUNTIUM_PROXY $NUNTIUM_PROXY_USERNAME $*\" > "+dir+"/args\necho "+dir+"/args\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := New("exec", map[string]string{"download": script, "upload": script + " up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		run      func() (string, error)
+		wantArgs string
+	}{
+		{"download", func() (string, error) {
+			return tr.Download(context.Background(), "http://mmsc/1", mms.Proxy{Host: "10.0.0.1", Port: 80, Username: "user", Password: "secret"})
+		}, "10.0.0.1:80 user http://mmsc/1\n"},
+		{"upload", func() (string, error) { return tr.Upload(context.Background(), "/tmp/req", "http://mmsc", mms.Proxy{}) }, "  up /tmp/req http://mmsc\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath, err := tc.run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			args, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(args) != tc.wantArgs {
+				t.Errorf("command got %q, want %q", args, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestExecTransportCancel(t *testing.T) {
+	tr, err := New("exec", map[string]string{"download": "true", "upload": "sleep 10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tr.Upload(ctx, "/tmp/req", "http://mmsc", mms.Proxy{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Upload with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
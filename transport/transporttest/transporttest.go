@@ -0,0 +1,108 @@
+// Package transporttest provides a transport which records the transactions
+// of the code under test and answers them with canned responses, so the
+// mediator can be tested without a network or MMSC.
+package transporttest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/ubports/nuntium/mms"
+	"github.com/ubports/nuntium/transport"
+)
+
+// ErrNoResponse is the error of transactions for which no response is
+// queued.
+var ErrNoResponse = errors.New("no response queued")
+
+// Transaction is a download or upload made through a Recorder.
+type Transaction struct {
+	// Upload is false for downloads.
+	Upload bool
+	// Location is the content location of a download, the message center
+	// of an upload.
+	Location string
+	// PDU is the content of the uploaded file.
+	PDU   []byte
+	Proxy mms.Proxy
+}
+
+// Response is the answer to a transaction, the PDU or Err.
+type Response struct {
+	PDU []byte
+	Err error
+}
+
+// Recorder is a transport.Transport which records the transactions and
+// answers them with the responses queued with Respond, in order. The PDUs
+// of responses are written to files in Dir, the temporary directory if it
+// is empty. It is safe for concurrent use.
+type Recorder struct {
+	Dir          string
+	lock         sync.Mutex
+	transactions []Transaction
+	responses    []Response
+}
+
+var _ transport.Transport = (*Recorder)(nil)
+
+// Respond queues responses for the next transactions.
+func (r *Recorder) Respond(responses ...Response) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.responses = append(r.responses, responses...)
+}
+
+// Transactions returns the transactions made so far.
+func (r *Recorder) Transactions() []Transaction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Transaction(nil), r.transactions...)
+}
+
+// New returns r whatever the transport, to be injected instead of
+// transport.New.
+func (r *Recorder) New(name string, options map[string]string) (transport.Transport, error) {
+	return r, nil
+}
+
+func (r *Recorder) Download(ctx context.Context, contentLocation string, proxy mms.Proxy) (string, error) {
+	return r.record(ctx, Transaction{Location: contentLocation, Proxy: proxy})
+}
+
+func (r *Recorder) Upload(ctx context.Context, file, messageCenter string, proxy mms.Proxy) (string, error) {
+	pdu, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return r.record(ctx, Transaction{Upload: true, Location: messageCenter, PDU: pdu, Proxy: proxy})
+}
+
+// record adds t and returns the file of the next response.
+func (r *Recorder) record(ctx context.Context, t Transaction) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	r.lock.Lock()
+	r.transactions = append(r.transactions, t)
+	response := Response{Err: ErrNoResponse}
+	if len(r.responses) > 0 {
+		response, r.responses = r.responses[0], r.responses[1:]
+	}
+	r.lock.Unlock()
+
+	if response.Err != nil {
+		return "", response.Err
+	}
+	f, err := ioutil.TempFile(r.Dir, "response-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(response.PDU); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}